@@ -374,6 +374,90 @@ var SystemConfig = Config{
 		false,         // mutable
 		false,         // case-insensitive
 	},
+	"projector.dataport.compression": ConfigValue{
+		"none",
+		"compression to apply on payloads sent to indexer, can be " +
+			"none, snappy or gzip. Applied only if indexer accepts " +
+			"compression, does not affect existing feeds.",
+		"none",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dataport.compressionThreshold": ConfigValue{
+		1024,
+		"payloads smaller than this size, in bytes, are not compressed, " +
+			"does not affect existing feeds.",
+		1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.dataport.flowControl": ConfigValue{
+		true,
+		"honor flow control credits advertised by indexer, when credits " +
+			"for a bucket are exhausted feed stops consuming DCP events " +
+			"for that bucket. Does not affect existing feeds.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.budget.tick": ConfigValue{
+		1000, // 1 second
+		"tick, in milliseconds, to measure usage of feed budgets and " +
+			"re-distribute evaluation CPU across feeds.",
+		1000,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"projector.budget.maxMutationsPerSec": ConfigValue{
+		0,
+		"maximum number of mutations per second consumed by a feed " +
+			"for a bucket, 0 means no limit.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.budget.maxBytesPerSec": ConfigValue{
+		0,
+		"maximum number of document bytes per second consumed by a feed " +
+			"for a bucket, 0 means no limit.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.budget.evalCpuShare": ConfigValue{
+		false,
+		"share evaluation CPU, capped by projector.maxCpuPercent, across " +
+			"feeds for each bucket in proportion to their weights, and " +
+			"across vbucket workers of each feed.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.budget.maintWeight": ConfigValue{
+		4,
+		"weight of maintenance and catchup streams while sharing " +
+			"evaluation CPU.",
+		4,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.budget.initWeight": ConfigValue{
+		1,
+		"weight of initial build streams while sharing evaluation CPU.",
+		1,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"projector.budget.overrides": ConfigValue{
+		"",
+		"JSON object of per feed budgets, keyed by `<bucket>` or " +
+			"`<topic-prefix>:<bucket>`, each value can specify " +
+			"maxMutationsPerSec, maxBytesPerSec and weight. For example " +
+			`{"INIT_STREAM_TOPIC:travel-sample": {"maxMutationsPerSec": 10000}}`,
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
 	"projector.gogc": ConfigValue{
 		100, // 100 percent
 		"set GOGC percent",
@@ -441,6 +525,80 @@ var SystemConfig = Config{
 		false,      // mutable
 		false,      // case-insensitive
 	},
+	"indexer.dataport.captureDir": ConfigValue{
+		"",
+		"directory to capture mutation stream received by dataport, " +
+			"for offline replay. Empty string disables capture, " +
+			"does not affect existing streams.",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.captureFileSize": ConfigValue{
+		64 * 1024 * 1024,
+		"size, in bytes, after which capture file is rotated.",
+		64 * 1024 * 1024,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.captureMaxFiles": ConfigValue{
+		16,
+		"maximum number of capture files to retain per dataport, " +
+			"older files are removed.",
+		16,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.replayDir": ConfigValue{
+		"",
+		"directory to replay captured mutation stream from, directly " +
+			"into the stream reader of each stream, when the stream " +
+			"is opened. Empty string disables replay.",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.replaySpeed": ConfigValue{
+		0.0,
+		"replay speed relative to capture, 1 for real time, " +
+			"0 for as fast as possible.",
+		0.0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.replayMaxGap": ConfigValue{
+		0,
+		"compress idle gaps in replay to replayMaxGap milliseconds, " +
+			"0 preserves captured timestamps.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.enableCompression": ConfigValue{
+		true,
+		"advertise to projector that compressed payloads can be received, " +
+			"does not affect existing connections.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.flowControl": ConfigValue{
+		true,
+		"advertise flow control credits to projector, credits are " +
+			"returned only after mutations are handed over to indexer, " +
+			"does not affect existing connections.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.dataport.creditWindow": ConfigValue{
+		20000,
+		"number of key-versions, per bucket per connection, projector " +
+			"can send without waiting for credits from indexer.",
+		20000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	// indexer queryport configuration
 	"indexer.queryport.maxPayload": ConfigValue{
 		64 * 1024,
@@ -484,6 +642,40 @@ var SystemConfig = Config{
 		false, // immutable
 		false, // case-insensitive
 	},
+	"indexer.queryport.compression": ConfigValue{
+		"none",
+		"compression to apply on scan responses, can be none, snappy " +
+			"or gzip. Applied only on connections where client accepts " +
+			"compression.",
+		"none",
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.compressionThreshold": ConfigValue{
+		1024,
+		"scan responses smaller than this size, in bytes, are not compressed.",
+		1024,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.multiplex": ConfigValue{
+		true,
+		"allow clients to multiplex concurrent scans on a single " +
+			"connection. Multiplexing is negotiated by the client on " +
+			"every connection.",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.queryport.multiplex.maxStreams": ConfigValue{
+		1000,
+		"maximum number of concurrent scans on a multiplexed connection, " +
+			"scans beyond that are rejected. Shall not be less than " +
+			"queryport.client.multiplex.maxStreams.",
+		1000,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	// queryport client configuration
 	"queryport.client.maxPayload": ConfigValue{
 		1000 * 1024,
@@ -492,6 +684,75 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"queryport.client.enableCompression": ConfigValue{
+		true,
+		"advertise to indexer that compressed scan responses can be received.",
+		true,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.multiplex.enable": ConfigValue{
+		false,
+		"multiplex concurrent scans to an indexer on shared connections, " +
+			"if the indexer supports it. Otherwise every scan uses a " +
+			"dedicated connection from the pool.",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.multiplex.connections": ConfigValue{
+		2,
+		"number of multiplexed connections to an indexer",
+		2,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.multiplex.maxStreams": ConfigValue{
+		1000,
+		"maximum number of concurrent scans on a multiplexed connection",
+		1000,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.metadataFeed.enable": ConfigValue{
+		false,
+		"subscribe to index metadata changes pushed by indexers, and " +
+			"apply them incrementally instead of refreshing all the " +
+			"index metadata on every change.",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tracing.enable": ConfigValue{
+		false,
+		"trace scan requests. Spans are opened for every scatter " +
+			"partition and propagated to the indexers.",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tracing.sampleRate": ConfigValue{
+		1.0,
+		"fraction, between [0, 1.0], of scan requests that are traced",
+		1.0,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"queryport.client.tracing.file": ConfigValue{
+		"gsi_client_trace.json",
+		"file to which spans are written, one JSON object per line",
+		"gsi_client_trace.json",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"queryport.client.tracing.maxFileSize": ConfigValue{
+		100 * 1024 * 1024,
+		"size, in bytes, after which trace file is rotated, " +
+			"one rotated file is retained. 0 for no limit.",
+		100 * 1024 * 1024,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"queryport.client.readDeadline": ConfigValue{
 		300000,
 		"timeout, in milliseconds, is timeout while reading from socket",
@@ -647,6 +908,76 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"queryport.client.scan.hedge.enable": ConfigValue{
+		false,
+		"If the first response of a partition scan does not arrive within the hedge delay, " +
+			"send the same scan to an equivalent replica and use whichever responds first.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.scan.hedge.percentile": ConfigValue{
+		95.0,
+		"Hedge delay is the given percentile of the observed first response latency.",
+		95.0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.scan.hedge.minDelay": ConfigValue{
+		10,
+		"Minimum hedge delay in milliseconds.",
+		10,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.scan.hedge.maxDelay": ConfigValue{
+		1000,
+		"Maximum hedge delay in milliseconds.  It is also used until enough latency " +
+			"samples are collected.",
+		1000,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.scan.rangeSplit.numSplits": ConfigValue{
+		0,
+		"Split a range scan on a non-partitioned index into the given number of " +
+			"sub-ranges, using split points from the indexer, and scan them concurrently. " +
+			"Only memdb indexes are split, and scans with a limit are not. " +
+			"Use 0 or 1 to disable.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.scan.rangeSplit.queueSize": ConfigValue{
+		16,
+		"Number of response packets buffered for each sub-range of a split range scan, " +
+			"while the previous sub-ranges are returned.",
+		16,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.circuitBreaker.enable": ConfigValue{
+		true,
+		"Exclude an indexer node from replica selection after repeated scan failures, " +
+			"until a probing scan succeeds.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.circuitBreaker.failureThreshold": ConfigValue{
+		5,
+		"Number of consecutive scan failures on an indexer node to open its circuit breaker.",
+		5,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"queryport.client.circuitBreaker.openTimeout": ConfigValue{
+		10000,
+		"Time in milliseconds an open circuit breaker waits before allowing a probing scan.",
+		10000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"queryport.client.allowCJsonScanFormat": ConfigValue{
 		true,
 		"Allow collatejson as data format between queryport client and indexer.",
@@ -802,6 +1133,31 @@ var SystemConfig = Config{
 		true, // immutable
 		true, // case-sensitive
 	},
+	"indexer.tracing.enable": ConfigValue{
+		false,
+		"trace scan requests that carry a trace context from the client. " +
+			"Spans are created for snapshot wait, pipeline stages and " +
+			"storage iteration.",
+		false,
+		true,  // immutable
+		false, // case-insensitive
+	},
+	"indexer.tracing.file": ConfigValue{
+		"indexer_trace.json",
+		"file to which spans are written, one JSON object per line. " +
+			"Relative path is relative to diagnostics directory.",
+		"indexer_trace.json",
+		true, // immutable
+		true, // case-sensitive
+	},
+	"indexer.tracing.maxFileSize": ConfigValue{
+		100 * 1024 * 1024,
+		"size, in bytes, after which trace file is rotated, " +
+			"one rotated file is retained. 0 for no limit.",
+		100 * 1024 * 1024,
+		true,  // immutable
+		false, // case-insensitive
+	},
 	"indexer.nodeuuid": ConfigValue{
 		"",
 		"Indexer node UUID",
@@ -1455,6 +1811,23 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.flusher.coalesceMutations": ConfigValue{
+		false,
+		"while flushing upto a timestamp, keep only the latest version " +
+			"of a document per index instance, superseded versions are " +
+			"never applied to storage.",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.flusher.coalesceWindow": ConfigValue{
+		10000,
+		"maximum number of mutations, per vbucket, held in memory " +
+			"for coalescing before they are flushed.",
+		10000,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.mutation_manager.maxQueueMem": ConfigValue{
 		uint64(1 * 1024 * 1024 * 1024),
 		"Max memory used by the mutation queue",
@@ -1655,6 +2028,27 @@ var SystemConfig = Config{
 		true,  // immutable
 		false, // case-insensitive
 	},
+	"indexer.settings.workload_capture.enable": ConfigValue{
+		false,
+		"Capture a sample of scan shapes for index advice",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.workload_capture.sample_rate": ConfigValue{
+		0.1,
+		"Fraction of scans captured when workload capture is enabled",
+		0.1,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.settings.workload_capture.max_shapes_per_index": ConfigValue{
+		100,
+		"Maximum number of scan shapes captured per index",
+		100,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.max_array_seckey_size": ConfigValue{
 		10240,
 		"Maximum size of secondary index key size for array index",
@@ -1875,6 +2269,14 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.settings.alter_index_timeout": ConfigValue{
+		86400,
+		"Timeout in seconds for the altered index to be built before alter index is aborted, " +
+			"0 means no timeout.",
+		86400,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"projector.settings.log_level": ConfigValue{
 		"info",
 		"Projector logging level",
@@ -1976,6 +2378,28 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.rebalance.maxConcurrentBuilds": ConfigValue{
+		0,
+		"max number of indexes transferred to the same destination node in one iteration during rebalance. 0 means no limit.",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.rebalance.resumeOnRestart": ConfigValue{
+		true,
+		"resume the pending transfers of a failed rebalance when it is restarted with the same topology change.",
+		true,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.rebalance.resumeTimeout": ConfigValue{
+		3600,
+		"seconds for which the transfers of a failed rebalance can be resumed. Indexes built by " +
+			"the transfers in progress are kept on the destination until then. 0 means no timeout.",
+		3600,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.settings.storage_mode.disable_upgrade": ConfigValue{
 		false,
 		"Disable upgrading storage mode. This is checked on every indexer restart, " +
@@ -2013,6 +2437,35 @@ var SystemConfig = Config{
 		false, // mutable
 		false, // case-insensitive
 	},
+	"indexer.build.queue.enable": ConfigValue{
+		false,
+		"Queue index build requests and build them in priority order within the build window",
+		false,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.queue.window": ConfigValue{
+		"",
+		"Comma separated list of time windows (HH:MM-HH:MM, local time) when queued index build can start. " +
+			"Empty for no restriction.",
+		"",
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.queue.maxConcurrentPerNode": ConfigValue{
+		0,
+		"Maximum number of queued index being built concurrently on a node.  0 to use indexer.settings.build.batch_size",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
+	"indexer.build.queue.maxConcurrentPerBucket": ConfigValue{
+		0,
+		"Maximum number of queued index being built concurrently for a bucket on a node.  0 for no limit",
+		0,
+		false, // mutable
+		false, // case-insensitive
+	},
 	"indexer.scan.queue_size": ConfigValue{
 		20,
 		"When performing scan scattering in indexer, specify the queue size for the scatterer.",
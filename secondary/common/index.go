@@ -23,6 +23,9 @@ type IndexerId string
 
 const INDEXER_ID_NIL = IndexerId("")
 
+// Name prefix of shadow index created by alter index
+const SHADOW_INDEX_PREFIX = "#alter#"
+
 // SecondaryKey is secondary-key in the shape of - [ val1, val2, ..., valN ]
 // where value can be any golang data-type that can be serialized into JSON.
 // simple-key shall be shaped as [ val ]
@@ -212,6 +215,18 @@ type IndexDefn struct {
 	HashScheme         HashScheme `json:"hashScheme,omitempty"`
 	NumReplica2        Counter    `json:"NumReplica2,omitempty"`
 
+	// Placement rules
+	NodeLabels         []string `json:"nodeLabels,omitempty"`
+	AntiAffinity       []string `json:"antiAffinity,omitempty"`
+	ColocatePartitions bool     `json:"colocatePartitions,omitempty"`
+
+	// Alter index.  A shadow index is built with the altered definition
+	// and hidden from query until it is swapped with the index it shadows.
+	// Once swapped, it replaces the altered index, which is hidden from
+	// query until it is dropped.
+	ShadowOf IndexDefnId `json:"shadowOf,omitempty"`
+	Replaces IndexDefnId `json:"replaces,omitempty"`
+
 	// Sizing info
 	NumDoc        uint64  `json:"numDoc,omitempty"`
 	SecKeySize    uint64  `json:"secKeySize,omitempty"`
@@ -267,6 +282,13 @@ func (idx IndexDefn) String() string {
 	str += fmt.Sprintf("PartitionKeys: %v ", idx.PartitionKeys)
 	str += fmt.Sprintf("WhereExpr: %v ", logging.TagUD(idx.WhereExpr))
 	str += fmt.Sprintf("RetainDeletedXATTR: %v ", idx.RetainDeletedXATTR)
+	if len(idx.NodeLabels) != 0 || len(idx.AntiAffinity) != 0 || idx.ColocatePartitions {
+		str += fmt.Sprintf("\n\t\tNodeLabels: %v AntiAffinity: %v ColocatePartitions: %v ",
+			idx.NodeLabels, idx.AntiAffinity, idx.ColocatePartitions)
+	}
+	if idx.ShadowOf != 0 || idx.Replaces != 0 {
+		str += fmt.Sprintf("\n\t\tShadowOf: %v Replaces: %v ", idx.ShadowOf, idx.Replaces)
+	}
 	return str
 
 }
@@ -299,9 +321,25 @@ func (idx IndexDefn) Clone() *IndexDefn {
 		DocKeySize:         idx.DocKeySize,
 		ArrSize:            idx.ArrSize,
 		NumReplica2:        idx.NumReplica2,
+		NodeLabels:         idx.NodeLabels,
+		AntiAffinity:       idx.AntiAffinity,
+		ColocatePartitions: idx.ColocatePartitions,
+		ShadowOf:           idx.ShadowOf,
+		Replaces:           idx.Replaces,
 	}
 }
 
+//
+// Name of the shadow index built for altering the given index.
+//
+func ShadowIndexName(name string) string {
+	return SHADOW_INDEX_PREFIX + name
+}
+
+func (idx *IndexDefn) IsShadow() bool {
+	return idx.ShadowOf != 0
+}
+
 func (idx *IndexDefn) HasDescending() bool {
 
 	if idx.Desc != nil {
@@ -71,6 +71,15 @@ type RouterEndpoint interface {
 	WaitForExit() error
 }
 
+// FlowControlledEndpoint is optionally implemented by endpoints whose
+// remote advertises credits, upstream can use it to stop consuming
+// mutations for a bucket instead of blocking on Send().
+type FlowControlledEndpoint interface {
+	// WaitCredits return a channel that will be closed when credits are
+	// available for bucket, nil if bucket already has credits.
+	WaitCredits(bucket string) <-chan struct{}
+}
+
 // MarshalJSON implements encoding/json.Marshaler{} interface
 func (r RouterEndpointFactory) MarshalJSON() ([]byte, error) {
 	return []byte(`""`), nil
@@ -0,0 +1,345 @@
+// Capture mutation stream received by dataport server into rotating files,
+// so that it can be replayed later, refer replay.go.
+//
+// file format:
+//
+//      { magic[8], record, record, ... }
+//
+//      record := { uint32(len), byte(kind), int64(timestamp), []byte(data) }
+//
+//      where, len == 1 + 8 + len(data)
+//             kind is captureKindVbKeyVersions, data is protobuf.Payload
+//             kind is captureKindConnError, data is JSON ConnectionError
+//
+// files are named as <prefix>.<seqno>.cap, where seqno is monotonically
+// increasing, older files are removed once their count exceeds maxFiles.
+
+package dataport
+
+import "bufio"
+import "encoding/binary"
+import "encoding/json"
+import "errors"
+import "fmt"
+import "io"
+import "net"
+import "os"
+import "path/filepath"
+import "sort"
+import "strconv"
+import "strings"
+import "time"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/couchbase/indexing/secondary/logging"
+import "github.com/golang/protobuf/proto"
+
+// ErrorCaptureFormat
+var ErrorCaptureFormat = errors.New("dataport.captureFormat")
+
+var captureMagic = []byte("GSICAP01")
+
+const captureSuffix = ".cap"
+
+// kind of captured records.
+const (
+	captureKindVbKeyVersions byte = iota + 1
+	captureKindConnError
+)
+
+const captureHdrSize = 4 + 1 + 8
+
+type captureWriter struct {
+	dir         string
+	prefix      string
+	maxFileSize int64
+	maxFiles    int
+	logPrefix   string
+	// local fields
+	seqno int
+	files []string
+	fd    *os.File
+	w     *bufio.Writer
+	size  int64
+	hdr   [captureHdrSize]byte
+}
+
+func newCaptureWriter(
+	dir, prefix string,
+	maxFileSize int64, maxFiles int,
+	logPrefix string) (*captureWriter, error) {
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	files, err := captureFiles(dir, prefix)
+	if err != nil {
+		return nil, err
+	}
+	cw := &captureWriter{
+		dir:         dir,
+		prefix:      prefix,
+		maxFileSize: maxFileSize,
+		maxFiles:    maxFiles,
+		logPrefix:   logPrefix,
+		files:       files,
+	}
+	// continue from last capture, do not overwrite them.
+	if n := len(files); n > 0 {
+		_, cw.seqno = captureName(files[n-1])
+	}
+	if err := cw.rotate(); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *captureWriter) writeVbKeyVersions(vbs []*protobuf.VbKeyVersions) error {
+	pl := &protobuf.Payload{
+		Version: proto.Uint32(uint32(ProtobufVersion())),
+		Vbkeys:  vbs,
+	}
+	data, err := proto.Marshal(pl)
+	if err != nil {
+		return err
+	}
+	return cw.write(captureKindVbKeyVersions, data)
+}
+
+func (cw *captureWriter) writeConnectionError(ce ConnectionError) error {
+	data, err := json.Marshal(ce)
+	if err != nil {
+		return err
+	}
+	return cw.write(captureKindConnError, data)
+}
+
+func (cw *captureWriter) write(kind byte, data []byte) error {
+	if cw.size >= cw.maxFileSize {
+		if err := cw.rotate(); err != nil {
+			return err
+		}
+	}
+	binary.BigEndian.PutUint32(cw.hdr[:4], uint32(1+8+len(data)))
+	cw.hdr[4] = kind
+	binary.BigEndian.PutUint64(cw.hdr[5:], uint64(time.Now().UnixNano()))
+	if _, err := cw.w.Write(cw.hdr[:]); err != nil {
+		return err
+	}
+	if _, err := cw.w.Write(data); err != nil {
+		return err
+	}
+	cw.size += int64(captureHdrSize + len(data))
+	// flush every record, so that a crashed indexer leaves usable capture.
+	return cw.w.Flush()
+}
+
+// close current file and open the next one in sequence.
+func (cw *captureWriter) rotate() error {
+	if err := cw.closeFile(); err != nil {
+		return err
+	}
+	cw.seqno++
+	name := fmt.Sprintf("%v.%08d%v", cw.prefix, cw.seqno, captureSuffix)
+	path := filepath.Join(cw.dir, name)
+	fd, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	cw.fd, cw.w, cw.size = fd, bufio.NewWriter(fd), 0
+	if _, err := cw.w.Write(captureMagic); err != nil {
+		return err
+	}
+	cw.size += int64(len(captureMagic))
+	cw.files = append(cw.files, path)
+	logging.Infof("%v capturing to %q\n", cw.logPrefix, path)
+
+	for cw.maxFiles > 0 && len(cw.files) > cw.maxFiles {
+		if err := os.Remove(cw.files[0]); err != nil {
+			logging.Errorf("%v remove %q: %v\n", cw.logPrefix, cw.files[0], err)
+		}
+		cw.files = cw.files[1:]
+	}
+	return nil
+}
+
+func (cw *captureWriter) closeFile() error {
+	if cw.fd == nil {
+		return nil
+	}
+	if err := cw.w.Flush(); err != nil {
+		cw.fd.Close()
+		return err
+	}
+	err := cw.fd.Close()
+	cw.fd, cw.w = nil, nil
+	return err
+}
+
+func (cw *captureWriter) close() error {
+	return cw.closeFile()
+}
+
+// CaptureRecord is a single record read back from capture files.
+type CaptureRecord struct {
+	Seqno     uint64    // position of this record in the capture
+	Timestamp time.Time // time at which dataport received the record
+	// only one of the following is valid.
+	Vbs     []*protobuf.VbKeyVersions
+	ConnErr ConnectionError
+}
+
+// CaptureReader reads records from capture files, in the same order they
+// were captured.
+type CaptureReader struct {
+	files []string
+	fd    *os.File
+	r     *bufio.Reader
+	seqno uint64
+	hdr   [captureHdrSize]byte
+}
+
+// CapturePrefix returns the prefix of capture files written by dataport
+// server listening on `laddr`, files are prefixed by the port so that
+// capture of each stream can be replayed into the same stream.
+func CapturePrefix(laddr string) string {
+	_, port, _ := net.SplitHostPort(laddr)
+	return "dataport-" + port
+}
+
+// NewCaptureReader opens capture files matching `prefix` under `dir`, if
+// prefix is empty all capture files under dir are read.
+func NewCaptureReader(dir, prefix string) (*CaptureReader, error) {
+	files, err := captureFiles(dir, prefix)
+	if err != nil {
+		return nil, err
+	} else if len(files) == 0 {
+		return nil, fmt.Errorf("no capture files in %q", dir)
+	}
+	return &CaptureReader{files: files}, nil
+}
+
+// Next returns the next captured record, io.EOF when all the files are read.
+func (cr *CaptureReader) Next() (*CaptureRecord, error) {
+	for {
+		if cr.fd == nil {
+			if len(cr.files) == 0 {
+				return nil, io.EOF
+			}
+			if err := cr.openFile(cr.files[0]); err != nil {
+				return nil, err
+			}
+			cr.files = cr.files[1:]
+		}
+
+		_, err := io.ReadFull(cr.r, cr.hdr[:])
+		if err == io.EOF {
+			cr.closeFile()
+			continue
+		} else if err != nil { // partially written record, move on.
+			logging.Warnf("CaptureReader: truncated record: %v\n", err)
+			cr.closeFile()
+			continue
+		}
+		l := int(binary.BigEndian.Uint32(cr.hdr[:4]))
+		if l < 1+8 {
+			return nil, ErrorCaptureFormat
+		}
+		kind := cr.hdr[4]
+		ts := int64(binary.BigEndian.Uint64(cr.hdr[5:]))
+		data := make([]byte, l-1-8)
+		if _, err := io.ReadFull(cr.r, data); err != nil {
+			logging.Warnf("CaptureReader: truncated record: %v\n", err)
+			cr.closeFile()
+			continue
+		}
+
+		cr.seqno++
+		rec := &CaptureRecord{Seqno: cr.seqno, Timestamp: time.Unix(0, ts)}
+		switch kind {
+		case captureKindVbKeyVersions:
+			value, err := protobufDecode(data)
+			if err != nil {
+				return nil, err
+			}
+			vbs, ok := value.([]*protobuf.VbKeyVersions)
+			if !ok {
+				return nil, ErrorCaptureFormat
+			}
+			rec.Vbs = vbs
+
+		case captureKindConnError:
+			if err := json.Unmarshal(data, &rec.ConnErr); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, ErrorCaptureFormat
+		}
+		return rec, nil
+	}
+}
+
+// Close the reader.
+func (cr *CaptureReader) Close() {
+	cr.closeFile()
+	cr.files = nil
+}
+
+func (cr *CaptureReader) openFile(path string) error {
+	fd, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(fd)
+	magic := make([]byte, len(captureMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != string(captureMagic) {
+		fd.Close()
+		return ErrorCaptureFormat
+	}
+	cr.fd, cr.r = fd, r
+	return nil
+}
+
+func (cr *CaptureReader) closeFile() {
+	if cr.fd != nil {
+		cr.fd.Close()
+		cr.fd, cr.r = nil, nil
+	}
+}
+
+// list capture files for prefix, sorted by {prefix, seqno}.
+func captureFiles(dir, prefix string) ([]string, error) {
+	pattern := filepath.Join(dir, "*"+captureSuffix)
+	if prefix != "" {
+		pattern = filepath.Join(dir, prefix+".*"+captureSuffix)
+	}
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(captureFileList(files))
+	return files, nil
+}
+
+type captureFileList []string
+
+func (files captureFileList) Len() int      { return len(files) }
+func (files captureFileList) Swap(i, j int) { files[i], files[j] = files[j], files[i] }
+
+func (files captureFileList) Less(i, j int) bool {
+	pi, si := captureName(files[i])
+	pj, sj := captureName(files[j])
+	if pi != pj {
+		return pi < pj
+	}
+	return si < sj
+}
+
+// split capture file path into prefix and seqno.
+func captureName(path string) (string, int) {
+	name := strings.TrimSuffix(path, captureSuffix)
+	ext := filepath.Ext(name)
+	seqno, _ := strconv.Atoi(strings.TrimPrefix(ext, "."))
+	return strings.TrimSuffix(name, ext), seqno
+}
@@ -0,0 +1,153 @@
+package dataport
+
+import "io/ioutil"
+import "os"
+import "testing"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+
+func TestCaptureReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataport-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// small file size to force rotation.
+	cw, err := newCaptureWriter(dir, "dataport-9999", 1024, 0, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nBatches, nVbs, nMuts, nIndexes := 10, 4, 5, 2
+	for i := 0; i < nBatches; i++ {
+		vbs := constructVbKeyVersions("default", i*nMuts, nVbs, nMuts, nIndexes)
+		if i == 0 {
+			for _, vb := range vbs {
+				kv := c.NewKeyVersions(0, nil, 1)
+				kv.AddStreamBegin()
+				vb.Kvs = append([]*c.KeyVersions{kv}, vb.Kvs...)
+			}
+		}
+		if err := cw.writeVbKeyVersions(captureVbs(t, vbs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ce := ConnectionError{"default": []uint16{0, 1, 2, 3}}
+	if err := cw.writeConnectionError(ce); err != nil {
+		t.Fatal(err)
+	}
+	// mutations after connection error are prefixed with StreamBegin.
+	vbs := constructVbKeyVersions("default", 1000, nVbs, nMuts, nIndexes)
+	if err := cw.writeVbKeyVersions(captureVbs(t, vbs)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cw.close(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := captureFiles(dir, "dataport-9999"); len(files) < 2 {
+		t.Fatalf("expected capture files to rotate, got %v", files)
+	}
+
+	reader, err := NewCaptureReader(dir, "dataport-9999")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	appch := make(chan interface{}, nBatches+2)
+	stats, err := Replay(reader, NewChannelSink(appch, nil), ReplayOptions{})
+	if err != nil {
+		t.Fatal(err)
+	} else if stats.Records != uint64(nBatches+2) || stats.ConnErrors != 1 {
+		t.Fatalf("unexpected replay stats %+v", stats)
+	}
+	close(appch)
+
+	seqno, nConnErrs := 0, 0
+	for msg := range appch {
+		switch val := msg.(type) {
+		case []*protobuf.VbKeyVersions:
+			if len(val) != nVbs {
+				t.Fatalf("expected %v vbuckets, got %v", nVbs, len(val))
+			}
+			begin := seqno == 0 || nConnErrs > 0
+			for _, vb := range val {
+				if startsWithStreamBegin(vb) != begin {
+					t.Fatalf("batch %v, expected StreamBegin %v", seqno, begin)
+				}
+			}
+			seqno++
+
+		case ConnectionError:
+			if seqno != nBatches {
+				t.Fatalf("connection error replayed out of order")
+			}
+			nConnErrs++
+		}
+	}
+	if seqno != nBatches+1 || nConnErrs != 1 {
+		t.Fatalf("unexpected replay %v %v", seqno, nConnErrs)
+	}
+}
+
+func TestCapturePrefix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dataport-capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	laddrs := []string{"localhost:9998", "localhost:9999"}
+	for i, laddr := range laddrs {
+		cw, err := newCaptureWriter(dir, CapturePrefix(laddr), 1024, 0, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for j := 0; j <= i; j++ {
+			vbs := constructVbKeyVersions("default", j, 1, 1, 1)
+			if err := cw.writeVbKeyVersions(captureVbs(t, vbs)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := cw.close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// each port replays only its own capture.
+	for i, laddr := range laddrs {
+		reader, err := NewCaptureReader(dir, CapturePrefix(laddr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		appch := make(chan interface{}, len(laddrs))
+		stats, err := Replay(reader, NewChannelSink(appch, nil), ReplayOptions{})
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		} else if stats.Records != uint64(i+1) {
+			t.Fatalf("%v: expected %v records, got %v", laddr, i+1, stats.Records)
+		}
+	}
+}
+
+func TestChannelSinkClosed(t *testing.T) {
+	finch := make(chan bool)
+	close(finch)
+	sink := NewChannelSink(make(chan interface{}), finch)
+	if err := sink.ConnectionError(ConnectionError{}); err != ErrorReplayClosed {
+		t.Fatalf("expected %v, got %v", ErrorReplayClosed, err)
+	}
+}
+
+func captureVbs(t *testing.T, vbs []*c.VbKeyVersions) []*protobuf.VbKeyVersions {
+	data, err := protobufEncode(vbs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := protobufDecode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload.([]*protobuf.VbKeyVersions)
+}
@@ -15,6 +15,10 @@
 //                            |
 //                            V
 //                          buffers
+//
+// if remote advertises flow control credits, buffers of buckets that have
+// run out of credits are held back until remote returns credits, refer
+// flowcontrol.go.
 
 package dataport
 
@@ -26,6 +30,7 @@ import "strings"
 import "sync/atomic"
 
 import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
 import "github.com/couchbase/indexing/secondary/transport"
 import "github.com/couchbase/indexing/secondary/logging"
 
@@ -45,6 +50,15 @@ type RouterEndpoint struct {
 	bufferTm   time.Duration // timeout to flush endpoint-buffer
 	harakiriTm time.Duration // timeout after which endpoint commits harakiri
 	statTick   time.Duration // timeout for logging statistics
+	// compression is applied only after remote accepts it.
+	compression   byte
+	compThreshold int
+	compAccepted  uint32
+	compApplied   bool
+	compStats     *transport.CompressionStats
+	// flow control is applied only after remote advertises credits.
+	flowControl bool
+	fc          *flowControl
 	// gen-server
 	ch    chan []interface{} // carries control commands
 	finch chan bool
@@ -88,15 +102,24 @@ func NewRouterEndpoint(
 		bufferTm:   time.Duration(config["bufferTimeout"].Int()),
 		harakiriTm: time.Duration(config["harakiriTimeout"].Int()),
 		prjLatency: &Average{},
+		compStats:  transport.NewCompressionStats(),
+		fc:         newFlowControl(),
 	}
+	endpoint.compression, err = transport.CompressionType(config["compression"].String())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	endpoint.compThreshold = config["compressionThreshold"].Int()
+	endpoint.flowControl = config["flowControl"].Bool()
 	endpoint.ch = make(chan []interface{}, endpoint.keyChSize)
 	endpoint.conn = conn
-	// TODO: add configuration params for transport flags.
 	flags := transport.TransportFlag(0).SetProtobuf()
 	maxPayload := config["maxPayload"].Int()
 	endpoint.pkt = transport.NewTransportPacket(maxPayload, flags)
 	endpoint.pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
 	endpoint.pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	endpoint.pkt.SetCompressionStats(endpoint.compStats)
 
 	endpoint.statTick *= time.Millisecond
 	endpoint.bufferTm *= time.Millisecond
@@ -106,11 +129,49 @@ func NewRouterEndpoint(
 		"ENDP[<-(%v,%4x)<-%v #%v]",
 		endpoint.raddr, uint16(endpoint.timestamp), cluster, topic)
 
+	if endpoint.compression != transport.CompressionNone || endpoint.flowControl {
+		go endpoint.doReceive()
+	}
 	go endpoint.run(endpoint.ch)
 	logging.Infof("%v started ...\n", endpoint.logPrefix)
 	return endpoint, nil
 }
 
+// read packets sent by remote, remote can advertise that it can decompress
+// payloads and it can advertise flow control credits. Nothing else is
+// sent by remote on this connection.
+func (endpoint *RouterEndpoint) doReceive() {
+	defer endpoint.fc.disable() // don't let upstream wait on a dead remote.
+
+	buf := make([]byte, transport.MaxSendBufSize)
+	for {
+		flags, data, err := transport.Receive(endpoint.conn, buf)
+		if err != nil {
+			logging.Tracef("%v doReceive(): %v\n", endpoint.logPrefix, err)
+			return
+		}
+		if flags.AcceptsCompression() {
+			atomic.StoreUint32(&endpoint.compAccepted, 1)
+			logging.Infof("%v remote accepts compression\n", endpoint.logPrefix)
+		}
+		if len(data) == 0 || !endpoint.flowControl {
+			continue
+		}
+		payload, err := protobufDecode(data)
+		if err != nil {
+			logging.Errorf("%v doReceive(): %v\n", endpoint.logPrefix, err)
+			return
+		}
+		credits, ok := payload.([]*protobuf.BucketCredits)
+		if !ok {
+			fmsg := "%v doReceive(): unexpected payload %T\n"
+			logging.Errorf(fmsg, endpoint.logPrefix, payload)
+			return
+		}
+		endpoint.fc.grant(credits)
+	}
+}
+
 // commands
 const (
 	endpCmdPing byte = iota + 1
@@ -144,6 +205,11 @@ func (endpoint *RouterEndpoint) Send(data interface{}) error {
 	return c.FailsafeOpNoblock(endpoint.ch, cmd, endpoint.finch)
 }
 
+// WaitCredits implements common.FlowControlledEndpoint{} interface.
+func (endpoint *RouterEndpoint) WaitCredits(bucket string) <-chan struct{} {
+	return endpoint.fc.wait(bucket)
+}
+
 // GetStatistics for this endpoint, synchronous call.
 func (endpoint *RouterEndpoint) GetStatistics() map[string]interface{} {
 	respch := make(chan []interface{}, 1)
@@ -183,6 +249,7 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 		}
 		// close the connection
 		endpoint.conn.Close()
+		endpoint.fc.disable()
 		// close this endpoint
 		atomic.StoreUint32(&endpoint.done, 1)
 		close(endpoint.finch)
@@ -190,7 +257,7 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 	}()
 
 	statSince := time.Now()
-	var stitems [14]string
+	var stitems [20]string
 	logstats := func() {
 		prjLatency := endpoint.prjLatency
 		stitems[0] = `"topic":"` + endpoint.topic + `"`
@@ -207,6 +274,14 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 		stitems[11] = `"latency.min":` + strconv.Itoa(int(prjLatency.Min()))
 		stitems[12] = `"latency.max":` + strconv.Itoa(int(prjLatency.Max()))
 		stitems[13] = `"latency.avg":` + strconv.Itoa(int(prjLatency.Mean()))
+		compStats := endpoint.compStats.Map()
+		stitems[14] = `"compression.count":` + fmt.Sprint(compStats["compressCount"])
+		stitems[15] = `"compression.ratio":` + fmt.Sprintf("%.2f", compStats["compressRatio"])
+		stitems[16] = `"compression.time":` + fmt.Sprint(compStats["compressTime"])
+		fcEnabled, fcWaitCount, fcWaitTime := endpoint.fc.stats()
+		stitems[17] = `"flowControl":` + strconv.FormatBool(fcEnabled)
+		stitems[18] = `"flowControl.waitCount":` + strconv.Itoa(int(fcWaitCount))
+		stitems[19] = `"flowControl.waitTime":` + strconv.Itoa(int(fcWaitTime))
 		statjson := strings.Join(stitems[:], ",")
 		fmsg := "%v stats {%v}\n"
 		logging.Infof(fmsg, endpoint.logPrefix, statjson)
@@ -220,7 +295,11 @@ func (endpoint *RouterEndpoint) run(ch chan []interface{}) {
 	flushBuffers := func() (err error) {
 		fmsg := "%v sent %v mutations to %q\n"
 		logging.Tracef(fmsg, endpoint.logPrefix, messageCount, raddr)
-		if messageCount > 0 {
+		if !endpoint.compApplied && atomic.LoadUint32(&endpoint.compAccepted) == 1 {
+			endpoint.pkt.SetCompression(endpoint.compression, endpoint.compThreshold)
+			endpoint.compApplied = true
+		}
+		if buffers.pending() {
 			err = buffers.flushBuffers(endpoint, endpoint.conn, endpoint.pkt)
 			if err != nil {
 				logging.Errorf("%v flushBuffers() %v\n", endpoint.logPrefix, err)
@@ -55,22 +55,44 @@ func (b *endpointBuffers) addKeyVersions(
 	}
 }
 
-// flush the buffers to the other end.
+// pending returns whether there are buffered key-versions.
+func (b *endpointBuffers) pending() bool {
+	return len(b.vbs) > 0
+}
+
+// flush the buffers to the other end, buffers of buckets that have run out
+// of flow control credits are held back.
 func (b *endpointBuffers) flushBuffers(
 	endpoint *RouterEndpoint,
 	conn net.Conn,
 	pkt *transport.TransportPacket) error {
 
 	vbs := make([]*c.VbKeyVersions, 0, len(b.vbs))
-	for _, vb := range b.vbs {
+	debits := make(map[string]int64)
+	for uuid, vb := range b.vbs {
+		if _, ok := debits[vb.Bucket]; !ok {
+			if !endpoint.fc.hasCredits(vb.Bucket) {
+				continue
+			}
+			debits[vb.Bucket] = 0
+		}
 		vbs = append(vbs, vb)
+		delete(b.vbs, uuid)
 		for _, kv := range vb.Kvs {
 			if kv.Ctime > 0 {
 				endpoint.prjLatency.Add(time.Now().UnixNano() - kv.Ctime)
 			}
+			if len(kv.Uuids) > 0 { // same as what is encoded.
+				debits[vb.Bucket]++
+			}
 		}
 	}
-	b.vbs = make(map[string]*c.VbKeyVersions)
+	if len(vbs) == 0 {
+		return nil
+	}
+	for bucket, n := range debits {
+		endpoint.fc.debit(bucket, n)
+	}
 
 	if err := pkt.Send(conn, vbs); err != nil {
 		return err
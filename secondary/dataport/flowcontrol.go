@@ -0,0 +1,217 @@
+// Credit based flow control between router endpoint and dataport server.
+//
+// protocol:
+//
+// 1. dataport server, on accepting a new connection, advertises the initial
+//    window as BucketCredits with empty bucket name. Window is counted in
+//    number of key-versions and applies to every bucket streamed on that
+//    connection.
+//
+// 2. router endpoint debits bucket's credits for every key-version it
+//    flushes, and holds back buffered key-versions for buckets that have
+//    run out of credits. Upstream, like KVData, can wait for credits using
+//    WaitCredits() and stop consuming DCP events for that bucket, thereby
+//    letting DCP buffer-ack throttle the producer.
+//
+// 3. dataport server returns credits for key-versions only after they are
+//    handed over to the application.
+//
+// endpoints that don't understand flow control never read from connection,
+// server detects this when key-versions received for a bucket exceeds
+// credits by more than a window and stops advertising for that connection.
+// Likewise a server that does not advertise a window is never waited upon.
+
+package dataport
+
+import "sync"
+import "time"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/golang/protobuf/proto"
+
+// flowControl tracks credits advertised by remote, per bucket, for a
+// router endpoint. Credits are updated by endpoint's reader and run
+// routines and waited upon by upstream, hence the lock.
+type flowControl struct {
+	mu      sync.Mutex
+	enabled bool
+	window  int64
+	credits map[string]int64         // bucket -> available credits
+	waiters map[string]chan struct{} // bucket -> closed when credited
+	since   map[string]time.Time     // bucket -> starved since
+	// statistics
+	waitCount int64
+	waitTime  int64 // nanoseconds
+}
+
+func newFlowControl() *flowControl {
+	return &flowControl{
+		credits: make(map[string]int64),
+		waiters: make(map[string]chan struct{}),
+		since:   make(map[string]time.Time),
+	}
+}
+
+// grant credits advertised by remote.
+func (fc *flowControl) grant(credits []*protobuf.BucketCredits) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	for _, bc := range credits {
+		bucket, n := bc.GetBucket(), int64(bc.GetCredits())
+		if bucket == "" { // initial window
+			fc.enabled, fc.window = true, n
+			continue
+		}
+		fc.credits[bucket] = fc.available(bucket) + n
+		if fc.credits[bucket] > 0 {
+			fc.wakeup(bucket)
+		}
+	}
+}
+
+// debit credits for `n` key-versions flushed for bucket.
+func (fc *flowControl) debit(bucket string, n int64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if fc.enabled {
+		fc.credits[bucket] = fc.available(bucket) - n
+		if _, ok := fc.since[bucket]; !ok && fc.credits[bucket] <= 0 {
+			fc.since[bucket] = time.Now()
+		}
+	}
+}
+
+// hasCredits for bucket, always true if remote does not do flow control.
+func (fc *flowControl) hasCredits(bucket string) bool {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	return !fc.enabled || fc.available(bucket) > 0
+}
+
+// wait returns a channel that is closed when credits are available for
+// bucket, nil if bucket already has credits.
+func (fc *flowControl) wait(bucket string) <-chan struct{} {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	if !fc.enabled || fc.available(bucket) > 0 {
+		return nil
+	}
+	ch, ok := fc.waiters[bucket]
+	if !ok {
+		ch = make(chan struct{})
+		fc.waiters[bucket] = ch
+	}
+	return ch
+}
+
+// disable flow control and release all waiters, once disabled remote's
+// credits are ignored.
+func (fc *flowControl) disable() {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	fc.enabled = false
+	for bucket := range fc.waiters {
+		fc.wakeup(bucket)
+	}
+}
+
+func (fc *flowControl) stats() (enabled bool, waitCount, waitTime int64) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+
+	waitTime = fc.waitTime
+	for _, since := range fc.since { // include ongoing waits.
+		waitTime += int64(time.Since(since))
+	}
+	return fc.enabled, fc.waitCount, waitTime
+}
+
+// should be called with lock held.
+func (fc *flowControl) available(bucket string) int64 {
+	if credits, ok := fc.credits[bucket]; ok {
+		return credits
+	}
+	return fc.window
+}
+
+// should be called with lock held.
+func (fc *flowControl) wakeup(bucket string) {
+	if since, ok := fc.since[bucket]; ok {
+		fc.waitCount++
+		fc.waitTime += int64(time.Since(since))
+		delete(fc.since, bucket)
+	}
+	if ch, ok := fc.waiters[bucket]; ok {
+		close(ch)
+		delete(fc.waiters, bucket)
+	}
+}
+
+// connCredits tracks credits advertised by dataport server on a single
+// connection. Used only from server's gen-server routine.
+type connCredits struct {
+	enabled     bool
+	window      int64
+	outstanding map[string]int64 // bucket -> credits with router
+	pending     map[string]int64 // bucket -> credits yet to be returned
+}
+
+func newConnCredits(window int64) *connCredits {
+	return &connCredits{
+		enabled:     true,
+		window:      window,
+		outstanding: make(map[string]int64),
+		pending:     make(map[string]int64),
+	}
+}
+
+// received key-versions for buckets, return false if router is found
+// to ignore the credits advertised to it.
+func (cc *connCredits) received(counts map[string]int64) bool {
+	for bucket, n := range counts {
+		outstanding, ok := cc.outstanding[bucket]
+		if !ok {
+			outstanding = cc.window
+		}
+		cc.outstanding[bucket] = outstanding - n
+		if cc.outstanding[bucket] < -cc.window {
+			cc.enabled = false
+		}
+	}
+	return cc.enabled
+}
+
+// consumed key-versions for buckets by application, return credits that
+// are due for the router. Credits are returned in batches of quarter
+// window, or right away if router is about to run out of credits.
+func (cc *connCredits) consumed(counts map[string]int64) []*protobuf.BucketCredits {
+	var credits []*protobuf.BucketCredits
+	batch := cc.window / 4
+	for bucket, n := range counts {
+		cc.pending[bucket] += n
+		pending := cc.pending[bucket]
+		if pending >= batch || cc.outstanding[bucket] < batch {
+			bc := &protobuf.BucketCredits{
+				Bucket:  proto.String(bucket),
+				Credits: proto.Uint64(uint64(pending)),
+			}
+			credits = append(credits, bc)
+			cc.outstanding[bucket] += pending
+			cc.pending[bucket] = 0
+		}
+	}
+	return credits
+}
+
+// count key-versions per bucket, in the same unit as debited by router.
+func countKeyVersions(vbs []*protobuf.VbKeyVersions) map[string]int64 {
+	counts := make(map[string]int64)
+	for _, vb := range vbs {
+		counts[vb.GetBucketname()] += int64(len(vb.GetKvs()))
+	}
+	return counts
+}
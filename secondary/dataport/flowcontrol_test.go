@@ -0,0 +1,83 @@
+package dataport
+
+import "testing"
+
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/golang/protobuf/proto"
+
+func TestFlowControl(t *testing.T) {
+	fc := newFlowControl()
+	// without a window from remote there is no flow control.
+	fc.debit("default", 1000)
+	if ch := fc.wait("default"); ch != nil {
+		t.Fatalf("unexpected wait without flow control")
+	}
+
+	fc.grant(bucketCredits("", 100))
+	fc.debit("default", 60)
+	if ch := fc.wait("default"); ch != nil {
+		t.Fatalf("unexpected wait with credits")
+	}
+	fc.debit("default", 60)
+	ch := fc.wait("default")
+	if ch == nil {
+		t.Fatalf("expected to wait after exhausting credits")
+	} else if !fc.hasCredits("beer-sample") {
+		t.Fatalf("expected window credits for other buckets")
+	}
+	fc.grant(bucketCredits("default", 10))
+	select {
+	case <-ch:
+		t.Fatalf("unexpected wakeup, credits still exhausted")
+	default:
+	}
+	fc.grant(bucketCredits("default", 50))
+	select {
+	case <-ch:
+	default:
+		t.Fatalf("expected wakeup after credits")
+	}
+	if _, count, _ := fc.stats(); count != 1 {
+		t.Fatalf("expected 1 wait, got %v", count)
+	}
+
+	// disable releases waiters.
+	fc.debit("default", 100)
+	ch = fc.wait("default")
+	fc.disable()
+	select {
+	case <-ch:
+	default:
+		t.Fatalf("expected wakeup after disable")
+	}
+}
+
+func TestConnCredits(t *testing.T) {
+	cc := newConnCredits(100)
+	counts := map[string]int64{"default": 10}
+	if !cc.received(counts) {
+		t.Fatalf("unexpected disable")
+	}
+	// below a quarter window, credits are held back.
+	if credits := cc.consumed(counts); len(credits) != 0 {
+		t.Fatalf("unexpected credits %v", credits)
+	}
+	counts = map[string]int64{"default": 20}
+	cc.received(counts)
+	credits := cc.consumed(counts)
+	if len(credits) != 1 || credits[0].GetCredits() != 30 {
+		t.Fatalf("unexpected credits %v", credits)
+	}
+	// router that ignores credits.
+	if cc.received(map[string]int64{"default": 300}) {
+		t.Fatalf("expected flow control to be disabled")
+	}
+}
+
+func bucketCredits(bucket string, n uint64) []*protobuf.BucketCredits {
+	bc := &protobuf.BucketCredits{
+		Bucket:  proto.String(bucket),
+		Credits: proto.Uint64(n),
+	}
+	return []*protobuf.BucketCredits{bc}
+}
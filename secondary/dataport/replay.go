@@ -0,0 +1,244 @@
+// Replay mutation stream captured by dataport server, refer capture.go.
+//
+// Records are replayed strictly in the order they were captured, from a
+// single routine, so that two replays of the same capture deliver the same
+// sequence of mutations. Replayed stream can be fed,
+//
+// a. to a dataport server, like that of a local indexer, over TCP,
+//    as if it is coming from a router, refer NewEndpointSink().
+// b. to an application channel, as if it is coming from a dataport server,
+//    for instance the mutation channel read by indexer's stream reader,
+//    refer NewChannelSink().
+
+package dataport
+
+import "errors"
+import "io"
+import "net"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
+import "github.com/couchbase/indexing/secondary/transport"
+import "github.com/couchbase/indexing/secondary/logging"
+import "github.com/golang/protobuf/proto"
+
+// ErrorReplayClosed
+var ErrorReplayClosed = errors.New("dataport.replayClosed")
+
+// ReplaySink consumes replayed records.
+type ReplaySink interface {
+	// VbKeyVersions is called for every batch of captured mutations.
+	VbKeyVersions(vbs []*protobuf.VbKeyVersions) error
+
+	// ConnectionError is called for every captured connection error.
+	ConnectionError(ce ConnectionError) error
+
+	// Close the sink.
+	Close() error
+}
+
+// ReplayOptions to control the pace of replay.
+type ReplayOptions struct {
+	// Speed factor relative to capture, 1 replays in real time, 2 replays
+	// twice as fast, 0 replays as fast as the sink can consume.
+	Speed float64
+	// MaxGap compress idle time between records to MaxGap, 0 preserves
+	// captured timestamps.
+	MaxGap time.Duration
+	// Limit replay to these many records, 0 replays all records.
+	Limit uint64
+}
+
+// ReplayStats summarize a replay.
+type ReplayStats struct {
+	Records    uint64
+	Mutations  uint64
+	ConnErrors uint64
+	Elapsed    time.Duration
+}
+
+// Replay records from `reader` into `sink`, returns when reader is
+// exhausted or sink fails. Vbuckets whose StreamBegin was rotated out of
+// the capture are prefixed with a StreamBegin, so that downstream does not
+// filter their mutations.
+func Replay(
+	reader *CaptureReader, sink ReplaySink,
+	opts ReplayOptions) (stats ReplayStats, err error) {
+
+	active := make(map[string]bool) // StreamID -> true
+	start := time.Now()
+	var prevTs time.Time
+	var virtual time.Duration // elapsed capture time, after compressing gaps
+
+	defer func() {
+		stats.Elapsed = time.Since(start)
+	}()
+
+	for opts.Limit == 0 || stats.Records < opts.Limit {
+		rec, err := reader.Next()
+		if err == io.EOF {
+			return stats, nil
+		} else if err != nil {
+			return stats, err
+		}
+
+		// pace the replay.
+		if !prevTs.IsZero() {
+			gap := rec.Timestamp.Sub(prevTs)
+			if gap < 0 {
+				gap = 0
+			} else if opts.MaxGap > 0 && gap > opts.MaxGap {
+				gap = opts.MaxGap
+			}
+			virtual += gap
+		}
+		prevTs = rec.Timestamp
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(virtual) / opts.Speed))
+			if d := due.Sub(time.Now()); d > 0 {
+				time.Sleep(d)
+			}
+		}
+
+		stats.Records++
+		if rec.ConnErr != nil {
+			for bucket, vbnos := range rec.ConnErr {
+				for _, vbno := range vbnos {
+					delete(active, c.StreamID(bucket, vbno))
+				}
+			}
+			stats.ConnErrors++
+			if err := sink.ConnectionError(rec.ConnErr); err != nil {
+				return stats, err
+			}
+			continue
+		}
+
+		for _, vb := range rec.Vbs {
+			stats.Mutations += uint64(len(vb.GetKvs()))
+			replayStreamBegin(active, vb)
+		}
+		if err := sink.VbKeyVersions(rec.Vbs); err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// track active vbuckets and prefix StreamBegin for inactive ones.
+func replayStreamBegin(active map[string]bool, vb *protobuf.VbKeyVersions) {
+	id := c.StreamID(vb.GetBucketname(), uint16(vb.GetVbucket()))
+	if !active[id] && !startsWithStreamBegin(vb) {
+		kv := &protobuf.KeyVersions{
+			Seqno:     proto.Uint64(0),
+			Uuids:     []uint64{0},
+			Commands:  []uint32{uint32(c.StreamBegin)},
+			Keys:      [][]byte{nil},
+			Oldkeys:   [][]byte{nil},
+			Partnkeys: [][]byte{nil},
+		}
+		vb.Kvs = append([]*protobuf.KeyVersions{kv}, vb.Kvs...)
+	}
+	for _, kv := range vb.GetKvs() {
+		if commands := kv.GetCommands(); len(commands) > 0 {
+			switch byte(commands[0]) {
+			case c.StreamBegin:
+				active[id] = true
+			case c.StreamEnd:
+				delete(active, id)
+			}
+		}
+	}
+}
+
+func startsWithStreamBegin(vb *protobuf.VbKeyVersions) bool {
+	for _, kv := range vb.GetKvs() {
+		if commands := kv.GetCommands(); len(commands) > 0 {
+			return byte(commands[0]) == c.StreamBegin
+		}
+	}
+	return false
+}
+
+// endpointSink replays records to a dataport server over TCP.
+type endpointSink struct {
+	raddr string
+	conn  net.Conn
+	buf   []byte
+}
+
+// NewEndpointSink returns a sink that sends replayed mutations to dataport
+// server listening on `raddr`. Connection errors are replayed by closing
+// the connection and opening a new one.
+func NewEndpointSink(raddr string, maxPayload int) (ReplaySink, error) {
+	conn, err := net.Dial("tcp", raddr)
+	if err != nil {
+		return nil, err
+	}
+	sink := &endpointSink{raddr: raddr, conn: conn, buf: make([]byte, maxPayload)}
+	return sink, nil
+}
+
+func (sink *endpointSink) VbKeyVersions(vbs []*protobuf.VbKeyVersions) error {
+	pl := &protobuf.Payload{
+		Version: proto.Uint32(uint32(ProtobufVersion())),
+		Vbkeys:  vbs,
+	}
+	data, err := proto.Marshal(pl)
+	if err != nil {
+		return err
+	}
+	flags := transport.TransportFlag(0).SetProtobuf()
+	return transport.Send(sink.conn, sink.buf, flags, data, true)
+}
+
+func (sink *endpointSink) ConnectionError(ce ConnectionError) error {
+	logging.Infof("replay: connection error %v, reconnecting %q\n", ce, sink.raddr)
+	sink.conn.Close()
+	conn, err := net.Dial("tcp", sink.raddr)
+	if err != nil {
+		return err
+	}
+	sink.conn = conn
+	return nil
+}
+
+func (sink *endpointSink) Close() error {
+	return sink.conn.Close()
+}
+
+// channelSink replays records to an application channel.
+type channelSink struct {
+	appch chan<- interface{}
+	finch <-chan bool
+}
+
+// NewChannelSink returns a sink that posts replayed records on `appch`,
+// using the same message types that dataport server posts to its
+// application. Replay fails with ErrorReplayClosed once `finch` is
+// closed, finch can be nil.
+func NewChannelSink(appch chan<- interface{}, finch <-chan bool) ReplaySink {
+	return &channelSink{appch: appch, finch: finch}
+}
+
+func (sink *channelSink) VbKeyVersions(vbs []*protobuf.VbKeyVersions) error {
+	return sink.post(vbs)
+}
+
+func (sink *channelSink) ConnectionError(ce ConnectionError) error {
+	return sink.post(ce)
+}
+
+func (sink *channelSink) post(msg interface{}) error {
+	select {
+	case sink.appch <- msg:
+		return nil
+	case <-sink.finch:
+		return ErrorReplayClosed
+	}
+}
+
+func (sink *channelSink) Close() error {
+	return nil
+}
@@ -49,6 +49,10 @@
 //    g. bucket delete
 //    h. bucket flush
 //    i. DCP feed error
+//
+// 4. if flow control is enabled, credits for key-versions received from a
+//    router are returned only after they are handed over to application,
+//    refer flowcontrol.go.
 
 package dataport
 
@@ -56,12 +60,14 @@ import "errors"
 import "fmt"
 import "io"
 import "net"
+import "sync/atomic"
 import "time"
 
 import c "github.com/couchbase/indexing/secondary/common"
 import protobuf "github.com/couchbase/indexing/secondary/protobuf/data"
 import "github.com/couchbase/indexing/secondary/transport"
 import "github.com/couchbase/indexing/secondary/logging"
+import "github.com/golang/protobuf/proto"
 
 // Error codes
 
@@ -120,6 +126,9 @@ type netConn struct {
 	worker chan interface{}
 	active bool
 	tpkt   *transport.TransportPacket
+	// flow control, nil if not enabled for this connection.
+	credits *connCredits
+	sbuf    []byte
 }
 
 // Server handles an active dataport server of mutation for all vbuckets.
@@ -139,7 +148,16 @@ type Server struct {
 	genChSize    int           // channel size for genServer routine
 	maxPayload   int           // maximum payload length from router
 	readDeadline time.Duration // timeout, in millisecond, reading from socket
+	enableComp   bool          // advertise that router can send compressed
+	flowControl  bool          // advertise flow control credits to router
+	creditWindow int64         // initial credits per bucket
 	logPrefix    string
+	// statistics
+	compStats *transport.CompressionStats
+	fcGrants  int64 // number of credit packets sent to routers
+	fcWait    int64 // nanoseconds, credits held back waiting on application
+	// capture mutation stream, nil if not enabled.
+	capture *captureWriter
 }
 
 // NewServer creates a new dataport daemon.
@@ -165,10 +183,25 @@ func NewServer(
 		genChSize:    genChSize,
 		maxPayload:   config["maxPayload"].Int(),
 		readDeadline: time.Duration(config["tcpReadDeadline"].Int()),
+		enableComp:   config["enableCompression"].Bool(),
+		flowControl:  config["flowControl"].Bool(),
+		creditWindow: int64(config["creditWindow"].Int()),
+		compStats:    transport.NewCompressionStats(),
 	}
 	s.logPrefix = fmt.Sprintf("DATP[->dataport %q]", laddr)
+	if dir := config["captureDir"].String(); dir != "" {
+		s.capture, err = newCaptureWriter(
+			dir, CapturePrefix(laddr),
+			int64(config["captureFileSize"].Int()),
+			config["captureMaxFiles"].Int(), s.logPrefix)
+		if err != nil {
+			logging.Errorf("%v failed starting capture! %v\n", s.logPrefix, err)
+			return nil, err
+		}
+	}
 	if s.lis, err = net.Listen("tcp", laddr); err != nil {
 		logging.Errorf("%v failed starting! %v\n", s.logPrefix, err)
+		s.closeCapture()
 		return nil, err
 	}
 	go listener(s.logPrefix, s.lis, s.reqch) // spawn daemon
@@ -202,6 +235,20 @@ func (s *Server) delUuids(finished, hostUuids keeper) keeper {
 	return hostUuids
 }
 
+// CompressionStats return compression statistics for payloads received
+// from all routers.
+func (s *Server) CompressionStats() map[string]interface{} {
+	return s.compStats.Map()
+}
+
+// FlowControlStats return flow control statistics for all routers.
+func (s *Server) FlowControlStats() map[string]interface{} {
+	return map[string]interface{}{
+		"grants":   atomic.LoadInt64(&s.fcGrants),
+		"waitTime": atomic.LoadInt64(&s.fcWait),
+	}
+}
+
 // Close the daemon listening for new connections and shuts down all read
 // routines for this dataport server. synchronous call.
 func (s *Server) Close() (err error) {
@@ -302,11 +349,24 @@ func (s *Server) genServer(reqch, datach chan []interface{}) {
 				worker := make(chan interface{}, s.maxVbuckets)
 				s.conns[raddr] = &netConn{
 					conn: conn, worker: worker,
-					tpkt: newTransportPkt(s.maxPayload),
+					tpkt: newTransportPkt(s.maxPayload, s.compStats),
 				}
 				n := len(s.conns)
 				fmsg := "%v new connection %q +%d\n"
 				logging.Infof(fmsg, s.logPrefix, raddr, n)
+				if s.enableComp {
+					s.acceptCompression(raddr, conn)
+				}
+				if s.flowControl && s.creditWindow > 0 {
+					nc := s.conns[raddr]
+					nc.credits = newConnCredits(s.creditWindow)
+					nc.sbuf = make([]byte, transport.MaxSendBufSize)
+					window := &protobuf.BucketCredits{
+						Bucket:  proto.String(""),
+						Credits: proto.Uint64(uint64(s.creditWindow)),
+					}
+					s.sendCredits(raddr, nc, []*protobuf.BucketCredits{window})
+				}
 				s.startWorker(raddr)
 			}
 
@@ -321,6 +381,10 @@ func (s *Server) genServer(reqch, datach chan []interface{}) {
 	}
 
 	nicetoapp := func(msg interface{}) {
+		since := time.Now()
+		defer func() {
+			atomic.AddInt64(&s.fcWait, int64(time.Since(since)))
+		}()
 		for {
 			select {
 			case <-s.finch:
@@ -352,11 +416,40 @@ loop:
 				s.startWorker(msg.raddr)
 
 			case serverCmdVbKeyVersions:
-				nicetoapp(parseVbs(msg))
+				var counts map[string]int64
+				nc, ok := s.conns[msg.raddr]
+				if ok && nc.credits != nil {
+					counts = countKeyVersions(msg.args[0].([]*protobuf.VbKeyVersions))
+					if !nc.credits.received(counts) {
+						fmsg := "%v %q does not honor flow control, disabled\n"
+						logging.Warnf(fmsg, s.logPrefix, msg.raddr)
+						nc.credits, counts = nil, nil
+					}
+				}
+				vbs := parseVbs(msg)
+				if s.capture != nil && len(vbs) > 0 {
+					if err := s.capture.writeVbKeyVersions(vbs); err != nil {
+						logging.Errorf("%v capture: %v\n", s.logPrefix, err)
+						s.closeCapture()
+					}
+				}
+				nicetoapp(vbs)
+				// return credits only after application has taken them.
+				if nc, ok := s.conns[msg.raddr]; ok && nc.credits != nil {
+					if credits := nc.credits.consumed(counts); len(credits) > 0 {
+						s.sendCredits(msg.raddr, nc, credits)
+					}
+				}
 
 			case serverCmdError:
 				var g interface{}
 				hostUuids, g = s.jumboErrorHandler(msg.raddr, hostUuids, msg.err)
+				if ce, ok := g.(ConnectionError); ok && s.capture != nil {
+					if err := s.capture.writeConnectionError(ce); err != nil {
+						logging.Errorf("%v capture: %v\n", s.logPrefix, err)
+						s.closeCapture()
+					}
+				}
 				if g != nil {
 					nicetoapp(g)
 					logging.Tracef("%v appmsg: %T:%+v\n", s.logPrefix, g, g)
@@ -384,12 +477,58 @@ func (s *Server) handleClose() {
 		closeConnection(s.logPrefix, raddr, nc)
 	}
 	s.lis, s.conns = nil, nil
+	s.closeCapture()
 	close(s.finch)
 
 	logging.Infof("%v ... stopped\n", s.logPrefix)
 	return
 }
 
+// stop capturing mutation stream.
+func (s *Server) closeCapture() {
+	if s.capture != nil {
+		if err := s.capture.close(); err != nil {
+			logging.Errorf("%v capture close: %v\n", s.logPrefix, err)
+		}
+		s.capture = nil
+	}
+}
+
+// advertise to router that compressed payloads can be received.
+func (s *Server) acceptCompression(raddr string, conn net.Conn) {
+	buf := make([]byte, transport.MaxSendBufSize)
+	flags := transport.TransportFlag(0).SetAcceptCompression()
+	if err := transport.Send(conn, buf, flags, nil, false); err != nil {
+		fmsg := "%v unable to accept compression from %q: %v\n"
+		logging.Errorf(fmsg, s.logPrefix, raddr, err)
+	}
+}
+
+// advertise flow control credits to router, router is expected to read
+// them right away, so writes are not expected to block.
+func (s *Server) sendCredits(
+	raddr string, nc *netConn, credits []*protobuf.BucketCredits) {
+
+	pl := &protobuf.Payload{
+		Version: proto.Uint32(uint32(ProtobufVersion())),
+		Credits: credits,
+	}
+	data, err := proto.Marshal(pl)
+	if err == nil {
+		flags := transport.TransportFlag(0).SetProtobuf()
+		timeout := s.readDeadline * time.Millisecond
+		nc.conn.SetWriteDeadline(time.Now().Add(timeout))
+		err = transport.Send(nc.conn, nc.sbuf, flags, data, false)
+	}
+	if err != nil {
+		fmsg := "%v unable to send credits to %q: %v, flow control disabled\n"
+		logging.Errorf(fmsg, s.logPrefix, raddr, err)
+		nc.credits = nil
+		return
+	}
+	atomic.AddInt64(&s.fcGrants, 1)
+}
+
 // start a connection worker to read mutation message for a subset of vbuckets.
 func (s *Server) startWorker(raddr string) {
 	nc, ok := s.conns[raddr]
@@ -480,6 +619,8 @@ func (s *Server) logStats(hostUuids keeper) {
 		fmsg = "%v bucket latest sequence numbers: %v\n"
 		logging.Infof(fmsg, s.logPrefix, seqnos)
 	}
+	logging.Infof("%v compression stats: %v\n", s.logPrefix, s.CompressionStats())
+	logging.Infof("%v flow control stats: %v\n", s.logPrefix, s.FlowControlStats())
 }
 
 func closeConnection(prefix, raddr string, nc *netConn) {
@@ -650,10 +791,14 @@ func (ce ConnectionError) Append(hostUuids keeper, raddr string) keeper {
 	return finished
 }
 
-func newTransportPkt(maxPayload int) *transport.TransportPacket {
+func newTransportPkt(
+	maxPayload int,
+	stats *transport.CompressionStats) *transport.TransportPacket {
+
 	flags := transport.TransportFlag(0).SetProtobuf()
 	pkt := transport.NewTransportPacket(maxPayload, flags)
 	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
 	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	pkt.SetCompressionStats(stats)
 	return pkt
 }
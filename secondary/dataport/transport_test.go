@@ -61,6 +61,75 @@ func TestPktVbmap(t *testing.T) {
 	}
 }
 
+func TestPktKeyVersionsCompressed(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	tc := newTestConnection()
+	tc.reset()
+	flags := transport.TransportFlag(0).SetProtobuf()
+	stats := transport.NewCompressionStats()
+	pkt := transport.NewTransportPacket(1000*1024, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	pkt.SetCompression(transport.CompressionGzip, 128)
+	pkt.SetCompressionStats(stats)
+
+	if err := pkt.Send(tc, vbsRef); err != nil { // Send reference
+		t.Fatal(err)
+	}
+	if payload, err := pkt.Receive(tc); err != nil { // Receive reference
+		t.Fatal(err)
+	} else { // compare both
+		vbs := protobuf2VbKeyVersions(payload.([]*protobuf.VbKeyVersions))
+		if len(vbsRef) != len(vbs) {
+			t.Fatal("Mismatch in length")
+		}
+		for i, vb := range vbs {
+			if vb.Equal(vbsRef[i]) == false {
+				t.Fatal("Mismatch in VbKeyVersions")
+			}
+		}
+	}
+	m := stats.Map()
+	if m["compressCount"].(int64) != 1 || m["decompressCount"].(int64) != 1 {
+		t.Fatalf("unexpected compression stats %v", m)
+	} else if stats.Ratio() <= 1 {
+		t.Fatalf("expected compression ratio > 1, got %v", stats.Ratio())
+	}
+
+	// payloads below threshold are sent uncompressed.
+	tc.reset()
+	pkt.SetCompression(transport.CompressionGzip, 1000*1024)
+	if err := pkt.Send(tc, vbsRef); err != nil {
+		t.Fatal(err)
+	} else if _, err := pkt.Receive(tc); err != nil {
+		t.Fatal(err)
+	} else if m := stats.Map(); m["compressSkipped"].(int64) != 1 {
+		t.Fatalf("unexpected compression stats %v", m)
+	}
+}
+
+func TestPktDecompressOverflow(t *testing.T) {
+	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
+	vbsRef := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
+	tc := newTestConnection()
+	tc.reset()
+	flags := transport.TransportFlag(0).SetProtobuf()
+	pkt := transport.NewTransportPacket(1000*1024, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobufEncode)
+	pkt.SetCompression(transport.CompressionGzip, 0)
+	if err := pkt.Send(tc, vbsRef); err != nil {
+		t.Fatal(err)
+	}
+
+	// compressed packet fits the receiver's buffer, its payload does not.
+	rpkt := transport.NewTransportPacket(tc.woff, flags)
+	rpkt.SetDecoder(transport.EncodingProtobuf, protobufDecode)
+	if _, err := rpkt.Receive(tc); err != transport.ErrorPacketOverflow {
+		t.Fatalf("expected %v, got %v", transport.ErrorPacketOverflow, err)
+	}
+}
+
 func BenchmarkSendVbKeyVersions(b *testing.B) {
 	seqno, nVbs, nMuts, nIndexes := 1, 20, 5, 5
 	vbs := constructVbKeyVersions("default", seqno, nVbs, nMuts, nIndexes)
@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package gsitest
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+
+	"github.com/couchbase/indexing/secondary/common"
+	couchbase "github.com/couchbase/indexing/secondary/dcp"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// The cluster info endpoints of ns_server a GsiClient reads to discover
+// indexer nodes and their services, for a cluster made of this node only.
+
+const (
+	serverGroup = "Group 1"
+	// 6.5 cluster, indexes are scanned with collatejson encoded keys.
+	clusterCompatibility = 6*65536 + 5
+)
+
+type restPool struct {
+	Nodes           []couchbase.Node  `json:"nodes"`
+	BucketURL       map[string]string `json:"buckets"`
+	ServerGroupsUri string            `json:"serverGroupsUri"`
+}
+
+func (s *Server) clusterHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pools", s.handlePools)
+	mux.HandleFunc("/pools/default", s.handlePool)
+	mux.HandleFunc("/pools/default/buckets", s.handleBuckets)
+	mux.HandleFunc("/pools/default/nodeServices", s.handleNodeServices)
+	mux.HandleFunc("/pools/default/serverGroups", s.handleServerGroups)
+	mux.HandleFunc("/poolsStreaming/default", s.handlePoolStreaming)
+	mux.HandleFunc("/pools/default/nodeServicesStreaming", s.handleNodeServicesStreaming)
+	return mux
+}
+
+func (s *Server) handlePools(w http.ResponseWriter, r *http.Request) {
+	pools := &couchbase.Pools{
+		ImplementationVersion: "6.5.0-0000-enterprise",
+		IsAdmin:               true,
+		UUID:                  s.nodeUUID,
+		Pools: []couchbase.RestPool{{
+			Name:         "default",
+			URI:          "/pools/default",
+			StreamingURI: "/poolsStreaming/default",
+		}},
+	}
+	writeJSON(w, pools)
+}
+
+func (s *Server) handlePool(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.pool())
+}
+
+// No bucket is reported, documents of any bucket name are accepted.
+func (s *Server) handleBuckets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, []couchbase.Bucket{})
+}
+
+func (s *Server) handleNodeServices(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, s.poolServices())
+}
+
+func (s *Server) handleServerGroups(w http.ResponseWriter, r *http.Request) {
+	groups := &couchbase.ServerGroups{
+		Groups: []couchbase.ServerGroup{{Name: serverGroup, Nodes: []couchbase.Node{s.node()}}},
+	}
+	writeJSON(w, groups)
+}
+
+// Streaming endpoints send the current state once, the cluster does not
+// change afterwards.  Connection is kept open until the server is closed.
+func (s *Server) handlePoolStreaming(w http.ResponseWriter, r *http.Request) {
+	s.stream(w, r, s.pool())
+}
+
+func (s *Server) handleNodeServicesStreaming(w http.ResponseWriter, r *http.Request) {
+	s.stream(w, r, s.poolServices())
+}
+
+func (s *Server) stream(w http.ResponseWriter, r *http.Request, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(append(data, '\n'))
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	select {
+	case <-s.donech:
+	case <-r.Context().Done():
+	}
+}
+
+func (s *Server) pool() *restPool {
+	return &restPool{
+		Nodes:           []couchbase.Node{s.node()},
+		BucketURL:       map[string]string{"uri": "/pools/default/buckets"},
+		ServerGroupsUri: "/pools/default/serverGroups",
+	}
+}
+
+func (s *Server) node() couchbase.Node {
+	return couchbase.Node{
+		ClusterCompatibility: clusterCompatibility,
+		ClusterMembership:    "active",
+		Hostname:             s.mgmtAddr,
+		Status:               "healthy",
+		Uptime:               1,
+		Version:              "6.5.0-0000-enterprise",
+		ThisNode:             true,
+		Services:             []string{"index"},
+		Ports:                map[string]int{},
+	}
+}
+
+func (s *Server) poolServices() *couchbase.PoolServices {
+	host, _, _ := net.SplitHostPort(s.mgmtAddr)
+	return &couchbase.PoolServices{
+		Rev: 1,
+		NodesExt: []couchbase.NodeServices{{
+			Services: map[string]int{
+				"mgmt":                     addrPort(s.mgmtAddr),
+				common.INDEX_ADMIN_SERVICE: addrPort(s.adminAddr),
+				common.INDEX_SCAN_SERVICE:  addrPort(s.scanAddr),
+				common.INDEX_HTTP_SERVICE:  addrPort(s.httpAddr),
+			},
+			Hostname: host,
+			ThisNode: true,
+		}},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		logging.Errorf("gsitest: Error marshalling response: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
@@ -0,0 +1,114 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package gsitest
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/couchbase/cbauth"
+	gometaC "github.com/couchbase/gometa/common"
+	"github.com/couchbase/gometa/message"
+	"github.com/couchbase/gometa/protocol"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager/client"
+)
+
+// requestHandler answers the requests that clients watching the metadata
+// repository make to the indexer.  Only the service map is served, index
+// DDL is done through the Server.
+type requestHandler struct {
+	server    *Server
+	outgoings chan gometaC.Packet
+	factory   *message.ConcreteMsgFactory
+}
+
+func newRequestHandler(s *Server) *requestHandler {
+	return &requestHandler{
+		server:    s,
+		outgoings: make(chan gometaC.Packet, 1000),
+		factory:   message.NewConcreteMsgFactory(),
+	}
+}
+
+func (h *requestHandler) OnNewRequest(fid string, request protocol.RequestMsg) {
+	go h.dispatchRequest(fid, request)
+}
+
+func (h *requestHandler) GetResponseChannel() <-chan gometaC.Packet {
+	return (<-chan gometaC.Packet)(h.outgoings)
+}
+
+func (h *requestHandler) dispatchRequest(fid string, request protocol.RequestMsg) {
+	var err error
+	var result []byte
+
+	op := gometaC.OpCode(request.GetOpCode())
+	switch op {
+	case client.OPCODE_SERVICE_MAP:
+		result, err = client.MarshallServiceMap(h.server.serviceMap())
+	default:
+		err = fmt.Errorf("Request %v is not supported by gsitest server", op)
+		logging.Warnf("gsitest: %v", err)
+	}
+
+	if fid == "internal" {
+		return
+	}
+
+	errStr := ""
+	if err != nil {
+		errStr = err.Error()
+	}
+	msg := h.factory.CreateResponse(fid, request.GetReqId(), errStr, result)
+	select {
+	case h.outgoings <- msg:
+	case <-h.server.donech:
+	}
+}
+
+func (s *Server) serviceMap() *client.ServiceMap {
+	return &client.ServiceMap{
+		IndexerId:      s.indexerId,
+		ScanAddr:       s.scanAddr,
+		HttpAddr:       s.httpAddr,
+		AdminAddr:      s.adminAddr,
+		NodeAddr:       s.mgmtAddr,
+		ServerGroup:    serverGroup,
+		NodeUUID:       s.nodeUUID,
+		IndexerVersion: common.INDEXER_CUR_VERSION,
+		ClusterVersion: common.INDEXER_65_VERSION,
+		StorageMode:    uint64(common.MOI),
+	}
+}
+
+// testAuthenticator gives the credentials of the server's cluster info
+// endpoints, which accept any.  Other methods of the authenticator are not
+// used by the client and panic if called.
+type testAuthenticator struct {
+	cbauth.Authenticator
+}
+
+func (a *testAuthenticator) GetHTTPServiceAuth(hostport string) (string, string, error) {
+	return "Administrator", "password", nil
+}
+
+var authOnce sync.Once
+
+// installAuthenticator makes cbauth usable in processes that do not run
+// under ns_server, an authenticator already set up is left as is.
+func installAuthenticator() {
+	authOnce.Do(func() {
+		if cbauth.Default == nil {
+			cbauth.Default = &testAuthenticator{}
+		}
+	})
+}
@@ -0,0 +1,356 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package gsitest runs an in-process indexer for tests of GSI client
+// consumers.  The server speaks the real queryport protocol, backed by
+// memdb, and serves the metadata and cluster info endpoints a GsiClient
+// needs, so that the client connects to it unmodified:
+//
+//	s, err := gsitest.NewServer()
+//	...
+//	defer s.Close()
+//	defnID, err := s.CreateIndex("default", "idx_age", []string{"`age`"}, "")
+//	err = s.Upsert("default", "doc1", map[string]interface{}{"age": 30})
+//	client, err := qclient.NewGsiClient(s.ClusterAddr(), config)
+//
+// Index keys are computed with the N1QL evaluator, as projector does.
+// Index DDL is done through the server, not through the client.
+// Partitioned indexes and SessionConsistency, which needs KV, are not
+// supported, and meta() of a document only has its id.
+package gsitest
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	gometaC "github.com/couchbase/gometa/common"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/common/queryutil"
+	"github.com/couchbase/indexing/secondary/indexer"
+	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/manager"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+)
+
+// metadata repository buffer cache, as used by memory optimized indexers.
+const repoQuota = 1 * 1024 * 1024
+
+// number of times the server is started on other ports, if a port is taken.
+const maxBindRetries = 5
+
+// Server is an in-process indexer node of a single node cluster.
+type Server struct {
+	indexerId string
+	nodeUUID  string
+
+	mgmtAddr  string
+	adminAddr string
+	scanAddr  string
+	httpAddr  string
+
+	storageDir string
+	listeners  []net.Listener
+	donech     chan bool
+
+	repo       *manager.MetadataRepo
+	reqHandler *requestHandler
+	indexer    *indexer.EmbeddedIndexer
+
+	// serializes index DDL
+	mu      sync.Mutex
+	indexes map[common.IndexDefnId]*common.IndexDefn
+	closed  bool
+}
+
+// NewServer starts a server on free ports of the loopback interface, with
+// its storage in a new temporary directory.
+func NewServer() (s *Server, err error) {
+	// Ports of gometa and queryport are free when picked, but can be taken
+	// by another process before they bind, the server is started again on
+	// other ports.
+	for i := 0; i < maxBindRetries; i++ {
+		if s, err = newServer(); err == nil || !isAddrInUse(err) {
+			return s, err
+		}
+		logging.Warnf("gsitest: Port taken while starting server, retrying: %v", err)
+	}
+	return nil, err
+}
+
+func newServer() (_ *Server, err error) {
+	s := &Server{
+		donech:  make(chan bool),
+		indexes: make(map[common.IndexDefnId]*common.IndexDefn),
+	}
+	defer func() {
+		if err != nil {
+			s.Close()
+		}
+	}()
+
+	if s.storageDir, err = ioutil.TempDir("", "gsitest"); err != nil {
+		return nil, err
+	}
+
+	for _, id := range []*string{&s.indexerId, &s.nodeUUID} {
+		uuid, err := common.NewUUID()
+		if err != nil {
+			return nil, err
+		}
+		*id = uuid.Str()
+	}
+
+	// gometa and queryport listen on their own, free ports are picked
+	// for them beforehand.
+	if s.adminAddr, err = freeAddr(); err != nil {
+		return nil, err
+	}
+	if s.scanAddr, err = freeAddr(); err != nil {
+		return nil, err
+	}
+
+	mgmt, err := s.listen()
+	if err != nil {
+		return nil, err
+	}
+	s.mgmtAddr = mgmt.Addr().String()
+	httpl, err := s.listen()
+	if err != nil {
+		return nil, err
+	}
+	s.httpAddr = httpl.Addr().String()
+	go http.Serve(mgmt, s.clusterHandler())
+
+	installAuthenticator()
+
+	s.reqHandler = newRequestHandler(s)
+	repoName := filepath.Join(s.storageDir, gometaC.REPOSITORY_NAME)
+	s.repo, _, err = manager.NewLocalMetadataRepo(s.adminAddr, nil, s.reqHandler, repoName, repoQuota)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.repo.SetLocalValue("IndexerId", s.indexerId); err != nil {
+		return nil, err
+	}
+	if err = s.repo.SetLocalValue("IndexerNodeUUID", s.nodeUUID); err != nil {
+		return nil, err
+	}
+
+	config := common.SystemConfig.SectionConfig("indexer.", true)
+	_, scanPort, _ := net.SplitHostPort(s.scanAddr)
+	config.SetValue("scanPort", scanPort)
+	config.SetValue("storage_dir", filepath.Join(s.storageDir, "data"))
+	config.SetValue("diagnostics_dir", s.storageDir)
+	config.SetValue("clusterAddr", s.mgmtAddr)
+	if s.indexer, err = indexer.NewEmbeddedIndexer(config); err != nil {
+		return nil, err
+	}
+
+	go http.Serve(httpl, s.indexer.HTTPHandler())
+
+	logging.Infof("gsitest: Server started, cluster %v admin %v scan %v http %v",
+		s.mgmtAddr, s.adminAddr, s.scanAddr, s.httpAddr)
+	return s, nil
+}
+
+// ClusterAddr returns the address a GsiClient connects to.
+func (s *Server) ClusterAddr() string {
+	return s.mgmtAddr
+}
+
+// CreateIndex creates and builds a secondary index on the N1QL expressions
+// secExprs of the bucket's documents, with an optional where clause.  The
+// index is active once CreateIndex returns.
+func (s *Server) CreateIndex(bucket, name string, secExprs []string,
+	where string) (uint64, error) {
+
+	defn := &common.IndexDefn{
+		Bucket:    bucket,
+		Name:      name,
+		SecExprs:  secExprs,
+		WhereExpr: where,
+	}
+	return s.createIndex(defn)
+}
+
+// CreatePrimaryIndex creates and builds a primary index on the bucket.
+func (s *Server) CreatePrimaryIndex(bucket, name string) (uint64, error) {
+	defn := &common.IndexDefn{
+		Bucket:    bucket,
+		Name:      name,
+		IsPrimary: true,
+	}
+	return s.createIndex(defn)
+}
+
+// DropIndex drops the index of definition id defnID.
+func (s *Server) DropIndex(defnID uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	defn, ok := s.indexes[common.IndexDefnId(defnID)]
+	if !ok {
+		return common.ErrIndexNotFound
+	}
+	delete(s.indexes, defn.DefnId)
+
+	if err := s.repo.RemoveIndexFromTopology(defn); err != nil {
+		return err
+	}
+	if err := s.repo.DropIndexById(defn.DefnId); err != nil {
+		return err
+	}
+	return s.indexer.DropIndex(defn.InstId)
+}
+
+// Upsert writes the document to the bucket.  doc is either the JSON body
+// of the document, as []byte, or a value marshalled to JSON.
+func (s *Server) Upsert(bucket, docid string, doc interface{}) error {
+	body, ok := doc.([]byte)
+	if !ok {
+		var err error
+		if body, err = json.Marshal(doc); err != nil {
+			return err
+		}
+	}
+	return s.indexer.Upsert(bucket, map[string][]byte{docid: body})
+}
+
+// Delete removes the documents from the bucket.
+func (s *Server) Delete(bucket string, docids ...string) error {
+	return s.indexer.Delete(bucket, docids)
+}
+
+// Vector returns the consistency vector of the latest write to the bucket,
+// for scans with QueryConsistency.
+func (s *Server) Vector(bucket string) *qclient.TsConsistency {
+	ts := s.indexer.Timestamp(bucket)
+
+	vector := qclient.NewTsConsistency(nil, nil, nil)
+	for vb, seqno := range ts.Seqnos {
+		if seqno > 0 {
+			vector.Override(uint16(vb), seqno, ts.Vbuuids[vb])
+		}
+	}
+	return vector
+}
+
+// Close stops the server and removes its storage.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	s.closed = true
+
+	close(s.donech)
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	if s.indexer != nil {
+		s.indexer.Close()
+	}
+	if s.repo != nil {
+		s.repo.Close()
+	}
+	if s.storageDir != "" {
+		os.RemoveAll(s.storageDir)
+	}
+}
+
+func (s *Server) createIndex(defn *common.IndexDefn) (uint64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return 0, indexer.ErrEmbeddedIndexerClosed
+	}
+	for _, index := range s.indexes {
+		if index.Bucket == defn.Bucket && index.Name == defn.Name {
+			return 0, fmt.Errorf("Index %v already exists on bucket %v", defn.Name, defn.Bucket)
+		}
+	}
+
+	var err error
+	if defn.DefnId, err = common.NewIndexDefnId(); err != nil {
+		return 0, err
+	}
+	if defn.InstId, err = common.NewIndexInstId(); err != nil {
+		return 0, err
+	}
+	defn.Using = common.MemDB
+	defn.ExprType = common.N1QL
+	defn.PartitionScheme = common.SINGLE
+	if !defn.IsPrimary {
+		defn.IsArrayIndex, _, _, err = queryutil.GetArrayExpressionPosition(defn.SecExprs)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Slice is built before the index shows up in the topology, so that
+	// clients only see the index once it can be scanned.
+	if err := s.indexer.CreateIndex(*defn, defn.InstId); err != nil {
+		return 0, err
+	}
+	if err := s.repo.CreateIndex(defn); err != nil {
+		s.indexer.DropIndex(defn.InstId)
+		return 0, err
+	}
+	err = s.repo.AddIndexToTopology(defn, defn.InstId, common.INDEX_STATE_ACTIVE)
+	if err != nil {
+		s.repo.DropIndexById(defn.DefnId)
+		s.indexer.DropIndex(defn.InstId)
+		return 0, err
+	}
+
+	s.indexes[defn.DefnId] = defn
+	return uint64(defn.DefnId), nil
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s.listeners = append(s.listeners, l)
+	return l, nil
+}
+
+// freeAddr returns an address of the loopback interface with a free port.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// isAddrInUse tells whether a listener failed to bind.  Errors of gometa
+// and queryport are not always of type net.OpError, the message is checked.
+func isAddrInUse(err error) bool {
+	return strings.Contains(err.Error(), "address already in use")
+}
+
+func addrPort(addr string) int {
+	_, port, _ := net.SplitHostPort(addr)
+	p, _ := strconv.Atoi(port)
+	return p
+}
@@ -0,0 +1,196 @@
+package gsitest
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+)
+
+func newTestClient(t *testing.T, s *Server) *qclient.GsiClient {
+
+	config := common.SystemConfig.SectionConfig("queryport.client.", true)
+	client, err := qclient.NewGsiClient(s.ClusterAddr(), config)
+	if err != nil {
+		t.Fatalf("client: %v", err)
+	}
+	return client
+}
+
+// wait for the client to see the index created, or dropped.
+func waitTestIndex(t *testing.T, client *qclient.GsiClient, defnID uint64, exists bool) {
+
+	for i := 0; i < 500; i++ {
+		indexes, _, _, err := client.Refresh()
+		if err == nil {
+			found := false
+			for _, index := range indexes {
+				if uint64(index.Definition.DefnId) == defnID {
+					found = true
+				}
+			}
+			if found == exists {
+				return
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("index %v: expected exists %v", defnID, exists)
+}
+
+// scan the range, return json encoded keys by docid.
+func scanTestIndex(client *qclient.GsiClient, defnID uint64, low, high common.SecondaryKey,
+	cons common.Consistency, vector *qclient.TsConsistency) (map[string]string, error) {
+
+	buf, poolIdx := qclient.GetFromPools()
+	defer qclient.PutInPools(buf, poolIdx)
+
+	var scanErr error
+	results := make(map[string]string)
+	err := client.Range(defnID, "", low, high, qclient.Both, false, math.MaxInt64, cons, vector,
+		func(resp qclient.ResponseReader) bool {
+			if scanErr = resp.Error(); scanErr != nil {
+				return false
+			}
+			skeys, pkeys, err := resp.GetEntries(client.GetDataEncodingFormat())
+			if err != nil {
+				scanErr = err
+				return false
+			}
+			keys, err, retBuf := skeys.Get(buf)
+			if err != nil {
+				scanErr = err
+				return false
+			}
+			if retBuf != nil {
+				buf = retBuf
+			}
+			// primary index returns no key
+			for i, pkey := range pkeys {
+				results[string(pkey)] = ""
+				if i < len(keys) {
+					data, err := json.Marshal(keys[i])
+					if err != nil {
+						scanErr = err
+						return false
+					}
+					results[string(pkey)] = string(data)
+				}
+			}
+			return true
+		})
+	if err != nil {
+		return nil, err
+	}
+	return results, scanErr
+}
+
+func TestServerScan(t *testing.T) {
+
+	s, err := NewServer()
+	if err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	defer s.Close()
+
+	defnID, err := s.CreateIndex("default", "idx_age", []string{"`age`"}, "`age` > 20")
+	if err != nil {
+		t.Fatalf("create index: %v", err)
+	}
+	primaryID, err := s.CreatePrimaryIndex("default", "idx_primary")
+	if err != nil {
+		t.Fatalf("create primary index: %v", err)
+	}
+	if _, err := s.CreateIndex("default", "idx_age", []string{"`name`"}, ""); err == nil {
+		t.Errorf("create index: expected error for duplicate name")
+	}
+
+	for docid, age := range map[string]int{"doc1": 30, "doc2": 10, "doc3": 40} {
+		if err := s.Upsert("default", docid, map[string]interface{}{"age": age}); err != nil {
+			t.Fatalf("upsert %v: %v", docid, err)
+		}
+	}
+
+	client := newTestClient(t, s)
+	defer client.Close()
+	waitTestIndex(t, client, defnID, true)
+	waitTestIndex(t, client, primaryID, true)
+
+	testcases := []struct {
+		comment   string
+		defnID    uint64
+		low, high common.SecondaryKey
+		update    func()
+		expected  map[string]string // keys by docid, docids only for primary
+	}{
+		{"scan", defnID, nil, nil, nil,
+			map[string]string{"doc1": `[30]`, "doc3": `[40]`}},
+		{"range", defnID, common.SecondaryKey{35}, common.SecondaryKey{60}, nil,
+			map[string]string{"doc3": `[40]`}},
+		{"upsert and delete", defnID, nil, nil,
+			func() {
+				s.Upsert("default", "doc1", []byte(`{"age": 50}`))
+				s.Upsert("default", "doc2", []byte(`{"age": 25}`))
+				s.Delete("default", "doc3")
+			},
+			map[string]string{"doc1": `[50]`, "doc2": `[25]`}},
+		{"primary", primaryID, nil, nil, nil,
+			map[string]string{"doc1": "", "doc2": ""}},
+	}
+
+	for _, tc := range testcases {
+		if tc.update != nil {
+			tc.update()
+		}
+
+		for _, cons := range []common.Consistency{common.AnyConsistency, common.QueryConsistency} {
+			var vector *qclient.TsConsistency
+			if cons == common.QueryConsistency {
+				vector = s.Vector("default")
+			}
+
+			results, err := scanTestIndex(client, tc.defnID, tc.low, tc.high, cons, vector)
+			if tc.defnID == primaryID {
+				for docid := range results {
+					results[docid] = ""
+				}
+			}
+			if err != nil {
+				t.Errorf("%v: consistency %v: unexpected error %v", tc.comment, cons, err)
+			} else if !reflect.DeepEqual(results, tc.expected) {
+				t.Errorf("%v: consistency %v: expected %v, got %v", tc.comment, cons, tc.expected, results)
+			}
+		}
+	}
+
+	// dropped index is not scanned
+	if err := s.DropIndex(defnID); err != nil {
+		t.Fatalf("drop index: %v", err)
+	}
+	if err := s.DropIndex(defnID); err != common.ErrIndexNotFound {
+		t.Errorf("drop index again: expected %v, got %v", common.ErrIndexNotFound, err)
+	}
+	waitTestIndex(t, client, defnID, false)
+	if _, err := scanTestIndex(client, defnID, nil, nil, common.AnyConsistency, nil); err == nil {
+		t.Errorf("dropped: expected scan error")
+	}
+
+	// other index is still scanned
+	results, err := scanTestIndex(client, primaryID, nil, nil, common.AnyConsistency, nil)
+	if err != nil || len(results) != 2 {
+		t.Errorf("primary after drop: expected 2 documents, got %v %v", results, err)
+	}
+
+	// closed server is not scanned, nor written to
+	s.Close()
+	if _, err := scanTestIndex(client, primaryID, nil, nil, common.AnyConsistency, nil); err == nil {
+		t.Errorf("closed: expected scan error")
+	}
+	if _, err := s.CreateIndex("default", "idx_name", []string{"`name`"}, ""); err == nil {
+		t.Errorf("closed: expected create index error")
+	}
+}
@@ -5,9 +5,11 @@ import "strings"
 import re "regexp"
 import "path/filepath"
 import "fmt"
+import "sync"
 
 import log "github.com/couchbase/indexing/secondary/logging"
 import c "github.com/couchbase/indexing/secondary/common"
+import qclient "github.com/couchbase/indexing/secondary/queryport/client"
 import "github.com/couchbase/cbauth"
 
 type target struct {
@@ -20,7 +22,16 @@ type target struct {
 }
 
 type restServer struct {
+	cluster  string
 	statsMgr *statsManager
+
+	// query client for the scan API, created on first use
+	mutex  sync.Mutex
+	client *qclient.GsiClient
+
+	// index name to definition id, built from the metadata of the client
+	indexNames   map[string]uint64
+	indexVersion uint64
 }
 
 type request struct {
@@ -42,11 +53,12 @@ func initHandlers(api *restServer) {
 	versionRx = re.MustCompile("v\\d+")
 	staticRoutes = make(map[string]reqHandler)
 	staticRoutes["stats"] = api.statsHandler
+	staticRoutes["index"] = api.indexHandler
 }
 
 func NewRestServer(cluster string, stMgr *statsManager) (*restServer, Message) {
 	log.Infof("%v starting RESTful services", cluster)
-	restapi := &restServer{statsMgr: stMgr}
+	restapi := &restServer{cluster: cluster, statsMgr: stMgr}
 	initHandlers(restapi)
 	mux := GetHTTPMux()
 	mux.HandleFunc("/api/", restapi.routeRequest)
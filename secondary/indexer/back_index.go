@@ -0,0 +1,355 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package indexer
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+)
+
+var (
+	ErrBackIndexPrimary     = errors.New("Primary index does not have a back index")
+	ErrBackIndexUnsupported = errors.New("Back index lookup is not supported by the storage")
+	ErrBackIndexTimeout     = errors.New("Timeout in back index lookup")
+)
+
+const (
+	// maximum time to wait for the slice writers to serve a lookup
+	backIndexLookupTimeout = 10 * time.Second
+	// maximum size of a lookup request body
+	backIndexMaxRequestSize = 1024 * 1024
+)
+
+//
+// BackIndexReader is implemented by slices that can lookup the keys of a
+// document in the back index.  Keys are returned in collatejson format,
+// in ascending collation, one per index entry of the document.  The back
+// index is only updated by the slice writers, so the lookup is queued to
+// the writers and is served in order with the mutations.
+//
+type BackIndexReader interface {
+	LookupBackIndex(docid []byte) ([][]byte, error)
+}
+
+// backIndexLookup is a lookup command queued to a slice writer.
+type backIndexLookup struct {
+	docid  []byte
+	respch chan *backIndexResult
+}
+
+type backIndexResult struct {
+	keys [][]byte
+	err  error
+}
+
+//
+// waitBackIndexLookup collects the result of a lookup queued to `n`
+// writers.  `respch` must have room for `n` results, so that a writer
+// never blocks after the lookup has timed out.
+//
+func waitBackIndexLookup(respch chan *backIndexResult, n int,
+	timeout <-chan time.Time) ([][]byte, error) {
+
+	var keys [][]byte
+	for i := 0; i < n; i++ {
+		select {
+		case res := <-respch:
+			if res.err != nil {
+				return nil, res.err
+			}
+			keys = append(keys, res.keys...)
+
+		case <-timeout:
+			return nil, ErrBackIndexTimeout
+		}
+	}
+	return keys, nil
+}
+
+//
+// backIndexKeys convert a key read from the back index to the keys of the
+// index entries in ascending collation.  The key is copied, as storage
+// owns the buffer.  Key of an array index is exploded into its entries.
+//
+func backIndexKeys(key []byte, defn *common.IndexDefn,
+	arrayPos int, isArrayDistinct bool) ([][]byte, error) {
+
+	key = append([]byte(nil), key...)
+	if defn.Desc != nil {
+		jsonEncoder.ReverseCollate(key, defn.Desc)
+	}
+	if !defn.IsArrayIndex {
+		return [][]byte{key}, nil
+	}
+
+	items, _, _, err := ArrayIndexItems(key, arrayPos,
+		make([]byte, 0, len(key)*3), isArrayDistinct, false)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, 0, len(items))
+	for _, item := range items {
+		keys = append(keys, append([]byte(nil), item...))
+	}
+	return keys, nil
+}
+
+//
+// POST /backindex/lookup  return the keys of documents in the back index
+// of the index instances and partitions on this node.  Refer to
+// client.BackIndexRequest for the request body.
+//
+func (s *scanCoordinator) handleBackIndexLookup(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := &qclient.BackIndexRequest{}
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, backIndexMaxRequestSize))
+	if err := decoder.Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	targets := s.getBackIndexTargets(common.IndexDefnId(req.DefnId))
+	defer func() {
+		for _, target := range targets {
+			target.slice.DecrRef()
+		}
+	}()
+
+	if len(targets) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(common.ErrIndexNotFound.Error() + "\n"))
+		return
+	}
+
+	// keys are user data, hence require the permission to query the bucket.
+	defn := &targets[0].inst.Defn
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.select!execute", defn.Bucket)
+	if !common.IsAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	logging.Infof("%v: back index lookup of %v documents in index %v:%v",
+		s.logPrefix, len(req.DocIds), defn.Bucket, defn.Name)
+
+	resp := &qclient.BackIndexResponse{DefnId: req.DefnId}
+	if len(req.Documents) != 0 {
+		resp.Projected = projectBackIndexKeys(defn, req.Documents)
+	}
+
+	partns := make([]*qclient.BackIndexPartition, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *backIndexTarget) {
+			defer wg.Done()
+			partns[i] = target.lookup(req.DocIds, r.Host)
+		}(i, target)
+	}
+	wg.Wait()
+
+	insts := make(map[common.IndexInstId]*qclient.BackIndexInstance)
+	for i, target := range targets {
+		inst, ok := insts[target.inst.InstId]
+		if !ok {
+			inst = &qclient.BackIndexInstance{
+				InstId:    uint64(target.inst.InstId),
+				ReplicaId: target.inst.ReplicaId,
+			}
+			insts[target.inst.InstId] = inst
+			resp.Instances = append(resp.Instances, inst)
+		}
+		inst.Partitions = append(inst.Partitions, partns[i])
+	}
+
+	s.writeJson(w, resp)
+}
+
+// backIndexTarget is a partition of an index instance to lookup.
+type backIndexTarget struct {
+	inst    common.IndexInst
+	partnId common.PartitionId
+	slice   Slice
+	seqnos  []uint64
+}
+
+//
+// getBackIndexTargets return the partitions of the instances of the index
+// on this node, with the timestamp of the latest snapshot of the instance.
+// Caller must DecrRef the slices.
+//
+func (s *scanCoordinator) getBackIndexTargets(defnId common.IndexDefnId) []*backIndexTarget {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var targets []*backIndexTarget
+	for instId, inst := range s.indexInstMap {
+		if inst.Defn.DefnId != defnId || inst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+
+		var seqnos []uint64
+		if snap, ok := s.lastSnapshot[instId]; ok && snap.Timestamp() != nil {
+			seqnos = append(seqnos, snap.Timestamp().Seqnos...)
+		}
+
+		for partnId, partn := range s.indexPartnMap[instId] {
+			slice := partn.Sc.GetSliceById(0)
+			if slice == nil {
+				continue
+			}
+			slice.IncrRef()
+			targets = append(targets, &backIndexTarget{
+				inst:    inst,
+				partnId: partnId,
+				slice:   slice,
+				seqnos:  seqnos,
+			})
+		}
+	}
+	return targets
+}
+
+func (t *backIndexTarget) lookup(docids []string, host string) *qclient.BackIndexPartition {
+
+	partn := &qclient.BackIndexPartition{
+		PartitionId: t.partnId,
+		Host:        host,
+		Bucket:      t.inst.Defn.Bucket,
+		Timestamp:   t.seqnos,
+		Entries:     make(map[string][]json.RawMessage),
+	}
+
+	if t.inst.Defn.IsPrimary {
+		partn.Error = ErrBackIndexPrimary.Error()
+		return partn
+	}
+
+	reader, ok := t.slice.(BackIndexReader)
+	if !ok {
+		partn.Error = ErrBackIndexUnsupported.Error()
+		return partn
+	}
+
+	for _, docid := range docids {
+		keys, err := reader.LookupBackIndex([]byte(docid))
+		if err != nil {
+			logging.Errorf("ScanCoordinator: back index lookup in instance %v partition %v: %v",
+				t.inst.InstId, t.partnId, err)
+			partn.Error = err.Error()
+			return partn
+		}
+		if len(keys) == 0 {
+			continue
+		}
+
+		entries := make([]json.RawMessage, 0, len(keys))
+		for _, key := range keys {
+			decoded, err := jsonEncoder.Decode(key, make([]byte, 0, len(key)*3))
+			if err != nil {
+				partn.Error = err.Error()
+				return partn
+			}
+			entries = append(entries, json.RawMessage(decoded))
+		}
+		partn.Entries[docid] = entries
+	}
+	return partn
+}
+
+//
+// projectBackIndexKeys compute the key of each document the same way the
+// projector does, so that it can be compared with the back index.  Meta
+// data other than the docid is not available to the expressions.
+//
+func projectBackIndexKeys(defn *common.IndexDefn,
+	docs map[string]json.RawMessage) map[string]*qclient.ProjectedKey {
+
+	result := make(map[string]*qclient.ProjectedKey)
+
+	if defn.IsPrimary {
+		for docid := range docs {
+			key, _ := json.Marshal([]string{docid})
+			result[docid] = &qclient.ProjectedKey{Key: key, Where: true}
+		}
+		return result
+	}
+
+	setError := func(err error) map[string]*qclient.ProjectedKey {
+		for docid := range docs {
+			result[docid] = &qclient.ProjectedKey{Error: err.Error()}
+		}
+		return result
+	}
+
+	skExprs, err := protobuf.CompileN1QLExpression(defn.SecExprs)
+	if err != nil {
+		return setError(err)
+	}
+	var whExpr interface{}
+	if len(defn.WhereExpr) > 0 {
+		cExprs, err := protobuf.CompileN1QLExpression([]string{defn.WhereExpr})
+		if err != nil {
+			return setError(err)
+		}
+		whExpr = cExprs[0]
+	}
+
+	context := qexpr.NewIndexContext()
+	for docid, body := range docs {
+		projected := &qclient.ProjectedKey{Where: true}
+		result[docid] = projected
+
+		docval := qvalue.NewAnnotatedValue(qvalue.NewValue([]byte(body)))
+		docval.SetAttachment("meta", map[string]interface{}{"id": docid})
+
+		if whExpr != nil {
+			out, _, err := protobuf.N1QLTransform(nil, docval, context, []interface{}{whExpr}, nil)
+			if err != nil {
+				projected.Error = err.Error()
+				continue
+			}
+			if projected.Where = string(out) == "true"; !projected.Where {
+				continue
+			}
+		}
+
+		key, _, err := protobuf.N1QLTransform([]byte(docid), docval, context, skExprs, nil)
+		if err != nil {
+			projected.Error = err.Error()
+			continue
+		}
+		if key != nil {
+			projected.Key = json.RawMessage(key)
+		}
+	}
+	return result
+}
@@ -0,0 +1,204 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+)
+
+// Encode a json key in collatejson format, as it is stored in the back index.
+func encodeTestBackIndexKey(t *testing.T, key string, desc []bool) []byte {
+
+	code, err := jsonEncoder.Encode([]byte(key), make([]byte, 0, len(key)*3+16))
+	if err != nil {
+		t.Fatalf("encode %v: %v", key, err)
+	}
+	if desc != nil {
+		jsonEncoder.ReverseCollate(code, desc)
+	}
+	return code
+}
+
+func decodeTestBackIndexKeys(t *testing.T, keys [][]byte) []interface{} {
+
+	var result []interface{}
+	for _, key := range keys {
+		decoded, err := jsonEncoder.Decode(key, make([]byte, 0, len(key)*3+16))
+		if err != nil {
+			t.Fatalf("decode: %v", err)
+		}
+		var value interface{}
+		if err := json.Unmarshal(decoded, &value); err != nil {
+			t.Fatalf("unmarshal %s: %v", decoded, err)
+		}
+		result = append(result, value)
+	}
+	return result
+}
+
+func TestBackIndexKeys(t *testing.T) {
+
+	desc := []bool{false, true, false}
+
+	testcases := []struct {
+		comment    string
+		key        string
+		desc       []bool
+		isArray    bool
+		arrayPos   int
+		isDistinct bool
+		expected   string
+	}{
+		{"scalar", `["a", 10, "x"]`, nil, false, 0, false,
+			`[["a", 10, "x"]]`},
+		{"desc", `["a", 10, "x"]`, desc, false, 0, false,
+			`[["a", 10, "x"]]`},
+		{"array", `["a", [3, 1, 2], "x"]`, nil, true, 1, false,
+			`[["a", 1, "x"], ["a", 2, "x"], ["a", 3, "x"]]`},
+		{"array with duplicates", `["a", [3, 1, 3], "x"]`, nil, true, 1, false,
+			`[["a", 1, "x"], ["a", 3, "x"]]`},
+		{"distinct array", `["a", [3, 1, 3], "x"]`, nil, true, 1, true,
+			`[["a", 1, "x"], ["a", 3, "x"]]`},
+		{"desc array", `["a", [3, 1, 2], "x"]`, desc, true, 1, false,
+			`[["a", 1, "x"], ["a", 2, "x"], ["a", 3, "x"]]`},
+		{"leading array", `[["b", "a"], 10]`, nil, true, 0, false,
+			`[["a", 10], ["b", 10]]`},
+	}
+
+	for _, tc := range testcases {
+		defn := &common.IndexDefn{Desc: tc.desc, IsArrayIndex: tc.isArray}
+
+		stored := encodeTestBackIndexKey(t, tc.key, tc.desc)
+		orig := append([]byte(nil), stored...)
+
+		keys, err := backIndexKeys(stored, defn, tc.arrayPos, tc.isDistinct)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tc.comment, err)
+			continue
+		}
+
+		// storage owns the key, it must not be modified
+		if !bytes.Equal(stored, orig) {
+			t.Errorf("%v: stored key is modified", tc.comment)
+		}
+
+		var expected []interface{}
+		json.Unmarshal([]byte(tc.expected), &expected)
+
+		if got := decodeTestBackIndexKeys(t, keys); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, expected, got)
+		}
+	}
+}
+
+func TestProjectBackIndexKeys(t *testing.T) {
+
+	docs := map[string]json.RawMessage{
+		"young":   json.RawMessage(`{"name": "a", "age": 5, "tags": ["x"]}`),
+		"old":     json.RawMessage(`{"name": "b", "age": 50, "tags": ["y"]}`),
+		"noage":   json.RawMessage(`{"name": "c"}`),
+		"notags":  json.RawMessage(`{"name": "d", "age": 30, "tags": []}`),
+		"noname":  json.RawMessage(`{"age": 40}`),
+		"invalid": json.RawMessage(`{"age": `),
+	}
+
+	type projected struct {
+		key   string // json, empty if not indexed
+		where bool
+		err   bool
+	}
+
+	testcases := []struct {
+		comment  string
+		defn     *common.IndexDefn
+		expected map[string]projected
+	}{
+		{"primary", &common.IndexDefn{IsPrimary: true}, map[string]projected{
+			"young":   {`["young"]`, true, false},
+			"old":     {`["old"]`, true, false},
+			"noage":   {`["noage"]`, true, false},
+			"notags":  {`["notags"]`, true, false},
+			"noname":  {`["noname"]`, true, false},
+			"invalid": {`["invalid"]`, true, false},
+		}},
+		{"composite", &common.IndexDefn{SecExprs: []string{"`name`", "`age`"}}, map[string]projected{
+			"young":   {`["a", 5]`, true, false},
+			"old":     {`["b", 50]`, true, false},
+			"notags":  {`["d", 30]`, true, false},
+			"noname":  {"", true, false},
+			"invalid": {"", true, false},
+		}},
+		{"where", &common.IndexDefn{SecExprs: []string{"`name`"}, WhereExpr: "`age` > 10"}, map[string]projected{
+			"young":   {"", false, false},
+			"old":     {`["b"]`, true, false},
+			"noage":   {"", false, false},
+			"notags":  {`["d"]`, true, false},
+			"noname":  {"", true, false},
+			"invalid": {"", false, false},
+		}},
+		{"array", &common.IndexDefn{SecExprs: []string{"DISTINCT ARRAY t FOR t IN `tags` END", "`name`"}},
+			map[string]projected{
+				"young":   {`[["x"], "a"]`, true, false},
+				"old":     {`[["y"], "b"]`, true, false},
+				"noage":   {"", true, false},
+				"notags":  {"", true, false},
+				"noname":  {"", true, false},
+				"invalid": {"", true, false},
+			}},
+		{"invalid expression", &common.IndexDefn{SecExprs: []string{"`name` +"}}, map[string]projected{
+			"young":   {"", false, true},
+			"old":     {"", false, true},
+			"noage":   {"", false, true},
+			"notags":  {"", false, true},
+			"noname":  {"", false, true},
+			"invalid": {"", false, true},
+		}},
+	}
+
+	for _, tc := range testcases {
+		result := projectBackIndexKeys(tc.defn, docs)
+
+		if len(result) != len(docs) {
+			t.Errorf("%v: expected %v documents, got %v", tc.comment, len(docs), len(result))
+		}
+
+		for docid, expected := range tc.expected {
+			got := result[docid]
+			if got == nil {
+				t.Errorf("%v: %v: missing projected key", tc.comment, docid)
+				continue
+			}
+
+			if (len(got.Error) != 0) != expected.err {
+				t.Errorf("%v: %v: expected error %v, got %q", tc.comment, docid, expected.err, got.Error)
+				continue
+			}
+
+			if got.Where != expected.where {
+				t.Errorf("%v: %v: expected where %v, got %v", tc.comment, docid, expected.where, got.Where)
+			}
+
+			if !equalTestProjectedKey(got, expected.key) {
+				t.Errorf("%v: %v: expected key %v, got %s", tc.comment, docid, expected.key, got.Key)
+			}
+		}
+	}
+}
+
+func equalTestProjectedKey(got *qclient.ProjectedKey, expected string) bool {
+
+	if len(expected) == 0 {
+		return len(got.Key) == 0
+	}
+
+	var v1, v2 interface{}
+	if err := json.Unmarshal(got.Key, &v1); err != nil {
+		return false
+	}
+	json.Unmarshal([]byte(expected), &v2)
+	return reflect.DeepEqual(v1, v2)
+}
@@ -768,6 +768,50 @@ func (meta *metaNotifier) OnPartitionPrune(instId common.IndexInstId, partitions
 	return nil
 }
 
+func (meta *metaNotifier) OnIndexRename(instIds []common.IndexInstId, name string, reqCtx *common.MetadataRequestContext) error {
+
+	logging.Infof("clustMgrAgent::OnIndexRename Notification "+
+		"Received for Rename Index %v %v %v", instIds, name, reqCtx)
+
+	respCh := make(MsgChannel)
+
+	meta.adminCh <- &MsgClustMgrRenameIndex{
+		instIds: instIds,
+		name:    name,
+		respCh:  respCh}
+
+	//wait for response
+	if res, ok := <-respCh; ok {
+
+		switch res.GetMsgType() {
+
+		case MSG_SUCCESS:
+			logging.Infof("clustMgrAgent::OnIndexRename Success "+
+				"for IndexId %v", instIds)
+			return nil
+
+		case MSG_ERROR:
+			logging.Errorf("clustMgrAgent::OnIndexRename Error "+
+				"for IndexId %v. Error %v", instIds, res)
+			err := res.(*MsgError).GetError()
+			return &common.IndexerError{Reason: err.String(), Code: err.convertError()}
+
+		default:
+			logging.Fatalf("clustMgrAgent::OnIndexRename Unknown Response "+
+				"Received for IndexId %v. Response %v", instIds, res)
+			common.CrashOnError(errors.New("Unknown Response"))
+
+		}
+
+	} else {
+		logging.Fatalf("clustMgrAgent::OnIndexRename Unexpected Channel Close "+
+			"for IndexId %v", instIds)
+		common.CrashOnError(errors.New("Unknown Response"))
+	}
+
+	return nil
+}
+
 func (meta *metaNotifier) OnFetchStats() error {
 
 	go meta.fetchStats()
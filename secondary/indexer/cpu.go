@@ -16,13 +16,15 @@ var cpuPercent uint64
 var rss uint64
 var memTotal uint64
 var memFree uint64
+var diskTotal uint64
 
 //////////////////////////////////////////////////////////////
 // Concrete Type/Struct
 //////////////////////////////////////////////////////////////
 
 type cpuCollector struct {
-	stats *system.SystemStats
+	stats      *system.SystemStats
+	storageDir string
 }
 
 //////////////////////////////////////////////////////////////
@@ -30,11 +32,12 @@ type cpuCollector struct {
 //////////////////////////////////////////////////////////////
 
 //
-// Start Cpu collection
+// Start Cpu collection.  Disk capacity is collected for the file
+// system of storageDir.
 //
-func StartCpuCollector() error {
+func StartCpuCollector(storageDir string) error {
 
-	collector := &cpuCollector{}
+	collector := &cpuCollector{storageDir: storageDir}
 
 	// open sigar for stats
 	stats, err := system.NewSystemStats()
@@ -97,12 +100,20 @@ func (c *cpuCollector) runCollectStats() {
 		}
 		updateMemFree(free)
 
+		disk, err := c.stats.TotalDisk(c.storageDir)
+		if err != nil {
+			logging.Debugf("Fail to get total disk. Err=%v", err)
+			continue
+		}
+		updateDiskTotal(disk)
+
 		count++
 		if count > 10 {
 			logging.Debugf("cpuCollector: cpu percent %v for pid %v", cpu, pid)
 			logging.Debugf("cpuCollector: RSS %v for pid %v", rss, pid)
 			logging.Debugf("cpuCollector: memory total %v", total)
 			logging.Debugf("cpuCollector: memory free %vv", free)
+			logging.Debugf("cpuCollector: disk total %v", disk)
 			count = 0
 		}
 	}
@@ -152,3 +163,13 @@ func getMemFree() uint64 {
 
 	return atomic.LoadUint64(&memFree)
 }
+
+func updateDiskTotal(disk uint64) {
+
+	atomic.StoreUint64(&diskTotal, disk)
+}
+
+func getDiskTotal() uint64 {
+
+	return atomic.LoadUint64(&diskTotal)
+}
@@ -16,6 +16,7 @@
 package indexer
 
 import (
+	"github.com/couchbase/cbauth"
 	"github.com/couchbase/cbauth/metakv"
 	"github.com/couchbase/cbauth/service"
 	"github.com/couchbase/indexing/secondary/common"
@@ -30,6 +31,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -67,8 +69,9 @@ type DDLServiceMgr struct {
 // DDL related settings
 //
 type ddlSettings struct {
-	numReplica   int32
-	numPartition int32
+	numReplica        int32
+	numPartition      int32
+	alterIndexTimeout int64
 
 	storageMode string
 	mutex       sync.RWMutex
@@ -98,7 +101,8 @@ func NewDDLServiceMgr(indexerId common.IndexerId, supvCmdch MsgChannel, supvMsgc
 	nodeId := service.NodeID(config["nodeuuid"].String())
 
 	numReplica := int32(config["settings.num_replica"].Int())
-	settings := &ddlSettings{numReplica: numReplica}
+	alterIndexTimeout := int64(config["settings.alter_index_timeout"].Int())
+	settings := &ddlSettings{numReplica: numReplica, alterIndexTimeout: alterIndexTimeout}
 
 	donech := make(chan bool)
 
@@ -124,6 +128,11 @@ func NewDDLServiceMgr(indexerId common.IndexerId, supvCmdch MsgChannel, supvMsgc
 	mux.HandleFunc("/listCreateTokens", mgr.handleListCreateTokens)
 	mux.HandleFunc("/listDeleteTokens", mgr.handleListDeleteTokens)
 	mux.HandleFunc("/listDropInstanceTokens", mgr.handleListDropInstanceTokens)
+	mux.HandleFunc("/buildQueue", mgr.handleListBuildQueue)
+	mux.HandleFunc("/buildQueue/reorder", mgr.handleReorderBuildQueue)
+	mux.HandleFunc("/buildQueue/cancel", mgr.handleCancelBuildQueue)
+	mux.HandleFunc("/ddlHistory", mgr.handleListDDLHistory)
+	mux.HandleFunc("/ddlHistory/recreate", mgr.handleRecreateFromDDLHistory)
 
 	go mgr.run()
 
@@ -154,6 +163,7 @@ func getDDLServiceMgr() *DDLServiceMgr {
 func (m *DDLServiceMgr) run() {
 
 	go m.processCreateCommand()
+	go m.processDDLHistoryPruning()
 
 loop:
 	for {
@@ -252,6 +262,10 @@ func (m *DDLServiceMgr) rebalanceDone(change *service.TopologyChange, isCancel b
 	m.cleanupDropCommand()
 	m.cleanupDropInstanceCommand()
 	m.cleanupBuildCommand()
+	m.cleanupRenameCommand()
+	m.cleanupAlterCommand()
+	m.cleanupIndexAlias()
+	m.cleanupBuildQueue()
 	m.handleClusterStorageMode(httpAddrMap)
 }
 
@@ -406,6 +420,154 @@ func (m *DDLServiceMgr) cleanupBuildCommand() {
 	}
 }
 
+//////////////////////////////////////////////////////////////
+// Rename Token
+//////////////////////////////////////////////////////////////
+
+//
+// Cleanup rename index token of dropped index
+//
+func (m *DDLServiceMgr) cleanupRenameCommand() {
+
+	entries, err := metakv.ListAllChildren(mc.RenameDDLCommandTokenPath)
+	if err != nil {
+		logging.Warnf("DDLServiceMgr: Failed to cleanup rename index token upon rebalancing.  Skip cleanup.  Internal Error = %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+
+		if strings.Contains(entry.Path, mc.RenameDDLCommandTokenPath) && entry.Value != nil {
+
+			logging.Infof("DDLServiceMgr: processing rename index token %v", entry.Path)
+
+			command, err := mc.UnmarshallRenameCommandToken(entry.Value)
+			if err != nil {
+				logging.Warnf("DDLServiceMgr: Failed to clean rename index token upon rebalancing.  Skp command %v.  Internal Error = %v.", entry.Path, err)
+				continue
+			}
+
+			// The rename token is needed as long as there is an indexer holding the index definition,
+			// since the indexer may not have picked up the new name.
+			if m.provider.FindIndexIgnoreStatus(command.DefnId) == nil {
+				if err := MetakvDel(entry.Path); err != nil {
+					logging.Warnf("DDLServiceMgr: Failed to remove rename index token %v. Error = %v", entry.Path, err)
+				} else {
+					logging.Infof("DDLServiceMgr: Remove rename index token %v.", entry.Path)
+				}
+			}
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////
+// Alter Token
+//////////////////////////////////////////////////////////////
+
+//
+// Cleanup alter index token of dropped index
+//
+func (m *DDLServiceMgr) cleanupAlterCommand() {
+
+	entries, err := metakv.ListAllChildren(mc.AlterDDLCommandTokenPath)
+	if err != nil {
+		logging.Warnf("DDLServiceMgr: Failed to cleanup alter index token upon rebalancing.  Skip cleanup.  Internal Error = %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+
+		if strings.Contains(entry.Path, mc.AlterDDLCommandTokenPath) && entry.Value != nil {
+
+			logging.Infof("DDLServiceMgr: processing alter index token %v", entry.Path)
+
+			command, err := mc.UnmarshallAlterCommandToken(entry.Value)
+			if err != nil {
+				logging.Warnf("DDLServiceMgr: Failed to clean alter index token upon rebalancing.  Skp command %v.  Internal Error = %v.", entry.Path, err)
+				continue
+			}
+
+			// The alter token is needed as long as there is an indexer holding either the old index or
+			// the altered index, since the indexer may not have swapped in the altered index.
+			if m.provider.FindIndexIgnoreStatus(command.DefnId) == nil &&
+				m.provider.FindIndexIgnoreStatus(command.ShadowDefnId) == nil {
+				if err := MetakvDel(entry.Path); err != nil {
+					logging.Warnf("DDLServiceMgr: Failed to remove alter index token %v. Error = %v", entry.Path, err)
+				} else {
+					logging.Infof("DDLServiceMgr: Remove alter index token %v.", entry.Path)
+				}
+			}
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////
+// Index Alias
+//////////////////////////////////////////////////////////////
+
+//
+// Cleanup index alias pointing to dropped index
+//
+func (m *DDLServiceMgr) cleanupIndexAlias() {
+
+	tokens, err := mc.ListIndexAliasToken("")
+	if err != nil {
+		logging.Warnf("DDLServiceMgr: Failed to cleanup index alias upon rebalancing.  Skip cleanup.  Internal Error = %v", err)
+		return
+	}
+
+	for _, token := range tokens {
+
+		if m.provider.FindIndexIgnoreStatus(token.DefnId) == nil {
+			if err := mc.DeleteIndexAliasToken(token.Bucket, token.Alias); err != nil {
+				logging.Warnf("DDLServiceMgr: Failed to remove index alias (%v, %v). Error = %v", token.Bucket, token.Alias, err)
+			} else {
+				logging.Infof("DDLServiceMgr: Remove index alias (%v, %v) of dropped index %v.", token.Bucket, token.Alias, token.DefnId)
+			}
+		}
+	}
+}
+
+//////////////////////////////////////////////////////////////
+// Build Queue
+//////////////////////////////////////////////////////////////
+
+//
+// Cleanup build queue entries of dropped index, or index that has
+// been moved out of an indexer node.
+//
+func (m *DDLServiceMgr) cleanupBuildQueue() {
+
+	entries, err := mc.ListBuildQueueEntry("")
+	if err != nil {
+		logging.Warnf("DDLServiceMgr: Failed to cleanup build queue upon rebalancing.  Skip cleanup.  Internal Error = %v", err)
+		return
+	}
+
+	for _, entry := range entries {
+
+		found := false
+		if index := m.provider.FindIndexIgnoreStatus(entry.DefnId); index != nil {
+			insts := append(append([]*client.InstanceDefn(nil), index.Instances...), index.InstsInRebalance...)
+			for _, inst := range insts {
+				for _, indexerId := range inst.IndexerId {
+					if indexerId == entry.IndexerId {
+						found = true
+					}
+				}
+			}
+		}
+
+		if !found {
+			if err := mc.DeleteBuildQueueEntry(entry.IndexerId, entry.DefnId); err != nil {
+				logging.Warnf("DDLServiceMgr: Failed to remove build queue entry (%v, %v). Error = %v", entry.IndexerId, entry.DefnId, err)
+			} else {
+				logging.Infof("DDLServiceMgr: Remove build queue entry (%v, %v).", entry.IndexerId, entry.DefnId)
+			}
+		}
+	}
+}
+
 //////////////////////////////////////////////////////////////
 // Create Token
 //////////////////////////////////////////////////////////////
@@ -849,6 +1011,33 @@ func (m *DDLServiceMgr) processCreateCommand() {
 	}
 }
 
+//////////////////////////////////////////////////////////////
+// DDL History
+//////////////////////////////////////////////////////////////
+
+//
+// Prune DDL history periodically, rather than on every DDL, since it
+// lists the DDL history of every bucket.
+//
+func (m *DDLServiceMgr) processDDLHistoryPruning() {
+
+	ticker := time.NewTicker(mc.DDLHistoryPruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := mc.PruneDDLHistory(""); err != nil {
+				logging.Warnf("DDLServiceMgr: Failed to prune DDL history.  Internal Error = %v", err)
+			}
+
+		case <-m.killch:
+			logging.Infof("DDLServiceMgr: DDL history pruning go-routine terminates.")
+			return
+		}
+	}
+}
+
 //////////////////////////////////////////////////////////////
 // Drop Instance Token
 //////////////////////////////////////////////////////////////
@@ -1281,8 +1470,478 @@ func (m *DDLServiceMgr) handleListDropInstanceTokens(w http.ResponseWriter, r *h
 	}
 }
 
+//
+// Build queue entry with its position and estimated start time
+//
+type buildQueueEntryStatus struct {
+	mc.BuildQueueEntry
+	Position int    `json:"position"`
+	ETA      string `json:"eta,omitempty"`
+}
+
+//
+// Return the build queue of all indexer nodes in build order.  Entries are
+// only returned for the buckets that the user is allowed to list index.
+//
+func (m *DDLServiceMgr) handleListBuildQueue(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuthCreds(w, r)
+	if !ok {
+		logging.Errorf("DDLServiceMgr::handleListBuildQueue Validation Failure for Request %v", r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	entries, err := mc.ListBuildQueueEntry("")
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::handleListBuildQueue error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	bucket := r.FormValue("bucket")
+	config := m.config.Load()
+
+	windows, err := mc.ParseBuildWindows(config["build.queue.window"].String())
+	if err != nil {
+		logging.Warnf("DDLServiceMgr::handleListBuildQueue invalid build window %v", err)
+	}
+
+	maxConcurrent := config["build.queue.maxConcurrentPerNode"].Int()
+	if maxConcurrent <= 0 {
+		maxConcurrent = config["settings.build.batch_size"].Int()
+	}
+
+	queues := make(map[common.IndexerId][]*mc.BuildQueueEntry)
+	for _, entry := range entries {
+		if !entry.Cancelled {
+			queues[entry.IndexerId] = append(queues[entry.IndexerId], entry)
+		}
+	}
+
+	now := time.Now()
+	result := make([]buildQueueEntryStatus, 0, len(entries))
+
+	for indexerId, queue := range queues {
+
+		var avgBuildTime time.Duration
+		if status, err := mc.FetchBuildQueueStatus(indexerId); err == nil && status != nil {
+			avgBuildTime = time.Duration(status.AvgBuildTime)
+		}
+
+		mc.SortBuildQueue(queue)
+		positions := mc.ComputeBuildQueuePositions(queue, avgBuildTime, maxConcurrent, windows, now)
+
+		for _, entry := range queue {
+
+			if len(bucket) != 0 && entry.Bucket != bucket {
+				continue
+			}
+
+			permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", entry.Bucket)
+			if allowed, err := creds.IsAllowed(permission); err != nil || !allowed {
+				continue
+			}
+
+			status := buildQueueEntryStatus{BuildQueueEntry: *entry}
+			if position, ok := positions[entry.DefnId]; ok {
+				status.Position = position.Position
+				if position.ETA != 0 {
+					status.ETA = time.Unix(0, position.ETA).Format(time.RFC3339)
+				}
+			}
+
+			result = append(result, status)
+		}
+	}
+
+	buf, err := json.Marshal(result)
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::handleListBuildQueue error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
+//
+// Change the build priority of index in the build queue.  The request body is a list
+// of {"defnId": <id>, "priority": <priority>}.  Index with higher priority is built first.
+//
+func (m *DDLServiceMgr) handleReorderBuildQueue(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuthCreds(w, r)
+	if !ok {
+		logging.Errorf("DDLServiceMgr::handleReorderBuildQueue Validation Failure for Request %v", r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var requests []struct {
+		DefnId   common.IndexDefnId `json:"defnId"`
+		Priority int                `json:"priority"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&requests); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	priorities := make(map[common.IndexDefnId]int)
+	for _, request := range requests {
+		priorities[request.DefnId] = request.Priority
+	}
+
+	logging.Infof("DDLServiceMgr::handleReorderBuildQueue Processing Request %v", priorities)
+
+	m.updateBuildQueue(w, creds, priorities, func(entry *mc.BuildQueueEntry) {
+		entry.Priority = priorities[entry.DefnId]
+	})
+}
+
+//
+// Cancel index build in the build queue.  The request body is {"defnId": <id>}.  Once
+// cancelled, the index is no longer scheduled for build on any node.  Cancel does not
+// stop an index build that has already started.  Such index has position 0 in the
+// build queue, and the build can only be stopped by dropping the index.
+//
+func (m *DDLServiceMgr) handleCancelBuildQueue(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuthCreds(w, r)
+	if !ok {
+		logging.Errorf("DDLServiceMgr::handleCancelBuildQueue Validation Failure for Request %v", r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		DefnId common.IndexDefnId `json:"defnId"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	logging.Infof("DDLServiceMgr::handleCancelBuildQueue Processing Request %v", request.DefnId)
+
+	m.updateBuildQueue(w, creds, map[common.IndexDefnId]int{request.DefnId: 0}, func(entry *mc.BuildQueueEntry) {
+		entry.Cancelled = true
+	})
+}
+
+func (m *DDLServiceMgr) updateBuildQueue(w http.ResponseWriter, creds cbauth.Creds, defnIds map[common.IndexDefnId]int,
+	update func(entry *mc.BuildQueueEntry)) {
+
+	entries, err := mc.ListBuildQueueEntry("")
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::updateBuildQueue error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	matched := make([]*mc.BuildQueueEntry, 0, len(defnIds))
+	for _, entry := range entries {
+		if _, ok := defnIds[entry.DefnId]; ok && !entry.Cancelled {
+			permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!build", entry.Bucket)
+			if !common.IsAllowed(creds, []string{permission}, w) {
+				return
+			}
+			matched = append(matched, entry)
+		}
+	}
+
+	if len(matched) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Index not found in build queue\n"))
+		return
+	}
+
+	// The builder updates the same entries concurrently.  Each entry is updated
+	// with a rev-checked read-modify-write, so that neither update is lost.
+	for _, entry := range matched {
+		_, err := mc.UpdateBuildQueueEntry(entry.IndexerId, entry.DefnId, func(current *mc.BuildQueueEntry) bool {
+			if current.Cancelled {
+				return false
+			}
+			update(current)
+			return true
+		})
+
+		if err != nil {
+			logging.Errorf("DDLServiceMgr::updateBuildQueue error %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(err.Error() + "\n"))
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+//
+// DDL history entry with the changes to the index definition
+//
+type ddlHistoryEntryStatus struct {
+	mc.DDLHistoryEntry
+	Diff []mc.DDLHistoryDiff `json:"diff,omitempty"`
+}
+
+//
+// Return the DDL history in the order the DDL is issued.  The history can be
+// filtered by bucket, index name, defnId and operation.  Entries are only returned
+// for the buckets that the user is allowed to list index.
+//
+func (m *DDLServiceMgr) handleListDDLHistory(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuthCreds(w, r)
+	if !ok {
+		logging.Errorf("DDLServiceMgr::handleListDDLHistory Validation Failure for Request %v", r)
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	bucket := r.FormValue("bucket")
+	name := r.FormValue("name")
+	op := r.FormValue("op")
+
+	var defnId common.IndexDefnId
+	if str := r.FormValue("defnId"); len(str) != 0 {
+		id, err := strconv.ParseUint(str, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(fmt.Sprintf("Invalid defnId %v\n", str)))
+			return
+		}
+		defnId = common.IndexDefnId(id)
+	}
+
+	entries, err := mc.ListDDLHistory(bucket)
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::handleListDDLHistory error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	result := make([]ddlHistoryEntryStatus, 0, len(entries))
+	for _, entry := range entries {
+
+		if len(name) != 0 && entry.Name != name &&
+			(entry.Before == nil || entry.Before.Name != name) &&
+			(entry.After == nil || entry.After.Name != name) {
+			continue
+		}
+
+		if defnId != 0 && entry.DefnId != defnId {
+			continue
+		}
+
+		if len(op) != 0 && entry.Op != op {
+			continue
+		}
+
+		permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", entry.Bucket)
+		if allowed, err := creds.IsAllowed(permission); err != nil || !allowed {
+			continue
+		}
+
+		status := ddlHistoryEntryStatus{DDLHistoryEntry: *entry}
+		if entry.Before != nil && entry.After != nil {
+			if status.Diff, err = mc.DiffIndexDefn(entry.Before, entry.After); err != nil {
+				logging.Warnf("DDLServiceMgr::handleListDDLHistory fail to diff entry %v: %v", entry.Id, err)
+			}
+		}
+
+		result = append(result, status)
+	}
+
+	buf, err := json.Marshal(result)
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::handleListDDLHistory error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
+//
+// Recreate an index from the definition before the DDL recorded in DDL history
+// (e.g. a dropped index, or the index before it is altered).  The request body
+// is {"bucket": <bucket>, "id": <entry id>, "name": <optional new name>}.  The
+// index is created with its original properties.
+//
+func (m *DDLServiceMgr) handleRecreateFromDDLHistory(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuthCreds(w, r)
+	if !ok {
+		logging.Errorf("DDLServiceMgr::handleRecreateFromDDLHistory Validation Failure for Request %v", r)
+		return
+	}
+
+	if r.Method != "POST" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		Bucket string `json:"bucket"`
+		Id     string `json:"id"`
+		Name   string `json:"name,omitempty"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!create", request.Bucket)
+	if !common.IsAllowed(creds, []string{permission}, w) {
+		return
+	}
+
+	entry, err := mc.FetchDDLHistoryEntry(request.Bucket, request.Id)
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::handleRecreateFromDDLHistory error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	if entry == nil || entry.Before == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("Index definition not found in DDL history\n"))
+		return
+	}
+
+	defn := entry.Before
+	name := defn.Name
+	if len(request.Name) != 0 {
+		name = request.Name
+	}
+
+	logging.Infof("DDLServiceMgr::handleRecreateFromDDLHistory Recreate index %v (%v, %v) as %v from entry %v",
+		defn.DefnId, defn.Bucket, defn.Name, name, entry.Id)
+
+	provider, _, err := m.newMetadataProvider(nil)
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::handleRecreateFromDDLHistory error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	defer provider.Close()
+
+	req := &mc.DDLRequest{
+		Creds: creds,
+		Context: mc.DDLHistoryContext{
+			Source:     mc.DDLHistorySourceREST,
+			Node:       m.localAddr,
+			RemoteAddr: r.RemoteAddr,
+			FromEntry:  entry.Id,
+		},
+	}
+
+	defnId, err, _ := provider.CreateIndexWithPlan(name, defn.Bucket, string(defn.Using), string(defn.ExprType),
+		defn.WhereExpr, defn.SecExprs, defn.Desc, defn.IsPrimary, defn.PartitionScheme, defn.PartitionKeys,
+		ddlHistoryRecreatePlan(defn), req)
+	if err != nil {
+		logging.Errorf("DDLServiceMgr::handleRecreateFromDDLHistory error %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	buf, err := json.Marshal(map[string]common.IndexDefnId{"defnId": defnId})
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
+//
+// Convert the properties of an index definition to the with-clause of create index.
+//
+func ddlHistoryRecreatePlan(defn *common.IndexDefn) map[string]interface{} {
+
+	toList := func(values []string) []interface{} {
+		result := make([]interface{}, 0, len(values))
+		for _, value := range values {
+			result = append(result, value)
+		}
+		return result
+	}
+
+	plan := make(map[string]interface{})
+	plan["defer_build"] = defn.Deferred
+
+	if len(defn.Nodes) != 0 {
+		plan["nodes"] = toList(defn.Nodes)
+	} else {
+		plan["num_replica"] = float64(defn.GetNumReplica())
+	}
+
+	if common.IsPartitioned(defn.PartitionScheme) && defn.NumPartitions != 0 {
+		plan["num_partition"] = float64(defn.NumPartitions)
+	}
+
+	if defn.RetainDeletedXATTR {
+		plan["retain_deleted_xattr"] = true
+	}
+
+	if len(defn.NodeLabels) != 0 {
+		plan["node_labels"] = toList(defn.NodeLabels)
+	}
+
+	if len(defn.AntiAffinity) != 0 {
+		plan["anti_affinity"] = toList(defn.AntiAffinity)
+	}
+
+	if defn.ColocatePartitions {
+		plan["colocate_partitions"] = true
+	}
+
+	return plan
+}
+
 func (m *DDLServiceMgr) validateAuth(w http.ResponseWriter, r *http.Request) bool {
-	_, valid, err := common.IsAuthValid(r)
+	_, valid := m.validateAuthCreds(w, r)
+	return valid
+}
+
+func (m *DDLServiceMgr) validateAuthCreds(w http.ResponseWriter, r *http.Request) (cbauth.Creds, bool) {
+	creds, valid, err := common.IsAuthValid(r)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(err.Error() + "\n"))
@@ -1290,7 +1949,7 @@ func (m *DDLServiceMgr) validateAuth(w http.ResponseWriter, r *http.Request) boo
 		w.WriteHeader(401)
 		w.Write([]byte("401 Unauthorized\n"))
 	}
-	return valid
+	return creds, valid
 }
 
 //////////////////////////////////////////////////////////////
@@ -1504,6 +2163,10 @@ func (s *ddlSettings) StorageMode() string {
 	return s.storageMode
 }
 
+func (s *ddlSettings) AlterIndexTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.alterIndexTimeout)) * time.Second
+}
+
 func (s *ddlSettings) handleSettings(config common.Config) {
 
 	numReplica := int32(config["settings.num_replica"].Int())
@@ -1520,6 +2183,13 @@ func (s *ddlSettings) handleSettings(config common.Config) {
 		logging.Errorf("DDLServiceMgr: invalid setting value for numPartitions=%v", numPartition)
 	}
 
+	alterIndexTimeout := config["settings.alter_index_timeout"].Int()
+	if alterIndexTimeout >= 0 {
+		atomic.StoreInt64(&s.alterIndexTimeout, int64(alterIndexTimeout))
+	} else {
+		logging.Errorf("DDLServiceMgr: invalid setting value for alter_index_timeout=%v", alterIndexTimeout)
+	}
+
 	storageMode := config["settings.storage_mode"].String()
 	if len(storageMode) != 0 {
 		func() {
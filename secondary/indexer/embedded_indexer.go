@@ -0,0 +1,589 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package indexer
+
+import (
+	"errors"
+	"hash/crc32"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/common/queryutil"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/projector"
+	qexpr "github.com/couchbase/query/expression"
+	qvalue "github.com/couchbase/query/value"
+)
+
+var (
+	ErrEmbeddedIndexExists      = errors.New("Index instance already exists")
+	ErrEmbeddedIndexPartitioned = errors.New("Partitioned index is not supported by embedded indexer")
+	ErrEmbeddedIndexerClosed    = errors.New("Embedded indexer is closed")
+)
+
+//
+// EmbeddedIndexer serves index scans over the queryport protocol from
+// memdb slices that are updated in-process, without projector, KV or
+// cluster manager.  It is meant for tests of queryport clients, index
+// definitions and topology are left to the caller.
+//
+// Documents are kept per bucket, so that an index created after the
+// documents were written is built from them.  Every write is assigned the
+// next sequence number of the document's vbucket and a new snapshot of the
+// affected indexes is published before the write returns, hence scans
+// issued after a write always see it.
+//
+type EmbeddedIndexer struct {
+	config      common.Config
+	numVbuckets int
+
+	cmdch            MsgChannel
+	msgch            MsgChannel
+	snapshotNotifych chan IndexSnapshot
+	scanCoord        ScanCoordinator
+	mux              *http.ServeMux
+
+	// serializes index updates and writes
+	mu       sync.Mutex
+	stats    *IndexerStats
+	instMap  common.IndexInstMap
+	partnMap IndexPartnMap
+	indexes  map[common.IndexInstId]*embeddedIndex
+	buckets  map[string]*embeddedBucket
+	closed   bool
+
+	// protects the published snapshots and the snapshot waiters
+	snapMu  sync.Mutex
+	snapMap map[common.IndexInstId]IndexSnapshot
+	waiters map[common.IndexInstId][]*snapshotWaiter
+
+	donech chan bool
+}
+
+type embeddedIndex struct {
+	inst    common.IndexInst
+	slice   Slice
+	skExprs []interface{}
+	whExpr  interface{}
+}
+
+type embeddedBucket struct {
+	ts   *common.TsVbuuid
+	docs map[string][]byte
+}
+
+//
+// NewEmbeddedIndexer starts the scan coordinator of an embedded indexer.
+// config is the indexer section of the system config, scans are served on
+// scanPort and slices are created under storage_dir.
+//
+func NewEmbeddedIndexer(config common.Config) (*EmbeddedIndexer, error) {
+	config = config.Clone()
+	if err := config.SetValue("moi.useMemMgmt", false); err != nil {
+		return nil, err
+	}
+
+	e := &EmbeddedIndexer{
+		config:           config,
+		numVbuckets:      config["numVbuckets"].Int(),
+		cmdch:            make(MsgChannel),
+		msgch:            make(MsgChannel),
+		snapshotNotifych: make(chan IndexSnapshot),
+		stats:            NewIndexerStats(),
+		instMap:          make(common.IndexInstMap),
+		partnMap:         make(IndexPartnMap),
+		indexes:          make(map[common.IndexInstId]*embeddedIndex),
+		buckets:          make(map[string]*embeddedBucket),
+		snapMap:          make(map[common.IndexInstId]IndexSnapshot),
+		waiters:          make(map[common.IndexInstId][]*snapshotWaiter),
+		donech:           make(chan bool),
+	}
+
+	storageDir := config["storage_dir"].String()
+	if err := os.MkdirAll(storageDir, 0755); err != nil {
+		return nil, err
+	}
+
+	scanCoord, msg := NewScanCoordinator(e.cmdch, e.msgch, config, e.snapshotNotifych)
+
+	if msg.GetMsgType() != MSG_SUCCESS {
+		return nil, msg.(*MsgError).GetError().cause
+	}
+	e.scanCoord = scanCoord
+
+	// every embedded indexer has its own mux, so that they can co-exist in
+	// the same process.
+	e.mux = http.NewServeMux()
+	scanCoord.RegisterRestEndpoints(e.mux)
+
+	go e.handleSnapshotRequests()
+
+	if err := e.sendCommand(&MsgIndexerState{mType: INDEXER_RESUME}); err != nil {
+		e.Close()
+		return nil, err
+	}
+
+	logging.Infof("EmbeddedIndexer: Started on scan port %v, storage %v",
+		config["scanPort"].String(), storageDir)
+	return e, nil
+}
+
+//
+// CreateIndex creates a memdb slice for the index instance and builds it
+// from the documents of the bucket.  The instance is served as active once
+// CreateIndex returns.
+//
+func (e *EmbeddedIndexer) CreateIndex(defn common.IndexDefn, instId common.IndexInstId) error {
+	if common.IsPartitioned(defn.PartitionScheme) {
+		return ErrEmbeddedIndexPartitioned
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrEmbeddedIndexerClosed
+	}
+	if _, ok := e.indexes[instId]; ok {
+		return ErrEmbeddedIndexExists
+	}
+
+	var err error
+	if !defn.IsPrimary {
+		defn.IsArrayIndex, _, _, err = queryutil.GetArrayExpressionPosition(defn.SecExprs)
+		if err != nil {
+			return err
+		}
+	}
+	defn.Using = common.MemDB
+
+	index := &embeddedIndex{}
+	if !defn.IsPrimary {
+		if index.skExprs, err = protobuf.CompileN1QLExpression(defn.SecExprs); err != nil {
+			return err
+		}
+		if len(defn.WhereExpr) > 0 {
+			cExprs, err := protobuf.CompileN1QLExpression([]string{defn.WhereExpr})
+			if err != nil {
+				return err
+			}
+			index.whExpr = cExprs[0]
+		}
+	}
+
+	partnId := common.PartitionId(0)
+	endpt := common.Endpoint(net.JoinHostPort("", e.config["scanPort"].String()))
+	partnDefn := common.KeyPartitionDefn{Id: partnId, Version: 0, Endpts: []common.Endpoint{endpt}}
+	pc := common.NewKeyPartitionContainer(e.numVbuckets, 1, defn.PartitionScheme, defn.HashScheme)
+	pc.AddPartition(partnId, partnDefn)
+
+	index.inst = common.IndexInst{
+		InstId:      instId,
+		Defn:        defn,
+		State:       common.INDEX_STATE_ACTIVE,
+		RState:      common.REBAL_ACTIVE,
+		Pc:          pc,
+		StorageMode: common.MemDB,
+	}
+
+	e.stats.AddPartition(instId, defn.Bucket, defn.Name, 0, partnId)
+	path := filepath.Join(e.config["storage_dir"].String(), IndexPath(&index.inst, partnId, SliceId(0)))
+	slice, err := NewMemDBSlice(path, SliceId(0), defn, instId, partnId, defn.IsPrimary, false, 1,
+		e.config, e.stats.GetPartitionStats(instId, partnId))
+	if err != nil {
+		e.stats.RemoveIndex(instId)
+		return err
+	}
+	index.slice = slice
+
+	bucket := e.getBucket(defn.Bucket)
+	for docid, body := range bucket.docs {
+		meta := e.mutationMeta(bucket, docid)
+		if err := e.writeDocument(index, docid, body, meta); err != nil {
+			e.destroySlice(index)
+			e.stats.RemoveIndex(instId)
+			return err
+		}
+	}
+
+	if err := e.publishSnapshot(index, bucket.ts); err != nil {
+		e.destroySlice(index)
+		e.stats.RemoveIndex(instId)
+		return err
+	}
+
+	e.indexes[instId] = index
+	e.instMap[instId] = index.inst
+
+	sc := NewHashedSliceContainer()
+	sc.AddSlice(SliceId(0), slice)
+	e.partnMap[instId] = PartitionInstMap{partnId: PartitionInst{Defn: partnDefn, Sc: sc}}
+
+	logging.Infof("EmbeddedIndexer: Created index %v instance %v, %v documents",
+		defn.DefnId, instId, len(bucket.docs))
+	return e.updateMaps()
+}
+
+//
+// DropIndex stops serving the index instance and destroys its slice.
+// Pending scans of the instance fail with ErrIndexNotFound.
+//
+func (e *EmbeddedIndexer) DropIndex(instId common.IndexInstId) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrEmbeddedIndexerClosed
+	}
+	index, ok := e.indexes[instId]
+	if !ok {
+		return common.ErrIndexNotFound
+	}
+
+	delete(e.indexes, instId)
+	delete(e.instMap, instId)
+	delete(e.partnMap, instId)
+	err := e.updateMaps()
+
+	e.removeSnapshot(instId)
+	e.destroySlice(index)
+	e.stats.RemoveIndex(instId)
+
+	logging.Infof("EmbeddedIndexer: Dropped index %v instance %v", index.inst.Defn.DefnId, instId)
+	return err
+}
+
+//
+// Upsert writes the documents to the bucket and updates the indexes of
+// the bucket.  Document bodies are JSON.
+//
+func (e *EmbeddedIndexer) Upsert(bucket string, docs map[string][]byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrEmbeddedIndexerClosed
+	}
+
+	b := e.getBucket(bucket)
+	for docid, body := range docs {
+		b.docs[docid] = append([]byte(nil), body...)
+	}
+	return e.writeDocuments(bucket, docs)
+}
+
+//
+// Delete removes the documents from the bucket and from the indexes of
+// the bucket.  Unknown documents are ignored.
+//
+func (e *EmbeddedIndexer) Delete(bucket string, docids []string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrEmbeddedIndexerClosed
+	}
+
+	b := e.getBucket(bucket)
+	docs := make(map[string][]byte)
+	for _, docid := range docids {
+		if _, ok := b.docs[docid]; ok {
+			delete(b.docs, docid)
+			docs[docid] = nil
+		}
+	}
+	return e.writeDocuments(bucket, docs)
+}
+
+//
+// Timestamp returns the timestamp of the latest write to the bucket.
+// Snapshots published after a write are at least as recent as it.
+//
+func (e *EmbeddedIndexer) Timestamp(bucket string) *common.TsVbuuid {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.getBucket(bucket).ts.Copy()
+}
+
+//
+// HTTPHandler returns the handler of the REST endpoints of the scan
+// coordinator, such as back index lookup.  Serving it is left to the
+// caller.
+//
+func (e *EmbeddedIndexer) HTTPHandler() http.Handler {
+	return e.mux
+}
+
+//
+// Close shuts down the scan coordinator and destroys all the slices.
+//
+func (e *EmbeddedIndexer) Close() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return
+	}
+	e.closed = true
+
+	e.cmdch <- &MsgGeneral{mType: SCAN_COORD_SHUTDOWN}
+	<-e.cmdch
+	close(e.snapshotNotifych)
+
+	for instId, index := range e.indexes {
+		e.removeSnapshot(instId)
+		e.destroySlice(index)
+	}
+	e.indexes = nil
+	close(e.donech)
+
+	logging.Infof("EmbeddedIndexer: Closed")
+}
+
+// writeDocuments apply the documents to the indexes of the bucket, a
+// document with nil body is deleted.  Snapshots of the indexes are
+// published once all the documents are applied.
+func (e *EmbeddedIndexer) writeDocuments(bucket string, docs map[string][]byte) error {
+	if len(docs) == 0 {
+		return nil
+	}
+
+	b := e.getBucket(bucket)
+	indexes := make([]*embeddedIndex, 0)
+	for _, index := range e.indexes {
+		if index.inst.Defn.Bucket == bucket {
+			indexes = append(indexes, index)
+		}
+	}
+
+	var err error
+	for docid, body := range docs {
+		vb := e.vbucket(docid)
+		b.ts.Seqnos[vb]++
+		b.ts.Snapshots[vb] = [2]uint64{b.ts.Seqnos[vb], b.ts.Seqnos[vb]}
+
+		meta := e.mutationMeta(b, docid)
+		for _, index := range indexes {
+			if err1 := e.writeDocument(index, docid, body, meta); err1 != nil && err == nil {
+				err = err1
+			}
+		}
+	}
+
+	for _, index := range indexes {
+		if err1 := e.publishSnapshot(index, b.ts); err1 != nil && err == nil {
+			err = err1
+		}
+	}
+	return err
+}
+
+// writeDocument evaluate the index keys of the document the way projector
+// does and apply them to the slice.  Meta data other than the docid is not
+// available to the expressions.
+func (e *EmbeddedIndexer) writeDocument(index *embeddedIndex, docid string,
+	body []byte, meta *MutationMeta) error {
+
+	if body == nil {
+		return index.slice.Delete([]byte(docid), meta)
+	}
+	if index.inst.Defn.IsPrimary {
+		return index.slice.Insert(nil, []byte(docid), meta)
+	}
+
+	docval := qvalue.NewAnnotatedValue(qvalue.NewValue(body))
+	docval.SetAttachment("meta", map[string]interface{}{"id": docid})
+	context := qexpr.NewIndexContext()
+
+	if index.whExpr != nil {
+		out, _, err := protobuf.N1QLTransform(nil, docval, context, []interface{}{index.whExpr}, nil)
+		if err != nil {
+			return err
+		}
+		if string(out) != "true" {
+			return index.slice.Delete([]byte(docid), meta)
+		}
+	}
+
+	// keys are collatejson encoded, as sent by projector to 5.5+ indexers.
+	key, _, err := protobuf.N1QLTransform([]byte(docid), docval, context, index.skExprs,
+		make([]byte, 0, maxIndexEntrySize+ENCODE_BUF_SAFE_PAD))
+	if err != nil {
+		return err
+	}
+	if key == nil {
+		return index.slice.Delete([]byte(docid), meta)
+	}
+	return index.slice.Insert(key, []byte(docid), meta)
+}
+
+// publishSnapshot create a snapshot of the slice at timestamp ts, replace
+// the published snapshot of the index and notify the waiters it satisfies.
+func (e *EmbeddedIndexer) publishSnapshot(index *embeddedIndex, ts *common.TsVbuuid) error {
+	ts = ts.Copy()
+	info, err := index.slice.NewSnapshot(ts, false)
+	if err != nil {
+		return err
+	}
+	snap, err := index.slice.OpenSnapshot(info)
+	if err != nil {
+		return err
+	}
+
+	instId := index.inst.InstId
+	is := &indexSnapshot{
+		instId: instId,
+		ts:     ts,
+		partns: map[common.PartitionId]PartitionSnapshot{
+			0: &partitionSnapshot{
+				id:     0,
+				slices: map[SliceId]SliceSnapshot{0: &sliceSnapshot{id: 0, snap: snap}},
+			},
+		},
+	}
+
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+
+	DestroyIndexSnapshot(e.snapMap[instId])
+	e.snapMap[instId] = is
+
+	t := time.Now()
+	var newWaiters []*snapshotWaiter
+	for _, w := range e.waiters[instId] {
+		if !w.expired.IsZero() && t.After(w.expired) {
+			w.Error(common.ErrScanTimedOut)
+		} else if isSnapshotConsistent(is, w.cons, w.ts) {
+			w.Notify(CloneIndexSnapshot(is))
+		} else {
+			newWaiters = append(newWaiters, w)
+		}
+	}
+	e.waiters[instId] = newWaiters
+	return nil
+}
+
+// removeSnapshot destroy the published snapshot of the index and fail its
+// waiters.
+func (e *EmbeddedIndexer) removeSnapshot(instId common.IndexInstId) {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+
+	DestroyIndexSnapshot(e.snapMap[instId])
+	delete(e.snapMap, instId)
+
+	for _, w := range e.waiters[instId] {
+		w.Error(common.ErrIndexNotFound)
+	}
+	delete(e.waiters, instId)
+}
+
+// handleSnapshotRequests serve the snapshot requests of the scan
+// coordinator, as storage manager does.  Scan coordinator is never notified
+// of new snapshots, so that every scan is served from the latest one.
+func (e *EmbeddedIndexer) handleSnapshotRequests() {
+	for {
+		select {
+		case msg := <-e.msgch:
+			req, ok := msg.(*MsgIndexSnapRequest)
+			if !ok {
+				logging.Warnf("EmbeddedIndexer: Ignoring message %v", msg.GetMsgType())
+				continue
+			}
+			e.handleSnapshotRequest(req)
+
+		case <-e.donech:
+			return
+		}
+	}
+}
+
+func (e *EmbeddedIndexer) handleSnapshotRequest(req *MsgIndexSnapRequest) {
+	e.snapMu.Lock()
+	defer e.snapMu.Unlock()
+
+	is, ok := e.snapMap[req.GetIndexId()]
+	if !ok {
+		req.respch <- common.ErrIndexNotFound
+		return
+	}
+	if isSnapshotConsistent(is, req.GetConsistency(), req.GetTS()) {
+		req.respch <- CloneIndexSnapshot(is)
+		return
+	}
+
+	w := newSnapshotWaiter(req.GetIndexId(), req.GetTS(), req.GetConsistency(),
+		req.GetReplyChannel(), req.GetExpiredTime())
+	e.waiters[req.idxInstId] = append(e.waiters[req.idxInstId], w)
+}
+
+// updateMaps send the index instance and partition maps to the scan
+// coordinator.
+func (e *EmbeddedIndexer) updateMaps() error {
+	err := e.sendCommand(&MsgUpdateInstMap{indexInstMap: e.instMap, stats: e.stats.Clone()})
+	if err != nil {
+		return err
+	}
+	return e.sendCommand(&MsgUpdatePartnMap{indexPartnMap: e.partnMap})
+}
+
+func (e *EmbeddedIndexer) sendCommand(cmd Message) error {
+	e.cmdch <- cmd
+	if resp := <-e.cmdch; resp.GetMsgType() == MSG_ERROR {
+		return resp.(*MsgError).GetError().cause
+	}
+	return nil
+}
+
+func (e *EmbeddedIndexer) getBucket(bucket string) *embeddedBucket {
+	b, ok := e.buckets[bucket]
+	if !ok {
+		b = &embeddedBucket{
+			ts:   common.NewTsVbuuid(bucket, e.numVbuckets),
+			docs: make(map[string][]byte),
+		}
+		vbuuid := uint64(rand.Int63()) | 1
+		for vb := range b.ts.Vbuuids {
+			b.ts.Vbuuids[vb] = vbuuid
+		}
+		e.buckets[bucket] = b
+	}
+	return b
+}
+
+func (e *EmbeddedIndexer) destroySlice(index *embeddedIndex) {
+	index.slice.Close()
+	index.slice.Destroy()
+}
+
+// mutationMeta return the meta data of the latest write of the document.
+// Slices queue the mutations, hence a new meta is needed for every one.
+func (e *EmbeddedIndexer) mutationMeta(b *embeddedBucket, docid string) *MutationMeta {
+	vb := e.vbucket(docid)
+	return &MutationMeta{
+		bucket:  b.ts.Bucket,
+		vbucket: Vbucket(vb),
+		vbuuid:  Vbuuid(b.ts.Vbuuids[vb]),
+		seqno:   Seqno(b.ts.Seqnos[vb]),
+		projVer: common.ProjVer_5_5_0,
+	}
+}
+
+// vbucket map the docid to its vbucket the way KV does.
+func (e *EmbeddedIndexer) vbucket(docid string) uint16 {
+	crc := crc32.ChecksumIEEE([]byte(docid))
+	return uint16(((crc >> 16) & 0x7fff) % uint32(e.numVbuckets))
+}
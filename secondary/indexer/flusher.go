@@ -73,11 +73,22 @@ type flusher struct {
 	indexPartnMap IndexPartnMap
 	config        common.Config
 	stats         *IndexerStats
+
+	//coalesce mutations of same docid while flushing upto a timestamp
+	coalesce       bool
+	coalesceWindow int
 }
 
 //NewFlusher returns new instance of flusher
 func NewFlusher(config common.Config, stats *IndexerStats) *flusher {
-	return &flusher{config: config, stats: stats}
+	f := &flusher{config: config, stats: stats}
+	if cv, ok := config["flusher.coalesceMutations"]; ok {
+		f.coalesce = cv.Bool()
+	}
+	if cv, ok := config["flusher.coalesceWindow"]; ok {
+		f.coalesceWindow = cv.Int()
+	}
+	return f
 }
 
 //PersistUptoTS will flush the mutation queue upto the
@@ -301,6 +312,17 @@ func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.S
 	var mut *MutationKeys
 	bucketStats := f.stats.buckets[bucket]
 
+	//Mutations are coalesced only when flushing upto a timestamp, as
+	//storage snapshots are created only at the end of a flush, no
+	//intermediate version of a document can be visible to scans.
+	var window *coalesceWindow
+	if persist && f.coalesce && f.coalesceWindow > 0 {
+		window = newCoalesceWindow(f.coalesceWindow, f.mutationPartnId)
+		defer func() {
+			f.flushCoalesceWindow(window, streamId, bucketStats)
+		}()
+	}
+
 	//Read till the channel is closed by queue indicating it has sent all the
 	//sequence numbers requested
 	for ok {
@@ -311,6 +333,16 @@ func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.S
 					//No persistence is required. Just skip this mutation.
 					continue
 				}
+				if window != nil {
+					window.add(mut)
+					if bucketStats != nil {
+						bucketStats.mutationQueueSize.Add(-1)
+					}
+					if window.full() {
+						f.flushCoalesceWindow(window, streamId, bucketStats)
+					}
+					continue
+				}
 				f.flushSingleMutation(mut, streamId)
 				mut.Free()
 				if bucketStats != nil {
@@ -325,6 +357,89 @@ func (f *flusher) flushSingleVbucketUptoSeqno(q MutationQueue, streamId common.S
 	}
 }
 
+//flushCoalesceWindow flushes the mutations left in the window after
+//coalescing, in the order they were dequeued.
+func (f *flusher) flushCoalesceWindow(window *coalesceWindow,
+	streamId common.StreamId, bucketStats *BucketStats) {
+
+	for _, mut := range window.muts {
+		if mut != nil {
+			f.flushSingleMutation(mut, streamId)
+			mut.Free()
+		}
+	}
+	if bucketStats != nil {
+		bucketStats.numMutationsCoalesced.Add(window.coalesced)
+	}
+	window.reset()
+}
+
+//mutationPartnId returns the partition of index instance the mutation
+//entry belongs to.
+func (f *flusher) mutationPartnId(m *Mutation) common.PartitionId {
+	if idxInst, ok := f.indexInstMap[m.uuid]; ok && idxInst.Pc != nil {
+		return idxInst.Pc.GetPartitionIdByPartitionKey(m.partnkey)
+	}
+	return common.PartitionId(0)
+}
+
+//coalesceWindow keeps only the latest version of a document, per index
+//instance partition, from a batch of mutations dequeued for a vbucket.
+type coalesceWindow struct {
+	size      int
+	muts      []*MutationKeys
+	docs      map[string]int //docid -> position of its latest mutation
+	coalesced int64          //mutations dropped since the last reset
+	partnIdOf func(*Mutation) common.PartitionId
+}
+
+func newCoalesceWindow(size int,
+	partnIdOf func(*Mutation) common.PartitionId) *coalesceWindow {
+
+	return &coalesceWindow{
+		size:      size,
+		muts:      make([]*MutationKeys, 0, size),
+		docs:      make(map[string]int),
+		partnIdOf: partnIdOf,
+	}
+}
+
+//add mutation to the window, entries of previous mutation of the same
+//docid, for index instance partitions present in this mutation, are
+//dropped. If all its entries are dropped, previous mutation is dropped
+//altogether.
+func (w *coalesceWindow) add(mutk *MutationKeys) {
+
+	if i, ok := w.docs[string(mutk.docid)]; ok {
+		prev := w.muts[i]
+		n := 0
+		for _, m := range prev.mut {
+			if !mutk.hasInstance(m.uuid, w.partnIdOf(m), w.partnIdOf) {
+				prev.mut[n] = m
+				n++
+			}
+		}
+		prev.mut = prev.mut[:n]
+		if n == 0 {
+			w.muts[i] = nil
+			w.coalesced++
+			prev.Free()
+		}
+	}
+	w.docs[string(mutk.docid)] = len(w.muts)
+	w.muts = append(w.muts, mutk)
+}
+
+func (w *coalesceWindow) full() bool {
+	return len(w.muts) >= w.size
+}
+
+func (w *coalesceWindow) reset() {
+	w.muts = w.muts[:0]
+	w.docs = make(map[string]int)
+	w.coalesced = 0
+}
+
 //flushSingleMutation talks to persistence layer to store the mutations
 //Any error from persistence layer is sent back on workerMsgCh
 func (f *flusher) flushSingleMutation(mut *MutationKeys, streamId common.StreamId) {
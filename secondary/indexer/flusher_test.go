@@ -0,0 +1,66 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestCoalesceWindow(t *testing.T) {
+
+	mutk := func(docid string, seqno Seqno, uuids ...common.IndexInstId) *MutationKeys {
+		mk := &MutationKeys{
+			meta:  &MutationMeta{vbucket: 0, seqno: seqno},
+			docid: []byte(docid),
+		}
+		for _, uuid := range uuids {
+			mk.mut = append(mk.mut, &Mutation{uuid: uuid, command: common.Upsert})
+		}
+		return mk
+	}
+
+	partnIdOf := func(m *Mutation) common.PartitionId {
+		return common.PartitionId(len(m.partnkey))
+	}
+
+	w := newCoalesceWindow(10, partnIdOf)
+	w.add(mutk("doc1", 1, 1, 2))
+	w.add(mutk("doc2", 2, 1, 2))
+	w.add(mutk("doc1", 3, 1))    // supersedes instance 1 of seqno 1
+	w.add(mutk("doc1", 4, 1, 2)) // supersedes seqno 1 and seqno 3
+
+	if w.coalesced != 2 {
+		t.Errorf("expected 2 coalesced mutations, got %v", w.coalesced)
+	}
+
+	var seqnos []Seqno
+	for _, mk := range w.muts {
+		if mk != nil {
+			seqnos = append(seqnos, mk.meta.seqno)
+		}
+	}
+	if len(seqnos) != 2 || seqnos[0] != 2 || seqnos[1] != 4 {
+		t.Errorf("unexpected mutations after coalescing %v", seqnos)
+	}
+
+	w.reset()
+	if len(w.muts) != 0 || w.coalesced != 0 || w.full() {
+		t.Errorf("expected window to be empty after reset")
+	}
+
+	// entries of other partitions of the same instance are not dropped.
+	doc1 := mutk("doc1", 5, 1)
+	doc1.mut[0].partnkey = []byte("a")
+	moved := mutk("doc1", 6, 1)
+	moved.mut[0].partnkey = []byte("ab")
+	w.add(doc1)
+	w.add(moved)
+	if w.coalesced != 0 || w.muts[0] == nil || len(w.muts[0].mut) != 1 {
+		t.Errorf("expected mutation of another partition to be kept")
+	}
+	w.add(mutk("doc1", 7, 1)) // partition 0, neither is superseded
+	w.add(mutk("doc1", 8, 1)) // supersedes seqno 7
+	if w.coalesced != 1 || w.muts[0] == nil || w.muts[1] == nil {
+		t.Errorf("unexpected mutations after coalescing partitions")
+	}
+}
@@ -312,6 +312,23 @@ func (fdb *fdbSlice) Delete(docid []byte, meta *MutationMeta) error {
 	return fdb.fatalDbErr
 }
 
+//LookupBackIndex implements BackIndexReader. The lookup is
+//served by any of the writers.
+func (fdb *fdbSlice) LookupBackIndex(docid []byte) ([][]byte, error) {
+	if fdb.isPrimary {
+		return nil, ErrBackIndexPrimary
+	}
+
+	timeout := time.After(backIndexLookupTimeout)
+	respch := make(chan *backIndexResult, 1)
+	select {
+	case fdb.cmdCh <- &backIndexLookup{docid: docid, respch: respch}:
+	case <-timeout:
+		return nil, ErrBackIndexTimeout
+	}
+	return waitBackIndexLookup(respch, 1, timeout)
+}
+
 //handleCommands keep listening to any buffered
 //write requests for the slice and processes
 //those. This will shut itself down internal
@@ -344,6 +361,11 @@ loop:
 				elapsed = time.Since(start)
 				fdb.totalFlushTime += elapsed
 
+			case *backIndexLookup:
+				lookup := c.(*backIndexLookup)
+				lookup.respch <- fdb.lookupBackIndex(lookup.docid, workerId)
+				continue loop
+
 			default:
 				logging.Errorf("ForestDBSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v Received "+
 					"Unknown Command %v", fdb.id, fdb.idxInstId, logging.TagUD(c))
@@ -893,6 +915,22 @@ func (fdb *fdbSlice) getBackIndexEntry(docid []byte, workerId int) ([]byte, erro
 	return kbytes, nil
 }
 
+func (fdb *fdbSlice) lookupBackIndex(docid []byte, workerId int) *backIndexResult {
+	backEntry, err := fdb.getBackIndexEntry(docid, workerId)
+	if err != nil || backEntry == nil {
+		return &backIndexResult{err: err}
+	}
+
+	//back entry of an array index is the array key, otherwise
+	//it is the main index entry
+	if !fdb.idxDefn.IsArrayIndex {
+		entry := secondaryIndexEntry(backEntry)
+		backEntry = backEntry[:entry.lenKey()]
+	}
+	keys, err := backIndexKeys(backEntry, &fdb.idxDefn, fdb.arrayExprPosition, fdb.isArrayDistinct)
+	return &backIndexResult{keys: keys, err: err}
+}
+
 //checkFatalDbError checks if the error returned from DB
 //is fatal and stores it. This error will be returned
 //to caller on next DB operation
@@ -419,6 +419,7 @@ func (idx *indexer) initHttpServer() {
 		idx.settingsMgr.RegisterRestEndpoints()
 		idx.statsMgr.RegisterRestEndpoints()
 		idx.clustMgrAgent.RegisterRestEndpoints()
+		idx.scanCoord.RegisterRestEndpoints(httpMux)
 		if err := srv.ListenAndServe(); err != nil {
 			logging.Fatalf("indexer:: Error Starting Http Server: %v", err)
 			common.CrashOnError(err)
@@ -1127,6 +1128,9 @@ func (idx *indexer) handleAdminMsgs(msg Message) {
 	case CLUST_MGR_PRUNE_PARTITION:
 		idx.handlePrunePartition(msg)
 
+	case CLUST_MGR_RENAME_INDEX:
+		idx.handleRenameIndex(msg)
+
 	case MSG_ERROR:
 
 		logging.Fatalf("Indexer::handleAdminMsgs Fatal Error On Admin Channel %+v", msg)
@@ -1973,6 +1977,47 @@ func (idx *indexer) handlePrunePartition(msg Message) {
 	respch <- &MsgSuccess{}
 }
 
+//
+// Rename index is for updating the index name of the index instances after
+// the index definition is renamed in metadata (e.g. shadow index swapped in by
+// alter index).  The index name is not part of the storage, so only the index
+// inst map needs to be updated.
+//
+func (idx *indexer) handleRenameIndex(msg Message) {
+
+	instIds := msg.(*MsgClustMgrRenameIndex).GetInstIds()
+	name := msg.(*MsgClustMgrRenameIndex).GetName()
+	respch := msg.(*MsgClustMgrRenameIndex).GetRespCh()
+
+	updated := false
+	for _, instId := range instIds {
+		if inst, ok := idx.indexInstMap[instId]; ok {
+			logging.Infof("Indexer::handleRenameIndex Rename Index Instance %v from %v to %v",
+				instId, inst.Defn.Name, name)
+			inst.Defn.Name = name
+			inst.Defn.ShadowOf = 0
+			idx.indexInstMap[instId] = inst
+			updated = true
+		} else {
+			logging.Warnf("Indexer::handleRenameIndex Index instance %v not found. Skip", instId)
+		}
+	}
+
+	if updated {
+		msgUpdateIndexInstMap := idx.newIndexInstMsg(idx.indexInstMap)
+		if err := idx.distributeIndexMapsToWorkers(msgUpdateIndexInstMap, nil); err != nil {
+			respch <- &MsgError{
+				err: Error{code: ERROR_INDEXER_INTERNAL_ERROR,
+					severity: FATAL,
+					cause:    err,
+					category: INDEXER}}
+			common.CrashOnError(err)
+		}
+	}
+
+	respch <- &MsgSuccess{}
+}
+
 //
 // Prune partition is for updating indexer's state after a partition is
 // removed from an index instance.    When indexer handles this request,
@@ -41,15 +41,17 @@ const (
 	opInsert = iota
 	opUpdate
 	opDelete
+	opLookup
 )
 
 const tmpDirName = ".tmp"
 
 type indexMutation struct {
-	op    int
-	key   []byte
-	docid []byte
-	meta  *MutationMeta
+	op     int
+	key    []byte
+	docid  []byte
+	meta   *MutationMeta
+	respch chan *backIndexResult // opLookup
 }
 
 func docIdFromEntryBytes(e []byte) []byte {
@@ -370,6 +372,26 @@ func (mdb *memdbSlice) Delete(docid []byte, meta *MutationMeta) error {
 	return mdb.fatalDbErr
 }
 
+// LookupBackIndex implements BackIndexReader.  A document is in the back
+// index of the writer its vbucket is routed to, hence all writers are
+// looked up.
+func (mdb *memdbSlice) LookupBackIndex(docid []byte) ([][]byte, error) {
+	if mdb.isPrimary {
+		return nil, ErrBackIndexPrimary
+	}
+
+	timeout := time.After(backIndexLookupTimeout)
+	respch := make(chan *backIndexResult, mdb.numWriters)
+	for i := 0; i < mdb.numWriters; i++ {
+		select {
+		case mdb.cmdCh[i] <- indexMutation{op: opLookup, docid: docid, respch: respch}:
+		case <-timeout:
+			return nil, ErrBackIndexTimeout
+		}
+	}
+	return waitBackIndexLookup(respch, mdb.numWriters, timeout)
+}
+
 func (mdb *memdbSlice) handleCommandsWorker(workerId int) {
 	var start time.Time
 	var elapsed time.Duration
@@ -393,6 +415,10 @@ loop:
 				elapsed = time.Since(start)
 				mdb.totalFlushTime += elapsed
 
+			case opLookup:
+				icmd.respch <- mdb.lookupBackIndex(icmd.docid, workerId)
+				continue loop
+
 			default:
 				logging.Errorf("MemDBSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v PartitionId %v Received "+
 					"Unknown Command %v", mdb.id, mdb.idxInstId, mdb.idxPartnId, logging.TagUD(icmd))
@@ -591,6 +617,27 @@ func (mdb *memdbSlice) insertSecArrayIndex(keys []byte, docid []byte, workerId i
 	return nmut
 }
 
+func (mdb *memdbSlice) lookupBackIndex(docid []byte, workerId int) *backIndexResult {
+	lookupentry := entryBytesFromDocId(docid)
+	ptr := (*skiplist.Node)(mdb.back[workerId].Get(lookupentry))
+	if ptr == nil {
+		return &backIndexResult{}
+	}
+
+	// Back index points to the main index node, nodes of the entries of
+	// an array index are linked.
+	var keys [][]byte
+	for _, e := range memdb.NewNodeList(ptr).Keys() {
+		entry := secondaryIndexEntry(e)
+		key := append([]byte(nil), e[:entry.lenKey()]...)
+		if mdb.idxDefn.Desc != nil {
+			jsonEncoder.ReverseCollate(key, mdb.idxDefn.Desc)
+		}
+		keys = append(keys, key)
+	}
+	return &backIndexResult{keys: keys}
+}
+
 func (mdb *memdbSlice) delete(docid []byte, workerId int) int {
 	var nmut int
 
@@ -1416,6 +1463,22 @@ func (s *memdbSnapshot) CountTotal(ctx IndexReaderContext, stopch StopChannel) (
 	return uint64(s.info.MainSnap.Count()), nil
 }
 
+// RangeSplitKeys implements RangeSplitter{} interface, keys are sampled
+// from the skiplist.
+func (s *memdbSnapshot) RangeSplitKeys(nways int) ([][]byte, error) {
+	if s.isPrimary() {
+		return nil, nil
+	}
+
+	entries := s.slice.mainstore.RangeSplitKeys(s.info.MainSnap, nways)
+	keys := make([][]byte, 0, len(entries))
+	for _, entry := range entries {
+		e := secondaryIndexEntry(entry)
+		keys = append(keys, entry[:e.lenKey()])
+	}
+	return keys, nil
+}
+
 func (s *memdbSnapshot) CountRange(ctx IndexReaderContext, low, high IndexKey, inclusion Inclusion,
 	stopch StopChannel) (uint64, error) {
 
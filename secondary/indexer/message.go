@@ -100,6 +100,7 @@ const (
 	CLUST_MGR_CLEANUP_PARTITION
 	CLUST_MGR_MERGE_PARTITION
 	CLUST_MGR_PRUNE_PARTITION
+	CLUST_MGR_RENAME_INDEX
 
 	//CBQ_BRIDGE_SHUTDOWN
 	CBQ_BRIDGE_SHUTDOWN
@@ -938,6 +939,38 @@ func (m *MsgClustMgrPrunePartition) GetString() string {
 	return str
 }
 
+// CLUST_MGR_RENAME_INDEX
+type MsgClustMgrRenameIndex struct {
+	instIds []common.IndexInstId
+	name    string
+	respCh  MsgChannel
+}
+
+func (m *MsgClustMgrRenameIndex) GetMsgType() MsgType {
+	return CLUST_MGR_RENAME_INDEX
+}
+
+func (m *MsgClustMgrRenameIndex) GetInstIds() []common.IndexInstId {
+	return m.instIds
+}
+
+func (m *MsgClustMgrRenameIndex) GetName() string {
+	return m.name
+}
+
+func (m *MsgClustMgrRenameIndex) GetRespCh() MsgChannel {
+	return m.respCh
+}
+
+func (m *MsgClustMgrRenameIndex) GetString() string {
+
+	str := "\n\tMessage: MsgClustMgrRenameIndex"
+	str += fmt.Sprintf("\n\tType: %v", CLUST_MGR_RENAME_INDEX)
+	str += fmt.Sprintf("\n\tinst Ids: %v", m.instIds)
+	str += fmt.Sprintf("\n\tname: %v", m.name)
+	return str
+}
+
 // INDEXER_CANCEL_MERGE_PARTITION
 //CLUST_MGR_BUILD_INDEX_DDL
 type MsgBuildIndex struct {
@@ -1813,6 +1846,8 @@ func (m MsgType) String() string {
 		return "CLUST_MGR_MERGE_PARTITION"
 	case CLUST_MGR_PRUNE_PARTITION:
 		return "CLUST_MGR_PRUNE_PARTITION"
+	case CLUST_MGR_RENAME_INDEX:
+		return "CLUST_MGR_RENAME_INDEX"
 
 	case CBQ_CREATE_INDEX_DDL:
 		return "CBQ_CREATE_INDEX_DDL"
@@ -115,6 +115,19 @@ func (mk *MutationKeys) Size() int64 {
 	return size
 }
 
+//hasInstance returns true if mutation has an entry for partition partnId
+//of index instance, partnIdOf gives the partition of an entry.
+func (mk *MutationKeys) hasInstance(uuid c.IndexInstId, partnId c.PartitionId,
+	partnIdOf func(*Mutation) c.PartitionId) bool {
+
+	for _, m := range mk.mut {
+		if m.uuid == uuid && partnIdOf(m) == partnId {
+			return true
+		}
+	}
+	return false
+}
+
 func (mk *MutationKeys) Free() {
 	if useMutationSyncPool {
 		mk.meta.Free()
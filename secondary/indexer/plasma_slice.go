@@ -473,6 +473,24 @@ func (mdb *plasmaSlice) Delete(docid []byte, meta *MutationMeta) error {
 	return mdb.fatalDbErr
 }
 
+// LookupBackIndex implements BackIndexReader.  Writers share the back
+// store, hence the first writer, which is never stopped by writer tuning,
+// serves the lookup.
+func (mdb *plasmaSlice) LookupBackIndex(docid []byte) ([][]byte, error) {
+	if mdb.isPrimary {
+		return nil, ErrBackIndexPrimary
+	}
+
+	timeout := time.After(backIndexLookupTimeout)
+	respch := make(chan *backIndexResult, 1)
+	select {
+	case mdb.cmdCh[0] <- indexMutation{op: opLookup, docid: docid, respch: respch}:
+	case <-timeout:
+		return nil, ErrBackIndexTimeout
+	}
+	return waitBackIndexLookup(respch, 1, timeout)
+}
+
 func (mdb *plasmaSlice) handleCommandsWorker(workerId int) {
 	var start time.Time
 	var elapsed time.Duration
@@ -496,6 +514,10 @@ loop:
 				elapsed = time.Since(start)
 				mdb.totalFlushTime += elapsed
 
+			case opLookup:
+				icmd.respch <- mdb.lookupBackIndex(icmd.docid, workerId)
+				continue loop
+
 			default:
 				logging.Errorf("plasmaSlice::handleCommandsWorker \n\tSliceId %v IndexInstId %v PartitionId %v Received "+
 					"Unknown Command %v", mdb.id, mdb.idxInstId, mdb.idxPartnId, logging.TagUD(icmd))
@@ -868,6 +890,26 @@ func (mdb *plasmaSlice) deleteSecIndex(docid []byte, compareKey []byte, workerId
 	return 1, true
 }
 
+func (mdb *plasmaSlice) lookupBackIndex(docid []byte, workerId int) *backIndexResult {
+	mdb.back[workerId].Begin()
+	defer mdb.back[workerId].End()
+
+	backEntry, err := mdb.back[workerId].LookupKV(docid)
+	if err == plasma.ErrItemNotFound {
+		return &backIndexResult{}
+	} else if err != nil {
+		return &backIndexResult{err: err}
+	}
+
+	// Back entry of an array index is the array key, otherwise it is
+	// the key followed by the 2 byte count.
+	if !mdb.idxDefn.IsArrayIndex {
+		backEntry = backEntry[:len(backEntry)-2]
+	}
+	keys, err := backIndexKeys(backEntry, &mdb.idxDefn, mdb.arrayExprPosition, mdb.isArrayDistinct)
+	return &backIndexResult{keys: keys, err: err}
+}
+
 func (mdb *plasmaSlice) deleteSecArrayIndex(docid []byte, workerId int) (nmut int) {
 	var olditm []byte
 	var err error
@@ -30,6 +30,13 @@ const RebalanceMetakvDir = c.IndexingMetaDir + "rebalance/"
 const RebalanceTokenPath = RebalanceMetakvDir + RebalanceTokenTag
 const MoveIndexTokenPath = RebalanceMetakvDir + MoveIndexTokenTag
 
+const RebalanceControlTag = "RebalanceControl"
+const RebalanceControlPath = RebalanceMetakvDir + RebalanceControlTag
+
+//rebalance plan is kept outside of RebalanceMetakvDir so that
+//it is not observed along with the transfer tokens
+const RebalancePlanPath = c.IndexingMetaDir + "rebalancePlan"
+
 type RebalSource byte
 
 const (
@@ -56,6 +63,33 @@ type RebalanceToken struct {
 	MasterIP string
 }
 
+//RebalanceControl allows the user to pause/resume and throttle
+//an in-progress rebalance. Zero value of TransferBatchSize and
+//MaxConcurrentBuilds means the configured setting is used. When
+//paused with PauseBuilds, the index builds in progress are stopped
+//and their transfers are restarted on resume. Stopping a build drops
+//the partially built index, so it is rebuilt from scratch on resume;
+//pause without PauseBuilds lets the builds in progress complete.
+type RebalanceControl struct {
+	RebalId             string
+	Paused              bool
+	PauseBuilds         bool
+	TransferBatchSize   int
+	MaxConcurrentBuilds int
+}
+
+//RebalancePlan records the transfer tokens yet to be completed for a
+//rebalance, so that a restarted rebalance with the same topology change
+//can resume from it instead of running the planner again. The state of
+//a published token is read from its own entry in metakv on resume.
+type RebalancePlan struct {
+	RebalId        string
+	KeepNodes      []string
+	EjectNodes     []string
+	TransferTokens map[string]*c.TransferToken
+	Timestamp      int64
+}
+
 type RebalTokens struct {
 	RT *RebalanceToken             `json:"rebalancetoken,omitempty"`
 	MT *RebalanceToken             `json:"moveindextoken,omitempty"`
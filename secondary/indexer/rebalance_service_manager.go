@@ -39,6 +39,7 @@ import (
 	l "github.com/couchbase/indexing/secondary/logging"
 	"github.com/couchbase/indexing/secondary/manager"
 	"github.com/couchbase/indexing/secondary/manager/client"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
 )
 
 //RebalanceMgr manages the integration with ns-server and
@@ -179,6 +180,7 @@ func (m *ServiceMgr) initService(cleanupPending bool) {
 	mux.HandleFunc("/registerRebalanceToken", m.handleRegisterRebalanceToken)
 	mux.HandleFunc("/listRebalanceTokens", m.handleListRebalanceTokens)
 	mux.HandleFunc("/cleanupRebalance", m.handleCleanupRebalance)
+	mux.HandleFunc("/rebalanceControl", m.handleRebalanceControl)
 	mux.HandleFunc("/moveIndex", m.handleMoveIndex)
 	mux.HandleFunc("/moveIndexInternal", m.handleMoveIndexInternal)
 	mux.HandleFunc("/nodeuuid", m.handleNodeuuid)
@@ -504,6 +506,8 @@ func (m *ServiceMgr) prepareRebalance(change service.TopologyChange) error {
 		return err
 	}
 
+	m.discardRebalancePlan(&change)
+
 	if m.rebalanceToken != nil && m.rebalanceToken.Source == RebalSourceClusterOp {
 		l.Warnf("ServiceMgr::prepareRebalance Found Rebalance In Progress. Cleanup.")
 		if m.rebalancerF != nil {
@@ -795,6 +799,11 @@ func (m *ServiceMgr) runCleanupPhaseLOCKED(path string, isMaster bool) error {
 		if err != nil {
 			return err
 		}
+
+		err = m.cleanupRebalanceControl()
+		if err != nil {
+			return err
+		}
 	}
 
 	if m.indexerReady {
@@ -846,6 +855,80 @@ func (m *ServiceMgr) cleanupGlobalRToken(path string) error {
 	return nil
 }
 
+func (m *ServiceMgr) cleanupRebalanceControl() error {
+
+	var control RebalanceControl
+	found, err := MetakvGet(RebalanceControlPath, &control)
+	if err != nil {
+		l.Errorf("ServiceMgr::cleanupRebalanceControl Error Fetching Rebalance Control From Metakv %v", err)
+		return err
+	}
+
+	if found {
+		l.Infof("ServiceMgr::cleanupRebalanceControl Delete Rebalance Control %v", control)
+
+		err := MetakvDel(RebalanceControlPath)
+		if err != nil {
+			l.Errorf("ServiceMgr::cleanupRebalanceControl Unable to delete RebalanceControl from "+
+				"Meta Storage. %v. Err %v", control, err)
+			return err
+		}
+	}
+	return nil
+}
+
+//getResumablePlan returns the plan of a failed rebalance if it can still be
+//resumed. Indexes of the transfers in progress for such a plan are not cleaned up.
+func (m *ServiceMgr) getResumablePlan() *RebalancePlan {
+
+	var plan RebalancePlan
+	found, err := MetakvGet(RebalancePlanPath, &plan)
+	if err != nil {
+		l.Errorf("ServiceMgr::getResumablePlan Error Fetching Rebalance Plan %v", err)
+		return nil
+	}
+
+	if !found || !isResumablePlan(&plan, m.config.Load()) {
+		return nil
+	}
+
+	return &plan
+}
+
+//discardRebalancePlan deletes the plan of a failed rebalance which cannot be
+//resumed by the given topology change, so that the indexes retained for it
+//are cleaned up before the topology change starts.
+func (m *ServiceMgr) discardRebalancePlan(change *service.TopologyChange) {
+
+	plan := m.getResumablePlan()
+	if plan == nil || isSamePlanChange(plan, change) {
+		return
+	}
+
+	l.Infof("ServiceMgr::discardRebalancePlan Topology Change Mismatch. Discard Plan %v", plan.RebalId)
+
+	if err := MetakvDel(RebalancePlanPath); err != nil {
+		l.Errorf("ServiceMgr::discardRebalancePlan Unable to delete rebalance plan. Err %v", err)
+		return
+	}
+
+	if !m.indexerReady {
+		return
+	}
+
+	rtokens, err := m.getCurrRebalTokens()
+	if err != nil {
+		l.Errorf("ServiceMgr::discardRebalancePlan Error Fetching Metakv Tokens %v", err)
+		return
+	}
+
+	if rtokens != nil && len(rtokens.TT) != 0 {
+		if err := m.cleanupTransferTokens(rtokens.TT); err != nil {
+			l.Errorf("ServiceMgr::discardRebalancePlan Error Cleaning Transfer Tokens %v", err)
+		}
+	}
+}
+
 func (m *ServiceMgr) cleanupOrphanTokens(change service.TopologyChange) error {
 
 	rtokens, err := m.getCurrRebalTokens()
@@ -948,9 +1031,16 @@ func (m *ServiceMgr) cleanupTransferTokens(tts map[string]*c.TransferToken) erro
 	}
 	<-respch
 
+	plan := m.getResumablePlan()
+
 	// cleanup transfer token
 	for ttid, tt := range tts {
 
+		if tt.DestId == string(m.nodeInfo.NodeID) && isRetainedForResume(plan, ttid, tt) {
+			l.Infof("ServiceMgr::cleanupTransferTokens Retain For Resume %v %v", ttid, tt)
+			continue
+		}
+
 		l.Infof("ServiceMgr::cleanupTransferTokens Cleaning Up %v %v", ttid, tt)
 
 		if tt.MasterId == string(m.nodeInfo.NodeID) {
@@ -1831,7 +1921,11 @@ func (m *ServiceMgr) checkLocalCleanupPending() bool {
 	}
 
 	if rtokens != nil && len(rtokens.TT) != 0 {
-		for _, tt := range rtokens.TT {
+		plan := m.getResumablePlan()
+		for ttid, tt := range rtokens.TT {
+			if isRetainedForResume(plan, ttid, tt) {
+				continue
+			}
 			ownerId := m.getTransferTokenOwner(tt)
 			if ownerId == string(m.nodeInfo.NodeID) {
 				l.Infof("ServiceMgr::checkLocalCleanupPending Found Local Pending Cleanup Token %v", tt)
@@ -1920,6 +2014,98 @@ func (m *ServiceMgr) handleListRebalanceTokens(w http.ResponseWriter, r *http.Re
 
 }
 
+//
+// handleRebalanceControl returns (GET) or updates (POST) the control settings of
+// the rebalance in progress. The settings are published in metakv and applied by
+// the rebalance master, so the request can be sent to any indexer node.
+//
+func (m *ServiceMgr) handleRebalanceControl(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := m.validateAuth(w, r)
+	if !ok {
+		l.Errorf("ServiceMgr::handleRebalanceControl Validation Failure for Request %v", l.TagUD(r))
+		return
+	}
+
+	var rtoken RebalanceToken
+	found, err := MetakvGet(RebalanceTokenPath, &rtoken)
+	if err == nil && !found {
+		found, err = MetakvGet(MoveIndexTokenPath, &rtoken)
+	}
+	if err != nil {
+		send(http.StatusInternalServerError, w, err.Error())
+		return
+	}
+
+	if r.Method == "GET" {
+
+		if !c.IsAllowed(creds, []string{"cluster.settings!read"}, w) {
+			return
+		}
+
+		if !found {
+			send(http.StatusNotFound, w, "No Rebalance In Progress")
+			return
+		}
+
+		var control RebalanceControl
+		if _, err := MetakvGet(RebalanceControlPath, &control); err != nil {
+			send(http.StatusInternalServerError, w, err.Error())
+			return
+		}
+
+		if control.RebalId != rtoken.RebalId {
+			control = RebalanceControl{RebalId: rtoken.RebalId}
+		}
+
+		out, err := json.Marshal(&control)
+		if err != nil {
+			m.writeError(w, err)
+			return
+		}
+		m.writeJson(w, out)
+
+	} else if r.Method == "POST" {
+
+		if !c.IsAllowed(creds, []string{"cluster.settings!write"}, w) {
+			return
+		}
+
+		if !found {
+			send(http.StatusNotFound, w, "No Rebalance In Progress")
+			return
+		}
+
+		var control RebalanceControl
+		bytes, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(bytes, &control); err != nil {
+			send(http.StatusBadRequest, w, err.Error())
+			return
+		}
+
+		if control.TransferBatchSize < 0 || control.MaxConcurrentBuilds < 0 {
+			send(http.StatusBadRequest, w, "Bad Request - TransferBatchSize and MaxConcurrentBuilds cannot be negative")
+			return
+		}
+
+		if !control.Paused {
+			control.PauseBuilds = false
+		}
+		control.RebalId = rtoken.RebalId
+
+		l.Infof("ServiceMgr::handleRebalanceControl New Rebalance Control %v", control)
+
+		if err := MetakvSet(RebalanceControlPath, &control); err != nil {
+			send(http.StatusInternalServerError, w, err.Error())
+			return
+		}
+		m.writeOk(w)
+
+	} else {
+		m.writeError(w, errors.New("Unsupported method"))
+	}
+}
+
 func (m *ServiceMgr) handleCleanupRebalance(w http.ResponseWriter, r *http.Request) {
 
 	_, ok := m.validateAuth(w, r)
@@ -2020,6 +2206,9 @@ func (m *ServiceMgr) getCurrRebalTokens() (*RebalTokens, error) {
 			json.Unmarshal(kv.Value, &mt)
 			rinfo.MT = &mt
 
+		} else if strings.Contains(kv.Path, RebalanceControlTag) {
+			continue
+
 		} else if strings.Contains(kv.Path, TransferTokenTag) {
 			ttidpos := strings.Index(kv.Path, TransferTokenTag)
 			ttid := kv.Path[ttidpos:]
@@ -2299,6 +2488,7 @@ func (m *ServiceMgr) handleMoveIndex(w http.ResponseWriter, r *http.Request) {
 		if errStr != "" {
 			sendIndexResponseWithError(code, w, errStr)
 		} else {
+			m.recordMoveIndexHistory(creds, r, &req)
 			sendIndexResponseMsg(w, MoveIndexStarted)
 		}
 	} else {
@@ -2337,6 +2527,7 @@ func (m *ServiceMgr) handleMoveIndexInternal(w http.ResponseWriter, r *http.Requ
 		if errStr != "" {
 			sendIndexResponseWithError(code, w, errStr)
 		} else {
+			m.recordMoveIndexHistory(creds, r, &req)
 			sendIndexResponseMsg(w, MoveIndexStarted)
 		}
 
@@ -2370,6 +2561,36 @@ func (m *ServiceMgr) doHandleMoveIndex(req *manager.IndexRequest) (int, string)
 	}
 }
 
+//
+// Record the move index request in DDL history.  The index definition
+// after the move has the destination nodes of the move.
+//
+func (m *ServiceMgr) recordMoveIndexHistory(creds cbauth.Creds, r *http.Request, req *manager.IndexRequest) {
+
+	topology, err := getGlobalTopology(m.localhttp)
+	if err != nil {
+		l.Warnf("ServiceMgr::recordMoveIndexHistory Fail to record move index %v: %v", req.IndexIds.DefnIds, err)
+		return
+	}
+
+	defnId := c.IndexDefnId(req.IndexIds.DefnIds[0])
+	nodes, _ := validateMoveIndexReq(req)
+
+	for _, localMeta := range topology.Metadata {
+		for _, defn := range localMeta.IndexDefinitions {
+			if defn.DefnId == defnId {
+				before := defn
+				after := defn
+				after.Nodes = nodes
+
+				context := mc.DDLHistoryContext{Source: mc.DDLHistorySourceREST, Node: m.localhttp, RemoteAddr: r.RemoteAddr}
+				mc.RecordDDLHistory(mc.DDLHistoryOpMove, creds.Name(), context, &before, &after)
+				return
+			}
+		}
+	}
+}
+
 func (m *ServiceMgr) monitorMoveIndex() {
 	select {
 	case err := <-m.moveStatusCh:
@@ -34,6 +34,12 @@ import (
 	"github.com/couchbase/indexing/secondary/planner"
 )
 
+//metakv and http access of the rebalancer, replaced in tests.
+var rebalanceMetakvGet = MetakvGet
+var rebalanceMetakvSet = MetakvSet
+var rebalanceMetakvDel = MetakvDel
+var rebalancePostWithAuth = postWithAuth
+
 type DoneCallback func(err error, cancel <-chan struct{})
 type ProgressCallback func(progress float64, cancel <-chan struct{})
 
@@ -87,8 +93,14 @@ type Rebalancer struct {
 	change     *service.TopologyChange
 	runPlanner bool
 
-	transferTokenBatches [][]string
-	currBatchTokens      []string
+	pendingTokens   []string
+	currBatchTokens []string
+
+	control       RebalanceControl
+	heldTokens    map[string]*c.TransferToken
+	batchDeferred bool
+
+	resumedTokens map[string]bool
 }
 
 func NewRebalancer(transferTokens map[string]*c.TransferToken, rebalToken *RebalanceToken,
@@ -125,7 +137,9 @@ func NewRebalancer(transferTokens map[string]*c.TransferToken, rebalToken *Rebal
 		change:     change,
 		runPlanner: runPlanner,
 
-		transferTokenBatches: make([][]string, 0),
+		pendingTokens: make([]string, 0),
+		heldTokens:    make(map[string]*c.TransferToken),
+		resumedTokens: make(map[string]bool),
 	}
 
 	r.config.Store(config)
@@ -178,6 +192,11 @@ func (r *Rebalancer) initRebalAsync() {
 
 					l.Infof("Rebalancer::initRebalAsync Global Topology %v", topology)
 
+					if r.transferTokens = r.resumeTransferTokens(topology); r.transferTokens != nil {
+						l.Infof("Rebalancer::initRebalAsync Resume Pending Transfers From Previous Rebalance")
+						break loop
+					}
+
 					onEjectOnly := cfg["rebalance.node_eject_only"].Bool()
 					disableReplicaRepair := cfg["rebalance.disable_replica_repair"].Bool()
 					timeout := cfg["planner.timeout"].Int()
@@ -276,7 +295,7 @@ func (r *Rebalancer) doRebalance() {
 			return
 
 		default:
-			r.createTransferBatches()
+			r.queueTransferTokens()
 			r.publishTransferTokenBatch()
 			close(r.waitForTokenPublish)
 			go r.observeRebalance()
@@ -289,29 +308,23 @@ func (r *Rebalancer) doRebalance() {
 
 }
 
-func (r *Rebalancer) createTransferBatches() {
+func (r *Rebalancer) queueTransferTokens() {
 
-	cfg := r.config.Load()
-	batchSize := cfg["rebalance.transferBatchSize"].Int()
-
-	var batch []string
+	//transfers resumed from a previous rebalance go first, as their
+	//indexes are already on the destination
 	for ttid, _ := range r.transferTokens {
-		if batch == nil {
-			batch = make([]string, 0, batchSize)
-		}
-		batch = append(batch, ttid)
-
-		if len(batch) == batchSize {
-			r.transferTokenBatches = append(r.transferTokenBatches, batch)
-			batch = nil
+		if r.resumedTokens[ttid] {
+			r.pendingTokens = append(r.pendingTokens, ttid)
 		}
 	}
 
-	if len(batch) != 0 {
-		r.transferTokenBatches = append(r.transferTokenBatches, batch)
+	for ttid, _ := range r.transferTokens {
+		if !r.resumedTokens[ttid] {
+			r.pendingTokens = append(r.pendingTokens, ttid)
+		}
 	}
 
-	l.Infof("Rebalancer::createTransferBatches Transfer Batches %v", r.transferTokenBatches)
+	l.Infof("Rebalancer::queueTransferTokens Pending Transfer Tokens %v", r.pendingTokens)
 }
 
 func (r *Rebalancer) publishTransferTokenBatch() {
@@ -319,8 +332,20 @@ func (r *Rebalancer) publishTransferTokenBatch() {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
-	r.currBatchTokens = r.transferTokenBatches[0]
-	r.transferTokenBatches = r.transferTokenBatches[1:]
+	r.publishTransferTokenBatchLOCKED()
+}
+
+func (r *Rebalancer) publishTransferTokenBatchLOCKED() {
+
+	if r.control.Paused {
+		l.Infof("Rebalancer::publishTransferTokenBatch Rebalance Paused. Defer Publishing "+
+			"Pending Transfer Tokens %v", r.pendingTokens)
+		r.batchDeferred = true
+		return
+	}
+
+	r.batchDeferred = false
+	r.currBatchTokens = r.nextTransferTokenBatchLOCKED()
 
 	l.Infof("Rebalancer::publishTransferTokenBatch Registered Transfer Token In Metakv %v", r.currBatchTokens)
 
@@ -328,6 +353,44 @@ func (r *Rebalancer) publishTransferTokenBatch() {
 		setTransferTokenInMetakv(ttid, r.transferTokens[ttid])
 	}
 
+	r.savePlanLOCKED()
+}
+
+//nextTransferTokenBatchLOCKED picks the next batch from the pending tokens.
+//Batch size and concurrent builds per destination are read on every batch,
+//so they can be adjusted while rebalance is running.
+func (r *Rebalancer) nextTransferTokenBatchLOCKED() []string {
+
+	cfg := r.config.Load()
+	batchSize := cfg["rebalance.transferBatchSize"].Int()
+	maxBuilds := cfg["rebalance.maxConcurrentBuilds"].Int()
+
+	if r.control.TransferBatchSize > 0 {
+		batchSize = r.control.TransferBatchSize
+	}
+	if r.control.MaxConcurrentBuilds > 0 {
+		maxBuilds = r.control.MaxConcurrentBuilds
+	}
+
+	builds := make(map[string]int)
+	batch := make([]string, 0)
+	remaining := make([]string, 0)
+
+	for _, ttid := range r.pendingTokens {
+		destId := r.transferTokens[ttid].DestId
+
+		if (batchSize > 0 && len(batch) >= batchSize) ||
+			(maxBuilds > 0 && builds[destId] >= maxBuilds) {
+			remaining = append(remaining, ttid)
+			continue
+		}
+
+		batch = append(batch, ttid)
+		builds[destId]++
+	}
+
+	r.pendingTokens = remaining
+	return batch
 }
 
 func (r *Rebalancer) observeRebalance() {
@@ -356,6 +419,10 @@ func (r *Rebalancer) processTokens(path string, value []byte, rev interface{}) e
 			r.cancelMetakv()
 			r.finish(nil)
 		}
+	} else if path == RebalanceControlPath {
+		if value != nil {
+			r.processControlToken(value)
+		}
 	} else if strings.Contains(path, TransferTokenTag) {
 		if value != nil {
 			ttid, tt, err := r.decodeTransferToken(path, value)
@@ -373,6 +440,150 @@ func (r *Rebalancer) processTokens(path string, value []byte, rev interface{}) e
 
 }
 
+func (r *Rebalancer) processControlToken(value []byte) {
+
+	var control RebalanceControl
+	if err := json.Unmarshal(value, &control); err != nil {
+		l.Errorf("Rebalancer::processControlToken Unable to decode control token %v. Ignored", err)
+		return
+	}
+
+	if control.RebalId != r.rebalToken.RebalId {
+		l.Warnf("Rebalancer::processControlToken Found RebalanceControl with Unknown "+
+			"RebalanceId. Local RId %v Control %v. Ignored.", r.rebalToken.RebalId, control)
+		return
+	}
+
+	if !r.addToWaitGroup() {
+		return
+	}
+
+	defer r.wg.Done()
+
+	l.Infof("Rebalancer::processControlToken RebalanceControl %v", control)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.control = control
+
+	if control.Paused && control.PauseBuilds {
+		r.stopIndexBuildsLOCKED()
+		return
+	}
+
+	for ttid, tt := range r.heldTokens {
+		if tt.State == c.TransferTokenCreated {
+			l.Infof("Rebalancer::processControlToken Process Held TransferToken %v", ttid)
+			if r.addToWaitGroup() {
+				go func(ttid string, tt *c.TransferToken) {
+					defer r.wg.Done()
+					r.processTransferToken(ttid, tt)
+				}(ttid, tt)
+			}
+			continue
+		}
+
+		l.Infof("Rebalancer::processControlToken Initiate Held TransferToken %v", ttid)
+		tt.State = c.TransferTokenInitate
+		setTransferTokenInMetakv(ttid, tt)
+	}
+	r.heldTokens = make(map[string]*c.TransferToken)
+
+	if !r.master {
+		return
+	}
+
+	if r.cb.progress != nil {
+		go r.progressInitOnce.Do(r.updateProgress)
+	}
+
+	if !control.Paused && r.batchDeferred {
+		r.publishTransferTokenBatchLOCKED()
+	}
+}
+
+//stopIndexBuildsLOCKED stops the index builds in progress on this node when
+//rebalance is paused with builds. The partially built index is dropped and its
+//token goes back to created state, where it is held until rebalance is resumed.
+//The build progress is not kept, the index is rebuilt from scratch on resume.
+func (r *Rebalancer) stopIndexBuildsLOCKED() {
+
+	for ttid, tt := range r.acceptedTokens {
+		if tt.State != c.TransferTokenInProgress {
+			continue
+		}
+
+		delete(r.acceptedTokens, ttid)
+		atomic.AddInt32(&r.pendingBuild, -1)
+
+		if r.addToWaitGroup() {
+			go r.stopIndexBuild(ttid, *tt)
+		}
+	}
+}
+
+func (r *Rebalancer) stopIndexBuild(ttid string, tt c.TransferToken) {
+
+	defer r.wg.Done()
+
+	l.Infof("Rebalancer::stopIndexBuild Rebalance Paused. Stop Index Build %v %v", ttid, tt)
+
+	defn := tt.IndexInst.Defn
+	defn.InstId = tt.InstId
+	defn.RealInstId = tt.RealInstId
+	req := manager.IndexRequest{Index: defn}
+	body, err := json.Marshal(&req)
+	if err != nil {
+		l.Errorf("Rebalancer::stopIndexBuild Error marshal drop index %v", err)
+		r.setTransferTokenError(ttid, &tt, err.Error())
+		return
+	}
+
+	bodybuf := bytes.NewBuffer(body)
+
+	url := "/dropIndex"
+	resp, err := rebalancePostWithAuth(r.localaddr+url, "application/json", bodybuf)
+	if err != nil {
+		l.Errorf("Rebalancer::stopIndexBuild Error drop index on %v %v", r.localaddr+url, err)
+		r.setTransferTokenError(ttid, &tt, err.Error())
+		return
+	}
+
+	response := new(manager.IndexResponse)
+	if err := convertResponse(resp, response); err != nil {
+		l.Errorf("Rebalancer::stopIndexBuild Error unmarshal response %v %v", r.localaddr+url, err)
+		r.setTransferTokenError(ttid, &tt, err.Error())
+		return
+	}
+
+	if response.Code == manager.RESP_ERROR {
+		l.Errorf("Rebalancer::stopIndexBuild Error dropping index %v %v", r.localaddr+url, response.Error)
+		r.setTransferTokenError(ttid, &tt, response.Error)
+		return
+	}
+
+	tt.State = c.TransferTokenCreated
+	setTransferTokenInMetakv(ttid, &tt)
+}
+
+//holdTransferToken keeps a token from starting the transfer while rebalance
+//is paused with builds. An accepted token is not initiated by the master and
+//a created token is not accepted by the destination.
+func (r *Rebalancer) holdTransferToken(ttid string, tt *c.TransferToken) bool {
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.control.Paused && r.control.PauseBuilds {
+		l.Infof("Rebalancer::holdTransferToken Rebalance Paused. Hold TransferToken %v", ttid)
+		r.heldTokens[ttid] = tt
+		return true
+	}
+
+	return false
+}
+
 func (r *Rebalancer) processTransferToken(ttid string, tt *c.TransferToken) {
 
 	if !r.addToWaitGroup() {
@@ -615,7 +826,7 @@ loop:
 			bodybuf := bytes.NewBuffer(body)
 
 			url := "/dropIndex"
-			resp, err := postWithAuth(r.localaddr+url, "application/json", bodybuf)
+			resp, err := rebalancePostWithAuth(r.localaddr+url, "application/json", bodybuf)
 			if err != nil {
 				l.Errorf("Rebalancer::dropIndexWhenIdle Error drop index on %v %v", r.localaddr+url, err)
 				r.setTransferTokenError(ttid, tt, err.Error())
@@ -693,39 +904,22 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 	switch tt.State {
 	case c.TransferTokenCreated:
 
-		indexDefn := tt.IndexInst.Defn
-		indexDefn.Nodes = nil
-		indexDefn.Deferred = true
-		indexDefn.InstId = tt.InstId
-		indexDefn.RealInstId = tt.RealInstId
-
-		ir := manager.IndexRequest{Index: indexDefn}
-		body, err := json.Marshal(&ir)
-		if err != nil {
-			l.Errorf("Rebalancer::processTokenAsDest Error marshal clone index %v", err)
-			r.setTransferTokenError(ttid, tt, err.Error())
+		if r.holdTransferToken(ttid, tt) {
 			return true
 		}
 
-		bodybuf := bytes.NewBuffer(body)
-
-		url := "/createIndexRebalance"
-		resp, err := postWithAuth(r.localaddr+url, "application/json", bodybuf)
+		//a transfer resumed from a previous rebalance keeps the index
+		//created on this node, so it is built from where it was left
+		created, err := r.isIndexCreated(tt)
 		if err != nil {
-			l.Errorf("Rebalancer::processTokenAsDest Error register clone index on %v %v", r.localaddr+url, err)
+			l.Errorf("Rebalancer::processTokenAsDest Error checking clone index %v", err)
 			r.setTransferTokenError(ttid, tt, err.Error())
 			return true
 		}
 
-		response := new(manager.IndexResponse)
-		if err := convertResponse(resp, response); err != nil {
-			l.Errorf("Rebalancer::processTokenAsDest Error unmarshal response %v %v", r.localaddr+url, err)
-			r.setTransferTokenError(ttid, tt, err.Error())
-			return true
-		}
-		if response.Code == manager.RESP_ERROR {
-			l.Errorf("Rebalancer::processTokenAsDest Error cloning index %v %v", r.localaddr+url, response.Error)
-			r.setTransferTokenError(ttid, tt, response.Error)
+		if created {
+			l.Infof("Rebalancer::processTokenAsDest Resume TransferToken With Existing Index %v %v", ttid, tt)
+		} else if !r.cloneIndex(ttid, tt) {
 			return true
 		}
 
@@ -778,6 +972,63 @@ func (r *Rebalancer) processTokenAsDest(ttid string, tt *c.TransferToken) bool {
 	return true
 }
 
+func (r *Rebalancer) cloneIndex(ttid string, tt *c.TransferToken) bool {
+
+	indexDefn := tt.IndexInst.Defn
+	indexDefn.Nodes = nil
+	indexDefn.Deferred = true
+	indexDefn.InstId = tt.InstId
+	indexDefn.RealInstId = tt.RealInstId
+
+	ir := manager.IndexRequest{Index: indexDefn}
+	body, err := json.Marshal(&ir)
+	if err != nil {
+		l.Errorf("Rebalancer::cloneIndex Error marshal clone index %v", err)
+		r.setTransferTokenError(ttid, tt, err.Error())
+		return false
+	}
+
+	bodybuf := bytes.NewBuffer(body)
+
+	url := "/createIndexRebalance"
+	resp, err := rebalancePostWithAuth(r.localaddr+url, "application/json", bodybuf)
+	if err != nil {
+		l.Errorf("Rebalancer::cloneIndex Error register clone index on %v %v", r.localaddr+url, err)
+		r.setTransferTokenError(ttid, tt, err.Error())
+		return false
+	}
+
+	response := new(manager.IndexResponse)
+	if err := convertResponse(resp, response); err != nil {
+		l.Errorf("Rebalancer::cloneIndex Error unmarshal response %v %v", r.localaddr+url, err)
+		r.setTransferTokenError(ttid, tt, err.Error())
+		return false
+	}
+	if response.Code == manager.RESP_ERROR {
+		l.Errorf("Rebalancer::cloneIndex Error cloning index %v %v", r.localaddr+url, response.Error)
+		r.setTransferTokenError(ttid, tt, response.Error)
+		return false
+	}
+
+	return true
+}
+
+func (r *Rebalancer) isIndexCreated(tt *c.TransferToken) (bool, error) {
+
+	localMeta, err := getLocalMeta(r.localaddr)
+	if err != nil {
+		return false, err
+	}
+
+	topology := findTopologyByBucket(localMeta.IndexTopologies, tt.IndexInst.Defn.Bucket)
+	if topology == nil {
+		return false, nil
+	}
+
+	state, _ := topology.GetStatusByInst(tt.IndexInst.Defn.DefnId, tt.InstId)
+	return state != c.INDEX_STATE_NIL, nil
+}
+
 func (r *Rebalancer) checkValidNotifyStateDest(ttid string, tt *c.TransferToken) bool {
 
 	r.mu.Lock()
@@ -855,7 +1106,7 @@ func (r *Rebalancer) buildAcceptedIndexes() {
 	body, _ := json.Marshal(&ir)
 	bodybuf := bytes.NewBuffer(body)
 
-	resp, err := postWithAuth(r.localaddr+url, "application/json", bodybuf)
+	resp, err := rebalancePostWithAuth(r.localaddr+url, "application/json", bodybuf)
 	if err != nil {
 		l.Errorf("Rebalancer::buildAcceptedIndexes Error register clone index on %v %v", r.localaddr+url, err)
 		errStr = err.Error()
@@ -1123,6 +1374,10 @@ func (r *Rebalancer) processTokenAsMaster(ttid string, tt *c.TransferToken) bool
 	switch tt.State {
 
 	case c.TransferTokenAccepted:
+		if r.holdTransferToken(ttid, tt) {
+			return true
+		}
+
 		tt.State = c.TransferTokenInitate
 		setTransferTokenInMetakv(ttid, tt)
 
@@ -1140,7 +1395,7 @@ func (r *Rebalancer) processTokenAsMaster(ttid string, tt *c.TransferToken) bool
 		r.updateMasterTokenState(ttid, c.TransferTokenCommit)
 
 	case c.TransferTokenDeleted:
-		err := MetakvDel(RebalanceMetakvDir + ttid)
+		err := rebalanceMetakvDel(RebalanceMetakvDir + ttid)
 		if err != nil {
 			l.Fatalf("Rebalancer::processTokenAsMaster Unable to set TransferToken In "+
 				"Meta Storage. %v. Err %v", tt, err)
@@ -1154,6 +1409,7 @@ func (r *Rebalancer) processTokenAsMaster(ttid string, tt *c.TransferToken) bool
 				r.cb.progress(1.0, r.cancel)
 			}
 			l.Infof("Rebalancer::processTokenAsMaster No Tokens Found. Mark Done.")
+			r.deletePlan()
 			r.cancelMetakv()
 			go r.finish(nil)
 		} else {
@@ -1186,6 +1442,11 @@ func (r *Rebalancer) updateMasterTokenState(ttid string, state c.TokenState) boo
 		return false
 	}
 
+	//completed transfer is not resumed
+	if state == c.TransferTokenDeleted {
+		r.savePlanLOCKED()
+	}
+
 	return true
 }
 
@@ -1195,7 +1456,7 @@ func setTransferTokenInMetakv(ttid string, tt *c.TransferToken) {
 		if r > 0 {
 			l.Warnf("Rebalancer::setTransferTokenInMetakv error=%v Retrying (%d)", err, r)
 		}
-		err = MetakvSet(RebalanceMetakvDir+ttid, tt)
+		err = rebalanceMetakvSet(RebalanceMetakvDir+ttid, tt)
 		return err
 	}
 
@@ -1328,6 +1589,241 @@ func (r *Rebalancer) checkCurrBatchDone() bool {
 	return true
 }
 
+func (r *Rebalancer) savePlanLOCKED() {
+
+	//only rebalance triggered by topology change can be resumed
+	if !r.runPlanner || r.change == nil {
+		return
+	}
+
+	plan := &RebalancePlan{
+		RebalId:        r.rebalToken.RebalId,
+		KeepNodes:      getKeepNodeIds(r.change),
+		EjectNodes:     getEjectNodeIds(r.change),
+		TransferTokens: make(map[string]*c.TransferToken),
+		Timestamp:      time.Now().UnixNano(),
+	}
+
+	for ttid, tt := range r.transferTokens {
+		if tt.State != c.TransferTokenCommit && tt.State != c.TransferTokenDeleted {
+			plan.TransferTokens[ttid] = tt
+		}
+	}
+
+	if err := rebalanceMetakvSet(RebalancePlanPath, plan); err != nil {
+		l.Errorf("Rebalancer::savePlan Unable to save rebalance plan. Err %v", err)
+	}
+}
+
+func (r *Rebalancer) deletePlan() {
+
+	if !r.runPlanner {
+		return
+	}
+
+	if err := rebalanceMetakvDel(RebalancePlanPath); err != nil {
+		l.Errorf("Rebalancer::deletePlan Unable to delete rebalance plan. Err %v", err)
+	}
+}
+
+//resumeTransferTokens returns the pending transfer tokens saved by a previous
+//rebalance with the same topology change. A token which was in progress has its
+//index kept on the destination (see isRetainedForResume), so the transfer resumes
+//from there under the same token id. Any other pending transfer is restarted.
+func (r *Rebalancer) resumeTransferTokens(topology *manager.ClusterIndexMetadata) map[string]*c.TransferToken {
+
+	var plan RebalancePlan
+	found, err := rebalanceMetakvGet(RebalancePlanPath, &plan)
+	if err != nil {
+		l.Errorf("Rebalancer::resumeTransferTokens Error Fetching Rebalance Plan %v", err)
+		return nil
+	}
+
+	if !found {
+		return nil
+	}
+
+	if !isResumablePlan(&plan, r.config.Load()) {
+		l.Infof("Rebalancer::resumeTransferTokens Resume Disabled Or Plan Expired. Discard Plan %v", plan.RebalId)
+		r.deletePlan()
+		return nil
+	}
+
+	if !isSamePlanChange(&plan, r.change) {
+		l.Infof("Rebalancer::resumeTransferTokens Topology Change Mismatch. Discard Plan %v", plan.RebalId)
+		r.deletePlan()
+		return nil
+	}
+
+	tokens := make(map[string]*c.TransferToken)
+	for ttid, tt := range plan.TransferTokens {
+
+		var curr c.TransferToken
+		found, err := rebalanceMetakvGet(RebalanceMetakvDir+ttid, &curr)
+		if err != nil {
+			l.Errorf("Rebalancer::resumeTransferTokens Error Fetching TransferToken %v %v. Discard Plan %v",
+				ttid, err, plan.RebalId)
+			r.deletePlan()
+			return nil
+		}
+
+		if found && isRetainedForResume(&plan, ttid, &curr) {
+			l.Infof("Rebalancer::resumeTransferTokens Resume TransferToken %v From %v", ttid, curr)
+			tt = &curr
+			r.resumedTokens[ttid] = true
+
+		} else {
+			defn := &tt.IndexInst.Defn
+
+			pending := !hasIndexPartitions(topology, tt.DestId, defn.DefnId, tt.InstId, defn.Partitions)
+			if pending && tt.RealInstId != 0 {
+				pending = !hasIndexPartitions(topology, tt.DestId, defn.DefnId, tt.RealInstId, defn.Partitions)
+			}
+			if pending && tt.TransferMode == c.TokenTransferModeMove && len(tt.SourceId) != 0 {
+				pending = hasIndexPartitions(topology, tt.SourceId, defn.DefnId, tt.IndexInst.InstId, defn.Partitions)
+			}
+
+			if !pending {
+				l.Infof("Rebalancer::resumeTransferTokens Skip Completed Or Obsolete TransferToken %v %v", ttid, tt)
+				continue
+			}
+
+			l.Infof("Rebalancer::resumeTransferTokens Restart TransferToken %v %v", ttid, tt)
+		}
+
+		//the destination accepts a resumed token with the index it already has
+		tt.MasterId = r.nodeId
+		tt.RebalId = r.rebalToken.RebalId
+		tt.State = c.TransferTokenCreated
+		tt.Error = ""
+		tokens[ttid] = tt
+	}
+
+	if len(tokens) == 0 {
+		r.deletePlan()
+		return nil
+	}
+
+	return tokens
+}
+
+//isResumablePlan returns true if resume is enabled and the plan has not expired.
+func isResumablePlan(plan *RebalancePlan, cfg c.Config) bool {
+
+	if !cfg["rebalance.resumeOnRestart"].Bool() {
+		return false
+	}
+
+	timeout := time.Duration(cfg["rebalance.resumeTimeout"].Int()) * time.Second
+	if timeout > 0 && time.Since(time.Unix(0, plan.Timestamp)) > timeout {
+		return false
+	}
+
+	return true
+}
+
+func isSamePlanChange(plan *RebalancePlan, change *service.TopologyChange) bool {
+
+	return isSameNodeIds(plan.KeepNodes, getKeepNodeIds(change)) &&
+		isSameNodeIds(plan.EjectNodes, getEjectNodeIds(change))
+}
+
+//isRetainedForResume returns true if the transfer token is in progress for the
+//plan of a failed rebalance. The index built by such a token is kept on the
+//destination by the cleanup, so that the transfer can be resumed.
+func isRetainedForResume(plan *RebalancePlan, ttid string, tt *c.TransferToken) bool {
+
+	if plan == nil || tt.Error != "" {
+		return false
+	}
+
+	if _, ok := plan.TransferTokens[ttid]; !ok {
+		return false
+	}
+
+	switch tt.State {
+	case c.TransferTokenAccepted, c.TransferTokenInitate, c.TransferTokenInProgress:
+		return true
+	}
+
+	return false
+}
+
+func hasIndexPartitions(topology *manager.ClusterIndexMetadata, nodeId string,
+	defnId c.IndexDefnId, instId c.IndexInstId, partitions []c.PartitionId) bool {
+
+	for _, meta := range topology.Metadata {
+		if meta.NodeUUID != nodeId {
+			continue
+		}
+
+		for i, _ := range meta.IndexTopologies {
+			inst := meta.IndexTopologies[i].GetIndexInstByDefn(defnId, instId)
+			if inst == nil {
+				continue
+			}
+
+			for _, partnId := range partitions {
+				found := false
+				for _, partn := range inst.Partitions {
+					if partn.PartId == uint64(partnId) {
+						found = true
+						break
+					}
+				}
+				if !found {
+					return false
+				}
+			}
+			return true
+		}
+	}
+
+	return false
+}
+
+func getKeepNodeIds(change *service.TopologyChange) []string {
+
+	nodeIds := make([]string, 0, len(change.KeepNodes))
+	for _, node := range change.KeepNodes {
+		nodeIds = append(nodeIds, string(node.NodeInfo.NodeID))
+	}
+
+	return nodeIds
+}
+
+func getEjectNodeIds(change *service.TopologyChange) []string {
+
+	nodeIds := make([]string, 0, len(change.EjectNodes))
+	for _, node := range change.EjectNodes {
+		nodeIds = append(nodeIds, string(node.NodeID))
+	}
+
+	return nodeIds
+}
+
+func isSameNodeIds(nodeIds1 []string, nodeIds2 []string) bool {
+
+	if len(nodeIds1) != len(nodeIds2) {
+		return false
+	}
+
+	for _, nodeId1 := range nodeIds1 {
+		found := false
+		for _, nodeId2 := range nodeIds2 {
+			if nodeId1 == nodeId2 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
 func getIndexStatusFromMeta(tt *c.TransferToken, localMeta *manager.LocalIndexMetadata) (c.IndexState, string) {
 
 	inst := tt.IndexInst
@@ -0,0 +1,474 @@
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/cbauth/service"
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/manager"
+)
+
+//testMetakv keeps the metakv entries of the rebalancer in memory.
+type testMetakv struct {
+	mutex  sync.Mutex
+	values map[string][]byte
+}
+
+func useTestMetakv() (*testMetakv, func()) {
+
+	m := &testMetakv{values: make(map[string][]byte)}
+
+	get, set, del := rebalanceMetakvGet, rebalanceMetakvSet, rebalanceMetakvDel
+	rebalanceMetakvGet, rebalanceMetakvSet, rebalanceMetakvDel = m.get, m.set, m.del
+
+	return m, func() {
+		rebalanceMetakvGet, rebalanceMetakvSet, rebalanceMetakvDel = get, set, del
+	}
+}
+
+func (m *testMetakv) get(path string, v interface{}) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	raw, ok := m.values[path]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+func (m *testMetakv) set(path string, v interface{}) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	m.values[path] = raw
+	return nil
+}
+
+func (m *testMetakv) del(path string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.values, path)
+	return nil
+}
+
+func (m *testMetakv) token(t *testing.T, ttid string) *c.TransferToken {
+
+	var tt c.TransferToken
+	if found, err := m.get(RebalanceMetakvDir+ttid, &tt); err != nil || !found {
+		t.Fatalf("expected transfer token %v in metakv, err %v", ttid, err)
+	}
+	return &tt
+}
+
+func newTestRebalancer(master bool) *Rebalancer {
+
+	r := &Rebalancer{
+		transferTokens: make(map[string]*c.TransferToken),
+		acceptedTokens: make(map[string]*c.TransferToken),
+		sourceTokens:   make(map[string]*c.TransferToken),
+		rebalToken:     &RebalanceToken{RebalId: "rebal1"},
+		nodeId:         "node1",
+		master:         master,
+		metakvCancel:   make(chan struct{}),
+		pendingTokens:  make([]string, 0),
+		heldTokens:     make(map[string]*c.TransferToken),
+		resumedTokens:  make(map[string]bool),
+	}
+	r.config.Store(c.SystemConfig.SectionConfig("indexer.", true).Clone())
+
+	return r
+}
+
+func newTestTransferToken(destId string, state c.TokenState) *c.TransferToken {
+
+	tt := &c.TransferToken{
+		MasterId: "master",
+		SourceId: "source",
+		DestId:   destId,
+		RebalId:  "rebal1",
+		State:    state,
+		InstId:   c.IndexInstId(10),
+	}
+	tt.IndexInst.InstId = c.IndexInstId(10)
+	tt.IndexInst.Defn.DefnId = c.IndexDefnId(100)
+	tt.IndexInst.Defn.Partitions = []c.PartitionId{0}
+	return tt
+}
+
+func TestIsResumablePlan(t *testing.T) {
+
+	cfg := c.SystemConfig.SectionConfig("indexer.", true).Clone()
+	cfg.SetValue("rebalance.resumeTimeout", 60)
+
+	plan := &RebalancePlan{Timestamp: time.Now().UnixNano()}
+	if !isResumablePlan(plan, cfg) {
+		t.Errorf("expected new plan to be resumable")
+	}
+
+	plan.Timestamp = time.Now().Add(-2 * time.Minute).UnixNano()
+	if isResumablePlan(plan, cfg) {
+		t.Errorf("expected expired plan not to be resumable")
+	}
+
+	cfg.SetValue("rebalance.resumeTimeout", 0)
+	if !isResumablePlan(plan, cfg) {
+		t.Errorf("expected plan without timeout to be resumable")
+	}
+
+	cfg.SetValue("rebalance.resumeOnRestart", false)
+	if isResumablePlan(plan, cfg) {
+		t.Errorf("expected plan not to be resumable when resume is disabled")
+	}
+}
+
+func TestIsRetainedForResume(t *testing.T) {
+
+	plan := &RebalancePlan{
+		TransferTokens: map[string]*c.TransferToken{"tt1": {}},
+	}
+
+	testcases := []struct {
+		comment  string
+		ttid     string
+		state    c.TokenState
+		err      string
+		retained bool
+	}{
+		{"created", "tt1", c.TransferTokenCreated, "", false},
+		{"accepted", "tt1", c.TransferTokenAccepted, "", true},
+		{"initiated", "tt1", c.TransferTokenInitate, "", true},
+		{"in progress", "tt1", c.TransferTokenInProgress, "", true},
+		{"merge", "tt1", c.TransferTokenMerge, "", false},
+		{"ready", "tt1", c.TransferTokenReady, "", false},
+		{"error", "tt1", c.TransferTokenInProgress, "build failed", false},
+		{"not in plan", "tt2", c.TransferTokenInProgress, "", false},
+	}
+
+	for _, tc := range testcases {
+		tt := &c.TransferToken{State: tc.state, Error: tc.err}
+		if retained := isRetainedForResume(plan, tc.ttid, tt); retained != tc.retained {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.retained, retained)
+		}
+	}
+
+	if isRetainedForResume(nil, "tt1", &c.TransferToken{State: c.TransferTokenInProgress}) {
+		t.Errorf("expected token not to be retained without a plan")
+	}
+}
+
+func TestNextTransferTokenBatch(t *testing.T) {
+
+	testcases := []struct {
+		comment      string
+		batchSize    int
+		maxBuilds    int
+		control      RebalanceControl
+		expected     []string
+		expectedLeft []string
+	}{
+		{"no limit", 0, 0, RebalanceControl{},
+			[]string{"tt1", "tt2", "tt3", "tt4", "tt5"}, []string{}},
+		{"batch size", 2, 0, RebalanceControl{},
+			[]string{"tt1", "tt2"}, []string{"tt3", "tt4", "tt5"}},
+		{"builds per destination", 0, 1, RebalanceControl{},
+			[]string{"tt1", "tt4"}, []string{"tt2", "tt3", "tt5"}},
+		{"batch size and builds per destination", 3, 2, RebalanceControl{},
+			[]string{"tt1", "tt2", "tt4"}, []string{"tt3", "tt5"}},
+		{"control batch size", 2, 0, RebalanceControl{TransferBatchSize: 3},
+			[]string{"tt1", "tt2", "tt3"}, []string{"tt4", "tt5"}},
+		{"control builds per destination", 0, 1, RebalanceControl{MaxConcurrentBuilds: 2},
+			[]string{"tt1", "tt2", "tt4", "tt5"}, []string{"tt3"}},
+	}
+
+	for _, tc := range testcases {
+		r := newTestRebalancer(true)
+		for ttid, destId := range map[string]string{"tt1": "n1", "tt2": "n1", "tt3": "n1", "tt4": "n2", "tt5": "n2"} {
+			r.transferTokens[ttid] = newTestTransferToken(destId, c.TransferTokenCreated)
+		}
+		r.pendingTokens = []string{"tt1", "tt2", "tt3", "tt4", "tt5"}
+		r.control = tc.control
+
+		cfg := r.config.Load().Clone()
+		cfg.SetValue("rebalance.transferBatchSize", tc.batchSize)
+		cfg.SetValue("rebalance.maxConcurrentBuilds", tc.maxBuilds)
+		r.config.Store(cfg)
+
+		batch := r.nextTransferTokenBatchLOCKED()
+		if !reflect.DeepEqual(batch, tc.expected) {
+			t.Errorf("%v: expected batch %v, got %v", tc.comment, tc.expected, batch)
+		}
+		if !reflect.DeepEqual(r.pendingTokens, tc.expectedLeft) {
+			t.Errorf("%v: expected pending %v, got %v", tc.comment, tc.expectedLeft, r.pendingTokens)
+		}
+	}
+}
+
+func TestHoldTransferToken(t *testing.T) {
+
+	testcases := []struct {
+		comment string
+		control RebalanceControl
+		held    bool
+	}{
+		{"running", RebalanceControl{}, false},
+		{"paused", RebalanceControl{Paused: true}, false},
+		{"paused with builds", RebalanceControl{Paused: true, PauseBuilds: true}, true},
+	}
+
+	for _, tc := range testcases {
+		r := newTestRebalancer(false)
+		r.control = tc.control
+
+		tt := newTestTransferToken("node1", c.TransferTokenCreated)
+		if held := r.holdTransferToken("tt1", tt); held != tc.held {
+			t.Errorf("%v: expected held %v, got %v", tc.comment, tc.held, held)
+		}
+		if _, ok := r.heldTokens["tt1"]; ok != tc.held {
+			t.Errorf("%v: expected token in held tokens %v, got %v", tc.comment, tc.held, ok)
+		}
+	}
+}
+
+func TestProcessControlTokenIgnored(t *testing.T) {
+
+	r := newTestRebalancer(false)
+
+	r.processControlToken([]byte("{"))
+	if r.control.Paused {
+		t.Errorf("expected invalid control token to be ignored")
+	}
+
+	value, _ := json.Marshal(&RebalanceControl{RebalId: "rebal0", Paused: true})
+	r.processControlToken(value)
+	if r.control.Paused {
+		t.Errorf("expected control token of another rebalance to be ignored")
+	}
+}
+
+func TestProcessControlTokenPauseBuilds(t *testing.T) {
+
+	m, restore := useTestMetakv()
+	defer restore()
+
+	var mutex sync.Mutex
+	var dropped []manager.IndexRequest
+	post := rebalancePostWithAuth
+	defer func() { rebalancePostWithAuth = post }()
+	rebalancePostWithAuth = func(url string, bodyType string, body io.Reader) (*http.Response, error) {
+		var req manager.IndexRequest
+		if err := json.NewDecoder(body).Decode(&req); err != nil || !strings.HasSuffix(url, "/dropIndex") {
+			t.Errorf("unexpected request %v %v", url, err)
+		}
+		mutex.Lock()
+		dropped = append(dropped, req)
+		mutex.Unlock()
+
+		resp, _ := json.Marshal(&manager.IndexResponse{Code: manager.RESP_SUCCESS})
+		return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(bytes.NewBuffer(resp))}, nil
+	}
+
+	r := newTestRebalancer(false)
+	r.acceptedTokens["tt1"] = newTestTransferToken("node1", c.TransferTokenInProgress)
+	r.acceptedTokens["tt2"] = newTestTransferToken("node1", c.TransferTokenAccepted)
+	r.pendingBuild = 1
+
+	value, _ := json.Marshal(&RebalanceControl{RebalId: "rebal1", Paused: true, PauseBuilds: true})
+	r.processControlToken(value)
+	r.wg.Wait()
+
+	if !r.control.Paused || !r.control.PauseBuilds {
+		t.Errorf("expected rebalance to be paused with builds, got %+v", r.control)
+	}
+
+	// only the build in progress is stopped, by dropping its index.
+	if len(dropped) != 1 || dropped[0].Index.InstId != c.IndexInstId(10) {
+		t.Errorf("expected the index in progress to be dropped, got %v", dropped)
+	}
+	if _, ok := r.acceptedTokens["tt1"]; ok || len(r.acceptedTokens) != 1 || r.pendingBuild != 0 {
+		t.Errorf("expected stopped build to be removed, got %v pending %v", r.acceptedTokens, r.pendingBuild)
+	}
+	if tt := m.token(t, "tt1"); tt.State != c.TransferTokenCreated || tt.Error != "" {
+		t.Errorf("expected stopped token to be created again, got %v", tt)
+	}
+}
+
+func TestProcessControlTokenResume(t *testing.T) {
+
+	m, restore := useTestMetakv()
+	defer restore()
+
+	r := newTestRebalancer(true)
+	r.control = RebalanceControl{RebalId: "rebal1", Paused: true, PauseBuilds: true}
+
+	// held tokens are not processed by this node
+	r.heldTokens["tt1"] = newTestTransferToken("other", c.TransferTokenCreated)
+	r.heldTokens["tt2"] = newTestTransferToken("other", c.TransferTokenAccepted)
+
+	// deferred batch
+	r.transferTokens["tt3"] = newTestTransferToken("other", c.TransferTokenCreated)
+	r.pendingTokens = []string{"tt3"}
+	r.batchDeferred = true
+
+	value, _ := json.Marshal(&RebalanceControl{RebalId: "rebal1", TransferBatchSize: 1})
+	r.processControlToken(value)
+	r.wg.Wait()
+
+	if r.control.Paused || r.control.TransferBatchSize != 1 {
+		t.Errorf("expected rebalance to be resumed, got %+v", r.control)
+	}
+	if len(r.heldTokens) != 0 {
+		t.Errorf("expected held tokens to be released, got %v", r.heldTokens)
+	}
+	if tt := m.token(t, "tt2"); tt.State != c.TransferTokenInitate {
+		t.Errorf("expected accepted held token to be initiated, got %v", tt)
+	}
+	if r.batchDeferred || !reflect.DeepEqual(r.currBatchTokens, []string{"tt3"}) {
+		t.Errorf("expected deferred batch to be published, got %v", r.currBatchTokens)
+	}
+	m.token(t, "tt3")
+}
+
+func newTestPlanRebalancer() *Rebalancer {
+
+	r := newTestRebalancer(true)
+	r.runPlanner = true
+	r.change = &service.TopologyChange{
+		EjectNodes: []service.NodeInfo{{NodeID: service.NodeID("node3")}},
+	}
+	return r
+}
+
+func TestSavePlan(t *testing.T) {
+
+	m, restore := useTestMetakv()
+	defer restore()
+
+	r := newTestPlanRebalancer()
+	r.transferTokens["tt1"] = newTestTransferToken("node1", c.TransferTokenInProgress)
+	r.transferTokens["tt2"] = newTestTransferToken("node1", c.TransferTokenCommit)
+	r.transferTokens["tt3"] = newTestTransferToken("node1", c.TransferTokenDeleted)
+	r.transferTokens["tt4"] = newTestTransferToken("node1", c.TransferTokenCreated)
+
+	r.savePlanLOCKED()
+
+	var plan RebalancePlan
+	if found, _ := m.get(RebalancePlanPath, &plan); !found {
+		t.Fatalf("expected plan to be saved")
+	}
+
+	ttids := make([]string, 0)
+	for ttid := range plan.TransferTokens {
+		ttids = append(ttids, ttid)
+	}
+	sort.Strings(ttids)
+	if !reflect.DeepEqual(ttids, []string{"tt1", "tt4"}) {
+		t.Errorf("expected pending tokens in plan, got %v", ttids)
+	}
+	if plan.RebalId != "rebal1" || !reflect.DeepEqual(plan.EjectNodes, []string{"node3"}) {
+		t.Errorf("unexpected plan %+v", plan)
+	}
+
+	// rebalance not triggered by topology change is not resumed
+	m.del(RebalancePlanPath)
+	r.runPlanner = false
+	r.savePlanLOCKED()
+	if found, _ := m.get(RebalancePlanPath, &plan); found {
+		t.Errorf("expected plan not to be saved")
+	}
+}
+
+func newTestIndexMetadata(nodeId string) manager.LocalIndexMetadata {
+
+	return manager.LocalIndexMetadata{
+		NodeUUID: nodeId,
+		IndexTopologies: []manager.IndexTopology{{
+			Definitions: []manager.IndexDefnDistribution{{
+				DefnId: 100,
+				Instances: []manager.IndexInstDistribution{{
+					InstId:     10,
+					Partitions: []manager.IndexPartDistribution{{PartId: 0}},
+				}},
+			}},
+		}},
+	}
+}
+
+func TestResumeTransferTokens(t *testing.T) {
+
+	m, restore := useTestMetakv()
+	defer restore()
+
+	plan := &RebalancePlan{
+		RebalId:    "rebal0",
+		EjectNodes: []string{"node3"},
+		Timestamp:  time.Now().UnixNano(),
+		TransferTokens: map[string]*c.TransferToken{
+			"tt1": newTestTransferToken("node2", c.TransferTokenInProgress),
+			"tt2": newTestTransferToken("node2", c.TransferTokenAccepted),
+			"tt3": newTestTransferToken("node4", c.TransferTokenCreated),
+		},
+	}
+
+	obsolete := newTestTransferToken("node2", c.TransferTokenCreated)
+	obsolete.SourceId = "node5"
+	plan.TransferTokens["tt4"] = obsolete
+
+	// the index of tt3 is already on its destination, the index of tt4
+	// is no longer on its source.
+	topology := &manager.ClusterIndexMetadata{
+		Metadata: []manager.LocalIndexMetadata{
+			newTestIndexMetadata("node4"),
+			newTestIndexMetadata("source"),
+		},
+	}
+
+	// tt1 is still in progress on its destination, tt2 is not published
+	m.set(RebalancePlanPath, plan)
+	m.set(RebalanceMetakvDir+"tt1", newTestTransferToken("node2", c.TransferTokenInProgress))
+
+	r := newTestPlanRebalancer()
+	tokens := r.resumeTransferTokens(topology)
+
+	if len(tokens) != 2 || tokens["tt1"] == nil || tokens["tt2"] == nil {
+		t.Fatalf("expected tt1 and tt2 to be resumed, got %v", tokens)
+	}
+	for ttid, tt := range tokens {
+		if tt.State != c.TransferTokenCreated || tt.MasterId != "node1" || tt.RebalId != "rebal1" {
+			t.Errorf("%v: unexpected resumed token %v", ttid, tt)
+		}
+	}
+	if !r.resumedTokens["tt1"] || r.resumedTokens["tt2"] {
+		t.Errorf("expected only tt1 to resume from its index, got %v", r.resumedTokens)
+	}
+
+	// plan of another topology change is discarded
+	r = newTestPlanRebalancer()
+	r.change.EjectNodes = nil
+	if tokens := r.resumeTransferTokens(topology); tokens != nil {
+		t.Errorf("expected no token for another topology change, got %v", tokens)
+	}
+	if found, _ := m.get(RebalancePlanPath, plan); found {
+		t.Errorf("expected plan to be discarded")
+	}
+
+	// no plan
+	if tokens := newTestPlanRebalancer().resumeTransferTokens(topology); tokens != nil {
+		t.Errorf("expected no token without plan, got %v", tokens)
+	}
+}
@@ -10,6 +10,7 @@ import "fmt"
 import c "github.com/couchbase/indexing/secondary/common"
 import qclient "github.com/couchbase/indexing/secondary/queryport/client"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
+import mc "github.com/couchbase/indexing/secondary/manager/common"
 import log "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/query/parser/n1ql"
 import "github.com/couchbase/query/expression"
@@ -149,14 +150,14 @@ func (api *testServer) handleIndexes(
 	q := request.URL.Query()
 	if _, ok := q["create"]; ok {
 		if request.Method == "POST" {
-			api.doCreate(w, request)
+			api.doCreate(w, request, creds)
 		} else {
 			msg := `invalid method, expected POST`
 			http.Error(w, jsonstr(msg), http.StatusMethodNotAllowed)
 		}
 	} else if _, ok := q["build"]; ok {
 		if request.Method == "PUT" {
-			api.doBuildMany(w, request)
+			api.doBuildMany(w, request, creds)
 		} else {
 			msg := `invalid method, expected PUT`
 			http.Error(w, jsonstr(msg), http.StatusMethodNotAllowed)
@@ -195,7 +196,7 @@ func (api *testServer) handleIndex(
 		q, _ := request.URL.Query(), segs[3]
 		if _, ok := q["build"]; ok {
 			if request.Method == "PUT" {
-				api.doBuildOne(w, request)
+				api.doBuildOne(w, request, creds)
 			} else {
 				msg := `invalid method, expected PUT`
 				http.Error(w, jsonstr(msg), http.StatusMethodNotAllowed)
@@ -245,7 +246,7 @@ func (api *testServer) handleIndex(
 		} else if request.Method == "GET" {
 			api.doGet(w, request)
 		} else if request.Method == "DELETE" {
-			api.doDrop(w, request)
+			api.doDrop(w, request, creds)
 		} else {
 			msg := `invalid request, missing api argument`
 			http.Error(w, jsonstr(msg), http.StatusBadRequest)
@@ -257,7 +258,8 @@ func (api *testServer) handleIndex(
 }
 
 // POST /internal/indexes?create=true
-func (api *testServer) doCreate(w http.ResponseWriter, request *http.Request) {
+func (api *testServer) doCreate(
+	w http.ResponseWriter, request *http.Request, creds cbauth.Creds) {
 
 	var params map[string]interface{}
 
@@ -382,9 +384,10 @@ func (api *testServer) doCreate(w http.ResponseWriter, request *http.Request) {
 		}
 	}
 
-	defnId, err := api.client.CreateIndex3(
+	defnId, err := api.client.CreateIndex4(
 		indexname, bucket, using, exprtype, whereExpr, secExprs,
-		desc, isPrimary, partnScheme, partnExprs, with)
+		desc, isPrimary, partnScheme, partnExprs, with,
+		ddlRequest(request, creds))
 	if err != nil {
 		http.Error(w, jsonstr("%v", err), http.StatusInternalServerError)
 		return
@@ -398,7 +401,7 @@ func (api *testServer) doCreate(w http.ResponseWriter, request *http.Request) {
 
 // PUT  /internal/indexes?build=true
 func (api *testServer) doBuildMany(
-	w http.ResponseWriter, request *http.Request) {
+	w http.ResponseWriter, request *http.Request, creds cbauth.Creds) {
 
 	var params []interface{}
 
@@ -419,7 +422,7 @@ func (api *testServer) doBuildMany(
 		defnIDs = append(defnIDs, id)
 	}
 
-	err = api.client.BuildIndexes(defnIDs)
+	err = api.client.BuildIndexes2(defnIDs, ddlRequest(request, creds))
 
 	// make response
 	if err != nil {
@@ -432,7 +435,9 @@ func (api *testServer) doBuildMany(
 }
 
 //PUT    /internal/index/{id}?build=true
-func (api *testServer) doBuildOne(w http.ResponseWriter, request *http.Request) {
+func (api *testServer) doBuildOne(
+	w http.ResponseWriter, request *http.Request, creds cbauth.Creds) {
+
 	defnId, err := urlPath2IndexId(request.URL.Path)
 	if err != nil {
 		msg := `invalid index id, ParseUint failed %v`
@@ -440,7 +445,7 @@ func (api *testServer) doBuildOne(w http.ResponseWriter, request *http.Request)
 		return
 	}
 
-	err = api.client.BuildIndexes([]uint64{defnId})
+	err = api.client.BuildIndexes2([]uint64{defnId}, ddlRequest(request, creds))
 
 	// make response
 	if err != nil {
@@ -493,7 +498,9 @@ func (api *testServer) doGetAll(w http.ResponseWriter, request *http.Request) {
 }
 
 //DELETE /internal/index/{id}
-func (api *testServer) doDrop(w http.ResponseWriter, request *http.Request) {
+func (api *testServer) doDrop(
+	w http.ResponseWriter, request *http.Request, creds cbauth.Creds) {
+
 	defnId, err := urlPath2IndexId(request.URL.Path)
 	if err != nil {
 		msg := `invalid index id, ParseUint failed %v`
@@ -501,7 +508,7 @@ func (api *testServer) doDrop(w http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	err = api.client.DropIndex(defnId)
+	err = api.client.DropIndex2(defnId, ddlRequest(request, creds))
 
 	// make response
 	if err != nil {
@@ -1430,6 +1437,17 @@ func incl2incl(inclusion string) qclient.Inclusion {
 	return qclient.Neither
 }
 
+// ddlRequest to record a DDL issued by `request` in DDL history.
+func ddlRequest(request *http.Request, creds cbauth.Creds) *mc.DDLRequest {
+	return &mc.DDLRequest{
+		Creds: creds,
+		Context: mc.DDLHistoryContext{
+			Source:     mc.DDLHistorySourceREST,
+			RemoteAddr: request.RemoteAddr,
+		},
+	}
+}
+
 func jsonstr(msg string, args ...interface{}) string {
 	s := fmt.Sprintf(msg, args...)
 	data, _ := json.Marshal(s)
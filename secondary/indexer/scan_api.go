@@ -0,0 +1,600 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	log "github.com/couchbase/indexing/secondary/logging"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+	"github.com/couchbase/query/value"
+)
+
+const (
+	// maximum size of a scan request body
+	scanApiMaxRequestSize = 1024 * 1024
+	// number of rows written between flushing the response
+	scanApiFlushRows = 100
+	// maximum time between flushing the response
+	scanApiFlushInterval = 100 * time.Millisecond
+)
+
+//
+// scanRequest is the body of POST /api/v1/index/{bucket}/{name}/scan.
+// Refer docs/restful-2i.md for the schema.
+//
+type scanRequest struct {
+	Scans      []*scanSpec              `json:"scans"`
+	Projection *qclient.IndexProjection `json:"projection"`
+	Distinct   bool                     `json:"distinct"`
+	Reverse    bool                     `json:"reverse"`
+	Offset     int64                    `json:"offset"`
+	Limit      int64                    `json:"limit"`
+	GroupAggr  *groupAggrSpec           `json:"groupAggr"`
+	IndexOrder *qclient.IndexKeyOrder   `json:"indexOrder"`
+	Stale      string                   `json:"stale"`
+	Timestamp  map[string][]string      `json:"timestamp"`
+	Timeout    int64                    `json:"timeout"` // in milliseconds
+	Partitions []c.PartitionId          `json:"partitions"`
+}
+
+type scanSpec struct {
+	Seek    []interface{} `json:"seek"`
+	Filters []*filterSpec `json:"filters"`
+}
+
+type filterSpec struct {
+	Low       json.RawMessage `json:"low"` // unbounded if omitted
+	High      json.RawMessage `json:"high"`
+	Inclusion string          `json:"inclusion"`
+}
+
+type groupAggrSpec struct {
+	Name               string           `json:"name"`
+	Group              []*groupKeySpec  `json:"group"`
+	Aggrs              []*aggregateSpec `json:"aggregates"`
+	DependsOnIndexKeys []int32          `json:"dependsOnIndexKeys"`
+	IndexKeyNames      []string         `json:"indexKeyNames"`
+	AllowPartialAggr   bool             `json:"allowPartialAggr"`
+	OnePerPrimaryKey   bool             `json:"onePerPrimaryKey"`
+}
+
+type groupKeySpec struct {
+	EntryKeyId int32  `json:"entryKeyId"`
+	KeyPos     *int32 `json:"keyPos"` // expr is used if omitted
+	Expr       string `json:"expr"`
+}
+
+type aggregateSpec struct {
+	Func       string `json:"func"`
+	EntryKeyId int32  `json:"entryKeyId"`
+	KeyPos     *int32 `json:"keyPos"` // expr is used if omitted
+	Expr       string `json:"expr"`
+	Distinct   bool   `json:"distinct"`
+}
+
+// scanRow is a single line of the NDJSON response.
+type scanRow struct {
+	Key   []value.Value `json:"key"`
+	DocId string        `json:"docid,omitempty"`
+}
+
+var aggrFuncs = map[string]c.AggrFuncType{
+	"min":    c.AGG_MIN,
+	"max":    c.AGG_MAX,
+	"sum":    c.AGG_SUM,
+	"count":  c.AGG_COUNT,
+	"countn": c.AGG_COUNTN,
+}
+
+//
+// indexHandler serves the index resources.
+// Example: _/api/index/bucket/name/scan (_ is a blank)
+//
+func (api *restServer) indexHandler(req request) {
+
+	segs := strings.Split(req.url, "/")
+	if req.version != "v1" || len(segs) != 6 || segs[5] != "scan" {
+		api.writeJSONError(req.w, http.StatusNotFound, fmt.Errorf("%v not found", req.r.URL.Path))
+		return
+	}
+
+	if req.r.Method != "POST" {
+		req.w.Header().Set("Allow", "POST")
+		api.writeJSONError(req.w, http.StatusMethodNotAllowed, fmt.Errorf("Unsupported method %v", req.r.Method))
+		return
+	}
+
+	api.scanHandler(req, segs[3], segs[4])
+}
+
+//
+// Scan an index and stream the result as newline delimited JSON.  Errors
+// found before the first row is sent are returned with the HTTP status code.
+// Once streaming has started, an error is sent as the last line.
+//
+func (api *restServer) scanHandler(req request, bucket, name string) {
+
+	w, r := req.w, req.r
+
+	// check permission before looking up the index, so that the
+	// existence of an index is not revealed to an unauthorized user.
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.select!execute", bucket)
+	if allowed, err := req.creds.IsAllowed(permission); err != nil {
+		api.writeJSONError(w, http.StatusInternalServerError, err)
+		return
+	} else if !allowed {
+		api.writeJSONError(w, http.StatusForbidden, fmt.Errorf("Forbidden. User needs permission %v", permission))
+		return
+	}
+
+	var body scanRequest
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, scanApiMaxRequestSize))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&body); err != nil {
+		api.writeJSONError(w, http.StatusBadRequest, fmt.Errorf("Invalid request body: %v", err))
+		return
+	}
+
+	opts, err := body.scanOptions()
+	if err != nil {
+		api.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	client, err := api.getClient()
+	if err != nil {
+		api.writeJSONError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+
+	defn, err := api.findIndex(client, bucket, name)
+	if err != nil {
+		api.writeJSONError(w, scanErrorStatus(err), err)
+		return
+	}
+
+	if err := validatePartitions(defn, opts.Partitions); err != nil {
+		api.writeJSONError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	ctx := r.Context()
+	if body.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(body.Timeout)*time.Millisecond)
+		defer cancel()
+	}
+
+	it, err := client.Scan3Context(ctx, uint64(defn.DefnId), opts)
+	if err != nil {
+		if err == c.ErrIndexNotFound || err == qclient.ErrorIndexNotFound {
+			api.forgetIndex(bucket, name)
+		}
+		api.writeJSONError(w, scanErrorStatus(err), err)
+		return
+	}
+	defer it.Close()
+
+	begin := time.Now()
+	count, err := api.streamRows(w, it)
+	if err != nil {
+		log.Errorf("restServer::scan index %v:%v requestId %v rows %v error %v",
+			bucket, name, opts.RequestId, count, err)
+		return
+	}
+
+	log.Verbosef("restServer::scan index %v:%v requestId %v rows %v elapsed %v",
+		bucket, name, opts.RequestId, count, time.Since(begin))
+}
+
+//
+// Write the rows from the iterator.  The status code is written along with
+// the first row, so that an error before the first row can still be returned
+// as the status code.
+//
+func (api *restServer) streamRows(w http.ResponseWriter, it *qclient.RowIterator) (int64, error) {
+
+	flusher, _ := w.(http.Flusher)
+
+	var count int64
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	lastFlush := time.Now()
+
+	flush := func() error {
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		buf.Reset()
+		if flusher != nil {
+			flusher.Flush()
+		}
+		lastFlush = time.Now()
+		return nil
+	}
+
+	writeErr := func(err error) error {
+		if count == 0 && buf.Len() == 0 {
+			api.writeJSONError(w, scanErrorStatus(err), err)
+			return err
+		}
+		encoder.Encode(map[string]string{"error": err.Error()})
+		flush()
+		return err
+	}
+
+	for it.Next() {
+		row := it.Row()
+
+		vals, err := row.Values()
+		if err != nil {
+			return count, writeErr(err)
+		}
+
+		if count == 0 {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+		}
+		count++
+
+		if err := encoder.Encode(&scanRow{Key: vals, DocId: row.DocId()}); err != nil {
+			return count, writeErr(err)
+		}
+
+		if count%scanApiFlushRows == 0 || time.Since(lastFlush) >= scanApiFlushInterval {
+			if err := flush(); err != nil {
+				// client has gone away
+				return count, err
+			}
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return count, writeErr(err)
+	}
+
+	if count == 0 {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+
+	return count, flush()
+}
+
+//
+// Create the query client on first use.  The client is shared by all
+// scan requests.
+//
+func (api *restServer) getClient() (*qclient.GsiClient, error) {
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	if api.client != nil {
+		return api.client, nil
+	}
+
+	config, err := c.GetSettingsConfig(c.SystemConfig)
+	if err != nil {
+		return nil, err
+	}
+	qconf := config.SectionConfig("queryport.client.", true /*trim*/)
+
+	client, err := qclient.NewGsiClient(api.cluster, qconf)
+	if err != nil {
+		return nil, err
+	}
+
+	api.client = client
+	return api.client, nil
+}
+
+//
+// Find the index definition by bucket and index name.  The index is looked
+// up from the metadata cached by the client.  The metadata is refreshed only
+// if the index is not found.  An index alias is resolved if there is no index
+// with the name.
+//
+func (api *restServer) findIndex(client *qclient.GsiClient, bucket, name string) (*c.IndexDefn, error) {
+
+	if defn := api.cachedIndex(client, bucket, name); defn != nil {
+		return defn, nil
+	}
+
+	indexes, version, _, err := client.Refresh()
+	if err != nil {
+		return nil, err
+	}
+
+	api.mutex.Lock()
+	if api.indexNames == nil || api.indexVersion != version {
+		api.indexNames = make(map[string]uint64)
+		for _, index := range indexes {
+			defn := index.Definition
+			api.indexNames[indexNameKey(defn.Bucket, defn.Name)] = uint64(defn.DefnId)
+		}
+		api.indexVersion = version
+	}
+	api.mutex.Unlock()
+
+	if defn := api.cachedIndex(client, bucket, name); defn != nil {
+		return defn, nil
+	}
+
+	if defnID, err := client.ResolveIndexAlias(bucket, name); err == nil {
+		if defn := client.Bridge().GetIndexDefn(defnID); defn != nil {
+			return defn, nil
+		}
+	}
+
+	return nil, qclient.ErrorIndexNotFound
+}
+
+//
+// Return the index definition if the index name is cached, and the cached
+// metadata of the client still has the index with the same name.
+//
+func (api *restServer) cachedIndex(client *qclient.GsiClient, bucket, name string) *c.IndexDefn {
+
+	api.mutex.Lock()
+	defnID, ok := api.indexNames[indexNameKey(bucket, name)]
+	api.mutex.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	defn := client.Bridge().GetIndexDefn(defnID)
+	if defn == nil || defn.Bucket != bucket || defn.Name != name {
+		return nil
+	}
+
+	return defn
+}
+
+//
+// Forget the cached index name, e.g. the index has been dropped.
+//
+func (api *restServer) forgetIndex(bucket, name string) {
+
+	api.mutex.Lock()
+	defer api.mutex.Unlock()
+
+	delete(api.indexNames, indexNameKey(bucket, name))
+}
+
+func indexNameKey(bucket, name string) string {
+	return bucket + "/" + name
+}
+
+//
+// A partitioned index can be scanned for a subset of its partitions.  The
+// partition ids of a partitioned index range from 1 to the number of
+// partitions.
+//
+func validatePartitions(defn *c.IndexDefn, partitions []c.PartitionId) error {
+
+	if len(partitions) == 0 {
+		return nil
+	}
+
+	if !c.IsPartitioned(defn.PartitionScheme) {
+		return fmt.Errorf("partitions is only supported for partitioned index")
+	}
+
+	for _, partnId := range partitions {
+		if partnId < 1 || uint32(partnId) > defn.NumPartitions {
+			return fmt.Errorf("invalid partition %v, expected 1 to %v", partnId, defn.NumPartitions)
+		}
+	}
+
+	return nil
+}
+
+func (api *restServer) writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
+
+func scanErrorStatus(err error) int {
+
+	switch err {
+	case qclient.ErrorIndexNotFound, c.ErrIndexNotFound:
+		return http.StatusNotFound
+	case qclient.ErrorNoHost, qclient.ErrorClientUninitialized, c.ErrIndexNotReady:
+		return http.StatusServiceUnavailable
+	case qclient.ErrorInvalidConsistency, qclient.ErrorExpectedTimestamp:
+		return http.StatusBadRequest
+	case context.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	}
+
+	if err.Error() == c.ErrIndexNotReady.Error() {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
+}
+
+//
+// Convert the request body to the scan options of the query client.
+//
+func (body *scanRequest) scanOptions() (*qclient.ScanOptions, error) {
+
+	opts := &qclient.ScanOptions{
+		Projection: body.Projection,
+		Distinct:   body.Distinct,
+		Reverse:    body.Reverse,
+		Offset:     body.Offset,
+		Limit:      body.Limit,
+		IndexOrder: body.IndexOrder,
+		Partitions: body.Partitions,
+	}
+
+	if body.Offset < 0 || body.Limit < 0 || body.Timeout < 0 {
+		return nil, fmt.Errorf("offset, limit and timeout must not be negative")
+	}
+
+	uuid, err := c.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+	opts.RequestId = uuid.Str()
+
+	// a missing scan list is a full index scan
+	if len(body.Scans) == 0 {
+		body.Scans = []*scanSpec{{}}
+	}
+
+	for i, spec := range body.Scans {
+		if spec == nil {
+			return nil, fmt.Errorf("scans[%v] is null", i)
+		}
+		scan, err := spec.scan()
+		if err != nil {
+			return nil, fmt.Errorf("scans[%v]: %v", i, err)
+		}
+		opts.Scans = append(opts.Scans, scan)
+	}
+
+	if body.GroupAggr != nil {
+		if opts.GroupAggr, err = body.GroupAggr.groupAggr(); err != nil {
+			return nil, err
+		}
+	}
+
+	stale := body.Stale
+	if len(stale) == 0 {
+		stale = "ok"
+	}
+	cons, ok := stale2consistency(stale)
+	if !ok {
+		return nil, fmt.Errorf("invalid stale %q, expected \"ok\", \"false\" or \"partial\"", body.Stale)
+	}
+	opts.Consistency = cons
+
+	if cons == c.QueryConsistency {
+		if len(body.Timestamp) == 0 {
+			return nil, fmt.Errorf("timestamp is required when stale is \"partial\"")
+		}
+		for vbno, val := range body.Timestamp {
+			if len(val) != 2 {
+				return nil, fmt.Errorf("timestamp of vbucket %v must be [vbuuid, seqno]", vbno)
+			}
+		}
+		if opts.Vector, err = vector2tsconsistency(body.Timestamp); err != nil {
+			return nil, fmt.Errorf("invalid timestamp: %v", err)
+		}
+	}
+
+	return opts, nil
+}
+
+func (spec *scanSpec) scan() (*qclient.Scan, error) {
+
+	scan := &qclient.Scan{}
+	if spec.Seek != nil {
+		scan.Seek = c.SecondaryKey(spec.Seek)
+	}
+
+	for i, f := range spec.Filters {
+		if f == nil {
+			return nil, fmt.Errorf("filters[%v] is null", i)
+		}
+
+		filter := &qclient.CompositeElementFilter{
+			Low:  c.MinUnbounded,
+			High: c.MaxUnbounded,
+		}
+
+		if len(f.Low) > 0 {
+			if err := json.Unmarshal(f.Low, &filter.Low); err != nil {
+				return nil, fmt.Errorf("filters[%v].low: %v", i, err)
+			}
+		}
+		if len(f.High) > 0 {
+			if err := json.Unmarshal(f.High, &filter.High); err != nil {
+				return nil, fmt.Errorf("filters[%v].high: %v", i, err)
+			}
+		}
+
+		switch f.Inclusion {
+		case "", "both", "low", "high", "neither":
+			if len(f.Inclusion) == 0 {
+				filter.Inclusion = qclient.Both
+			} else {
+				filter.Inclusion = incl2incl(f.Inclusion)
+			}
+		default:
+			return nil, fmt.Errorf("filters[%v]: invalid inclusion %q", i, f.Inclusion)
+		}
+
+		scan.Filter = append(scan.Filter, filter)
+	}
+
+	return scan, nil
+}
+
+func (spec *groupAggrSpec) groupAggr() (*qclient.GroupAggr, error) {
+
+	groupAggr := &qclient.GroupAggr{
+		Name:               spec.Name,
+		DependsOnIndexKeys: spec.DependsOnIndexKeys,
+		IndexKeyNames:      spec.IndexKeyNames,
+		AllowPartialAggr:   spec.AllowPartialAggr,
+		OnePerPrimaryKey:   spec.OnePerPrimaryKey,
+	}
+
+	for i, g := range spec.Group {
+		if g == nil {
+			return nil, fmt.Errorf("groupAggr.group[%v] is null", i)
+		}
+		groupAggr.Group = append(groupAggr.Group, &qclient.GroupKey{
+			EntryKeyId: g.EntryKeyId,
+			KeyPos:     keyPosOrExpr(g.KeyPos),
+			Expr:       g.Expr,
+		})
+	}
+
+	for i, a := range spec.Aggrs {
+		if a == nil {
+			return nil, fmt.Errorf("groupAggr.aggregates[%v] is null", i)
+		}
+		fn, ok := aggrFuncs[strings.ToLower(a.Func)]
+		if !ok {
+			return nil, fmt.Errorf("groupAggr.aggregates[%v]: invalid func %q", i, a.Func)
+		}
+		groupAggr.Aggrs = append(groupAggr.Aggrs, &qclient.Aggregate{
+			AggrFunc:   fn,
+			EntryKeyId: a.EntryKeyId,
+			KeyPos:     keyPosOrExpr(a.KeyPos),
+			Expr:       a.Expr,
+			Distinct:   a.Distinct,
+		})
+	}
+
+	return groupAggr, nil
+}
+
+// A negative key position means the expression is used.
+func keyPosOrExpr(keyPos *int32) int32 {
+	if keyPos == nil {
+		return -1
+	}
+	return *keyPos
+}
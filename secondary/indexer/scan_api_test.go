@@ -0,0 +1,180 @@
+package indexer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	qclient "github.com/couchbase/indexing/secondary/queryport/client"
+)
+
+func decodeScanRequest(body string) (*scanRequest, error) {
+
+	var req scanRequest
+	decoder := json.NewDecoder(bytes.NewBufferString(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, err
+	}
+	return &req, nil
+}
+
+func TestScanRequestDecode(t *testing.T) {
+
+	testcases := []struct {
+		comment string
+		body    string
+		valid   bool
+	}{
+		{"empty body", `{}`, true},
+		{"full body", `{"scans": [{"seek": null, "filters": [{"low": "D", "high": "F", "inclusion": "low"}]}],
+			"projection": {"entryKeys": [0], "primaryKey": true}, "distinct": true, "reverse": false,
+			"offset": 10, "limit": 100, "stale": "false", "timeout": 1000, "partitions": [1, 2]}`, true},
+		{"unknown field", `{"scan": []}`, false},
+		{"unknown filter field", `{"scans": [{"filters": [{"lo": "D"}]}]}`, false},
+		{"invalid partitions", `{"partitions": ["1"]}`, false},
+		{"malformed", `{"scans": [`, false},
+	}
+
+	for _, tc := range testcases {
+		_, err := decodeScanRequest(tc.body)
+		if valid := err == nil; valid != tc.valid {
+			t.Errorf("%v: expected valid %v, got error %v", tc.comment, tc.valid, err)
+		}
+	}
+}
+
+func TestScanRequestScanOptions(t *testing.T) {
+
+	testcases := []struct {
+		comment string
+		body    string
+		err     bool
+		check   func(opts *qclient.ScanOptions) bool
+	}{
+		{"full index scan", `{}`, false, func(opts *qclient.ScanOptions) bool {
+			return len(opts.Scans) == 1 && opts.Scans[0].Seek == nil && len(opts.Scans[0].Filter) == 0 &&
+				opts.Consistency == c.AnyConsistency && opts.Vector == nil && len(opts.RequestId) != 0
+		}},
+		{"unbounded filter", `{"scans": [{"filters": [{"low": "D"}]}]}`, false, func(opts *qclient.ScanOptions) bool {
+			f := opts.Scans[0].Filter[0]
+			return f.Low == "D" && f.High == c.MaxUnbounded && f.Inclusion == qclient.Both
+		}},
+		{"inclusion", `{"scans": [{"filters": [{"high": 10, "inclusion": "neither"}]}]}`, false,
+			func(opts *qclient.ScanOptions) bool {
+				f := opts.Scans[0].Filter[0]
+				return f.Low == c.MinUnbounded && f.High == float64(10) && f.Inclusion == qclient.Neither
+			}},
+		{"seek", `{"scans": [{"seek": ["E", 25]}]}`, false, func(opts *qclient.ScanOptions) bool {
+			return reflect.DeepEqual(opts.Scans[0].Seek, c.SecondaryKey{"E", float64(25)})
+		}},
+		{"pagination", `{"offset": 10, "limit": 20, "distinct": true, "reverse": true}`, false,
+			func(opts *qclient.ScanOptions) bool {
+				return opts.Offset == 10 && opts.Limit == 20 && opts.Distinct && opts.Reverse
+			}},
+		{"partitions", `{"partitions": [2, 3]}`, false, func(opts *qclient.ScanOptions) bool {
+			return reflect.DeepEqual(opts.Partitions, []c.PartitionId{2, 3})
+		}},
+		{"group aggregate", `{"groupAggr": {"group": [{"entryKeyId": 3, "keyPos": 0}],
+			"aggregates": [{"func": "COUNT", "entryKeyId": 4, "expr": "cover ((d.age))"}]}}`, false,
+			func(opts *qclient.ScanOptions) bool {
+				g := opts.GroupAggr
+				return g.Group[0].KeyPos == 0 && g.Group[0].EntryKeyId == 3 &&
+					g.Aggrs[0].AggrFunc == c.AGG_COUNT && g.Aggrs[0].KeyPos == -1
+			}},
+		{"session consistency", `{"stale": "false"}`, false, func(opts *qclient.ScanOptions) bool {
+			return opts.Consistency == c.SessionConsistency
+		}},
+		{"query consistency", `{"stale": "partial", "timestamp": {"30": ["213423442342342", "350"]}}`, false,
+			func(opts *qclient.ScanOptions) bool {
+				return opts.Consistency == c.QueryConsistency && opts.Vector != nil
+			}},
+		{"missing timestamp", `{"stale": "partial"}`, true, nil},
+		{"invalid timestamp", `{"stale": "partial", "timestamp": {"30": ["350"]}}`, true, nil},
+		{"invalid vbuuid", `{"stale": "partial", "timestamp": {"30": ["x", "350"]}}`, true, nil},
+		{"invalid stale", `{"stale": "maybe"}`, true, nil},
+		{"invalid inclusion", `{"scans": [{"filters": [{"inclusion": "all"}]}]}`, true, nil},
+		{"invalid func", `{"groupAggr": {"aggregates": [{"func": "avg"}]}}`, true, nil},
+		{"null scan", `{"scans": [null]}`, true, nil},
+		{"negative offset", `{"offset": -1}`, true, nil},
+		{"negative limit", `{"limit": -1}`, true, nil},
+		{"negative timeout", `{"timeout": -1}`, true, nil},
+	}
+
+	for _, tc := range testcases {
+		body, err := decodeScanRequest(tc.body)
+		if err != nil {
+			t.Errorf("%v: unexpected decode error %v", tc.comment, err)
+			continue
+		}
+
+		opts, err := body.scanOptions()
+		if tc.err {
+			if err == nil {
+				t.Errorf("%v: expected error, got %+v", tc.comment, opts)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tc.comment, err)
+		} else if !tc.check(opts) {
+			t.Errorf("%v: unexpected options %+v", tc.comment, opts)
+		}
+	}
+}
+
+func TestValidatePartitions(t *testing.T) {
+
+	single := &c.IndexDefn{PartitionScheme: c.SINGLE}
+	partitioned := &c.IndexDefn{PartitionScheme: c.KEY, NumPartitions: 8}
+
+	testcases := []struct {
+		comment    string
+		defn       *c.IndexDefn
+		partitions []c.PartitionId
+		valid      bool
+	}{
+		{"non-partitioned, all partitions", single, nil, true},
+		{"non-partitioned, partitions", single, []c.PartitionId{0}, false},
+		{"partitioned, all partitions", partitioned, nil, true},
+		{"partitioned, in range", partitioned, []c.PartitionId{1, 8}, true},
+		{"partitioned, zero", partitioned, []c.PartitionId{0}, false},
+		{"partitioned, out of range", partitioned, []c.PartitionId{1, 9}, false},
+	}
+
+	for _, tc := range testcases {
+		err := validatePartitions(tc.defn, tc.partitions)
+		if valid := err == nil; valid != tc.valid {
+			t.Errorf("%v: expected valid %v, got error %v", tc.comment, tc.valid, err)
+		}
+	}
+}
+
+func TestScanErrorStatus(t *testing.T) {
+
+	testcases := []struct {
+		err    error
+		status int
+	}{
+		{qclient.ErrorIndexNotFound, http.StatusNotFound},
+		{c.ErrIndexNotFound, http.StatusNotFound},
+		{qclient.ErrorNoHost, http.StatusServiceUnavailable},
+		{c.ErrIndexNotReady, http.StatusServiceUnavailable},
+		{errors.New(c.ErrIndexNotReady.Error()), http.StatusServiceUnavailable},
+		{qclient.ErrorExpectedTimestamp, http.StatusBadRequest},
+		{context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{errors.New("scan error"), http.StatusInternalServerError},
+	}
+
+	for _, tc := range testcases {
+		if status := scanErrorStatus(tc.err); status != tc.status {
+			t.Errorf("%v: expected %v, got %v", tc.err, tc.status, status)
+		}
+	}
+}
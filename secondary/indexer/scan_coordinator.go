@@ -13,6 +13,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"net/http"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -24,6 +26,7 @@ import (
 	p "github.com/couchbase/indexing/secondary/pipeline"
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/indexing/secondary/queryport"
+	"github.com/couchbase/indexing/secondary/tracing"
 	"github.com/golang/protobuf/proto"
 )
 
@@ -44,6 +47,7 @@ func init() {
 }
 
 type ScanCoordinator interface {
+	RegisterRestEndpoints(mux *http.ServeMux)
 }
 
 type scanCoordinator struct {
@@ -68,6 +72,14 @@ type scanCoordinator struct {
 	stats IndexerStatsHolder
 
 	indexerState atomic.Value
+
+	workload *workloadCapture
+
+	// traces scan requests that carry a trace context
+	tracer *tracing.Tracer
+
+	// snapshots pinned for the sub-range scans of split ranges
+	pinnedSnapshots *pinnedSnapshots
 }
 
 // NewScanCoordinator returns an instance of scanCoordinator or err message
@@ -86,11 +98,21 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 		snapshotNotifych: snapshotNotifych,
 		logPrefix:        "ScanCoordinator",
 		reqCounter:       0,
+		workload:         newWorkloadCapture(),
+		pinnedSnapshots:  newPinnedSnapshots(),
 	}
 
 	s.config.Store(config)
 	s.initRollbackInProgress()
 
+	tracePath := config["tracing.file"].String()
+	if !filepath.IsAbs(tracePath) {
+		tracePath = filepath.Join(config["diagnostics_dir"].String(), tracePath)
+	}
+	// Indexer does not start traces of its own, hence the sample rate of 0.
+	s.tracer = tracing.NewFileTracer("indexer", config["tracing.enable"].Bool(), 0, tracePath,
+		int64(config["tracing.maxFileSize"].Int()))
+
 	addr := net.JoinHostPort("", config["scanPort"].String())
 	queryportCfg := config.SectionConfig("queryport.", true)
 	s.serv, err = queryport.NewServer(addr, s.serverCallback, createConnectionContext, queryportCfg)
@@ -115,6 +137,14 @@ func NewScanCoordinator(supvCmdch MsgChannel, supvMsgch MsgChannel,
 
 }
 
+// RegisterRestEndpoints registers the REST endpoints of scan coordinator
+// on mux.
+func (s *scanCoordinator) RegisterRestEndpoints(mux *http.ServeMux) {
+	mux.HandleFunc("/workload/capture", s.handleWorkloadCapture)
+	mux.HandleFunc("/workload/advise", s.handleWorkloadAdvise)
+	mux.HandleFunc("/backindex/lookup", s.handleBackIndexLookup)
+}
+
 func (s *scanCoordinator) run() {
 loop:
 	for {
@@ -124,6 +154,7 @@ loop:
 				if cmd.GetMsgType() == SCAN_COORD_SHUTDOWN {
 					logging.Infof("ScanCoordinator: Shutting Down")
 					s.serv.Close()
+					s.tracer.Close()
 					s.supvCmdch <- &MsgSuccess{}
 					break loop
 				}
@@ -277,7 +308,10 @@ func (s *scanCoordinator) serverCallback(protoReq interface{}, ctx interface{},
 	}
 
 	t0 := time.Now()
+	snapSpan := req.span.StartChild("indexer.snapshotWait")
 	is, err := s.getRequestedIndexSnapshot(req)
+	snapSpan.SetError(err)
+	snapSpan.Finish()
 	if s.tryRespondWithError(w, req, err) {
 		return
 	}
@@ -370,6 +404,8 @@ func (s *scanCoordinator) processRequest(req *ScanRequest, w ScanResponseWriter,
 		s.handleMultiScanCountRequest(req, w, is, t0)
 	case StatsReq:
 		s.handleStatsRequest(req, w, is)
+	case SplitPointsReq:
+		s.handleSplitPointsRequest(req, w, is)
 	}
 }
 
@@ -410,6 +446,10 @@ func (s *scanCoordinator) handleScanRequest(req *ScanRequest, w ScanResponseWrit
 		}
 	}
 
+	if err == nil {
+		s.captureWorkload(req, scanPipeline.RowsScanned(), scanPipeline.RowsReturned())
+	}
+
 	if err != nil {
 		status := fmt.Sprintf("(error = %s)", err)
 		logging.LazyVerbose(func() string {
@@ -524,6 +564,51 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 	s.handleError(req.LogPrefix, err)
 }
 
+func (s *scanCoordinator) handleSplitPointsRequest(req *ScanRequest, w ScanResponseWriter,
+	is IndexSnapshot) {
+	var keys [][]byte
+	var err error
+	var snapshots []SliceSnapshot
+
+	// Keys of an index with desc collation are not split, client scans
+	// the whole range.
+	if !req.isPrimary && req.IndexInst.Defn.Desc == nil {
+		if snapshots, err = GetSliceSnapshots(is, req.PartitionIds); err == nil {
+			keys, err = getRangeSplitKeys(snapshots, req.Low, req.High, req.NumSplits)
+		}
+	}
+
+	var splitKeys [][]byte
+	for _, key := range keys {
+		if err != nil {
+			break
+		}
+		var decoded []byte
+		if decoded, err = jsonEncoder.Decode(key, make([]byte, 0, len(key)*3)); err == nil {
+			splitKeys = append(splitKeys, decoded)
+		}
+	}
+
+	if s.tryRespondWithError(w, req, err) {
+		return
+	}
+
+	// Sub-ranges are scanned from this snapshot, whatever their consistency.
+	var snapshotId uint64
+	if len(splitKeys) != 0 {
+		expiry := time.Millisecond * time.Duration(s.config.Load()["settings.scan_timeout"].Int())
+		if expiry == 0 {
+			expiry = defaultPinnedSnapshotExpiry
+		}
+		snapshotId = s.pinnedSnapshots.pin(is, len(splitKeys)+1, expiry)
+	}
+
+	logging.Verbosef("%s RESPONSE splitKeys:%d snapshotId:%d status:ok", req.LogPrefix,
+		len(splitKeys), snapshotId)
+	err = w.SplitPoints(splitKeys, snapshotId)
+	s.handleError(req.LogPrefix, err)
+}
+
 /////////////////////////////////////////////////////////////////////////
 //
 //  scan helpers
@@ -539,6 +624,11 @@ func (s *scanCoordinator) handleStatsRequest(req *ScanRequest, w ScanResponseWri
 // will block wait.
 // This mechanism can be used to implement RYOW.
 func (s *scanCoordinator) getRequestedIndexSnapshot(r *ScanRequest) (snap IndexSnapshot, err error) {
+	// sub-range of a split range is scanned from the pinned snapshot
+	if r.SnapshotId != 0 {
+		return s.pinnedSnapshots.take(r.SnapshotId, r.IndexInstId)
+	}
+
 	snapshot, err := func() (IndexSnapshot, error) {
 		s.mu.RLock()
 		defer s.mu.RUnlock()
@@ -690,6 +780,10 @@ func (s *scanCoordinator) respondWithError(conn net.Conn, req *ScanRequest, err
 		res = &protobuf.ResponseStream{
 			Err: protoErr,
 		}
+	case SplitPointsReq:
+		res = &protobuf.SplitPointsResponse{
+			Err: protoErr,
+		}
 	}
 
 	err2 := protobuf.EncodeAndWrite(conn, *buf, res)
@@ -714,6 +808,7 @@ func (s *scanCoordinator) handleError(prefix string, err error) {
 
 func (s *scanCoordinator) tryRespondWithError(w ScanResponseWriter, req *ScanRequest, err error) bool {
 	if err != nil {
+		req.span.SetError(err)
 		if err == common.ErrIndexNotReady && req.Stats != nil {
 			req.Stats.notReadyError.Add(1)
 		} else if err == common.ErrIndexNotFound {
@@ -750,6 +845,9 @@ func (s *scanCoordinator) handleStats(cmd Message) {
 	stats := s.stats.Get()
 	st := s.serv.Statistics()
 	stats.numConnections.Set(st.Connections)
+	stats.scanCompressedBytes.Set(st.Compression["compressedBytes"].(int64))
+	stats.scanUncompressedBytes.Set(st.Compression["uncompressedBytes"].(int64))
+	stats.scanCompressTime.Set(st.Compression["compressTime"].(int64))
 
 	// Compute counts asynchronously and reply to stats request
 	go func() {
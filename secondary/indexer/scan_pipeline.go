@@ -21,6 +21,7 @@ import (
 	c "github.com/couchbase/indexing/secondary/common"
 	l "github.com/couchbase/indexing/secondary/logging"
 	p "github.com/couchbase/indexing/secondary/pipeline"
+	"github.com/couchbase/indexing/secondary/tracing"
 	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/value"
 )
@@ -46,6 +47,8 @@ type ScanPipeline struct {
 	cacheHitRatio int
 	exprEvalDur   time.Duration
 	exprEvalNum   int64
+
+	span *tracing.Span
 }
 
 func (p *ScanPipeline) Cancel(err error) {
@@ -53,7 +56,15 @@ func (p *ScanPipeline) Cancel(err error) {
 }
 
 func (p *ScanPipeline) Execute() error {
-	return p.object.Execute()
+	err := p.object.Execute()
+
+	p.span.SetAttribute("rowsScanned", p.rowsScanned)
+	p.span.SetAttribute("rowsReturned", p.rowsReturned)
+	p.span.SetAttribute("bytesRead", p.bytesRead)
+	p.span.SetError(err)
+	p.span.Finish()
+
+	return err
 }
 
 func (p ScanPipeline) RowsReturned() uint64 {
@@ -84,6 +95,7 @@ func NewScanPipeline(req *ScanRequest, w ScanResponseWriter, is IndexSnapshot, c
 	scanPipeline := new(ScanPipeline)
 	scanPipeline.req = req
 	scanPipeline.config = cfg
+	scanPipeline.span = req.span.StartChild("indexer.pipeline")
 
 	src := &IndexScanSource{is: is, p: scanPipeline}
 	src.InitWriter()
@@ -137,6 +149,9 @@ func (s *IndexScanSource) Routine() error {
 	var err error
 	defer s.CloseWrite()
 
+	span := s.p.span.StartChild("indexer.pipeline.source")
+	defer span.Finish()
+
 	r := s.p.req
 	var currentScan Scan
 	currOffset := int64(0)
@@ -418,6 +433,9 @@ func (d *IndexScanDecoder) Routine() error {
 	defer d.CloseWrite()
 	defer d.CloseRead()
 
+	span := d.p.span.StartChild("indexer.pipeline.decoder")
+	defer span.Finish()
+
 	var sk, docid []byte
 	tmpBuf := p.GetBlock()
 	defer p.PutBlock(tmpBuf)
@@ -486,12 +504,17 @@ func (d *IndexScanWriter) Routine() error {
 	var err error
 	var sk, pk []byte
 
+	span := d.p.span.StartChild("indexer.pipeline.writer")
+
 	defer func() {
 		// Send error to the client if not client requested cancel.
 		if err != nil && err.Error() != c.ErrClientCancel.Error() {
 			d.w.Error(err)
 		}
 		d.CloseRead()
+
+		span.SetError(err)
+		span.Finish()
 	}()
 
 loop:
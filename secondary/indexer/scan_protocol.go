@@ -26,6 +26,7 @@ type ScanResponseWriter interface {
 	Row(pk, sk []byte) error
 	Done() error
 	Helo() error
+	SplitPoints(keys [][]byte, snapshotId uint64) error
 }
 
 type protoResponseWriter struct {
@@ -73,6 +74,10 @@ func (w *protoResponseWriter) Error(err error) error {
 		res = &protobuf.ResponseStream{
 			Err: protoErr,
 		}
+	case SplitPointsReq:
+		res = &protobuf.SplitPointsResponse{
+			Err: protoErr,
+		}
 	}
 
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
@@ -93,7 +98,8 @@ func (w *protoResponseWriter) Stats(rows, unique uint64, min, max []byte) error
 
 func (w *protoResponseWriter) Helo() error {
 	res := &protobuf.HeloResponse{
-		Version: proto.Uint32(common.INDEXER_CUR_VERSION),
+		Version:     proto.Uint32(common.INDEXER_CUR_VERSION),
+		SplitPoints: proto.Bool(true),
 	}
 
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
@@ -107,6 +113,17 @@ func (w *protoResponseWriter) Count(c uint64) error {
 	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
 }
 
+func (w *protoResponseWriter) SplitPoints(keys [][]byte, snapshotId uint64) error {
+	res := &protobuf.SplitPointsResponse{
+		SplitKeys: keys,
+	}
+	if snapshotId != 0 {
+		res.SnapshotId = proto.Uint64(snapshotId)
+	}
+
+	return protobuf.EncodeAndWrite(w.conn, *w.encBuf, res)
+}
+
 func (w *protoResponseWriter) RawBytes(b []byte) error {
 	err := w.writeLen(len(b))
 	if err != nil {
@@ -24,6 +24,7 @@ import (
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 
 	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/tracing"
 	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/expression/parser"
 	"github.com/couchbase/query/value"
@@ -38,6 +39,7 @@ const (
 	ScanAllReq                    = "scanAll"
 	HeloReq                       = "helo"
 	MultiScanCountReq             = "multiscancount"
+	SplitPointsReq                = "splitpoints"
 )
 
 type ScanRequest struct {
@@ -90,6 +92,11 @@ type ScanRequest struct {
 	// Rollback Time
 	rollbackTime int64
 
+	// Number of sub-ranges requested by SplitPointsReq
+	NumSplits int
+	// Snapshot pinned by SplitPointsReq, to scan a sub-range from
+	SnapshotId uint64
+
 	ScanId      uint64
 	ExpiredTime time.Time
 	Timeout     *time.Timer
@@ -110,6 +117,9 @@ type ScanRequest struct {
 	connCtx *ConnectionContext
 
 	dataEncFmt common.DataEncodingFormat
+
+	// span of the request, nil if the client is not tracing the request
+	span *tracing.Span
 }
 
 type Projection struct {
@@ -368,9 +378,43 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 			return
 		}
 
+	case *protobuf.SplitPointsRequest:
+		r.DefnID = req.GetDefnID()
+		r.RequestId = req.GetRequestId()
+		r.rollbackTime = req.GetRollbackTime()
+		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
+		cons := common.Consistency(req.GetCons())
+		vector := req.GetVector()
+		r.ScanType = SplitPointsReq
+		r.Incl = Inclusion(req.GetSpan().GetRange().GetInclusion())
+		r.NumSplits = int(req.GetNumSplits())
+		r.Sorted = true
+
+		if isBootstrapMode {
+			err = common.ErrIndexerInBootstrap
+			return
+		}
+
+		if err = r.setIndexParams(); err != nil {
+			return
+		}
+
+		if err = r.setConsistency(cons, vector); err != nil {
+			return
+		}
+
+		err = r.fillRanges(
+			req.GetSpan().GetRange().GetLow(),
+			req.GetSpan().GetRange().GetHigh(),
+			req.GetSpan().GetEquals())
+		if err != nil {
+			return
+		}
+
 	case *protobuf.ScanRequest:
 		r.DefnID = req.GetDefnID()
 		r.RequestId = req.GetRequestId()
+		r.span = s.tracer.StartRemoteSpan("indexer.scan", req.GetTraceContext())
 		r.rollbackTime = req.GetRollbackTime()
 		r.PartitionIds = makePartitionIds(req.GetPartitionIds())
 		cons := common.Consistency(req.GetCons())
@@ -386,6 +430,7 @@ func NewScanRequest(protoReq interface{}, ctx interface{},
 			r.Distinct = req.GetDistinct()
 		}
 		r.Offset = req.GetOffset()
+		r.SnapshotId = req.GetSnapshotId()
 		if isBootstrapMode {
 			err = common.ErrIndexerInBootstrap
 			return
@@ -497,6 +542,15 @@ func (r *ScanRequest) Done() {
 	if r.Timeout != nil {
 		r.Timeout.Stop()
 	}
+
+	if r.span != nil {
+		r.span.SetAttribute("scanId", r.ScanId)
+		r.span.SetAttribute("requestId", r.RequestId)
+		r.span.SetAttribute("index", fmt.Sprintf("%v:%v", r.Bucket, r.IndexName))
+		r.span.SetAttribute("instId", r.IndexInstId)
+		r.span.SetAttribute("partitions", r.PartitionIds)
+		r.span.Finish()
+	}
 }
 
 func (r *ScanRequest) isNil(k []byte) bool {
@@ -1658,7 +1712,8 @@ func (r ScanRequest) String() string {
 		}
 
 		if len(r.Keys) == 0 {
-			if r.ScanType == StatsReq || r.ScanType == ScanReq || r.ScanType == CountReq {
+			if r.ScanType == StatsReq || r.ScanType == ScanReq || r.ScanType == CountReq ||
+				r.ScanType == SplitPointsReq {
 				span = fmt.Sprintf("range (%s,%s %s)", r.Low, r.High, incl)
 			} else {
 				span = "all"
@@ -1680,6 +1735,14 @@ func (r ScanRequest) String() string {
 		str += fmt.Sprintf(", limit:%d", r.Limit)
 	}
 
+	if r.NumSplits > 0 {
+		str += fmt.Sprintf(", numSplits:%d", r.NumSplits)
+	}
+
+	if r.SnapshotId != 0 {
+		str += fmt.Sprintf(", snapshotId:%d", r.SnapshotId)
+	}
+
 	if r.Consistency != nil {
 		str += fmt.Sprintf(", consistency:%s", strings.ToLower(r.Consistency.String()))
 	}
@@ -1688,6 +1751,10 @@ func (r ScanRequest) String() string {
 		str += fmt.Sprintf(", requestId:%v", r.RequestId)
 	}
 
+	if r.span != nil {
+		str += fmt.Sprintf(", traceId:%v", r.span.TraceId())
+	}
+
 	if r.GroupAggr != nil {
 		str += fmt.Sprintf(", groupaggr: %v", r.GroupAggr)
 	}
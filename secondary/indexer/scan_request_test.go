@@ -0,0 +1,81 @@
+package indexer
+
+import (
+	"sync"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+	"github.com/couchbase/indexing/secondary/tracing"
+	"github.com/golang/protobuf/proto"
+)
+
+type testSpanExporter struct {
+	mutex sync.Mutex
+	spans map[string]*tracing.SpanData // by name
+}
+
+func (e *testSpanExporter) Export(span *tracing.SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans[span.Name] = span
+	return nil
+}
+
+func (e *testSpanExporter) Close() error {
+	return nil
+}
+
+func TestScanRequestTraceContext(t *testing.T) {
+
+	exporter := &testSpanExporter{spans: make(map[string]*tracing.SpanData)}
+	client := tracing.NewTracer("gsiClient", 1, exporter)
+	partitionScan := client.StartSpan("client.partitionScan")
+
+	// scan request as sent by the client, over queryport.
+	data, err := protobuf.ProtobufEncode(&protobuf.ScanRequest{
+		DefnID:       proto.Uint64(100),
+		Span:         &protobuf.Span{},
+		Distinct:     proto.Bool(false),
+		Limit:        proto.Int64(10),
+		Cons:         proto.Uint32(uint32(c.AnyConsistency)),
+		RequestId:    proto.String("request"),
+		TraceContext: proto.String(partitionScan.Context().String()),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	protoReq, err := protobuf.ProtobufDecode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &scanCoordinator{tracer: tracing.NewTracer("indexer", 0, exporter)}
+	s.config.Store(c.SystemConfig.SectionConfig("indexer.", true))
+	// bootstrap mode fails the request right after it is decoded.
+	s.setIndexerState(c.INDEXER_BOOTSTRAP)
+
+	r, err := NewScanRequest(protoReq, nil, nil, s)
+	if err != c.ErrIndexerInBootstrap {
+		t.Fatalf("expected %v, got %v", c.ErrIndexerInBootstrap, err)
+	}
+	snapshotWait := r.span.StartChild("indexer.snapshotWait")
+	snapshotWait.Finish()
+	r.Done()
+	partitionScan.Finish()
+
+	// indexer spans are in the trace of the client, under the partition scan.
+	scan, wait := exporter.spans["indexer.scan"], exporter.spans["indexer.snapshotWait"]
+	if scan == nil || wait == nil {
+		t.Fatalf("expected indexer spans, got %v", exporter.spans)
+	}
+	if scan.TraceId != partitionScan.TraceId() || wait.TraceId != partitionScan.TraceId() {
+		t.Errorf("expected trace %v, got %v %v", partitionScan.TraceId(), scan.TraceId, wait.TraceId)
+	}
+	if scan.ParentId != partitionScan.Context().SpanId || wait.ParentId != scan.SpanId {
+		t.Errorf("unexpected span hierarchy %+v, %+v", scan, wait)
+	}
+	if scan.Service != "indexer" || scan.Attributes["requestId"] != "request" {
+		t.Errorf("unexpected indexer span %+v", scan)
+	}
+}
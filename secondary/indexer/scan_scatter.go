@@ -197,6 +197,14 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 		}
 	}
 
+	span := request.span.StartChild("indexer.storageScan")
+	defer func() {
+		span.SetAttribute("partitionId", partitionId)
+		span.SetAttribute("scanType", scan.ScanType)
+		span.SetAttribute("rows", count)
+		span.Finish()
+	}()
+
 	var err error
 	if scan.ScanType == AllReq {
 		err = snap.Snapshot().All(ctx, handler)
@@ -207,6 +215,7 @@ func scanSingleSlice(request *ScanRequest, scan Scan, ctx IndexReaderContext, sn
 	}
 
 	if err != nil {
+		span.SetError(err)
 		if err != ErrFinishCallback {
 			errch <- err
 		}
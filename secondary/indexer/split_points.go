@@ -0,0 +1,177 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package indexer
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+const (
+	// maximum number of sub-ranges of a split points request
+	maxRangeSplits = 64
+	// number of keys sampled from the whole snapshot per requested split,
+	// so that a range covering a part of the index still gets split.
+	rangeSplitSampleFactor = 16
+	// time a pinned snapshot is retained for the sub-range scans, if the
+	// scan timeout is not set.
+	defaultPinnedSnapshotExpiry = 2 * time.Minute
+)
+
+//
+// RangeSplitter is implemented by snapshots that can estimate the keys
+// splitting the snapshot into nways ranges of about the same number of
+// entries, without scanning the snapshot.  Keys are returned in
+// collatejson format, in ascending storage order.
+//
+// Only memdb snapshots implement it, the keys are sampled from the
+// skiplist.  Forestdb and plasma have no such sampling, a range on these
+// storages is not split and is scanned as a whole by the client.
+//
+type RangeSplitter interface {
+	RangeSplitKeys(nways int) ([][]byte, error)
+}
+
+//
+// getRangeSplitKeys return upto numSplits-1 keys, in ascending order,
+// that split the range [low, high] of the snapshots into sub-ranges of
+// about the same number of entries.  Keys are strictly within the range,
+// so that every key is a valid boundary.  No key is returned if the
+// storage cannot estimate split points.
+//
+func getRangeSplitKeys(snapshots []SliceSnapshot, low, high IndexKey,
+	numSplits int) ([][]byte, error) {
+
+	if numSplits > maxRangeSplits {
+		numSplits = maxRangeSplits
+	}
+	if numSplits < 2 {
+		return nil, nil
+	}
+
+	var samples [][]byte
+	for _, ss := range snapshots {
+		splitter, ok := ss.Snapshot().(RangeSplitter)
+		if !ok {
+			return nil, nil
+		}
+		keys, err := splitter.RangeSplitKeys(numSplits * rangeSplitSampleFactor)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			k := secondaryKey(key)
+			if k.ComparePrefixIndexKey(low) > 0 && k.ComparePrefixIndexKey(high) < 0 {
+				samples = append(samples, key)
+			}
+		}
+	}
+
+	// samples of different partitions are interleaved.
+	sort.Sort(byteSlices(samples))
+	uniq := samples[:0]
+	for _, key := range samples {
+		if len(uniq) == 0 || !bytes.Equal(uniq[len(uniq)-1], key) {
+			uniq = append(uniq, key)
+		}
+	}
+	samples = uniq
+
+	if len(samples) < numSplits {
+		return samples, nil
+	}
+
+	splitKeys := make([][]byte, 0, numSplits-1)
+	for i := 1; i < numSplits; i++ {
+		splitKeys = append(splitKeys, samples[i*len(samples)/numSplits])
+	}
+	return splitKeys, nil
+}
+
+type byteSlices [][]byte
+
+func (s byteSlices) Len() int           { return len(s) }
+func (s byteSlices) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byteSlices) Less(i, j int) bool { return bytes.Compare(s[i], s[j]) < 0 }
+
+//
+// pinnedSnapshots hold the snapshots that split points were read from,
+// so that all the sub-ranges of a split range are scanned from the same
+// snapshot.  A snapshot is released once it is taken by every sub-range,
+// or when it expires, if some sub-ranges are never scanned.
+//
+type pinnedSnapshots struct {
+	mu     sync.Mutex
+	nextId uint64
+	snaps  map[uint64]*pinnedSnapshot
+}
+
+type pinnedSnapshot struct {
+	is    IndexSnapshot
+	uses  int
+	timer *time.Timer
+}
+
+func newPinnedSnapshots() *pinnedSnapshots {
+	return &pinnedSnapshots{snaps: make(map[uint64]*pinnedSnapshot)}
+}
+
+//
+// pin the snapshot for the given number of uses, and return its id.
+//
+func (p *pinnedSnapshots) pin(is IndexSnapshot, uses int, expiry time.Duration) uint64 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.nextId++
+	id := p.nextId
+	p.snaps[id] = &pinnedSnapshot{
+		is:    CloneIndexSnapshot(is),
+		uses:  uses,
+		timer: time.AfterFunc(expiry, func() { p.release(id) }),
+	}
+	return id
+}
+
+//
+// take return a clone of the pinned snapshot of the index instance, it is
+// released on its last use.  ErrSnapNotAvailable is returned if the
+// snapshot is released or expired.
+//
+func (p *pinnedSnapshots) take(id uint64, instId common.IndexInstId) (IndexSnapshot, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	snap, ok := p.snaps[id]
+	if !ok || snap.is.IndexInstId() != instId {
+		return nil, ErrSnapNotAvailable
+	}
+
+	is := CloneIndexSnapshot(snap.is)
+	if snap.uses--; snap.uses <= 0 {
+		snap.timer.Stop()
+		delete(p.snaps, id)
+		DestroyIndexSnapshot(snap.is)
+	}
+	return is, nil
+}
+
+func (p *pinnedSnapshots) release(id uint64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if snap, ok := p.snaps[id]; ok {
+		delete(p.snaps, id)
+		DestroyIndexSnapshot(snap.is)
+	}
+}
@@ -0,0 +1,189 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+// Snapshot that cannot estimate split points, counting its references.
+type testSnapshot struct {
+	Snapshot
+	refs int32
+}
+
+func (s *testSnapshot) Open() error {
+	atomic.AddInt32(&s.refs, 1)
+	return nil
+}
+
+func (s *testSnapshot) Close() error {
+	atomic.AddInt32(&s.refs, -1)
+	return nil
+}
+
+type testSplitSnapshot struct {
+	testSnapshot
+	keys [][]byte
+}
+
+func (s *testSplitSnapshot) RangeSplitKeys(nways int) ([][]byte, error) {
+	return s.keys, nil
+}
+
+func newTestSplitSnapshot(t *testing.T, keys ...string) SliceSnapshot {
+
+	snap := &testSplitSnapshot{}
+	for _, key := range keys {
+		snap.keys = append(snap.keys, encodeTestBackIndexKey(t, key, nil))
+	}
+	return &sliceSnapshot{snap: snap}
+}
+
+func newTestIndexKey(t *testing.T, key string) IndexKey {
+
+	k, err := NewSecondaryKey([]byte(key), make([]byte, 0, len(key)*3+16))
+	if err != nil {
+		t.Fatalf("key %v: %v", key, err)
+	}
+	return k
+}
+
+func TestGetRangeSplitKeys(t *testing.T) {
+
+	testcases := []struct {
+		comment   string
+		snapshots []SliceSnapshot
+		low, high string // json, empty for unbounded
+		numSplits int
+		expected  string
+	}{
+		{"fewer keys than splits",
+			[]SliceSnapshot{newTestSplitSnapshot(t, `["a"]`, `["b"]`, `["c"]`)},
+			"", "", 4, `[["a"], ["b"], ["c"]]`},
+		{"evenly spaced",
+			[]SliceSnapshot{newTestSplitSnapshot(t, `[0]`, `[1]`, `[2]`, `[3]`, `[4]`, `[5]`, `[6]`, `[7]`,
+				`[8]`, `[9]`, `[10]`, `[11]`, `[12]`, `[13]`, `[14]`, `[15]`)},
+			"", "", 4, `[[4], [8], [12]]`},
+		{"clipped to range",
+			[]SliceSnapshot{newTestSplitSnapshot(t, `["a"]`, `["b"]`, `["c"]`, `["d"]`, `["e"]`)},
+			`["b"]`, `["d"]`, 4, `[["c"]]`},
+		{"clipped at low prefix",
+			[]SliceSnapshot{newTestSplitSnapshot(t, `["b", 1]`, `["b", 2]`, `["c", 1]`)},
+			`["b"]`, "", 4, `[["c", 1]]`},
+		{"clipped at high prefix",
+			[]SliceSnapshot{newTestSplitSnapshot(t, `["a", 1]`, `["b", 1]`, `["b", 2]`)},
+			"", `["b"]`, 4, `[["a", 1]]`},
+		{"empty range",
+			[]SliceSnapshot{newTestSplitSnapshot(t, `["a"]`, `["b"]`, `["c"]`)},
+			`["b"]`, `["b"]`, 4, `null`},
+		{"partitions deduplicated",
+			[]SliceSnapshot{
+				newTestSplitSnapshot(t, `["a"]`, `["c"]`, `["e"]`),
+				newTestSplitSnapshot(t, `["b"]`, `["c"]`, `["d"]`)},
+			"", "", 8, `[["a"], ["b"], ["c"], ["d"], ["e"]]`},
+		{"no split",
+			[]SliceSnapshot{newTestSplitSnapshot(t, `["a"]`, `["b"]`, `["c"]`)},
+			"", "", 1, `null`},
+		{"storage without split points",
+			[]SliceSnapshot{
+				newTestSplitSnapshot(t, `["a"]`, `["b"]`, `["c"]`),
+				&sliceSnapshot{snap: &testSnapshot{}}},
+			"", "", 4, `null`},
+	}
+
+	for _, tc := range testcases {
+		low, high := IndexKey(MinIndexKey), IndexKey(MaxIndexKey)
+		if tc.low != "" {
+			low = newTestIndexKey(t, tc.low)
+		}
+		if tc.high != "" {
+			high = newTestIndexKey(t, tc.high)
+		}
+
+		keys, err := getRangeSplitKeys(tc.snapshots, low, high, tc.numSplits)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tc.comment, err)
+			continue
+		}
+
+		var expected []interface{}
+		json.Unmarshal([]byte(tc.expected), &expected)
+
+		if got := decodeTestBackIndexKeys(t, keys); !reflect.DeepEqual(got, expected) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, expected, got)
+		}
+	}
+
+	// number of splits is capped
+	var keys []string
+	for i := 0; i < 4*maxRangeSplits; i++ {
+		keys = append(keys, fmt.Sprintf("[%d]", i))
+	}
+	splitKeys, _ := getRangeSplitKeys([]SliceSnapshot{newTestSplitSnapshot(t, keys...)},
+		MinIndexKey, MaxIndexKey, 2*maxRangeSplits)
+	if len(splitKeys) != maxRangeSplits-1 {
+		t.Errorf("capped: expected %v keys, got %v", maxRangeSplits-1, len(splitKeys))
+	}
+}
+
+func TestPinnedSnapshots(t *testing.T) {
+
+	snap := &testSnapshot{refs: 1}
+	is := &indexSnapshot{
+		instId: 11,
+		partns: map[common.PartitionId]PartitionSnapshot{
+			0: &partitionSnapshot{slices: map[SliceId]SliceSnapshot{0: &sliceSnapshot{snap: snap}}},
+		},
+	}
+
+	checkRefs := func(comment string, refs int32) {
+		if got := atomic.LoadInt32(&snap.refs); got != refs {
+			t.Errorf("%v: expected %v references, got %v", comment, refs, got)
+		}
+	}
+
+	p := newPinnedSnapshots()
+
+	// snapshot is released on its last use
+	id := p.pin(is, 2, time.Minute)
+	checkRefs("pinned", 2)
+
+	if _, err := p.take(id, 12); err != ErrSnapNotAvailable {
+		t.Errorf("other instance: expected %v, got %v", ErrSnapNotAvailable, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		taken, err := p.take(id, 11)
+		if err != nil || taken != is {
+			t.Errorf("take %v: expected snapshot, got %v %v", i, taken, err)
+		}
+		checkRefs("taken", 3-int32(i))
+		DestroyIndexSnapshot(taken)
+	}
+	checkRefs("released", 1)
+
+	if _, err := p.take(id, 11); err != ErrSnapNotAvailable {
+		t.Errorf("released: expected %v, got %v", ErrSnapNotAvailable, err)
+	}
+
+	// snapshot is released on expiry, if not taken
+	id2 := p.pin(is, 2, 10*time.Millisecond)
+	if id2 == id {
+		t.Errorf("expected a new snapshot id, got %v", id2)
+	}
+
+	for i := 0; i < 500 && atomic.LoadInt32(&snap.refs) != 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	checkRefs("expired", 1)
+
+	if _, err := p.take(id2, 11); err != ErrSnapNotAvailable {
+		t.Errorf("expired: expected %v, got %v", ErrSnapNotAvailable, err)
+	}
+}
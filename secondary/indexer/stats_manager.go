@@ -53,6 +53,8 @@ type BucketStats struct {
 
 	tsQueueSize   stats.Int64Val
 	numNonAlignTS stats.Int64Val
+
+	numMutationsCoalesced stats.Int64Val
 }
 
 func (s *BucketStats) Init() {
@@ -61,6 +63,7 @@ func (s *BucketStats) Init() {
 	s.numMutationsQueued.Init()
 	s.tsQueueSize.Init()
 	s.numNonAlignTS.Init()
+	s.numMutationsCoalesced.Init()
 }
 
 type IndexTimingStats struct {
@@ -119,6 +122,8 @@ type IndexStats struct {
 	numDocsProcessed          stats.Int64Val
 	numRequests               stats.Int64Val
 	lastScanTime              stats.Int64Val
+	statsSince                stats.Int64Val
+	numRequestsPersisted      stats.Int64Val
 	numCompletedRequests      stats.Int64Val
 	numRowsReturned           stats.Int64Val
 	numRequestsRange          stats.Int64Val
@@ -206,6 +211,8 @@ func (s *IndexStats) Init() {
 	s.numDocsProcessed.Init()
 	s.numRequests.Init()
 	s.lastScanTime.Init()
+	s.statsSince.Init()
+	s.numRequestsPersisted.Init()
 	s.numCompletedRequests.Init()
 	s.numRowsReturned.Init()
 	s.numRequestsRange.Init()
@@ -389,6 +396,11 @@ type IndexerStats struct {
 	statsResponse      stats.TimingStat
 	notFoundError      stats.Int64Val
 
+	// queryport response compression
+	scanCompressedBytes   stats.Int64Val
+	scanUncompressedBytes stats.Int64Val
+	scanCompressTime      stats.Int64Val
+
 	indexerState stats.Int64Val
 }
 
@@ -405,6 +417,9 @@ func (s *IndexerStats) Init() {
 	s.statsResponse.Init()
 	s.indexerState.Init()
 	s.notFoundError.Init()
+	s.scanCompressedBytes.Init()
+	s.scanUncompressedBytes.Init()
+	s.scanCompressTime.Init()
 }
 
 func (s *IndexerStats) Reset() {
@@ -428,6 +443,7 @@ func (s *IndexerStats) AddIndex(id common.IndexInstId, bucket string, name strin
 	if _, ok := s.indexes[id]; !ok {
 		idxStats := &IndexStats{name: name, bucket: bucket, replicaId: replicaId}
 		idxStats.Init()
+		idxStats.statsSince.Set(time.Now().UnixNano())
 		s.indexes[id] = idxStats
 
 		b.indexCount++
@@ -507,6 +523,9 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 	addStat("uptime", fmt.Sprintf("%s", time.Since(uptime)))
 	addStat("num_connections", is.numConnections.Value())
 	addStat("index_not_found_errcount", is.notFoundError.Value())
+	addStat("scan_compressed_bytes", is.scanCompressedBytes.Value())
+	addStat("scan_uncompressed_bytes", is.scanUncompressedBytes.Value())
+	addStat("scan_compress_time", is.scanCompressTime.Value())
 	addStat("memory_quota", is.memoryQuota.Value())
 	addStat("memory_used", is.memoryUsed.Value())
 	addStat("memory_used_storage", is.memoryUsedStorage.Value())
@@ -520,6 +539,7 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 	addStat("memory_rss", getRSS())
 	addStat("memory_free", getMemFree())
 	addStat("memory_total", getMemTotal())
+	addStat("disk_total", getDiskTotal())
 
 	indexerState := common.IndexerState(is.indexerState.Value())
 	if indexerState == common.INDEXER_PREPARE_UNPAUSE {
@@ -582,6 +602,10 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 
 		addStat("last_query_time", s.lastScanTime.Value())
 
+		// index stats, preserved across restart
+		addStat("stats_since", s.statsSince.Value())
+		addStat("num_requests_total", s.numRequestsPersisted.Value()+s.numRequests.Value())
+
 		// partition and index stats
 		addStat("num_completed_requests", s.numCompletedRequests.Value())
 		addStat("num_rows_returned",
@@ -905,6 +929,7 @@ func (is IndexerStats) GetStats(getPartition bool, skipEmpty bool) common.Statis
 		addStat("num_mutations_queued", s.numMutationsQueued.Value())
 		addStat("ts_queue_size", s.tsQueueSize.Value())
 		addStat("num_nonalign_ts", s.numNonAlignTS.Value())
+		addStat("num_mutations_coalesced", s.numMutationsCoalesced.Value())
 		if st := common.BucketSeqsTiming(s.bucket); st != nil {
 			addStat("timings/dcp_getseqs", st.Value())
 		}
@@ -1137,7 +1162,7 @@ func NewStatsManager(supvCmdch MsgChannel,
 
 	go s.run()
 	go s.runStatsDumpLogger()
-	StartCpuCollector()
+	StartCpuCollector(config["storage_dir"].String())
 	return s, &MsgSuccess{}
 }
 
@@ -1432,6 +1457,8 @@ func (s *statsManager) runStatsDumpLogger() {
 }
 
 const last_query_time = "lqt"
+const stats_since = "ssi"
+const num_requests_total = "nrt"
 const avg_scan_rate = "asr"
 const num_rows_scanned = "nrs"
 const last_num_rows_scanned = "lrs"
@@ -1456,6 +1483,8 @@ func (s *statsManager) runStatsPersister() {
 				for k, indexStats := range indexerStats.indexes {
 					instdId := strconv.FormatUint(uint64(k), 10)
 					statsToBePersisted[instdId+":"+last_query_time] = indexStats.lastScanTime.Value()
+					statsToBePersisted[instdId+":"+stats_since] = indexStats.statsSince.Value()
+					statsToBePersisted[instdId+":"+num_requests_total] = indexStats.numRequestsPersisted.Value() + indexStats.numRequests.Value()
 
 					for pk, partnStats := range indexStats.partitions {
 						partnId := strconv.FormatUint(uint64(pk), 10)
@@ -1535,6 +1564,16 @@ func (s *statsManager) updateStatsFromPersistence(indexerStats *IndexerStats) {
 				if ok {
 					indexerStats.indexes[instdId].lastScanTime.Set(val)
 				}
+			case stats_since:
+				val, ok := getInt64Val(value, statName)
+				if ok && val != 0 {
+					indexerStats.indexes[instdId].statsSince.Set(val)
+				}
+			case num_requests_total:
+				val, ok := getInt64Val(value, statName)
+				if ok {
+					indexerStats.indexes[instdId].numRequestsPersisted.Set(val)
+				}
 			}
 		}
 		if len(kstrs) == 3 { // partition level stat
@@ -125,9 +125,48 @@ func CreateMutationStreamReader(streamId common.StreamId, bucketQueueMap BucketQ
 
 	go r.syncWorker()
 
+	if dir := dpconf["replayDir"].String(); dir != "" {
+		go r.replayCapture(dir, dpconf)
+	}
+
 	return r, &MsgSuccess{}
 }
 
+//replayCapture replays mutations captured by the dataport of this
+//stream, refer dataport/capture.go, directly into the stream reader,
+//as if they are received from projector.
+func (r *mutationStreamReader) replayCapture(dir string, dpconf common.Config) {
+
+	prefix := dataport.CapturePrefix(string(StreamAddrMap[r.streamId]))
+	reader, err := dataport.NewCaptureReader(dir, prefix)
+	if err != nil {
+		logging.Errorf("MutationStreamReader::replayCapture Stream %v "+
+			"Error opening capture %v. Err %v", r.streamId, dir, err)
+		return
+	}
+	defer reader.Close()
+
+	opts := dataport.ReplayOptions{
+		Speed:  dpconf["replaySpeed"].Float64(),
+		MaxGap: time.Duration(dpconf["replayMaxGap"].Int()) * time.Millisecond,
+	}
+	sink := dataport.NewChannelSink(r.streamMutch, r.killch)
+	defer sink.Close()
+
+	logging.Infof("MutationStreamReader::replayCapture Stream %v "+
+		"Replaying %v from %v", r.streamId, prefix, dir)
+	stats, err := dataport.Replay(reader, sink, opts)
+	if err != nil {
+		logging.Errorf("MutationStreamReader::replayCapture Stream %v "+
+			"Error replaying capture %v. Err %v", r.streamId, dir, err)
+		return
+	}
+	logging.Infof("MutationStreamReader::replayCapture Stream %v "+
+		"Replayed %v records, %v mutations, %v connection errors in %v",
+		r.streamId, stats.Records, stats.Mutations, stats.ConnErrors,
+		stats.Elapsed)
+}
+
 //Shutdown shuts down the mutation stream and all workers.
 //This call doesn't return till shutdown is complete.
 func (r *mutationStreamReader) Shutdown() {
@@ -0,0 +1,873 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package indexer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/indexing/secondary/collatejson"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+/////////////////////////////////////////////////////////////////////////
+//
+// Workload capture
+//
+// When enabled, a sample of the scan requests is normalized into a scan
+// shape:  which index keys have equality or range filters, which keys
+// are projected and which keys are grouped.  Values are not part of the
+// shape.  The number of shapes per index is bounded.  When the bound is
+// reached, the least frequent shape is evicted.
+//
+/////////////////////////////////////////////////////////////////////////
+
+// Advice type
+const (
+	AdviceReorder   = "reorder"
+	AdviceCovering  = "covering"
+	AdvicePartial   = "partial"
+	AdviceRedundant = "redundant"
+)
+
+// Minimum fraction of the scans of an index with the same equality constant
+// before a partial index is suggested.
+const partialIndexMinShare = 0.9
+
+type ScanShape struct {
+	Equality   []int `json:"equality,omitempty"`
+	Range      []int `json:"range,omitempty"`
+	ProjectAll bool  `json:"projectAll,omitempty"`
+	Projected  []int `json:"projected,omitempty"`
+	GroupBy    []int `json:"groupBy,omitempty"`
+	Aggregate  bool  `json:"aggregate,omitempty"`
+}
+
+type ScanShapeStats struct {
+	Shape        ScanShape      `json:"shape"`
+	Count        uint64         `json:"count"`
+	RowsScanned  uint64         `json:"rowsScanned"`
+	RowsReturned uint64         `json:"rowsReturned"`
+	LastSeen     int64          `json:"lastSeen"`
+	Constants    map[int]string `json:"constants,omitempty"`
+
+	// equality keys whose value is not the same across scans
+	varied map[int]bool
+}
+
+type IndexWorkload struct {
+	DefnId common.IndexDefnId         `json:"defnId"`
+	Bucket string                     `json:"bucket"`
+	Name   string                     `json:"name"`
+	Count  uint64                     `json:"count"`
+	Shapes map[string]*ScanShapeStats `json:"shapes"`
+}
+
+type IndexAdvice struct {
+	Type           string             `json:"type"`
+	DefnId         common.IndexDefnId `json:"defnId"`
+	Bucket         string             `json:"bucket"`
+	Index          string             `json:"index"`
+	Recommendation string             `json:"recommendation"`
+	Keys           []string           `json:"keys,omitempty"`
+	Where          string             `json:"where,omitempty"`
+	Benefit        float64            `json:"estimatedBenefit"`
+}
+
+type workloadCapture struct {
+	mutex   sync.Mutex
+	indexes map[common.IndexDefnId]*IndexWorkload
+}
+
+func newWorkloadCapture() *workloadCapture {
+	return &workloadCapture{
+		indexes: make(map[common.IndexDefnId]*IndexWorkload),
+	}
+}
+
+//
+// Normalize a scan request into a scan shape.  It also returns the
+// encoded value of each equality key.
+//
+func normalizeScanShape(req *ScanRequest) (ScanShape, map[int][]byte) {
+
+	var shape ScanShape
+
+	numKeys := len(req.IndexInst.Defn.SecExprs)
+	equality := make([]bool, numKeys)
+	filtered := make([]bool, numKeys)
+	values := make(map[int][]byte)
+
+	for i := 0; i < numKeys; i++ {
+		equality[i] = len(req.Scans) != 0
+	}
+
+	for _, scan := range req.Scans {
+		if scan.ScanType == AllReq {
+			for i := 0; i < numKeys; i++ {
+				equality[i] = false
+			}
+			continue
+		}
+
+		for _, filter := range scan.Filters {
+			for i := 0; i < numKeys; i++ {
+				if i >= len(filter.CompositeFilters) {
+					equality[i] = false
+					continue
+				}
+
+				cf := filter.CompositeFilters[i]
+				if cf.Low == MinIndexKey && cf.High == MaxIndexKey {
+					equality[i] = false
+					continue
+				}
+
+				filtered[i] = true
+				if cf.Inclusion != Both || cf.Low == MinIndexKey || cf.High == MaxIndexKey ||
+					cf.Low == MaxIndexKey || cf.High == MinIndexKey || !bytes.Equal(cf.Low.Bytes(), cf.High.Bytes()) {
+					equality[i] = false
+					continue
+				}
+
+				if value, ok := values[i]; !ok {
+					values[i] = cf.Low.Bytes()
+				} else if !bytes.Equal(value, cf.Low.Bytes()) {
+					// multiple values (e.g. IN list) is still an equality filter
+					values[i] = nil
+				}
+			}
+		}
+	}
+
+	for i := 0; i < numKeys; i++ {
+		if filtered[i] && equality[i] {
+			shape.Equality = append(shape.Equality, i)
+		} else if filtered[i] {
+			shape.Range = append(shape.Range, i)
+			delete(values, i)
+		} else {
+			delete(values, i)
+		}
+	}
+
+	if req.Indexprojection == nil {
+		shape.ProjectAll = true
+	} else if req.Indexprojection.projectSecKeys {
+		for i, projected := range req.Indexprojection.projectionKeys {
+			if projected && i < numKeys {
+				shape.Projected = append(shape.Projected, i)
+			}
+		}
+	}
+
+	if req.GroupAggr != nil {
+		for _, group := range req.GroupAggr.Group {
+			if group.KeyPos >= 0 {
+				shape.GroupBy = append(shape.GroupBy, int(group.KeyPos))
+			}
+		}
+		shape.Aggregate = len(req.GroupAggr.Aggrs) != 0
+	}
+
+	return shape, values
+}
+
+func (s ScanShape) key() string {
+	return fmt.Sprintf("eq%v|rg%v|pj%v,%v|gb%v|ag%v", s.Equality, s.Range, s.ProjectAll, s.Projected, s.GroupBy, s.Aggregate)
+}
+
+//
+// Add a scan request to the captured workload.
+//
+func (w *workloadCapture) add(req *ScanRequest, rowsScanned uint64, rowsReturned uint64, maxShapes int) {
+
+	shape, values := normalizeScanShape(req)
+	key := shape.key()
+	defn := &req.IndexInst.Defn
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	workload, ok := w.indexes[defn.DefnId]
+	if !ok {
+		workload = &IndexWorkload{
+			DefnId: defn.DefnId,
+			Bucket: defn.Bucket,
+			Name:   defn.Name,
+			Shapes: make(map[string]*ScanShapeStats),
+		}
+		w.indexes[defn.DefnId] = workload
+	}
+	workload.Name = defn.Name
+	workload.Count++
+
+	stats, ok := workload.Shapes[key]
+	if !ok {
+		if maxShapes > 0 && len(workload.Shapes) >= maxShapes {
+			w.evict(workload)
+		}
+
+		stats = &ScanShapeStats{
+			Shape:     shape,
+			Constants: make(map[int]string),
+			varied:    make(map[int]bool),
+		}
+		workload.Shapes[key] = stats
+	}
+
+	stats.Count++
+	stats.RowsScanned += rowsScanned
+	stats.RowsReturned += rowsReturned
+	stats.LastSeen = time.Now().UnixNano()
+
+	// Remember the value of equality keys as long as it does not change.
+	// Values of descending keys are not decoded.
+	for pos, value := range values {
+		if stats.varied[pos] {
+			continue
+		}
+
+		if value == nil || (pos < len(defn.Desc) && defn.Desc[pos]) {
+			stats.varied[pos] = true
+			delete(stats.Constants, pos)
+			continue
+		}
+
+		text, err := decodeShapeValue(value)
+		if err != nil {
+			stats.varied[pos] = true
+			continue
+		}
+
+		if current, ok := stats.Constants[pos]; !ok && stats.Count == 1 {
+			stats.Constants[pos] = text
+		} else if !ok || current != text {
+			stats.varied[pos] = true
+			delete(stats.Constants, pos)
+		}
+	}
+}
+
+//
+// Evict the least frequent (then least recent) shape of an index.
+//
+func (w *workloadCapture) evict(workload *IndexWorkload) {
+
+	var victim string
+	var min *ScanShapeStats
+
+	for key, stats := range workload.Shapes {
+		if min == nil || stats.Count < min.Count || (stats.Count == min.Count && stats.LastSeen < min.LastSeen) {
+			victim = key
+			min = stats
+		}
+	}
+
+	if min != nil {
+		delete(workload.Shapes, victim)
+	}
+}
+
+func (w *workloadCapture) reset() {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	w.indexes = make(map[common.IndexDefnId]*IndexWorkload)
+}
+
+//
+// Return a copy of the captured workload
+//
+func (w *workloadCapture) snapshot() map[common.IndexDefnId]*IndexWorkload {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	result := make(map[common.IndexDefnId]*IndexWorkload)
+	for defnId, workload := range w.indexes {
+		copied := *workload
+		copied.Shapes = make(map[string]*ScanShapeStats)
+		for key, stats := range workload.Shapes {
+			s := *stats
+			s.Constants = make(map[int]string)
+			for pos, value := range stats.Constants {
+				s.Constants[pos] = value
+			}
+			copied.Shapes[key] = &s
+		}
+		result[defnId] = &copied
+	}
+
+	return result
+}
+
+//
+// Remove the equality key values from a copy of the captured workload
+//
+func (w *IndexWorkload) redactConstants() {
+	for _, stats := range w.Shapes {
+		stats.Constants = nil
+	}
+}
+
+func decodeShapeValue(value []byte) (string, error) {
+
+	codec := collatejson.NewCodec(16)
+	size := len(value) * 3
+	if size < collatejson.MinBufferSize {
+		size = collatejson.MinBufferSize
+	}
+
+	text, err := codec.Decode(value, make([]byte, 0, size))
+	if err != nil {
+		return "", err
+	}
+	return string(text), nil
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+// Index advisor
+//
+// Estimated benefit is a number between 0 and 1:
+// - reorder:    fraction of the rows scanned by the index that would be
+//               skipped if the keys are reordered.
+// - covering:   fraction of the scans that fetch documents because no
+//               index key is projected.
+// - partial:    fraction of the index entries that would not be indexed
+//               by the partial index.
+// - redundant:  fraction of the redundant index that is duplicated in
+//               the covering index.
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// Analyse the captured workload against the index definitions.  itemsCount
+// is the number of entries in each index, if known.
+//
+func adviseIndexes(defns map[common.IndexDefnId]*common.IndexDefn, workloads map[common.IndexDefnId]*IndexWorkload,
+	itemsCount map[common.IndexDefnId]int64) []*IndexAdvice {
+
+	var result []*IndexAdvice
+
+	for defnId, workload := range workloads {
+		defn, ok := defns[defnId]
+		if !ok || defn.IsPrimary || workload.Count == 0 {
+			continue
+		}
+
+		result = append(result, adviseReorder(defn, workload)...)
+		result = append(result, adviseCovering(defn, workload)...)
+		result = append(result, advisePartial(defn, workload, itemsCount[defnId])...)
+	}
+
+	result = append(result, adviseRedundant(defns, workloads)...)
+
+	sort.Sort(indexAdviceSorter(result))
+
+	return result
+}
+
+//
+// Number of leading index keys that can be used to position the scan:
+// all leading equality keys, followed by at most one range key.
+//
+func usableKeyPrefix(order []int, equality map[int]bool, ranged map[int]bool) int {
+
+	usable := 0
+	for _, pos := range order {
+		if equality[pos] {
+			usable++
+		} else if ranged[pos] {
+			usable++
+			break
+		} else {
+			break
+		}
+	}
+	return usable
+}
+
+func adviseReorder(defn *common.IndexDefn, workload *IndexWorkload) []*IndexAdvice {
+
+	advices := make(map[string]*IndexAdvice)
+
+	for _, stats := range workload.Shapes {
+		if stats.RowsScanned == 0 || stats.RowsScanned <= stats.RowsReturned {
+			continue
+		}
+
+		equality := make(map[int]bool)
+		for _, pos := range stats.Shape.Equality {
+			equality[pos] = true
+		}
+		ranged := make(map[int]bool)
+		for _, pos := range stats.Shape.Range {
+			ranged[pos] = true
+		}
+
+		current := make([]int, len(defn.SecExprs))
+		for i := range current {
+			current[i] = i
+		}
+
+		// equality keys first, then the first range key, then the rest in current order
+		ideal := make([]int, 0, len(defn.SecExprs))
+		ideal = append(ideal, stats.Shape.Equality...)
+		if len(stats.Shape.Range) != 0 {
+			ideal = append(ideal, stats.Shape.Range[0])
+		}
+		for _, pos := range current {
+			if !equality[pos] && (len(stats.Shape.Range) == 0 || pos != stats.Shape.Range[0]) {
+				ideal = append(ideal, pos)
+			}
+		}
+
+		if usableKeyPrefix(current, equality, ranged) >= usableKeyPrefix(ideal, equality, ranged) {
+			continue
+		}
+
+		keys := make([]string, 0, len(ideal))
+		for _, pos := range ideal {
+			keys = append(keys, defn.SecExprs[pos])
+		}
+
+		filtered := float64(stats.RowsScanned-stats.RowsReturned) / float64(stats.RowsScanned)
+		share := float64(stats.Count) / float64(workload.Count)
+
+		id := strings.Join(keys, ",")
+		advice, ok := advices[id]
+		if !ok {
+			advice = &IndexAdvice{
+				Type:   AdviceReorder,
+				DefnId: defn.DefnId,
+				Bucket: defn.Bucket,
+				Index:  defn.Name,
+				Keys:   keys,
+				Recommendation: fmt.Sprintf("Reorder index keys to (%v) so that equality keys lead the range keys",
+					strings.Join(keys, ", ")),
+			}
+			advices[id] = advice
+		}
+		advice.Benefit += filtered * share
+	}
+
+	result := make([]*IndexAdvice, 0, len(advices))
+	for _, advice := range advices {
+		result = append(result, advice)
+	}
+	return result
+}
+
+func adviseCovering(defn *common.IndexDefn, workload *IndexWorkload) []*IndexAdvice {
+
+	var count uint64
+	keys := make(map[int]bool)
+
+	for _, stats := range workload.Shapes {
+		shape := stats.Shape
+		if shape.ProjectAll || len(shape.Projected) != 0 || len(shape.GroupBy) != 0 || shape.Aggregate {
+			continue
+		}
+
+		count += stats.Count
+		for _, pos := range shape.Equality {
+			keys[pos] = true
+		}
+		for _, pos := range shape.Range {
+			keys[pos] = true
+		}
+	}
+
+	if count == 0 {
+		return nil
+	}
+
+	positions := make([]int, 0, len(keys))
+	for pos, _ := range keys {
+		positions = append(positions, pos)
+	}
+	sort.Ints(positions)
+
+	names := make([]string, 0, len(positions))
+	for _, pos := range positions {
+		names = append(names, defn.SecExprs[pos])
+	}
+
+	return []*IndexAdvice{&IndexAdvice{
+		Type:   AdviceCovering,
+		DefnId: defn.DefnId,
+		Bucket: defn.Bucket,
+		Index:  defn.Name,
+		Keys:   names,
+		Recommendation: "Scans project only document keys and fetch documents.  " +
+			"Add the fields referenced by the query to the index keys to cover the query",
+		Benefit: float64(count) / float64(workload.Count),
+	}}
+}
+
+func advisePartial(defn *common.IndexDefn, workload *IndexWorkload, itemsCount int64) []*IndexAdvice {
+
+	var result []*IndexAdvice
+
+	for pos, expr := range defn.SecExprs {
+
+		var count, scanned uint64
+		var value string
+
+		for _, stats := range workload.Shapes {
+			constant, ok := stats.Constants[pos]
+			if !ok {
+				continue
+			}
+			if len(value) == 0 {
+				value = constant
+			}
+			if constant == value {
+				count += stats.Count
+				scanned += stats.RowsScanned
+			}
+		}
+
+		if count == 0 || float64(count)/float64(workload.Count) < partialIndexMinShare {
+			continue
+		}
+
+		where := fmt.Sprintf("%v = %v", expr, value)
+		if len(defn.WhereExpr) != 0 {
+			where = fmt.Sprintf("(%v) AND %v", defn.WhereExpr, where)
+		}
+
+		keys := make([]string, 0, len(defn.SecExprs))
+		for i, key := range defn.SecExprs {
+			if i != pos {
+				keys = append(keys, key)
+			}
+		}
+
+		// Without the index size, use the fraction of scans that can use the partial index.
+		benefit := float64(count) / float64(workload.Count)
+		if itemsCount > 0 {
+			benefit = 1 - (float64(scanned)/float64(count))/float64(itemsCount)
+			if benefit < 0 {
+				benefit = 0
+			}
+		}
+
+		result = append(result, &IndexAdvice{
+			Type:           AdvicePartial,
+			DefnId:         defn.DefnId,
+			Bucket:         defn.Bucket,
+			Index:          defn.Name,
+			Keys:           keys,
+			Where:          where,
+			Recommendation: fmt.Sprintf("Scans always filter on %v.  Create a partial index with WHERE %v", where, where),
+			Benefit:        benefit,
+		})
+	}
+
+	return result
+}
+
+//
+// An index is redundant if its keys are a leading subset of another index
+// with the same WHERE clause and partitioning.  Of two identical indexes,
+// the one with fewer captured scans is reported.
+//
+func adviseRedundant(defns map[common.IndexDefnId]*common.IndexDefn, workloads map[common.IndexDefnId]*IndexWorkload) []*IndexAdvice {
+
+	var result []*IndexAdvice
+
+	scans := func(defnId common.IndexDefnId) uint64 {
+		if workload, ok := workloads[defnId]; ok {
+			return workload.Count
+		}
+		return 0
+	}
+
+	isPrefix := func(a, b *common.IndexDefn) bool {
+		if len(a.SecExprs) > len(b.SecExprs) {
+			return false
+		}
+		for i, expr := range a.SecExprs {
+			if expr != b.SecExprs[i] {
+				return false
+			}
+			if a.HasDescending() != b.HasDescending() || (a.HasDescending() && a.Desc[i] != b.Desc[i]) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, a := range defns {
+		if a.IsPrimary || a.IsShadow() {
+			continue
+		}
+
+		for _, b := range defns {
+			if a.DefnId == b.DefnId || b.IsPrimary || b.IsShadow() || a.Bucket != b.Bucket ||
+				a.WhereExpr != b.WhereExpr || a.PartitionScheme != b.PartitionScheme ||
+				strings.Join(a.PartitionKeys, ",") != strings.Join(b.PartitionKeys, ",") || !isPrefix(a, b) {
+				continue
+			}
+
+			if len(a.SecExprs) == len(b.SecExprs) {
+				// identical index:  keep the one with more scans
+				if scans(a.DefnId) > scans(b.DefnId) || (scans(a.DefnId) == scans(b.DefnId) && a.DefnId < b.DefnId) {
+					continue
+				}
+			}
+
+			result = append(result, &IndexAdvice{
+				Type:           AdviceRedundant,
+				DefnId:         a.DefnId,
+				Bucket:         a.Bucket,
+				Index:          a.Name,
+				Keys:           b.SecExprs,
+				Recommendation: fmt.Sprintf("Index keys are a leading subset of index %v.  Consider dropping the index", b.Name),
+				Benefit:        float64(len(a.SecExprs)) / float64(len(b.SecExprs)),
+			})
+			break
+		}
+	}
+
+	return result
+}
+
+type indexAdviceSorter []*IndexAdvice
+
+func (s indexAdviceSorter) Len() int {
+	return len(s)
+}
+
+func (s indexAdviceSorter) Less(i, j int) bool {
+	if s[i].Benefit != s[j].Benefit {
+		return s[i].Benefit > s[j].Benefit
+	}
+	if s[i].DefnId != s[j].DefnId {
+		return s[i].DefnId < s[j].DefnId
+	}
+	return s[i].Type < s[j].Type
+}
+
+func (s indexAdviceSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+type indexWorkloadSorter []*IndexWorkload
+
+func (s indexWorkloadSorter) Len() int {
+	return len(s)
+}
+
+func (s indexWorkloadSorter) Less(i, j int) bool {
+	return s[i].DefnId < s[j].DefnId
+}
+
+func (s indexWorkloadSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+/////////////////////////////////////////////////////////////////////////
+//
+// Scan coordinator integration
+//
+/////////////////////////////////////////////////////////////////////////
+
+//
+// Capture a sample of the scan request if workload capture is enabled.
+//
+func (s *scanCoordinator) captureWorkload(req *ScanRequest, rowsScanned uint64, rowsReturned uint64) {
+
+	cfg := s.config.Load()
+	if !cfg["settings.workload_capture.enable"].Bool() || req.IndexInst.Defn.IsPrimary {
+		return
+	}
+
+	if rand.Float64() >= cfg["settings.workload_capture.sample_rate"].Float64() {
+		return
+	}
+
+	s.workload.add(req, rowsScanned, rowsReturned, cfg["settings.workload_capture.max_shapes_per_index"].Int())
+}
+
+func (s *scanCoordinator) getIndexDefns() map[common.IndexDefnId]*common.IndexDefn {
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	defns := make(map[common.IndexDefnId]*common.IndexDefn)
+	for _, inst := range s.indexInstMap {
+		if inst.State == common.INDEX_STATE_DELETED {
+			continue
+		}
+		defn := inst.Defn
+		defns[defn.DefnId] = &defn
+	}
+	return defns
+}
+
+func (s *scanCoordinator) getItemsCount() map[common.IndexDefnId]int64 {
+
+	result := make(map[common.IndexDefnId]int64)
+
+	stats := s.stats.Get()
+	if stats == nil {
+		return result
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for instId, idxStats := range stats.indexes {
+		if inst, ok := s.indexInstMap[instId]; ok {
+			result[inst.Defn.DefnId] += idxStats.partnInt64Stats(func(ss *IndexStats) int64 {
+				return ss.itemsCount.Value()
+			})
+		}
+	}
+	return result
+}
+
+//
+// GET    /workload/capture?bucket=<bucket>  return the captured workload
+// DELETE /workload/capture                  clear the captured workload
+//
+func (s *scanCoordinator) handleWorkloadCapture(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		bucket := r.FormValue("bucket")
+
+		result := make([]*IndexWorkload, 0)
+		for _, workload := range s.workload.snapshot() {
+			if len(bucket) != 0 && workload.Bucket != bucket {
+				continue
+			}
+			if !isIndexListAllowed(creds, workload.Bucket) {
+				continue
+			}
+			// constants are document data, not shown without read access to it
+			if !isDataReadAllowed(creds, workload.Bucket) {
+				workload.redactConstants()
+			}
+			result = append(result, workload)
+		}
+
+		sort.Sort(indexWorkloadSorter(result))
+		s.writeJson(w, result)
+
+	case "DELETE":
+		if !common.IsAllowed(creds, []string{"cluster.settings!write"}, w) {
+			return
+		}
+		logging.Infof("%v: clear captured workload", s.logPrefix)
+		s.workload.reset()
+		w.WriteHeader(http.StatusOK)
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+//
+// GET /workload/advise?bucket=<bucket>  return index advice based on the captured workload
+//
+func (s *scanCoordinator) handleWorkloadAdvise(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := s.validateAuth(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	bucket := r.FormValue("bucket")
+
+	defns := s.getIndexDefns()
+	for defnId, defn := range defns {
+		if (len(bucket) != 0 && defn.Bucket != bucket) || !isIndexListAllowed(creds, defn.Bucket) {
+			delete(defns, defnId)
+		}
+	}
+
+	// partial index advice is based on the constants, which are document data
+	workloads := s.workload.snapshot()
+	for _, workload := range workloads {
+		if !isDataReadAllowed(creds, workload.Bucket) {
+			workload.redactConstants()
+		}
+	}
+
+	advices := adviseIndexes(defns, workloads, s.getItemsCount())
+	if advices == nil {
+		advices = make([]*IndexAdvice, 0)
+	}
+
+	s.writeJson(w, advices)
+}
+
+func (s *scanCoordinator) validateAuth(w http.ResponseWriter, r *http.Request) (cbauth.Creds, bool) {
+	creds, valid, err := common.IsAuthValid(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error() + "\n"))
+	} else if valid == false {
+		w.WriteHeader(401)
+		w.Write([]byte("401 Unauthorized\n"))
+	}
+	return creds, valid
+}
+
+func (s *scanCoordinator) writeJson(w http.ResponseWriter, v interface{}) {
+
+	buf, err := json.Marshal(v)
+	if err != nil {
+		logging.Errorf("%v: fail to marshal response %v", s.logPrefix, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error() + "\n"))
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf)
+}
+
+func isIndexListAllowed(creds cbauth.Creds, bucket string) bool {
+	permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", bucket)
+	allowed, err := creds.IsAllowed(permission)
+	return err == nil && allowed
+}
+
+func isDataReadAllowed(creds cbauth.Creds, bucket string) bool {
+	permission := fmt.Sprintf("cluster.bucket[%s].data.docs!read", bucket)
+	allowed, err := creds.IsAllowed(permission)
+	return err == nil && allowed
+}
@@ -0,0 +1,114 @@
+package indexer
+
+import (
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestWorkloadAdvisor(t *testing.T) {
+
+	key := func(v string) IndexKey {
+		k, err := NewSecondaryKey([]byte(v), make([]byte, 0, 1024))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	defn := common.IndexDefn{
+		DefnId:   1,
+		Bucket:   "default",
+		Name:     "idx_age_city",
+		SecExprs: []string{"`age`", "`city`"},
+	}
+
+	// age > 30 AND city = "NY", project document keys only
+	req := &ScanRequest{
+		IndexInst:       common.IndexInst{Defn: defn},
+		Indexprojection: &Projection{projectSecKeys: false},
+		Scans: []Scan{Scan{
+			ScanType: FilterRangeReq,
+			Filters: []Filter{Filter{
+				CompositeFilters: []CompositeElementFilter{
+					CompositeElementFilter{Low: key("30"), High: MaxIndexKey, Inclusion: Neither},
+					CompositeElementFilter{Low: key(`"NY"`), High: key(`"NY"`), Inclusion: Both},
+				},
+			}},
+		}},
+	}
+
+	shape, values := normalizeScanShape(req)
+	if len(shape.Equality) != 1 || shape.Equality[0] != 1 {
+		t.Errorf("expected equality on key 1, got %v", shape.Equality)
+	}
+	if len(shape.Range) != 1 || shape.Range[0] != 0 {
+		t.Errorf("expected range on key 0, got %v", shape.Range)
+	}
+	if _, ok := values[1]; !ok || len(values) != 1 {
+		t.Errorf("expected equality value of key 1, got %v", values)
+	}
+
+	w := newWorkloadCapture()
+	for i := 0; i < 10; i++ {
+		w.add(req, 100, 10, 10)
+	}
+
+	workloads := w.snapshot()
+	if workloads[1] == nil || workloads[1].Count != 10 || len(workloads[1].Shapes) != 1 {
+		t.Fatalf("unexpected workload %v", workloads[1])
+	}
+
+	dup := defn
+	dup.DefnId = 2
+	dup.Name = "idx_age"
+	dup.SecExprs = []string{"`age`"}
+
+	defns := map[common.IndexDefnId]*common.IndexDefn{1: &defn, 2: &dup}
+	advices := adviseIndexes(defns, workloads, map[common.IndexDefnId]int64{1: 1000})
+
+	found := make(map[string]*IndexAdvice)
+	for _, advice := range advices {
+		found[advice.Type] = advice
+	}
+
+	if advice := found[AdviceReorder]; advice == nil || advice.Keys[0] != "`city`" || advice.Benefit != 0.9 {
+		t.Errorf("unexpected reorder advice %v", advice)
+	}
+	if advice := found[AdviceCovering]; advice == nil || advice.Benefit != 1 {
+		t.Errorf("unexpected covering advice %v", advice)
+	}
+	if advice := found[AdvicePartial]; advice == nil || advice.Where != "`city` = \"NY\"" {
+		t.Errorf("unexpected partial advice %v", advice)
+	}
+	if advice := found[AdviceRedundant]; advice == nil || advice.DefnId != 2 || advice.Benefit != 0.5 {
+		t.Errorf("unexpected redundant advice %v", advice)
+	}
+
+	// redacted constants are not shown, nor advised on
+	redacted := w.snapshot()
+	redacted[1].redactConstants()
+	for _, stats := range redacted[1].Shapes {
+		if len(stats.Constants) != 0 {
+			t.Errorf("expected no constants after redaction, got %v", stats.Constants)
+		}
+	}
+	for _, advice := range adviseIndexes(defns, redacted, map[common.IndexDefnId]int64{1: 1000}) {
+		if advice.Type == AdvicePartial {
+			t.Errorf("unexpected partial advice after redaction %v", advice)
+		}
+	}
+	for _, stats := range w.snapshot()[1].Shapes {
+		if len(stats.Constants) == 0 {
+			t.Errorf("expected captured constants to be unchanged")
+		}
+	}
+
+	// least frequent shape is evicted
+	other := *req
+	other.Indexprojection = nil
+	w.add(&other, 1, 1, 1)
+	if shapes := w.snapshot()[1].Shapes; len(shapes) != 1 {
+		t.Errorf("expected 1 shape after eviction, got %v", len(shapes))
+	}
+}
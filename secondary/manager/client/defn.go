@@ -50,6 +50,8 @@ const (
 	OPCODE_UPDATE_REPLICA_COUNT                     = OPCODE_DROP_INSTANCE + 1
 	OPCODE_GET_REPLICA_COUNT                        = OPCODE_UPDATE_REPLICA_COUNT + 1
 	OPCODE_CHECK_TOKEN_EXIST                        = OPCODE_GET_REPLICA_COUNT + 1
+	OPCODE_SWAP_SHADOW_INDEX                        = OPCODE_CHECK_TOKEN_EXIST + 1
+	OPCODE_RENAME_INDEX                             = OPCODE_SWAP_SHADOW_INDEX + 1
 )
 
 /////////////////////////////////////////////////////////////////////////
@@ -147,6 +149,25 @@ type CheckToken struct {
 	Flag   uint32        `json:"flag,omitempty"`
 }
 
+/////////////////////////////////////////////////////////////////////////
+// Alter Index
+////////////////////////////////////////////////////////////////////////
+
+type SwapShadowIndex struct {
+	DefnId       c.IndexDefnId `json:"defnId,omitempty"`
+	ShadowDefnId c.IndexDefnId `json:"shadowDefnId,omitempty"`
+	Name         string        `json:"name,omitempty"`
+}
+
+/////////////////////////////////////////////////////////////////////////
+// Rename Index
+////////////////////////////////////////////////////////////////////////
+
+type RenameIndex struct {
+	DefnId c.IndexDefnId `json:"defnId,omitempty"`
+	Name   string        `json:"name,omitempty"`
+}
+
 /////////////////////////////////////////////////////////////////////////
 // marshalling/unmarshalling
 ////////////////////////////////////////////////////////////////////////
@@ -363,3 +384,43 @@ func MarshallCheckToken(checkToken *CheckToken) ([]byte, error) {
 
 	return buf, nil
 }
+
+func UnmarshallSwapShadowIndex(data []byte) (*SwapShadowIndex, error) {
+
+	swap := new(SwapShadowIndex)
+	if err := json.Unmarshal(data, swap); err != nil {
+		return nil, err
+	}
+
+	return swap, nil
+}
+
+func MarshallSwapShadowIndex(swap *SwapShadowIndex) ([]byte, error) {
+
+	buf, err := json.Marshal(&swap)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func UnmarshallRenameIndex(data []byte) (*RenameIndex, error) {
+
+	rename := new(RenameIndex)
+	if err := json.Unmarshal(data, rename); err != nil {
+		return nil, err
+	}
+
+	return rename, nil
+}
+
+func MarshallRenameIndex(rename *RenameIndex) ([]byte, error) {
+
+	buf, err := json.Marshal(&rename)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
@@ -0,0 +1,481 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/cbauth"
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+	"net/http"
+	"net/url"
+	"sync/atomic"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+//
+// MetadataDelta is sent when index definitions have been changed by the
+// metadata feed.  The metadata version has moved from FromVersion to
+// ToVersion by this change alone, so the receiver can apply the change on
+// top of its metadata of FromVersion.  Otherwise, the receiver should refresh
+// all its metadata.
+//
+type MetadataDelta struct {
+	DefnIds     []c.IndexDefnId
+	FromVersion uint64
+	ToVersion   uint64
+}
+
+//
+// Message sent by the metadata feed of the indexer (see manager.MetadataFeedMessage).
+//
+type metadataFeedMessage struct {
+	Epoch       string             `json:"epoch"`
+	Seqno       uint64             `json:"seqno"`
+	Sync        bool               `json:"sync,omitempty"`
+	Definitions []c.IndexDefn      `json:"definitions,omitempty"`
+	Topologies  []mc.IndexTopology `json:"topologies,omitempty"`
+	Change      *metadataChange    `json:"change,omitempty"`
+}
+
+type metadataChange struct {
+	Seqno      uint64                    `json:"seqno"`
+	Type       string                    `json:"type"`
+	Bucket     string                    `json:"bucket,omitempty"`
+	DefnId     c.IndexDefnId             `json:"defnId"`
+	InstId     c.IndexInstId             `json:"instId"`
+	Definition *c.IndexDefn              `json:"definition,omitempty"`
+	Instance   *mc.IndexInstDistribution `json:"instance,omitempty"`
+}
+
+//
+// feedSubscriber keeps a long-lived subscription to the metadata feed
+// of an indexer.  Changes must arrive in sequence.  If there is a gap in
+// sequence number, or the indexer cannot serve the changes since the last
+// seen sequence number, the subscriber falls back to a full sync of the
+// index metadata of the indexer.
+//
+type feedSubscriber struct {
+	watcher *watcher
+	epoch   string
+	seqno   uint64
+	killch  chan bool
+}
+
+var errFeedUnsupported = errors.New("Indexer does not support metadata feed")
+var errFeedGap = errors.New("Metadata feed is out of sequence")
+
+// Retry interval for a broken subscription
+var FEED_RETRY_INTERVAL = time.Duration(1000) * time.Millisecond
+
+// Retry interval when indexer does not support metadata feed (e.g. during upgrade)
+var FEED_UNSUPPORTED_RETRY_INTERVAL = time.Duration(5) * time.Minute
+
+// Subscription is considered broken if there is no message (including heartbeat)
+var FEED_TIMEOUT = time.Duration(30000) * time.Millisecond
+
+///////////////////////////////////////////////////////
+// Public function : MetadataProvider
+///////////////////////////////////////////////////////
+
+//
+// Subscribe to the metadata feed of each indexer.  Changes pushed by the
+// indexers are applied incrementally to the metadata, and each change is
+// notified through deltaCh.  On full sync, the caller is notified through the
+// regular metadata change channel.  While the subscription to an indexer is in
+// sync, topology changes of the indexer are only applied from the feed.
+//
+func (o *MetadataProvider) EnableMetadataFeed(deltaCh chan *MetadataDelta) {
+
+	o.mutex.Lock()
+	defer o.mutex.Unlock()
+
+	if o.deltaNotifyCh != nil {
+		return
+	}
+
+	o.deltaNotifyCh = deltaCh
+	for _, watcher := range o.watchers {
+		watcher.startFeed()
+	}
+}
+
+//
+// Find indexes by definition id, along with the metadata version.  Similar
+// to ListIndex(), an index is not returned if it does not have a valid instance.
+//
+func (o *MetadataProvider) FindIndexes(ids []c.IndexDefnId) (map[c.IndexDefnId]*IndexMetadata, uint64) {
+
+	indices, version := o.repo.findDefnWithValidInst(ids)
+	result := make(map[c.IndexDefnId]*IndexMetadata)
+
+	replaced := o.repo.listReplacedDefn()
+
+	for id, meta := range indices {
+		// shadow index of alter index is not visible until it is swapped in
+		if isHiddenIndex(meta, replaced) {
+			continue
+		}
+
+		if o.isValidIndexFromActiveIndexer(meta) {
+			result[id] = meta
+		}
+	}
+
+	return result, version
+}
+
+func (o *MetadataProvider) notifyDelta(delta *MetadataDelta) {
+
+	if o.deltaNotifyCh != nil {
+		select {
+		case o.deltaNotifyCh <- delta:
+		default:
+			// receiver is falling behind.  Ask for full refresh.
+			o.needRefresh()
+		}
+	}
+}
+
+///////////////////////////////////////////////////////
+// private function : watcher
+///////////////////////////////////////////////////////
+
+func (w *watcher) startFeed() {
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	if w.feed != nil || w.isClosed {
+		return
+	}
+
+	w.feed = &feedSubscriber{
+		watcher: w,
+		killch:  make(chan bool),
+	}
+	go w.feed.run()
+}
+
+func (w *watcher) closeFeed() {
+
+	w.mutex.Lock()
+	feed := w.feed
+	w.feed = nil
+	w.mutex.Unlock()
+
+	if feed != nil {
+		close(feed.killch)
+	}
+	atomic.StoreInt32(&w.feedSynced, 0)
+}
+
+func (w *watcher) isFeedSynced() bool {
+	return atomic.LoadInt32(&w.feedSynced) == 1
+}
+
+func (w *watcher) applyFeedSync(msg *metadataFeedMessage) {
+
+	indexerId := w.getIndexerId()
+
+	logging.Infof("watcher.applyFeedSync(): full sync of metadata from indexer %v at seqno %v", indexerId, msg.Seqno)
+
+	w.provider.repo.syncTopology(indexerId, msg.Definitions, msg.Topologies)
+	w.provider.repo.notifyEvent()
+	w.provider.needRefresh()
+}
+
+func (w *watcher) applyFeedChange(change *metadataChange) {
+
+	indexerId := w.getIndexerId()
+
+	logging.Debugf("watcher.applyFeedChange(): indexer %v seqno %v %v index defn %v inst %v",
+		indexerId, change.Seqno, change.Type, change.DefnId, change.InstId)
+
+	defnId, from, to := w.provider.repo.applyChange(indexerId, change)
+	w.provider.repo.notifyEvent()
+
+	if from != to {
+		w.provider.notifyDelta(&MetadataDelta{
+			DefnIds:     []c.IndexDefnId{defnId},
+			FromVersion: from,
+			ToVersion:   to,
+		})
+	}
+}
+
+///////////////////////////////////////////////////////
+// private function : feedSubscriber
+///////////////////////////////////////////////////////
+
+func (s *feedSubscriber) run() {
+
+	for {
+		err := s.subscribe()
+		atomic.StoreInt32(&s.watcher.feedSynced, 0)
+
+		retry := FEED_RETRY_INTERVAL
+		if err == errFeedUnsupported {
+			retry = FEED_UNSUPPORTED_RETRY_INTERVAL
+		}
+
+		select {
+		case <-s.killch:
+			return
+		default:
+		}
+
+		logging.Warnf("feedSubscriber: subscription to metadata feed of indexer %v terminated.  Retry in %v.  Error = %v",
+			s.watcher.getAdminAddr(), retry, err)
+
+		select {
+		case <-s.killch:
+			return
+		case <-time.After(retry):
+		}
+	}
+}
+
+func (s *feedSubscriber) subscribe() error {
+
+	addr := fmt.Sprintf("http://%v/metadataChanges?epoch=%v&since=%v",
+		s.watcher.getHttpAddr(), url.QueryEscape(s.epoch), s.seqno)
+
+	req, err := http.NewRequest("GET", addr, nil)
+	if err != nil {
+		return err
+	}
+	if err := cbauth.SetRequestAuthVia(req, nil); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req = req.WithContext(ctx)
+
+	// cancel the subscription if it is closed or there is no heartbeat from indexer
+	timer := time.AfterFunc(FEED_TIMEOUT, cancel)
+	defer timer.Stop()
+
+	go func() {
+		select {
+		case <-s.killch:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errFeedUnsupported
+	} else if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Unexpected response status %v", resp.Status)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		msg := new(metadataFeedMessage)
+		if err := decoder.Decode(msg); err != nil {
+			return err
+		}
+		timer.Reset(FEED_TIMEOUT)
+
+		if err := s.process(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *feedSubscriber) process(msg *metadataFeedMessage) error {
+
+	if msg.Sync {
+		s.watcher.applyFeedSync(msg)
+		s.epoch = msg.Epoch
+		s.seqno = msg.Seqno
+		atomic.StoreInt32(&s.watcher.feedSynced, 1)
+		return nil
+	}
+
+	// Indexer sends changes in sequence.  Changes that cannot be seen by
+	// this subscriber are sent without content.  Heartbeat carries the last
+	// sequence number.  Anything else means that changes have been missed.
+	inSeq := msg.Epoch == s.epoch &&
+		(msg.Seqno == s.seqno+1 || (msg.Change == nil && msg.Seqno == s.seqno))
+
+	if !inSeq {
+		logging.Warnf("feedSubscriber: indexer %v sent seqno %v epoch %v while expecting seqno %v epoch %v.  Full sync is required.",
+			s.watcher.getAdminAddr(), msg.Seqno, msg.Epoch, s.seqno+1, s.epoch)
+
+		// empty epoch forces the indexer to send a full sync
+		s.epoch = ""
+		s.seqno = 0
+		return errFeedGap
+	}
+
+	if msg.Change != nil {
+		s.watcher.applyFeedChange(msg.Change)
+	}
+	s.seqno = msg.Seqno
+
+	return nil
+}
+
+///////////////////////////////////////////////////////
+// private function : metadataRepo
+///////////////////////////////////////////////////////
+
+//
+// Replace all the index instances of the indexer with a full sync from the
+// metadata feed.
+//
+func (r *metadataRepo) syncTopology(indexerId c.IndexerId, defns []c.IndexDefn, topologies []mc.IndexTopology) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for i, _ := range defns {
+		r.addDefnNoLock(&defns[i])
+	}
+
+	r.removeInstForIndexerNoLock(indexerId, "")
+
+	for i, _ := range topologies {
+		r.updateTopologyNoLock(&topologies[i], indexerId)
+	}
+
+	r.cleanupOrphanDefnNoLock(indexerId, "")
+
+	for defnId, _ := range r.indices {
+		r.updateIndexMetadataNoLock(defnId)
+	}
+
+	r.incrementVersion()
+}
+
+//
+// Apply a change of an index instance from the metadata feed.  It returns
+// the definition id of the instance, and the metadata version before and
+// after the change.
+//
+func (r *metadataRepo) applyChange(indexerId c.IndexerId, change *metadataChange) (c.IndexDefnId, uint64, uint64) {
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	from := r.getVersion()
+
+	defnId := change.DefnId
+	if defnId == c.IndexDefnId(0) {
+		for id, instsByInstId := range r.instances {
+			if _, ok := instsByInstId[change.InstId]; ok {
+				defnId = id
+				break
+			}
+		}
+	}
+
+	if defnId == c.IndexDefnId(0) {
+		return defnId, from, from
+	}
+
+	if change.Definition != nil {
+		r.addDefnNoLock(change.Definition)
+	}
+
+	r.removeInstNoLock(indexerId, defnId, change.InstId)
+
+	if change.Instance != nil {
+		if _, ok := r.topology[indexerId]; !ok {
+			r.topology[indexerId] = make(map[c.IndexDefnId]bool)
+		}
+		r.topology[indexerId][defnId] = true
+
+		r.addInstNoLock(defnId, *change.Instance)
+	}
+
+	r.updateIndexMetadataNoLock(defnId)
+	r.cleanupOrphanDefnNoLock(indexerId, change.Bucket)
+
+	r.incrementVersion()
+
+	return defnId, from, r.getVersion()
+}
+
+//
+// Remove the partitions of an index instance residing on the indexer.
+//
+func (r *metadataRepo) removeInstNoLock(indexerId c.IndexerId, defnId c.IndexDefnId, instId c.IndexInstId) {
+
+	instsByInstId, ok := r.instances[defnId]
+	if !ok {
+		return
+	}
+
+	instsByPartitionId, ok := instsByInstId[instId]
+	if !ok {
+		return
+	}
+
+	for partnId, instsByVersion := range instsByPartitionId {
+		for version, instByVersion := range instsByVersion {
+			if instByVersion.FindIndexerId() == string(indexerId) {
+				delete(instsByVersion, version)
+			}
+		}
+
+		if len(instsByVersion) == 0 {
+			delete(instsByPartitionId, partnId)
+		}
+	}
+
+	if len(instsByPartitionId) == 0 {
+		delete(instsByInstId, instId)
+	}
+
+	if len(instsByInstId) == 0 {
+		delete(r.instances, defnId)
+	}
+}
+
+func (r *metadataRepo) findDefnWithValidInst(ids []c.IndexDefnId) (map[c.IndexDefnId]*IndexMetadata, uint64) {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	result := make(map[c.IndexDefnId]*IndexMetadata)
+	for _, id := range ids {
+		if meta, ok := r.indices[id]; ok && isValidIndex(meta) {
+			result[id] = copyValidIndexMetadata(meta)
+		}
+	}
+
+	return result, r.getVersion()
+}
+
+func (r *metadataRepo) listReplacedDefn() map[c.IndexDefnId]bool {
+
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return replacedIndexes(r.indices)
+}
@@ -0,0 +1,231 @@
+package client
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+//
+// Return an active, non-partitioned index instance residing on the indexer.
+//
+func newTestInstDistribution(indexerId c.IndexerId, instId c.IndexInstId) mc.IndexInstDistribution {
+
+	return mc.IndexInstDistribution{
+		InstId: uint64(instId),
+		State:  uint32(c.INDEX_STATE_ACTIVE),
+		RState: uint32(c.REBAL_ACTIVE),
+		Partitions: []mc.IndexPartDistribution{{
+			PartId: uint64(c.NON_PARTITION_ID),
+			SinglePartition: mc.IndexSinglePartDistribution{
+				Slices: []mc.IndexSliceLocator{{IndexerId: string(indexerId)}},
+			},
+		}},
+	}
+}
+
+func newTestFeedSync(indexerId c.IndexerId, epoch string, seqno uint64,
+	defns []c.IndexDefn, instIds []c.IndexInstId) *metadataFeedMessage {
+
+	msg := &metadataFeedMessage{Epoch: epoch, Seqno: seqno, Sync: true, Definitions: defns}
+
+	topologies := make(map[string]*mc.IndexTopology)
+	for i, defn := range defns {
+		topology, ok := topologies[defn.Bucket]
+		if !ok {
+			topology = &mc.IndexTopology{Bucket: defn.Bucket}
+			topologies[defn.Bucket] = topology
+		}
+		topology.Definitions = append(topology.Definitions, mc.IndexDefnDistribution{
+			Bucket:    defn.Bucket,
+			Name:      defn.Name,
+			DefnId:    uint64(defn.DefnId),
+			Instances: []mc.IndexInstDistribution{newTestInstDistribution(indexerId, instIds[i])},
+		})
+	}
+
+	for _, topology := range topologies {
+		msg.Topologies = append(msg.Topologies, *topology)
+	}
+
+	return msg
+}
+
+func newTestFeedChange(epoch string, seqno uint64, defn *c.IndexDefn, defnId c.IndexDefnId,
+	instId c.IndexInstId, inst *mc.IndexInstDistribution) *metadataFeedMessage {
+
+	return &metadataFeedMessage{
+		Epoch: epoch,
+		Seqno: seqno,
+		Change: &metadataChange{
+			Seqno:      seqno,
+			Bucket:     "b1",
+			DefnId:     defnId,
+			InstId:     instId,
+			Definition: defn,
+			Instance:   inst,
+		},
+	}
+}
+
+func TestFeedSubscriberProcess(t *testing.T) {
+
+	indexerId := c.IndexerId("idxr1")
+	o := newTestMetadataProvider(indexerId)
+	deltaCh := make(chan *MetadataDelta, 10)
+	o.deltaNotifyCh = deltaCh
+
+	// index known before the feed is subscribed, but dropped since
+	addTestIndex(o, indexerId, &c.IndexDefn{DefnId: 3, Bucket: "b1", Name: "idx3"}, 13)
+
+	defn1 := c.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}
+	defn2 := c.IndexDefn{DefnId: 2, Bucket: "b1", Name: "idx2"}
+	inst12 := newTestInstDistribution(indexerId, 12)
+
+	s := &feedSubscriber{watcher: o.watchers[indexerId]}
+
+	testcases := []struct {
+		comment string
+		msg     *metadataFeedMessage
+		err     error
+		epoch   string
+		seqno   uint64
+		visible []c.IndexDefnId
+		delta   []c.IndexDefnId
+	}{
+		{"change before sync", newTestFeedChange("e1", 1, &defn2, 2, 12, &inst12),
+			errFeedGap, "", 0, []c.IndexDefnId{3}, nil},
+		{"sync", newTestFeedSync(indexerId, "e1", 5, []c.IndexDefn{defn1}, []c.IndexInstId{11}),
+			nil, "e1", 5, []c.IndexDefnId{1}, nil},
+		{"created", newTestFeedChange("e1", 6, &defn2, 2, 12, &inst12),
+			nil, "e1", 6, []c.IndexDefnId{1, 2}, []c.IndexDefnId{2}},
+		{"heartbeat", &metadataFeedMessage{Epoch: "e1", Seqno: 6},
+			nil, "e1", 6, []c.IndexDefnId{1, 2}, nil},
+		{"change not visible", &metadataFeedMessage{Epoch: "e1", Seqno: 7},
+			nil, "e1", 7, []c.IndexDefnId{1, 2}, nil},
+		{"dropped", newTestFeedChange("e1", 8, nil, 1, 11, nil),
+			nil, "e1", 8, []c.IndexDefnId{2}, []c.IndexDefnId{1}},
+		{"dropped without defn id", newTestFeedChange("e1", 9, nil, 0, 12, nil),
+			nil, "e1", 9, nil, []c.IndexDefnId{2}},
+		{"unknown instance", newTestFeedChange("e1", 10, nil, 0, 99, nil),
+			nil, "e1", 10, nil, nil},
+		{"gap", newTestFeedChange("e1", 12, &defn2, 2, 12, &inst12),
+			errFeedGap, "", 0, nil, nil},
+		{"sync new epoch", newTestFeedSync(indexerId, "e2", 3, []c.IndexDefn{defn1, defn2}, []c.IndexInstId{11, 12}),
+			nil, "e2", 3, []c.IndexDefnId{1, 2}, nil},
+		{"old epoch", newTestFeedChange("e1", 4, nil, 1, 11, nil),
+			errFeedGap, "", 0, []c.IndexDefnId{1, 2}, nil},
+	}
+
+	for _, tc := range testcases {
+		err := s.process(tc.msg)
+		if err != tc.err {
+			t.Errorf("%v: expected error %v, got %v", tc.comment, tc.err, err)
+		}
+
+		if s.epoch != tc.epoch || s.seqno != tc.seqno {
+			t.Errorf("%v: expected epoch %v seqno %v, got %v %v", tc.comment, tc.epoch, tc.seqno, s.epoch, s.seqno)
+		}
+
+		if tc.msg.Sync && !s.watcher.isFeedSynced() {
+			t.Errorf("%v: expected feed to be synced", tc.comment)
+		}
+
+		indexes, _ := o.FindIndexes([]c.IndexDefnId{1, 2, 3})
+		var visible []c.IndexDefnId
+		for id, _ := range indexes {
+			visible = append(visible, id)
+		}
+		sort.Slice(visible, func(i, j int) bool { return visible[i] < visible[j] })
+		if !reflect.DeepEqual(visible, tc.visible) {
+			t.Errorf("%v: expected indexes %v, got %v", tc.comment, tc.visible, visible)
+		}
+
+		var delta *MetadataDelta
+		select {
+		case delta = <-deltaCh:
+		default:
+		}
+
+		if tc.delta == nil {
+			if delta != nil {
+				t.Errorf("%v: unexpected delta %v", tc.comment, delta)
+			}
+		} else if delta == nil || !reflect.DeepEqual(delta.DefnIds, tc.delta) ||
+			delta.ToVersion != delta.FromVersion+1 {
+			t.Errorf("%v: expected delta for %v, got %v", tc.comment, tc.delta, delta)
+		}
+	}
+}
+
+func TestApplyChange(t *testing.T) {
+
+	indexerId := c.IndexerId("idxr1")
+	o := newTestMetadataProvider(indexerId)
+	addTestIndex(o, indexerId, &c.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}, 11)
+
+	// a change replaces the instance residing on the indexer
+	inst := newTestInstDistribution(indexerId, 11)
+	inst.State = uint32(c.INDEX_STATE_INITIAL)
+
+	version := o.repo.getVersion()
+	defnId, from, to := o.repo.applyChange(indexerId, &metadataChange{DefnId: 1, InstId: 11, Instance: &inst})
+	if defnId != 1 || from != version || to != version+1 {
+		t.Errorf("state change: expected defn 1 version %v -> %v, got %v %v -> %v", version, version+1, defnId, from, to)
+	}
+
+	meta := o.repo.indices[1]
+	if meta == nil || len(meta.Instances) != 1 || meta.Instances[0].State != c.INDEX_STATE_INITIAL {
+		t.Errorf("state change: expected a single initial instance, got %+v", meta)
+	}
+
+	// a change for an unknown instance is ignored
+	version = o.repo.getVersion()
+	defnId, from, to = o.repo.applyChange(indexerId, &metadataChange{InstId: 99})
+	if defnId != 0 || from != version || to != version {
+		t.Errorf("unknown instance: expected no change, got %v %v -> %v", defnId, from, to)
+	}
+
+	// definition is removed along with its last instance
+	o.repo.applyChange(indexerId, &metadataChange{Bucket: "b1", InstId: 11})
+	if _, ok := o.repo.indices[1]; ok {
+		t.Errorf("dropped: expected index 1 to be removed")
+	}
+	if _, ok := o.repo.instances[1]; ok {
+		t.Errorf("dropped: expected instances of index 1 to be removed")
+	}
+}
+
+func TestSyncTopology(t *testing.T) {
+
+	o := newTestMetadataProvider("idxr1")
+	o.watchers["idxr2"] = &watcher{provider: o, serviceMap: &ServiceMap{IndexerId: "idxr2"}}
+
+	addTestIndex(o, "idxr1", &c.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}, 11)
+	addTestIndex(o, "idxr2", &c.IndexDefn{DefnId: 2, Bucket: "b1", Name: "idx2"}, 21)
+
+	// full sync replaces the instances of the indexer only
+	defn3 := c.IndexDefn{DefnId: 3, Bucket: "b2", Name: "idx3"}
+	msg := newTestFeedSync("idxr1", "e1", 1, []c.IndexDefn{defn3}, []c.IndexInstId{31})
+
+	version := o.repo.getVersion()
+	o.repo.syncTopology("idxr1", msg.Definitions, msg.Topologies)
+
+	if o.repo.getVersion() <= version {
+		t.Errorf("expected version to be incremented from %v", version)
+	}
+
+	indexes, _ := o.FindIndexes([]c.IndexDefnId{1, 2, 3})
+	if _, ok := indexes[1]; ok {
+		t.Errorf("expected index 1 to be removed")
+	}
+	if _, ok := indexes[2]; !ok {
+		t.Errorf("expected index 2 of another indexer to be retained")
+	}
+	if meta, ok := indexes[3]; !ok || meta.Definition.Name != "idx3" {
+		t.Errorf("expected index 3 to be added, got %v", meta)
+	}
+}
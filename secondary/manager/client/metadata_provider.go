@@ -46,6 +46,7 @@ type Settings interface {
 	NumReplica() int32
 	NumPartition() int32
 	StorageMode() string
+	AlterIndexTimeout() time.Duration
 }
 
 ///////////////////////////////////////////////////////
@@ -71,6 +72,9 @@ type MetadataProvider struct {
 	indexerVersion     uint64
 	clusterVersion     uint64
 	statsNotifyCh      chan map[c.IndexInstId]map[c.PartitionId]c.Statistics
+	ddlContext         mc.DDLHistoryContext
+	deltaNotifyCh      chan *MetadataDelta
+	aliases            *mc.IndexAliasListener
 }
 
 //
@@ -129,6 +133,10 @@ type watcher struct {
 	incomingReqs chan *protocol.RequestHandle
 	pendingReqs  map[uint64]*protocol.RequestHandle // key : request id
 	loggedReqs   map[common.Txnid]*protocol.RequestHandle
+
+	// metadata feed subscription
+	feed       *feedSubscriber
+	feedSynced int32
 }
 
 // With partitioning, index instance is distributed among indexer nodes.
@@ -173,7 +181,8 @@ type watcherCallback func(string, c.IndexerId, c.IndexerId)
 var REQUEST_CHANNEL_COUNT = 1000
 
 var VALID_PARAM_NAMES = []string{"nodes", "defer_build", "retain_deleted_xattr",
-	"num_partition", "num_replica", "docKeySize", "secKeySize", "arrSize", "numDoc", "residentRatio"}
+	"num_partition", "num_replica", "docKeySize", "secKeySize", "arrSize", "numDoc", "residentRatio",
+	"node_labels", "anti_affinity", "colocate_partitions"}
 
 ///////////////////////////////////////////////////////
 // Public function : MetadataProvider
@@ -193,6 +202,7 @@ func NewMetadataProvider(cluster string, providerId string, changeCh chan bool,
 	s.settings = settings
 
 	s.providerId = providerId
+	s.ddlContext = mc.DDLHistoryContext{Source: mc.DDLHistorySourceQuery, Node: providerId}
 	if err != nil {
 		return nil, err
 	}
@@ -204,6 +214,9 @@ func NewMetadataProvider(cluster string, providerId string, changeCh chan bool,
 	}
 	s.clusterVersion = cinfo.GetClusterVersion()
 
+	s.aliases = mc.NewIndexAliasListener()
+	s.aliases.ListenTokens()
+
 	return s, nil
 }
 
@@ -211,6 +224,35 @@ func (o *MetadataProvider) SetTimeout(timeout int64) {
 	o.timeout = timeout
 }
 
+//
+// Record a DDL in DDL history.  The user is taken from the credential of
+// the request that issues the DDL.  The context of the request overrides
+// the default context of this MetadataProvider.  req can be nil, if the
+// DDL is not issued on behalf of a request (e.g. alter replica count).
+//
+func (o *MetadataProvider) recordDDLHistory(req *mc.DDLRequest, op string, before *c.IndexDefn, after *c.IndexDefn) {
+
+	var user string
+	context := o.ddlContext
+
+	if req != nil {
+		if req.Creds != nil {
+			user = req.Creds.Name()
+		}
+		if len(req.Context.Source) != 0 {
+			context.Source = req.Context.Source
+		}
+		if len(req.Context.Node) != 0 {
+			context.Node = req.Context.Node
+		}
+		context.RemoteAddr = req.Context.RemoteAddr
+		context.RequestId = req.Context.RequestId
+		context.FromEntry = req.Context.FromEntry
+	}
+
+	mc.RecordDDLHistory(op, user, context, before, after)
+}
+
 func (o *MetadataProvider) SetClusterStatus(numExpectedWatcher int, numFailedNode int, numUnhealthyNode int, numAddNode int) {
 
 	if (numExpectedWatcher > -1 && int32(numExpectedWatcher) != atomic.LoadInt32(&o.numExpectedWatcher)) ||
@@ -365,13 +407,20 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 	name, bucket, using, exprType, whereExpr string,
 	secExprs []string, desc []bool, isPrimary bool,
 	scheme c.PartitionScheme, partitionKeys []string,
-	plan map[string]interface{}) (c.IndexDefnId, error, bool) {
+	plan map[string]interface{}, req *mc.DDLRequest) (c.IndexDefnId, error, bool) {
 
 	// FindIndexByName will only return valid index
 	if o.findIndexByName(name, bucket) != nil {
 		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Index %s already exists.", name)), false
 	}
 
+	// The index name cannot be the same as an index alias
+	if alias, err := mc.FetchIndexAliasToken(bucket, name); err != nil {
+		return c.IndexDefnId(0), err, true
+	} else if alias != nil {
+		return c.IndexDefnId(0), errors.New(fmt.Sprintf("Index alias %s already exists.", name)), false
+	}
+
 	// Create index definition
 	idxDefn, err, retry := o.PrepareIndexDefn(name, bucket, using, exprType, whereExpr, secExprs, desc,
 		isPrimary, scheme, partitionKeys, plan)
@@ -390,6 +439,8 @@ func (o *MetadataProvider) CreateIndexWithPlan(
 		}
 	}
 
+	o.recordDDLHistory(req, mc.DDLHistoryOpCreate, nil, idxDefn)
+
 	return idxDefn.DefnId, nil, false
 }
 
@@ -1010,6 +1061,9 @@ func (o *MetadataProvider) PrepareIndexDefn(
 	var docKeySize uint64 = 0
 	var arrSize uint64 = 0
 	var residentRatio float64 = 0
+	var nodeLabels []string = nil
+	var antiAffinity []string = nil
+	var colocatePartitions = false
 
 	version := o.GetIndexerVersion()
 	clusterVersion := o.GetClusterVersion()
@@ -1139,6 +1193,21 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		if err != nil {
 			return nil, err, retry
 		}
+
+		nodeLabels, err, retry = o.getStringListParam(plan, "node_labels")
+		if err != nil {
+			return nil, err, retry
+		}
+
+		antiAffinity, err, retry = o.getStringListParam(plan, "anti_affinity")
+		if err != nil {
+			return nil, err, retry
+		}
+
+		colocatePartitions, err, retry = o.getColocatePartitionsParam(partitionScheme, plan)
+		if err != nil {
+			return nil, err, retry
+		}
 	}
 
 	logging.Debugf("MetadataProvider:CreateIndex(): deferred_build %v nodes %v", deferred, nodes)
@@ -1213,6 +1282,9 @@ func (o *MetadataProvider) PrepareIndexDefn(
 		DocKeySize:         docKeySize,
 		ArrSize:            arrSize,
 		ResidentRatio:      residentRatio,
+		NodeLabels:         nodeLabels,
+		AntiAffinity:       antiAffinity,
+		ColocatePartitions: colocatePartitions,
 	}
 
 	idxDefn.NumReplica2.Initialize(idxDefn.NumReplica)
@@ -1240,6 +1312,9 @@ func (o *MetadataProvider) prepareIndexSpec(defn *c.IndexDefn) *planner.IndexSpe
 	spec.Replica = uint64(defn.NumReplica) + 1
 	spec.RetainDeletedXATTR = defn.RetainDeletedXATTR
 	spec.ExprType = string(defn.ExprType)
+	spec.NodeLabels = defn.NodeLabels
+	spec.AntiAffinity = defn.AntiAffinity
+	spec.ColocatePartitions = defn.ColocatePartitions
 
 	spec.NumDoc = defn.NumDoc
 	spec.DocKeySize = defn.DocKeySize
@@ -1624,6 +1699,60 @@ func (o *MetadataProvider) getDeferredParam(plan map[string]interface{}) (bool,
 	return deferred, nil, false
 }
 
+func (o *MetadataProvider) getStringListParam(plan map[string]interface{}, name string) ([]string, error, bool) {
+
+	var result []string = nil
+
+	list, ok := plan[name].([]interface{})
+	if ok {
+		for _, elem := range list {
+			str, ok := elem.(string)
+			if !ok || len(str) == 0 {
+				return nil, errors.New(fmt.Sprintf("Fails to create index.  Parameter %v '%v' is not valid", name, plan[name])), false
+			}
+			result = append(result, str)
+		}
+	} else {
+		str, ok := plan[name].(string)
+		if ok && len(str) != 0 {
+			result = []string{str}
+		} else if _, ok := plan[name]; ok {
+			return nil, errors.New(fmt.Sprintf("Fails to create index.  Parameter %v '%v' is not valid", name, plan[name])), false
+		}
+	}
+
+	return result, nil, false
+}
+
+func (o *MetadataProvider) getColocatePartitionsParam(partitionScheme c.PartitionScheme, plan map[string]interface{}) (bool, error, bool) {
+
+	colocate := false
+
+	colocate2, ok := plan["colocate_partitions"].(bool)
+	if !ok {
+		colocate_str, ok := plan["colocate_partitions"].(string)
+		if ok {
+			var err error
+			colocate2, err = strconv.ParseBool(colocate_str)
+			if err != nil {
+				return false, errors.New("Fails to create index.  Parameter colocate_partitions must be a boolean value of (true or false)."), false
+			}
+			colocate = colocate2
+
+		} else if _, ok := plan["colocate_partitions"]; ok {
+			return false, errors.New("Fails to create index.  Parameter colocate_partitions must be a boolean value of (true or false)."), false
+		}
+	} else {
+		colocate = colocate2
+	}
+
+	if colocate && !c.IsPartitioned(partitionScheme) {
+		return false, errors.New("Fails to create index.  Parameter colocate_partitions can be used only for partitioned index."), false
+	}
+
+	return colocate, nil, false
+}
+
 func (o *MetadataProvider) validatePartitionKeys(partitionScheme c.PartitionScheme, partitionKeys []string, secKeys []string, isPrimary bool) error {
 
 	if partitionScheme != c.SINGLE && partitionScheme != c.KEY {
@@ -2087,7 +2216,35 @@ RETRY1:
 	return watchers, nil, false
 }
 
-func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId) error {
+func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId, req *mc.DDLRequest) error {
+
+	var before *c.IndexDefn
+	if meta := o.findIndex(defnID); meta != nil {
+		defn := *meta.Definition
+		if c.IsPartitioned(defn.PartitionScheme) {
+			defn.NumPartitions = uint32(meta.numPartitions())
+		}
+		before = &defn
+	}
+
+	if err := o.dropIndex(defnID); err != nil {
+		return err
+	}
+
+	// Index alias pointing to the dropped index is dropped as well.  Any alias
+	// left behind is cleaned up during rebalance.
+	if before != nil {
+		if err := o.dropIndexAliasOfIndex(before.Bucket, defnID); err != nil {
+			logging.Warnf("Fail to drop index alias of index %v: %v", defnID, err)
+		}
+	}
+
+	o.recordDDLHistory(req, mc.DDLHistoryOpDrop, before, nil)
+
+	return nil
+}
+
+func (o *MetadataProvider) dropIndex(defnID c.IndexDefnId) error {
 
 	// place token for recovery.  Even if the index does not exist, the delete token will
 	// be cleaned up during rebalance.  By placing the delete token, it will make sure that the
@@ -2103,6 +2260,14 @@ func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId) error {
 		return errors.New("Index does not exist.")
 	}
 
+	// abort alter index if the index is being altered.  If the shadow index
+	// has already been swapped in, it is no longer dropped with the index.
+	if shadow := o.findShadowIndex(defnID); shadow != nil {
+		if err := o.abortAlterIndex(defnID, shadow.Definition.DefnId); err != nil && err != mc.ErrAlterIndexSwapped {
+			logging.Warnf("Fail to drop shadow index %v of index %v: %v", shadow.Definition.DefnId, defnID, err)
+		}
+	}
+
 	// find watcher -- This method does not check index status (return the watcher even
 	// if index is in created status). This return an error if  watcher (holding the index)
 	// is dropped asynchronously (concurrent unwatchMetadata).
@@ -2139,7 +2304,7 @@ func (o *MetadataProvider) DropIndex(defnID c.IndexDefnId) error {
 	return nil
 }
 
-func (o *MetadataProvider) BuildIndexes(defnIDs []c.IndexDefnId) error {
+func (o *MetadataProvider) BuildIndexes(defnIDs []c.IndexDefnId, req *mc.DDLRequest) error {
 
 	watcherIndexMap := make(map[c.IndexerId][]c.IndexDefnId)
 	watcherNodeMap := make(map[c.IndexerId]string)
@@ -2246,6 +2411,12 @@ func (o *MetadataProvider) BuildIndexes(defnIDs []c.IndexDefnId) error {
 		return errors.New(errStr)
 	}
 
+	for _, id := range defnList {
+		if meta := o.findIndex(id); meta != nil {
+			o.recordDDLHistory(req, mc.DDLHistoryOpBuild, nil, meta.Definition)
+		}
+	}
+
 	return nil
 }
 
@@ -2365,8 +2536,14 @@ func (o *MetadataProvider) ListIndex() ([]*IndexMetadata, uint64) {
 
 	indices, version := o.repo.listDefnWithValidInst()
 	result := make([]*IndexMetadata, 0, len(indices))
+	replaced := replacedIndexes(indices)
 
 	for _, meta := range indices {
+		// shadow index of alter index is not visible until it is swapped in
+		if isHiddenIndex(meta, replaced) {
+			continue
+		}
+
 		if o.isValidIndexFromActiveIndexer(meta) {
 			result = append(result, meta)
 		}
@@ -2375,6 +2552,31 @@ func (o *MetadataProvider) ListIndex() ([]*IndexMetadata, uint64) {
 	return result, version
 }
 
+//
+// Find the indexes that have been replaced by an altered index.  An index is
+// replaced once the shadow index is swapped in on any indexer.
+//
+func replacedIndexes(indices map[c.IndexDefnId]*IndexMetadata) map[c.IndexDefnId]bool {
+
+	replaced := make(map[c.IndexDefnId]bool)
+	for _, meta := range indices {
+		if !meta.Definition.IsShadow() && meta.Definition.Replaces != c.IndexDefnId(0) {
+			replaced[meta.Definition.Replaces] = true
+		}
+	}
+
+	return replaced
+}
+
+//
+// During alter index, either the old index or the shadow index is visible, but
+// not both.  The shadow index is hidden until it is swapped in, and the old index
+// is hidden once it is replaced.
+//
+func isHiddenIndex(meta *IndexMetadata, replaced map[c.IndexDefnId]bool) bool {
+	return meta.Definition.IsShadow() || replaced[meta.Definition.DefnId]
+}
+
 //
 // Find an index with at least one valid instance.  Note that the instance may not be well-formed.
 //
@@ -2415,8 +2617,9 @@ func (o *MetadataProvider) findIndexByName(name string, bucket string) *IndexMet
 	defer o.mutex.Unlock()
 
 	indices, _ := o.repo.listDefnWithValidInstNoLock()
+	replaced := replacedIndexes(indices)
 	for _, meta := range indices {
-		if meta.Definition.Name == name && meta.Definition.Bucket == bucket {
+		if meta.Definition.Name == name && meta.Definition.Bucket == bucket && !replaced[meta.Definition.DefnId] {
 			// will not hold lock on metadataRepo
 			if o.isValidIndexFromActiveIndexerNoLock(meta) {
 				return meta
@@ -2590,11 +2793,22 @@ func (o *MetadataProvider) AlterReplicaCount(action string, defnId c.IndexDefnId
 
 	logging.Infof("alter replica count.  Current num replica %v new replica count %v", curCount, count)
 
+	recordHistory := func(count int) {
+		before := defn
+		before.NumReplica = uint32(curCount)
+		before.NumReplica2 = c.Counter{HasValue: true, Base: uint32(curCount)}
+		after := defn
+		after.NumReplica = uint32(count)
+		after.NumReplica2 = c.Counter{HasValue: true, Base: uint32(count)}
+		o.recordDDLHistory(nil, mc.DDLHistoryOpAlterReplica, &before, &after)
+	}
+
 	// drop replica
 	if dropReplicaId != -1 {
 		if err := o.removeReplica(&defn, watcherMap, *numReplica, 1, numPartition, dropReplicaId, (map[string]interface{})(nil)); err != nil {
 			return fmt.Errorf("Fail to alter index: %v", err)
 		}
+		recordHistory(int(curCount) - 1)
 		return nil
 	}
 
@@ -2617,9 +2831,478 @@ func (o *MetadataProvider) AlterReplicaCount(action string, defnId c.IndexDefnId
 		}
 	}
 
+	recordHistory(count)
+
+	return nil
+}
+
+//
+// This function alters the definition of an index (keys, where clause, partition scheme
+// or storage mode).  A hidden shadow index is created with the new definition and built
+// alongside the old index.  Once the shadow index has caught up, it is swapped into the
+// name of the old index, and the old index is dropped.  The index remains available for
+// query during the whole operation.  Alter index can be aborted by AbortAlterIndex()
+// any time before the swap.
+//
+func (o *MetadataProvider) AlterIndexDefinition(defnId c.IndexDefnId,
+	using, exprType, whereExpr string, secExprs []string, desc []bool, isPrimary bool,
+	scheme c.PartitionScheme, partitionKeys []string, plan map[string]interface{},
+	req *mc.DDLRequest) error {
+
+	// Support for 6.5 and onwards
+	clusterVersion := o.GetClusterVersion()
+	if clusterVersion < c.INDEXER_65_VERSION {
+		return errors.New("Alter index requires version 6.5 or higher")
+	}
+
+	idxMeta := o.findIndex(defnId)
+	if idxMeta == nil || idxMeta.Definition.IsShadow() {
+		return fmt.Errorf("Index %v does not exist.", defnId)
+	}
+
+	defn := *idxMeta.Definition
+	if shadow := o.findShadowIndex(defnId); shadow != nil ||
+		o.findIndexByName(c.ShadowIndexName(defn.Name), defn.Bucket) != nil {
+		return fmt.Errorf("Alter index is already in progress for index %v.  Abort the alter index before retry.", defn.Name)
+	}
+
+	if plan == nil {
+		plan = make(map[string]interface{})
+	}
+
+	// Keep the replica and partition count of the index unless specified
+	if _, ok := plan["num_replica"]; !ok {
+		if _, ok := plan["nodes"]; !ok {
+			plan["num_replica"] = float64(defn.GetNumReplica())
+		}
+	}
+
+	if _, ok := plan["num_partition"]; !ok && c.IsPartitioned(scheme) && c.IsPartitioned(defn.PartitionScheme) {
+		plan["num_partition"] = float64(idxMeta.numPartitions())
+	}
+	plan["defer_build"] = false
+
+	shadowDefn, err, _ := o.PrepareIndexDefn(c.ShadowIndexName(defn.Name), defn.Bucket, using, exprType, whereExpr,
+		secExprs, desc, isPrimary, scheme, partitionKeys, plan)
+	if err != nil {
+		return fmt.Errorf("Fail to alter index: %v", err)
+	}
+	shadowDefn.ShadowOf = defn.DefnId
+
+	// Post the alter token before creating the shadow index.  If this node crashes
+	// before the swap is committed, the janitor drops the shadow index once the token
+	// expires.  Once committed, the janitor completes the swap on every indexer.
+	var expiry int64
+	if timeout := o.settings.AlterIndexTimeout(); timeout > 0 {
+		expiry = time.Now().Add(timeout).UnixNano()
+	}
+
+	token := &mc.AlterCommandToken{
+		DefnId:       defn.DefnId,
+		ShadowDefnId: shadowDefn.DefnId,
+		Bucket:       defn.Bucket,
+		Name:         defn.Name,
+		Expiry:       expiry,
+	}
+	if err := mc.PostAlterCommandToken(token); err != nil {
+		return err
+	}
+
+	logging.Infof("alter index %v (%v, %v).  Create shadow index %v", defn.DefnId, defn.Bucket, defn.Name, shadowDefn.DefnId)
+
+	if err := o.recoverableCreateIndex(shadowDefn, plan); err != nil {
+		o.abortAlterIndex(defn.DefnId, shadowDefn.DefnId)
+		return fmt.Errorf("Fail to alter index: %v", err)
+	}
+
+	if err := o.waitForShadowIndex(defn.DefnId, shadowDefn.DefnId, expiry); err != nil {
+		o.abortAlterIndex(defn.DefnId, shadowDefn.DefnId)
+		return fmt.Errorf("Fail to alter index: %v", err)
+	}
+
+	// This is the point of no return.  The swap is either committed for every
+	// indexer or aborted.
+	if err := mc.CommitAlterCommandToken(defn.DefnId); err != nil {
+		if err != mc.ErrAlterIndexAborted {
+			o.abortAlterIndex(defn.DefnId, shadowDefn.DefnId)
+		}
+		return fmt.Errorf("Fail to alter index: %v", err)
+	}
+
+	// If any indexer misses the swap request, the janitor will swap the
+	// shadow index and drop the old index on that indexer.
+	if err := o.swapShadowIndex(&defn, shadowDefn.DefnId); err != nil {
+		logging.Warnf("alter index %v (%v, %v).  Fail to swap shadow index %v: %v.  Janitor will retry.",
+			defn.DefnId, defn.Bucket, defn.Name, shadowDefn.DefnId, err)
+	}
+
+	logging.Infof("alter index %v (%v, %v).  Shadow index %v swapped in.  Drop old index.",
+		defn.DefnId, defn.Bucket, defn.Name, shadowDefn.DefnId)
+
+	// Index alias pointing to the old index now points to the new index
+	if err := o.retargetIndexAlias(defn.Bucket, defn.DefnId, shadowDefn.DefnId); err != nil {
+		logging.Warnf("alter index %v (%v, %v).  Fail to retarget index alias: %v", defn.DefnId, defn.Bucket, defn.Name, err)
+	}
+
+	// The shadow index is visible now.  If the old index cannot be dropped, it will be retried
+	// by the drop token.
+	if err := o.dropIndex(defn.DefnId); err != nil {
+		logging.Warnf("alter index %v (%v, %v).  Fail to drop old index: %v", defn.DefnId, defn.Bucket, defn.Name, err)
+	}
+
+	before := defn
+	if c.IsPartitioned(before.PartitionScheme) {
+		before.NumPartitions = uint32(idxMeta.numPartitions())
+	}
+	after := *shadowDefn
+	after.Name = defn.Name
+	after.ShadowOf = c.IndexDefnId(0)
+	o.recordDDLHistory(req, mc.DDLHistoryOpAlter, &before, &after)
+
+	return nil
+}
+
+//
+// This function aborts an in-progress alter index by dropping the shadow index.
+// Alter index cannot be aborted once the swap is committed.
+//
+func (o *MetadataProvider) AbortAlterIndex(defnId c.IndexDefnId) error {
+
+	shadow := o.findShadowIndex(defnId)
+	if shadow == nil {
+		return fmt.Errorf("There is no alter index in progress for index %v.", defnId)
+	}
+
+	logging.Infof("abort alter index %v.  Drop shadow index %v", defnId, shadow.Definition.DefnId)
+
+	if err := o.abortAlterIndex(defnId, shadow.Definition.DefnId); err != nil {
+		if err == mc.ErrAlterIndexSwapped {
+			return fmt.Errorf("Fail to abort alter index: %v", err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+//
+// Abort alter index by removing the alter token before dropping the shadow
+// index.  If the shadow index cannot be dropped, the janitor will drop it since
+// there is no alter token for the shadow index.
+//
+func (o *MetadataProvider) abortAlterIndex(defnId c.IndexDefnId, shadowId c.IndexDefnId) error {
+
+	if err := mc.AbortAlterCommandToken(defnId); err != nil {
+		return err
+	}
+
+	return o.dropIndex(shadowId)
+}
+
+//
+// Find the shadow index of an index being altered.
+//
+func (o *MetadataProvider) findShadowIndex(defnId c.IndexDefnId) *IndexMetadata {
+
+	indices, _ := o.repo.listDefnWithValidInst()
+	for _, meta := range indices {
+		if meta.Definition.ShadowOf == defnId && o.isValidIndexFromActiveIndexer(meta) {
+			return meta
+		}
+	}
+
+	return nil
+}
+
+//
+// Wait for all the instances of the shadow index to become active.  Alter index
+// times out if the shadow index is not active by the expiry (0 means no timeout).
+//
+func (o *MetadataProvider) waitForShadowIndex(defnId c.IndexDefnId, shadowId c.IndexDefnId, expiry int64) error {
+
+	for {
+		shadow := o.findIndex(shadowId)
+		if shadow == nil {
+			return mc.ErrAlterIndexAborted
+		}
+
+		if o.findIndex(defnId) == nil {
+			return errors.New("Index has been dropped while being altered.")
+		}
+
+		if expiry != 0 && time.Now().UnixNano() > expiry {
+			return errors.New("Timeout while waiting for the altered index to be built.")
+		}
+
+		ready := len(shadow.Instances) != 0
+		for _, inst := range shadow.Instances {
+			if inst.State != c.INDEX_STATE_ACTIVE || inst.RState != uint32(c.REBAL_ACTIVE) {
+				ready = false
+				break
+			}
+		}
+
+		if ready {
+			return nil
+		}
+
+		time.Sleep(time.Second)
+	}
+}
+
+//
+// Swap the shadow index into the name of the index being altered on every
+// indexer hosting the shadow index.
+//
+func (o *MetadataProvider) swapShadowIndex(defn *c.IndexDefn, shadowId c.IndexDefnId) error {
+
+	watchers, err := o.findWatchersByDefnIdIgnoreStatus(shadowId)
+	if err != nil {
+		return fmt.Errorf("Cannot locate cluster node hosting shadow index %v.", shadowId)
+	}
+
+	swap := &SwapShadowIndex{DefnId: defn.DefnId, ShadowDefnId: shadowId, Name: defn.Name}
+	content, err := MarshallSwapShadowIndex(swap)
+	if err != nil {
+		return err
+	}
+
+	key := fmt.Sprintf("%d", shadowId)
+	errMap := make(map[string]bool)
+	for _, watcher := range watchers {
+		if _, err := watcher.makeRequest(OPCODE_SWAP_SHADOW_INDEX, key, content); err != nil {
+			errMap[err.Error()] = true
+		}
+	}
+
+	if len(errMap) != 0 {
+		errStr := ""
+		for msg, _ := range errMap {
+			errStr += msg + "\n"
+		}
+		return fmt.Errorf("Fail to swap shadow index on some indexer nodes.  Error=%s.", errStr)
+	}
+
+	return nil
+}
+
+//
+// This function renames an index.  The rename token is posted before the
+// request is sent to the indexers, so that any indexer missing the request
+// will pick up the new name during cleanup.
+//
+func (o *MetadataProvider) RenameIndex(defnId c.IndexDefnId, name string) error {
+
+	// Support for 6.5 and onwards
+	clusterVersion := o.GetClusterVersion()
+	if clusterVersion < c.INDEXER_65_VERSION {
+		return errors.New("Rename index requires version 6.5 or higher")
+	}
+
+	if len(name) == 0 || strings.HasPrefix(name, c.SHADOW_INDEX_PREFIX) {
+		return fmt.Errorf("Invalid index name %v.", name)
+	}
+
+	idxMeta := o.findIndex(defnId)
+	if idxMeta == nil || idxMeta.Definition.IsShadow() {
+		return fmt.Errorf("Index %v does not exist.", defnId)
+	}
+
+	defn := idxMeta.Definition
+	if defn.Name == name {
+		return nil
+	}
+
+	if o.findShadowIndex(defnId) != nil {
+		return fmt.Errorf("Alter index is in progress for index %v.  Cannot rename index.", defn.Name)
+	}
+
+	if o.findIndexByName(name, defn.Bucket) != nil {
+		return fmt.Errorf("Index %s already exists.", name)
+	}
+
+	if alias, err := mc.FetchIndexAliasToken(defn.Bucket, name); err != nil {
+		return fmt.Errorf("Fail to rename index due to internal errors.  Error=%v.", err)
+	} else if alias != nil {
+		return fmt.Errorf("Index alias %s already exists.", name)
+	}
+
+	watchers, err := o.findWatchersByDefnIdIgnoreStatus(defnId)
+	if err != nil {
+		return fmt.Errorf("Cannot locate cluster node hosting Index %s.", defn.Name)
+	}
+
+	if err := mc.PostRenameCommandToken(defnId, defn.Bucket, name); err != nil {
+		return err
+	}
+
+	content, err := MarshallRenameIndex(&RenameIndex{DefnId: defnId, Name: name})
+	if err != nil {
+		return err
+	}
+
+	logging.Infof("rename index %v (%v, %v) to %v", defnId, defn.Bucket, defn.Name, name)
+
+	key := fmt.Sprintf("%d", defnId)
+	errMap := make(map[string]bool)
+	for _, watcher := range watchers {
+		if _, err := watcher.makeRequest(OPCODE_RENAME_INDEX, key, content); err != nil {
+			errMap[err.Error()] = true
+		}
+	}
+
+	if len(errMap) != 0 {
+		errStr := ""
+		for msg, _ := range errMap {
+			errStr += msg + "\n"
+		}
+
+		msg := fmt.Sprintf("Fail to rename index on some indexer nodes.  Error=%s.  ", errStr)
+		msg += "If cluster or indexer is currently unavailable, the operation will automaticaly retry after cluster is back to normal."
+		return errors.New(msg)
+	}
+
+	after := *defn
+	after.Name = name
+	o.recordDDLHistory(nil, mc.DDLHistoryOpRename, defn, &after)
+
+	return nil
+}
+
+//
+// This function creates an index alias, or atomically retargets an existing
+// index alias to the given index.
+//
+func (o *MetadataProvider) SetIndexAlias(bucket string, alias string, defnId c.IndexDefnId) error {
+
+	if len(alias) == 0 || strings.HasPrefix(alias, c.SHADOW_INDEX_PREFIX) {
+		return fmt.Errorf("Invalid index alias %v.", alias)
+	}
+
+	idxMeta := o.findIndex(defnId)
+	if idxMeta == nil || idxMeta.Definition.IsShadow() {
+		return fmt.Errorf("Index %v does not exist.", defnId)
+	}
+
+	if idxMeta.Definition.Bucket != bucket {
+		return fmt.Errorf("Index %v does not belong to bucket %v.", defnId, bucket)
+	}
+
+	if o.findIndexByName(alias, bucket) != nil {
+		return fmt.Errorf("Index %s already exists.", alias)
+	}
+
+	logging.Infof("set index alias (%v, %v) to index %v (%v)", bucket, alias, defnId, idxMeta.Definition.Name)
+
+	if err := mc.PostIndexAliasToken(bucket, alias, defnId); err != nil {
+		return err
+	}
+
+	o.aliases.AddIndexAliasToken(&mc.IndexAliasToken{Bucket: bucket, Alias: alias, DefnId: defnId})
+	return nil
+}
+
+//
+// This function drops an index alias.  The index pointed to by the alias is not affected.
+//
+func (o *MetadataProvider) DropIndexAlias(bucket string, alias string) error {
+
+	token, err := mc.FetchIndexAliasToken(bucket, alias)
+	if err != nil {
+		return fmt.Errorf("Fail to drop index alias due to internal errors.  Error=%v.", err)
+	}
+
+	if token == nil {
+		return fmt.Errorf("Index alias %v does not exist.", alias)
+	}
+
+	logging.Infof("drop index alias (%v, %v)", bucket, alias)
+
+	if err := mc.DeleteIndexAliasToken(bucket, alias); err != nil {
+		return err
+	}
+
+	o.aliases.RemoveIndexAliasToken(bucket, alias)
+	return nil
+}
+
+//
+// This function returns the index alias of a bucket.  If bucket is empty, it
+// returns the index alias of all buckets.
+//
+func (o *MetadataProvider) ListIndexAlias(bucket string) ([]*mc.IndexAliasToken, error) {
+
+	return mc.ListIndexAliasToken(bucket)
+}
+
+//
+// Retarget all the index alias of a bucket pointing to one index to another index.
+//
+func (o *MetadataProvider) retargetIndexAlias(bucket string, from c.IndexDefnId, to c.IndexDefnId) error {
+
+	tokens, err := mc.ListIndexAliasToken(bucket)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if token.DefnId == from {
+			if err := mc.PostIndexAliasToken(bucket, token.Alias, to); err != nil {
+				return err
+			}
+			o.aliases.AddIndexAliasToken(&mc.IndexAliasToken{Bucket: bucket, Alias: token.Alias, DefnId: to})
+		}
+	}
+
+	return nil
+}
+
+//
+// Drop all the index alias of a bucket pointing to an index.
+//
+func (o *MetadataProvider) dropIndexAliasOfIndex(bucket string, defnId c.IndexDefnId) error {
+
+	tokens, err := mc.ListIndexAliasToken(bucket)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range tokens {
+		if token.DefnId == defnId {
+			logging.Infof("drop index alias (%v, %v) of dropped index %v", bucket, token.Alias, defnId)
+
+			if err := mc.DeleteIndexAliasToken(bucket, token.Alias); err != nil {
+				return err
+			}
+			o.aliases.RemoveIndexAliasToken(bucket, token.Alias)
+		}
+	}
+
 	return nil
 }
 
+//
+// This function resolves an index alias to the index it points to.  It returns
+// nil if the alias does not exist or the index it points to has been dropped.
+// The alias is resolved from cache, unless the cache is not yet available.
+//
+func (o *MetadataProvider) ResolveIndexAlias(bucket string, alias string) (*IndexMetadata, error) {
+
+	token, ok := o.aliases.GetIndexAliasToken(bucket, alias)
+	if !ok {
+		var err error
+		if token, err = mc.FetchIndexAliasToken(bucket, alias); err != nil {
+			return nil, err
+		}
+	}
+
+	if token == nil {
+		return nil, nil
+	}
+
+	return o.findIndex(token.DefnId), nil
+}
+
 //
 // This function adds replica count of an index.
 //
@@ -2737,6 +3420,8 @@ func (o *MetadataProvider) Close() {
 	for _, watcher := range o.watchers {
 		watcher.close()
 	}
+
+	o.aliases.Close()
 }
 
 //
@@ -3042,6 +3727,11 @@ func (o *MetadataProvider) addWatcherNoLock(watcher *watcher, tempIndexerId c.In
 	}
 	o.watchers[indexerId] = watcher
 
+	// subscribe to metadata changes pushed by the indexer
+	if o.deltaNotifyCh != nil {
+		watcher.startFeed()
+	}
+
 	// increment version whenever a watcher is registered
 	o.repo.incrementVersion()
 
@@ -3538,34 +4228,40 @@ func (r *metadataRepo) listDefnWithValidInstNoLock() (map[c.IndexDefnId]*IndexMe
 	result := make(map[c.IndexDefnId]*IndexMetadata)
 	for id, meta := range r.indices {
 		if isValidIndex(meta) {
-			var insts []*InstanceDefn
-			for _, inst := range meta.Instances {
-				if isValidIndexInst(inst) {
-					insts = append(insts, inst)
-				}
-			}
+			result[id] = copyValidIndexMetadata(meta)
+		}
+	}
 
-			var instsInRebalance []*InstanceDefn
-			for _, inst := range meta.InstsInRebalance {
-				if isValidIndexInst(inst) {
-					instsInRebalance = append(instsInRebalance, inst)
-				}
-			}
+	return result, r.getVersion()
+}
 
-			defn := *meta.Definition
-			tmp := &IndexMetadata{
-				Definition:       &defn,
-				State:            meta.State,
-				Error:            meta.Error,
-				Instances:        insts,
-				InstsInRebalance: instsInRebalance,
-			}
+//
+// Copy index metadata with valid instances only.
+//
+func copyValidIndexMetadata(meta *IndexMetadata) *IndexMetadata {
 
-			result[id] = tmp
+	var insts []*InstanceDefn
+	for _, inst := range meta.Instances {
+		if isValidIndexInst(inst) {
+			insts = append(insts, inst)
 		}
 	}
 
-	return result, r.getVersion()
+	var instsInRebalance []*InstanceDefn
+	for _, inst := range meta.InstsInRebalance {
+		if isValidIndexInst(inst) {
+			instsInRebalance = append(instsInRebalance, inst)
+		}
+	}
+
+	defn := *meta.Definition
+	return &IndexMetadata{
+		Definition:       &defn,
+		State:            meta.State,
+		Error:            meta.Error,
+		Instances:        insts,
+		InstsInRebalance: instsInRebalance,
+	}
 }
 
 func (r *metadataRepo) addDefn(defn *c.IndexDefn) {
@@ -3573,6 +4269,11 @@ func (r *metadataRepo) addDefn(defn *c.IndexDefn) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	r.addDefnNoLock(defn)
+}
+
+func (r *metadataRepo) addDefnNoLock(defn *c.IndexDefn) {
+
 	logging.Debugf("metadataRepo.addDefn %v", defn.DefnId)
 
 	// A definition can have mutliple physical copies.  If
@@ -3933,6 +4634,12 @@ func (r *metadataRepo) updateTopology(topology *mc.IndexTopology, indexerId c.In
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
 
+	r.updateTopologyNoLock(topology, indexerId)
+	r.incrementVersion()
+}
+
+func (r *metadataRepo) updateTopologyNoLock(topology *mc.IndexTopology, indexerId c.IndexerId) {
+
 	if len(indexerId) == 0 {
 		indexerId = c.IndexerId(topology.FindIndexerId())
 	}
@@ -3965,29 +4672,7 @@ func (r *metadataRepo) updateTopology(topology *mc.IndexTopology, indexerId c.In
 		r.topology[indexerId][defnId] = true
 
 		for _, instRef := range defnRef.Instances {
-			if _, ok := r.instances[defnId]; !ok {
-				r.instances[defnId] = make(map[c.IndexInstId]map[c.PartitionId]map[uint64]*mc.IndexInstDistribution)
-			}
-
-			if _, ok := r.instances[defnId][c.IndexInstId(instRef.InstId)]; !ok {
-				r.instances[defnId][c.IndexInstId(instRef.InstId)] = make(map[c.PartitionId]map[uint64]*mc.IndexInstDistribution)
-			}
-
-			for k, partnRef := range instRef.Partitions {
-				// for backward compatiblity on non-partitioned index (pre-5.5.)
-				if partnRef.PartId == 0 && partnRef.Version == 0 && instRef.Version != partnRef.Version {
-					instRef.Partitions[k].Version = instRef.Version
-					partnRef.Version = instRef.Version
-				}
-
-				if _, ok := r.instances[defnId][c.IndexInstId(instRef.InstId)][c.PartitionId(partnRef.PartId)]; !ok {
-					r.instances[defnId][c.IndexInstId(instRef.InstId)][c.PartitionId(partnRef.PartId)] = make(map[uint64]*mc.IndexInstDistribution)
-				}
-
-				// r.Instances has all the index instances and partitions regardless of its state and version
-				temp := instRef
-				r.instances[defnId][c.IndexInstId(instRef.InstId)][c.PartitionId(partnRef.PartId)][partnRef.Version] = &temp
-			}
+			r.addInstNoLock(defnId, instRef)
 		}
 
 		logging.Debugf("update Topology: defn %v", defnId)
@@ -3997,8 +4682,33 @@ func (r *metadataRepo) updateTopology(topology *mc.IndexTopology, indexerId c.In
 	if len(indexerId) != 0 {
 		r.cleanupOrphanDefnNoLock(indexerId, topology.Bucket)
 	}
+}
 
-	r.incrementVersion()
+func (r *metadataRepo) addInstNoLock(defnId c.IndexDefnId, instRef mc.IndexInstDistribution) {
+
+	if _, ok := r.instances[defnId]; !ok {
+		r.instances[defnId] = make(map[c.IndexInstId]map[c.PartitionId]map[uint64]*mc.IndexInstDistribution)
+	}
+
+	if _, ok := r.instances[defnId][c.IndexInstId(instRef.InstId)]; !ok {
+		r.instances[defnId][c.IndexInstId(instRef.InstId)] = make(map[c.PartitionId]map[uint64]*mc.IndexInstDistribution)
+	}
+
+	for k, partnRef := range instRef.Partitions {
+		// for backward compatiblity on non-partitioned index (pre-5.5.)
+		if partnRef.PartId == 0 && partnRef.Version == 0 && instRef.Version != partnRef.Version {
+			instRef.Partitions[k].Version = instRef.Version
+			partnRef.Version = instRef.Version
+		}
+
+		if _, ok := r.instances[defnId][c.IndexInstId(instRef.InstId)][c.PartitionId(partnRef.PartId)]; !ok {
+			r.instances[defnId][c.IndexInstId(instRef.InstId)][c.PartitionId(partnRef.PartId)] = make(map[uint64]*mc.IndexInstDistribution)
+		}
+
+		// r.Instances has all the index instances and partitions regardless of its state and version
+		temp := instRef
+		r.instances[defnId][c.IndexInstId(instRef.InstId)][c.PartitionId(partnRef.PartId)][partnRef.Version] = &temp
+	}
 }
 
 func (r *metadataRepo) incrementVersion() {
@@ -4770,6 +5480,7 @@ func (w *watcher) close() {
 		w.timerKillCh <- true
 	}
 
+	w.closeFeed()
 	w.cleanupOnClose()
 }
 
@@ -5200,6 +5911,15 @@ func (w *watcher) processChange(txid common.Txnid, op uint32, key string, conten
 			if len(content) == 0 {
 				logging.Debugf("watcher.processChange(): content of key = %v is empty.", key)
 			}
+
+			// Topology changes are applied from the metadata feed when subscription is in sync.
+			if w.isFeedSynced() {
+				if txid > w.lastSeenTxid {
+					w.lastSeenTxid = txid
+				}
+				return false, nil, nil
+			}
+
 			if err := w.provider.repo.unmarshallAndUpdateTopology(content, indexerId); err != nil {
 				return false, nil, err
 			}
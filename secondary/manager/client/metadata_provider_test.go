@@ -0,0 +1,215 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	c "github.com/couchbase/indexing/secondary/common"
+	mc "github.com/couchbase/indexing/secondary/manager/common"
+)
+
+//
+// Create a metadata provider watching a single indexer, without connecting
+// to the cluster.  The index alias cache is empty and synchronized.
+//
+func newTestMetadataProvider(indexerId c.IndexerId) *MetadataProvider {
+
+	o := new(MetadataProvider)
+	o.watchers = make(map[c.IndexerId]*watcher)
+	o.pendings = make(map[c.IndexerId]chan bool)
+	o.repo = newMetadataRepo(o)
+	o.clusterVersion = c.INDEXER_CUR_VERSION
+	o.aliases = mc.NewIndexAliasListener()
+	o.aliases.Sync(nil)
+
+	o.watchers[indexerId] = &watcher{provider: o, serviceMap: &ServiceMap{IndexerId: string(indexerId)}}
+
+	return o
+}
+
+//
+// Add an active, non-partitioned index residing on the indexer.
+//
+func addTestIndex(o *MetadataProvider, indexerId c.IndexerId, defn *c.IndexDefn, instId c.IndexInstId) {
+
+	o.repo.addDefn(defn)
+
+	topology := &mc.IndexTopology{
+		Bucket: defn.Bucket,
+		Definitions: []mc.IndexDefnDistribution{{
+			Bucket: defn.Bucket,
+			Name:   defn.Name,
+			DefnId: uint64(defn.DefnId),
+			Instances: []mc.IndexInstDistribution{{
+				InstId: uint64(instId),
+				State:  uint32(c.INDEX_STATE_ACTIVE),
+				RState: uint32(c.REBAL_ACTIVE),
+				Partitions: []mc.IndexPartDistribution{{
+					PartId: uint64(c.NON_PARTITION_ID),
+					SinglePartition: mc.IndexSinglePartDistribution{
+						Slices: []mc.IndexSliceLocator{{IndexerId: string(indexerId)}},
+					},
+				}},
+			}},
+		}},
+	}
+
+	o.repo.updateTopology(topology, indexerId)
+}
+
+func TestAlterIndexVisibility(t *testing.T) {
+
+	old := &IndexMetadata{Definition: &c.IndexDefn{DefnId: 1, Name: "idx"}}
+	shadow := &IndexMetadata{Definition: &c.IndexDefn{DefnId: 2, Name: c.ShadowIndexName("idx"), ShadowOf: 1}}
+	swapped := &IndexMetadata{Definition: &c.IndexDefn{DefnId: 2, Name: "idx", Replaces: 1}}
+	other := &IndexMetadata{Definition: &c.IndexDefn{DefnId: 3, Name: "other"}}
+
+	testcases := []struct {
+		comment string
+		indices []*IndexMetadata
+		visible []c.IndexDefnId
+	}{
+		{"not altered", []*IndexMetadata{old, other}, []c.IndexDefnId{1, 3}},
+		{"shadow not swapped", []*IndexMetadata{old, shadow, other}, []c.IndexDefnId{1, 3}},
+		{"shadow swapped", []*IndexMetadata{old, swapped, other}, []c.IndexDefnId{2, 3}},
+		{"old index dropped", []*IndexMetadata{swapped, other}, []c.IndexDefnId{2, 3}},
+	}
+
+	for _, tc := range testcases {
+		indices := make(map[c.IndexDefnId]*IndexMetadata)
+		for _, meta := range tc.indices {
+			indices[meta.Definition.DefnId] = meta
+		}
+
+		replaced := replacedIndexes(indices)
+		visible := make(map[c.IndexDefnId]bool)
+		for id, meta := range indices {
+			if !isHiddenIndex(meta, replaced) {
+				visible[id] = true
+			}
+		}
+
+		if len(visible) != len(tc.visible) {
+			t.Errorf("%v: expected visible indexes %v, got %v", tc.comment, tc.visible, visible)
+			continue
+		}
+		for _, id := range tc.visible {
+			if !visible[id] {
+				t.Errorf("%v: expected index %v to be visible, got %v", tc.comment, id, visible)
+			}
+		}
+	}
+}
+
+func TestRenameIndex(t *testing.T) {
+
+	o := newTestMetadataProvider("idxr1")
+	addTestIndex(o, "idxr1", &c.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}, 11)
+	addTestIndex(o, "idxr1", &c.IndexDefn{DefnId: 2, Bucket: "b1", Name: "idx2"}, 12)
+	addTestIndex(o, "idxr1", &c.IndexDefn{DefnId: 3, Bucket: "b1", Name: c.ShadowIndexName("idx2"), ShadowOf: 2}, 13)
+
+	testcases := []struct {
+		comment string
+		defnId  c.IndexDefnId
+		name    string
+		err     string
+	}{
+		{"empty name", 1, "", "Invalid index name"},
+		{"shadow name", 1, c.ShadowIndexName("idx3"), "Invalid index name"},
+		{"unknown index", 4, "idx3", "does not exist"},
+		{"shadow index", 3, "idx3", "does not exist"},
+		{"same name", 1, "idx1", ""},
+		{"name of another index", 1, "idx2", "already exists"},
+		{"alter in progress", 2, "idx3", "Alter index is in progress"},
+	}
+
+	for _, tc := range testcases {
+		err := o.RenameIndex(tc.defnId, tc.name)
+		if len(tc.err) == 0 {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", tc.comment, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: expected error %q, got %v", tc.comment, tc.err, err)
+		}
+	}
+}
+
+func TestSetIndexAlias(t *testing.T) {
+
+	o := newTestMetadataProvider("idxr1")
+	addTestIndex(o, "idxr1", &c.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}, 11)
+	addTestIndex(o, "idxr1", &c.IndexDefn{DefnId: 2, Bucket: "b1", Name: c.ShadowIndexName("idx1"), ShadowOf: 1}, 12)
+
+	testcases := []struct {
+		comment string
+		bucket  string
+		alias   string
+		defnId  c.IndexDefnId
+		err     string
+	}{
+		{"empty alias", "b1", "", 1, "Invalid index alias"},
+		{"shadow alias", "b1", c.ShadowIndexName("a1"), 1, "Invalid index alias"},
+		{"unknown index", "b1", "a1", 3, "does not exist"},
+		{"shadow index", "b1", "a1", 2, "does not exist"},
+		{"wrong bucket", "b2", "a1", 1, "does not belong to bucket"},
+		{"name of an index", "b1", "idx1", 1, "already exists"},
+	}
+
+	for _, tc := range testcases {
+		err := o.SetIndexAlias(tc.bucket, tc.alias, tc.defnId)
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%v: expected error %q, got %v", tc.comment, tc.err, err)
+		}
+	}
+}
+
+func TestResolveIndexAlias(t *testing.T) {
+
+	o := newTestMetadataProvider("idxr1")
+	addTestIndex(o, "idxr1", &c.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}, 11)
+
+	o.aliases.Sync([]*mc.IndexAliasToken{
+		{Bucket: "b1", Alias: "a1", DefnId: 1},
+		{Bucket: "b1", Alias: "a2", DefnId: 2},
+	})
+
+	testcases := []struct {
+		comment string
+		bucket  string
+		alias   string
+		defnId  c.IndexDefnId
+	}{
+		{"alias", "b1", "a1", 1},
+		{"alias of dropped index", "b1", "a2", 0},
+		{"unknown alias", "b1", "a3", 0},
+		{"alias of another bucket", "b2", "a1", 0},
+	}
+
+	for _, tc := range testcases {
+		meta, err := o.ResolveIndexAlias(tc.bucket, tc.alias)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tc.comment, err)
+			continue
+		}
+
+		if tc.defnId == 0 {
+			if meta != nil {
+				t.Errorf("%v: expected no index, got %v", tc.comment, meta.Definition.DefnId)
+			}
+		} else if meta == nil || meta.Definition.DefnId != tc.defnId {
+			t.Errorf("%v: expected index %v, got %v", tc.comment, tc.defnId, meta)
+		}
+	}
+
+	// retarget alias in cache
+	o.aliases.AddIndexAliasToken(&mc.IndexAliasToken{Bucket: "b1", Alias: "a2", DefnId: 1})
+	if meta, err := o.ResolveIndexAlias("b1", "a2"); err != nil || meta == nil || meta.Definition.DefnId != 1 {
+		t.Errorf("retarget: expected index 1, got %v %v", meta, err)
+	}
+
+	o.aliases.RemoveIndexAliasToken("b1", "a1")
+	if meta, err := o.ResolveIndexAlias("b1", "a1"); err != nil || meta != nil {
+		t.Errorf("drop: expected no index, got %v %v", meta, err)
+	}
+}
@@ -0,0 +1,515 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/cbauth/metakv"
+	c "github.com/couchbase/indexing/secondary/common"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////
+// Const
+////////////////////////////////////////////////////////////////////////
+
+const BuildQueueMetakvDir = DDLMetakvDir + "buildQueue/"
+
+const BuildQueueEntryTag = "entry/"
+const BuildQueueEntryPath = BuildQueueMetakvDir + BuildQueueEntryTag
+
+const BuildQueueStatusTag = "status/"
+const BuildQueueStatusPath = BuildQueueMetakvDir + BuildQueueStatusTag
+
+const maxBuildQueueUpdateRetry = 10
+
+//////////////////////////////////////////////////////////////
+// Concrete Type
+//
+// Each indexer node has its own build queue.  An index with
+// replica on multiple nodes has an entry in the queue of
+// each node.  The builder on each node owns its queue:  it
+// adds entries for scheduled index and removes entries once
+// the index is built.  Priority can be changed, and entries
+// can be cancelled, from any node.
+//
+//////////////////////////////////////////////////////////////
+
+type BuildQueueEntry struct {
+	DefnId      c.IndexDefnId `json:"defnId,omitempty"`
+	IndexerId   c.IndexerId   `json:"indexerId,omitempty"`
+	Bucket      string        `json:"bucket,omitempty"`
+	Name        string        `json:"name,omitempty"`
+	Priority    int           `json:"priority"`
+	EnqueueTime int64         `json:"enqueueTime,omitempty"`
+	StartTime   int64         `json:"startTime,omitempty"`
+	Cancelled   bool          `json:"cancelled,omitempty"`
+}
+
+//
+// Position of an index in the build queue.  Position is 0 if the
+// index is being built.  ETA is the estimated time (unix nano) when
+// the index build starts, or 0 if it cannot be estimated.
+//
+type BuildQueuePosition struct {
+	Position int   `json:"position"`
+	ETA      int64 `json:"eta,omitempty"`
+}
+
+//
+// Status of the build queue of an indexer node, as published by the
+// builder on that node.
+//
+type BuildQueueStatus struct {
+	IndexerId    c.IndexerId                          `json:"indexerId,omitempty"`
+	AvgBuildTime int64                                `json:"avgBuildTime,omitempty"`
+	UpdateTime   int64                                `json:"updateTime,omitempty"`
+	Positions    map[c.IndexDefnId]BuildQueuePosition `json:"positions,omitempty"`
+}
+
+//
+// Build window in minutes since midnight (local time).  If End is
+// smaller than Start, the window crosses midnight.
+//
+type BuildWindow struct {
+	Start int
+	End   int
+}
+
+//////////////////////////////////////////////////////////////
+// Build Queue Entry
+//////////////////////////////////////////////////////////////
+
+func buildQueueEntryPath(indexerId c.IndexerId, defnId c.IndexDefnId) string {
+	return fmt.Sprintf("%v%v/%v", BuildQueueEntryPath, indexerId, defnId)
+}
+
+//
+// Add an index to the build queue of an indexer node.  If the index is
+// already in the queue, the existing entry (and its priority) is kept.
+// A cancelled entry is replaced.
+//
+func AddBuildQueueEntry(indexerId c.IndexerId, defnId c.IndexDefnId, bucket string, name string) (bool, error) {
+
+	path := buildQueueEntryPath(indexerId, defnId)
+
+	for i := 0; i < maxBuildQueueUpdateRetry; i++ {
+
+		current, rev, err := fetchBuildQueueEntry(path)
+		if err != nil {
+			return false, err
+		}
+
+		if current != nil && !current.Cancelled {
+			return false, nil
+		}
+
+		entry := &BuildQueueEntry{
+			DefnId:      defnId,
+			IndexerId:   indexerId,
+			Bucket:      bucket,
+			Name:        name,
+			EnqueueTime: time.Now().UnixNano(),
+		}
+
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return false, err
+		}
+
+		if current == nil {
+			err = metakv.Add(path, buf)
+		} else {
+			err = metakv.Set(path, buf, rev)
+		}
+
+		if err == nil {
+			return true, nil
+		}
+
+		if err != metakv.ErrRevMismatch {
+			return false, errors.New(fmt.Sprintf("Fail to update build queue.  Internal Error = %v", err))
+		}
+	}
+
+	return false, errors.New("Fail to update build queue.  Too many concurrent updates.")
+}
+
+//
+// Update a build queue entry.  The entry is updated by a rev-checked
+// read-modify-write, and it is retried if the entry is changed concurrently
+// by the builder or by another request.  The update function returns false
+// if the entry should be left unchanged.  Return nil if entry does not exist.
+//
+func UpdateBuildQueueEntry(indexerId c.IndexerId, defnId c.IndexDefnId,
+	update func(entry *BuildQueueEntry) bool) (*BuildQueueEntry, error) {
+
+	path := buildQueueEntryPath(indexerId, defnId)
+
+	for i := 0; i < maxBuildQueueUpdateRetry; i++ {
+
+		entry, rev, err := fetchBuildQueueEntry(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if entry == nil {
+			return nil, nil
+		}
+
+		if !update(entry) {
+			return entry, nil
+		}
+
+		buf, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+
+		err = metakv.Set(path, buf, rev)
+		if err == nil {
+			return entry, nil
+		}
+
+		if err != metakv.ErrRevMismatch {
+			return nil, errors.New(fmt.Sprintf("Fail to update build queue.  Internal Error = %v", err))
+		}
+	}
+
+	return nil, errors.New("Fail to update build queue.  Too many concurrent updates.")
+}
+
+func fetchBuildQueueEntry(path string) (*BuildQueueEntry, interface{}, error) {
+
+	buf, rev, err := metakv.Get(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if buf == nil {
+		return nil, nil, nil
+	}
+
+	entry := &BuildQueueEntry{}
+	if err := json.Unmarshal(buf, entry); err != nil {
+		return nil, nil, err
+	}
+
+	return entry, rev, nil
+}
+
+//
+// Fetch a build queue entry.  Return nil if entry does not exist.
+//
+func FetchBuildQueueEntry(indexerId c.IndexerId, defnId c.IndexDefnId) (*BuildQueueEntry, error) {
+
+	entry := &BuildQueueEntry{}
+	exists, err := c.MetakvGet(buildQueueEntryPath(indexerId, defnId), entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+func DeleteBuildQueueEntry(indexerId c.IndexerId, defnId c.IndexDefnId) error {
+
+	return c.MetakvDel(buildQueueEntryPath(indexerId, defnId))
+}
+
+//
+// Return the build queue entries of an indexer node.  If indexerId
+// is empty, return the entries of all indexer nodes.
+//
+func ListBuildQueueEntry(indexerId c.IndexerId) ([]*BuildQueueEntry, error) {
+
+	dir := BuildQueueEntryPath
+	if len(indexerId) != 0 {
+		dir = fmt.Sprintf("%v%v/", BuildQueueEntryPath, indexerId)
+	}
+
+	paths, err := c.MetakvList(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*BuildQueueEntry
+
+	if len(paths) != 0 {
+		result = make([]*BuildQueueEntry, 0, len(paths))
+		for _, path := range paths {
+			entry := &BuildQueueEntry{}
+			exist, err := c.MetakvGet(path, entry)
+			if err != nil {
+				return nil, err
+			}
+
+			if exist {
+				result = append(result, entry)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+//
+// Sort build queue entries in build order:  higher priority first, then
+// first come first serve.
+//
+func SortBuildQueue(entries []*BuildQueueEntry) {
+	sort.Sort(buildQueueSorter(entries))
+}
+
+type buildQueueSorter []*BuildQueueEntry
+
+func (s buildQueueSorter) Len() int {
+	return len(s)
+}
+
+func (s buildQueueSorter) Less(i, j int) bool {
+	if s[i].Priority != s[j].Priority {
+		return s[i].Priority > s[j].Priority
+	}
+	if s[i].EnqueueTime != s[j].EnqueueTime {
+		return s[i].EnqueueTime < s[j].EnqueueTime
+	}
+	return s[i].DefnId < s[j].DefnId
+}
+
+func (s buildQueueSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+//
+// Compute the position and ETA of the entries in the build queue of a
+// single indexer node.  The entries must be sorted in build order.
+//
+func ComputeBuildQueuePositions(entries []*BuildQueueEntry, avgBuildTime time.Duration, maxConcurrent int,
+	windows []BuildWindow, now time.Time) map[c.IndexDefnId]BuildQueuePosition {
+
+	result := make(map[c.IndexDefnId]BuildQueuePosition)
+
+	running := 0
+	for _, entry := range entries {
+		if entry.StartTime != 0 && !entry.Cancelled {
+			result[entry.DefnId] = BuildQueuePosition{Position: 0, ETA: entry.StartTime}
+			running++
+		}
+	}
+
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+
+	position := 0
+	for _, entry := range entries {
+		if entry.StartTime != 0 || entry.Cancelled {
+			continue
+		}
+
+		position++
+
+		// number of build rounds ahead of this index
+		rounds := (running + position - 1) / maxConcurrent
+
+		var eta int64
+		if rounds == 0 || avgBuildTime != 0 {
+			t := NextBuildWindow(windows, now)
+			for i := 0; i < rounds; i++ {
+				t = NextBuildWindow(windows, t.Add(avgBuildTime))
+			}
+			eta = t.UnixNano()
+		}
+
+		result[entry.DefnId] = BuildQueuePosition{Position: position, ETA: eta}
+	}
+
+	return result
+}
+
+//////////////////////////////////////////////////////////////
+// Build Queue Status
+//////////////////////////////////////////////////////////////
+
+func PostBuildQueueStatus(status *BuildQueueStatus) error {
+
+	path := fmt.Sprintf("%v%v", BuildQueueStatusPath, status.IndexerId)
+	if err := c.MetakvSet(path, status); err != nil {
+		return errors.New(fmt.Sprintf("Fail to update build queue status.  Internal Error = %v", err))
+	}
+
+	return nil
+}
+
+//
+// Fetch the build queue status of an indexer node.  Return nil if status does not exist.
+//
+func FetchBuildQueueStatus(indexerId c.IndexerId) (*BuildQueueStatus, error) {
+
+	status := &BuildQueueStatus{}
+	exists, err := c.MetakvGet(fmt.Sprintf("%v%v", BuildQueueStatusPath, indexerId), status)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	return status, nil
+}
+
+func ListBuildQueueStatus() ([]*BuildQueueStatus, error) {
+
+	paths, err := c.MetakvList(BuildQueueStatusPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*BuildQueueStatus
+
+	for _, path := range paths {
+		status := &BuildQueueStatus{}
+		exist, err := c.MetakvGet(path, status)
+		if err != nil {
+			return nil, err
+		}
+
+		if exist {
+			result = append(result, status)
+		}
+	}
+
+	return result, nil
+}
+
+//////////////////////////////////////////////////////////////
+// Build Window
+//////////////////////////////////////////////////////////////
+
+//
+// Parse build windows of the form "HH:MM-HH:MM[,HH:MM-HH:MM...]".
+// Empty string means there is no restriction.
+//
+func ParseBuildWindows(value string) ([]BuildWindow, error) {
+
+	var windows []BuildWindow
+
+	for _, str := range strings.Split(value, ",") {
+		str = strings.TrimSpace(str)
+		if len(str) == 0 {
+			continue
+		}
+
+		bounds := strings.Split(str, "-")
+		if len(bounds) != 2 {
+			return nil, fmt.Errorf("Invalid build window %v", str)
+		}
+
+		start, err := parseMinuteOfDay(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid build window %v: %v", str, err)
+		}
+
+		end, err := parseMinuteOfDay(bounds[1])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid build window %v: %v", str, err)
+		}
+
+		if start == end {
+			return nil, fmt.Errorf("Invalid build window %v: empty window", str)
+		}
+
+		windows = append(windows, BuildWindow{Start: start, End: end})
+	}
+
+	return windows, nil
+}
+
+func parseMinuteOfDay(str string) (int, error) {
+
+	parts := strings.Split(strings.TrimSpace(str), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("time must be in HH:MM format")
+	}
+
+	hour, err := strconv.Atoi(parts[0])
+	if err != nil || hour < 0 || hour > 24 {
+		return 0, fmt.Errorf("invalid hour %v", parts[0])
+	}
+
+	minute, err := strconv.Atoi(parts[1])
+	if err != nil || minute < 0 || minute > 59 || (hour == 24 && minute != 0) {
+		return 0, fmt.Errorf("invalid minute %v", parts[1])
+	}
+
+	return (hour*60 + minute) % (24 * 60), nil
+}
+
+func (w BuildWindow) contains(minute int) bool {
+
+	if w.Start < w.End {
+		return minute >= w.Start && minute < w.End
+	}
+
+	// window crosses midnight
+	return minute >= w.Start || minute < w.End
+}
+
+//
+// Is the given time within any of the build windows?
+//
+func InBuildWindow(windows []BuildWindow, now time.Time) bool {
+
+	if len(windows) == 0 {
+		return true
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	for _, window := range windows {
+		if window.contains(minute) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+// Return the earliest time, at or after the given time, that is within
+// a build window.
+//
+func NextBuildWindow(windows []BuildWindow, now time.Time) time.Time {
+
+	if InBuildWindow(windows, now) {
+		return now
+	}
+
+	minute := now.Hour()*60 + now.Minute()
+	delay := 24 * 60
+	for _, window := range windows {
+		d := (window.Start - minute + 24*60) % (24 * 60)
+		if d < delay {
+			delay = d
+		}
+	}
+
+	return now.Truncate(time.Minute).Add(time.Duration(delay) * time.Minute)
+}
@@ -0,0 +1,170 @@
+package common
+
+import (
+	"testing"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestParseBuildWindows(t *testing.T) {
+
+	testcases := []struct {
+		value   string
+		windows []BuildWindow
+		err     bool
+	}{
+		{"", nil, false},
+		{" , ", nil, false},
+		{"01:00-05:30", []BuildWindow{{60, 330}}, false},
+		{"22:00-02:00", []BuildWindow{{1320, 120}}, false},
+		{"00:00-24:00", nil, true},
+		{"20:00-24:00", []BuildWindow{{1200, 0}}, false},
+		{"01:00-02:00, 13:15-14:45", []BuildWindow{{60, 120}, {795, 885}}, false},
+		{"01:00", nil, true},
+		{"01:00-02:00-03:00", nil, true},
+		{"1-2", nil, true},
+		{"25:00-02:00", nil, true},
+		{"01:60-02:00", nil, true},
+		{"24:01-02:00", nil, true},
+		{"ab:00-02:00", nil, true},
+		{"02:00-02:00", nil, true},
+	}
+
+	for _, tc := range testcases {
+		windows, err := ParseBuildWindows(tc.value)
+		if tc.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %v", tc.value, windows)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", tc.value, err)
+			continue
+		}
+
+		if len(windows) != len(tc.windows) {
+			t.Errorf("%q: expected %v, got %v", tc.value, tc.windows, windows)
+			continue
+		}
+
+		for i := range windows {
+			if windows[i] != tc.windows[i] {
+				t.Errorf("%q: expected %v, got %v", tc.value, tc.windows, windows)
+				break
+			}
+		}
+	}
+}
+
+func TestNextBuildWindow(t *testing.T) {
+
+	at := func(hour, minute, second int) time.Time {
+		return time.Date(2020, 1, 1, hour, minute, second, 0, time.Local)
+	}
+
+	windows := []BuildWindow{{60, 120}, {1320, 180}}
+
+	testcases := []struct {
+		comment string
+		windows []BuildWindow
+		now     time.Time
+		next    time.Time
+	}{
+		{"no window", nil, at(10, 30, 15), at(10, 30, 15)},
+		{"in window", []BuildWindow{{60, 120}}, at(1, 30, 15), at(1, 30, 15)},
+		{"start of window", []BuildWindow{{60, 120}}, at(1, 0, 0), at(1, 0, 0)},
+		{"end of window", []BuildWindow{{60, 120}}, at(2, 0, 0), at(1, 0, 0).Add(24 * time.Hour)},
+		{"before window", []BuildWindow{{60, 120}}, at(0, 30, 15), at(1, 0, 0)},
+		{"after window", []BuildWindow{{60, 120}}, at(10, 30, 15), at(1, 0, 0).Add(24 * time.Hour)},
+		{"in window across midnight", windows, at(23, 0, 0), at(23, 0, 0)},
+		{"in window after midnight", windows, at(2, 30, 0), at(2, 30, 0)},
+		{"earliest window", windows, at(10, 30, 0), at(22, 0, 0)},
+	}
+
+	for _, tc := range testcases {
+		if next := NextBuildWindow(tc.windows, tc.now); !next.Equal(tc.next) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.next, next)
+		}
+
+		if InBuildWindow(tc.windows, tc.now) != tc.next.Equal(tc.now) {
+			t.Errorf("%v: unexpected InBuildWindow %v", tc.comment, !tc.next.Equal(tc.now))
+		}
+	}
+}
+
+func TestComputeBuildQueuePositions(t *testing.T) {
+
+	now := time.Date(2020, 1, 1, 10, 0, 0, 0, time.Local)
+	avg := 10 * time.Minute
+
+	entries := []*BuildQueueEntry{
+		{DefnId: 1, StartTime: now.Add(-time.Minute).UnixNano()},
+		{DefnId: 2},
+		{DefnId: 3, Cancelled: true},
+		{DefnId: 4},
+		{DefnId: 5},
+	}
+
+	testcases := []struct {
+		comment       string
+		avg           time.Duration
+		maxConcurrent int
+		windows       []BuildWindow
+		positions     map[c.IndexDefnId]BuildQueuePosition
+	}{
+		{"one at a time", avg, 1, nil, map[c.IndexDefnId]BuildQueuePosition{
+			1: {0, now.Add(-time.Minute).UnixNano()},
+			2: {1, now.Add(avg).UnixNano()},
+			4: {2, now.Add(2 * avg).UnixNano()},
+			5: {3, now.Add(3 * avg).UnixNano()},
+		}},
+		{"two at a time", avg, 2, nil, map[c.IndexDefnId]BuildQueuePosition{
+			1: {0, now.Add(-time.Minute).UnixNano()},
+			2: {1, now.UnixNano()},
+			4: {2, now.Add(avg).UnixNano()},
+			5: {3, now.Add(avg).UnixNano()},
+		}},
+		{"unknown build time", 0, 2, nil, map[c.IndexDefnId]BuildQueuePosition{
+			1: {0, now.Add(-time.Minute).UnixNano()},
+			2: {1, now.UnixNano()},
+			4: {2, 0},
+			5: {3, 0},
+		}},
+		{"invalid concurrency", avg, 0, nil, map[c.IndexDefnId]BuildQueuePosition{
+			1: {0, now.Add(-time.Minute).UnixNano()},
+			2: {1, now.Add(avg).UnixNano()},
+			4: {2, now.Add(2 * avg).UnixNano()},
+			5: {3, now.Add(3 * avg).UnixNano()},
+		}},
+		{"build window", avg, 2, []BuildWindow{{600, 615}}, map[c.IndexDefnId]BuildQueuePosition{
+			1: {0, now.Add(-time.Minute).UnixNano()},
+			2: {1, now.UnixNano()},
+			4: {2, now.Add(avg).UnixNano()},
+			5: {3, now.Add(avg).UnixNano()},
+		}},
+		{"next build window", avg, 1, []BuildWindow{{600, 615}}, map[c.IndexDefnId]BuildQueuePosition{
+			1: {0, now.Add(-time.Minute).UnixNano()},
+			2: {1, now.Add(avg).UnixNano()},
+			4: {2, now.Add(24 * time.Hour).UnixNano()},
+			5: {3, now.Add(24*time.Hour + avg).UnixNano()},
+		}},
+	}
+
+	for _, tc := range testcases {
+		positions := ComputeBuildQueuePositions(entries, tc.avg, tc.maxConcurrent, tc.windows, now)
+
+		if len(positions) != len(tc.positions) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.positions, positions)
+			continue
+		}
+
+		for defnId, expected := range tc.positions {
+			if actual, ok := positions[defnId]; !ok || actual != expected {
+				t.Errorf("%v: index %v expected %v, got %v", tc.comment, defnId, expected, actual)
+			}
+		}
+	}
+}
@@ -0,0 +1,371 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package common
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/cbauth/metakv"
+	c "github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"reflect"
+	"sort"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////
+// Const
+////////////////////////////////////////////////////////////////////////
+
+const DDLHistoryMetakvDir = DDLMetakvDir + "history/"
+
+const (
+	DDLHistoryOpCreate       = "create"
+	DDLHistoryOpDrop         = "drop"
+	DDLHistoryOpBuild        = "build"
+	DDLHistoryOpAlter        = "alter"
+	DDLHistoryOpAlterReplica = "alterReplica"
+	DDLHistoryOpRename       = "rename"
+	DDLHistoryOpMove         = "move"
+)
+
+//
+// Retention of DDL history.  The oldest entries of a bucket are removed
+// once the bucket has more than DDLHistoryMaxEntries entries, or once
+// the entries are older than DDLHistoryMaxAge.  The entries are pruned
+// every DDLHistoryPruneInterval, so a bucket can exceed the retention
+// in between.
+//
+const (
+	DDLHistoryMaxEntries    = 1000
+	DDLHistoryMaxAge        = 90 * 24 * time.Hour
+	DDLHistoryPruneInterval = time.Hour
+)
+
+const (
+	DDLHistorySourceQuery = "query"
+	DDLHistorySourceREST  = "rest"
+)
+
+//
+// These fields of index definition only describe a single
+// instance or partition during DDL.  They are not part of the
+// logical definition and are ignored when comparing definitions.
+//
+var ddlHistoryTransientFields = map[string]bool{
+	"instanceVersion": true,
+	"replicaId":       true,
+	"instanceId":      true,
+	"partitions":      true,
+	"versions":        true,
+	"realInstId":      true,
+}
+
+//////////////////////////////////////////////////////////////
+// Concrete Type
+//
+// DDL history is an append-only log of DDL operations.  An
+// entry is never updated once it is posted.  It is removed
+// only when it falls out of the retention of its bucket.  The
+// entry id is prefixed with the timestamp so the entries of
+// a bucket are listed in the order they are posted.
+//
+//////////////////////////////////////////////////////////////
+
+//
+// The context in which the DDL is requested.
+//
+type DDLHistoryContext struct {
+	Source     string `json:"source,omitempty"`
+	Node       string `json:"node,omitempty"`
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	RequestId  string `json:"requestId,omitempty"`
+	FromEntry  string `json:"fromEntry,omitempty"`
+}
+
+//
+// The credential and context of the request that issues a DDL.
+//
+type DDLRequest struct {
+	Creds   cbauth.Creds
+	Context DDLHistoryContext
+}
+
+type DDLHistoryEntry struct {
+	Id        string            `json:"id"`
+	Op        string            `json:"op"`
+	DefnId    c.IndexDefnId     `json:"defnId,omitempty"`
+	Bucket    string            `json:"bucket,omitempty"`
+	Name      string            `json:"name,omitempty"`
+	User      string            `json:"user,omitempty"`
+	Timestamp int64             `json:"timestamp"`
+	Context   DDLHistoryContext `json:"context"`
+	Before    *c.IndexDefn      `json:"before,omitempty"`
+	After     *c.IndexDefn      `json:"after,omitempty"`
+}
+
+//
+// A field of the index definition changed by a DDL.
+//
+type DDLHistoryDiff struct {
+	Field  string      `json:"field"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+//////////////////////////////////////////////////////////////
+// DDL History Entry
+//////////////////////////////////////////////////////////////
+
+func ddlHistoryEntryPath(bucket string, id string) string {
+	return fmt.Sprintf("%v%v/%v", DDLHistoryMetakvDir, bucket, id)
+}
+
+//
+// Append an entry to the DDL history.  The entry id and timestamp are
+// assigned by this function.
+//
+func PostDDLHistoryEntry(entry *DDLHistoryEntry) error {
+
+	if len(entry.Bucket) == 0 {
+		return errors.New("Fail to update DDL history.  Missing bucket.")
+	}
+
+	entry.Timestamp = time.Now().UnixNano()
+	entry.Id = fmt.Sprintf("%020d-%v", entry.Timestamp, entry.DefnId)
+
+	if err := c.MetakvSet(ddlHistoryEntryPath(entry.Bucket, entry.Id), entry); err != nil {
+		return errors.New(fmt.Sprintf("Fail to update DDL history.  Internal Error = %v", err))
+	}
+
+	return nil
+}
+
+//
+// Record a DDL operation in the DDL history.  Either before or after
+// can be nil (e.g. create or drop).  DDL history is informational.  An
+// error is logged but does not fail the DDL.
+//
+func RecordDDLHistory(op string, user string, context DDLHistoryContext, before *c.IndexDefn, after *c.IndexDefn) {
+
+	defn := after
+	if defn == nil {
+		defn = before
+	}
+	if defn == nil {
+		return
+	}
+
+	entry := &DDLHistoryEntry{
+		Op:      op,
+		DefnId:  defn.DefnId,
+		Bucket:  defn.Bucket,
+		Name:    defn.Name,
+		User:    user,
+		Context: context,
+		Before:  before,
+		After:   after,
+	}
+
+	if err := PostDDLHistoryEntry(entry); err != nil {
+		logging.Warnf("RecordDDLHistory: fail to record %v of index %v (%v, %v): %v", op, defn.DefnId, defn.Bucket, defn.Name, err)
+	}
+}
+
+//
+// Remove the entries that fall out of the retention of DDL history.
+// If bucket is empty, prune the DDL history of all buckets.
+//
+func PruneDDLHistory(bucket string) error {
+
+	entries, err := ListDDLHistory(bucket)
+	if err != nil {
+		return errors.New(fmt.Sprintf("Fail to prune DDL history.  Internal Error = %v", err))
+	}
+
+	// entries remain in the order they are posted within each bucket
+	buckets := make(map[string][]*DDLHistoryEntry)
+	for _, entry := range entries {
+		buckets[entry.Bucket] = append(buckets[entry.Bucket], entry)
+	}
+
+	now := time.Now()
+	for _, entries := range buckets {
+		for _, entry := range expiredDDLHistory(entries, DDLHistoryMaxEntries, DDLHistoryMaxAge, now) {
+			if err := c.MetakvDel(ddlHistoryEntryPath(entry.Bucket, entry.Id)); err != nil {
+				return errors.New(fmt.Sprintf("Fail to prune DDL history.  Internal Error = %v", err))
+			}
+		}
+	}
+
+	return nil
+}
+
+//
+// Return the entries that fall out of the retention.  The entries must
+// be sorted in the order they are posted.  An entry is expired if it is
+// older than maxAge, or if there are more than maxEntries newer entries.
+// A non-positive maxEntries or maxAge disables the respective limit.
+//
+func expiredDDLHistory(entries []*DDLHistoryEntry, maxEntries int, maxAge time.Duration, now time.Time) []*DDLHistoryEntry {
+
+	var result []*DDLHistoryEntry
+
+	for i, entry := range entries {
+		if maxEntries > 0 && len(entries)-i > maxEntries {
+			result = append(result, entry)
+			continue
+		}
+
+		if maxAge > 0 && now.Sub(time.Unix(0, entry.Timestamp)) > maxAge {
+			result = append(result, entry)
+		}
+	}
+
+	return result
+}
+
+//
+// Fetch a DDL history entry.  Return nil if entry does not exist.
+//
+func FetchDDLHistoryEntry(bucket string, id string) (*DDLHistoryEntry, error) {
+
+	entry := &DDLHistoryEntry{}
+	exists, err := c.MetakvGet(ddlHistoryEntryPath(bucket, id), entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	return entry, nil
+}
+
+//
+// Return the DDL history of a bucket in the order the entries are
+// posted.  If bucket is empty, return the DDL history of all buckets.
+//
+func ListDDLHistory(bucket string) ([]*DDLHistoryEntry, error) {
+
+	dir := DDLHistoryMetakvDir
+	if len(bucket) != 0 {
+		dir = DDLHistoryMetakvDir + bucket + "/"
+	}
+
+	// The values are listed along with the paths.
+	entries, err := metakv.ListAllChildren(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*DDLHistoryEntry
+
+	if len(entries) != 0 {
+		result = make([]*DDLHistoryEntry, 0, len(entries))
+		for _, kv := range entries {
+			if len(kv.Value) == 0 {
+				continue
+			}
+
+			entry := &DDLHistoryEntry{}
+			if err := json.Unmarshal(kv.Value, entry); err != nil {
+				return nil, err
+			}
+
+			result = append(result, entry)
+		}
+	}
+
+	sort.Sort(ddlHistorySorter(result))
+
+	return result, nil
+}
+
+type ddlHistorySorter []*DDLHistoryEntry
+
+func (s ddlHistorySorter) Len() int {
+	return len(s)
+}
+
+func (s ddlHistorySorter) Less(i, j int) bool {
+	return s[i].Id < s[j].Id
+}
+
+func (s ddlHistorySorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+//////////////////////////////////////////////////////////////
+// Definition Diff
+//////////////////////////////////////////////////////////////
+
+//
+// Return the fields of the index definition that differ between before
+// and after, sorted by field name.  Fields are named after their json
+// tag.  Instance specific fields are ignored.
+//
+func DiffIndexDefn(before *c.IndexDefn, after *c.IndexDefn) ([]DDLHistoryDiff, error) {
+
+	toMap := func(defn *c.IndexDefn) (map[string]interface{}, error) {
+		result := make(map[string]interface{})
+		if defn == nil {
+			return result, nil
+		}
+
+		buf, err := json.Marshal(defn)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(buf, &result); err != nil {
+			return nil, err
+		}
+		return result, nil
+	}
+
+	m1, err := toMap(before)
+	if err != nil {
+		return nil, err
+	}
+
+	m2, err := toMap(after)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]bool)
+	for field, _ := range m1 {
+		fields[field] = true
+	}
+	for field, _ := range m2 {
+		fields[field] = true
+	}
+
+	names := make([]string, 0, len(fields))
+	for field, _ := range fields {
+		if !ddlHistoryTransientFields[field] {
+			names = append(names, field)
+		}
+	}
+	sort.Strings(names)
+
+	var result []DDLHistoryDiff
+	for _, field := range names {
+		if !reflect.DeepEqual(m1[field], m2[field]) {
+			result = append(result, DDLHistoryDiff{Field: field, Before: m1[field], After: m2[field]})
+		}
+	}
+
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package common
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	c "github.com/couchbase/indexing/secondary/common"
+)
+
+func TestDiffIndexDefn(t *testing.T) {
+
+	base := c.IndexDefn{
+		DefnId:    c.IndexDefnId(100),
+		Name:      "idx",
+		Bucket:    "default",
+		Using:     c.PlasmaDB,
+		SecExprs:  []string{"`age`"},
+		WhereExpr: "`age` > 10",
+	}
+
+	rename := base
+	rename.Name = "idx2"
+
+	alter := base
+	alter.SecExprs = []string{"`age`", "`name`"}
+	alter.WhereExpr = ""
+	alter.Deferred = true
+
+	transient := base
+	transient.InstId = c.IndexInstId(200)
+	transient.ReplicaId = 1
+	transient.InstVersion = 2
+	transient.Partitions = []c.PartitionId{1, 2}
+	transient.Versions = []int{0, 0}
+	transient.RealInstId = c.IndexInstId(300)
+
+	testcases := []struct {
+		comment string
+		before  *c.IndexDefn
+		after   *c.IndexDefn
+		fields  []string
+	}{
+		{"no change", &base, &base, nil},
+		{"rename", &base, &rename, []string{"name"}},
+		{"sorted by field", &base, &alter, []string{"deferred", "secExprs", "where"}},
+		{"transient fields ignored", &base, &transient, nil},
+		{"no definition", nil, nil, nil},
+	}
+
+	for _, tc := range testcases {
+		diffs, err := DiffIndexDefn(tc.before, tc.after)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tc.comment, err)
+			continue
+		}
+
+		var fields []string
+		for _, diff := range diffs {
+			fields = append(fields, diff.Field)
+		}
+
+		if !reflect.DeepEqual(fields, tc.fields) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.fields, fields)
+		}
+	}
+
+	// The values of a changed field are reported as json values.
+	diffs, _ := DiffIndexDefn(&base, &rename)
+	if len(diffs) != 1 || diffs[0].Before != "idx" || diffs[0].After != "idx2" {
+		t.Errorf("rename: expected idx -> idx2, got %v", diffs)
+	}
+
+	// A create or drop reports every field of the definition, except
+	// the transient fields, against an empty definition.
+	for _, tc := range []struct {
+		comment string
+		before  *c.IndexDefn
+		after   *c.IndexDefn
+	}{
+		{"create", nil, &transient},
+		{"drop", &transient, nil},
+	} {
+		diffs, err := DiffIndexDefn(tc.before, tc.after)
+		if err != nil {
+			t.Errorf("%v: unexpected error %v", tc.comment, err)
+			continue
+		}
+
+		found := make(map[string]bool)
+		for i, diff := range diffs {
+			found[diff.Field] = true
+			if i > 0 && diffs[i-1].Field >= diff.Field {
+				t.Errorf("%v: fields not sorted at %v", tc.comment, diff.Field)
+			}
+		}
+
+		for _, field := range []string{"name", "bucket", "secExprs", "where"} {
+			if !found[field] {
+				t.Errorf("%v: expected field %v in diff", tc.comment, field)
+			}
+		}
+
+		for field, _ := range ddlHistoryTransientFields {
+			if found[field] {
+				t.Errorf("%v: unexpected transient field %v in diff", tc.comment, field)
+			}
+		}
+	}
+}
+
+func TestExpiredDDLHistory(t *testing.T) {
+
+	now := time.Now()
+
+	newEntries := func(ages ...time.Duration) []*DDLHistoryEntry {
+		var entries []*DDLHistoryEntry
+		for i, age := range ages {
+			ts := now.Add(-age).UnixNano()
+			entries = append(entries, &DDLHistoryEntry{Id: string(rune('a' + i)), Timestamp: ts})
+		}
+		return entries
+	}
+
+	testcases := []struct {
+		comment    string
+		entries    []*DDLHistoryEntry
+		maxEntries int
+		maxAge     time.Duration
+		expired    []string
+	}{
+		{"empty", nil, 2, time.Hour, nil},
+		{"within retention", newEntries(3*time.Minute, 2*time.Minute, time.Minute), 3, time.Hour, nil},
+		{"over count", newEntries(3*time.Minute, 2*time.Minute, time.Minute), 2, time.Hour, []string{"a"}},
+		{"over age", newEntries(3*time.Hour, 2*time.Hour, time.Minute), 3, time.Hour, []string{"a", "b"}},
+		{"over count and age", newEntries(3*time.Hour, 2*time.Minute, time.Minute), 1, time.Hour, []string{"a", "b"}},
+		{"no count limit", newEntries(3*time.Minute, 2*time.Minute, time.Minute), 0, time.Hour, nil},
+		{"no age limit", newEntries(3*time.Hour, 2*time.Hour, time.Minute), 3, 0, nil},
+	}
+
+	for _, tc := range testcases {
+		var expired []string
+		for _, entry := range expiredDDLHistory(tc.entries, tc.maxEntries, tc.maxAge, now) {
+			expired = append(expired, entry.Id)
+		}
+
+		if !reflect.DeepEqual(expired, tc.expired) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expired, expired)
+		}
+	}
+}
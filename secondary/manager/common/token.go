@@ -42,6 +42,15 @@ const BuildDDLCommandTokenPath = CommandMetakvDir + BuildDDLCommandTokenTag
 const DropInstanceDDLCommandTokenTag = "dropInstance/"
 const DropInstanceDDLCommandTokenPath = CommandMetakvDir + DropInstanceDDLCommandTokenTag
 
+const RenameDDLCommandTokenTag = "rename/"
+const RenameDDLCommandTokenPath = CommandMetakvDir + RenameDDLCommandTokenTag
+
+const AlterDDLCommandTokenTag = "alter/"
+const AlterDDLCommandTokenPath = CommandMetakvDir + AlterDDLCommandTokenTag
+
+const IndexAliasTokenTag = "alias/"
+const IndexAliasTokenPath = DDLMetakvDir + IndexAliasTokenTag
+
 const IndexerVersionTokenTag = "versionToken"
 const IndexerVersionTokenPath = InfoMetakvDir + IndexerVersionTokenTag
 
@@ -97,6 +106,41 @@ type DropInstanceCommandToken struct {
 	Defn      c.IndexDefn
 }
 
+type RenameCommandToken struct {
+	DefnId c.IndexDefnId
+	Bucket string
+	Name   string
+}
+
+//
+// An alter token tracks an alter index from the creation of the shadow
+// index until it is swapped in.  Unlike the other command tokens, it is
+// mutable.  Swapped is set by a rev-checked update, which is the commit
+// point of the swap.  A shadow index without alter token is dropped by
+// the janitor.  A swapped token is kept until both the old index and the
+// altered index are dropped.
+//
+type AlterCommandToken struct {
+	DefnId       c.IndexDefnId
+	ShadowDefnId c.IndexDefnId
+	Bucket       string
+	Name         string
+	Expiry       int64
+	Swapped      bool
+}
+
+//
+// An index alias is a stable name that resolves to the index
+// definition it currently points to.  Unlike the command tokens,
+// an alias token is mutable.  It is retargeted by overwriting the
+// token, which is atomic in metakv.
+//
+type IndexAliasToken struct {
+	Bucket string
+	Alias  string
+	DefnId c.IndexDefnId
+}
+
 type IndexerVersionToken struct {
 	Version uint64
 }
@@ -121,6 +165,18 @@ type CommandListener struct {
 	donech         chan bool
 }
 
+//
+// IndexAliasListener keeps a cache of the index alias, so that an index
+// alias can be resolved without reading metakv.  The cache is not used
+// until it has been synchronized with metakv.
+//
+type IndexAliasListener struct {
+	tokens   map[string]*IndexAliasToken // key: token path
+	synced   bool
+	mutex    sync.RWMutex
+	cancelCh chan struct{}
+}
+
 //////////////////////////////////////////////////////////////
 // Create Token Management
 //////////////////////////////////////////////////////////////
@@ -629,6 +685,540 @@ func MarshallDropInstanceCommandTokenList(r *DropInstanceCommandTokenList) ([]by
 	return buf, nil
 }
 
+//////////////////////////////////////////////////////////////
+// Rename Token Management
+//////////////////////////////////////////////////////////////
+
+//
+// Generate a token to metakv for recovery purpose.  An indexer that
+// misses the rename request will pick up the new name from the token.
+//
+func PostRenameCommandToken(defnId c.IndexDefnId, bucket string, name string) error {
+
+	commandToken := &RenameCommandToken{
+		DefnId: defnId,
+		Bucket: bucket,
+		Name:   name,
+	}
+
+	id := fmt.Sprintf("%v", defnId)
+	if err := c.MetakvSet(RenameDDLCommandTokenPath+id, commandToken); err != nil {
+		return errors.New(fmt.Sprintf("Fail to rename index.  Internal Error = %v", err))
+	}
+
+	return nil
+}
+
+//
+// Fetch the rename token of an index.  Return nil if token does not exist.
+//
+func FetchRenameCommandToken(defnId c.IndexDefnId) (*RenameCommandToken, error) {
+
+	commandToken := &RenameCommandToken{}
+	id := fmt.Sprintf("%v", defnId)
+	exists, err := c.MetakvGet(RenameDDLCommandTokenPath+id, commandToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	return commandToken, nil
+}
+
+//
+// Return the list of rename token
+//
+func ListRenameCommandToken() ([]*RenameCommandToken, error) {
+
+	paths, err := c.MetakvList(RenameDDLCommandTokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*RenameCommandToken
+
+	if len(paths) != 0 {
+		result = make([]*RenameCommandToken, 0, len(paths))
+		for _, path := range paths {
+			token := &RenameCommandToken{}
+			exist, err := c.MetakvGet(path, token)
+			if err != nil {
+				return nil, err
+			}
+
+			if exist {
+				result = append(result, token)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+func DeleteRenameCommandToken(defnId c.IndexDefnId) error {
+
+	id := fmt.Sprintf("%v", defnId)
+	return c.MetakvDel(RenameDDLCommandTokenPath + id)
+}
+
+//
+// Unmarshall
+//
+func UnmarshallRenameCommandToken(data []byte) (*RenameCommandToken, error) {
+
+	r := new(RenameCommandToken)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//
+// Marshall
+//
+func MarshallRenameCommandToken(r *RenameCommandToken) ([]byte, error) {
+
+	buf, err := json.Marshal(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+//////////////////////////////////////////////////////////////
+// Alter Token Management
+//////////////////////////////////////////////////////////////
+
+var ErrAlterIndexAborted = errors.New("Alter index has been aborted.")
+var ErrAlterIndexSwapped = errors.New("Alter index has already swapped in the altered index.")
+
+//
+// Generate a token to metakv before the shadow index is created.  It fails
+// if there is an alter index in progress for the same index.
+//
+func PostAlterCommandToken(token *AlterCommandToken) error {
+
+	buf, err := MarshallAlterCommandToken(token)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("%v", token.DefnId)
+	if err := metakv.Add(AlterDDLCommandTokenPath+id, buf); err != nil {
+		if err == metakv.ErrRevMismatch {
+			return errors.New(fmt.Sprintf("Alter index is already in progress for index %v.", token.Name))
+		}
+		return errors.New(fmt.Sprintf("Fail to alter index.  Internal Error = %v", err))
+	}
+
+	return nil
+}
+
+//
+// Fetch the alter token of an index along with its revision.
+// Return nil if token does not exist.
+//
+func FetchAlterCommandToken(defnId c.IndexDefnId) (*AlterCommandToken, interface{}, error) {
+
+	id := fmt.Sprintf("%v", defnId)
+	buf, rev, err := metakv.Get(AlterDDLCommandTokenPath + id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if buf == nil {
+		return nil, nil, nil
+	}
+
+	token, err := UnmarshallAlterCommandToken(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return token, rev, nil
+}
+
+//
+// Commit the swap of the shadow index.  Once committed, alter index can no
+// longer be aborted.  This function is idempotent.
+//
+func CommitAlterCommandToken(defnId c.IndexDefnId) error {
+
+	token, rev, err := FetchAlterCommandToken(defnId)
+	if err != nil {
+		return err
+	}
+
+	if token == nil {
+		return ErrAlterIndexAborted
+	}
+
+	if token.Swapped {
+		return nil
+	}
+
+	token.Swapped = true
+	buf, err := MarshallAlterCommandToken(token)
+	if err != nil {
+		return err
+	}
+
+	id := fmt.Sprintf("%v", defnId)
+	if err := metakv.Set(AlterDDLCommandTokenPath+id, buf, rev); err != nil {
+		if err == metakv.ErrRevMismatch {
+			return ErrAlterIndexAborted
+		}
+		return err
+	}
+
+	return nil
+}
+
+//
+// Abort alter index by deleting the token, unless the swap has been committed.
+// The shadow index is dropped by the janitor if it is not dropped by the caller.
+//
+func AbortAlterCommandToken(defnId c.IndexDefnId) error {
+
+	token, rev, err := FetchAlterCommandToken(defnId)
+	if err != nil {
+		return err
+	}
+
+	if token == nil {
+		return nil
+	}
+
+	if token.Swapped {
+		return ErrAlterIndexSwapped
+	}
+
+	id := fmt.Sprintf("%v", defnId)
+	if err := metakv.Delete(AlterDDLCommandTokenPath+id, rev); err != nil {
+		if err == metakv.ErrRevMismatch {
+			return ErrAlterIndexSwapped
+		}
+		return err
+	}
+
+	return nil
+}
+
+func DeleteAlterCommandToken(defnId c.IndexDefnId) error {
+
+	id := fmt.Sprintf("%v", defnId)
+	return c.MetakvDel(AlterDDLCommandTokenPath + id)
+}
+
+//
+// Return the list of alter token
+//
+func ListAlterCommandToken() ([]*AlterCommandToken, error) {
+
+	paths, err := c.MetakvList(AlterDDLCommandTokenPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*AlterCommandToken
+
+	if len(paths) != 0 {
+		result = make([]*AlterCommandToken, 0, len(paths))
+		for _, path := range paths {
+			token := &AlterCommandToken{}
+			exist, err := c.MetakvGet(path, token)
+			if err != nil {
+				return nil, err
+			}
+
+			if exist {
+				result = append(result, token)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+//
+// An alter token expires if the shadow index is not swapped in before the
+// timeout.
+//
+func (t *AlterCommandToken) Expired(now time.Time) bool {
+	return !t.Swapped && t.Expiry != 0 && now.UnixNano() > t.Expiry
+}
+
+//
+// Unmarshall
+//
+func UnmarshallAlterCommandToken(data []byte) (*AlterCommandToken, error) {
+
+	r := new(AlterCommandToken)
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+//
+// Marshall
+//
+func MarshallAlterCommandToken(r *AlterCommandToken) ([]byte, error) {
+
+	buf, err := json.Marshal(&r)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+//////////////////////////////////////////////////////////////
+// Index Alias Management
+//////////////////////////////////////////////////////////////
+
+func indexAliasTokenPath(bucket string, alias string) string {
+	return fmt.Sprintf("%v%v/%v", IndexAliasTokenPath, bucket, alias)
+}
+
+//
+// Create or retarget an index alias.
+//
+func PostIndexAliasToken(bucket string, alias string, defnId c.IndexDefnId) error {
+
+	token := &IndexAliasToken{
+		Bucket: bucket,
+		Alias:  alias,
+		DefnId: defnId,
+	}
+
+	if err := c.MetakvSet(indexAliasTokenPath(bucket, alias), token); err != nil {
+		return errors.New(fmt.Sprintf("Fail to set index alias.  Internal Error = %v", err))
+	}
+
+	return nil
+}
+
+//
+// Fetch an index alias.  Return nil if alias does not exist.
+//
+func FetchIndexAliasToken(bucket string, alias string) (*IndexAliasToken, error) {
+
+	token := &IndexAliasToken{}
+	exists, err := c.MetakvGet(indexAliasTokenPath(bucket, alias), token)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	return token, nil
+}
+
+func DeleteIndexAliasToken(bucket string, alias string) error {
+
+	if err := c.MetakvDel(indexAliasTokenPath(bucket, alias)); err != nil {
+		return errors.New(fmt.Sprintf("Fail to drop index alias.  Internal Error = %v", err))
+	}
+
+	return nil
+}
+
+//
+// Return the list of index alias of a bucket.  If bucket is empty,
+// return the index alias of all buckets.
+//
+func ListIndexAliasToken(bucket string) ([]*IndexAliasToken, error) {
+
+	dir := IndexAliasTokenPath
+	if len(bucket) != 0 {
+		dir = IndexAliasTokenPath + bucket + "/"
+	}
+
+	paths, err := c.MetakvList(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []*IndexAliasToken
+
+	if len(paths) != 0 {
+		result = make([]*IndexAliasToken, 0, len(paths))
+		for _, path := range paths {
+			token := &IndexAliasToken{}
+			exist, err := c.MetakvGet(path, token)
+			if err != nil {
+				return nil, err
+			}
+
+			if exist {
+				result = append(result, token)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+//////////////////////////////////////////////////////////////
+// IndexAliasListener
+//////////////////////////////////////////////////////////////
+
+func NewIndexAliasListener() *IndexAliasListener {
+
+	return &IndexAliasListener{
+		tokens:   make(map[string]*IndexAliasToken),
+		cancelCh: make(chan struct{}),
+	}
+}
+
+func (m *IndexAliasListener) Close() {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	select {
+	case <-m.cancelCh:
+	default:
+		close(m.cancelCh)
+	}
+}
+
+//
+// Replace the cache with the index alias read from metakv.
+//
+func (m *IndexAliasListener) Sync(tokens []*IndexAliasToken) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.tokens = make(map[string]*IndexAliasToken)
+	for _, token := range tokens {
+		m.tokens[indexAliasTokenPath(token.Bucket, token.Alias)] = token
+	}
+	m.synced = true
+}
+
+func (m *IndexAliasListener) AddIndexAliasToken(token *IndexAliasToken) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.tokens[indexAliasTokenPath(token.Bucket, token.Alias)] = token
+}
+
+func (m *IndexAliasListener) RemoveIndexAliasToken(bucket string, alias string) {
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	delete(m.tokens, indexAliasTokenPath(bucket, alias))
+}
+
+//
+// Get an index alias from cache.  Return false if the cache has not
+// been synchronized with metakv.
+//
+func (m *IndexAliasListener) GetIndexAliasToken(bucket string, alias string) (*IndexAliasToken, bool) {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if !m.synced {
+		return nil, false
+	}
+
+	return m.tokens[indexAliasTokenPath(bucket, alias)], true
+}
+
+//
+// Return the list of cached index alias of a bucket.  If bucket is empty,
+// return the index alias of all buckets.  Return false if the cache has not
+// been synchronized with metakv.
+//
+func (m *IndexAliasListener) ListIndexAliasToken(bucket string) ([]*IndexAliasToken, bool) {
+
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+
+	if !m.synced {
+		return nil, false
+	}
+
+	result := make([]*IndexAliasToken, 0, len(m.tokens))
+	for _, token := range m.tokens {
+		if len(bucket) == 0 || token.Bucket == bucket {
+			result = append(result, token)
+		}
+	}
+
+	return result, true
+}
+
+//
+// Populate the cache from metakv, and then keep it up-to-date by observing
+// changes of the index alias.  The cache is populated again whenever the
+// metakv notifier is restarted.
+//
+func (m *IndexAliasListener) ListenTokens() {
+
+	metaKVCallback := func(path string, value []byte, rev interface{}) error {
+		if !strings.HasPrefix(path, IndexAliasTokenPath) {
+			return nil
+		}
+
+		if value == nil {
+			m.mutex.Lock()
+			delete(m.tokens, path)
+			m.mutex.Unlock()
+			return nil
+		}
+
+		token := &IndexAliasToken{}
+		if err := json.Unmarshal(value, token); err != nil {
+			logging.Warnf("IndexAliasListener: Failed to unmarshall index alias %v.  Internal Error = %v", path, err)
+			return nil
+		}
+
+		m.mutex.Lock()
+		m.tokens[path] = token
+		m.mutex.Unlock()
+
+		return nil
+	}
+
+	go func() {
+		fn := func(r int, err error) error {
+			if r > 0 {
+				logging.Errorf("IndexAliasListener: metakv notifier failed (%v)..Restarting %v", err, r)
+			}
+
+			tokens, err := ListIndexAliasToken("")
+			if err != nil {
+				return err
+			}
+			m.Sync(tokens)
+
+			err = metakv.RunObserveChildren(IndexAliasTokenPath, metaKVCallback, m.cancelCh)
+			return err
+		}
+
+		rh := c.NewRetryHelper(200, time.Second, 2, fn)
+		err := rh.Run()
+		if err != nil {
+			logging.Errorf("IndexAliasListener: metakv notifier failed even after max retries.")
+		}
+	}()
+}
+
 //////////////////////////////////////////////////////////////
 // Version Management
 //////////////////////////////////////////////////////////////
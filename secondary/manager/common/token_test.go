@@ -0,0 +1,75 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAlterCommandTokenExpired(t *testing.T) {
+
+	now := time.Now()
+
+	testcases := []struct {
+		comment string
+		token   AlterCommandToken
+		expired bool
+	}{
+		{"no timeout", AlterCommandToken{}, false},
+		{"not expired", AlterCommandToken{Expiry: now.Add(time.Minute).UnixNano()}, false},
+		{"expired", AlterCommandToken{Expiry: now.Add(-time.Minute).UnixNano()}, true},
+		{"swapped", AlterCommandToken{Expiry: now.Add(-time.Minute).UnixNano(), Swapped: true}, false},
+	}
+
+	for _, tc := range testcases {
+		if expired := tc.token.Expired(now); expired != tc.expired {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expired, expired)
+		}
+	}
+}
+
+func TestIndexAliasListener(t *testing.T) {
+
+	listener := NewIndexAliasListener()
+	defer listener.Close()
+
+	if _, ok := listener.GetIndexAliasToken("b1", "a1"); ok {
+		t.Errorf("expected cache not to be available before sync")
+	}
+
+	listener.Sync([]*IndexAliasToken{
+		{Bucket: "b1", Alias: "a1", DefnId: 1},
+		{Bucket: "b2", Alias: "a1", DefnId: 2},
+	})
+
+	if token, ok := listener.GetIndexAliasToken("b1", "a1"); !ok || token == nil || token.DefnId != 1 {
+		t.Errorf("expected alias (b1, a1) to resolve to 1, got %v", token)
+	}
+
+	if token, ok := listener.GetIndexAliasToken("b1", "a2"); !ok || token != nil {
+		t.Errorf("expected alias (b1, a2) not to exist, got %v", token)
+	}
+
+	listener.AddIndexAliasToken(&IndexAliasToken{Bucket: "b1", Alias: "a1", DefnId: 3})
+	if token, _ := listener.GetIndexAliasToken("b1", "a1"); token == nil || token.DefnId != 3 {
+		t.Errorf("expected alias (b1, a1) to be retargeted to 3, got %v", token)
+	}
+
+	if tokens, ok := listener.ListIndexAliasToken("b2"); !ok || len(tokens) != 1 || tokens[0].DefnId != 2 {
+		t.Errorf("expected one alias in bucket b2, got %v", tokens)
+	}
+
+	if tokens, _ := listener.ListIndexAliasToken(""); len(tokens) != 2 {
+		t.Errorf("expected two alias, got %v", tokens)
+	}
+
+	listener.RemoveIndexAliasToken("b1", "a1")
+	if token, _ := listener.GetIndexAliasToken("b1", "a1"); token != nil {
+		t.Errorf("expected alias (b1, a1) to be dropped, got %v", token)
+	}
+
+	// sync replaces the cache
+	listener.Sync(nil)
+	if tokens, _ := listener.ListIndexAliasToken(""); len(tokens) != 0 {
+		t.Errorf("expected no alias after sync, got %v", tokens)
+	}
+}
@@ -59,6 +59,12 @@ const COORDINATOR_CONFIG_STORE = "IndexCoordinatorConfigStore"
 const DEFAULT_EVT_QUEUE_SIZE = 20
 const DEFAULT_NOTIFIER_QUEUE_SIZE = 5
 
+// Metadata Feed
+const METADATA_FEED_LISTENER = "metadataFeed"
+const METADATA_FEED_LOG_SIZE = 1000
+
+var METADATA_FEED_HEARTBEAT = time.Duration(5000) * time.Millisecond
+
 // Stream Manager
 const MAINT_TOPIC = "MAINT_STREAM_TOPIC"
 const CATCHUP_TOPIC = "CATCHUP_STREAM_TOPIC"
@@ -94,6 +94,13 @@ type builder struct {
 	batchSize int32
 	disable   int32
 
+	// build queue
+	queueEnable  int32
+	maxPerNode   int32
+	maxPerBucket int32
+	windows      atomic.Value
+	avgBuildTime int64
+
 	commandListener *mc.CommandListener
 	listenerDonech  chan bool
 }
@@ -104,6 +111,8 @@ type janitor struct {
 	commandListener *mc.CommandListener
 	listenerDonech  chan bool
 	runch           chan bool
+
+	orphanShadows map[common.IndexDefnId]bool
 }
 
 type updator struct {
@@ -329,7 +338,11 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 	case client.OPCODE_DROP_INDEX:
 		err = m.handleDeleteIndex(key, common.NewUserRequestContext())
 	case client.OPCODE_BUILD_INDEX:
-		err = m.handleBuildIndexes(content, common.NewUserRequestContext(), true)
+		if m.builder.queueEnabled() {
+			err = m.handleQueueBuildIndexes(content)
+		} else {
+			err = m.handleBuildIndexes(content, common.NewUserRequestContext(), true)
+		}
 	case client.OPCODE_SERVICE_MAP:
 		result, err = m.handleServiceMap(content)
 	case client.OPCODE_DELETE_BUCKET:
@@ -376,6 +389,10 @@ func (m *LifecycleMgr) dispatchRequest(request *requestHolder, factory *message.
 		result, err = m.handleGetIndexReplicaCount(content)
 	case client.OPCODE_CHECK_TOKEN_EXIST:
 		result, err = m.handleCheckTokenExist(content)
+	case client.OPCODE_SWAP_SHADOW_INDEX:
+		err = m.handleSwapShadowIndex(content, common.NewUserRequestContext())
+	case client.OPCODE_RENAME_INDEX:
+		err = m.handleRenameIndex(content, common.NewUserRequestContext())
 	}
 
 	logging.Debugf("LifecycleMgr.dispatchRequest () : send response for requestId %d, op %d, len(result) %d", reqId, op, len(result))
@@ -1466,6 +1483,74 @@ func (m *LifecycleMgr) handleBuildIndexes(content []byte, reqCtx *common.Metadat
 	return nil
 }
 
+//
+// Add index to the build queue of this node.  The builder will build the index in
+// queue order.
+//
+func (m *LifecycleMgr) handleQueueBuildIndexes(content []byte) error {
+
+	list, err := client.UnmarshallIndexIdList(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleQueueBuildIndexes() : buildIndex fails. Unable to unmarshall index list. Reason = %v", err)
+		return err
+	}
+
+	indexerId, err := m.repo.GetLocalIndexerId()
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleQueueBuildIndexes() : buildIndex fails. Unable to find indexerId. Reason = %v", err)
+		return err
+	}
+
+	for _, id := range list.DefnIds {
+
+		defn, err := m.repo.GetIndexDefnById(common.IndexDefnId(id))
+		if err != nil || defn == nil {
+			logging.Warnf("LifecycleMgr.handleQueueBuildIndexes() : index %v does not exist. Skip this index.", id)
+			continue
+		}
+
+		insts, err := m.FindAllLocalIndexInst(defn.Bucket, defn.DefnId)
+		if len(insts) == 0 || err != nil {
+			logging.Warnf("LifecycleMgr.handleQueueBuildIndexes: Failed to find index instance (%v, %v).  Skip this index.", defn.Name, defn.Bucket)
+			continue
+		}
+
+		queued := false
+		for _, inst := range insts {
+
+			if inst.State != uint32(common.INDEX_STATE_READY) {
+				continue
+			}
+
+			// Schedule the build so that it can be recovered upon restart
+			if err := m.SetScheduledFlag(defn.Bucket, defn.DefnId, common.IndexInstId(inst.InstId), true); err != nil {
+				logging.Warnf("LifecycleMgr.handleQueueBuildIndexes: Unable to set scheduled flag in index instance (%v, %v).", defn.Name, defn.Bucket)
+			}
+
+			// Reset any previous error
+			m.UpdateIndexInstance(defn.Bucket, defn.DefnId, common.IndexInstId(inst.InstId), common.INDEX_STATE_NIL, common.NIL_STREAM, "", nil,
+				inst.RState, nil, nil, -1)
+
+			queued = true
+		}
+
+		if !queued {
+			continue
+		}
+
+		if _, err := mc.AddBuildQueueEntry(indexerId, defn.DefnId, defn.Bucket, defn.Name); err != nil {
+			logging.Errorf("LifecycleMgr.handleQueueBuildIndexes: Unable to add index (%v, %v) to build queue.  Error = %v.",
+				defn.Name, defn.Bucket, err)
+			return err
+		}
+
+		logging.Infof("LifecycleMgr.handleQueueBuildIndexes: Queue index (%v, %v) for build.", defn.Name, defn.Bucket)
+		m.builder.notifych <- defn
+	}
+
+	return nil
+}
+
 func (m *LifecycleMgr) BuildIndexes(ids []common.IndexDefnId,
 	reqCtx *common.MetadataRequestContext, retry bool) ([]error, []common.IndexDefnId, []error) {
 
@@ -2587,6 +2672,193 @@ func (m *LifecycleMgr) MergePartition(id common.IndexDefnId, srcInstId common.In
 	return nil
 }
 
+//-----------------------------------------------------------
+// Swap Shadow Index
+//-----------------------------------------------------------
+
+func (m *LifecycleMgr) handleSwapShadowIndex(content []byte, reqCtx *common.MetadataRequestContext) error {
+
+	swap, err := client.UnmarshallSwapShadowIndex(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleSwapShadowIndex() : Unable to unmarshall request. Reason = %v", err)
+		return err
+	}
+
+	return m.SwapShadowIndex(swap.ShadowDefnId, swap.DefnId, swap.Name, reqCtx)
+}
+
+//
+// Swap the shadow index into the name of the index being altered.  Once swapped,
+// the shadow index is visible to query and the old index can be dropped.  The
+// swap must have been committed in the alter token, so that the shadow index is
+// either swapped on every indexer or none.  This function is idempotent.
+//
+func (m *LifecycleMgr) SwapShadowIndex(shadowId common.IndexDefnId, id common.IndexDefnId, name string,
+	reqCtx *common.MetadataRequestContext) error {
+
+	logging.Infof("LifecycleMgr.SwapShadowIndex() : shadow index defnId %v index defnId %v name %v", shadowId, id, name)
+
+	defn, err := m.repo.GetIndexDefnById(shadowId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.SwapShadowIndex() : swap fails for index defn %v.  Error = %v.", shadowId, err)
+		return err
+	}
+	if defn == nil {
+		// shadow index does not reside on this node
+		logging.Infof("LifecycleMgr.SwapShadowIndex() : index %v does not exist.", shadowId)
+		return nil
+	}
+
+	if defn.ShadowOf == 0 {
+		logging.Infof("LifecycleMgr.SwapShadowIndex() : index %v has already been swapped.", shadowId)
+		return nil
+	}
+
+	if defn.ShadowOf != id {
+		return fmt.Errorf("Index %v is not a shadow of index %v.", shadowId, id)
+	}
+
+	// The shadow index has caught up on every indexer before the swap is committed.
+	token, _, err := mc.FetchAlterCommandToken(id)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.SwapShadowIndex() : swap fails for index defn %v.  Error = %v.", shadowId, err)
+		return err
+	}
+
+	if token == nil || token.ShadowDefnId != shadowId {
+		return fmt.Errorf("Index %v cannot be swapped.  %v", shadowId, mc.ErrAlterIndexAborted)
+	}
+
+	if !token.Swapped {
+		return fmt.Errorf("Index %v cannot be swapped.  Alter index has not been committed.", shadowId)
+	}
+
+	insts, err := m.FindAllLocalIndexInst(defn.Bucket, shadowId)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.SwapShadowIndex() : swap fails for index defn %v.  Error = %v.", shadowId, err)
+		return err
+	}
+
+	instIds := make([]common.IndexInstId, 0, len(insts))
+	for _, inst := range insts {
+		if common.IndexState(inst.State) != common.INDEX_STATE_DELETED {
+			instIds = append(instIds, common.IndexInstId(inst.InstId))
+		}
+	}
+
+	newDefn := *defn
+	newDefn.Name = token.Name
+	newDefn.ShadowOf = 0
+	newDefn.Replaces = id
+	if err := m.repo.UpdateIndex(&newDefn); err != nil {
+		logging.Errorf("LifecycleMgr.SwapShadowIndex() : swap fails for index defn %v.  Error = %v.", shadowId, err)
+		return err
+	}
+
+	// If indexer fails to pick up the new name, it will do so during bootstrap.
+	if m.notifier != nil && len(instIds) != 0 {
+		if err := m.notifier.OnIndexRename(instIds, token.Name, reqCtx); err != nil {
+			logging.Warnf("LifecycleMgr.SwapShadowIndex() : fail to notify indexer for index defn %v.  Error = %v.", shadowId, err)
+		}
+	}
+
+	return nil
+}
+
+//-----------------------------------------------------------
+// Rename Index
+//-----------------------------------------------------------
+
+func (m *LifecycleMgr) handleRenameIndex(content []byte, reqCtx *common.MetadataRequestContext) error {
+
+	rename, err := client.UnmarshallRenameIndex(content)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.handleRenameIndex() : Unable to unmarshall request. Reason = %v", err)
+		return err
+	}
+
+	return m.RenameIndex(rename.DefnId, rename.Name, reqCtx)
+}
+
+//
+// Rename an index.  The index definition id does not change, so the index
+// instances and their data are not affected.  This function is idempotent.
+//
+func (m *LifecycleMgr) RenameIndex(id common.IndexDefnId, name string, reqCtx *common.MetadataRequestContext) error {
+
+	logging.Infof("LifecycleMgr.RenameIndex() : index defnId %v name %v", id, name)
+
+	defn, err := m.repo.GetIndexDefnById(id)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.RenameIndex() : rename fails for index defn %v.  Error = %v.", id, err)
+		return err
+	}
+	if defn == nil {
+		// index does not reside on this node
+		logging.Infof("LifecycleMgr.RenameIndex() : index %v does not exist.", id)
+		return nil
+	}
+
+	if defn.Name == name {
+		logging.Infof("LifecycleMgr.RenameIndex() : index %v has already been renamed.", id)
+		return nil
+	}
+
+	if defn.IsShadow() {
+		return fmt.Errorf("Index %v is being altered.  Cannot rename index.", id)
+	}
+
+	// Make sure the name is not taken by another index on this node
+	existDefn, err := m.repo.GetIndexDefnByName(defn.Bucket, name)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.RenameIndex() : rename fails for index defn %v.  Error = %v.", id, err)
+		return err
+	}
+
+	if existDefn != nil && existDefn.DefnId != id {
+		insts, err := m.FindAllLocalIndexInst(existDefn.Bucket, existDefn.DefnId)
+		if err != nil {
+			logging.Errorf("LifecycleMgr.RenameIndex() : rename fails for index defn %v.  Error = %v.", id, err)
+			return err
+		}
+
+		for _, inst := range insts {
+			if common.IndexState(inst.State) != common.INDEX_STATE_DELETED {
+				return fmt.Errorf("Index %v already exists.", name)
+			}
+		}
+	}
+
+	insts, err := m.FindAllLocalIndexInst(defn.Bucket, id)
+	if err != nil {
+		logging.Errorf("LifecycleMgr.RenameIndex() : rename fails for index defn %v.  Error = %v.", id, err)
+		return err
+	}
+
+	instIds := make([]common.IndexInstId, 0, len(insts))
+	for _, inst := range insts {
+		if common.IndexState(inst.State) != common.INDEX_STATE_DELETED {
+			instIds = append(instIds, common.IndexInstId(inst.InstId))
+		}
+	}
+
+	newDefn := *defn
+	newDefn.Name = name
+	if err := m.repo.UpdateIndex(&newDefn); err != nil {
+		logging.Errorf("LifecycleMgr.RenameIndex() : rename fails for index defn %v.  Error = %v.", id, err)
+		return err
+	}
+
+	// If indexer fails to pick up the new name, it will do so during bootstrap.
+	if m.notifier != nil && len(instIds) != 0 {
+		if err := m.notifier.OnIndexRename(instIds, name, reqCtx); err != nil {
+			logging.Warnf("LifecycleMgr.RenameIndex() : fail to notify indexer for index defn %v.  Error = %v.", id, err)
+		}
+	}
+
+	return nil
+}
+
 //-----------------------------------------------------------
 // Prune Partition
 //-----------------------------------------------------------
@@ -3230,6 +3502,45 @@ func (m *janitor) cleanup() {
 		m.commandListener.AddNewDropInstanceToken(path, token)
 	}
 
+	//
+	// Cleanup based on rename token
+	//
+	renameTokens, err := mc.ListRenameCommandToken()
+	if err != nil {
+		logging.Warnf("janitor: Failed to list rename token during cleanup.  Internal Error = %v.", err)
+	}
+
+	for _, command := range renameTokens {
+
+		defn, err := m.manager.repo.GetIndexDefnById(command.DefnId)
+		if err != nil {
+			logging.Warnf("janitor: Failed to rename index upon cleanup.  Skip index %v.  Internal Error = %v.", command.DefnId, err)
+			continue
+		}
+
+		// index may already be deleted or does not exist in this node
+		if defn == nil || defn.Name == command.Name {
+			continue
+		}
+
+		content, err := client.MarshallRenameIndex(&client.RenameIndex{DefnId: command.DefnId, Name: command.Name})
+		if err != nil {
+			logging.Warnf("janitor: Failed to rename index upon cleanup.  Skip index %v.  Internal Error = %v.", command.DefnId, err)
+			continue
+		}
+
+		if err := m.manager.requestServer.MakeRequest(client.OPCODE_RENAME_INDEX, fmt.Sprintf("%v", command.DefnId), content); err != nil {
+			logging.Warnf("janitor: Failed to rename index upon cleanup.  Skip index %v.  Internal Error = %v.", command.DefnId, err)
+		} else {
+			logging.Infof("janitor: Rename index %v to %v during periodic cleanup ", command.DefnId, command.Name)
+		}
+	}
+
+	//
+	// Cleanup based on alter token
+	//
+	m.cleanupAlterIndex()
+
 	//
 	// Cleanup based on index status (DELETED index)
 	//
@@ -3281,6 +3592,126 @@ func (m *janitor) cleanup() {
 	}
 }
 
+//
+// Complete or abort alter index based on the alter token:
+// 1) An alter token that has not been swapped before the timeout is aborted.
+// 2) A shadow index without alter token is dropped.  The shadow index must be
+//    orphaned in two consecutive cleanups, since the token may not have been
+//    propagated to this node when the shadow index is created.
+// 3) For a swapped alter token, the shadow index is swapped in and the old index
+//    is dropped.
+//
+// The shadow indexes are collected before listing the alter tokens.  Since the
+// token is posted before the shadow index is created, a shadow index found here
+// must have its token listed unless alter index has been aborted.
+//
+func (m *janitor) cleanupAlterIndex() {
+
+	metaIter, err := m.manager.repo.NewIterator()
+	if err != nil {
+		logging.Warnf("janitor: Failed to instantiate metadata iterator during alter index cleanup.  Internal Error = %v", err)
+		return
+	}
+
+	shadows := make(map[common.IndexDefnId]*common.IndexDefn)
+	for _, defn, err := metaIter.Next(); err == nil; _, defn, err = metaIter.Next() {
+		if defn.IsShadow() {
+			shadows[defn.DefnId] = defn
+		}
+	}
+	metaIter.Close()
+
+	tokens, err := mc.ListAlterCommandToken()
+	if err != nil {
+		logging.Warnf("janitor: Failed to list alter token during cleanup.  Internal Error = %v.", err)
+		return
+	}
+
+	now := time.Now()
+	altered := make(map[common.IndexDefnId]bool)
+
+	for _, token := range tokens {
+
+		if token.Expired(now) {
+			if err := mc.AbortAlterCommandToken(token.DefnId); err != nil {
+				// The token may have been committed concurrently.  Retry on next cleanup.
+				logging.Warnf("janitor: Failed to abort alter index %v upon timeout.  Internal Error = %v.", token.DefnId, err)
+				altered[token.ShadowDefnId] = true
+			} else {
+				logging.Infof("janitor: Abort alter index %v upon timeout.", token.DefnId)
+			}
+			continue
+		}
+
+		altered[token.ShadowDefnId] = true
+
+		if !token.Swapped {
+			continue
+		}
+
+		if shadow, ok := shadows[token.ShadowDefnId]; ok && shadow.ShadowOf == token.DefnId {
+			swap := &client.SwapShadowIndex{DefnId: token.DefnId, ShadowDefnId: token.ShadowDefnId, Name: token.Name}
+			content, err := client.MarshallSwapShadowIndex(swap)
+			if err != nil {
+				logging.Warnf("janitor: Failed to swap shadow index upon cleanup.  Skip index %v.  Internal Error = %v.", token.ShadowDefnId, err)
+				continue
+			}
+
+			if err := m.manager.requestServer.MakeRequest(client.OPCODE_SWAP_SHADOW_INDEX, fmt.Sprintf("%v", token.ShadowDefnId), content); err != nil {
+				logging.Warnf("janitor: Failed to swap shadow index upon cleanup.  Skip index %v.  Internal Error = %v.", token.ShadowDefnId, err)
+				continue
+			}
+			logging.Infof("janitor: Swap shadow index %v into index %v during periodic cleanup ", token.ShadowDefnId, token.DefnId)
+		}
+
+		defn, err := m.manager.repo.GetIndexDefnById(token.DefnId)
+		if err != nil {
+			logging.Warnf("janitor: Failed to drop altered index upon cleanup.  Skip index %v.  Internal Error = %v.", token.DefnId, err)
+			continue
+		}
+
+		// old index may already be deleted or does not exist in this node
+		if defn == nil {
+			continue
+		}
+
+		if exist, err := mc.DeleteCommandTokenExist(token.DefnId); err == nil && !exist {
+			if err := mc.PostDeleteCommandToken(token.DefnId); err != nil {
+				logging.Warnf("janitor: Failed to drop altered index upon cleanup.  Skip index %v.  Internal Error = %v.", token.DefnId, err)
+				continue
+			}
+		}
+
+		if err := m.manager.requestServer.MakeRequest(client.OPCODE_DROP_INDEX, fmt.Sprintf("%v", token.DefnId), nil); err != nil {
+			logging.Warnf("janitor: Failed to drop altered index upon cleanup.  Skip index %v.  Internal Error = %v.", token.DefnId, err)
+		} else {
+			logging.Infof("janitor: Clean up altered index %v during periodic cleanup ", token.DefnId)
+		}
+	}
+
+	orphans := make(map[common.IndexDefnId]bool)
+	for id, shadow := range shadows {
+
+		if altered[id] {
+			continue
+		}
+
+		if !m.orphanShadows[id] {
+			orphans[id] = true
+			continue
+		}
+
+		if err := m.manager.requestServer.MakeRequest(client.OPCODE_DROP_INDEX, fmt.Sprintf("%v", id), nil); err != nil {
+			orphans[id] = true
+			logging.Warnf("janitor: Failed to drop shadow index upon cleanup.  Skip index %v.  Internal Error = %v.", id, err)
+		} else {
+			logging.Infof("janitor: Clean up shadow index (%v, %v, %v) of aborted alter index during periodic cleanup ",
+				shadow.Bucket, shadow.Name, id)
+		}
+	}
+	m.orphanShadows = orphans
+}
+
 func (m *janitor) run() {
 
 	// start listener
@@ -3329,6 +3760,7 @@ func newJanitor(mgr *LifecycleMgr) *janitor {
 		commandListener: mc.NewCommandListener(donech, false, false, true, true),
 		listenerDonech:  donech,
 		runch:           make(chan bool),
+		orphanShadows:   make(map[common.IndexDefnId]bool),
 	}
 
 	return janitor
@@ -3368,10 +3800,14 @@ func (s *builder) run() {
 			// Otherwise, rebalancer could fail if builder has issued an index build ahead of the rebalancer.
 			time.Sleep(time.Second * 120)
 
-			buildList, quota := s.getBuildList()
+			if s.queueEnabled() {
+				s.processBuildQueue()
+			} else {
+				buildList, quota := s.getBuildList()
 
-			for _, bucket := range buildList {
-				quota = s.tryBuildIndex(bucket, quota)
+				for _, bucket := range buildList {
+					quota = s.tryBuildIndex(bucket, quota)
+				}
 			}
 
 		case <-s.manager.killch:
@@ -3548,7 +3984,11 @@ func (s *builder) tryBuildIndex(bucket string, quota int32) int32 {
 
 func (s *builder) getQuota() (int32, map[string]bool) {
 
-	quota := atomic.LoadInt32(&s.batchSize)
+	return s.computeQuota(atomic.LoadInt32(&s.batchSize))
+}
+
+func (s *builder) computeQuota(quota int32) (int32, map[string]bool) {
+
 	skipList := make(map[string]bool)
 
 	metaIter, err := s.manager.repo.NewIterator()
@@ -3626,6 +4066,15 @@ func (s *builder) recover() {
 
 	logging.Infof("builder: recovering scheduled index")
 
+	//
+	// Recover build queue statistics
+	//
+	if indexerId, err := s.manager.repo.GetLocalIndexerId(); err == nil {
+		if status, err := mc.FetchBuildQueueStatus(indexerId); err == nil && status != nil {
+			atomic.StoreInt64(&s.avgBuildTime, status.AvgBuildTime)
+		}
+	}
+
 	//
 	// Cleanup based on build token
 	//
@@ -3671,6 +4120,325 @@ func (s *builder) configUpdate(config *common.Config) {
 	} else {
 		atomic.StoreInt32(&s.disable, int32(0))
 	}
+
+	s.updateQueueSettings((*config)["build.queue.enable"].Bool(),
+		(*config)["build.queue.window"].String(),
+		(*config)["build.queue.maxConcurrentPerNode"].Int(),
+		(*config)["build.queue.maxConcurrentPerBucket"].Int())
+}
+
+func (s *builder) updateQueueSettings(enable bool, window string, maxPerNode int, maxPerBucket int) {
+
+	if enable {
+		atomic.StoreInt32(&s.queueEnable, int32(1))
+	} else {
+		atomic.StoreInt32(&s.queueEnable, int32(0))
+	}
+
+	atomic.StoreInt32(&s.maxPerNode, int32(maxPerNode))
+	atomic.StoreInt32(&s.maxPerBucket, int32(maxPerBucket))
+
+	windows, err := mc.ParseBuildWindows(window)
+	if err != nil {
+		logging.Errorf("builder: Invalid build window setting %v.  Keep current build window.  Error = %v", window, err)
+		return
+	}
+	s.windows.Store(windows)
+}
+
+func (s *builder) queueEnabled() bool {
+
+	return atomic.LoadInt32(&s.queueEnable) == 1
+}
+
+func (s *builder) buildWindows() []mc.BuildWindow {
+
+	windows, _ := s.windows.Load().([]mc.BuildWindow)
+	return windows
+}
+
+func (s *builder) maxConcurrentBuild() int32 {
+
+	if max := atomic.LoadInt32(&s.maxPerNode); max > 0 {
+		return max
+	}
+
+	return atomic.LoadInt32(&s.batchSize)
+}
+
+//
+// Build index in the order of the build queue.  An index is added to the build queue of
+// this node when it is scheduled for build.  Since the build queue is kept in metakv,
+// the build order (and priority) survives indexer restart.   Index build only starts
+// within the build window, and it is subject to the per-node and per-bucket limit.
+//
+func (s *builder) processBuildQueue() {
+
+	indexerId, err := s.manager.repo.GetLocalIndexerId()
+	if err != nil {
+		logging.Warnf("builder: Failed to find indexerId.  Skip processing build queue.  Error = %v", err)
+		return
+	}
+
+	entries, err := mc.ListBuildQueueEntry(indexerId)
+	if err != nil {
+		logging.Warnf("builder: Failed to read build queue.  Skip processing build queue.  Error = %v", err)
+		return
+	}
+
+	now := time.Now()
+	queue := make([]*mc.BuildQueueEntry, 0, len(entries))
+	inQueue := make(map[common.IndexDefnId]bool)
+
+	for _, entry := range entries {
+
+		defn, err := s.manager.repo.GetIndexDefnById(entry.DefnId)
+		if err != nil {
+			logging.Warnf("builder: Failed to find index definition %v in build queue.  Skipping.  Error = %v", entry.DefnId, err)
+			continue
+		}
+
+		// index has been dropped
+		if defn == nil {
+			s.removeBuildQueueEntry(entry)
+			continue
+		}
+
+		insts, err := s.manager.FindAllLocalIndexInst(defn.Bucket, defn.DefnId)
+		if err != nil {
+			logging.Warnf("builder: Failed to find index instance (%v, %v) in build queue.  Skipping.  Error = %v", defn.Bucket, defn.Name, err)
+			continue
+		}
+
+		if entry.Cancelled {
+			for _, inst := range insts {
+				if inst.Scheduled && inst.State == uint32(common.INDEX_STATE_READY) {
+					if err := s.manager.SetScheduledFlag(defn.Bucket, defn.DefnId, common.IndexInstId(inst.InstId), false); err != nil {
+						logging.Warnf("builder: Failed to unschedule index (%v, %v, %v).  Error = %v", defn.Bucket, defn.Name, inst.ReplicaId, err)
+					}
+				}
+			}
+
+			logging.Infof("builder: Index build (%v, %v) is cancelled.", defn.Bucket, defn.Name)
+			s.removeBuildQueueEntry(entry)
+			continue
+		}
+
+		numReady := 0
+		numBuilding := 0
+		numActive := 0
+		for _, inst := range insts {
+			switch common.IndexState(inst.State) {
+			case common.INDEX_STATE_READY:
+				numReady++
+			case common.INDEX_STATE_INITIAL, common.INDEX_STATE_CATCHUP:
+				numBuilding++
+			case common.INDEX_STATE_ACTIVE:
+				numActive++
+			}
+		}
+
+		// index has been built
+		if numReady == 0 && numBuilding == 0 {
+			if numActive != 0 && entry.StartTime != 0 {
+				s.updateAvgBuildTime(now.Sub(time.Unix(0, entry.StartTime)))
+			}
+			s.removeBuildQueueEntry(entry)
+			continue
+		}
+
+		if numBuilding != 0 && entry.StartTime == 0 {
+			// index build is started outside of the build queue
+			entry = s.setBuildQueueStartTime(entry, now.UnixNano())
+
+		} else if numBuilding == 0 && entry.StartTime != 0 {
+			// index build has failed or rejected by indexer.  Put it back to the queue.
+			entry = s.setBuildQueueStartTime(entry, 0)
+		}
+
+		queue = append(queue, entry)
+		inQueue[entry.DefnId] = true
+	}
+
+	// add scheduled index to the build queue
+	for bucket, defnIds := range s.pendings {
+		for _, defnId := range defnIds {
+			if inQueue[common.IndexDefnId(defnId)] {
+				continue
+			}
+
+			defn, err := s.manager.repo.GetIndexDefnById(common.IndexDefnId(defnId))
+			if err != nil || defn == nil {
+				continue
+			}
+
+			if _, err := mc.AddBuildQueueEntry(indexerId, defn.DefnId, bucket, defn.Name); err != nil {
+				logging.Warnf("builder: Failed to add index (%v, %v) to build queue.  Error = %v", bucket, defn.Name, err)
+				continue
+			}
+
+			if entry, err := mc.FetchBuildQueueEntry(indexerId, defn.DefnId); err == nil && entry != nil {
+				queue = append(queue, entry)
+				inQueue[entry.DefnId] = true
+			}
+		}
+	}
+
+	mc.SortBuildQueue(queue)
+
+	limit := s.maxConcurrentBuild()
+	windows := s.buildWindows()
+
+	s.publishBuildQueueStatus(indexerId, queue, limit, windows, now)
+
+	if !mc.InBuildWindow(windows, now) {
+		logging.Debugf("builder: Outside of build window.  Skip building index from build queue.")
+		return
+	}
+
+	if s.disableBuild() {
+		logging.Warnf("builder: Background build is disabled.  Skip building index from build queue.")
+		return
+	}
+
+	quota, skipList := s.computeQuota(limit)
+	maxPerBucket := int(atomic.LoadInt32(&s.maxPerBucket))
+
+	buckets := ([]string)(nil)
+	buildMap := make(map[string][]*mc.BuildQueueEntry)
+
+	for _, entry := range queue {
+
+		if quota == 0 {
+			break
+		}
+
+		if entry.StartTime != 0 {
+			continue
+		}
+
+		// The indexer can only build one batch of index for a bucket at a time.
+		if _, ok := skipList[entry.Bucket]; ok {
+			continue
+		}
+
+		if maxPerBucket > 0 && len(buildMap[entry.Bucket]) >= maxPerBucket {
+			continue
+		}
+
+		if _, ok := buildMap[entry.Bucket]; !ok {
+			buckets = append(buckets, entry.Bucket)
+		}
+		buildMap[entry.Bucket] = append(buildMap[entry.Bucket], entry)
+		quota = quota - 1
+	}
+
+	for _, bucket := range buckets {
+
+		buildList := make([]uint64, 0, len(buildMap[bucket]))
+		for _, entry := range buildMap[bucket] {
+			buildList = append(buildList, uint64(entry.DefnId))
+		}
+
+		idList := &client.IndexIdList{DefnIds: buildList}
+		key := fmt.Sprintf("%d", idList.DefnIds[0])
+		content, err := client.MarshallIndexIdList(idList)
+		if err != nil {
+			logging.Warnf("builder: Failed to marshall index defnIds during index build.  Error = %v. Retry later.", err)
+			continue
+		}
+
+		logging.Infof("builder: Try build index from build queue for bucket %v. Index %v", bucket, idList)
+
+		if err := s.manager.requestServer.MakeRequest(client.OPCODE_BUILD_INDEX_RETRY, key, content); err != nil {
+			logging.Warnf("builder: Failed to build index.  Error = %v.", err)
+			continue
+		}
+
+		for _, entry := range buildMap[bucket] {
+			s.setBuildQueueStartTime(entry, time.Now().UnixNano())
+			s.removePending(bucket, uint64(entry.DefnId))
+		}
+	}
+}
+
+//
+// Set the start time of a build queue entry.  The entry is not updated if it
+// has been cancelled concurrently.  The cancelled entry is processed in the
+// next round.
+//
+func (s *builder) setBuildQueueStartTime(entry *mc.BuildQueueEntry, startTime int64) *mc.BuildQueueEntry {
+
+	updated, err := mc.UpdateBuildQueueEntry(entry.IndexerId, entry.DefnId, func(current *mc.BuildQueueEntry) bool {
+		if current.Cancelled {
+			return false
+		}
+		current.StartTime = startTime
+		return true
+	})
+
+	if err != nil {
+		logging.Warnf("builder: Failed to update build queue for index %v.  Error = %v.", entry.DefnId, err)
+		return entry
+	}
+
+	if updated == nil {
+		return entry
+	}
+
+	return updated
+}
+
+func (s *builder) removeBuildQueueEntry(entry *mc.BuildQueueEntry) {
+
+	s.removePending(entry.Bucket, uint64(entry.DefnId))
+
+	if err := mc.DeleteBuildQueueEntry(entry.IndexerId, entry.DefnId); err != nil {
+		logging.Warnf("builder: Failed to remove index %v from build queue.  Error = %v.", entry.DefnId, err)
+	}
+}
+
+func (s *builder) removePending(bucket string, id uint64) {
+
+	pendings := s.pendings[bucket]
+	for i, id2 := range pendings {
+		if id2 == id {
+			s.pendings[bucket] = append(pendings[:i], pendings[i+1:]...)
+			break
+		}
+	}
+
+	if len(s.pendings[bucket]) == 0 {
+		delete(s.pendings, bucket)
+	}
+}
+
+func (s *builder) updateAvgBuildTime(elapsed time.Duration) {
+
+	avg := atomic.LoadInt64(&s.avgBuildTime)
+	if avg == 0 {
+		avg = int64(elapsed)
+	} else {
+		avg = (avg*3 + int64(elapsed)) / 4
+	}
+	atomic.StoreInt64(&s.avgBuildTime, avg)
+}
+
+func (s *builder) publishBuildQueueStatus(indexerId common.IndexerId, queue []*mc.BuildQueueEntry, limit int32,
+	windows []mc.BuildWindow, now time.Time) {
+
+	avg := atomic.LoadInt64(&s.avgBuildTime)
+
+	status := &mc.BuildQueueStatus{
+		IndexerId:    indexerId,
+		AvgBuildTime: avg,
+		UpdateTime:   now.UnixNano(),
+		Positions:    mc.ComputeBuildQueuePositions(queue, time.Duration(avg), int(limit), windows, now),
+	}
+
+	if err := mc.PostBuildQueueStatus(status); err != nil {
+		logging.Warnf("builder: Failed to publish build queue status.  Error = %v.", err)
+	}
 }
 
 func (s *builder) disableBuild() bool {
@@ -3701,6 +4469,11 @@ func newBuilder(mgr *LifecycleMgr) *builder {
 	} else {
 		atomic.StoreInt32(&builder.disable, int32(0))
 	}
+
+	builder.updateQueueSettings(common.SystemConfig["indexer.build.queue.enable"].Bool(),
+		common.SystemConfig["indexer.build.queue.window"].String(),
+		common.SystemConfig["indexer.build.queue.maxConcurrentPerNode"].Int(),
+		common.SystemConfig["indexer.build.queue.maxConcurrentPerBucket"].Int())
 	return builder
 }
 
@@ -36,6 +36,7 @@ type IndexManager struct {
 	coordinator   *Coordinator
 	eventMgr      *eventManager
 	lifecycleMgr  *LifecycleMgr
+	metaFeed      *metadataFeed
 	cinfoClient   *common.ClusterInfoClient
 	requestServer RequestServer
 	basepath      string
@@ -95,6 +96,7 @@ type MetadataNotifier interface {
 	OnIndexDelete(common.IndexInstId, string, *common.MetadataRequestContext) error
 	OnIndexBuild([]common.IndexInstId, []string, *common.MetadataRequestContext) map[common.IndexInstId]error
 	OnPartitionPrune(common.IndexInstId, []common.PartitionId, *common.MetadataRequestContext) error
+	OnIndexRename([]common.IndexInstId, string, *common.MetadataRequestContext) error
 	OnFetchStats() error
 }
 
@@ -183,6 +185,14 @@ func NewIndexManagerInternal(config common.Config, storageMode common.StorageMod
 	// start lifecycle manager
 	mgr.lifecycleMgr.Run(mgr.repo, mgr.requestServer)
 
+	// Initialize metadata feed.  The feed keeps a log of changes
+	// to the local index metadata for query clients to subscribe.
+	mgr.metaFeed, err = newMetadataFeed(mgr)
+	if err != nil {
+		mgr.Close()
+		return nil, err
+	}
+
 	// coordinator
 	mgr.coordinator = nil
 
@@ -249,6 +259,10 @@ func (m *IndexManager) Close() {
 		m.coordinator.Terminate()
 	}
 
+	if m.metaFeed != nil {
+		m.metaFeed.close()
+	}
+
 	if m.eventMgr != nil {
 		m.eventMgr.close()
 	}
@@ -771,6 +785,13 @@ func (m *IndexManager) getMetadataRepo() *MetadataRepo {
 	return m.repo
 }
 
+//
+// Get metadata feed
+//
+func (m *IndexManager) getMetadataFeed() *metadataFeed {
+	return m.metaFeed
+}
+
 //
 // Get lifecycle manager
 //
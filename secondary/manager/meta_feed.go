@@ -0,0 +1,413 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"encoding/json"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"reflect"
+	"sync"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+type MetadataChangeType string
+
+const (
+	METADATA_INDEX_CREATED  MetadataChangeType = "created"
+	METADATA_INDEX_BUILT    MetadataChangeType = "built"
+	METADATA_INDEX_STATE    MetadataChangeType = "stateChanged"
+	METADATA_INDEX_MOVED    MetadataChangeType = "moved"
+	METADATA_INDEX_DROPPED  MetadataChangeType = "dropped"
+	METADATA_REPLICA_CHANGE MetadataChangeType = "replicaChanged"
+)
+
+//
+// A change to an index instance residing on this indexer node.  Instance
+// is the new distribution of the instance, and it is nil if the instance
+// has been dropped.  Definition is sent along when the instance is first
+// seen, so the subscriber does not depend on the definition having been
+// received through other means.
+//
+type MetadataChange struct {
+	Seqno      uint64                 `json:"seqno"`
+	Type       MetadataChangeType     `json:"type"`
+	Bucket     string                 `json:"bucket,omitempty"`
+	DefnId     common.IndexDefnId     `json:"defnId"`
+	InstId     common.IndexInstId     `json:"instId"`
+	Definition *common.IndexDefn      `json:"definition,omitempty"`
+	Instance   *IndexInstDistribution `json:"instance,omitempty"`
+}
+
+//
+// Message sent over the metadata feed.  A message is one of
+// 1) A change, with Seqno being the sequence number of the change.
+// 2) A full sync (Sync is true), carrying all the local index metadata as of Seqno.
+//    This is sent when the subscriber cannot catch up from its last seen
+//    sequence number (e.g. indexer restarted or the change log has been truncated).
+// 3) A heartbeat, carrying the latest sequence number without any change.  A
+//    change that the subscriber is not allowed to see is also sent as heartbeat.
+//
+type MetadataFeedMessage struct {
+	Epoch       string             `json:"epoch"`
+	Seqno       uint64             `json:"seqno"`
+	Sync        bool               `json:"sync,omitempty"`
+	Definitions []common.IndexDefn `json:"definitions,omitempty"`
+	Topologies  []IndexTopology    `json:"topologies,omitempty"`
+	Change      *MetadataChange    `json:"change,omitempty"`
+}
+
+//
+// metadataFeed keeps a log of versioned changes to the local index metadata.
+// Changes are derived by comparing each topology update against the last
+// topology seen for the bucket.  Sequence numbers are only meaningful within
+// an epoch, which is regenerated whenever the indexer restarts.
+//
+type metadataFeed struct {
+	mgr     *IndexManager
+	epoch   string
+	getDefn func(common.IndexDefnId) (*common.IndexDefn, error)
+
+	mutex    sync.Mutex
+	seqno    uint64
+	changes  []*MetadataChange
+	snapshot map[string]*IndexTopology
+	waitch   chan bool
+	isClosed bool
+
+	notifych <-chan interface{}
+	killch   chan bool
+}
+
+///////////////////////////////////////////////////////
+// Package Local Function
+///////////////////////////////////////////////////////
+
+func newMetadataFeed(mgr *IndexManager) (*metadataFeed, error) {
+
+	uuid, err := common.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
+	feed := &metadataFeed{
+		mgr:      mgr,
+		epoch:    uuid.Str(),
+		getDefn:  mgr.repo.GetIndexDefnById,
+		snapshot: make(map[string]*IndexTopology),
+		waitch:   make(chan bool),
+		killch:   make(chan bool),
+	}
+
+	// Register before reading the current topology.  Any update made in between
+	// will be compared against the snapshot, so no change is lost.
+	feed.notifych, err = mgr.StartListenTopologyUpdate(METADATA_FEED_LISTENER)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := mgr.repo.NewTopologyIterator()
+	if err != nil {
+		mgr.StopListenTopologyUpdate(METADATA_FEED_LISTENER)
+		return nil, err
+	}
+	defer iter.Close()
+
+	for {
+		topology, err := iter.Next()
+		if err != nil {
+			break
+		}
+
+		// topology in repo cache can be updated in place.  Keep a copy.
+		if clone := cloneIndexTopology(topology); clone != nil {
+			feed.snapshot[clone.Bucket] = clone
+		}
+	}
+
+	go feed.run()
+
+	logging.Infof("metadataFeed: started with epoch %v", feed.epoch)
+	return feed, nil
+}
+
+func (f *metadataFeed) run() {
+
+	for {
+		select {
+		case obj, ok := <-f.notifych:
+			if !ok {
+				f.close()
+				return
+			}
+
+			data, ok := obj.([]byte)
+			if !ok {
+				continue
+			}
+
+			topology, err := unmarshallIndexTopology(data)
+			if err != nil {
+				logging.Errorf("metadataFeed: unable to unmarshall topology.  Error = %v", err)
+				continue
+			}
+
+			f.update(topology)
+
+		case <-f.killch:
+			return
+		}
+	}
+}
+
+//
+// Compare the topology of a bucket against the last topology seen for the
+// bucket, and log a change for each index instance that has changed.
+//
+func (f *metadataFeed) update(topology *IndexTopology) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.isClosed {
+		return
+	}
+
+	prevInsts := make(map[common.IndexInstId]*IndexInstDistribution)
+	prevDefns := make(map[common.IndexInstId]common.IndexDefnId)
+	if prev, ok := f.snapshot[topology.Bucket]; ok {
+		for i, _ := range prev.Definitions {
+			defnRef := &prev.Definitions[i]
+			for j, _ := range defnRef.Instances {
+				instId := common.IndexInstId(defnRef.Instances[j].InstId)
+				prevInsts[instId] = &defnRef.Instances[j]
+				prevDefns[instId] = common.IndexDefnId(defnRef.DefnId)
+			}
+		}
+	}
+
+	hasDefn := func(defnId common.IndexDefnId) bool {
+		for _, id := range prevDefns {
+			if id == defnId {
+				return true
+			}
+		}
+		return false
+	}
+
+	var changes []*MetadataChange
+
+	for i, _ := range topology.Definitions {
+		defnRef := &topology.Definitions[i]
+		defnId := common.IndexDefnId(defnRef.DefnId)
+
+		for j, _ := range defnRef.Instances {
+			inst := &defnRef.Instances[j]
+			instId := common.IndexInstId(inst.InstId)
+
+			prevInst, ok := prevInsts[instId]
+			delete(prevInsts, instId)
+
+			var evtType MetadataChangeType
+			if !ok {
+				evtType = METADATA_INDEX_CREATED
+				if common.RebalanceState(inst.RState) == common.REBAL_PENDING {
+					evtType = METADATA_INDEX_MOVED
+				} else if hasDefn(defnId) {
+					evtType = METADATA_REPLICA_CHANGE
+				}
+			} else {
+				evtType = instChangeType(prevInst, inst)
+			}
+
+			if len(evtType) == 0 {
+				continue
+			}
+
+			instCopy := *inst
+			change := &MetadataChange{
+				Type:     evtType,
+				Bucket:   topology.Bucket,
+				DefnId:   defnId,
+				InstId:   instId,
+				Instance: &instCopy,
+			}
+
+			if !ok {
+				if defn, err := f.getDefn(defnId); err == nil && defn != nil {
+					change.Definition = defn
+				}
+			}
+
+			changes = append(changes, change)
+		}
+	}
+
+	for instId, _ := range prevInsts {
+		changes = append(changes, &MetadataChange{
+			Type:   METADATA_INDEX_DROPPED,
+			Bucket: topology.Bucket,
+			DefnId: prevDefns[instId],
+			InstId: instId,
+		})
+	}
+
+	f.snapshot[topology.Bucket] = topology
+
+	if len(changes) == 0 {
+		return
+	}
+
+	for _, change := range changes {
+		f.seqno++
+		change.Seqno = f.seqno
+		f.changes = append(f.changes, change)
+
+		logging.Debugf("metadataFeed: seqno %v %v index defn %v inst %v",
+			change.Seqno, change.Type, change.DefnId, change.InstId)
+	}
+
+	if len(f.changes) > METADATA_FEED_LOG_SIZE {
+		f.changes = append([]*MetadataChange(nil), f.changes[len(f.changes)-METADATA_FEED_LOG_SIZE:]...)
+	}
+
+	// wake up subscribers
+	close(f.waitch)
+	f.waitch = make(chan bool)
+}
+
+//
+// Read changes after the given sequence number.  If the changes cannot be
+// served from the log, a full sync message is returned instead.  The returned
+// channel is closed when there are new changes.
+//
+func (f *metadataFeed) read(epoch string, since uint64) ([]*MetadataChange, *MetadataFeedMessage, <-chan bool) {
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	gap := epoch != f.epoch || since > f.seqno ||
+		(since < f.seqno && (len(f.changes) == 0 || f.changes[0].Seqno > since+1))
+
+	if gap {
+		msg := &MetadataFeedMessage{
+			Epoch: f.epoch,
+			Seqno: f.seqno,
+			Sync:  true,
+		}
+
+		for _, topology := range f.snapshot {
+			msg.Topologies = append(msg.Topologies, *topology)
+
+			for _, defnRef := range topology.Definitions {
+				defn, err := f.getDefn(common.IndexDefnId(defnRef.DefnId))
+				if err == nil && defn != nil {
+					msg.Definitions = append(msg.Definitions, *defn)
+				}
+			}
+		}
+
+		return nil, msg, f.waitch
+	}
+
+	if since == f.seqno {
+		return nil, nil, f.waitch
+	}
+
+	pos := int(since + 1 - f.changes[0].Seqno)
+	result := make([]*MetadataChange, len(f.changes)-pos)
+	copy(result, f.changes[pos:])
+
+	return result, nil, f.waitch
+}
+
+func (f *metadataFeed) getEpoch() string {
+	return f.epoch
+}
+
+func (f *metadataFeed) close() {
+
+	f.mutex.Lock()
+	if f.isClosed {
+		f.mutex.Unlock()
+		return
+	}
+	f.isClosed = true
+	close(f.killch)
+	f.mutex.Unlock()
+
+	// Do not hold the mutex.  Event manager can be blocked on notifying the feed.
+	f.mgr.StopListenTopologyUpdate(METADATA_FEED_LISTENER)
+}
+
+func (f *metadataFeed) closed() <-chan bool {
+	return f.killch
+}
+
+///////////////////////////////////////////////////////
+// Private Function
+///////////////////////////////////////////////////////
+
+//
+// Return the type of change between two versions of an index instance.
+// Empty string is returned if nothing that matters to the subscriber
+// has changed.
+//
+func instChangeType(prev *IndexInstDistribution, curr *IndexInstDistribution) MetadataChangeType {
+
+	if common.IndexState(curr.State) == common.INDEX_STATE_DELETED {
+		if common.IndexState(prev.State) == common.INDEX_STATE_DELETED {
+			return ""
+		}
+		return METADATA_INDEX_DROPPED
+	}
+
+	if prev.RState != curr.RState || prev.Version != curr.Version ||
+		!reflect.DeepEqual(prev.Partitions, curr.Partitions) {
+		return METADATA_INDEX_MOVED
+	}
+
+	if prev.State != curr.State {
+		if common.IndexState(curr.State) == common.INDEX_STATE_ACTIVE {
+			return METADATA_INDEX_BUILT
+		}
+		return METADATA_INDEX_STATE
+	}
+
+	if prev.Error != curr.Error || prev.StorageMode != curr.StorageMode {
+		return METADATA_INDEX_STATE
+	}
+
+	if prev.ReplicaId != curr.ReplicaId {
+		return METADATA_REPLICA_CHANGE
+	}
+
+	return ""
+}
+
+func cloneIndexTopology(topology *IndexTopology) *IndexTopology {
+
+	data, err := json.Marshal(topology)
+	if err != nil {
+		logging.Errorf("metadataFeed: unable to marshall topology.  Error = %v", err)
+		return nil
+	}
+
+	clone, err := unmarshallIndexTopology(data)
+	if err != nil {
+		logging.Errorf("metadataFeed: unable to unmarshall topology.  Error = %v", err)
+		return nil
+	}
+
+	return clone
+}
@@ -0,0 +1,218 @@
+package manager
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+//
+// Create a metadata feed without an index manager.  Definitions are looked
+// up from defns.
+//
+func newTestMetadataFeed(defns map[common.IndexDefnId]*common.IndexDefn) *metadataFeed {
+
+	return &metadataFeed{
+		epoch: "epoch1",
+		getDefn: func(defnId common.IndexDefnId) (*common.IndexDefn, error) {
+			if defn, ok := defns[defnId]; ok {
+				return defn, nil
+			}
+			return nil, fmt.Errorf("index %v not found", defnId)
+		},
+		snapshot: make(map[string]*IndexTopology),
+		waitch:   make(chan bool),
+		killch:   make(chan bool),
+	}
+}
+
+func newTestInst(instId uint64, state common.IndexState) IndexInstDistribution {
+
+	return IndexInstDistribution{
+		InstId: instId,
+		State:  uint32(state),
+		RState: uint32(common.REBAL_ACTIVE),
+		Partitions: []IndexPartDistribution{{
+			PartId: uint64(common.NON_PARTITION_ID),
+			SinglePartition: IndexSinglePartDistribution{
+				Slices: []IndexSliceLocator{{IndexerId: "idxr1"}},
+			},
+		}},
+	}
+}
+
+func newTestTopology(bucket string, defnId uint64, insts ...IndexInstDistribution) *IndexTopology {
+
+	return &IndexTopology{
+		Bucket: bucket,
+		Definitions: []IndexDefnDistribution{{
+			Bucket:    bucket,
+			Name:      fmt.Sprintf("idx%v", defnId),
+			DefnId:    defnId,
+			Instances: insts,
+		}},
+	}
+}
+
+func TestMetadataFeedUpdate(t *testing.T) {
+
+	defn := &common.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}
+	f := newTestMetadataFeed(map[common.IndexDefnId]*common.IndexDefn{1: defn})
+
+	initial := newTestInst(11, common.INDEX_STATE_INITIAL)
+	active := newTestInst(11, common.INDEX_STATE_ACTIVE)
+	failed := active
+	failed.Error = "build failed"
+	replica := newTestInst(12, common.INDEX_STATE_ACTIVE)
+	replica.ReplicaId = 1
+	moved := replica
+	moved.Partitions = []IndexPartDistribution{{
+		PartId: uint64(common.NON_PARTITION_ID),
+		SinglePartition: IndexSinglePartDistribution{
+			Slices: []IndexSliceLocator{{IndexerId: "idxr2"}},
+		},
+	}}
+	deleted := moved
+	deleted.State = uint32(common.INDEX_STATE_DELETED)
+	pending := newTestInst(13, common.INDEX_STATE_ACTIVE)
+	pending.RState = uint32(common.REBAL_PENDING)
+
+	testcases := []struct {
+		comment  string
+		topology *IndexTopology
+		changes  []MetadataChangeType
+		instIds  []common.IndexInstId
+	}{
+		{"created", newTestTopology("b1", 1, initial),
+			[]MetadataChangeType{METADATA_INDEX_CREATED}, []common.IndexInstId{11}},
+		{"no change", newTestTopology("b1", 1, initial), nil, nil},
+		{"built", newTestTopology("b1", 1, active),
+			[]MetadataChangeType{METADATA_INDEX_BUILT}, []common.IndexInstId{11}},
+		{"error", newTestTopology("b1", 1, failed),
+			[]MetadataChangeType{METADATA_INDEX_STATE}, []common.IndexInstId{11}},
+		{"replica added", newTestTopology("b1", 1, failed, replica),
+			[]MetadataChangeType{METADATA_REPLICA_CHANGE}, []common.IndexInstId{12}},
+		{"replica moved", newTestTopology("b1", 1, failed, moved),
+			[]MetadataChangeType{METADATA_INDEX_MOVED}, []common.IndexInstId{12}},
+		{"rebalance pending", newTestTopology("b1", 1, failed, moved, pending),
+			[]MetadataChangeType{METADATA_INDEX_MOVED}, []common.IndexInstId{13}},
+		{"replica deleted", newTestTopology("b1", 1, failed, deleted, pending),
+			[]MetadataChangeType{METADATA_INDEX_DROPPED}, []common.IndexInstId{12}},
+		{"deleted again", newTestTopology("b1", 1, failed, deleted, pending), nil, nil},
+		{"other bucket", newTestTopology("b2", 2, newTestInst(21, common.INDEX_STATE_ACTIVE)),
+			[]MetadataChangeType{METADATA_INDEX_CREATED}, []common.IndexInstId{21}},
+		{"removed", newTestTopology("b1", 1, deleted),
+			[]MetadataChangeType{METADATA_INDEX_DROPPED, METADATA_INDEX_DROPPED}, nil},
+	}
+
+	for _, tc := range testcases {
+		seqno := f.seqno
+		f.update(tc.topology)
+
+		changes, _, _ := f.read(f.epoch, seqno)
+
+		var types []MetadataChangeType
+		var instIds []common.IndexInstId
+		for i, change := range changes {
+			types = append(types, change.Type)
+			if change.Type != METADATA_INDEX_DROPPED || change.Instance != nil {
+				instIds = append(instIds, change.InstId)
+			}
+			if change.Seqno != seqno+uint64(i)+1 {
+				t.Errorf("%v: expected seqno %v, got %v", tc.comment, seqno+uint64(i)+1, change.Seqno)
+			}
+		}
+
+		if !reflect.DeepEqual(types, tc.changes) {
+			t.Errorf("%v: expected changes %v, got %v", tc.comment, tc.changes, types)
+		}
+		if !reflect.DeepEqual(instIds, tc.instIds) {
+			t.Errorf("%v: expected instances %v, got %v", tc.comment, tc.instIds, instIds)
+		}
+	}
+
+	// definition is sent along with the first change of the instance only
+	changes, _, _ := f.read(f.epoch, 0)
+	if changes[0].Definition == nil || changes[0].Definition.Name != "idx1" {
+		t.Errorf("created: expected definition idx1, got %v", changes[0].Definition)
+	}
+	if changes[1].Definition != nil {
+		t.Errorf("built: expected no definition, got %v", changes[1].Definition)
+	}
+}
+
+func TestMetadataFeedRead(t *testing.T) {
+
+	defn := &common.IndexDefn{DefnId: 1, Bucket: "b1", Name: "idx1"}
+	f := newTestMetadataFeed(map[common.IndexDefnId]*common.IndexDefn{1: defn})
+
+	inst := newTestInst(11, common.INDEX_STATE_ACTIVE)
+	update := func(n int) {
+		for i := 0; i < n; i++ {
+			inst.Error = fmt.Sprintf("error %v", f.seqno)
+			f.update(newTestTopology("b1", 1, inst))
+		}
+	}
+
+	// subscriber is woken up on new changes
+	_, _, waitch := f.read(f.epoch, 0)
+	update(5)
+	select {
+	case <-waitch:
+	default:
+		t.Errorf("update: expected subscriber to be woken up")
+	}
+
+	checkRead := func(comment string, epoch string, since uint64, first uint64, sync bool) {
+		changes, msg, _ := f.read(epoch, since)
+
+		if sync {
+			if msg == nil || !msg.Sync || msg.Epoch != f.epoch || msg.Seqno != f.seqno {
+				t.Errorf("%v: expected full sync at seqno %v, got %+v", comment, f.seqno, msg)
+			} else if len(msg.Topologies) != 1 || len(msg.Definitions) != 1 || msg.Definitions[0].Name != "idx1" {
+				t.Errorf("%v: unexpected full sync %+v", comment, msg)
+			}
+			if len(changes) != 0 {
+				t.Errorf("%v: expected no change with full sync, got %v", comment, len(changes))
+			}
+			return
+		}
+
+		if msg != nil {
+			t.Errorf("%v: unexpected full sync", comment)
+			return
+		}
+		if len(changes) != int(f.seqno-since) {
+			t.Errorf("%v: expected %v changes, got %v", comment, f.seqno-since, len(changes))
+		} else if len(changes) != 0 && changes[0].Seqno != first {
+			t.Errorf("%v: expected first seqno %v, got %v", comment, first, changes[0].Seqno)
+		}
+	}
+
+	checkRead("from start", f.epoch, 0, 1, false)
+	checkRead("in between", f.epoch, 3, 4, false)
+	checkRead("up to date", f.epoch, 5, 0, false)
+	checkRead("ahead of feed", f.epoch, 6, 0, true)
+	checkRead("other epoch", "epoch0", 3, 0, true)
+	checkRead("no epoch", "", 0, 0, true)
+
+	// changes older than the log are served by full sync
+	update(METADATA_FEED_LOG_SIZE)
+	first := f.seqno - METADATA_FEED_LOG_SIZE + 1
+	if len(f.changes) != METADATA_FEED_LOG_SIZE || f.changes[0].Seqno != first {
+		t.Errorf("truncate: expected %v changes from %v, got %v", METADATA_FEED_LOG_SIZE, first, len(f.changes))
+	}
+	checkRead("truncated", f.epoch, 0, 0, true)
+	checkRead("before log", f.epoch, first-2, 0, true)
+	checkRead("start of log", f.epoch, first-1, first, false)
+
+	// closed feed ignores updates
+	f.isClosed = true
+	seqno := f.seqno
+	update(1)
+	if f.seqno != seqno {
+		t.Errorf("closed: expected seqno %v, got %v", seqno, f.seqno)
+	}
+}
@@ -483,6 +483,43 @@ func (c *MetadataRepo) DropIndexById(id common.IndexDefnId) error {
 	return nil
 }
 
+//
+// Add the instance of a non-partitioned index to the topology of its
+// bucket and set the instance to the given state.  This is for indexers that do not run
+// lifecycle manager, such as an embedded test indexer.
+//
+func (c *MetadataRepo) AddIndexToTopology(defn *common.IndexDefn, instId common.IndexInstId,
+	state common.IndexState) error {
+
+	partitions := []common.PartitionId{common.PartitionId(0)}
+	versions := []int{0}
+	if err := c.addIndexToTopology(defn, instId, 0, partitions, versions, 1, 0, false); err != nil {
+		return err
+	}
+
+	topology, err := c.CloneTopologyByBucket(defn.Bucket)
+	if err != nil {
+		return err
+	}
+	if topology != nil && topology.UpdateStateForIndexInst(defn.DefnId, instId, state) {
+		return c.SetTopologyByBucket(defn.Bucket, topology)
+	}
+	return nil
+}
+
+//
+// Remove the index from the topology of its bucket.
+//
+func (c *MetadataRepo) RemoveIndexFromTopology(defn *common.IndexDefn) error {
+
+	topology, err := c.CloneTopologyByBucket(defn.Bucket)
+	if err != nil || topology == nil {
+		return err
+	}
+	topology.RemoveIndexDefinitionById(defn.DefnId)
+	return c.SetTopologyByBucket(defn.Bucket, topology)
+}
+
 func (c *MetadataRepo) UpdateIndex(defn *common.IndexDefn) error {
 
 	// check if defn already exist
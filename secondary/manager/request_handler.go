@@ -125,6 +125,8 @@ type IndexStatus struct {
 	NumReplica   int                `json:"numReplica"`
 	IndexName    string             `json:"indexName"`
 	ReplicaId    int                `json:"replicaId"`
+	QueuePos     int                `json:"buildQueuePosition,omitempty"`
+	BuildETA     string             `json:"buildETA,omitempty"`
 }
 
 type indexStatusSorter []IndexStatus
@@ -171,11 +173,14 @@ func registerRequestHandler(mgr *IndexManager, clusterUrl string, mux *http.Serv
 		mux.HandleFunc("/getIndexMetadata", handlerContext.handleIndexMetadataRequest)
 		mux.HandleFunc("/restoreIndexMetadata", handlerContext.handleRestoreIndexMetadataRequest)
 		mux.HandleFunc("/getIndexStatus", handlerContext.handleIndexStatusRequest)
+		mux.HandleFunc("/getIndexUsageReport", handlerContext.handleIndexUsageReportRequest)
 		mux.HandleFunc("/getIndexStatement", handlerContext.handleIndexStatementRequest)
 		mux.HandleFunc("/planIndex", handlerContext.handleIndexPlanRequest)
+		mux.HandleFunc("/planTopologyChange", handlerContext.handleTopologyChangePlanRequest)
 		mux.HandleFunc("/settings/storageMode", handlerContext.handleIndexStorageModeRequest)
 		mux.HandleFunc("/settings/planner", handlerContext.handlePlannerRequest)
 		mux.HandleFunc("/listReplicaCount", handlerContext.handleListLocalReplicaCountRequest)
+		mux.HandleFunc("/metadataChanges", handlerContext.handleMetadataChangesRequest)
 	})
 
 	handlerContext.mgr = mgr
@@ -240,6 +245,9 @@ func (m *requestHandlerContext) doCreateIndex(w http.ResponseWriter, r *http.Req
 		indexDefn.Bucket, indexDefn.Name)
 
 	if err := m.mgr.HandleCreateIndexDDL(&indexDefn, isRebalReq); err == nil {
+		if !isRebalReq {
+			mc.RecordDDLHistory(mc.DDLHistoryOpCreate, creds.Name(), ddlHistoryContext(r), nil, &indexDefn)
+		}
 		// No error, return success
 		sendIndexResponse(w)
 	} else {
@@ -272,7 +280,11 @@ func (m *requestHandlerContext) dropIndexRequest(w http.ResponseWriter, r *http.
 	indexDefn := request.Index
 
 	if indexDefn.RealInstId == 0 {
+		before, _ := m.mgr.GetIndexDefnById(indexDefn.DefnId)
 		if err := m.mgr.HandleDeleteIndexDDL(indexDefn.DefnId); err == nil {
+			if before != nil {
+				mc.RecordDDLHistory(mc.DDLHistoryOpDrop, creds.Name(), ddlHistoryContext(r), before, nil)
+			}
 			// No error, return success
 			sendIndexResponse(w)
 		} else {
@@ -315,6 +327,11 @@ func (m *requestHandlerContext) buildIndexRequest(w http.ResponseWriter, r *http
 	// call the index manager to handle the DDL
 	indexIds := request.IndexIds
 	if err := m.mgr.HandleBuildIndexDDL(indexIds); err == nil {
+		for _, id := range indexIds.DefnIds {
+			if defn, _ := m.mgr.GetIndexDefnById(common.IndexDefnId(id)); defn != nil {
+				mc.RecordDDLHistory(mc.DDLHistoryOpBuild, creds.Name(), ddlHistoryContext(r), nil, defn)
+			}
+		}
 		// No error, return success
 		sendIndexResponse(w)
 	} else {
@@ -323,6 +340,13 @@ func (m *requestHandlerContext) buildIndexRequest(w http.ResponseWriter, r *http
 	}
 }
 
+//
+// Context of a DDL request made through the REST API, as recorded in DDL history.
+//
+func ddlHistoryContext(r *http.Request) mc.DDLHistoryContext {
+	return mc.DDLHistoryContext{Source: mc.DDLHistorySourceREST, RemoteAddr: r.RemoteAddr}
+}
+
 func (m *requestHandlerContext) convertIndexRequest(r *http.Request) *IndexRequest {
 
 	req := &IndexRequest{}
@@ -399,6 +423,19 @@ func (m *requestHandlerContext) getIndexStatus(creds cbauth.Creds, bucket string
 	list := make([]IndexStatus, 0)
 	failedNodes := make([]string, 0)
 
+	// build queue position published by the builder of each indexer node.
+	// Skip reading metakv if build queue is not enabled.
+	queueStatus := make(map[common.IndexerId]*mc.BuildQueueStatus)
+	if m.mgr.getLifecycleMgr().builder.queueEnabled() {
+		if statuses, err := mc.ListBuildQueueStatus(); err == nil {
+			for _, status := range statuses {
+				queueStatus[status.IndexerId] = status
+			}
+		} else {
+			logging.Debugf("RequestHandler::getIndexStatus: Error while retrieving build queue status %v", err)
+		}
+	}
+
 	mergeCounter := func(defnId common.IndexDefnId, counter common.Counter) {
 		if current, ok := numReplicas[defnId]; ok {
 			newValue, merged, err := current.MergeWith(counter)
@@ -586,6 +623,17 @@ func (m *requestHandlerContext) getIndexStatus(creds cbauth.Creds, bucket string
 								ReplicaId:    int(instance.ReplicaId),
 							}
 
+							if state == common.INDEX_STATE_READY {
+								if qs, ok := queueStatus[common.IndexerId(localMeta.IndexerId)]; ok {
+									if pos, ok := qs.Positions[defn.DefnId]; ok && pos.Position != 0 {
+										status.QueuePos = pos.Position
+										if pos.ETA != 0 {
+											status.BuildETA = time.Unix(0, pos.ETA).Format(time.RFC3339)
+										}
+									}
+								}
+							}
+
 							list = append(list, status)
 						}
 					}
@@ -631,6 +679,11 @@ func (m *requestHandlerContext) consolideIndexStatus(statuses []IndexStatus) []I
 			s2.Progress = (s2.Progress + status.Progress) / 2.0
 			s2.NumPartition += status.NumPartition
 			s2.NodeUUID = ""
+			if status.QueuePos != 0 && (s2.QueuePos == 0 || status.QueuePos > s2.QueuePos) {
+				// index build completes only when the last partition is built
+				s2.QueuePos = status.QueuePos
+				s2.BuildETA = status.BuildETA
+			}
 			if len(status.Error) != 0 {
 				s2.Error = fmt.Sprintf("%v %v", s2.Error, status.Error)
 			}
@@ -891,6 +944,10 @@ func (m *requestHandlerContext) getLocalIndexMetadata(creds cbauth.Creds, bucket
 		meta.LocalSettings["excludeNode"] = exclude
 	}
 
+	if labels, err := m.mgr.GetLocalValue("nodeLabels"); err == nil {
+		meta.LocalSettings["nodeLabels"] = labels
+	}
+
 	iter, err := repo.NewIterator()
 	if err != nil {
 		return nil, err
@@ -1085,6 +1142,61 @@ func (m *requestHandlerContext) convertIndexPlanRequest(r *http.Request) ([]*pla
 	return specs, nil
 }
 
+//
+// Plan a hypothetical topology change (add/remove node, server group, replica,
+// memory quota) against the live index layout.  This does not modify the cluster.
+//
+func (m *requestHandlerContext) handleTopologyChangePlanRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	if !isAllowed(creds, []string{"cluster.settings!read"}, w) {
+		return
+	}
+
+	spec, err := m.convertTopologyChangeRequest(r)
+	if err != nil {
+		sendHttpError(w, fmt.Sprintf("Fail to read topology change from request.   Error=%v", err), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := planner.RetrievePlanFromCluster(m.clusterUrl, nil)
+	if err != nil {
+		sendHttpError(w, fmt.Sprintf("Fail to retreive index information from cluster.   Error=%v", err), http.StatusInternalServerError)
+		return
+	}
+
+	result, err := planner.ExecuteWhatIf(plan, spec)
+	if err != nil {
+		sendHttpError(w, fmt.Sprintf("Fail to plan topology change.   Error=%v", err), http.StatusInternalServerError)
+		return
+	}
+
+	send(http.StatusOK, w, result)
+}
+
+func (m *requestHandlerContext) convertTopologyChangeRequest(r *http.Request) (*planner.WhatIfSpec, error) {
+
+	buf := new(bytes.Buffer)
+	if _, err := buf.ReadFrom(r.Body); err != nil {
+		logging.Debugf("RequestHandler::convertTopologyChangeRequest: unable to read request body, err %v", err)
+		return nil, err
+	}
+
+	logging.Debugf("requestHandler.convertTopologyChangeRequest(): input %v", string(buf.Bytes()))
+
+	spec := &planner.WhatIfSpec{}
+	if err := json.Unmarshal(buf.Bytes(), spec); err != nil {
+		logging.Debugf("RequestHandler::convertTopologyChangeRequest: unable to unmarshall request body. Buf = %s, err %v", buf, err)
+		return nil, err
+	}
+
+	return spec, nil
+}
+
 //////////////////////////////////////////////////////
 // Storage Mode
 ///////////////////////////////////////////////////////
@@ -1159,6 +1271,22 @@ func (m *requestHandlerContext) handlePlannerRequest(w http.ResponseWriter, r *h
 		return
 	}
 
+	// Set the labels of the local indexer node, as a comma separated list.  Labels are
+	// used by the planner for placing indexes with node label placement rule.
+	r.ParseForm()
+	if labels, ok := r.Form["nodeLabels"]; ok {
+		value := strings.Join(planner.ParseNodeLabels(labels[0]), ",")
+		if err := m.mgr.SetLocalValue("nodeLabels", value); err != nil {
+			sendHttpError(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		if _, ok := r.Form["excludeNode"]; !ok {
+			send(http.StatusOK, w, "OK")
+			return
+		}
+	}
+
 	// Override the storage mode for the local indexer.  Override will not take into effect until
 	// indexer has restarted manually by administrator.   During indexer bootstrap, it will upgrade/downgrade
 	// individual index to the override storage mode.
@@ -1228,6 +1356,115 @@ func (m *requestHandlerContext) getLocalReplicaCount(creds cbauth.Creds) (map[co
 	return result, nil
 }
 
+///////////////////////////////////////////////////////
+// Metadata Feed
+///////////////////////////////////////////////////////
+
+//
+// Stream changes to the local index metadata as newline delimited JSON.  The
+// subscriber passes the epoch and the sequence number of the last change it
+// has seen.  If the changes cannot be streamed from there, a full sync message is
+// sent first.  The response does not end until the subscriber disconnects.
+// Heartbeat is sent periodically, so the subscriber can detect a dead connection.
+//
+func (m *requestHandlerContext) handleMetadataChangesRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	feed := m.mgr.getMetadataFeed()
+	if feed == nil {
+		sendHttpError(w, "Metadata feed is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	epoch := r.FormValue("epoch")
+	since := uint64(0)
+	if s := r.FormValue("since"); len(s) != 0 {
+		var err error
+		if since, err = strconv.ParseUint(s, 10, 64); err != nil {
+			sendHttpError(w, "Invalid sequence number "+s, http.StatusBadRequest)
+			return
+		}
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		sendHttpError(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	// permission is checked once per bucket for the lifetime of the subscription
+	permitted := make(map[string]bool)
+	isPermitted := func(bucket string) bool {
+		if allowed, ok := permitted[bucket]; ok {
+			return allowed
+		}
+		permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", bucket)
+		permitted[bucket] = isAllowed(creds, []string{permission}, nil)
+		return permitted[bucket]
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	encoder := json.NewEncoder(w)
+
+	ticker := time.NewTicker(METADATA_FEED_HEARTBEAT)
+	defer ticker.Stop()
+
+	logging.Infof("RequestHandler::handleMetadataChangesRequest: subscriber %v epoch %v since %v", r.RemoteAddr, epoch, since)
+	defer logging.Infof("RequestHandler::handleMetadataChangesRequest: subscriber %v disconnected", r.RemoteAddr)
+
+	for {
+		changes, syncMsg, waitch := feed.read(epoch, since)
+
+		if syncMsg != nil {
+			msg := &MetadataFeedMessage{Epoch: syncMsg.Epoch, Seqno: syncMsg.Seqno, Sync: true}
+			for _, topology := range syncMsg.Topologies {
+				if isPermitted(topology.Bucket) {
+					msg.Topologies = append(msg.Topologies, topology)
+				}
+			}
+			for _, defn := range syncMsg.Definitions {
+				if isPermitted(defn.Bucket) {
+					msg.Definitions = append(msg.Definitions, defn)
+				}
+			}
+			if err := encoder.Encode(msg); err != nil {
+				return
+			}
+			epoch, since = syncMsg.Epoch, syncMsg.Seqno
+		}
+
+		for _, change := range changes {
+			msg := &MetadataFeedMessage{Epoch: epoch, Seqno: change.Seqno}
+			if isPermitted(change.Bucket) {
+				msg.Change = change
+			}
+			if err := encoder.Encode(msg); err != nil {
+				return
+			}
+			since = change.Seqno
+		}
+		flusher.Flush()
+
+		select {
+		case <-waitch:
+		case <-ticker.C:
+			if err := encoder.Encode(&MetadataFeedMessage{Epoch: epoch, Seqno: since}); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-feed.closed():
+			return
+		}
+	}
+}
+
 ///////////////////////////////////////////////////////
 // Utility
 ///////////////////////////////////////////////////////
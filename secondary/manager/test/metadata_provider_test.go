@@ -124,13 +124,13 @@ func TestMetadataProvider(t *testing.T) {
 	}
 	input := make([]common.IndexDefnId, 1)
 	input[0] = newDefnId
-	if err := provider.BuildIndexes(input); err != nil {
+	if err := provider.BuildIndexes(input, nil); err != nil {
 		t.Fatal("Cannot build Index Defn : %v", err)
 	}
 	logging.Infof("done creating index 102")
 
 	// Drop a seeded index (created during setup step)
-	if err := provider.DropIndex(common.IndexDefnId(101)); err != nil {
+	if err := provider.DropIndex(common.IndexDefnId(101), nil); err != nil {
 		t.Fatal("Cannot drop Index Defn 101 through MetadataProvider")
 	}
 	logging.Infof("done dropping index 101")
@@ -0,0 +1,403 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+///////////////////////////////////////////////////////
+// Type Definition
+///////////////////////////////////////////////////////
+
+//
+// Index Usage Report
+//
+
+const DEFAULT_UNUSED_DAYS = 30
+
+type IndexUsageReportResponse struct {
+	Version     uint64           `json:"version,omitempty"`
+	Code        string           `json:"code,omitempty"`
+	Error       string           `json:"error,omitempty"`
+	FailedNodes []string         `json:"failedNodes,omitempty"`
+	UnusedDays  int              `json:"unusedDays"`
+	Unused      []IndexUsage     `json:"unused"`
+	Redundant   []RedundantIndex `json:"redundant"`
+	Indexes     []IndexUsage     `json:"indexes,omitempty"`
+}
+
+type IndexUsage struct {
+	DefnId       common.IndexDefnId `json:"defnId,omitempty"`
+	Name         string             `json:"name,omitempty"`
+	Bucket       string             `json:"bucket,omitempty"`
+	SecExprs     []string           `json:"secExprs,omitempty"`
+	WhereExpr    string             `json:"where,omitempty"`
+	Hosts        []string           `json:"hosts,omitempty"`
+	NumRequests  int64              `json:"numRequests"`
+	LastScanTime string             `json:"lastScanTime,omitempty"`
+	StatsSince   string             `json:"statsSince,omitempty"`
+	MemoryUsed   int64              `json:"memoryUsed"`
+	DiskSize     int64              `json:"diskSize"`
+
+	defn         common.IndexDefn
+	lastScanTime int64
+	statsSince   int64
+	numRequests  map[common.IndexInstId]int64
+}
+
+type RedundantIndex struct {
+	IndexUsage
+	CoveredBy       string             `json:"coveredBy,omitempty"`
+	CoveredByDefnId common.IndexDefnId `json:"coveredByDefnId,omitempty"`
+}
+
+type indexUsageSorter []IndexUsage
+
+///////////////////////////////////////////////////////
+// REST Handler
+///////////////////////////////////////////////////////
+
+//
+// GET /getIndexUsageReport?bucket=<bucket>&unusedDays=<days>&all=true
+//
+// Report the indexes with no scan in the last <days> and the indexes whose keys
+// are a strict leading subset of another index with the same WHERE clause.
+//
+func (m *requestHandlerContext) handleIndexUsageReportRequest(w http.ResponseWriter, r *http.Request) {
+
+	creds, ok := doAuth(r, w)
+	if !ok {
+		return
+	}
+
+	bucket := m.getBucket(r)
+
+	days := DEFAULT_UNUSED_DAYS
+	if val := r.FormValue("unusedDays"); len(val) != 0 {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			sendIndexResponseWithError(http.StatusBadRequest, w, fmt.Sprintf("Invalid unusedDays %v", val))
+			return
+		}
+		days = n
+	}
+
+	usages, failedNodes, err := m.getIndexUsage(creds, bucket)
+	if err != nil {
+		logging.Debugf("RequestHandler::handleIndexUsageReportRequest: err %v", err)
+		resp := &IndexUsageReportResponse{Code: RESP_ERROR, Error: err.Error()}
+		send(http.StatusInternalServerError, w, resp)
+		return
+	}
+
+	resp := &IndexUsageReportResponse{
+		Code:       RESP_SUCCESS,
+		UnusedDays: days,
+		Unused:     findUnusedIndexes(usages, time.Now().Add(-time.Duration(days)*24*time.Hour)),
+		Redundant:  findRedundantIndexes(usages),
+	}
+
+	if r.FormValue("all") == "true" {
+		resp.Indexes = usages
+	}
+
+	if len(failedNodes) != 0 {
+		logging.Debugf("RequestHandler::handleIndexUsageReportRequest: failed nodes %v", failedNodes)
+		resp.Code = RESP_ERROR
+		resp.Error = "Fail to retrieve cluster-wide index usage from index service"
+		resp.FailedNodes = failedNodes
+		send(http.StatusInternalServerError, w, resp)
+		return
+	}
+
+	send(http.StatusOK, w, resp)
+}
+
+///////////////////////////////////////////////////////
+// Index Usage
+///////////////////////////////////////////////////////
+
+//
+// Collect the usage of each index across all index nodes.  Stats of replicas
+// and partitions of the same index are combined.
+//
+func (m *requestHandlerContext) getIndexUsage(creds cbauth.Creds, bucket string) ([]IndexUsage, []string, error) {
+
+	var cinfo *common.ClusterInfoCache
+	cinfo = m.mgr.cinfoClient.GetClusterInfoCache()
+
+	if cinfo == nil {
+		return nil, nil, errors.New("ClusterInfoCache unavailable in IndexManager")
+	}
+
+	cinfo.RLock()
+	defer cinfo.RUnlock()
+
+	nids := cinfo.GetNodesByServiceType(common.INDEX_HTTP_SERVICE)
+
+	usages := make(map[common.IndexDefnId]*IndexUsage)
+	failedNodes := make([]string, 0)
+
+	getInt64 := func(stats *common.Statistics, key string) int64 {
+		if stat, ok := stats.ToMap()[key]; ok {
+			if val, ok := stat.(float64); ok {
+				return int64(val)
+			}
+		}
+		return 0
+	}
+
+	for _, nid := range nids {
+
+		addr, err := cinfo.GetServiceAddress(nid, common.INDEX_HTTP_SERVICE)
+		if err != nil {
+			logging.Debugf("RequestHandler::getIndexUsage: Error from GetServiceAddress (indexHttp) for node id %v. Error = %v", nid, err)
+			failedNodes = append(failedNodes, addr)
+			continue
+		}
+
+		resp, err := getWithAuth(addr + "/getLocalIndexMetadata")
+		if err != nil {
+			logging.Debugf("RequestHandler::getIndexUsage: Error while retrieving %v with auth %v", addr+"/getLocalIndexMetadata", err)
+			failedNodes = append(failedNodes, addr)
+			continue
+		}
+
+		localMeta := new(LocalIndexMetadata)
+		status := convertResponse(resp, localMeta)
+		resp.Body.Close()
+		if status == RESP_ERROR {
+			logging.Debugf("RequestHandler::getIndexUsage: Error from convertResponse for localMeta")
+			failedNodes = append(failedNodes, addr)
+			continue
+		}
+
+		curl, err := cinfo.GetServiceAddress(nid, "mgmt")
+		if err != nil {
+			logging.Debugf("RequestHandler::getIndexUsage: Error from GetServiceAddress (mgmt) for node id %v. Error = %v", nid, err)
+			failedNodes = append(failedNodes, addr)
+			continue
+		}
+
+		resp, err = getWithAuth(addr + "/stats?async=true")
+		if err != nil {
+			logging.Debugf("RequestHandler::getIndexUsage: Error while retrieving %v with auth %v", addr+"/stats?async=true", err)
+			failedNodes = append(failedNodes, addr)
+			continue
+		}
+
+		stats := new(common.Statistics)
+		status = convertResponse(resp, stats)
+		resp.Body.Close()
+		if status == RESP_ERROR {
+			logging.Debugf("RequestHandler::getIndexUsage: Error from convertResponse for stats")
+			failedNodes = append(failedNodes, addr)
+			continue
+		}
+
+		for _, defn := range localMeta.IndexDefinitions {
+
+			if len(bucket) != 0 && bucket != defn.Bucket {
+				continue
+			}
+
+			if defn.IsShadow() {
+				continue
+			}
+
+			permission := fmt.Sprintf("cluster.bucket[%s].n1ql.index!list", defn.Bucket)
+			if !isAllowed(creds, []string{permission}, nil) {
+				continue
+			}
+
+			topology := findTopologyByBucket(localMeta.IndexTopologies, defn.Bucket)
+			if topology == nil {
+				continue
+			}
+
+			for _, instance := range topology.GetIndexInstancesByDefn(defn.DefnId) {
+
+				state, _ := topology.GetStatusByInst(defn.DefnId, common.IndexInstId(instance.InstId))
+				if state == common.INDEX_STATE_DELETED || state == common.INDEX_STATE_NIL {
+					continue
+				}
+
+				usage, ok := usages[defn.DefnId]
+				if !ok {
+					usage = &IndexUsage{
+						DefnId:      defn.DefnId,
+						Name:        defn.Name,
+						Bucket:      defn.Bucket,
+						SecExprs:    defn.SecExprs,
+						WhereExpr:   defn.WhereExpr,
+						defn:        defn,
+						numRequests: make(map[common.IndexInstId]int64),
+					}
+					usages[defn.DefnId] = usage
+				}
+
+				if !hasHost(usage.Hosts, curl) {
+					usage.Hosts = append(usage.Hosts, curl)
+				}
+
+				prefix := fmt.Sprintf("%v:%v:", defn.Bucket, common.FormatIndexInstDisplayName(defn.Name, int(instance.ReplicaId)))
+
+				// Each partition of an instance counts the scans of the instance.
+				instId := common.IndexInstId(instance.InstId)
+				if numRequests := getInt64(stats, prefix+"num_requests_total"); numRequests > usage.numRequests[instId] {
+					usage.numRequests[instId] = numRequests
+				}
+
+				usage.MemoryUsed += getInt64(stats, prefix+"memory_used")
+				usage.DiskSize += getInt64(stats, prefix+"disk_size")
+
+				if lastScanTime := getInt64(stats, prefix+"last_query_time"); lastScanTime > usage.lastScanTime {
+					usage.lastScanTime = lastScanTime
+				}
+
+				if since := getInt64(stats, prefix+"stats_since"); since != 0 && (usage.statsSince == 0 || since < usage.statsSince) {
+					usage.statsSince = since
+				}
+			}
+		}
+	}
+
+	result := make([]IndexUsage, 0, len(usages))
+	for _, usage := range usages {
+		for _, numRequests := range usage.numRequests {
+			usage.NumRequests += numRequests
+		}
+		if usage.lastScanTime != 0 {
+			usage.LastScanTime = time.Unix(0, usage.lastScanTime).Format(time.RFC3339)
+		}
+		if usage.statsSince != 0 {
+			usage.StatsSince = time.Unix(0, usage.statsSince).Format(time.RFC3339)
+		}
+		result = append(result, *usage)
+	}
+	sort.Sort(indexUsageSorter(result))
+
+	return result, failedNodes, nil
+}
+
+//
+// An index is unused if it has no scan since cutoff.  An index that has never
+// been scanned is unused only if its stats have been collected since cutoff.
+//
+func findUnusedIndexes(usages []IndexUsage, cutoff time.Time) []IndexUsage {
+
+	result := make([]IndexUsage, 0)
+
+	for _, usage := range usages {
+		if usage.lastScanTime != 0 {
+			if usage.lastScanTime < cutoff.UnixNano() {
+				result = append(result, usage)
+			}
+			continue
+		}
+
+		if usage.statsSince != 0 && usage.statsSince < cutoff.UnixNano() {
+			result = append(result, usage)
+		}
+	}
+
+	return result
+}
+
+//
+// An index is redundant if its keys are a strict leading subset of the keys of
+// another index on the same bucket with the same WHERE clause.
+//
+func findRedundantIndexes(usages []IndexUsage) []RedundantIndex {
+
+	result := make([]RedundantIndex, 0)
+
+	for _, usage := range usages {
+		if usage.defn.IsPrimary {
+			continue
+		}
+
+		for _, other := range usages {
+			if other.DefnId == usage.DefnId || other.defn.IsPrimary {
+				continue
+			}
+
+			if isKeyPrefixOf(&usage.defn, &other.defn) {
+				result = append(result, RedundantIndex{
+					IndexUsage:      usage,
+					CoveredBy:       other.Name,
+					CoveredByDefnId: other.DefnId,
+				})
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+func isKeyPrefixOf(defn *common.IndexDefn, other *common.IndexDefn) bool {
+
+	if defn.Bucket != other.Bucket || defn.WhereExpr != other.WhereExpr {
+		return false
+	}
+
+	if len(defn.SecExprs) >= len(other.SecExprs) {
+		return false
+	}
+
+	for i, expr := range defn.SecExprs {
+		if strings.TrimSpace(expr) != strings.TrimSpace(other.SecExprs[i]) {
+			return false
+		}
+
+		desc := defn.HasDescending() && defn.Desc[i]
+		otherDesc := other.HasDescending() && other.Desc[i]
+		if desc != otherDesc {
+			return false
+		}
+	}
+
+	return true
+}
+
+func hasHost(hosts []string, host string) bool {
+	for _, h := range hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (s indexUsageSorter) Len() int {
+	return len(s)
+}
+
+func (s indexUsageSorter) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s indexUsageSorter) Less(i, j int) bool {
+	if s[i].Bucket != s[j].Bucket {
+		return s[i].Bucket < s[j].Bucket
+	}
+	return s[i].Name < s[j].Name
+}
@@ -0,0 +1,157 @@
+package manager
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestIndexUsage(defnId common.IndexDefnId, name string, secExprs []string, where string) IndexUsage {
+
+	return IndexUsage{
+		DefnId: defnId,
+		Name:   name,
+		Bucket: "default",
+		defn: common.IndexDefn{
+			DefnId:    defnId,
+			Name:      name,
+			Bucket:    "default",
+			SecExprs:  secExprs,
+			WhereExpr: where,
+		},
+	}
+}
+
+func TestFindUnusedIndexes(t *testing.T) {
+
+	now := time.Now()
+	cutoff := now.Add(-24 * time.Hour)
+	before := cutoff.Add(-time.Hour).UnixNano()
+	after := cutoff.Add(time.Hour).UnixNano()
+
+	testcases := []struct {
+		comment      string
+		lastScanTime int64
+		statsSince   int64
+		unused       bool
+	}{
+		{"scanned before cutoff", before, before, true},
+		{"scanned after cutoff", after, before, false},
+		{"never scanned, stats before cutoff", 0, before, true},
+		{"never scanned, stats after cutoff", 0, after, false},
+		{"never scanned, no stats", 0, 0, false},
+	}
+
+	for _, tc := range testcases {
+		usage := newTestIndexUsage(1, "idx", []string{"`age`"}, "")
+		usage.lastScanTime = tc.lastScanTime
+		usage.statsSince = tc.statsSince
+
+		result := findUnusedIndexes([]IndexUsage{usage}, cutoff)
+		if unused := len(result) == 1; unused != tc.unused {
+			t.Errorf("%v: expected unused %v, got %v", tc.comment, tc.unused, unused)
+		}
+	}
+}
+
+func TestIsKeyPrefixOf(t *testing.T) {
+
+	testcases := []struct {
+		comment  string
+		defn     common.IndexDefn
+		other    common.IndexDefn
+		expected bool
+	}{
+		{"leading keys",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			true},
+		{"same keys",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			false},
+		{"more keys",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}},
+			false},
+		{"not leading",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`b`"}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			false},
+		{"spaces ignored",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{" `a` "}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			true},
+		{"different bucket",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}},
+			common.IndexDefn{Bucket: "other", SecExprs: []string{"`a`", "`b`"}},
+			false},
+		{"different where",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}, WhereExpr: "`a` > 1"},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			false},
+		{"same where",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}, WhereExpr: "`a` > 1"},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}, WhereExpr: "`a` > 1"},
+			true},
+		{"different order",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}, Desc: []bool{true}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}},
+			false},
+		{"same order",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}, Desc: []bool{true}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}, Desc: []bool{true, false}},
+			true},
+		{"descending on other key",
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`"}},
+			common.IndexDefn{Bucket: "default", SecExprs: []string{"`a`", "`b`"}, Desc: []bool{false, true}},
+			true},
+	}
+
+	for _, tc := range testcases {
+		if got := isKeyPrefixOf(&tc.defn, &tc.other); got != tc.expected {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expected, got)
+		}
+	}
+}
+
+func TestFindRedundantIndexes(t *testing.T) {
+
+	a := newTestIndexUsage(1, "idx_a", []string{"`a`"}, "")
+	ab := newTestIndexUsage(2, "idx_ab", []string{"`a`", "`b`"}, "")
+	abc := newTestIndexUsage(3, "idx_abc", []string{"`a`", "`b`", "`c`"}, "")
+	b := newTestIndexUsage(4, "idx_b", []string{"`b`"}, "")
+	aWhere := newTestIndexUsage(5, "idx_a_where", []string{"`a`"}, "`a` > 1")
+	primary := newTestIndexUsage(6, "idx_primary", nil, "")
+	primary.defn.IsPrimary = true
+
+	testcases := []struct {
+		comment  string
+		usages   []IndexUsage
+		expected map[string]string // covered by, by index name
+	}{
+		{"no index", nil, map[string]string{}},
+		{"covered", []IndexUsage{a, ab},
+			map[string]string{"idx_a": "idx_ab"}},
+		{"covered by first", []IndexUsage{a, ab, abc},
+			map[string]string{"idx_a": "idx_ab", "idx_ab": "idx_abc"}},
+		{"not covered", []IndexUsage{b, ab},
+			map[string]string{}},
+		{"different where", []IndexUsage{aWhere, ab},
+			map[string]string{}},
+		{"primary", []IndexUsage{primary, a},
+			map[string]string{}},
+	}
+
+	for _, tc := range testcases {
+		got := make(map[string]string)
+		for _, redundant := range findRedundantIndexes(tc.usages) {
+			got[redundant.Name] = redundant.CoveredBy
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expected, got)
+		}
+	}
+}
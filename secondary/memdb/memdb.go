@@ -706,6 +706,53 @@ func (m *MemDB) ptrToItem(itmPtr unsafe.Pointer) *Item {
 	return itm
 }
 
+// rangeSplitItems return items that split the snapshot into about nways
+// ranges of the same number of items, in ascending order. Items are
+// sampled from the skiplist towers, hence the split is approximate.
+func (m *MemDB) rangeSplitItems(snap *Snapshot, nways int) []*Item {
+	var pivotItems []*Item
+
+	tmpIter := m.NewIterator(snap)
+	if tmpIter == nil {
+		panic("iterator cannot be nil")
+	}
+	defer tmpIter.Close()
+
+	barrier := m.store.GetAccesBarrier()
+	token := barrier.Acquire()
+	defer barrier.Release(token)
+
+	pivotPtrs := m.store.GetRangeSplitItems(nways)
+	for _, itmPtr := range pivotPtrs {
+		itm := m.ptrToItem(itmPtr)
+		tmpIter.Seek(itm.Bytes())
+		if tmpIter.Valid() {
+			// Find bigger item than prev pivot
+			if len(pivotItems) == 0 ||
+				m.insCmp(unsafe.Pointer(itm), unsafe.Pointer(pivotItems[len(pivotItems)-1])) > 0 {
+				pivotItems = append(pivotItems, itm)
+			}
+		}
+	}
+
+	return pivotItems
+}
+
+// RangeSplitKeys return keys that split the snapshot into about nways
+// ranges of the same number of items, in ascending order.
+func (m *MemDB) RangeSplitKeys(snap *Snapshot, nways int) [][]byte {
+	if snap == nil {
+		panic("snapshot cannot be nil")
+	}
+
+	items := m.rangeSplitItems(snap, nways)
+	keys := make([][]byte, 0, len(items))
+	for _, itm := range items {
+		keys = append(keys, itm.Bytes())
+	}
+	return keys
+}
+
 func (m *MemDB) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concurrency int) error {
 	var wg sync.WaitGroup
 	var pivotItems []*Item
@@ -716,32 +763,9 @@ func (m *MemDB) Visitor(snap *Snapshot, callb VisitorCallback, shards int, concu
 		panic("snapshot cannot be nil")
 	}
 
-	func() {
-		tmpIter := m.NewIterator(snap)
-		if tmpIter == nil {
-			panic("iterator cannot be nil")
-		}
-		defer tmpIter.Close()
-
-		barrier := m.store.GetAccesBarrier()
-		token := barrier.Acquire()
-		defer barrier.Release(token)
-
-		pivotItems = append(pivotItems, nil) // start item
-		pivotPtrs := m.store.GetRangeSplitItems(shards)
-		for _, itmPtr := range pivotPtrs {
-			itm := m.ptrToItem(itmPtr)
-			tmpIter.Seek(itm.Bytes())
-			if tmpIter.Valid() {
-				prevItm := pivotItems[len(pivotItems)-1]
-				// Find bigger item than prev pivot
-				if prevItm == nil || m.insCmp(unsafe.Pointer(itm), unsafe.Pointer(prevItm)) > 0 {
-					pivotItems = append(pivotItems, itm)
-				}
-			}
-		}
-		pivotItems = append(pivotItems, nil) // end item
-	}()
+	pivotItems = append(pivotItems, nil) // start item
+	pivotItems = append(pivotItems, m.rangeSplitItems(snap, shards)...)
+	pivotItems = append(pivotItems, nil) // end item
 
 	errors := make([]error, len(pivotItems)-1)
 
@@ -522,6 +522,33 @@ func TestVisitorError(t *testing.T) {
 	}
 }
 
+func TestRangeSplitKeys(t *testing.T) {
+	const nways = 8
+	const n = 100000
+	var wg sync.WaitGroup
+	db := NewWithConfig(testConf)
+	defer db.Close()
+
+	wg.Add(1)
+	doInsert(db, &wg, n, false, false)
+	snap, _ := db.NewSnapshot()
+	defer snap.Close()
+
+	keys := db.RangeSplitKeys(snap, nways)
+	if len(keys) == 0 || len(keys) > nways-1 {
+		t.Fatalf("Expected upto %d split keys, got %d", nways-1, len(keys))
+	}
+
+	var prev uint64
+	for i, key := range keys {
+		v := binary.BigEndian.Uint64(key)
+		if i > 0 && v <= prev {
+			t.Errorf("split keys not in order %d <= %d", v, prev)
+		}
+		prev = v
+	}
+}
+
 func doUpdate(db *MemDB, wg *sync.WaitGroup, w *Writer, start, end int, version int) {
 	defer wg.Done()
 	for ; start < end; start++ {
@@ -113,6 +113,11 @@ type IndexSpec struct {
 	Using              string             `json:"using,omitempty"`
 	ExprType           string             `json:"exprType,omitempty"`
 
+	// placement rules
+	NodeLabels         []string `json:"nodeLabels,omitempty"`
+	AntiAffinity       []string `json:"antiAffinity,omitempty"`
+	ColocatePartitions bool     `json:"colocatePartitions,omitempty"`
+
 	// usage
 	NumDoc        uint64  `json:"numDoc,omitempty"`
 	DocKeySize    uint64  `json:"docKeySize,omitempty"`
@@ -124,6 +129,55 @@ type IndexSpec struct {
 	ScanRate      uint64  `json:"scanRate,omitempty"`
 }
 
+// hypothetical topology change for what-if analysis
+type WhatIfSpec struct {
+	AddNodes     []*WhatIfNode     `json:"addNodes,omitempty"`
+	DeleteNodes  []string          `json:"deleteNodes,omitempty"`
+	ServerGroups map[string]string `json:"serverGroups,omitempty"` // nodeId -> server group
+	Replicas     []*WhatIfReplica  `json:"replicas,omitempty"`
+	MemQuota     int64             `json:"memQuota,omitempty"`
+	CpuQuota     int               `json:"cpuQuota,omitempty"`
+	RebuildRate  uint64            `json:"rebuildRate,omitempty"` // bytes per second per node
+	Timeout      int               `json:"timeout,omitempty"`     // seconds
+}
+
+type WhatIfNode struct {
+	NodeId      string `json:"nodeId"`
+	ServerGroup string `json:"serverGroup,omitempty"`
+	MemQuota    uint64 `json:"memQuota,omitempty"`
+	CpuQuota    uint64 `json:"cpuQuota,omitempty"`
+	DiskQuota   uint64 `json:"diskQuota,omitempty"`
+}
+
+type WhatIfReplica struct {
+	Bucket    string `json:"bucket"`
+	Name      string `json:"name"`
+	Increment int    `json:"increment"`
+}
+
+type NodeUsage struct {
+	NodeId      string  `json:"nodeId"`
+	ServerGroup string  `json:"serverGroup,omitempty"`
+	MemUsage    uint64  `json:"memUsage"`
+	CpuUsage    float64 `json:"cpuUsage"`
+	DataSize    uint64  `json:"dataSize"`
+	NumIndex    int     `json:"numIndex"`
+	IsDeleted   bool    `json:"isDeleted,omitempty"`
+	IsNew       bool    `json:"isNew,omitempty"`
+}
+
+type WhatIfResult struct {
+	Placement            []*IndexerNode `json:"placement,omitempty"`
+	Before               []*NodeUsage   `json:"before"`
+	After                []*NodeUsage   `json:"after"`
+	MemQuota             uint64         `json:"memQuota"`
+	CpuQuota             uint64         `json:"cpuQuota"`
+	DataMoved            uint64         `json:"dataMoved"`
+	IndexMoved           uint64         `json:"indexMoved"`
+	EstimatedRebuildTime uint64         `json:"estimatedRebuildTime"` // seconds
+	Violations           *Violations    `json:"violations,omitempty"`
+}
+
 //////////////////////////////////////////////////////////////
 // Integration with Rebalancer
 /////////////////////////////////////////////////////////////
@@ -475,6 +529,190 @@ func ExecuteRetrieveWithOptions(plan *Plan, config *RunConfig) (*Solution, error
 	return solution, nil
 }
 
+//////////////////////////////////////////////////////////////
+// What-If Analysis
+/////////////////////////////////////////////////////////////
+
+//
+// ExecuteWhatIf runs the rebalance planner against a hypothetical topology
+// change of the given plan.   The plan is typically retrieved from the live
+// cluster, but the cluster is never modified.  If the planner cannot satisfy
+// the constraint, the best effort layout is returned along with violations.
+//
+func ExecuteWhatIf(plan *Plan, spec *WhatIfSpec) (*WhatIfResult, error) {
+
+	if plan == nil {
+		return nil, errors.New("missing argument: plan must be present")
+	}
+
+	if spec.MemQuota < 0 || spec.CpuQuota < 0 {
+		return nil, errors.New("memory and cpu quota cannot be negative")
+	}
+
+	// server group change is applied before creating the initial solution
+	for nodeId, group := range spec.ServerGroups {
+		indexer := findIndexerByNodeId(plan.Placement, nodeId)
+		if indexer == nil {
+			return nil, errors.New(fmt.Sprintf("Unable to find indexer node %v", nodeId))
+		}
+		indexer.ServerGroup = group
+	}
+
+	runtime := time.Now()
+
+	config := DefaultRunConfig()
+	config.Resize = false
+	config.UseLive = true
+	config.Runtime = &runtime
+	config.Timeout = spec.Timeout
+	if config.Timeout <= 0 {
+		config.Timeout = DefaultWhatIfTimeout
+	}
+	if spec.MemQuota != 0 {
+		config.MemQuota = spec.MemQuota
+	}
+	if spec.CpuQuota != 0 {
+		config.CpuQuota = spec.CpuQuota
+	}
+
+	rebuildRate := spec.RebuildRate
+	if rebuildRate == 0 {
+		rebuildRate = DefaultRebuildRate
+	}
+
+	sizing := newGeneralSizingMethod()
+	solution, constraint, indexes, _, _ := solutionFromPlan(CommandRebalance, config, sizing, plan)
+
+	// An existing node has its own quota only when its host has less capacity
+	// than the cluster quota.  A what-if quota below it applies to the node.
+	for _, indexer := range solution.Placement {
+		if spec.MemQuota != 0 && indexer.MemQuota >= constraint.GetMemQuota() {
+			indexer.MemQuota = 0
+		}
+		if spec.CpuQuota != 0 && indexer.CpuQuota >= constraint.GetCpuQuota() {
+			indexer.CpuQuota = 0
+		}
+	}
+
+	for _, node := range spec.AddNodes {
+		if solution.findMatchingIndexer(node.NodeId) != nil {
+			return nil, errors.New(fmt.Sprintf("Indexer node %v already exists", node.NodeId))
+		}
+		indexer := newIndexerNode(node.NodeId, sizing)
+		indexer.ServerGroup = node.ServerGroup
+		indexer.MemQuota = node.MemQuota
+		indexer.CpuQuota = node.CpuQuota
+		indexer.DiskQuota = node.DiskQuota
+		solution.Placement = append(solution.Placement, indexer)
+	}
+
+	for _, replica := range spec.Replicas {
+		if replica.Increment <= 0 {
+			return nil, errors.New(fmt.Sprintf("Replica increment for index (%v, %v) must be positive", replica.Bucket, replica.Name))
+		}
+
+		found := false
+		for _, indexer := range solution.Placement {
+			for _, index := range indexer.Indexes {
+				if index.Bucket == replica.Bucket && index.Name == replica.Name && index.Instance != nil {
+					index.Instance.Defn.NumReplica += uint32(replica.Increment)
+					found = true
+				}
+			}
+		}
+
+		if !found {
+			return nil, errors.New(fmt.Sprintf("Unable to find index (%v, %v)", replica.Bucket, replica.Name))
+		}
+	}
+
+	outIndexes, err := changeTopology(config, solution, spec.DeleteNodes)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &WhatIfResult{
+		Before: computeNodeUsages(solution),
+	}
+
+	// same selection of eligible indexes as general rebalancing
+	if len(outIndexes) != 0 {
+		indexes = outIndexes
+	}
+
+	if len(outIndexes) != 0 || solution.findNumEmptyNodes() != 0 {
+		partitioned := findAllPartitionedIndexExcluding(solution, indexes)
+		if len(partitioned) != 0 {
+			indexes = append(indexes, partitioned...)
+		}
+	}
+
+	placement := newRandomPlacement(indexes, config.AllowSwap, false)
+	cost := newUsageBasedCostMethod(constraint, config.DataCostWeight, config.CpuCostWeight, config.MemCostWeight)
+	planner := newSAPlanner(cost, constraint, placement, sizing)
+	planner.SetTimeout(config.Timeout)
+	planner.SetRuntime(config.Runtime)
+
+	final, err := planner.Plan(CommandRebalance, solution)
+	if final == nil || (err != nil && planner.violations == nil) {
+		if err == nil {
+			err = errors.New("Planner fails to find a solution")
+		}
+		return nil, err
+	}
+
+	result.Placement = final.Placement
+	result.After = computeNodeUsages(final)
+	result.MemQuota = constraint.GetMemQuota()
+	result.CpuQuota = constraint.GetCpuQuota()
+	result.Violations = planner.violations
+	result.computeMovement(final, rebuildRate)
+
+	return result, nil
+}
+
+func computeNodeUsages(s *Solution) []*NodeUsage {
+
+	usages := make([]*NodeUsage, 0, len(s.Placement))
+	for _, indexer := range s.Placement {
+		usages = append(usages, &NodeUsage{
+			NodeId:      indexer.NodeId,
+			ServerGroup: indexer.ServerGroup,
+			MemUsage:    indexer.GetMemUsage(s.UseLiveData()),
+			CpuUsage:    indexer.GetCpuUsage(s.UseLiveData()),
+			DataSize:    indexer.GetDataSize(s.UseLiveData()),
+			NumIndex:    len(indexer.Indexes),
+			IsDeleted:   indexer.isDelete,
+			IsNew:       indexer.isNew || (!indexer.isDelete && len(indexer.Indexes) == 0),
+		})
+	}
+
+	return usages
+}
+
+//
+// Compute the data to be built on each node, either moved from another node or
+// newly created replica/partition.  Nodes rebuild in parallel, so the estimated
+// rebuild time is determined by the node receiving the most data.
+//
+func (r *WhatIfResult) computeMovement(s *Solution, rebuildRate uint64) {
+
+	for _, indexer := range s.Placement {
+		dataIn := uint64(0)
+		for _, index := range indexer.Indexes {
+			if index.initialNode == nil || index.initialNode.NodeId != indexer.NodeId {
+				dataIn += index.GetDataSize(s.UseLiveData())
+				r.IndexMoved++
+			}
+		}
+
+		r.DataMoved += dataIn
+		if elapsed := (dataIn + rebuildRate - 1) / rebuildRate; elapsed > r.EstimatedRebuildTime {
+			r.EstimatedRebuildTime = elapsed
+		}
+	}
+}
+
 //////////////////////////////////////////////////////////////
 // Execution
 /////////////////////////////////////////////////////////////
@@ -1075,6 +1313,12 @@ func solutionFromPlan(command CommandType, config *RunConfig, sizing SizingMetho
 	r.calculateSize() // in case sizing formula changes after the plan is saved
 	r.usedReplicaIdMap = plan.UsedReplicaIdMap
 
+	// The quota factor applies to the quota of a node as well
+	for _, indexer := range r.Placement {
+		indexer.MemQuota = uint64(float64(indexer.MemQuota) * memQuotaFactor)
+		indexer.CpuQuota = uint64(float64(indexer.CpuQuota) * cpuQuotaFactor)
+	}
+
 	if shuffle != 0 {
 		placement := newRandomPlacement(indexes, config.AllowSwap, false)
 		movedIndex, movedData = placement.randomMoveNoConstraint(r, shuffle)
@@ -1378,6 +1622,9 @@ func indexUsageFromSpec(sizing SizingMethod, spec *IndexSpec) ([]*IndexUsage, er
 			index.Instance.Defn.ArrSize = spec.ArrSize
 			index.Instance.Defn.ResidentRatio = spec.ResidentRatio
 			index.Instance.Defn.ExprType = common.ExprType(spec.ExprType)
+			index.Instance.Defn.NodeLabels = spec.NodeLabels
+			index.Instance.Defn.AntiAffinity = spec.AntiAffinity
+			index.Instance.Defn.ColocatePartitions = spec.ColocatePartitions
 			if index.Instance.Defn.ResidentRatio == 0 {
 				index.Instance.Defn.ResidentRatio = 100
 			}
@@ -0,0 +1,223 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package planner
+
+import (
+	"testing"
+)
+
+// 4 nodes, 2 identical index on each node
+const whatIfPlan = "../tests/testdata/planner/plan/identical-8-0.json"
+
+func readWhatIfPlan(t *testing.T) *Plan {
+
+	plan, err := ReadPlan(whatIfPlan)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return plan
+}
+
+func findNodeUsage(usages []*NodeUsage, nodeId string) *NodeUsage {
+
+	for _, usage := range usages {
+		if usage.NodeId == nodeId {
+			return usage
+		}
+	}
+
+	return nil
+}
+
+func countIndexes(usages []*NodeUsage) int {
+
+	count := 0
+	for _, usage := range usages {
+		count += usage.NumIndex
+	}
+
+	return count
+}
+
+func TestWhatIfAddNode(t *testing.T) {
+
+	plan := readWhatIfPlan(t)
+
+	spec := &WhatIfSpec{
+		AddNodes: []*WhatIfNode{{NodeId: "new1"}, {NodeId: "new2"}, {NodeId: "new3"}, {NodeId: "new4"}},
+		Timeout:  10,
+	}
+
+	result, err := ExecuteWhatIf(plan, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Violations != nil {
+		t.Fatalf("unexpected violations %v", result.Violations.Error())
+	}
+
+	if len(result.Before) != 8 || len(result.After) != 8 {
+		t.Fatalf("expected 8 nodes before and after, got %v and %v", len(result.Before), len(result.After))
+	}
+
+	if countIndexes(result.Before) != 8 || countIndexes(result.After) != 8 {
+		t.Fatalf("expected 8 indexes before and after, got %v and %v", countIndexes(result.Before), countIndexes(result.After))
+	}
+
+	for _, node := range spec.AddNodes {
+		before := findNodeUsage(result.Before, node.NodeId)
+		if before == nil || !before.IsNew || before.NumIndex != 0 {
+			t.Errorf("expected new node %v to be empty before, got %+v", node.NodeId, before)
+		}
+
+		after := findNodeUsage(result.After, node.NodeId)
+		if after == nil || after.NumIndex == 0 || after.MemUsage == 0 {
+			t.Errorf("expected new node %v to have indexes after, got %+v", node.NodeId, after)
+		}
+	}
+
+	// every new node receives at least one index
+	if result.IndexMoved < 4 || result.DataMoved == 0 || result.EstimatedRebuildTime == 0 {
+		t.Errorf("unexpected movement: index %v data %v time %v",
+			result.IndexMoved, result.DataMoved, result.EstimatedRebuildTime)
+	}
+}
+
+func TestWhatIfRemoveNode(t *testing.T) {
+
+	plan := readWhatIfPlan(t)
+	removed := plan.Placement[0].NodeId
+	numRemoved := len(plan.Placement[0].Indexes)
+
+	spec := &WhatIfSpec{
+		DeleteNodes: []string{removed},
+		MemQuota:    2 * 1024 * 1024 * 1024,
+		CpuQuota:    8,
+		RebuildRate: 1024,
+		Timeout:     10,
+	}
+
+	result, err := ExecuteWhatIf(plan, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Violations != nil {
+		t.Fatalf("unexpected violations %v", result.Violations.Error())
+	}
+
+	if result.MemQuota != uint64(spec.MemQuota) || result.CpuQuota != uint64(spec.CpuQuota) {
+		t.Errorf("expected quota %v %v, got %v %v", spec.MemQuota, spec.CpuQuota, result.MemQuota, result.CpuQuota)
+	}
+
+	before := findNodeUsage(result.Before, removed)
+	if before == nil || !before.IsDeleted || before.NumIndex != numRemoved {
+		t.Fatalf("expected removed node to hold %v indexes before, got %+v", numRemoved, before)
+	}
+
+	// removed node is dropped from the layout once it is emptied
+	if after := findNodeUsage(result.After, removed); after != nil && after.NumIndex != 0 {
+		t.Fatalf("expected removed node to be empty after, got %+v", after)
+	}
+
+	if countIndexes(result.After) != 8 {
+		t.Fatalf("expected 8 indexes after, got %v", countIndexes(result.After))
+	}
+
+	if result.IndexMoved < uint64(numRemoved) || result.DataMoved == 0 {
+		t.Errorf("expected indexes of removed node to move, got index %v data %v", result.IndexMoved, result.DataMoved)
+	}
+
+	// nodes rebuild in parallel at 1KB per second
+	if result.EstimatedRebuildTime == 0 || result.EstimatedRebuildTime > (result.DataMoved+1023)/1024 {
+		t.Errorf("unexpected rebuild time %v for %v bytes", result.EstimatedRebuildTime, result.DataMoved)
+	}
+}
+
+func TestWhatIfNodeQuota(t *testing.T) {
+
+	const gb = 1024 * 1024 * 1024
+
+	plan := readWhatIfPlan(t)
+	small := plan.Placement[1].NodeId
+
+	// a node has its own quota only when its host has less capacity than the cluster quota
+	plan.Placement[0].MemQuota = 4 * gb
+	plan.Placement[0].CpuQuota = 16
+	plan.Placement[1].MemQuota = 1 * gb
+	plan.Placement[1].CpuQuota = 4
+
+	spec := &WhatIfSpec{
+		MemQuota: 2 * gb,
+		CpuQuota: 8,
+		Timeout:  10,
+	}
+
+	result, err := ExecuteWhatIf(plan, spec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, indexer := range result.Placement {
+		var memQuota, cpuQuota uint64
+		if indexer.NodeId == small {
+			memQuota, cpuQuota = 1*gb, 4
+		}
+		if indexer.MemQuota != memQuota || indexer.CpuQuota != cpuQuota {
+			t.Errorf("node %v: expected quota %v %v, got %v %v",
+				indexer.NodeId, memQuota, cpuQuota, indexer.MemQuota, indexer.CpuQuota)
+		}
+	}
+}
+
+func TestNodeQuotaFactor(t *testing.T) {
+
+	plan := readWhatIfPlan(t)
+	plan.Placement[0].MemQuota = 1000
+	plan.Placement[0].CpuQuota = 4
+
+	config := DefaultRunConfig()
+	config.MemQuotaFactor = 2
+	config.CpuQuotaFactor = 1.5
+
+	solution, _, _, _, _ := solutionFromPlan(CommandPlan, config, newGeneralSizingMethod(), plan)
+
+	indexer := solution.findMatchingIndexer(plan.Placement[0].NodeId)
+	if indexer == nil || indexer.MemQuota != 2000 || indexer.CpuQuota != 6 {
+		t.Errorf("expected node quota 2000 6, got %+v", indexer)
+	}
+	if plan.Placement[0].MemQuota != 1000 {
+		t.Errorf("expected plan to be unchanged, got %v", plan.Placement[0].MemQuota)
+	}
+}
+
+func TestWhatIfInvalidSpec(t *testing.T) {
+
+	plan := readWhatIfPlan(t)
+
+	if _, err := ExecuteWhatIf(plan, &WhatIfSpec{DeleteNodes: []string{"unknown"}, Timeout: 1}); err == nil {
+		t.Errorf("expected error removing unknown node")
+	}
+
+	existing := plan.Placement[0].NodeId
+	if _, err := ExecuteWhatIf(plan, &WhatIfSpec{AddNodes: []*WhatIfNode{{NodeId: existing}}, Timeout: 1}); err == nil {
+		t.Errorf("expected error adding existing node")
+	}
+
+	if _, err := ExecuteWhatIf(plan, &WhatIfSpec{MemQuota: -1}); err == nil {
+		t.Errorf("expected error for negative quota")
+	}
+
+	if _, err := ExecuteWhatIf(nil, &WhatIfSpec{}); err == nil {
+		t.Errorf("expected error without plan")
+	}
+}
@@ -56,6 +56,12 @@ const (
 	MOIScanTimeout                = 120
 )
 
+// constant - what-if analysis
+const (
+	DefaultRebuildRate   uint64 = 20 * 1024 * 1024 // bytes per second per node
+	DefaultWhatIfTimeout int    = 60               // seconds
+)
+
 // constant - command
 type CommandType string
 
@@ -74,11 +80,13 @@ const (
 	NoViolation          ViolationCode = "NoViolation"
 	MemoryViolation                    = "MemoryViolation"
 	CpuViolation                       = "CpuViolation"
+	DiskViolation                      = "DiskViolation"
 	ReplicaViolation                   = "ReplicaViolation"
 	EquivIndexViolation                = "EquivIndexViolation"
 	ServerGroupViolation               = "ServerGroupViolation"
 	DeleteNodeViolation                = "DeleteNodeViolation"
 	ExcludeNodeViolation               = "ExcludeNodeViolation"
+	PlacementRuleViolation             = "PlacementRuleViolation"
 )
 
 //////////////////////////////////////////////////////////////
@@ -158,6 +166,14 @@ type IndexerNode struct {
 	ServerGroup string `json:"serverGroup,omitempty"`
 	StorageMode string `json:"storageMode,omitempty"`
 
+	// input: node labels for placement rule
+	Labels []string `json:"labels,omitempty"`
+
+	// input: node capacity.  If not set, the cluster-wide quota is used.
+	MemQuota  uint64 `json:"memQuota,omitempty"`
+	CpuQuota  uint64 `json:"cpuQuota,omitempty"`
+	DiskQuota uint64 `json:"diskQuota,omitempty"`
+
 	// input/output: resource consumption (from sizing)
 	MemUsage    uint64  `json:"memUsage"`
 	CpuUsage    float64 `json:"cpuUsage"`
@@ -339,6 +355,9 @@ type SAPlanner struct {
 	StartTemp       float64   `json:"startTemp,omitempty"`
 	StartScore      float64   `json:"startScore,omitempty"`
 	Try             uint64    `json:"try,omitempty"`
+
+	// violations from the last run, if planner fails to satisfy constraint
+	violations *Violations
 }
 
 //////////////////////////////////////////////////////////////
@@ -526,6 +545,7 @@ func (p *SAPlanner) Plan(command CommandType, solution *Solution) (*Solution, er
 		err = p.Validate(solution)
 		if err == nil {
 			result, err, violations = p.planSingleRun(command, solution)
+			p.violations = violations
 
 			if violations == nil {
 				return result, err
@@ -1195,7 +1215,7 @@ func (p *SAPlanner) addReplicaIfNecessary(s *Solution) {
 			if index.Instance != nil && int(index.Instance.Defn.NumReplica+1) > numReplica &&
 				numReplica < numLiveNode && !index.pendingDelete {
 
-				targets := s.FindIndexerWithNoReplica(index)
+				targets := s.filterByPlacementRule(s.FindIndexerWithNoReplica(index), index)
 				if len(targets) == 0 && !indexer.ExcludeAny(s) {
 					targets = []*IndexerNode{indexer}
 				}
@@ -1314,8 +1334,11 @@ func (p *SAPlanner) addPartitionIfNecessary(s *Solution) {
 				// repair only if there is no replica, otherwise, replica repair would have handle this.
 				if s.findNumReplica(cloned) == 0 {
 
-					n := rand.Intn(len(available))
-					indexer := available[n]
+					indexer := s.findColocatedNode(cloned)
+					if indexer == nil || indexer.ExcludeAny(s) {
+						targets := s.filterByPlacementRule(available, cloned)
+						indexer = targets[rand.Intn(len(targets))]
+					}
 
 					// add the new partition to the solution
 					s.addIndex(indexer, cloned, false)
@@ -1771,14 +1794,14 @@ func (s *Solution) ComputeMemUsage() (float64, float64) {
 	// Compute mean memory usage
 	var meanMemUsage float64
 	for _, indexerUsage := range s.Placement {
-		meanMemUsage += float64(indexerUsage.GetMemUsage(s.UseLiveData()))
+		meanMemUsage += s.getMemUtilization(indexerUsage)
 	}
 	meanMemUsage = meanMemUsage / float64(len(s.Placement))
 
 	// compute memory variance
 	var varianceMemUsage float64
 	for _, indexerUsage := range s.Placement {
-		v := float64(indexerUsage.GetMemUsage(s.UseLiveData())) - meanMemUsage
+		v := s.getMemUtilization(indexerUsage) - meanMemUsage
 		varianceMemUsage += v * v
 	}
 	varianceMemUsage = varianceMemUsage / float64(len(s.Placement))
@@ -1797,14 +1820,14 @@ func (s *Solution) ComputeCpuUsage() (float64, float64) {
 	// Compute mean cpu usage
 	var meanCpuUsage float64
 	for _, indexerUsage := range s.Placement {
-		meanCpuUsage += float64(indexerUsage.GetCpuUsage(s.UseLiveData()))
+		meanCpuUsage += s.getCpuUtilization(indexerUsage)
 	}
 	meanCpuUsage = meanCpuUsage / float64(len(s.Placement))
 
 	// compute cpu variance
 	var varianceCpuUsage float64
 	for _, indexerUsage := range s.Placement {
-		v := float64(indexerUsage.GetCpuUsage(s.UseLiveData())) - meanCpuUsage
+		v := s.getCpuUtilization(indexerUsage) - meanCpuUsage
 		varianceCpuUsage += v * v
 	}
 	varianceCpuUsage = varianceCpuUsage / float64(len(s.Placement))
@@ -1849,14 +1872,14 @@ func (s *Solution) ComputeDrainRate() (float64, float64) {
 	// Compute mean drain rate
 	var meanDrainRate float64
 	for _, indexerUsage := range s.Placement {
-		meanDrainRate += float64(indexerUsage.GetDrainRate(s.UseLiveData()))
+		meanDrainRate += float64(indexerUsage.GetDrainRate(s.UseLiveData())) / s.cpuCapacityRatio(indexerUsage)
 	}
 	meanDrainRate = meanDrainRate / float64(len(s.Placement))
 
 	// compute drain rate variance
 	var varianceDrainRate float64
 	for _, indexerUsage := range s.Placement {
-		v := float64(indexerUsage.GetDrainRate(s.UseLiveData())) - meanDrainRate
+		v := float64(indexerUsage.GetDrainRate(s.UseLiveData()))/s.cpuCapacityRatio(indexerUsage) - meanDrainRate
 		varianceDrainRate += v * v
 	}
 	varianceDrainRate = varianceDrainRate / float64(len(s.Placement))
@@ -1875,14 +1898,14 @@ func (s *Solution) ComputeScanRate() (float64, float64) {
 	// Compute mean scan rate
 	var meanScanRate float64
 	for _, indexerUsage := range s.Placement {
-		meanScanRate += float64(indexerUsage.GetScanRate(s.UseLiveData()))
+		meanScanRate += float64(indexerUsage.GetScanRate(s.UseLiveData())) / s.cpuCapacityRatio(indexerUsage)
 	}
 	meanScanRate = meanScanRate / float64(len(s.Placement))
 
 	// compute scan rate variance
 	var varianceScanRate float64
 	for _, indexerUsage := range s.Placement {
-		v := float64(indexerUsage.GetScanRate(s.UseLiveData())) - meanScanRate
+		v := float64(indexerUsage.GetScanRate(s.UseLiveData()))/s.cpuCapacityRatio(indexerUsage) - meanScanRate
 		varianceScanRate += v * v
 	}
 	varianceScanRate = varianceScanRate / float64(len(s.Placement))
@@ -1937,14 +1960,15 @@ func (s *Solution) ComputeCapacityAfterEmptyIndex() float64 {
 	var emptyIdxCnt uint64
 	var totalIdxCnt uint64
 	var emptyIdxMem uint64
+	var maxTotal uint64
 
 	for _, indexer := range s.Placement {
 		emptyIdxMem += indexer.computeFreeMemPerEmptyIndex(s, max, 0) * uint64(indexer.numEmptyIndex)
 		emptyIdxCnt += uint64(indexer.numEmptyIndex)
 		totalIdxCnt += uint64(len(indexer.Indexes))
+		maxTotal += uint64(float64(max) * s.memCapacityRatio(indexer))
 	}
 
-	maxTotal := max * uint64(len(s.Placement))
 	usageAfterEmptyIdx := float64(maxTotal-emptyIdxMem) / float64(maxTotal)
 	weight := float64(emptyIdxCnt) / float64(totalIdxCnt)
 
@@ -1959,14 +1983,14 @@ func (s *Solution) ComputeDataSize() (float64, float64) {
 	// Compute mean data size
 	var meanDataSize float64
 	for _, indexerUsage := range s.Placement {
-		meanDataSize += float64(indexerUsage.GetDataSize(s.UseLiveData()))
+		meanDataSize += s.getDataUtilization(indexerUsage)
 	}
 	meanDataSize = meanDataSize / float64(len(s.Placement))
 
 	// compute data size variance
 	var varianceDataSize float64
 	for _, indexerUsage := range s.Placement {
-		v := float64(indexerUsage.GetDataSize(s.UseLiveData())) - meanDataSize
+		v := s.getDataUtilization(indexerUsage) - meanDataSize
 		varianceDataSize += v * v
 	}
 	varianceDataSize = varianceDataSize / float64(len(s.Placement))
@@ -2805,8 +2829,9 @@ retry1:
 		}
 
 		// Do not use Cpu for estimation for now since cpu measurement is fluctuating
-		freeMem := max - float64(indexer.GetMemTotal(s.UseLiveData()))
-		freeMemRatio := freeMem / float64(max)
+		nodeMax := max * s.memCapacityRatio(indexer)
+		freeMem := nodeMax - float64(indexer.GetMemTotal(s.UseLiveData()))
+		freeMemRatio := freeMem / nodeMax
 		if freeMem > 0 && freeMemRatio > threshold {
 			// freeMem is a positive number
 			adjFreeMem := uint64(freeMem * 0.8)
@@ -2929,7 +2954,7 @@ func (s *Solution) computeUsageRatio(indexer *IndexerNode) float64 {
 	scanCost := float64(0)
 
 	if s.memMean != 0 {
-		memCost = float64(indexer.GetMemUsage(s.UseLiveData())) / s.memMean
+		memCost = s.getMemUtilization(indexer) / s.memMean
 	}
 
 	//if s.cpuMean != 0 {
@@ -2937,7 +2962,7 @@ func (s *Solution) computeUsageRatio(indexer *IndexerNode) float64 {
 	//}
 
 	if s.dataMean != 0 {
-		dataCost = float64(indexer.GetDataSize(s.UseLiveData())) / s.dataMean
+		dataCost = s.getDataUtilization(indexer) / s.dataMean
 	}
 
 	if s.diskMean != 0 {
@@ -2945,17 +2970,89 @@ func (s *Solution) computeUsageRatio(indexer *IndexerNode) float64 {
 	}
 
 	if s.drainMean != 0 {
-		drainCost = float64(indexer.GetDrainRate(s.UseLiveData())) / s.drainMean
+		drainCost = float64(indexer.GetDrainRate(s.UseLiveData())) / s.cpuCapacityRatio(indexer) / s.drainMean
 	}
 
 	if s.scanMean != 0 {
-		scanCost = float64(indexer.GetScanRate(s.UseLiveData())) / s.scanMean
+		scanCost = float64(indexer.GetScanRate(s.UseLiveData())) / s.cpuCapacityRatio(indexer) / s.scanMean
 	}
 
 	//return (memCost + cpuCost + dataCost + diskCost + drainCost + scanCost) / 6
 	return (memCost + dataCost + diskCost + drainCost + scanCost) / 5
 }
 
+//
+// Return the memory capacity of an indexer node relative to the cluster-wide
+// memory quota.  Resource usage of a node is divided by its capacity ratio,
+// so that nodes of different sizes are balanced by utilization rather than
+// by absolute usage.   If all nodes have the same capacity, the ratio is 1.
+//
+func (s *Solution) memCapacityRatio(indexer *IndexerNode) float64 {
+
+	quota := s.constraint.GetMemQuota()
+	if indexer.MemQuota == 0 || quota == 0 {
+		return 1
+	}
+
+	return float64(indexer.MemQuota) / float64(quota)
+}
+
+//
+// Return the cpu capacity of an indexer node relative to the cluster-wide cpu quota.
+//
+func (s *Solution) cpuCapacityRatio(indexer *IndexerNode) float64 {
+
+	quota := s.constraint.GetCpuQuota()
+	if indexer.CpuQuota == 0 || quota == 0 {
+		return 1
+	}
+
+	return float64(indexer.CpuQuota) / float64(quota)
+}
+
+//
+// Return the disk capacity of an indexer node relative to the average disk
+// capacity.  There is no cluster-wide disk quota.  If any node does not have
+// disk capacity, disk capacity is ignored.
+//
+func (s *Solution) diskCapacityRatio(indexer *IndexerNode) float64 {
+
+	if indexer.DiskQuota == 0 {
+		return 1
+	}
+
+	var total uint64
+	for _, indexer2 := range s.Placement {
+		if indexer2.DiskQuota == 0 {
+			return 1
+		}
+		total += indexer2.DiskQuota
+	}
+
+	return float64(indexer.DiskQuota) * float64(len(s.Placement)) / float64(total)
+}
+
+//
+// Memory usage of a node normalized by its memory capacity
+//
+func (s *Solution) getMemUtilization(indexer *IndexerNode) float64 {
+	return float64(indexer.GetMemUsage(s.UseLiveData())) / s.memCapacityRatio(indexer)
+}
+
+//
+// Cpu usage of a node normalized by its cpu capacity
+//
+func (s *Solution) getCpuUtilization(indexer *IndexerNode) float64 {
+	return indexer.GetCpuUsage(s.UseLiveData()) / s.cpuCapacityRatio(indexer)
+}
+
+//
+// Data size of a node normalized by its disk capacity
+//
+func (s *Solution) getDataUtilization(indexer *IndexerNode) float64 {
+	return float64(indexer.GetDataSize(s.UseLiveData())) / s.diskCapacityRatio(indexer)
+}
+
 //
 // compute average mean usage
 // 1) memory usage
@@ -2971,12 +3068,17 @@ func (s *Solution) computeMeanUsageRatio() float64 {
 	return 5
 }
 
+//
+// Compute the max memory usage of all nodes.  Memory usage of each node is
+// scaled to the cluster-wide memory quota.
+//
 func (s *Solution) computeMaxMemUsage() uint64 {
 
 	max := uint64(0)
 	for _, indexer := range s.Placement {
-		if indexer.GetMemTotal(s.UseLiveData()) > max {
-			max = indexer.GetMemTotal(s.UseLiveData())
+		usage := uint64(float64(indexer.GetMemTotal(s.UseLiveData())) / s.memCapacityRatio(indexer))
+		if usage > max {
+			max = usage
 		}
 	}
 
@@ -3043,9 +3145,16 @@ func (c *IndexerConstraint) Validate(s *Solution) error {
 		}
 	}
 
-	if totalIndexMem > (c.MemQuota * uint64(s.findNumLiveNode())) {
+	var totalMemQuota uint64
+	for _, indexer := range s.Placement {
+		if !indexer.isDelete {
+			totalMemQuota += indexer.GetMemQuota(c)
+		}
+	}
+
+	if totalIndexMem > totalMemQuota {
 		return errors.New(fmt.Sprintf("Total memory usage of all indexes (%v) exceed aggregated memory quota of all indexer nodes (%v)",
-			totalIndexMem, (c.MemQuota * uint64(s.findNumLiveNode()))))
+			totalIndexMem, totalMemQuota))
 	}
 
 	/*
@@ -3094,6 +3203,11 @@ func (c *IndexerConstraint) GetViolations(s *Solution, eligibles map[*IndexUsage
 						CpuUsage: index.GetCpuUsage(s.UseLiveData()),
 						Details:  nil}
 
+					if !s.SatisfyPlacementRule(indexer, index, true) {
+						err := fmt.Sprintf("Violates placement rule at %v: %v", indexer.NodeId, PlacementRuleViolation)
+						violation.Details = append(violation.Details, err)
+					}
+
 					// If this indexer node has a placeable index, then check if the
 					// index can be moved to other nodes.
 					for _, indexer2 := range s.Placement {
@@ -3146,6 +3260,11 @@ func (c *IndexerConstraint) acceptViolation(s *Solution, index *IndexUsage, inde
 		return false
 	}
 
+	// placement rule must be reported even if the index stays in the same node.
+	if !s.SatisfyPlacementRule(indexer, index, true) {
+		return true
+	}
+
 	// if cannot load balance, don't report error.
 	if index.initialNode != nil && index.initialNode.NodeId == indexer.NodeId && !indexer.IsDeleted() {
 		return false
@@ -3168,6 +3287,26 @@ func (c *IndexerConstraint) GetCpuQuota() uint64 {
 	return c.CpuQuota
 }
 
+//
+// Get the memory and cpu quota of a node, after applying
+// maximum memory and cpu utilization.
+//
+func (c *IndexerConstraint) getNodeQuota(n *IndexerNode) (uint64, float64) {
+
+	memQuota := n.GetMemQuota(c)
+	cpuQuota := float64(n.GetCpuQuota(c))
+
+	if c.MaxMemUse != -1 {
+		memQuota = memQuota * uint64(c.MaxMemUse) / 100
+	}
+
+	if c.MaxCpuUse != -1 {
+		cpuQuota = cpuQuota * float64(c.MaxCpuUse) / 100
+	}
+
+	return memQuota, cpuQuota
+}
+
 //
 // Allow Add Node
 //
@@ -3234,25 +3373,25 @@ func (c *IndexerConstraint) CanAddIndex(s *Solution, n *IndexerNode, u *IndexUsa
 		return ServerGroupViolation
 	}
 
-	if s.ignoreResourceConstraint() {
-		return NoViolation
+	// Co-located partitions on the same node are moved along with the index.
+	if !s.SatisfyPlacementRule(n, u, false) {
+		return PlacementRuleViolation
 	}
 
-	memQuota := c.MemQuota
-	cpuQuota := float64(c.CpuQuota)
-
-	if c.MaxMemUse != -1 {
-		memQuota = memQuota * uint64(c.MaxMemUse) / 100
+	if s.ignoreResourceConstraint() {
+		return NoViolation
 	}
 
-	if c.MaxCpuUse != -1 {
-		cpuQuota = cpuQuota * float64(c.MaxCpuUse) / 100
-	}
+	memQuota, _ := c.getNodeQuota(n)
 
 	if u.GetMemTotal(s.UseLiveData())+n.GetMemTotal(s.UseLiveData()) > memQuota {
 		return MemoryViolation
 	}
 
+	if diskQuota := n.GetDiskQuota(); diskQuota != 0 && u.GetDataSize(s.UseLiveData())+n.GetDataSize(s.UseLiveData()) > diskQuota {
+		return DiskViolation
+	}
+
 	/*
 		if u.GetCpuUsage(s.UseLiveData())+n.GetCpuUsage(s.UseLiveData()) > cpuQuota {
 			return CpuViolation
@@ -3294,25 +3433,26 @@ func (c *IndexerConstraint) CanSwapIndex(sol *Solution, n *IndexerNode, s *Index
 		return ServerGroupViolation
 	}
 
-	if sol.ignoreResourceConstraint() {
-		return NoViolation
+	// Co-located partitions are not swapped along with the index.
+	if !sol.SatisfyPlacementRule(n, s, true) {
+		return PlacementRuleViolation
 	}
 
-	memQuota := c.MemQuota
-	cpuQuota := float64(c.CpuQuota)
-
-	if c.MaxMemUse != -1 {
-		memQuota = memQuota * uint64(c.MaxMemUse) / 100
+	if sol.ignoreResourceConstraint() {
+		return NoViolation
 	}
 
-	if c.MaxCpuUse != -1 {
-		cpuQuota = cpuQuota * float64(c.MaxCpuUse) / 100
-	}
+	memQuota, _ := c.getNodeQuota(n)
 
 	if s.GetMemTotal(sol.UseLiveData())+n.GetMemTotal(sol.UseLiveData())-t.GetMemTotal(sol.UseLiveData()) > memQuota {
 		return MemoryViolation
 	}
 
+	if diskQuota := n.GetDiskQuota(); diskQuota != 0 &&
+		s.GetDataSize(sol.UseLiveData())+n.GetDataSize(sol.UseLiveData())-t.GetDataSize(sol.UseLiveData()) > diskQuota {
+		return DiskViolation
+	}
+
 	/*
 		if s.GetCpuUsage(sol.UseLiveData())+n.GetCpuUsage(sol.UseLiveData())-t.GetCpuUsage(sol.UseLiveData()) > cpuQuota {
 			return CpuViolation
@@ -3331,18 +3471,13 @@ func (c *IndexerConstraint) SatisfyNodeResourceConstraint(s *Solution, n *Indexe
 		return true
 	}
 
-	memQuota := c.MemQuota
-	cpuQuota := float64(c.CpuQuota)
-
-	if c.MaxMemUse != -1 {
-		memQuota = memQuota * uint64(c.MaxMemUse) / 100
-	}
+	memQuota, _ := c.getNodeQuota(n)
 
-	if c.MaxCpuUse != -1 {
-		cpuQuota = cpuQuota * float64(c.MaxCpuUse) / 100
+	if n.GetMemTotal(s.UseLiveData()) > memQuota {
+		return false
 	}
 
-	if n.GetMemTotal(s.UseLiveData()) > memQuota {
+	if diskQuota := n.GetDiskQuota(); diskQuota != 0 && n.GetDataSize(s.UseLiveData()) > diskQuota {
 		return false
 	}
 
@@ -3412,6 +3547,11 @@ func (c *IndexerConstraint) SatisfyIndexHAConstraintAt(s *Solution, n *IndexerNo
 		return false
 	}
 
+	// Does the index follow its placement rule?
+	if isEligibleIndex(source, eligibles) && !s.SatisfyPlacementRule(n, source, true) {
+		return false
+	}
+
 	return true
 }
 
@@ -3424,26 +3564,10 @@ func (c *IndexerConstraint) SatisfyClusterResourceConstraint(s *Solution) bool {
 		return true
 	}
 
-	memQuota := c.MemQuota
-	cpuQuota := float64(c.CpuQuota)
-
-	if c.MaxMemUse != -1 {
-		memQuota = memQuota * uint64(c.MaxMemUse) / 100
-	}
-
-	if c.MaxCpuUse != -1 {
-		cpuQuota = cpuQuota * float64(c.MaxCpuUse) / 100
-	}
-
 	for _, indexer := range s.Placement {
-		if indexer.GetMemTotal(s.UseLiveData()) > memQuota {
+		if !c.SatisfyNodeResourceConstraint(s, indexer) {
 			return false
 		}
-		/*
-			if indexer.GetCpuUsage(s.UseLiveData()) > cpuQuota {
-				return false
-			}
-		*/
 	}
 
 	return true
@@ -3578,6 +3702,10 @@ func (o *IndexerNode) clone() *IndexerNode {
 	r.RestUrl = o.RestUrl
 	r.ServerGroup = o.ServerGroup
 	r.StorageMode = o.StorageMode
+	r.Labels = o.Labels
+	r.MemQuota = o.MemQuota
+	r.CpuQuota = o.CpuQuota
+	r.DiskQuota = o.DiskQuota
 	r.MemUsage = o.MemUsage
 	r.MemOverhead = o.MemOverhead
 	r.DataSize = o.DataSize
@@ -3626,12 +3754,45 @@ func (o *IndexerNode) String() string {
 //
 func (o *IndexerNode) freeUsage(s *Solution, constraint ConstraintMethod) (uint64, float64) {
 
-	freeMem := constraint.GetMemQuota() - o.GetMemTotal(s.UseLiveData())
-	freeCpu := float64(constraint.GetCpuQuota()) - o.GetCpuUsage(s.UseLiveData())
+	freeMem := o.GetMemQuota(constraint) - o.GetMemTotal(s.UseLiveData())
+	freeCpu := float64(o.GetCpuQuota(constraint)) - o.GetCpuUsage(s.UseLiveData())
 
 	return freeMem, freeCpu
 }
 
+//
+// Get memory quota of this node.  If the node does not have its
+// own memory quota, the cluster-wide memory quota is returned.
+//
+func (o *IndexerNode) GetMemQuota(constraint ConstraintMethod) uint64 {
+
+	if o.MemQuota != 0 {
+		return o.MemQuota
+	}
+
+	return constraint.GetMemQuota()
+}
+
+//
+// Get cpu quota of this node.  If the node does not have its
+// own cpu quota, the cluster-wide cpu quota is returned.
+//
+func (o *IndexerNode) GetCpuQuota(constraint ConstraintMethod) uint64 {
+
+	if o.CpuQuota != 0 {
+		return o.CpuQuota
+	}
+
+	return constraint.GetCpuQuota()
+}
+
+//
+// Get disk capacity of this node.  Return 0 if disk capacity is unknown.
+//
+func (o *IndexerNode) GetDiskQuota() uint64 {
+	return o.DiskQuota
+}
+
 //
 // Get cpu usage
 //
@@ -4012,6 +4173,9 @@ func (o *IndexerNode) computeFreeMemPerEmptyIndex(s *Solution, maxThreshold uint
 
 	memPerEmpIndex := uint64(0)
 
+	// threshold is relative to the cluster-wide quota
+	maxThreshold = uint64(float64(maxThreshold) * s.memCapacityRatio(o))
+
 	if maxThreshold > o.GetMemTotal(s.UseLiveData()) {
 		freeMem := maxThreshold - o.GetMemTotal(s.UseLiveData())
 
@@ -4815,23 +4979,29 @@ func (p *RandomPlacement) Validate(s *Solution) error {
 		return nil
 	}
 
+	// An index must fit in the largest node
 	memQuota := s.getConstraintMethod().GetMemQuota()
 	//cpuQuota := float64(s.getConstraintMethod().GetCpuQuota())
+	for _, indexer := range s.Placement {
+		if !indexer.isDelete && indexer.MemQuota > memQuota {
+			memQuota = indexer.MemQuota
+		}
+	}
 
 	for index, _ := range p.indexes {
 
 		//if index.GetMemTotal(s.UseLiveData()) > memQuota || index.GetCpuUsage(s.UseLiveData()) > cpuQuota {
 		if index.GetMemTotal(s.UseLiveData()) > memQuota {
 			return errors.New(fmt.Sprintf("Index exceeding quota. Index=%v Bucket=%v Memory=%v Cpu=%.4f MemoryQuota=%v CpuQuota=%v",
-				index.GetDisplayName(), index.Bucket, index.GetMemTotal(s.UseLiveData()), index.GetCpuUsage(s.UseLiveData()), s.getConstraintMethod().GetMemQuota(),
+				index.GetDisplayName(), index.Bucket, index.GetMemTotal(s.UseLiveData()), index.GetCpuUsage(s.UseLiveData()), memQuota,
 				s.getConstraintMethod().GetCpuQuota()))
 		}
 
 		if !s.constraint.CanAddNode(s) {
 			found := false
 			for _, indexer := range s.Placement {
-				freeMem := s.getConstraintMethod().GetMemQuota()
-				//freeCpu := float64(s.getConstraintMethod().GetCpuQuota())
+				freeMem := indexer.GetMemQuota(s.getConstraintMethod())
+				//freeCpu := float64(indexer.GetCpuQuota(s.getConstraintMethod()))
 
 				for _, index2 := range indexer.Indexes {
 					if !p.isEligibleIndex(index2) {
@@ -5218,9 +5388,13 @@ func (p *RandomPlacement) randomMoveByLoad(s *Solution, checkConstraint bool) (b
 
 		// See if the index can be moved while obeying resource constraint.
 		violation := s.constraint.CanAddIndex(s, target, index)
+		if violation == NoViolation {
+			violation = s.canMoveColocatedIndexes(source, index, target, p.isEligibleIndex)
+		}
 		if !checkConstraint || violation == NoViolation {
 			force := source.isDelete || !s.constraint.SatisfyIndexHAConstraint(s, source, index, p.GetEligibleIndexes())
 			s.moveIndex(source, index, target, checkConstraint)
+			p.moveColocatedIndexes(s, source, index, target)
 			p.randomMoveDur += time.Now().Sub(now).Nanoseconds()
 			p.randomMoveCnt++
 
@@ -5447,7 +5621,7 @@ func (p *RandomPlacement) Add(s *Solution, indexes []*IndexUsage) error {
 	}
 
 	for _, idx := range indexes {
-		indexer := getRandomNode(p.rs, candidates)
+		indexer := p.findRuleFittedNode(s, candidates, idx)
 		s.addIndex(indexer, idx, false)
 		idx.initialNode = nil
 	}
@@ -5472,7 +5646,7 @@ func (p *RandomPlacement) InitialPlace(s *Solution, indexes []*IndexUsage) error
 	}
 
 	for _, idx := range indexes {
-		indexer := getRandomNode(p.rs, candidates)
+		indexer := p.findRuleFittedNode(s, candidates, idx)
 		s.addIndex(indexer, idx, false)
 		idx.initialNode = indexer
 	}
@@ -5480,6 +5654,36 @@ func (p *RandomPlacement) InitialPlace(s *Solution, indexes []*IndexUsage) error
 	return nil
 }
 
+//
+// This function finds a node for the index following the placement rule.  Co-located
+// partitions are placed together.  Otherwise, a random node that satisfies node labels
+// and anti-affinity rule is selected.
+//
+func (p *RandomPlacement) findRuleFittedNode(s *Solution, candidates []*IndexerNode, idx *IndexUsage) *IndexerNode {
+
+	if indexer := s.findColocatedNode(idx); indexer != nil {
+		for _, candidate := range candidates {
+			if candidate == indexer {
+				return indexer
+			}
+		}
+	}
+
+	return getRandomNode(p.rs, s.filterByPlacementRule(candidates, idx))
+}
+
+//
+// Move the co-located partitions of an index along with it.
+//
+func (p *RandomPlacement) moveColocatedIndexes(s *Solution, source *IndexerNode, idx *IndexUsage, target *IndexerNode) {
+
+	for _, colocated := range s.findColocatedIndexes(source, idx) {
+		if p.isEligibleIndex(colocated) {
+			s.moveIndex(source, colocated, target, false)
+		}
+	}
+}
+
 //
 // Randomly select two index and swap them.
 //
@@ -5703,9 +5907,13 @@ func (p *RandomPlacement) exhaustiveMove(s *Solution, sources []*IndexerNode, ta
 
 				// See if the index can be moved while obeying resource constraint.
 				violation := s.constraint.CanAddIndex(s, target, sourceIndex)
+				if violation == NoViolation {
+					violation = s.canMoveColocatedIndexes(source, sourceIndex, target, p.isEligibleIndex)
+				}
 				if !checkConstraint || violation == NoViolation {
 					force := source.isDelete || !s.constraint.SatisfyIndexHAConstraint(s, source, sourceIndex, p.GetEligibleIndexes())
 					s.moveIndex(source, sourceIndex, target, checkConstraint)
+					p.moveColocatedIndexes(s, source, sourceIndex, target)
 
 					logging.Tracef("Planner::exhaustive move2: source %v index '%v' (%v) target %v checkConstraint %v force %v",
 						source.NodeId, sourceIndex.GetDisplayName(), sourceIndex.Bucket, target.NodeId, checkConstraint, force)
@@ -207,6 +207,7 @@ func getIndexLayout(clusterUrl string, config common.Config, hosts []string) ([]
 		node.IndexerId = localMeta.IndexerId
 		node.StorageMode = localMeta.StorageMode
 		node.exclude = localMeta.LocalSettings["excludeNode"]
+		node.Labels = ParseNodeLabels(localMeta.LocalSettings["nodeLabels"])
 
 		// convert from LocalIndexMetadata to IndexUsage
 		indexes, err := ConvertToIndexUsages(config, localMeta, node)
@@ -437,6 +438,21 @@ func getIndexStats(clusterUrl string, plan *Plan) error {
 			plan.MemQuota = uint64(memQuota.(float64))
 		}
 
+		// memory_total is the physical memory of the host.  The memory
+		// quota of a node cannot exceed its physical memory.  Otherwise,
+		// the node does not have its own memory quota, and the cluster-wide
+		// memory quota applies.
+		if memTotal, ok := statsMap["memory_total"]; ok {
+			if total := uint64(memTotal.(float64)); total != 0 && total < plan.MemQuota {
+				indexer.MemQuota = total
+			}
+		}
+
+		// disk_total is the capacity of the file system of the storage dir.
+		if diskTotal, ok := statsMap["disk_total"]; ok {
+			indexer.DiskQuota = uint64(diskTotal.(float64))
+		}
+
 		// uptime
 		var elapsed uint64
 		if uptimeStat, ok := statsMap["uptime"]; ok {
@@ -447,12 +463,10 @@ func getIndexStats(clusterUrl string, plan *Plan) error {
 		}
 
 		// cpu core in host.   This is the actual num of cpu core, not cpu quota.
-		/*
-			var actualCpuCore uint64
-			if cpuCore, ok := statsMap["num_cpu_core"]; ok {
-				actualCpuCore = uint64(cpuCore.(float64))
-			}
-		*/
+		// The cpu quota of the node is adjusted by the cpu setting later.
+		if cpuCore, ok := statsMap["num_cpu_core"]; ok {
+			indexer.CpuQuota = uint64(cpuCore.(float64))
+		}
 
 		// cpu utilization for the indexer process
 		var actualCpuUtil float64
@@ -800,6 +814,21 @@ func getIndexSettings(clusterUrl string, plan *Plan) error {
 			plan.CpuQuota = uint64(runtime.NumCPU())
 		} else {
 			plan.CpuQuota = uint64(quota.(float64) / 100)
+
+			// The cpu setting applies to every node, up to the cpu core of the node.
+			for _, indexer := range plan.Placement {
+				if indexer.CpuQuota > plan.CpuQuota {
+					indexer.CpuQuota = plan.CpuQuota
+				}
+			}
+		}
+
+		// A node has its own cpu quota only if it differs from the cluster-wide
+		// cpu quota.  Otherwise, the cluster-wide cpu quota applies.
+		for _, indexer := range plan.Placement {
+			if indexer.CpuQuota == plan.CpuQuota {
+				indexer.CpuQuota = 0
+			}
 		}
 
 		return nil
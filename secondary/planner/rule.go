@@ -0,0 +1,323 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package planner
+
+import (
+	"sort"
+	"strings"
+)
+
+//////////////////////////////////////////////////////////////
+// Placement Rule
+//
+// Placement rules are declared in the index definition and honored
+// as part of the HA constraint:
+// 1) NodeLabels - index can only be placed on indexer nodes having all the labels.
+// 2) AntiAffinity - index cannot be placed on the same node as the named indexes
+//    in the same bucket.  The rule applies if either index declares it.
+// 3) ColocatePartitions - a partition is placed on the same node as the partition
+//    (with same partition id and replica id) of other co-located indexes in the same
+//    bucket having the same partition keys.
+//////////////////////////////////////////////////////////////
+
+//
+// This function parses a comma separated list of node labels.
+//
+func ParseNodeLabels(value string) []string {
+
+	var labels []string
+	for _, label := range strings.Split(value, ",") {
+		if label = strings.TrimSpace(label); len(label) != 0 {
+			labels = append(labels, label)
+		}
+	}
+
+	sort.Strings(labels)
+	return labels
+}
+
+//
+// Does this node have all the given labels?
+//
+func (o *IndexerNode) HasLabels(labels []string) bool {
+
+	for _, label := range labels {
+		found := false
+		for _, nodeLabel := range o.Labels {
+			if nodeLabel == label {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// Does this index declare any placement rule?
+//
+func (o *IndexUsage) hasPlacementRule() bool {
+
+	if o.Instance == nil {
+		return false
+	}
+
+	defn := &o.Instance.Defn
+	return len(defn.NodeLabels) != 0 || len(defn.AntiAffinity) != 0 || defn.ColocatePartitions
+}
+
+//
+// Can these two indexes be placed on the same node?
+//
+func (o *IndexUsage) IsAntiAffinity(other *IndexUsage) bool {
+
+	if o.IsSameIndex(other) || o.Bucket != other.Bucket {
+		return false
+	}
+
+	if o.Instance != nil {
+		for _, name := range o.Instance.Defn.AntiAffinity {
+			if name == other.Name {
+				return true
+			}
+		}
+	}
+
+	if other.Instance != nil {
+		for _, name := range other.Instance.Defn.AntiAffinity {
+			if name == o.Name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+//
+// Must these two index partitions be placed on the same node?
+//
+func (o *IndexUsage) IsColocated(other *IndexUsage) bool {
+
+	if o.IsSameIndex(other) || o.Bucket != other.Bucket || !o.IsSamePartition(other) {
+		return false
+	}
+
+	if o.Instance == nil || other.Instance == nil {
+		return false
+	}
+
+	d1, d2 := &o.Instance.Defn, &other.Instance.Defn
+	if !d1.ColocatePartitions || !d2.ColocatePartitions || o.Instance.ReplicaId != other.Instance.ReplicaId {
+		return false
+	}
+
+	if len(d1.PartitionKeys) == 0 || len(d1.PartitionKeys) != len(d2.PartitionKeys) {
+		return false
+	}
+
+	for i, key := range d1.PartitionKeys {
+		if key != d2.PartitionKeys[i] {
+			return false
+		}
+	}
+
+	return o.Instance.Pc != nil && other.Instance.Pc != nil &&
+		o.Instance.Pc.GetNumPartitions() == other.Instance.Pc.GetNumPartitions()
+}
+
+//
+// This function determines if an index can be placed on the given node without
+// violating node labels and anti-affinity rule.
+//
+func (s *Solution) satisfyNodePlacementRule(n *IndexerNode, u *IndexUsage) bool {
+
+	if u.Instance != nil && !n.HasLabels(u.Instance.Defn.NodeLabels) {
+		return false
+	}
+
+	for _, index := range n.Indexes {
+		if index != u && index.IsAntiAffinity(u) {
+			return false
+		}
+	}
+
+	return true
+}
+
+//
+// This function determines if an index can be placed on the given node without
+// violating co-location rule.  If strict is false, co-located partitions residing on
+// the same node as the index are ignored, since they are moved along with the index.
+//
+func (s *Solution) satisfyColocationRule(n *IndexerNode, u *IndexUsage, strict bool) bool {
+
+	if u.Instance == nil || !u.Instance.Defn.ColocatePartitions {
+		return true
+	}
+
+	for _, indexer := range s.Placement {
+		if indexer == n || indexer.isDelete {
+			continue
+		}
+
+		if !strict && s.findIndexOffset(indexer, u) != -1 {
+			continue
+		}
+
+		for _, index := range indexer.Indexes {
+			if index.IsColocated(u) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+//
+// This function determines if an index can be placed on the given node
+// while satisfying all the placement rules.
+//
+func (s *Solution) SatisfyPlacementRule(n *IndexerNode, u *IndexUsage, strict bool) bool {
+
+	if !u.hasPlacementRule() && !s.hasAntiAffinityOn(n, u) {
+		return true
+	}
+
+	return s.satisfyNodePlacementRule(n, u) && s.satisfyColocationRule(n, u, strict)
+}
+
+//
+// Does any index on the node declare anti-affinity with the given index?
+//
+func (s *Solution) hasAntiAffinityOn(n *IndexerNode, u *IndexUsage) bool {
+
+	for _, index := range n.Indexes {
+		if index != u && index.Instance != nil && len(index.Instance.Defn.AntiAffinity) != 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+//
+// Find the co-located partitions of an index residing on the same node.
+//
+func (s *Solution) findColocatedIndexes(n *IndexerNode, u *IndexUsage) []*IndexUsage {
+
+	if u.Instance == nil || !u.Instance.Defn.ColocatePartitions {
+		return nil
+	}
+
+	var result []*IndexUsage
+	for _, index := range n.Indexes {
+		if index.IsColocated(u) {
+			result = append(result, index)
+		}
+	}
+
+	return result
+}
+
+//
+// This function determines if the co-located partitions of an index residing on the
+// source node can be moved to the target node along with the index, without exceeding
+// memory, cpu or disk quota of the target node.  Only eligible partitions are moved.
+//
+func (s *Solution) canMoveColocatedIndexes(source *IndexerNode, u *IndexUsage, target *IndexerNode,
+	eligible func(*IndexUsage) bool) ViolationCode {
+
+	var moved []*IndexUsage
+	for _, index := range s.findColocatedIndexes(source, u) {
+		if eligible(index) {
+			moved = append(moved, index)
+		}
+	}
+
+	if len(moved) == 0 || s.ignoreResourceConstraint() {
+		return NoViolation
+	}
+
+	useLive := s.UseLiveData()
+	mem := target.GetMemTotal(useLive) + u.GetMemTotal(useLive)
+	cpu := target.GetCpuUsage(useLive) + u.GetCpuUsage(useLive)
+	data := target.GetDataSize(useLive) + u.GetDataSize(useLive)
+	for _, index := range moved {
+		mem += index.GetMemTotal(useLive)
+		cpu += index.GetCpuUsage(useLive)
+		data += index.GetDataSize(useLive)
+	}
+
+	if mem > target.GetMemQuota(s.constraint) {
+		return MemoryViolation
+	}
+
+	if cpu > float64(target.GetCpuQuota(s.constraint)) {
+		return CpuViolation
+	}
+
+	if diskQuota := target.GetDiskQuota(); diskQuota != 0 && data > diskQuota {
+		return DiskViolation
+	}
+
+	return NoViolation
+}
+
+//
+// Filter the list of nodes which the index can be placed on without violating
+// node labels and anti-affinity rule.  If no node qualifies, the original list
+// is returned, and the violation will be reported by the planner.
+//
+func (s *Solution) filterByPlacementRule(indexers []*IndexerNode, u *IndexUsage) []*IndexerNode {
+
+	var result []*IndexerNode
+	for _, indexer := range indexers {
+		if s.satisfyNodePlacementRule(indexer, u) {
+			result = append(result, indexer)
+		}
+	}
+
+	if len(result) == 0 {
+		return indexers
+	}
+
+	return result
+}
+
+//
+// Find the node hosting a co-located partition of the index.
+//
+func (s *Solution) findColocatedNode(u *IndexUsage) *IndexerNode {
+
+	if u.Instance == nil || !u.Instance.Defn.ColocatePartitions {
+		return nil
+	}
+
+	for _, indexer := range s.Placement {
+		if indexer.isDelete {
+			continue
+		}
+
+		for _, index := range indexer.Indexes {
+			if index != u && index.IsColocated(u) {
+				return indexer
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package planner
+
+import (
+	"github.com/couchbase/indexing/secondary/common"
+	"testing"
+)
+
+func colocatedIndex(defnId common.IndexDefnId, name string, partnId common.PartitionId, replicaId int,
+	numPartition int, keys ...string) *IndexUsage {
+
+	u := &IndexUsage{
+		DefnId:   defnId,
+		InstId:   common.IndexInstId(defnId),
+		PartnId:  partnId,
+		Name:     name,
+		Bucket:   "default",
+		MemUsage: 100,
+		CpuUsage: 1,
+		DataSize: 100,
+	}
+
+	u.Instance = &common.IndexInst{
+		InstId:    u.InstId,
+		ReplicaId: replicaId,
+		Pc:        common.NewKeyPartitionContainer(1024, numPartition, common.KEY, common.CRC32),
+	}
+	u.Instance.Defn = common.IndexDefn{
+		DefnId:             defnId,
+		Name:               name,
+		Bucket:             "default",
+		PartitionScheme:    common.KEY,
+		PartitionKeys:      keys,
+		ColocatePartitions: true,
+	}
+
+	return u
+}
+
+func TestIsColocated(t *testing.T) {
+
+	a := colocatedIndex(1, "a", 1, 0, 4, "`city`")
+
+	otherBucket := colocatedIndex(2, "b", 1, 0, 4, "`city`")
+	otherBucket.Bucket = "travel"
+
+	notDeclared := colocatedIndex(2, "b", 1, 0, 4, "`city`")
+	notDeclared.Instance.Defn.ColocatePartitions = false
+
+	testcases := []struct {
+		comment string
+		other   *IndexUsage
+		result  bool
+	}{
+		{"same partition and keys", colocatedIndex(2, "b", 1, 0, 4, "`city`"), true},
+		{"same index", colocatedIndex(1, "a", 1, 1, 4, "`city`"), false},
+		{"different partition", colocatedIndex(2, "b", 2, 0, 4, "`city`"), false},
+		{"different bucket", otherBucket, false},
+		{"different replica", colocatedIndex(2, "b", 1, 1, 4, "`city`"), false},
+		{"different keys", colocatedIndex(2, "b", 1, 0, 4, "`country`"), false},
+		{"more keys", colocatedIndex(2, "b", 1, 0, 4, "`city`", "`country`"), false},
+		{"different number of partitions", colocatedIndex(2, "b", 1, 0, 8, "`city`"), false},
+		{"rule not declared", notDeclared, false},
+		{"no definition", &IndexUsage{DefnId: 2, PartnId: 1, Bucket: "default"}, false},
+	}
+
+	for _, tc := range testcases {
+		if result := a.IsColocated(tc.other); result != tc.result {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.result, result)
+		}
+		if result := tc.other.IsColocated(a); result != tc.result {
+			t.Errorf("%v (reversed): expected %v, got %v", tc.comment, tc.result, result)
+		}
+	}
+
+	noKeys := colocatedIndex(1, "a", 1, 0, 4)
+	if noKeys.IsColocated(colocatedIndex(2, "b", 1, 0, 4)) {
+		t.Errorf("indexes without partition keys cannot be co-located")
+	}
+}
+
+func TestColocationRule(t *testing.T) {
+
+	a1 := colocatedIndex(1, "a", 1, 0, 4, "`city`")
+	b1 := colocatedIndex(2, "b", 1, 0, 4, "`city`")
+	a2 := colocatedIndex(1, "a", 2, 0, 4, "`city`")
+	c1 := colocatedIndex(3, "c", 1, 0, 4, "`country`")
+
+	nodes := []*IndexerNode{
+		{NodeId: "n1", Indexes: []*IndexUsage{a1, b1}},
+		{NodeId: "n2", Indexes: []*IndexUsage{a2, c1}},
+		{NodeId: "n3"},
+	}
+	constraint := newIndexerConstraint(1000, 4, false, len(nodes), -1, -1)
+	s := newSolution(constraint, newGeneralSizingMethod(), nodes, false, false, false)
+	n1, n2, n3 := s.Placement[0], s.Placement[1], s.Placement[2]
+
+	// satisfyColocationRule
+	if !s.satisfyColocationRule(n1, b1, true) {
+		t.Errorf("b1 should be allowed on the node of a1")
+	}
+	if s.satisfyColocationRule(n3, b1, true) {
+		t.Errorf("b1 should not be allowed away from a1")
+	}
+	if !s.satisfyColocationRule(n3, a1, false) {
+		t.Errorf("a1 should be allowed to move along with b1")
+	}
+	if s.satisfyColocationRule(n3, a1, true) {
+		t.Errorf("a1 should not be allowed to move away from b1 when strict")
+	}
+	if !s.satisfyColocationRule(n3, c1, true) {
+		t.Errorf("c1 has no co-located partition, it can be placed anywhere")
+	}
+
+	// findColocatedIndexes
+	if colocated := s.findColocatedIndexes(n1, a1); len(colocated) != 1 || colocated[0] != b1 {
+		t.Errorf("expected b1 to be co-located with a1, got %v", colocated)
+	}
+	if colocated := s.findColocatedIndexes(n2, a2); len(colocated) != 0 {
+		t.Errorf("expected no co-located partition for a2, got %v", colocated)
+	}
+
+	// findColocatedNode
+	b2 := colocatedIndex(2, "b", 2, 0, 4, "`city`")
+	if node := s.findColocatedNode(b2); node != n2 {
+		t.Errorf("expected b2 to be co-located on n2, got %v", node)
+	}
+	if node := s.findColocatedNode(c1); node != nil {
+		t.Errorf("expected no co-located node for c1, got %v", node)
+	}
+	n2.isDelete = true
+	if node := s.findColocatedNode(b2); node != nil {
+		t.Errorf("deleted node should not be used for co-location, got %v", node)
+	}
+	n2.isDelete = false
+}
+
+func TestCanMoveColocatedIndexes(t *testing.T) {
+
+	a1 := colocatedIndex(1, "a", 1, 0, 4, "`city`")
+	b1 := colocatedIndex(2, "b", 1, 0, 4, "`city`")
+
+	all := func(*IndexUsage) bool { return true }
+	none := func(*IndexUsage) bool { return false }
+
+	testcases := []struct {
+		comment   string
+		memQuota  uint64
+		cpuQuota  uint64
+		diskQuota uint64
+		eligible  func(*IndexUsage) bool
+		violation ViolationCode
+	}{
+		{"fits", 1000, 4, 0, all, NoViolation},
+		{"memory", 150, 4, 0, all, MemoryViolation},
+		{"cpu", 1000, 1, 0, all, CpuViolation},
+		{"disk", 1000, 4, 150, all, DiskViolation},
+		{"not moved", 150, 1, 150, none, NoViolation},
+	}
+
+	for _, tc := range testcases {
+		nodes := []*IndexerNode{
+			{NodeId: "n1", Indexes: []*IndexUsage{a1, b1}},
+			{NodeId: "n2", DiskQuota: tc.diskQuota},
+		}
+		constraint := newIndexerConstraint(tc.memQuota, tc.cpuQuota, false, len(nodes), -1, -1)
+		s := newSolution(constraint, newGeneralSizingMethod(), nodes, false, false, false)
+
+		violation := s.canMoveColocatedIndexes(s.Placement[0], a1, s.Placement[1], tc.eligible)
+		if violation != tc.violation {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.violation, violation)
+		}
+	}
+}
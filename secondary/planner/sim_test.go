@@ -187,6 +187,214 @@ func TestSimulation(t *testing.T) {
 	}
 }
 
+//////////////////////////////////////////////////////////////
+// Mixed Capacity Test
+/////////////////////////////////////////////////////////////
+
+const (
+	testGB            = uint64(1024 * 1024 * 1024)
+	testNumIndex      = 60
+	testImbalance     = 0.25
+	testSmallMem      = 32 * testGB
+	testSmallCpu      = 8
+	testSmallDisk     = 512 * testGB
+	testCapacityRatio = 2
+)
+
+//
+// A workload of indexes of similar size and rate, so that every resource
+// usage of a node is proportional to the number of indexes on the node.
+//
+func mixedCapacityWorkloadSpec(s *simulator) *WorkloadSpec {
+
+	spec := s.defaultWorkloadSpec()
+	spec.Name = "Mixed Capacity Simulation Workload"
+	spec.MinNumIndex = testNumIndex
+	spec.MaxNumIndex = testNumIndex
+
+	bucket := spec.Workload[0]
+	bucket.Replica = 1
+
+	collection := bucket.Workload[0]
+	collection.MinNumDoc = 1000000
+	collection.MaxNumDoc = 1100000
+	collection.MinDocKeySize = 50
+	collection.MaxDocKeySize = 50
+	collection.MinSecKeySize = 100
+	collection.MaxSecKeySize = 110
+	collection.MinMutationRate = 10000
+	collection.MaxMutationRate = 10000
+	collection.MinScanRate = 1000
+	collection.MaxScanRate = 1000
+
+	// two large nodes with twice the memory, cpu and disk of the two small nodes
+	spec.Nodes = []*NodeSpec{
+		{Count: 2, MemQuota: testCapacityRatio * testSmallMem, CpuQuota: testCapacityRatio * testSmallCpu, DiskQuota: testCapacityRatio * testSmallDisk},
+		{Count: 2, MemQuota: testSmallMem, CpuQuota: testSmallCpu, DiskQuota: testSmallDisk},
+	}
+
+	return spec
+}
+
+func TestPlanFromNodeSpec(t *testing.T) {
+
+	s := NewSimulator()
+
+	specs := []*NodeSpec{
+		{Count: 2, MemQuota: 2 * testSmallMem, CpuQuota: 2 * testSmallCpu, DiskQuota: 2 * testSmallDisk, ServerGroup: "sg1"},
+		{MemQuota: testSmallMem, CpuQuota: testSmallCpu, ServerGroup: "sg2"},
+	}
+
+	p := s.planFromNodeSpec(newGeneralSizingMethod(), specs)
+
+	// a spec without count creates a single node
+	if len(p.Placement) != 3 {
+		t.Fatalf("expected 3 nodes, got %v", len(p.Placement))
+	}
+
+	expected := []*NodeSpec{specs[0], specs[0], specs[1]}
+	for i, indexer := range p.Placement {
+		spec := expected[i]
+		if indexer.MemQuota != spec.MemQuota || indexer.CpuQuota != spec.CpuQuota ||
+			indexer.DiskQuota != spec.DiskQuota || indexer.ServerGroup != spec.ServerGroup {
+			t.Errorf("node %v: expected %+v, got mem %v cpu %v disk %v server group %v", i, spec,
+				indexer.MemQuota, indexer.CpuQuota, indexer.DiskQuota, indexer.ServerGroup)
+		}
+
+		if len(indexer.NodeId) == 0 || len(indexer.Indexes) != 0 {
+			t.Errorf("node %v: expected empty node with node id, got %v with %v indexes", i, indexer.NodeId, len(indexer.Indexes))
+		}
+	}
+}
+
+func TestCapacityRatio(t *testing.T) {
+
+	sizing := newGeneralSizingMethod()
+
+	newNode := func(nodeId string, memQuota uint64, cpuQuota uint64, diskQuota uint64) *IndexerNode {
+		indexer := newIndexerNode(nodeId, sizing)
+		indexer.MemQuota = memQuota
+		indexer.CpuQuota = cpuQuota
+		indexer.DiskQuota = diskQuota
+		return indexer
+	}
+
+	testcases := []struct {
+		comment  string
+		memQuota uint64
+		cpuQuota uint64
+		nodes    []*IndexerNode
+		mem      []float64
+		cpu      []float64
+		disk     []float64
+	}{
+		{
+			"same capacity",
+			testSmallMem, testSmallCpu,
+			[]*IndexerNode{newNode("a", testSmallMem, testSmallCpu, testSmallDisk), newNode("b", testSmallMem, testSmallCpu, testSmallDisk)},
+			[]float64{1, 1}, []float64{1, 1}, []float64{1, 1},
+		},
+		{
+			"mixed capacity",
+			testSmallMem, testSmallCpu,
+			[]*IndexerNode{newNode("a", 2*testSmallMem, 2*testSmallCpu, 3*testSmallDisk), newNode("b", testSmallMem, testSmallCpu, testSmallDisk)},
+			[]float64{2, 1}, []float64{2, 1}, []float64{1.5, 0.5},
+		},
+		{
+			"node without capacity",
+			testSmallMem, testSmallCpu,
+			[]*IndexerNode{newNode("a", 2*testSmallMem, 2*testSmallCpu, testSmallDisk), newNode("b", 0, 0, 0)},
+			[]float64{2, 1}, []float64{2, 1}, []float64{1, 1},
+		},
+		{
+			"no cluster-wide quota",
+			0, 0,
+			[]*IndexerNode{newNode("a", 2*testSmallMem, 2*testSmallCpu, 0), newNode("b", testSmallMem, testSmallCpu, 0)},
+			[]float64{1, 1}, []float64{1, 1}, []float64{1, 1},
+		},
+	}
+
+	for _, tc := range testcases {
+		constraint := newIndexerConstraint(tc.memQuota, tc.cpuQuota, false, int(math.MaxInt16), -1, -1)
+		solution := newSolution(constraint, sizing, tc.nodes, false, false, false)
+
+		for i, indexer := range solution.Placement {
+			if ratio := solution.memCapacityRatio(indexer); ratio != tc.mem[i] {
+				t.Errorf("%v: node %v: expected mem ratio %v, got %v", tc.comment, indexer.NodeId, tc.mem[i], ratio)
+			}
+			if ratio := solution.cpuCapacityRatio(indexer); ratio != tc.cpu[i] {
+				t.Errorf("%v: node %v: expected cpu ratio %v, got %v", tc.comment, indexer.NodeId, tc.cpu[i], ratio)
+			}
+			if ratio := solution.diskCapacityRatio(indexer); ratio != tc.disk[i] {
+				t.Errorf("%v: node %v: expected disk ratio %v, got %v", tc.comment, indexer.NodeId, tc.disk[i], ratio)
+			}
+		}
+	}
+}
+
+//
+// Place and rebalance a workload on a fleet of nodes with different
+// capacity.  The memory usage of each node must be proportional to its
+// memory capacity, i.e. the memory utilization of the nodes is balanced.
+//
+func TestMixedCapacitySimulation(t *testing.T) {
+
+	for _, command := range []CommandType{CommandPlan, CommandRebalance} {
+
+		s := NewSimulator()
+		spec := mixedCapacityWorkloadSpec(s)
+
+		config := DefaultRunConfig()
+		config.Resize = false
+		config.MemQuota = int64(testSmallMem)
+		config.CpuQuota = testSmallCpu
+
+		p, _, err := s.RunSingleTest(config, command, spec, nil, nil)
+		if err != nil {
+			t.Fatalf("%v: %v", command, err)
+		}
+
+		result := p.Result
+		if len(result.Placement) != 4 {
+			t.Fatalf("%v: expected 4 nodes, got %v", command, len(result.Placement))
+		}
+
+		numIndex := 0
+		meanUtil := float64(0)
+		for _, indexer := range result.Placement {
+			numIndex += len(indexer.Indexes)
+			meanUtil += result.getMemUtilization(indexer)
+		}
+		meanUtil = meanUtil / float64(len(result.Placement))
+
+		if numIndex != testNumIndex {
+			t.Fatalf("%v: expected %v indexes, got %v", command, testNumIndex, numIndex)
+		}
+
+		var large, small uint64
+		for _, indexer := range result.Placement {
+			memUsage := indexer.GetMemUsage(result.UseLiveData())
+			if indexer.MemQuota == testSmallMem {
+				small += memUsage
+			} else {
+				large += memUsage
+			}
+
+			util := result.getMemUtilization(indexer)
+			if math.Abs(util-meanUtil) > testImbalance*meanUtil {
+				t.Errorf("%v: node %v (mem quota %v) with %v indexes: memory utilization %v deviates from mean %v",
+					command, indexer.NodeId, formatMemoryStr(indexer.MemQuota), len(indexer.Indexes), util, meanUtil)
+			}
+		}
+
+		// the large nodes hold about twice the memory of the small nodes
+		ratio := float64(large) / float64(small)
+		if math.Abs(ratio-testCapacityRatio) > testImbalance*testCapacityRatio {
+			t.Errorf("%v: expected memory usage ratio of large to small nodes about %v, got %v", command, testCapacityRatio, ratio)
+		}
+	}
+}
+
 //////////////////////////////////////////////////////////////
 // Utility
 /////////////////////////////////////////////////////////////
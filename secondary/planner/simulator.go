@@ -53,7 +53,10 @@ import (
     }],
 "distribution" 	: [100],
 "minNumIndex"   : 5,
-"maxNumIndex"   : 100
+"maxNumIndex"   : 100,
+"nodes"         : [
+    {"count" : 2, "memQuota" : 68719476736, "cpuQuota" : 16, "diskQuota" : 1099511627776},
+    {"count" : 1, "memQuota" : 34359738368, "cpuQuota" : 8, "diskQuota" : 549755813888}]
 }
 
 ******************* SAMPLE WORKLOAD *****************/
@@ -73,6 +76,15 @@ type WorkloadSpec struct {
 	Distribution []int64       `json:"distribution,omitempty"`
 	MinNumIndex  int64         `json:"minNumIndex,omitempty"`
 	MaxNumIndex  int64         `json:"maxNumIndex,omitempty"`
+	Nodes        []*NodeSpec   `json:"nodes,omitempty"`
+}
+
+type NodeSpec struct {
+	Count       int64  `json:"count,omitempty"`
+	MemQuota    uint64 `json:"memQuota,omitempty"`
+	CpuQuota    uint64 `json:"cpuQuota,omitempty"`
+	DiskQuota   uint64 `json:"diskQuota,omitempty"`
+	ServerGroup string `json:"serverGroup,omitempty"`
 }
 
 type BucketSpec struct {
@@ -351,6 +363,10 @@ func (t *simulator) RunSingleTest(config *RunConfig, command CommandType, spec *
 			return nil, nil, errors.New("missing argument:  workload or indexes must be present")
 		}
 
+		if p == nil && spec != nil && len(spec.Nodes) != 0 {
+			p = t.planFromNodeSpec(sizing, spec.Nodes)
+		}
+
 		if p != nil {
 			t.setStorageType(p)
 		}
@@ -368,6 +384,16 @@ func (t *simulator) RunSingleTest(config *RunConfig, command CommandType, spec *
 			return nil, nil, errors.New("missing argument: either workload or plan must be present")
 		}
 
+		if p == nil && len(spec.Nodes) != 0 {
+			// place the workload randomly on the specified nodes before rebalancing
+			p = t.planFromNodeSpec(sizing, spec.Nodes)
+			for _, index := range indexes {
+				indexer := p.Placement[t.rs.Intn(len(p.Placement))]
+				indexer.Indexes = append(indexer.Indexes, index)
+			}
+			indexes = nil
+		}
+
 		deletedNodes, err := t.findNodesToDelete(config, p)
 		if err != nil {
 			return nil, nil, err
@@ -414,6 +440,38 @@ func (t *simulator) findNodesToDelete(config *RunConfig, plan *Plan) ([]string,
 	return outNodeIds, nil
 }
 
+//////////////////////////////////////////////////////////////
+// Node Generation
+/////////////////////////////////////////////////////////////
+
+//
+// Create a plan with empty indexer nodes based on the node spec.  Each
+// node has its own memory, cpu and disk capacity, so the simulation can
+// model a cluster with heterogeneous hardware.
+//
+func (t *simulator) planFromNodeSpec(s SizingMethod, specs []*NodeSpec) *Plan {
+
+	var indexers []*IndexerNode
+	for _, spec := range specs {
+		count := spec.Count
+		if count <= 0 {
+			count = 1
+		}
+
+		for i := int64(0); i < count; i++ {
+			nodeId := strconv.FormatUint(uint64(t.rs.Uint32()), 10)
+			indexer := newIndexerNode(nodeId, s)
+			indexer.MemQuota = spec.MemQuota
+			indexer.CpuQuota = spec.CpuQuota
+			indexer.DiskQuota = spec.DiskQuota
+			indexer.ServerGroup = spec.ServerGroup
+			indexers = append(indexers, indexer)
+		}
+	}
+
+	return &Plan{Placement: indexers}
+}
+
 //////////////////////////////////////////////////////////////
 // Index Usage Genreation
 /////////////////////////////////////////////////////////////
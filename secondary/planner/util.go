@@ -83,8 +83,11 @@ func formatTimeStr(time uint64) string {
 //
 func computeIndexerUsage(s *Solution, indexer *IndexerNode) float64 {
 
-	memUsage := float64(indexer.GetMemTotal(s.UseLiveData())) / float64(s.constraint.GetMemQuota())
-	cpuUsage := float64(indexer.GetCpuUsage(s.UseLiveData())) / float64(s.constraint.GetCpuQuota())
+	memQuota := float64(indexer.GetMemQuota(s.constraint))
+	cpuQuota := float64(indexer.GetCpuQuota(s.constraint))
+
+	memUsage := float64(indexer.GetMemTotal(s.UseLiveData())) / memQuota
+	cpuUsage := float64(indexer.GetCpuUsage(s.UseLiveData())) / cpuQuota
 
 	return memUsage + cpuUsage
 }
@@ -94,12 +97,15 @@ func computeIndexerUsage(s *Solution, indexer *IndexerNode) float64 {
 //
 func computeIndexerFreeQuota(s *Solution, indexer *IndexerNode) float64 {
 
-	memUsage := (float64(s.constraint.GetMemQuota()) - float64(indexer.GetMemTotal(s.UseLiveData()))) / float64(s.constraint.GetMemQuota())
+	memQuota := float64(indexer.GetMemQuota(s.constraint))
+	cpuQuota := float64(indexer.GetCpuQuota(s.constraint))
+
+	memUsage := (memQuota - float64(indexer.GetMemTotal(s.UseLiveData()))) / memQuota
 	if memUsage < 0 {
 		memUsage = 0
 	}
 
-	cpuUsage := (float64(s.constraint.GetCpuQuota()) - float64(indexer.GetCpuUsage(s.UseLiveData()))) / float64(s.constraint.GetCpuQuota())
+	cpuUsage := (cpuQuota - float64(indexer.GetCpuUsage(s.UseLiveData()))) / cpuQuota
 	if cpuUsage < 0 {
 		cpuUsage = 0
 	}
@@ -41,6 +41,7 @@ func (p *Projector) mainAdminPort(reqch chan ap.Request) {
 	p.admind.Register(reqStats)
 	p.admind.RegisterHTTPHandler("/stats", p.handleStats)
 	p.admind.RegisterHTTPHandler("/settings", p.handleSettings)
+	p.admind.RegisterHTTPHandler("/budgets", p.handleBudgets)
 
 	// debug pprof hanlders.
 	p.admind.RegisterHTTPHandler("/debug/pprof", c.PProfHandler)
@@ -0,0 +1,591 @@
+// Budgets for feeds, per {topic, bucket}.
+//
+// Each KVData gets a budget that limits,
+//   * mutations consumed from upstream per second.
+//   * document bytes consumed from upstream per second.
+//   * evaluation CPU, nanoseconds per second, spent by its vbucket-workers.
+//
+// Mutation and byte limits are configured, evaluation CPU is shared by
+// budget-scheduler across all budgets, in proportion to their weights, out
+// of the capacity given by projector.maxCpuPercent, and each budget's share
+// is further shared across its vbucket-workers. Sharing is work conserving
+// at both levels, share unused by a budget or a worker is given to those
+// using more than their fair share. Maintenance streams get a larger
+// weight than initial build streams, so an initial build cannot starve
+// maintenance of other buckets.
+//
+// When mutation or byte budget runs out, KVData stops reading from
+// upstream until the budget is refilled. When a vbucket-worker runs out of
+// its evaluation CPU it stops picking events, its queue fills up and
+// KVData blocks on it. Either way DCP buffer-acks will eventually throttle
+// the producer.
+
+package projector
+
+import "encoding/json"
+import "strings"
+import "sync"
+import "sync/atomic"
+import "time"
+
+import c "github.com/couchbase/indexing/secondary/common"
+import "github.com/couchbase/indexing/secondary/logging"
+
+// topics of initial build streams are prefixed with this, refer indexer.
+const initTopicPrefix = "INIT_STREAM_TOPIC"
+
+// budgetLimits can be overridden per feed.
+type budgetLimits struct {
+	MaxMutationsPerSec int64 `json:"maxMutationsPerSec"`
+	MaxBytesPerSec     int64 `json:"maxBytesPerSec"`
+	Weight             int64 `json:"weight"`
+}
+
+// Budget for a single {topic, bucket}. Usage is accounted concurrently by
+// KVData and its vbucket-workers, while rates and shares are computed by
+// budget-scheduler.
+type Budget struct {
+	topic  string // immutable
+	bucket string // immutable
+
+	mu       sync.Mutex
+	limits   budgetLimits
+	cpuShare int64 // evaluation nanoseconds per second, 0 means no limit
+	// usage rates measured over the last tick.
+	mutRate  int64
+	byteRate int64
+	cpuRate  int64
+	prevMuts int64
+	prevByts int64
+	prevCpu  int64
+	workers  map[int]*workerBudget
+
+	// token buckets, can go negative, refilled only by KVData.
+	mutTokens  int64
+	byteTokens int64
+	refilled   time.Time
+	// cumulative usage
+	mutations int64
+	bytes     int64
+	cpuTime   int64
+	// throttling, updated only by KVData.
+	throttleSince time.Time
+	throttleCount int64
+	throttleTime  int64
+	// throttling on cpu, across vbucket-workers.
+	cpuThrottleCount int64
+	cpuThrottleTime  int64
+}
+
+func newBudget(topic, bucket string, limits budgetLimits) *Budget {
+	return &Budget{
+		topic:    topic,
+		bucket:   bucket,
+		limits:   limits,
+		workers:  make(map[int]*workerBudget),
+		refilled: time.Now(),
+	}
+}
+
+// addWorker returns the share of this budget for vbucket-worker `id`.
+func (b *Budget) addWorker(id int) *workerBudget {
+	if b == nil {
+		return nil
+	}
+	w := &workerBudget{budget: b, id: id, refilled: time.Now()}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.workers[id] = w
+	return w
+}
+
+// removeWorker once the vbucket-worker has exited.
+func (b *Budget) removeWorker(w *workerBudget) {
+	if b == nil || w == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.workers[w.id] == w {
+		delete(b.workers, w.id)
+	}
+}
+
+// consume account for a DcpEvent read from upstream.
+func (b *Budget) consume(mutations, bytes int64) {
+	if b == nil {
+		return
+	}
+	atomic.AddInt64(&b.mutations, mutations)
+	atomic.AddInt64(&b.bytes, bytes)
+	atomic.AddInt64(&b.mutTokens, -mutations)
+	atomic.AddInt64(&b.byteTokens, -bytes)
+}
+
+// delay returns how long KVData shall wait before reading the next
+// event from upstream, zero if budget is available. Shall be called only
+// by KVData.
+func (b *Budget) delay() time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	b.mu.Lock()
+	limits := b.limits
+	b.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(b.refilled)
+	b.refilled = now
+
+	d := refillTokens(&b.mutTokens, limits.MaxMutationsPerSec, elapsed)
+	if w := refillTokens(&b.byteTokens, limits.MaxBytesPerSec, elapsed); w > d {
+		d = w
+	}
+
+	if d > 0 && b.throttleSince.IsZero() {
+		b.throttleSince = now
+		atomic.AddInt64(&b.throttleCount, 1)
+	} else if d == 0 && !b.throttleSince.IsZero() {
+		atomic.AddInt64(&b.throttleTime, int64(now.Sub(b.throttleSince)))
+		b.throttleSince = time.Time{}
+	}
+	return d
+}
+
+// refillTokens for `elapsed` time at `rate` per second, allowing a burst
+// of upto a second, and return how long to wait for tokens to go positive.
+// A rate of zero means no limit.
+func refillTokens(tokens *int64, rate int64, elapsed time.Duration) time.Duration {
+	if rate <= 0 {
+		atomic.StoreInt64(tokens, 0)
+		return 0
+	}
+	refill := int64(float64(rate) * elapsed.Seconds())
+	if n := atomic.AddInt64(tokens, refill); n > rate {
+		atomic.StoreInt64(tokens, rate)
+	} else if n < 0 {
+		return time.Duration(float64(-n) / float64(rate) * 1e9)
+	}
+	return 0
+}
+
+// measure usage rates over the last `tick`, return its vbucket-workers and
+// their evaluation cpu demand, which is unbounded, -1, if the worker was
+// throttled on cpu.
+func (b *Budget) measure(tick time.Duration) ([]*workerBudget, []int64) {
+	muts := atomic.LoadInt64(&b.mutations)
+	byts := atomic.LoadInt64(&b.bytes)
+	cpu := atomic.LoadInt64(&b.cpuTime)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	secs := tick.Seconds()
+	b.mutRate = int64(float64(muts-b.prevMuts) / secs)
+	b.byteRate = int64(float64(byts-b.prevByts) / secs)
+	b.cpuRate = int64(float64(cpu-b.prevCpu) / secs)
+	b.prevMuts, b.prevByts, b.prevCpu = muts, byts, cpu
+
+	workers := make([]*workerBudget, 0, len(b.workers))
+	demands := make([]int64, 0, len(b.workers))
+	for _, w := range b.workers {
+		workers = append(workers, w)
+		demands = append(demands, w.measure(tick))
+	}
+	return workers, demands
+}
+
+func (b *Budget) weight() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limits.Weight
+}
+
+func (b *Budget) setLimits(limits budgetLimits) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.limits = limits
+}
+
+func (b *Budget) setCpuShare(share int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cpuShare = share
+}
+
+// stats return current vs budgeted usage.
+func (b *Budget) stats() map[string]interface{} {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return map[string]interface{}{
+		"topic":              b.topic,
+		"bucket":             b.bucket,
+		"weight":             b.limits.Weight,
+		"mutationsPerSec":    b.mutRate,
+		"maxMutationsPerSec": b.limits.MaxMutationsPerSec,
+		"bytesPerSec":        b.byteRate,
+		"maxBytesPerSec":     b.limits.MaxBytesPerSec,
+		"evalCpuPercent":     float64(b.cpuRate) / 1e7,
+		"evalCpuSharePct":    float64(b.cpuShare) / 1e7,
+		"throttleCount":      atomic.LoadInt64(&b.throttleCount),
+		"throttleTime":       atomic.LoadInt64(&b.throttleTime),
+		"cpuThrottleCount":   atomic.LoadInt64(&b.cpuThrottleCount),
+		"cpuThrottleTime":    atomic.LoadInt64(&b.cpuThrottleTime),
+		"vbucketWorkers":     len(b.workers),
+	}
+}
+
+// workerBudget is the share of a budget's evaluation CPU given to one of
+// its vbucket-workers.
+type workerBudget struct {
+	budget *Budget // immutable
+	id     int     // immutable
+
+	mu       sync.Mutex
+	cpuShare int64 // evaluation nanoseconds per second, 0 means no limit
+	prevCpu  int64
+	// throttled on cpu since the last tick.
+	cpuThrottled bool
+
+	// token bucket, can go negative, refilled only by the worker.
+	cpuTokens int64
+	refilled  time.Time
+	cpuTime   int64
+	// throttling, updated only by the worker.
+	throttleSince time.Time
+}
+
+// consumeCPU account for evaluation time spent by vbucket-worker.
+func (w *workerBudget) consumeCPU(elapsed time.Duration) {
+	if w == nil {
+		return
+	}
+	atomic.AddInt64(&w.cpuTime, int64(elapsed))
+	atomic.AddInt64(&w.cpuTokens, -int64(elapsed))
+	atomic.AddInt64(&w.budget.cpuTime, int64(elapsed))
+}
+
+// delay returns how long vbucket-worker shall wait before picking the
+// next event, zero if its share is available. Shall be called only by
+// the vbucket-worker.
+func (w *workerBudget) delay() time.Duration {
+	if w == nil {
+		return 0
+	}
+
+	w.mu.Lock()
+	cpuShare := w.cpuShare
+	w.mu.Unlock()
+
+	now := time.Now()
+	elapsed := now.Sub(w.refilled)
+	w.refilled = now
+
+	d := refillTokens(&w.cpuTokens, cpuShare, elapsed)
+	if d > 0 {
+		w.mu.Lock()
+		w.cpuThrottled = true
+		w.mu.Unlock()
+	}
+
+	b := w.budget
+	if d > 0 && w.throttleSince.IsZero() {
+		w.throttleSince = now
+		atomic.AddInt64(&b.cpuThrottleCount, 1)
+	} else if d == 0 && !w.throttleSince.IsZero() {
+		atomic.AddInt64(&b.cpuThrottleTime, int64(now.Sub(w.throttleSince)))
+		w.throttleSince = time.Time{}
+	}
+	return d
+}
+
+// measure evaluation cpu used over the last `tick`, -1 if worker was
+// throttled on cpu.
+func (w *workerBudget) measure(tick time.Duration) int64 {
+	cpu := atomic.LoadInt64(&w.cpuTime)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	rate := int64(float64(cpu-w.prevCpu) / tick.Seconds())
+	w.prevCpu = cpu
+	if w.cpuThrottled {
+		w.cpuThrottled = false
+		return -1
+	}
+	return rate
+}
+
+func (w *workerBudget) setCpuShare(share int64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cpuShare = share
+}
+
+// budgetScheduler manages budgets of all feeds in this projector, it runs
+// for the life time of the projector.
+type budgetScheduler struct {
+	mu           sync.Mutex
+	budgets      map[string]*Budget // "topic/bucket" -> budget
+	tick         time.Duration
+	cpuCapacity  int64 // evaluation nanoseconds per second
+	evalCpuShare bool
+	defaults     budgetLimits
+	maintWeight  int64
+	initWeight   int64
+	overrides    map[string]budgetLimits
+	logPrefix    string
+}
+
+func newBudgetScheduler(config c.Config, logPrefix string) *budgetScheduler {
+	s := &budgetScheduler{
+		budgets:   make(map[string]*Budget),
+		overrides: make(map[string]budgetLimits),
+		logPrefix: logPrefix,
+	}
+	s.tick = time.Duration(config["projector.budget.tick"].Int())
+	s.tick *= time.Millisecond
+	s.resetConfig(config)
+	go s.run()
+	return s
+}
+
+// register a new budget for {topic, bucket}.
+func (s *budgetScheduler) register(topic, bucket string) *Budget {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b := newBudget(topic, bucket, s.limits(topic, bucket))
+	s.budgets[topic+"/"+bucket] = b
+	return b
+}
+
+// unregister budget, once its KVData has exited.
+func (s *budgetScheduler) unregister(b *Budget) {
+	if s == nil || b == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := b.topic + "/" + b.bucket
+	if s.budgets[key] == b {
+		delete(s.budgets, key)
+	}
+}
+
+// resetConfig with full or subset of projector settings.
+func (s *budgetScheduler) resetConfig(config c.Config) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if cv, ok := config["projector.maxCpuPercent"]; ok {
+		s.cpuCapacity = int64(cv.Int()) * 1e7 // percent of a core, in ns/sec
+	}
+	if cv, ok := config["projector.budget.evalCpuShare"]; ok {
+		s.evalCpuShare = cv.Bool()
+	}
+	if cv, ok := config["projector.budget.maxMutationsPerSec"]; ok {
+		s.defaults.MaxMutationsPerSec = int64(cv.Int())
+	}
+	if cv, ok := config["projector.budget.maxBytesPerSec"]; ok {
+		s.defaults.MaxBytesPerSec = int64(cv.Int())
+	}
+	if cv, ok := config["projector.budget.maintWeight"]; ok {
+		s.maintWeight = int64(cv.Int())
+	}
+	if cv, ok := config["projector.budget.initWeight"]; ok {
+		s.initWeight = int64(cv.Int())
+	}
+	if cv, ok := config["projector.budget.overrides"]; ok {
+		overrides := make(map[string]budgetLimits)
+		if str := cv.String(); str != "" {
+			if err := json.Unmarshal([]byte(str), &overrides); err != nil {
+				fmsg := "%v invalid budget overrides %q: %v\n"
+				logging.Errorf(fmsg, s.logPrefix, str, err)
+				overrides = s.overrides
+			}
+		}
+		s.overrides = overrides
+	}
+	for _, b := range s.budgets {
+		b.setLimits(s.limits(b.topic, b.bucket))
+	}
+}
+
+// should be called with lock held.
+func (s *budgetScheduler) limits(topic, bucket string) budgetLimits {
+	limits := s.defaults
+	limits.Weight = s.maintWeight
+	if strings.HasPrefix(topic, initTopicPrefix) {
+		limits.Weight = s.initWeight
+	}
+	override, ok := s.overrides[bucket]
+	for key, o := range s.overrides { // topic specific override wins.
+		parts := strings.SplitN(key, ":", 2)
+		if len(parts) == 2 && parts[1] == bucket && strings.HasPrefix(topic, parts[0]) {
+			override, ok = o, true
+			break
+		}
+	}
+	if ok {
+		if override.MaxMutationsPerSec > 0 {
+			limits.MaxMutationsPerSec = override.MaxMutationsPerSec
+		}
+		if override.MaxBytesPerSec > 0 {
+			limits.MaxBytesPerSec = override.MaxBytesPerSec
+		}
+		if override.Weight > 0 {
+			limits.Weight = override.Weight
+		}
+	}
+	if limits.Weight <= 0 {
+		limits.Weight = 1
+	}
+	return limits
+}
+
+func (s *budgetScheduler) run() {
+	defer func() {
+		if r := recover(); r != nil {
+			logging.Errorf("%v budget scheduler crashed: %v\n", s.logPrefix, r)
+			logging.Errorf("%s", logging.StackTrace())
+			go s.run()
+		}
+	}()
+
+	tick := time.NewTicker(s.tick)
+	defer tick.Stop()
+	for range tick.C {
+		s.schedule()
+	}
+}
+
+// schedule measures usage of all budgets and re-distributes evaluation
+// CPU, first across budgets in proportion to their weights and then
+// equally across vbucket-workers of each budget.
+func (s *budgetScheduler) schedule() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.budgets) == 0 {
+		return
+	}
+	budgets := make([]*Budget, 0, len(s.budgets))
+	workers := make([][]*workerBudget, 0, len(s.budgets))
+	workerDemands := make([][]int64, 0, len(s.budgets))
+	demands := make([]int64, 0, len(s.budgets))
+	weights := make([]int64, 0, len(s.budgets))
+	for _, b := range s.budgets {
+		ws, wdemands := b.measure(s.tick)
+		demand := int64(0)
+		for _, wdemand := range wdemands {
+			if wdemand < 0 {
+				demand = -1 // unbounded
+				break
+			}
+			demand += wdemand
+		}
+		budgets = append(budgets, b)
+		workers = append(workers, ws)
+		workerDemands = append(workerDemands, wdemands)
+		demands = append(demands, demand)
+		weights = append(weights, b.weight())
+	}
+
+	if !s.evalCpuShare || s.cpuCapacity <= 0 {
+		for i, b := range budgets {
+			b.setCpuShare(0)
+			for _, w := range workers[i] {
+				w.setCpuShare(0)
+			}
+		}
+		return
+	}
+
+	shares := fairShares(s.cpuCapacity, demands, weights)
+	for i, b := range budgets {
+		b.setCpuShare(shares[i])
+		equal := make([]int64, len(workers[i]))
+		for j := range equal {
+			equal[j] = 1
+		}
+		wshares := fairShares(shares[i], workerDemands[i], equal)
+		for j, w := range workers[i] {
+			w.setCpuShare(wshares[j])
+		}
+	}
+}
+
+// fairShares divides `capacity` across consumers. Every consumer is
+// entitled to its weighted fair share of capacity. Consumers demanding less
+// than that are capped at their demand, when there are consumers demanding
+// more, and the spare is given to the latter in proportion to their
+// weights, so that shares never add up to more than capacity. A demand of
+// -1 is unbounded. Shares are at least 1, as a share of 0 means no limit.
+func fairShares(capacity int64, demands, weights []int64) []int64 {
+	totalWeight := int64(0)
+	for _, weight := range weights {
+		totalWeight += weight
+	}
+	shares := make([]int64, len(demands))
+	if totalWeight <= 0 {
+		return shares
+	}
+
+	overWeight := int64(0)
+	for i, demand := range demands {
+		shares[i] = capacity * weights[i] / totalWeight
+		if demand < 0 || demand >= shares[i] {
+			overWeight += weights[i]
+		}
+	}
+	if overWeight > 0 {
+		spare := int64(0)
+		for i, demand := range demands {
+			if demand >= 0 && demand < shares[i] {
+				if demand < 1 {
+					demand = 1
+				}
+				spare += shares[i] - demand
+				shares[i] = demand
+			}
+		}
+		for i, demand := range demands {
+			if demand < 0 || demand >= shares[i] {
+				shares[i] += spare * weights[i] / overWeight
+			}
+		}
+	}
+	for i := range shares {
+		if shares[i] < 1 {
+			shares[i] = 1
+		}
+	}
+	return shares
+}
+
+// stats return usage of all budgets.
+func (s *budgetScheduler) stats() map[string]interface{} {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m := make(map[string]interface{})
+	for key, b := range s.budgets {
+		m[key] = b.stats()
+	}
+	m["evalCpuCapacityPct"] = float64(s.cpuCapacity) / 1e7
+	return m
+}
@@ -0,0 +1,150 @@
+package projector
+
+import "reflect"
+import "testing"
+import "time"
+
+func TestRefillTokens(t *testing.T) {
+	tokens := int64(0)
+	// no limit.
+	if d := refillTokens(&tokens, 0, time.Second); d != 0 || tokens != 0 {
+		t.Fatalf("unexpected delay %v tokens %v", d, tokens)
+	}
+	// burst is capped at a second worth of tokens.
+	if d := refillTokens(&tokens, 100, 10*time.Second); d != 0 || tokens != 100 {
+		t.Fatalf("unexpected delay %v tokens %v", d, tokens)
+	}
+	// overdrawn by 200 tokens at 100 per second, half a second refills
+	// 50, leaving 1.5 seconds to wait.
+	tokens = -200
+	if d := refillTokens(&tokens, 100, 500*time.Millisecond); d != 1500*time.Millisecond {
+		t.Fatalf("expected 1.5s, got %v", d)
+	} else if tokens != -150 {
+		t.Fatalf("expected -150 tokens, got %v", tokens)
+	}
+}
+
+func TestBudgetThrottle(t *testing.T) {
+	limits := budgetLimits{MaxMutationsPerSec: 1000, Weight: 1}
+	b := newBudget("MAINT_STREAM_TOPIC", "default", limits)
+	if d := b.delay(); d != 0 {
+		t.Fatalf("expected no delay, got %v", d)
+	}
+
+	b.consume(2000, 0)
+	d := b.delay()
+	if d < 1900*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("expected ~2s delay, got %v", d)
+	} else if b.throttleCount != 1 {
+		t.Fatalf("expected a throttle, got %v", b.throttleCount)
+	}
+
+	// refilled after waiting.
+	b.refilled = b.refilled.Add(-3 * time.Second)
+	if d := b.delay(); d != 0 {
+		t.Fatalf("expected no delay, got %v", d)
+	} else if b.throttleTime <= 0 {
+		t.Fatalf("expected throttle time, got %v", b.throttleTime)
+	}
+
+	// cpu is accounted only by workers.
+	w := b.addWorker(0)
+	w.consumeCPU(time.Second)
+	if d := b.delay(); d != 0 {
+		t.Fatalf("expected no delay, got %v", d)
+	} else if b.cpuTime != int64(time.Second) {
+		t.Fatalf("unexpected budget cpu time %v", b.cpuTime)
+	}
+}
+
+func TestWorkerBudgetThrottle(t *testing.T) {
+	b := newBudget("MAINT_STREAM_TOPIC", "default", budgetLimits{Weight: 1})
+	w := b.addWorker(0)
+	if d := w.delay(); d != 0 {
+		t.Fatalf("expected no delay without a share, got %v", d)
+	}
+
+	w.setCpuShare(int64(100 * time.Millisecond)) // 10% of a core
+	w.consumeCPU(200 * time.Millisecond)
+	if d := w.delay(); d < 1900*time.Millisecond || d > 2*time.Second {
+		t.Fatalf("expected ~2s delay, got %v", d)
+	} else if b.cpuThrottleCount != 1 {
+		t.Fatalf("expected a cpu throttle, got %v", b.cpuThrottleCount)
+	}
+	if demand := w.measure(time.Second); demand != -1 {
+		t.Fatalf("expected unbounded demand when throttled, got %v", demand)
+	}
+	if demand := w.measure(time.Second); demand != 0 {
+		t.Fatalf("expected no demand, got %v", demand)
+	}
+
+	b.removeWorker(w)
+	if len(b.workers) != 0 {
+		t.Fatalf("expected worker to be removed")
+	}
+}
+
+func TestFairShares(t *testing.T) {
+	testCases := []struct {
+		name     string
+		capacity int64
+		demands  []int64
+		weights  []int64
+		shares   []int64
+	}{
+		{"idle", 1000, []int64{0, 0}, []int64{4, 1}, []int64{800, 200}},
+		{"weighted", 1000, []int64{-1, -1}, []int64{4, 1}, []int64{800, 200}},
+		{"spare to busy", 1000, []int64{100, -1}, []int64{4, 1}, []int64{100, 900}},
+		{"spare by weight", 900, []int64{0, -1, -1}, []int64{1, 1, 2},
+			[]int64{1, 299, 599}},
+		{"bounded demand", 1000, []int64{100, 600, 700}, []int64{1, 1, 1},
+			[]int64{100, 449, 449}},
+		{"minimum share", 1, []int64{-1, -1}, []int64{1, 1}, []int64{1, 1}},
+		{"no consumers", 1000, nil, nil, []int64{}},
+	}
+	for _, tc := range testCases {
+		shares := fairShares(tc.capacity, tc.demands, tc.weights)
+		if !reflect.DeepEqual(shares, tc.shares) {
+			t.Errorf("%v: expected %v, got %v", tc.name, tc.shares, shares)
+		}
+	}
+}
+
+func TestScheduleWorkers(t *testing.T) {
+	s := &budgetScheduler{
+		budgets:      make(map[string]*Budget),
+		tick:         time.Second,
+		cpuCapacity:  int64(time.Second),
+		evalCpuShare: true,
+	}
+	maint := newBudget("MAINT_STREAM_TOPIC", "default", budgetLimits{Weight: 4})
+	initb := newBudget("INIT_STREAM_TOPIC", "default", budgetLimits{Weight: 1})
+	s.budgets["maint"], s.budgets["init"] = maint, initb
+
+	busy, idle := maint.addWorker(0), maint.addWorker(1)
+	initw := initb.addWorker(0)
+	busy.cpuThrottled, initw.cpuThrottled = true, true
+	idle.consumeCPU(100 * time.Millisecond)
+
+	s.schedule()
+	if maint.cpuShare != int64(800*time.Millisecond) {
+		t.Fatalf("unexpected maint share %v", maint.cpuShare)
+	} else if initb.cpuShare != int64(200*time.Millisecond) {
+		t.Fatalf("unexpected init share %v", initb.cpuShare)
+	}
+	// idle worker's spare is given to the busy worker of the same budget.
+	if busy.cpuShare != int64(700*time.Millisecond) {
+		t.Fatalf("unexpected busy worker share %v", busy.cpuShare)
+	} else if idle.cpuShare != int64(100*time.Millisecond) {
+		t.Fatalf("unexpected idle worker share %v", idle.cpuShare)
+	} else if initw.cpuShare != int64(200*time.Millisecond) {
+		t.Fatalf("unexpected init worker share %v", initw.cpuShare)
+	}
+
+	// shares are lifted once cpu sharing is disabled.
+	s.evalCpuShare = false
+	s.schedule()
+	if busy.cpuShare != 0 || maint.cpuShare != 0 {
+		t.Fatalf("expected no limit, got %v %v", busy.cpuShare, maint.cpuShare)
+	}
+}
@@ -1841,6 +1841,9 @@ func FeedConfigParams() []string {
 		"dataport.bufferTimeout",
 		"dataport.harakiriTimeout",
 		"dataport.statTick",
-		"dataport.maxPayload"}
+		"dataport.maxPayload",
+		"dataport.compression",
+		"dataport.compressionThreshold",
+		"dataport.flowControl"}
 	return paramNames
 }
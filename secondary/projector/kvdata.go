@@ -13,6 +13,14 @@
 //     GetStatistics() --*
 //                       |
 //             Close() --*
+//
+// events are consumed from upstream within the budget for {topic, bucket},
+// refer budget.go.
+//
+// when any of the endpoints run out of flow control credits for this
+// bucket, runScatter stops reading from upstream until credits are
+// available, upstream DCP feed then stops acknowledging buffers and
+// eventually throttles the producer.
 
 package projector
 
@@ -39,6 +47,7 @@ type KVData struct {
 	// evaluators and subscribers
 	engines   map[uint64]*Engine
 	endpoints map[string]c.RouterEndpoint
+	budget    *Budget
 	// server channels
 	sbch  chan []interface{}
 	finch chan bool
@@ -58,6 +67,8 @@ type KVData struct {
 	ainstCount  int64
 	dinstCount  int64
 	tsCount     int64
+	fcWaitCount int64
+	fcWaitTime  int64 // nanoseconds
 }
 
 // NewKVData create a new data-path instance.
@@ -96,6 +107,9 @@ func NewKVData(
 	for raddr, endpoint := range endpoints {
 		kvdata.endpoints[raddr] = endpoint
 	}
+	if feed.projector != nil {
+		kvdata.budget = feed.projector.budgets.register(feed.topic, bucket)
+	}
 	// start workers
 	kvdata.workers = kvdata.spawnWorkers(feed, bucket, config, opaque)
 	go kvdata.runScatter(reqTs, mutch)
@@ -199,6 +213,9 @@ func (kvdata *KVData) runScatter(
 			worker.Close()
 		}
 		kvdata.workers = nil
+		if kvdata.feed.projector != nil {
+			kvdata.feed.projector.budgets.unregister(kvdata.budget)
+		}
 		kvdata.feed.PostFinKVdata(kvdata.bucket)
 		close(kvdata.finch)
 		logging.Infof("%v ##%x ... stopped\n", kvdata.logPrefix, kvdata.opaque)
@@ -208,7 +225,7 @@ func (kvdata *KVData) runScatter(
 
 	// stats
 	statSince := time.Now()
-	var stitems [16]string
+	var stitems [18]string
 	logstats := func() {
 		snapStat := kvdata.snapStat
 		stitems[0] = `"topic":"` + kvdata.topic + `"`
@@ -227,6 +244,8 @@ func (kvdata *KVData) runScatter(
 		stitems[13] = `"ainstCount":` + strconv.Itoa(int(kvdata.ainstCount))
 		stitems[14] = `"dinstCount":` + strconv.Itoa(int(kvdata.dinstCount))
 		stitems[15] = `"tsCount":` + strconv.Itoa(int(kvdata.tsCount))
+		stitems[16] = `"fcWaitCount":` + strconv.Itoa(int(kvdata.fcWaitCount))
+		stitems[17] = `"fcWaitTime":` + strconv.Itoa(int(kvdata.fcWaitTime))
 		statjson := strings.Join(stitems[:], ",")
 		fmsg := "%v ##%x stats {%v}\n"
 		logging.Infof(fmsg, kvdata.logPrefix, kvdata.opaque, statjson)
@@ -238,15 +257,44 @@ func (kvdata *KVData) runScatter(
 	fmsg := "%v ##%x heartbeat (%v) loaded ...\n"
 	logging.Infof(fmsg, kvdata.logPrefix, kvdata.opaque, kvdata.syncTimeout)
 
+	var fcSince time.Time
+
 loop:
 	for {
+		// stop reading from upstream if downstream has run out of credits.
+		var budgetch <-chan time.Time
+		srcch, creditch := mutch, kvdata.waitCredits()
+		if creditch == nil {
+			// or if this feed has exhausted its budget.
+			if d := kvdata.budget.delay(); d > 0 {
+				srcch, budgetch = nil, time.After(d)
+			}
+		}
+		if creditch != nil {
+			srcch = nil
+			if fcSince.IsZero() {
+				fcSince = time.Now()
+				kvdata.fcWaitCount++
+			}
+		} else if !fcSince.IsZero() {
+			kvdata.fcWaitTime += int64(time.Since(fcSince))
+			fcSince = time.Time{}
+		}
+
 		select {
-		case m, ok := <-mutch:
+		case m, ok := <-srcch:
 			if ok == false { // upstream has closed
 				break loop
 			}
 			kvdata.eventCount++
 			vbseqnos[m.VBucket], _ = kvdata.scatterMutation(m, ts)
+			switch m.Opcode {
+			case mcd.DCP_MUTATION, mcd.DCP_DELETION, mcd.DCP_EXPIRATION:
+				kvdata.budget.consume(1, int64(len(m.Key)+len(m.Value)))
+			}
+
+		case <-creditch:
+		case <-budgetch:
 
 		case <-heartBeat:
 			heartBeat = nil
@@ -343,6 +391,11 @@ loop:
 				stats.Set("addInsts", float64(kvdata.ainstCount))
 				stats.Set("delInsts", float64(kvdata.dinstCount))
 				stats.Set("tsCount", float64(kvdata.tsCount))
+				stats.Set("fcWaitCount", float64(kvdata.fcWaitCount))
+				stats.Set("fcWaitTime", float64(kvdata.fcWaitTime))
+				if budget := kvdata.budget.stats(); budget != nil {
+					stats.Set("budget", budget)
+				}
 				statVbuckets := make(map[string]interface{})
 				for _, worker := range kvdata.workers {
 					if stats, err := worker.GetStatistics(); err != nil {
@@ -400,6 +453,19 @@ loop:
 	logstats()
 }
 
+// waitCredits return a channel to wait on, if any of the endpoints has
+// run out of flow control credits for this bucket.
+func (kvdata *KVData) waitCredits() <-chan struct{} {
+	for _, endpoint := range kvdata.endpoints {
+		if fce, ok := endpoint.(c.FlowControlledEndpoint); ok {
+			if ch := fce.WaitCredits(kvdata.bucket); ch != nil {
+				return ch
+			}
+		}
+	}
+	return nil
+}
+
 func (kvdata *KVData) scatterMutation(
 	m *mc.DcpEvent, ts *protobuf.TsVbuuid) (seqno uint64, err error) {
 
@@ -486,7 +552,8 @@ func (kvdata *KVData) spawnWorkers(
 	nworkers := config["vbucketWorkers"].Int()
 	workers := make([]*VbucketWorker, nworkers)
 	for i := 0; i < nworkers; i++ {
-		workers[i] = NewVbucketWorker(i, feed, bucket, opaque, config)
+		workers[i] = NewVbucketWorker(
+			i, feed, bucket, opaque, config, kvdata.budget)
 	}
 	return workers
 }
@@ -518,6 +585,9 @@ func (kvdata *KVData) newStats() c.Statistics {
 		"delInsts": float64(0),   // no. of delInsts received
 		"tsCount":  float64(0),   // no. of updateTs received
 		"vbuckets": statVbuckets, // per vbucket statistics
+		// no. of times and nanoseconds waited on flow control credits
+		"fcWaitCount": float64(0),
+		"fcWaitTime":  float64(0),
 	}
 	stats, _ := c.NewStatistics(m)
 	return stats
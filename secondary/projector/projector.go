@@ -29,6 +29,7 @@ type Projector struct {
 	topics         map[string]*Feed // active topics
 	topicSerialize map[string]*sync.Mutex
 	config         c.Config // full configuration information.
+	budgets        *budgetScheduler
 	// immutable config params
 	name        string // human readable name of the projector
 	clusterAddr string // kv cluster's address to connect
@@ -61,9 +62,9 @@ func NewProjector(maxvbs int, config c.Config) *Projector {
 	config["projector.routerEndpointFactory"] = ef
 
 	p.config = config
-	p.ResetConfig(config)
-
 	p.logPrefix = fmt.Sprintf("PROJ[%s]", p.adminport)
+	p.budgets = newBudgetScheduler(config, p.logPrefix)
+	p.ResetConfig(config)
 
 	cluster := p.clusterAddr
 	if !strings.HasPrefix(p.clusterAddr, "http://") {
@@ -119,6 +120,9 @@ func (p *Projector) ResetConfig(config c.Config) {
 		logging.Infof("Projector CPU set at %v", cv.Int())
 		c.SetNumCPUs(cv.Int())
 	}
+	if p.budgets != nil {
+		p.budgets.resetConfig(config)
+	}
 	if cv, ok := config["projector.gogc"]; ok {
 		gogc := cv.Int()
 		oldGogc := debug.SetGCPercent(gogc)
@@ -627,6 +631,7 @@ func (p *Projector) doStatistics() interface{} {
 		feeds.Set(topic, feed.GetStatistics())
 	}
 	stats.Set("feeds", feeds)
+	stats.Set("budgets", p.budgets.stats())
 	return map[string]interface{}(stats)
 }
 
@@ -671,6 +676,26 @@ func (p *Projector) handleStats(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "%s", c.Statistics(stats).Lines())
 }
 
+// handle current vs budgeted usage of feeds.
+func (p *Projector) handleBudgets(w http.ResponseWriter, r *http.Request) {
+	valid := validateAuth(w, r)
+	if !valid {
+		return
+	}
+
+	logging.Infof("%s Request %q\n", p.logPrefix, r.URL.Path)
+
+	data, err := json.Marshal(p.budgets.stats())
+	if err != nil {
+		logging.Errorf("%v encoding budgets: %v\n", p.logPrefix, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	header := w.Header()
+	header["Content-Type"] = []string{"application/json"}
+	fmt.Fprintf(w, "%s", string(data))
+}
+
 // handle settings
 func (p *Projector) handleSettings(w http.ResponseWriter, r *http.Request) {
 	valid := validateAuth(w, r)
@@ -21,6 +21,7 @@ package projector
 
 import "fmt"
 import "strconv"
+import "time"
 
 import qvalue "github.com/couchbase/query/value"
 import qexpr "github.com/couchbase/query/expression"
@@ -43,6 +44,7 @@ type VbucketWorker struct {
 	// evaluators and subscribers
 	engines   map[uint64]*Engine
 	endpoints map[string]c.RouterEndpoint
+	budget    *workerBudget // share of feed's evaluation CPU budget
 	// server channels
 	sbch   chan []interface{}
 	datach chan []interface{}
@@ -58,7 +60,7 @@ type VbucketWorker struct {
 // NewVbucketWorker creates a new routine to handle this vbucket stream.
 func NewVbucketWorker(
 	id int, feed *Feed, bucket string,
-	opaque uint16, config c.Config) *VbucketWorker {
+	opaque uint16, config c.Config, budget *Budget) *VbucketWorker {
 
 	mutChanSize := config["mutationChanSize"].Int()
 	encodeBufSize := config["encodeBufSize"].Int()
@@ -74,6 +76,7 @@ func NewVbucketWorker(
 		vbuckets:  make(map[uint16]*Vbucket),
 		engines:   make(map[uint64]*Engine),
 		endpoints: make(map[string]c.RouterEndpoint),
+		budget:    budget.addWorker(id),
 		sbch:      make(chan []interface{}, mutChanSize),
 		datach:    make(chan []interface{}, mutChanSize),
 		finch:     make(chan bool),
@@ -198,14 +201,27 @@ func (worker *VbucketWorker) run(datach, sbch chan []interface{}) {
 				logging.Errorf(fmsg, logPrefix, worker.opaque, v.vbno)
 			}
 		}
+		if worker.budget != nil {
+			worker.budget.budget.removeWorker(worker.budget)
+		}
 		close(worker.finch)
 		logging.Infof("%v ##%x ... stopped\n", logPrefix, worker.opaque)
 	}()
 
 loop:
 	for {
+		// stop picking events once evaluation CPU share is exhausted,
+		// control commands are still served.
+		var budgetch <-chan time.Time
+		eventch := datach
+		if d := worker.budget.delay(); d > 0 {
+			eventch, budgetch = nil, time.After(d)
+		}
+
 		select {
-		case msg := <-datach:
+		case <-budgetch:
+
+		case msg := <-eventch:
 			cmd := msg[0].(byte)
 			switch cmd {
 			case vwCmdEvent:
@@ -412,6 +428,7 @@ func (worker *VbucketWorker) handleEvent(m *mc.DcpEvent) *Vbucket {
 			nvalue = qvalue.NewBinaryValue(m.Value)
 		}
 
+		evalStart := time.Now()
 		context := qexpr.NewIndexContext()
 		docval := qvalue.NewAnnotatedValue(nvalue)
 		for _, engine := range worker.engines {
@@ -432,6 +449,7 @@ func (worker *VbucketWorker) handleEvent(m *mc.DcpEvent) *Vbucket {
 				worker.encodeBuf = newBuf[:0]
 			}
 		}
+		worker.budget.consumeCPU(time.Since(evalStart))
 		// send data to corresponding endpoint.
 		for raddr, data := range dataForEndpoints {
 			if endpoint, ok := worker.endpoints[raddr]; ok {
@@ -10,6 +10,7 @@ It is generated from these files:
 
 It has these top-level messages:
 	Payload
+	BucketCredits
 	VbConnectionMap
 	VbKeyVersions
 	KeyVersions
@@ -113,6 +114,7 @@ type Payload struct {
 	// -- Following fields are mutually exclusive --
 	Vbkeys           []*VbKeyVersions `protobuf:"bytes,2,rep,name=vbkeys" json:"vbkeys,omitempty"`
 	Vbmap            *VbConnectionMap `protobuf:"bytes,3,opt,name=vbmap" json:"vbmap,omitempty"`
+	Credits          []*BucketCredits `protobuf:"bytes,4,rep,name=credits" json:"credits,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -141,6 +143,40 @@ func (m *Payload) GetVbmap() *VbConnectionMap {
 	return nil
 }
 
+func (m *Payload) GetCredits() []*BucketCredits {
+	if m != nil {
+		return m.Credits
+	}
+	return nil
+}
+
+// Flow control credits granted by downstream to router, in number of
+// key-versions. Bucket with empty name specifies the initial window
+// applicable to every bucket streamed on the connection.
+type BucketCredits struct {
+	Bucket           *string `protobuf:"bytes,1,req,name=bucket" json:"bucket,omitempty"`
+	Credits          *uint64 `protobuf:"varint,2,req,name=credits" json:"credits,omitempty"`
+	XXX_unrecognized []byte  `json:"-"`
+}
+
+func (m *BucketCredits) Reset()         { *m = BucketCredits{} }
+func (m *BucketCredits) String() string { return proto.CompactTextString(m) }
+func (*BucketCredits) ProtoMessage()    {}
+
+func (m *BucketCredits) GetBucket() string {
+	if m != nil && m.Bucket != nil {
+		return *m.Bucket
+	}
+	return ""
+}
+
+func (m *BucketCredits) GetCredits() uint64 {
+	if m != nil && m.Credits != nil {
+		return *m.Credits
+	}
+	return 0
+}
+
 // List of vbuckets that will be streamed via a newly opened connection.
 type VbConnectionMap struct {
 	Bucket           *string  `protobuf:"bytes,1,req,name=bucket" json:"bucket,omitempty"`
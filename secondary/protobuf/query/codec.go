@@ -30,6 +30,9 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *CountRequest:
 		pl.CountRequest = val
 
+	case *SplitPointsRequest:
+		pl.SplitPointsRequest = val
+
 	case *ScanRequest:
 		pl.ScanRequest = val
 
@@ -46,6 +49,9 @@ func ProtobufEncodeInBuf(payload interface{}, buf []byte) (data []byte, err erro
 	case *CountResponse:
 		pl.CountResponse = val
 
+	case *SplitPointsResponse:
+		pl.SplitPointsResponse = val
+
 	case *ResponseStream:
 		pl.Stream = val
 
@@ -89,6 +95,8 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetCountRequest(); val != nil {
 		return val, nil
+	} else if val := pl.GetSplitPointsRequest(); val != nil {
+		return val, nil
 	} else if val := pl.GetScanRequest(); val != nil {
 		return val, nil
 	} else if val := pl.GetScanAllRequest(); val != nil {
@@ -102,6 +110,8 @@ func ProtobufDecode(data []byte) (value interface{}, err error) {
 		return val, nil
 	} else if val := pl.GetCountResponse(); val != nil {
 		return val, nil
+	} else if val := pl.GetSplitPointsResponse(); val != nil {
+		return val, nil
 	} else if val := pl.GetEndStream(); val != nil {
 		return val, nil
 	} else if val := pl.GetStreamEnd(); val != nil {
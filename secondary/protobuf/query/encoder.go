@@ -18,6 +18,11 @@ func EncodeAndWrite(conn net.Conn, buf []byte, r interface{}) (err error) {
 		return
 	}
 	flags := transport.TransportFlag(0).SetProtobuf()
+	if cconn, ok := conn.(*transport.CompressedConn); ok {
+		if flags, data, err = cconn.Compress(flags, data); err != nil {
+			return
+		}
+	}
 	err = transport.Send(conn, buf, flags, data, false)
 	return
 }
@@ -23,6 +23,8 @@ It has these top-level messages:
 	StreamEndResponse
 	CountRequest
 	CountResponse
+	SplitPointsRequest
+	SplitPointsResponse
 	Span
 	Range
 	CompositeElementFilter
@@ -107,19 +109,21 @@ func (m *TsConsistency) GetCrc64() uint64 {
 
 // Request can be one of the optional field.
 type QueryPayload struct {
-	Version           *uint32             `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
-	StatisticsRequest *StatisticsRequest  `protobuf:"bytes,2,opt,name=statisticsRequest" json:"statisticsRequest,omitempty"`
-	Statistics        *StatisticsResponse `protobuf:"bytes,3,opt,name=statistics" json:"statistics,omitempty"`
-	ScanRequest       *ScanRequest        `protobuf:"bytes,4,opt,name=scanRequest" json:"scanRequest,omitempty"`
-	ScanAllRequest    *ScanAllRequest     `protobuf:"bytes,5,opt,name=scanAllRequest" json:"scanAllRequest,omitempty"`
-	Stream            *ResponseStream     `protobuf:"bytes,6,opt,name=stream" json:"stream,omitempty"`
-	CountRequest      *CountRequest       `protobuf:"bytes,7,opt,name=countRequest" json:"countRequest,omitempty"`
-	CountResponse     *CountResponse      `protobuf:"bytes,8,opt,name=countResponse" json:"countResponse,omitempty"`
-	EndStream         *EndStreamRequest   `protobuf:"bytes,9,opt,name=endStream" json:"endStream,omitempty"`
-	StreamEnd         *StreamEndResponse  `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
-	HeloRequest       *HeloRequest        `protobuf:"bytes,11,opt,name=heloRequest" json:"heloRequest,omitempty"`
-	HeloResponse      *HeloResponse       `protobuf:"bytes,12,opt,name=heloResponse" json:"heloResponse,omitempty"`
-	XXX_unrecognized  []byte              `json:"-"`
+	Version             *uint32              `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	StatisticsRequest   *StatisticsRequest   `protobuf:"bytes,2,opt,name=statisticsRequest" json:"statisticsRequest,omitempty"`
+	Statistics          *StatisticsResponse  `protobuf:"bytes,3,opt,name=statistics" json:"statistics,omitempty"`
+	ScanRequest         *ScanRequest         `protobuf:"bytes,4,opt,name=scanRequest" json:"scanRequest,omitempty"`
+	ScanAllRequest      *ScanAllRequest      `protobuf:"bytes,5,opt,name=scanAllRequest" json:"scanAllRequest,omitempty"`
+	Stream              *ResponseStream      `protobuf:"bytes,6,opt,name=stream" json:"stream,omitempty"`
+	CountRequest        *CountRequest        `protobuf:"bytes,7,opt,name=countRequest" json:"countRequest,omitempty"`
+	CountResponse       *CountResponse       `protobuf:"bytes,8,opt,name=countResponse" json:"countResponse,omitempty"`
+	EndStream           *EndStreamRequest    `protobuf:"bytes,9,opt,name=endStream" json:"endStream,omitempty"`
+	StreamEnd           *StreamEndResponse   `protobuf:"bytes,10,opt,name=streamEnd" json:"streamEnd,omitempty"`
+	HeloRequest         *HeloRequest         `protobuf:"bytes,11,opt,name=heloRequest" json:"heloRequest,omitempty"`
+	HeloResponse        *HeloResponse        `protobuf:"bytes,12,opt,name=heloResponse" json:"heloResponse,omitempty"`
+	SplitPointsRequest  *SplitPointsRequest  `protobuf:"bytes,13,opt,name=splitPointsRequest" json:"splitPointsRequest,omitempty"`
+	SplitPointsResponse *SplitPointsResponse `protobuf:"bytes,14,opt,name=splitPointsResponse" json:"splitPointsResponse,omitempty"`
+	XXX_unrecognized    []byte               `json:"-"`
 }
 
 func (m *QueryPayload) Reset()         { *m = QueryPayload{} }
@@ -210,9 +214,24 @@ func (m *QueryPayload) GetHeloResponse() *HeloResponse {
 	return nil
 }
 
+func (m *QueryPayload) GetSplitPointsRequest() *SplitPointsRequest {
+	if m != nil {
+		return m.SplitPointsRequest
+	}
+	return nil
+}
+
+func (m *QueryPayload) GetSplitPointsResponse() *SplitPointsResponse {
+	if m != nil {
+		return m.SplitPointsResponse
+	}
+	return nil
+}
+
 // Get current server version/capabilities
 type HeloRequest struct {
 	Version          *uint32 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	Multiplex        *bool   `protobuf:"varint,2,opt,name=multiplex" json:"multiplex,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -227,8 +246,17 @@ func (m *HeloRequest) GetVersion() uint32 {
 	return 0
 }
 
+func (m *HeloRequest) GetMultiplex() bool {
+	if m != nil && m.Multiplex != nil {
+		return *m.Multiplex
+	}
+	return false
+}
+
 type HeloResponse struct {
 	Version          *uint32 `protobuf:"varint,1,req,name=version" json:"version,omitempty"`
+	Multiplex        *bool   `protobuf:"varint,2,opt,name=multiplex" json:"multiplex,omitempty"`
+	SplitPoints      *bool   `protobuf:"varint,3,opt,name=splitPoints" json:"splitPoints,omitempty"`
 	XXX_unrecognized []byte  `json:"-"`
 }
 
@@ -243,6 +271,20 @@ func (m *HeloResponse) GetVersion() uint32 {
 	return 0
 }
 
+func (m *HeloResponse) GetMultiplex() bool {
+	if m != nil && m.Multiplex != nil {
+		return *m.Multiplex
+	}
+	return false
+}
+
+func (m *HeloResponse) GetSplitPoints() bool {
+	if m != nil && m.SplitPoints != nil {
+		return *m.SplitPoints
+	}
+	return false
+}
+
 // Get Index statistics. StatisticsResponse is returned back from indexer.
 type StatisticsRequest struct {
 	DefnID           *uint64 `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -318,6 +360,8 @@ type ScanRequest struct {
 	GroupAggr        *GroupAggr       `protobuf:"bytes,14,opt,name=groupAggr" json:"groupAggr,omitempty"`
 	Sorted           *bool            `protobuf:"varint,15,opt,name=sorted" json:"sorted,omitempty"`
 	DataEncFmt       *uint32          `protobuf:"varint,16,opt,name=dataEncFmt" json:"dataEncFmt,omitempty"`
+	TraceContext     *string          `protobuf:"bytes,17,opt,name=traceContext" json:"traceContext,omitempty"`
+	SnapshotId       *uint64          `protobuf:"varint,18,opt,name=snapshotId" json:"snapshotId,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -437,6 +481,20 @@ func (m *ScanRequest) GetDataEncFmt() uint32 {
 	return 0
 }
 
+func (m *ScanRequest) GetTraceContext() string {
+	if m != nil && m.TraceContext != nil {
+		return *m.TraceContext
+	}
+	return ""
+}
+
+func (m *ScanRequest) GetSnapshotId() uint64 {
+	if m != nil && m.SnapshotId != nil {
+		return *m.SnapshotId
+	}
+	return 0
+}
+
 // Full table scan request from indexer.
 type ScanAllRequest struct {
 	DefnID           *uint64        `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
@@ -666,6 +724,115 @@ func (m *CountResponse) GetErr() *Error {
 	return nil
 }
 
+// Split points request to indexer, approximate keys that split the span
+// into numSplits sub-spans of about the same number of entries.
+type SplitPointsRequest struct {
+	DefnID           *uint64        `protobuf:"varint,1,req,name=defnID" json:"defnID,omitempty"`
+	Span             *Span          `protobuf:"bytes,2,req,name=span" json:"span,omitempty"`
+	NumSplits        *uint32        `protobuf:"varint,3,req,name=numSplits" json:"numSplits,omitempty"`
+	Cons             *uint32        `protobuf:"varint,4,req,name=cons" json:"cons,omitempty"`
+	Vector           *TsConsistency `protobuf:"bytes,5,opt,name=vector" json:"vector,omitempty"`
+	RequestId        *string        `protobuf:"bytes,6,opt,name=requestId" json:"requestId,omitempty"`
+	RollbackTime     *int64         `protobuf:"varint,7,opt,name=rollbackTime" json:"rollbackTime,omitempty"`
+	PartitionIds     []uint64       `protobuf:"varint,8,rep,name=partitionIds" json:"partitionIds,omitempty"`
+	XXX_unrecognized []byte         `json:"-"`
+}
+
+func (m *SplitPointsRequest) Reset()         { *m = SplitPointsRequest{} }
+func (m *SplitPointsRequest) String() string { return proto.CompactTextString(m) }
+func (*SplitPointsRequest) ProtoMessage()    {}
+
+func (m *SplitPointsRequest) GetDefnID() uint64 {
+	if m != nil && m.DefnID != nil {
+		return *m.DefnID
+	}
+	return 0
+}
+
+func (m *SplitPointsRequest) GetSpan() *Span {
+	if m != nil {
+		return m.Span
+	}
+	return nil
+}
+
+func (m *SplitPointsRequest) GetNumSplits() uint32 {
+	if m != nil && m.NumSplits != nil {
+		return *m.NumSplits
+	}
+	return 0
+}
+
+func (m *SplitPointsRequest) GetCons() uint32 {
+	if m != nil && m.Cons != nil {
+		return *m.Cons
+	}
+	return 0
+}
+
+func (m *SplitPointsRequest) GetVector() *TsConsistency {
+	if m != nil {
+		return m.Vector
+	}
+	return nil
+}
+
+func (m *SplitPointsRequest) GetRequestId() string {
+	if m != nil && m.RequestId != nil {
+		return *m.RequestId
+	}
+	return ""
+}
+
+func (m *SplitPointsRequest) GetRollbackTime() int64 {
+	if m != nil && m.RollbackTime != nil {
+		return *m.RollbackTime
+	}
+	return 0
+}
+
+func (m *SplitPointsRequest) GetPartitionIds() []uint64 {
+	if m != nil {
+		return m.PartitionIds
+	}
+	return nil
+}
+
+// split keys in ascending order, json encoded composite keys, and the id
+// of the snapshot they were read from. Snapshot is pinned by the indexer
+// for the sub-range scans, to scan them all from the same snapshot.
+type SplitPointsResponse struct {
+	SplitKeys        [][]byte `protobuf:"bytes,1,rep,name=splitKeys" json:"splitKeys,omitempty"`
+	SnapshotId       *uint64  `protobuf:"varint,2,opt,name=snapshotId" json:"snapshotId,omitempty"`
+	Err              *Error   `protobuf:"bytes,3,opt,name=err" json:"err,omitempty"`
+	XXX_unrecognized []byte   `json:"-"`
+}
+
+func (m *SplitPointsResponse) Reset()         { *m = SplitPointsResponse{} }
+func (m *SplitPointsResponse) String() string { return proto.CompactTextString(m) }
+func (*SplitPointsResponse) ProtoMessage()    {}
+
+func (m *SplitPointsResponse) GetSplitKeys() [][]byte {
+	if m != nil {
+		return m.SplitKeys
+	}
+	return nil
+}
+
+func (m *SplitPointsResponse) GetSnapshotId() uint64 {
+	if m != nil && m.SnapshotId != nil {
+		return *m.SnapshotId
+	}
+	return 0
+}
+
+func (m *SplitPointsResponse) GetErr() *Error {
+	if m != nil {
+		return m.Err
+	}
+	return nil
+}
+
 type Span struct {
 	Range            *Range   `protobuf:"bytes,1,opt,name=range" json:"range,omitempty"`
 	Equals           [][]byte `protobuf:"bytes,2,rep,name=equals" json:"equals,omitempty"`
@@ -0,0 +1,215 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//--------------------------
+// Back index lookup
+//--------------------------
+
+// BackIndexLookupPath is the indexer endpoint serving back index lookups.
+const BackIndexLookupPath = "/backindex/lookup"
+
+const backIndexLookupTimeout = 30 * time.Second
+
+// BackIndexRequest is the body of POST /backindex/lookup.
+type BackIndexRequest struct {
+	DefnId uint64   `json:"defnId"`
+	DocIds []string `json:"docids"`
+	// Documents is optional and maps docid to the document body. For
+	// each document, the key computed by the projector is returned.
+	Documents map[string]json.RawMessage `json:"documents,omitempty"`
+}
+
+// BackIndexResponse return, for each instance and partition of an index,
+// the keys of the requested documents found in the back index.
+type BackIndexResponse struct {
+	DefnId    uint64                   `json:"defnId"`
+	Instances []*BackIndexInstance     `json:"instances"`
+	Projected map[string]*ProjectedKey `json:"projected,omitempty"`
+	// Errors from indexer nodes that could not be reached, by host.
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// BackIndexInstance is an index instance, or replica, of the index.
+type BackIndexInstance struct {
+	InstId     uint64                `json:"instId"`
+	ReplicaId  int                   `json:"replicaId"`
+	Partitions []*BackIndexPartition `json:"partitions"`
+}
+
+// BackIndexPartition holds the back index entries of a partition. The
+// back index is read as of the time of the request, Timestamp is the
+// snapshot that is visible to scans at that time, the back index can be
+// ahead of the snapshot by the mutations not yet flushed to a snapshot.
+type BackIndexPartition struct {
+	PartitionId common.PartitionId `json:"partitionId"`
+	Host        string             `json:"host"`
+	Bucket      string             `json:"bucket"`
+	Timestamp   []uint64           `json:"timestamp,omitempty"` // seqno by vbucket
+	// Keys by docid, a document in an array index can have many keys.
+	// Documents missing in the back index are not indexed in the partition.
+	Entries map[string][]json.RawMessage `json:"entries"`
+	Error   string                       `json:"error,omitempty"`
+}
+
+// ProjectedKey is the key computed for a document supplied with the
+// request, the same way the projector does. Key is omitted if the
+// document is not indexed, either because the where clause is false or
+// the leading key is missing.
+type ProjectedKey struct {
+	Key   json.RawMessage `json:"key,omitempty"`
+	Where bool            `json:"where"`
+	Error string          `json:"error,omitempty"`
+}
+
+// LookupBackIndex implements BridgeAccessor{} interface.
+func (b *metadataClient) LookupBackIndex(req *BackIndexRequest) (*BackIndexResponse, error) {
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+
+	defnID := common.IndexDefnId(req.DefnId)
+	if _, ok := currmeta.defns[defnID]; !ok {
+		return nil, ErrorIndexNotFound
+	}
+
+	// every indexer hosting a partition of an instance of the index.
+	indexers := make(map[common.IndexerId]bool)
+	for _, instId := range currmeta.replicas[defnID] {
+		if inst, ok := currmeta.insts[instId]; ok {
+			for _, indexerId := range inst.IndexerId {
+				indexers[indexerId] = true
+			}
+		}
+	}
+
+	hosts := make([]string, 0, len(indexers))
+	for indexerId := range indexers {
+		if _, _, httpport, err := b.mdClient.FindServiceForIndexer(indexerId); err == nil {
+			hosts = append(hosts, httpport)
+		}
+	}
+	if len(hosts) == 0 {
+		return nil, ErrorNoHost
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var wg sync.WaitGroup
+	responses := make([]*BackIndexResponse, len(hosts))
+	errs := make([]error, len(hosts))
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			responses[i], errs[i] = lookupBackIndex(host, body)
+		}(i, host)
+	}
+	wg.Wait()
+
+	return mergeBackIndexResponses(req.DefnId, hosts, responses, errs)
+}
+
+func lookupBackIndex(host string, body []byte) (*BackIndexResponse, error) {
+	resp, err := postWithAuth(host+BackIndexLookupPath, "application/json",
+		bytes.NewBuffer(body), backIndexLookupTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %s", resp.Status, bytes.TrimSpace(buf))
+	}
+
+	response := &BackIndexResponse{}
+	if err := json.Unmarshal(buf, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+// mergeBackIndexResponses merge the partitions of an instance hosted on
+// different indexer nodes. Error is returned only if no indexer could
+// serve the request.
+func mergeBackIndexResponses(defnID uint64, hosts []string,
+	responses []*BackIndexResponse, errs []error) (*BackIndexResponse, error) {
+
+	result := &BackIndexResponse{DefnId: defnID}
+	insts := make(map[uint64]*BackIndexInstance)
+
+	var err error
+	for i, response := range responses {
+		if errs[i] != nil {
+			logging.Errorf("LookupBackIndex %v: error from %v: %v", defnID, hosts[i], errs[i])
+			if result.Errors == nil {
+				result.Errors = make(map[string]string)
+			}
+			result.Errors[hosts[i]] = errs[i].Error()
+			err = errs[i]
+			continue
+		}
+
+		for _, inst := range response.Instances {
+			if merged, ok := insts[inst.InstId]; ok {
+				merged.Partitions = append(merged.Partitions, inst.Partitions...)
+			} else {
+				insts[inst.InstId] = inst
+				result.Instances = append(result.Instances, inst)
+			}
+		}
+		if result.Projected == nil {
+			result.Projected = response.Projected
+		}
+	}
+
+	if len(result.Errors) == len(hosts) {
+		return nil, err
+	}
+
+	sort.Sort(backIndexInstanceSorter(result.Instances))
+	for _, inst := range result.Instances {
+		sort.Sort(backIndexPartitionSorter(inst.Partitions))
+	}
+	return result, nil
+}
+
+type backIndexInstanceSorter []*BackIndexInstance
+
+func (s backIndexInstanceSorter) Len() int           { return len(s) }
+func (s backIndexInstanceSorter) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s backIndexInstanceSorter) Less(i, j int) bool { return s[i].ReplicaId < s[j].ReplicaId }
+
+type backIndexPartitionSorter []*BackIndexPartition
+
+func (s backIndexPartitionSorter) Len() int      { return len(s) }
+func (s backIndexPartitionSorter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s backIndexPartitionSorter) Less(i, j int) bool {
+	return s[i].PartitionId < s[j].PartitionId
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func newTestBackIndexResponse(host string, replicaIds []int, partnIds ...common.PartitionId) *BackIndexResponse {
+
+	response := &BackIndexResponse{DefnId: 1}
+	for _, replicaId := range replicaIds {
+		inst := &BackIndexInstance{InstId: uint64(10 + replicaId), ReplicaId: replicaId}
+		for _, partnId := range partnIds {
+			inst.Partitions = append(inst.Partitions, &BackIndexPartition{PartitionId: partnId, Host: host})
+		}
+		response.Instances = append(response.Instances, inst)
+	}
+	return response
+}
+
+func TestMergeBackIndexResponses(t *testing.T) {
+
+	errHost := errors.New("connection refused")
+
+	projected := newTestBackIndexResponse("h1", []int{0}, 1)
+	projected.Projected = map[string]*ProjectedKey{"doc1": {Key: json.RawMessage(`["a"]`), Where: true}}
+
+	testcases := []struct {
+		comment   string
+		responses []*BackIndexResponse
+		errs      []error
+		expected  map[uint64][]string // partitions by instance, as host:partition
+		replicas  []int
+		errors    []string
+		failed    bool
+	}{
+		{"single host",
+			[]*BackIndexResponse{newTestBackIndexResponse("h1", []int{0}, 0)},
+			[]error{nil},
+			map[uint64][]string{10: {"h1:0"}}, []int{0}, nil, false},
+		{"partitions across hosts",
+			[]*BackIndexResponse{
+				newTestBackIndexResponse("h1", []int{0}, 4, 2),
+				newTestBackIndexResponse("h2", []int{0}, 3, 1)},
+			[]error{nil, nil},
+			map[uint64][]string{10: {"h2:1", "h1:2", "h2:3", "h1:4"}}, []int{0}, nil, false},
+		{"replicas across hosts",
+			[]*BackIndexResponse{
+				newTestBackIndexResponse("h1", []int{2, 0}, 2, 1),
+				newTestBackIndexResponse("h2", []int{1, 2}, 3)},
+			[]error{nil, nil},
+			map[uint64][]string{
+				10: {"h1:1", "h1:2"},
+				11: {"h2:3"},
+				12: {"h1:1", "h1:2", "h2:3"}},
+			[]int{0, 1, 2}, nil, false},
+		{"host failed",
+			[]*BackIndexResponse{nil, newTestBackIndexResponse("h2", []int{0}, 2)},
+			[]error{errHost, nil},
+			map[uint64][]string{10: {"h2:2"}}, []int{0}, []string{"h1"}, false},
+		{"all hosts failed",
+			[]*BackIndexResponse{nil, nil},
+			[]error{errHost, errHost},
+			nil, nil, nil, true},
+		{"projected",
+			[]*BackIndexResponse{newTestBackIndexResponse("h1", []int{0}, 0), projected},
+			[]error{nil, nil},
+			map[uint64][]string{10: {"h1:0", "h1:1"}}, []int{0}, nil, false},
+	}
+
+	for _, tc := range testcases {
+		hosts := []string{"h1", "h2"}[:len(tc.responses)]
+
+		result, err := mergeBackIndexResponses(1, hosts, tc.responses, tc.errs)
+		if tc.failed {
+			if result != nil || err != errHost {
+				t.Errorf("%v: expected error %v, got %v %v", tc.comment, errHost, result, err)
+			}
+			continue
+		}
+		if err != nil || result == nil {
+			t.Errorf("%v: unexpected error %v", tc.comment, err)
+			continue
+		}
+
+		got := make(map[uint64][]string)
+		var replicas []int
+		for _, inst := range result.Instances {
+			replicas = append(replicas, inst.ReplicaId)
+			for _, partn := range inst.Partitions {
+				got[inst.InstId] = append(got[inst.InstId], fmt.Sprintf("%v:%v", partn.Host, partn.PartitionId))
+			}
+		}
+
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expected, got)
+		}
+		if !reflect.DeepEqual(replicas, tc.replicas) {
+			t.Errorf("%v: expected replicas %v, got %v", tc.comment, tc.replicas, replicas)
+		}
+
+		var errHosts []string
+		for host := range result.Errors {
+			errHosts = append(errHosts, host)
+		}
+		sort.Strings(errHosts)
+		if !reflect.DeepEqual(errHosts, tc.errors) {
+			t.Errorf("%v: expected errors from %v, got %v", tc.comment, tc.errors, result.Errors)
+		}
+
+		if tc.comment == "projected" && !reflect.DeepEqual(result.Projected, projected.Projected) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, projected.Projected, result.Projected)
+		}
+	}
+}
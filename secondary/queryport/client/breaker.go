@@ -0,0 +1,253 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//--------------------------
+// Circuit breaker
+//--------------------------
+
+// CircuitState of the circuit breaker of an indexer node.
+type CircuitState int
+
+const (
+	// CircuitClosed allows scans to the indexer node.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen excludes the indexer node from replica selection.
+	CircuitOpen
+	// CircuitHalfOpen allows a single probing scan to the indexer node.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+func (s CircuitState) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// CircuitBreakerStats is the state of the circuit breaker of an indexer node.
+type CircuitBreakerStats struct {
+	State     CircuitState `json:"state"`
+	Failures  int64        `json:"failures"`
+	OpenedAt  time.Time    `json:"openedAt,omitempty"`
+	LastError string       `json:"lastError,omitempty"`
+}
+
+type circuitBreaker struct {
+	state    CircuitState
+	failures int64
+	openedAt time.Time
+	probeAt  time.Time
+	lastErr  error
+}
+
+//
+// circuitBreakers keeps a circuit breaker per scanport.  The breaker opens
+// after consecutive scan failures (connection errors, timeouts and error
+// responses).  An open breaker excludes the indexer node from replica
+// selection.  After the open timeout, a single probing scan is allowed
+// (half-open).  If the probe succeeds, the breaker is closed.  Otherwise,
+// it opens again.
+//
+type circuitBreakers struct {
+	settings *ClientSettings
+
+	mutex    sync.Mutex
+	breakers map[string]*circuitBreaker
+}
+
+func newCircuitBreakers(settings *ClientSettings) *circuitBreakers {
+
+	return &circuitBreakers{
+		settings: settings,
+		breakers: make(map[string]*circuitBreaker),
+	}
+}
+
+func (b *circuitBreakers) enabled() bool {
+	return b != nil && b.settings != nil && b.settings.CircuitBreakerEnabled()
+}
+
+//
+// Return true if scans can be routed to the scanport.  Once the open timeout
+// has elapsed, the first caller is permitted as the probe, and the breaker
+// becomes half-open.  No other scan is permitted while the probe is in
+// flight.  If the probe does not report back within the open timeout (e.g.
+// the permitted replica is not chosen for the scan), another probe is
+// permitted.
+//
+func (b *circuitBreakers) permit(queryport string) bool {
+
+	if !b.enabled() {
+		return true
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb, ok := b.breakers[queryport]
+	if !ok {
+		return true
+	}
+
+	timeout := b.settings.CircuitBreakerOpenTimeout()
+
+	switch cb.state {
+	case CircuitOpen:
+		if time.Since(cb.openedAt) < timeout {
+			return false
+		}
+	case CircuitHalfOpen:
+		if time.Since(cb.probeAt) < timeout {
+			return false
+		}
+	default:
+		return true
+	}
+
+	cb.state = CircuitHalfOpen
+	cb.probeAt = time.Now()
+	logging.Infof("CircuitBreaker: probing indexer %v", queryport)
+
+	return true
+}
+
+//
+// Record the result of a scan sent to the scanport.
+//
+func (b *circuitBreakers) record(queryport string, err error) {
+
+	if !b.enabled() {
+		return
+	}
+
+	if err != nil && !isBreakerError(err) {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	cb, ok := b.breakers[queryport]
+
+	if err == nil {
+		if ok && cb.state != CircuitClosed {
+			logging.Infof("CircuitBreaker: close circuit breaker for indexer %v", queryport)
+		}
+		if ok {
+			cb.state = CircuitClosed
+			cb.failures = 0
+		}
+		return
+	}
+
+	if !ok {
+		cb = &circuitBreaker{}
+		b.breakers[queryport] = cb
+	}
+
+	cb.failures++
+	cb.lastErr = err
+
+	if cb.state == CircuitHalfOpen ||
+		(cb.state == CircuitClosed && cb.failures >= b.settings.CircuitBreakerThreshold()) {
+
+		if cb.state == CircuitClosed {
+			logging.Warnf("CircuitBreaker: open circuit breaker for indexer %v after %v failures.  Last error %v",
+				queryport, cb.failures, err)
+		}
+		cb.state = CircuitOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+//
+// Forget the breaker of a scanport that is no longer in the cluster.
+//
+func (b *circuitBreakers) remove(queryport string) {
+
+	if b == nil {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	delete(b.breakers, queryport)
+}
+
+//
+// Return state of all circuit breakers.
+//
+func (b *circuitBreakers) stats() map[string]CircuitBreakerStats {
+
+	result := make(map[string]CircuitBreakerStats)
+	if b == nil {
+		return result
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	for queryport, cb := range b.breakers {
+		stats := CircuitBreakerStats{
+			State:    cb.state,
+			Failures: cb.failures,
+		}
+		if cb.state != CircuitClosed {
+			stats.OpenedAt = cb.openedAt
+		}
+		if cb.lastErr != nil {
+			stats.LastError = cb.lastErr.Error()
+		}
+		result[queryport] = stats
+	}
+
+	return result
+}
+
+//
+// Errors caused by the caller, or by stale topology, do not reflect
+// health of the indexer node.
+//
+func isBreakerError(err error) bool {
+
+	if err == context.Canceled || err == context.DeadlineExceeded {
+		return false
+	}
+
+	switch err.Error() {
+	case common.ErrClientCancel.Error(),
+		common.ErrIndexNotFound.Error(),
+		common.ErrIndexNotReady.Error():
+		return false
+	}
+
+	return !strings.Contains(err.Error(), NotMyPartition)
+}
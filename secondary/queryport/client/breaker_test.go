@@ -0,0 +1,187 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+const testBreakerTimeout = 50 * time.Millisecond
+
+func newTestCircuitBreakers(enable bool, threshold int64) *circuitBreakers {
+
+	settings := &ClientSettings{
+		breakerThreshold:   threshold,
+		breakerOpenTimeout: int64(testBreakerTimeout / time.Millisecond),
+	}
+	if enable {
+		settings.breakerEnable = 1
+	}
+
+	return newCircuitBreakers(settings)
+}
+
+func checkBreakerState(t *testing.T, comment string, b *circuitBreakers, queryport string, state CircuitState) {
+
+	stats, ok := b.stats()[queryport]
+	if !ok {
+		stats.State = CircuitClosed
+	}
+
+	if stats.State != state {
+		t.Errorf("%v: expected state %v, got %v", comment, state, stats.State)
+	}
+}
+
+func TestCircuitBreakerOpen(t *testing.T) {
+
+	q := "host:9101"
+	scanErr := errors.New("connection reset")
+
+	b := newTestCircuitBreakers(true, 3)
+
+	if !b.permit(q) {
+		t.Errorf("unknown scanport: expected permit")
+	}
+
+	// errors caused by the caller or stale topology are ignored
+	for _, err := range []error{context.Canceled, context.DeadlineExceeded, common.ErrClientCancel,
+		common.ErrIndexNotFound, errors.New(NotMyPartition + " 10")} {
+		b.record(q, err)
+	}
+	checkBreakerState(t, "ignored errors", b, q, CircuitClosed)
+
+	// a success resets the consecutive failures
+	b.record(q, scanErr)
+	b.record(q, scanErr)
+	b.record(q, nil)
+	b.record(q, scanErr)
+	b.record(q, scanErr)
+	checkBreakerState(t, "below threshold", b, q, CircuitClosed)
+	if !b.permit(q) {
+		t.Errorf("below threshold: expected permit")
+	}
+
+	b.record(q, scanErr)
+	checkBreakerState(t, "threshold", b, q, CircuitOpen)
+	if b.permit(q) {
+		t.Errorf("open: expected no permit")
+	}
+
+	if stats := b.stats()[q]; stats.Failures != 3 || stats.LastError != scanErr.Error() || stats.OpenedAt.IsZero() {
+		t.Errorf("open: unexpected stats %+v", stats)
+	}
+
+	// other scanports are not affected
+	if !b.permit("other:9101") {
+		t.Errorf("other scanport: expected permit")
+	}
+
+	b.remove(q)
+	checkBreakerState(t, "removed", b, q, CircuitClosed)
+	if !b.permit(q) {
+		t.Errorf("removed: expected permit")
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+
+	q := "host:9101"
+	scanErr := errors.New("connection reset")
+
+	b := newTestCircuitBreakers(true, 1)
+	b.record(q, scanErr)
+	checkBreakerState(t, "open", b, q, CircuitOpen)
+
+	// a single probe is permitted once the open timeout elapses
+	time.Sleep(testBreakerTimeout)
+	if !b.permit(q) {
+		t.Fatalf("open timeout: expected probe to be permitted")
+	}
+	checkBreakerState(t, "probe", b, q, CircuitHalfOpen)
+
+	for i := 0; i < 3; i++ {
+		if b.permit(q) {
+			t.Errorf("probe in flight: expected no permit")
+		}
+	}
+
+	// a failed probe opens the breaker again
+	b.record(q, scanErr)
+	checkBreakerState(t, "failed probe", b, q, CircuitOpen)
+	if b.permit(q) {
+		t.Errorf("failed probe: expected no permit")
+	}
+
+	// a probe that does not report back is replaced after the open timeout
+	time.Sleep(testBreakerTimeout)
+	if !b.permit(q) {
+		t.Fatalf("reopen timeout: expected probe to be permitted")
+	}
+	if b.permit(q) {
+		t.Errorf("probe in flight: expected no permit")
+	}
+	time.Sleep(testBreakerTimeout)
+	if !b.permit(q) {
+		t.Fatalf("stale probe: expected another probe to be permitted")
+	}
+	if b.permit(q) {
+		t.Errorf("probe in flight: expected no permit")
+	}
+
+	// a successful probe closes the breaker
+	b.record(q, nil)
+	checkBreakerState(t, "successful probe", b, q, CircuitClosed)
+	for i := 0; i < 3; i++ {
+		if !b.permit(q) {
+			t.Errorf("closed: expected permit")
+		}
+	}
+}
+
+func TestCircuitBreakerConcurrentProbe(t *testing.T) {
+
+	q := "host:9101"
+
+	b := newTestCircuitBreakers(true, 1)
+	b.record(q, errors.New("connection reset"))
+	time.Sleep(testBreakerTimeout)
+
+	var permitted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.permit(q) {
+				atomic.AddInt32(&permitted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if permitted != 1 {
+		t.Errorf("expected exactly one probe, got %v", permitted)
+	}
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+
+	q := "host:9101"
+
+	for _, b := range []*circuitBreakers{nil, newTestCircuitBreakers(false, 1)} {
+		b.record(q, errors.New("connection reset"))
+
+		if !b.permit(q) {
+			t.Errorf("disabled: expected permit")
+		}
+		if len(b.stats()) != 0 {
+			t.Errorf("disabled: expected no breaker, got %v", b.stats())
+		}
+	}
+}
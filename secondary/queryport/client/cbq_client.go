@@ -16,6 +16,7 @@ import "math"
 import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/common"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
+import mc "github.com/couchbase/indexing/secondary/manager/common"
 
 // indexError for a failed index-request.
 type indexError struct {
@@ -136,7 +137,7 @@ func (b *cbqClient) CreateIndex(
 	name, bucket, using, exprType, whereExpr string,
 	secExprs []string, desc []bool, isPrimary bool,
 	scheme common.PartitionScheme, partitionKeys []string,
-	with []byte) (defnID uint64, err error) {
+	with []byte, ddlReq *mc.DDLRequest) (defnID uint64, err error) {
 
 	var resp *http.Response
 	var mresp indexMetaResponse
@@ -180,7 +181,7 @@ func (b *cbqClient) CreateIndex(
 }
 
 // BuildIndexes implement BridgeAccessor{} interface.
-func (b *cbqClient) BuildIndexes(defnID []uint64) error {
+func (b *cbqClient) BuildIndexes(defnID []uint64, ddlReq *mc.DDLRequest) error {
 	panic("cbqClient does not implement build-indexes")
 }
 
@@ -194,8 +195,48 @@ func (b *cbqClient) AlterReplicaCount(action string, defnID uint64, plan map[str
 	panic("cbqClient does not implement alter replica count")
 }
 
+// AlterIndexDefinition implement BridgeAccessor{} interface.
+func (b *cbqClient) AlterIndexDefinition(
+	defnID uint64, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme common.PartitionScheme, partitionKeys []string,
+	with []byte, ddlReq *mc.DDLRequest) error {
+
+	panic("cbqClient does not implement alter index definition")
+}
+
+// AbortAlterIndex implement BridgeAccessor{} interface.
+func (b *cbqClient) AbortAlterIndex(defnID uint64) error {
+	panic("cbqClient does not implement abort alter index")
+}
+
+// RenameIndex implement BridgeAccessor{} interface.
+func (b *cbqClient) RenameIndex(defnID uint64, name string) error {
+	panic("cbqClient does not implement rename index")
+}
+
+// SetIndexAlias implement BridgeAccessor{} interface.
+func (b *cbqClient) SetIndexAlias(bucket, alias string, defnID uint64) error {
+	panic("cbqClient does not implement set index alias")
+}
+
+// DropIndexAlias implement BridgeAccessor{} interface.
+func (b *cbqClient) DropIndexAlias(bucket, alias string) error {
+	panic("cbqClient does not implement drop index alias")
+}
+
+// ResolveIndexAlias implement BridgeAccessor{} interface.
+func (b *cbqClient) ResolveIndexAlias(bucket, alias string) (uint64, error) {
+	panic("cbqClient does not implement resolve index alias")
+}
+
+// LookupBackIndex implement BridgeAccessor{} interface.
+func (b *cbqClient) LookupBackIndex(req *BackIndexRequest) (*BackIndexResponse, error) {
+	panic("cbqClient does not implement lookup back index")
+}
+
 // DropIndex implement BridgeAccessor{} interface.
-func (b *cbqClient) DropIndex(defnID uint64) error {
+func (b *cbqClient) DropIndex(defnID uint64, ddlReq *mc.DDLRequest) error {
 	var resp *http.Response
 
 	// Construct request body.
@@ -238,6 +279,15 @@ func (b *cbqClient) GetScanport(
 	return []string{b.queryport}, defnID, nil, []int64{math.MaxInt64}, nil, 0, true
 }
 
+// GetHedgeScanport implement BridgeAccessor{} interface.
+func (b *cbqClient) GetHedgeScanport(
+	defnID uint64, instID uint64,
+	partitions []common.PartitionId) (queryport string, targetDefnID uint64,
+	targetInstID uint64, rollbackTime int64, ok bool) {
+
+	return "", 0, 0, 0, false
+}
+
 // GetIndexDefn implements BridgeAccessor{} interface.
 func (b *cbqClient) GetIndexDefn(defnID uint64) *common.IndexDefn {
 	panic("cbqClient does not implement GetIndexDefn")
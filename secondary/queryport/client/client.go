@@ -20,6 +20,8 @@ import "strings"
 import "github.com/couchbase/indexing/secondary/logging"
 import "github.com/couchbase/indexing/secondary/common"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
+import mc "github.com/couchbase/indexing/secondary/manager/common"
+import "github.com/couchbase/indexing/secondary/tracing"
 import "github.com/couchbase/query/value"
 
 // TODO:
@@ -56,8 +58,10 @@ type ResponseSender func(pkey []byte, mskey []value.Value, uskey common.ScanResu
 // ResponseHandlerFactory returns an instance of ResponseHandler
 type ResponseHandlerFactory func(id ResponseHandlerId, instId uint64, partitions []common.PartitionId) ResponseHandler
 
-// ScanRequestHandler initiates a request to a single server connection
-type ScanRequestHandler func(*GsiScanClient, *common.IndexDefn, int64, []common.PartitionId, ResponseHandler) (error, bool)
+// ScanRequestHandler initiates a request to a single server connection.
+// The last argument is the trace context to propagate to the indexer,
+// empty if the request is not traced.
+type ScanRequestHandler func(*GsiScanClient, *common.IndexDefn, int64, []common.PartitionId, ResponseHandler, string) (error, bool)
 
 // CountRequestHandler initiates a request to a single server connection
 type CountRequestHandler func(*GsiScanClient, *common.IndexDefn, int64, []common.PartitionId) (int64, error, bool)
@@ -173,15 +177,18 @@ type BridgeAccessor interface {
 	//      specify whether the index is created on docid.
 	// with
 	//      JSON marshalled description about index deployment (and more...).
+	// ddlReq
+	//      credential and context of the request, recorded in DDL history,
+	//      can be nil.
 	CreateIndex(
 		name, bucket, using, exprType, whereExpr string,
 		secExprs []string, desc []bool, isPrimary bool,
 		scheme common.PartitionScheme, partitionKeys []string,
-		with []byte) (defnID uint64, err error)
+		with []byte, ddlReq *mc.DDLRequest) (defnID uint64, err error)
 
 	// BuildIndexes to build a deferred set of indexes. This call implies
 	// that indexes specified are already created.
-	BuildIndexes(defnIDs []uint64) error
+	BuildIndexes(defnIDs []uint64, ddlReq *mc.DDLRequest) error
 
 	// MoveIndex to move a set of indexes to different node.
 	MoveIndex(defnID uint64, with map[string]interface{}) error
@@ -189,10 +196,36 @@ type BridgeAccessor interface {
 	// AlterReplicaCount to change replica count of index
 	AlterReplicaCount(action string, defnID uint64, with map[string]interface{}) error
 
+	// AlterIndexDefinition to change the definition of index specified
+	// by `defnID`. The index is rebuilt with the new definition and
+	// swapped in once caught up, without being unavailable to query.
+	AlterIndexDefinition(
+		defnID uint64, using, exprType, whereExpr string,
+		secExprs []string, desc []bool, isPrimary bool,
+		scheme common.PartitionScheme, partitionKeys []string,
+		with []byte, ddlReq *mc.DDLRequest) error
+
+	// AbortAlterIndex to abort an in-progress AlterIndexDefinition.
+	AbortAlterIndex(defnID uint64) error
+
+	// RenameIndex to rename index specified by `defnID`.
+	RenameIndex(defnID uint64, name string) error
+
+	// SetIndexAlias to create an index alias, or retarget an existing
+	// index alias, to index specified by `defnID`.
+	SetIndexAlias(bucket, alias string, defnID uint64) error
+
+	// DropIndexAlias to drop an index alias.
+	DropIndexAlias(bucket, alias string) error
+
+	// ResolveIndexAlias shall return the index pointed to by the alias,
+	// ErrorIndexNotFound if alias does not exist or index is dropped.
+	ResolveIndexAlias(bucket, alias string) (defnID uint64, err error)
+
 	// DropIndex to drop index specified by `defnID`.
 	// - if index is in deferred build state, it shall be removed
 	//   from deferred list.
-	DropIndex(defnID uint64) error
+	DropIndex(defnID uint64, ddlReq *mc.DDLRequest) error
 
 	// GetScanports shall return list of queryports for all indexer in
 	// the cluster.
@@ -209,6 +242,15 @@ type BridgeAccessor interface {
 		skips map[common.IndexDefnId]bool) (queryport []string, targetDefnID uint64, targetInstID []uint64,
 		rollbackTime []int64, partition [][]common.PartitionId, numPartitions uint32, ok bool)
 
+	// GetHedgeScanport shall fetch queryport address for an indexer, other
+	// than the one hosting `instID`, that hosts all of `partitions` in a
+	// replica of the index or an equivalent index, and whose replica is not
+	// lagging behind. This indexer is used for hedging a slow scan.
+	GetHedgeScanport(
+		defnID uint64, instID uint64,
+		partitions []common.PartitionId) (queryport string, targetDefnID uint64,
+		targetInstID uint64, rollbackTime int64, ok bool)
+
 	// GetIndexDefn will return the index-definition structure for defnID.
 	GetIndexDefn(defnID uint64) *common.IndexDefn
 
@@ -230,6 +272,10 @@ type BridgeAccessor interface {
 	// Timeit will add `value` to incrementalAvg for index-load.
 	Timeit(instID uint64, partitionId common.PartitionId, value float64)
 
+	// LookupBackIndex shall return the keys of documents in the back
+	// index of every instance and partition of the index.
+	LookupBackIndex(req *BackIndexRequest) (*BackIndexResponse, error)
+
 	// Close this accessor.
 	Close()
 }
@@ -381,6 +427,9 @@ type GsiClient struct {
 	numScans     int64
 	scanResponse int64
 	dataEncFmt   uint32
+	hedger       *scanHedger
+	breakers     *circuitBreakers
+	tracer       *tracing.Tracer
 }
 
 // NewGsiClient returns client to access GSI cluster.
@@ -523,6 +572,18 @@ func (c *GsiClient) CreateIndex3(
 	scheme common.PartitionScheme, partitionKeys []string,
 	with []byte) (defnID uint64, err error) {
 
+	return c.CreateIndex4(name, bucket, using, exprType, whereExpr,
+		secExprs, desc, isPrimary, scheme, partitionKeys, with, nil)
+}
+
+// CreateIndex4 is CreateIndex3 on behalf of the request `ddlReq`, that
+// is recorded in DDL history.
+func (c *GsiClient) CreateIndex4(
+	name, bucket, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme common.PartitionScheme, partitionKeys []string,
+	with []byte, ddlReq *mc.DDLRequest) (defnID uint64, err error) {
+
 	err = common.IsValidIndexName(name)
 	if err != nil {
 		return 0, err
@@ -534,7 +595,7 @@ func (c *GsiClient) CreateIndex3(
 	begin := time.Now()
 	defnID, err = c.bridge.CreateIndex(
 		name, bucket, using, exprType, whereExpr,
-		secExprs, desc, isPrimary, scheme, partitionKeys, with)
+		secExprs, desc, isPrimary, scheme, partitionKeys, with, ddlReq)
 	fmsg := "CreateIndex %v %v/%v using:%v exprType:%v " +
 		"whereExpr:%v secExprs:%v desc:%v isPrimary:%v scheme:%v " +
 		" partitionKeys:%v with:%v - elapsed(%v) err(%v)"
@@ -546,11 +607,17 @@ func (c *GsiClient) CreateIndex3(
 
 // BuildIndexes implements BridgeAccessor{} interface.
 func (c *GsiClient) BuildIndexes(defnIDs []uint64) error {
+	return c.BuildIndexes2(defnIDs, nil)
+}
+
+// BuildIndexes2 is BuildIndexes on behalf of the request `ddlReq`, that
+// is recorded in DDL history.
+func (c *GsiClient) BuildIndexes2(defnIDs []uint64, ddlReq *mc.DDLRequest) error {
 	if c.bridge == nil {
 		return ErrorClientUninitialized
 	}
 	begin := time.Now()
-	err := c.bridge.BuildIndexes(defnIDs)
+	err := c.bridge.BuildIndexes(defnIDs, ddlReq)
 	fmsg := "BuildIndexes %v - elapsed(%v), err(%v)"
 	logging.Infof(fmsg, defnIDs, time.Since(begin), err)
 	return err
@@ -580,18 +647,126 @@ func (c *GsiClient) AlterReplicaCount(action string, defnID uint64, with map[str
 	return err
 }
 
+// AlterIndexDefinition implements BridgeAccessor{} interface.
+func (c *GsiClient) AlterIndexDefinition(
+	defnID uint64, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme common.PartitionScheme, partitionKeys []string,
+	with []byte) error {
+
+	return c.AlterIndexDefinition2(defnID, using, exprType, whereExpr,
+		secExprs, desc, isPrimary, scheme, partitionKeys, with, nil)
+}
+
+// AlterIndexDefinition2 is AlterIndexDefinition on behalf of the request
+// `ddlReq`, that is recorded in DDL history.
+func (c *GsiClient) AlterIndexDefinition2(
+	defnID uint64, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme common.PartitionScheme, partitionKeys []string,
+	with []byte, ddlReq *mc.DDLRequest) error {
+
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+	begin := time.Now()
+	err := c.bridge.AlterIndexDefinition(defnID, using, exprType, whereExpr,
+		secExprs, desc, isPrimary, scheme, partitionKeys, with, ddlReq)
+	fmsg := "AlterIndexDefinition %v using:%v exprType:%v whereExpr:%v secExprs:%v desc:%v isPrimary:%v scheme:%v " +
+		" partitionKeys:%v with:%v - elapsed(%v) err(%v)"
+	logging.Infof(
+		fmsg, defnID, using, exprType, logging.TagUD(whereExpr), logging.TagUD(secExprs), desc, isPrimary,
+		scheme, logging.TagUD(partitionKeys), string(with), time.Since(begin), err)
+	return err
+}
+
+// AbortAlterIndex implements BridgeAccessor{} interface.
+func (c *GsiClient) AbortAlterIndex(defnID uint64) error {
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+	begin := time.Now()
+	err := c.bridge.AbortAlterIndex(defnID)
+	fmsg := "AbortAlterIndex %v - elapsed(%v), err(%v)"
+	logging.Infof(fmsg, defnID, time.Since(begin), err)
+	return err
+}
+
+// RenameIndex implements BridgeAccessor{} interface.
+func (c *GsiClient) RenameIndex(defnID uint64, name string) error {
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+	begin := time.Now()
+	err := c.bridge.RenameIndex(defnID, name)
+	fmsg := "RenameIndex %v name:%v - elapsed(%v), err(%v)"
+	logging.Infof(fmsg, defnID, name, time.Since(begin), err)
+	return err
+}
+
+// SetIndexAlias implements BridgeAccessor{} interface.
+func (c *GsiClient) SetIndexAlias(bucket, alias string, defnID uint64) error {
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+	begin := time.Now()
+	err := c.bridge.SetIndexAlias(bucket, alias, defnID)
+	fmsg := "SetIndexAlias %v/%v index:%v - elapsed(%v), err(%v)"
+	logging.Infof(fmsg, bucket, alias, defnID, time.Since(begin), err)
+	return err
+}
+
+// DropIndexAlias implements BridgeAccessor{} interface.
+func (c *GsiClient) DropIndexAlias(bucket, alias string) error {
+	if c.bridge == nil {
+		return ErrorClientUninitialized
+	}
+	begin := time.Now()
+	err := c.bridge.DropIndexAlias(bucket, alias)
+	fmsg := "DropIndexAlias %v/%v - elapsed(%v), err(%v)"
+	logging.Infof(fmsg, bucket, alias, time.Since(begin), err)
+	return err
+}
+
+// ResolveIndexAlias implements BridgeAccessor{} interface.
+func (c *GsiClient) ResolveIndexAlias(bucket, alias string) (uint64, error) {
+	if c.bridge == nil {
+		return 0, ErrorClientUninitialized
+	}
+	return c.bridge.ResolveIndexAlias(bucket, alias)
+}
+
 // DropIndex implements BridgeAccessor{} interface.
 func (c *GsiClient) DropIndex(defnID uint64) error {
+	return c.DropIndex2(defnID, nil)
+}
+
+// DropIndex2 is DropIndex on behalf of the request `ddlReq`, that is
+// recorded in DDL history.
+func (c *GsiClient) DropIndex2(defnID uint64, ddlReq *mc.DDLRequest) error {
 	if c.bridge == nil {
 		return ErrorClientUninitialized
 	}
 	begin := time.Now()
-	err := c.bridge.DropIndex(defnID)
+	err := c.bridge.DropIndex(defnID, ddlReq)
 	fmsg := "DropIndex %v - elapsed(%v), err(%v)"
 	logging.Infof(fmsg, defnID, time.Since(begin), err)
 	return err
 }
 
+// LookupBackIndex implements BridgeAccessor{} interface. It is meant for
+// debugging why a document is, or is not, returned by an index.
+func (c *GsiClient) LookupBackIndex(req *BackIndexRequest) (*BackIndexResponse, error) {
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+	begin := time.Now()
+	resp, err := c.bridge.LookupBackIndex(req)
+	fmsg := "LookupBackIndex %v docids:%v - elapsed(%v), err(%v)"
+	logging.Infof(fmsg, req.DefnId, len(req.DocIds), time.Since(begin), err)
+	return resp, err
+}
+
 // LookupStatistics for a single secondary-key.
 func (c *GsiClient) LookupStatistics(
 	defnID uint64, requestId string, value common.SecondaryKey) (common.IndexStatistics, error) {
@@ -638,7 +813,7 @@ func (c *GsiClient) LookupInternal(
 	begin := time.Now()
 
 	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId,
-		callb ResponseHandler) (error, bool) {
+		callb ResponseHandler, traceContext string) (error, bool) {
 		var err error
 
 		dataEncFmt := broker.GetDataEncodingFormat()
@@ -649,10 +824,11 @@ func (c *GsiClient) LookupInternal(
 		}
 		return qc.Lookup(
 			uint64(index.DefnId), requestId, values, distinct, broker.GetLimit(), cons,
-			vector, callb, rollbackTime, partitions, dataEncFmt, broker.DoRetry())
+			vector, callb, rollbackTime, partitions, dataEncFmt, broker.DoRetry(), traceContext)
 	}
 
 	broker.SetScanRequestHandler(handler)
+	broker.SetConsistency(cons)
 	broker.SetLimit(limit)
 
 	_, err = c.doScan(defnID, requestId, broker)
@@ -696,7 +872,7 @@ func (c *GsiClient) RangeInternal(
 	begin := time.Now()
 
 	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId,
-		handler ResponseHandler) (error, bool) {
+		handler ResponseHandler, traceContext string) (error, bool) {
 		var err error
 
 		dataEncFmt := broker.GetDataEncodingFormat()
@@ -722,16 +898,23 @@ func (c *GsiClient) RangeInternal(
 			return qc.RangePrimary(
 				uint64(index.DefnId), requestId, l, h, inclusion, distinct,
 				broker.GetLimit(), cons, vector, handler, rollbackTime,
-				partitions, dataEncFmt, broker.DoRetry())
+				partitions, dataEncFmt, broker.DoRetry(), traceContext)
 		}
 		// dealing with secondary index.
+		if numSplits := c.rangeSplits(qc, index, broker.GetLimit()); numSplits > 1 {
+			return c.splitRange(
+				qc, uint64(index.DefnId), requestId, low, high, inclusion, distinct,
+				broker.GetLimit(), cons, vector, handler, rollbackTime, partitions,
+				dataEncFmt, broker.DoRetry(), traceContext, numSplits)
+		}
 		return qc.Range(
 			uint64(index.DefnId), requestId, low, high, inclusion, distinct,
 			broker.GetLimit(), cons, vector, handler, rollbackTime, partitions,
-			dataEncFmt, broker.DoRetry())
+			dataEncFmt, broker.DoRetry(), traceContext)
 	}
 
 	broker.SetScanRequestHandler(handler)
+	broker.SetConsistency(cons)
 	broker.SetLimit(limit)
 
 	_, err = c.doScan(defnID, requestId, broker)
@@ -773,7 +956,7 @@ func (c *GsiClient) ScanAllInternal(
 	begin := time.Now()
 
 	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId,
-		handler ResponseHandler) (error, bool) {
+		handler ResponseHandler, traceContext string) (error, bool) {
 		var err error
 
 		dataEncFmt := broker.GetDataEncodingFormat()
@@ -787,6 +970,7 @@ func (c *GsiClient) ScanAllInternal(
 	}
 
 	broker.SetScanRequestHandler(handler)
+	broker.SetConsistency(cons)
 	broker.SetLimit(limit)
 
 	_, err = c.doScan(defnID, requestId, broker)
@@ -828,7 +1012,7 @@ func (c *GsiClient) MultiScanInternal(
 	begin := time.Now()
 
 	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId,
-		handler ResponseHandler) (error, bool) {
+		handler ResponseHandler, traceContext string) (error, bool) {
 		var err error
 
 		dataEncFmt := broker.GetDataEncodingFormat()
@@ -842,16 +1026,17 @@ func (c *GsiClient) MultiScanInternal(
 			return qc.MultiScanPrimary(
 				uint64(index.DefnId), requestId, scans, reverse, distinct,
 				projection, broker.GetOffset(), broker.GetLimit(), cons,
-				vector, handler, rollbackTime, partitions, dataEncFmt, broker.DoRetry())
+				vector, handler, rollbackTime, partitions, dataEncFmt, broker.DoRetry(), traceContext)
 		}
 
 		return qc.MultiScan(
 			uint64(index.DefnId), requestId, scans, reverse, distinct,
 			projection, broker.GetOffset(), broker.GetLimit(), cons, vector,
-			handler, rollbackTime, partitions, dataEncFmt, broker.DoRetry())
+			handler, rollbackTime, partitions, dataEncFmt, broker.DoRetry(), traceContext)
 	}
 
 	broker.SetScanRequestHandler(handler)
+	broker.SetConsistency(cons)
 	broker.SetLimit(limit)
 	broker.SetOffset(offset)
 	broker.SetScans(scans)
@@ -1086,7 +1271,7 @@ func (c *GsiClient) Scan3Internal(
 	begin := time.Now()
 
 	handler := func(qc *GsiScanClient, index *common.IndexDefn, rollbackTime int64, partitions []common.PartitionId,
-		handler ResponseHandler) (error, bool) {
+		handler ResponseHandler, traceContext string) (error, bool) {
 		var err error
 
 		dataEncFmt := broker.GetDataEncodingFormat()
@@ -1101,17 +1286,18 @@ func (c *GsiClient) Scan3Internal(
 				uint64(index.DefnId), requestId, scans, reverse, distinct,
 				projection, broker.GetOffset(), broker.GetLimit(), groupAggr,
 				broker.GetSorted(), cons, vector, handler, rollbackTime,
-				partitions, dataEncFmt, broker.DoRetry())
+				partitions, dataEncFmt, broker.DoRetry(), traceContext)
 		}
 
 		return qc.Scan3(
 			uint64(index.DefnId), requestId, scans, reverse, distinct,
 			projection, broker.GetOffset(), broker.GetLimit(), groupAggr,
 			broker.GetSorted(), cons, vector, handler, rollbackTime,
-			partitions, dataEncFmt, broker.DoRetry())
+			partitions, dataEncFmt, broker.DoRetry(), traceContext)
 	}
 
 	broker.SetScanRequestHandler(handler)
+	broker.SetConsistency(cons)
 	broker.SetLimit(limit)
 	broker.SetOffset(offset)
 	broker.SetScans(scans)
@@ -1131,6 +1317,12 @@ func (c *GsiClient) Scan3Internal(
 	return
 }
 
+// CircuitBreakers returns the circuit breaker state of every indexer
+// node that has failed a scan, keyed by scanport.
+func (c *GsiClient) CircuitBreakers() map[string]CircuitBreakerStats {
+	return c.breakers.stats()
+}
+
 // DescribeError return error description as human readable string.
 func (c *GsiClient) DescribeError(err error) string {
 	if desc, ok := errorDescriptions[err.Error()]; ok {
@@ -1160,6 +1352,7 @@ func (c *GsiClient) Close() {
 	for _, qc := range qcs {
 		qc.Close()
 	}
+	c.tracer.Close()
 	close(c.killch)
 }
 
@@ -1179,6 +1372,7 @@ func (c *GsiClient) updateScanClients() {
 		if _, ok := cache[queryport]; !ok {
 			qc.Close()
 			staleclients[queryport] = true
+			c.breakers.remove(queryport)
 		}
 	}
 	if len(newclients) > 0 || len(staleclients) > 0 {
@@ -1299,11 +1493,26 @@ func (c *GsiClient) doScan(defnID uint64, requestId string, broker *RequestBroke
 	var err error
 
 	broker.SetResponseTimer(c.bridge.Timeit)
+	if c.hedger.enabled() {
+		broker.SetHedge(c.hedger, c.bridge.GetHedgeScanport, c.bridge.GetIndexDefn)
+	}
+	broker.SetCircuitBreakers(c.breakers)
 	skips := make(map[common.IndexDefnId]bool)
 
+	span := c.tracer.StartSpan("client.scan")
+	span.SetAttribute("requestId", requestId)
+	span.SetAttribute("defnId", defnID)
+	broker.SetSpan(span)
+	defer span.Finish()
+
 	wait := c.config["retryIntervalScanport"].Int()
 	retry := c.config["retryScanPort"].Int()
 	for i := 0; true; {
+		// do not retry if the caller has cancelled the scan
+		if ctxErr := broker.ContextErr(); ctxErr != nil {
+			return 0, ctxErr
+		}
+
 		foundScanport := false
 
 		if queryports, targetDefnID, targetInstIds, rollbackTimes, partitions, numPartitions, ok := c.bridge.GetScanport(defnID, excludes, skips); ok {
@@ -1487,8 +1696,13 @@ func makeWithMetaProvider(
 		settings:     NewClientSettings(needRefresh),
 		killch:       make(chan bool, 1),
 	}
+	c.hedger = newScanHedger(c.settings)
+	c.breakers = newCircuitBreakers(c.settings)
+	c.tracer = tracing.NewFileTracer("gsiClient", config["tracing.enable"].Bool(),
+		config["tracing.sampleRate"].Float64(), config["tracing.file"].String(),
+		int64(config["tracing.maxFileSize"].Int()))
 	atomic.StorePointer(&c.bucketHash, (unsafe.Pointer)(new(map[string]uint64)))
-	c.bridge, err = newMetaBridgeClient(cluster, config, c.metaCh, c.settings)
+	c.bridge, err = newMetaBridgeClient(cluster, config, c.metaCh, c.settings, c.breakers)
 	if err != nil {
 		return nil, err
 	}
@@ -1523,6 +1737,16 @@ func (c *GsiClient) logstats(killch chan bool) {
 		case <-tick.C:
 			logging.Infof("num concurrent scans {%v}", atomic.LoadInt64(&c.numScans))
 			logging.Infof("average scan response {%v ms}", atomic.LoadInt64(&c.scanResponse)/int64(time.Millisecond))
+			if c.hedger.enabled() {
+				hedged, won := c.hedger.stats()
+				logging.Infof("hedged scans {%v} hedge responded first {%v}", hedged, won)
+			}
+			for queryport, stats := range c.breakers.stats() {
+				if stats.State != CircuitClosed {
+					logging.Infof("circuit breaker %v {%v} failures {%v} since {%v} last error {%v}",
+						queryport, stats.State, stats.Failures, stats.OpenedAt, stats.LastError)
+				}
+			}
 		case <-killch:
 			return
 		}
@@ -42,11 +42,17 @@ type connectionPool struct {
 	relConnBatchSize int32
 	stopCh           chan bool
 	ewma             gometrics.EWMA
+	// advertise to server that compressed responses can be received.
+	acceptCompression bool
+	compStats         *transport.CompressionStats
+	// streams of multiplexed connections, nil if multiplexing is disabled.
+	mux *muxPool
 }
 
 type connection struct {
-	conn net.Conn
-	pkt  *transport.TransportPacket
+	conn  net.Conn
+	pkt   *transport.TransportPacket
+	muxed bool // conn is a stream of a multiplexed connection
 }
 
 func newConnectionPool(
@@ -66,6 +72,7 @@ func newConnectionPool(
 		minPoolSizeWM:    minPoolSizeWM,
 		relConnBatchSize: relConnBatchSize,
 		stopCh:           make(chan bool, 1),
+		compStats:        transport.NewCompressionStats(),
 	}
 	cp.mkConn = cp.defaultMkConn
 	cp.ewma = gometrics.NewEWMA5()
@@ -85,10 +92,14 @@ func (cp *connectionPool) defaultMkConn(host string) (*connection, error) {
 		return nil, err
 	}
 	flags := transport.TransportFlag(0).SetProtobuf()
+	if cp.acceptCompression {
+		flags = flags.SetAcceptCompression()
+	}
 	pkt := transport.NewTransportPacket(cp.maxPayload, flags)
 	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
 	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
-	return &connection{conn, pkt}, nil
+	pkt.SetCompressionStats(cp.compStats)
+	return &connection{conn: conn, pkt: pkt}, nil
 }
 
 func (cp *connectionPool) Close() (err error) {
@@ -99,6 +110,9 @@ func (cp *connectionPool) Close() (err error) {
 		}
 	}()
 	cp.stopCh <- true
+	if cp.mux != nil {
+		cp.mux.close()
+	}
 	close(cp.connections)
 	for connectn := range cp.connections {
 		connectn.conn.Close()
@@ -120,6 +134,13 @@ func (cp *connectionPool) GetWithTimeout(d time.Duration) (connectn *connection,
 		}(&path, time.Now())
 	}
 
+	if cp.mux != nil && cp.mux.enabled() {
+		path = "multiplex"
+		if connectn, err = cp.mux.get(d); err != errorMuxUnsupported {
+			return connectn, err
+		}
+	}
+
 	path = "short-circuit"
 
 	// short-circuit available connetions.
@@ -188,6 +209,10 @@ func (cp *connectionPool) GetWithTimeout(d time.Duration) (connectn *connection,
 
 func (cp *connectionPool) Renew(conn *connection) (*connection, error) {
 
+	if conn.muxed {
+		return cp.mux.renew(conn)
+	}
+
 	newConn, err := cp.mkConn(cp.host)
 	if err == nil {
 		logging.Infof("%v closing unhealthy connection %q\n", cp.logPrefix, conn.conn.LocalAddr())
@@ -203,6 +228,11 @@ func (cp *connectionPool) Get() (*connection, error) {
 }
 
 func (cp *connectionPool) Return(connectn *connection, healthy bool) {
+	if connectn != nil && connectn.muxed {
+		cp.mux.put(connectn)
+		return
+	}
+
 	defer atomic.AddInt32(&cp.curActConns, -1)
 	if connectn == nil || connectn.conn == nil {
 		return
@@ -315,6 +345,13 @@ func (cp *connectionPool) releaseConnsRoutine() {
 			fc := atomic.LoadInt32(&cp.freeConns)
 			if j == CONN_COUNT_LOG_INTERVAL-1 {
 				logging.Infof("%v active conns %v, free conns %v", cp.logPrefix, act, fc)
+				if cp.mux != nil && cp.mux.enabled() {
+					conns, streams := cp.mux.numStreams()
+					logging.Infof("%v multiplexed conns %v, active streams %v", cp.logPrefix, conns, streams)
+				}
+				if cp.acceptCompression {
+					logging.Infof("%v compression stats %v", cp.logPrefix, cp.compStats.Map())
+				}
 			}
 
 			i = (i + 1) % CONN_RELEASE_INTERVAL
@@ -0,0 +1,257 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+//--------------------------
+// Hedged scan
+//--------------------------
+
+// HedgeTargetFinder returns an alternate indexer for scanning the given
+// partitions of an index instance.
+type HedgeTargetFinder func(defnID uint64, instID uint64, partitions []common.PartitionId) (queryport string,
+	targetDefnID uint64, targetInstID uint64, rollbackTime int64, ok bool)
+
+const (
+	// number of latency samples kept per consistency level
+	hedgeSampleSize = 1000
+	// number of samples between recomputing the hedge delay
+	hedgeSampleInterval = 100
+)
+
+//
+// scanHedger keeps track of the latency of the first response of a
+// partition scan, and computes the hedge delay from it.  Latency is
+// tracked per consistency level, since a consistent scan needs to wait
+// for the index to catch up before the first response is sent.
+//
+type scanHedger struct {
+	settings *ClientSettings
+
+	mutex   sync.Mutex
+	windows map[common.Consistency]*latencyWindow
+
+	numHedged  int64
+	numHedgeOk int64
+}
+
+type latencyWindow struct {
+	samples []float64
+	pos     int
+	count   int64
+	delay   int64 // time.Duration, 0 if not yet computed
+}
+
+func newScanHedger(settings *ClientSettings) *scanHedger {
+
+	return &scanHedger{
+		settings: settings,
+		windows:  make(map[common.Consistency]*latencyWindow),
+	}
+}
+
+func (h *scanHedger) enabled() bool {
+	return h != nil && h.settings != nil && h.settings.HedgeEnabled()
+}
+
+//
+// Record the latency of the first response of a partition scan.
+//
+func (h *scanHedger) record(cons common.Consistency, latency time.Duration) {
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	w, ok := h.windows[cons]
+	if !ok {
+		w = &latencyWindow{samples: make([]float64, 0, hedgeSampleSize)}
+		h.windows[cons] = w
+	}
+
+	if len(w.samples) < hedgeSampleSize {
+		w.samples = append(w.samples, float64(latency))
+	} else {
+		w.samples[w.pos] = float64(latency)
+		w.pos = (w.pos + 1) % hedgeSampleSize
+	}
+
+	// recompute the delay periodically rather than on every scan
+	w.count++
+	if w.count%hedgeSampleInterval == 0 {
+		sorted := make([]float64, len(w.samples))
+		copy(sorted, w.samples)
+		sort.Float64s(sorted)
+
+		pos := int(h.settings.HedgePercentile() / 100 * float64(len(sorted)-1))
+		w.delay = int64(sorted[pos])
+	}
+}
+
+//
+// Return the hedge delay for the consistency level.  The delay is the
+// configured percentile of the first response latency, bounded by the
+// min and max delay.  Max delay is used until enough samples are collected.
+//
+func (h *scanHedger) delay(cons common.Consistency) time.Duration {
+
+	min := h.settings.HedgeMinDelay()
+	max := h.settings.HedgeMaxDelay()
+
+	h.mutex.Lock()
+	var delay time.Duration
+	if w, ok := h.windows[cons]; ok {
+		delay = time.Duration(w.delay)
+	}
+	h.mutex.Unlock()
+
+	if delay == 0 || delay > max {
+		return max
+	}
+	if delay < min {
+		return min
+	}
+	return delay
+}
+
+func (h *scanHedger) hedged() {
+	atomic.AddInt64(&h.numHedged, 1)
+}
+
+func (h *scanHedger) hedgeWon() {
+	atomic.AddInt64(&h.numHedgeOk, 1)
+}
+
+//
+// Return number of hedged scans, and number of hedged scans that
+// responded before the original scan.
+//
+func (h *scanHedger) stats() (int64, int64) {
+	return atomic.LoadInt64(&h.numHedged), atomic.LoadInt64(&h.numHedgeOk)
+}
+
+//
+// This function scans the partitions with hedging.  The scan is first sent to
+// the chosen replica.  If its first response does not arrive within the hedge
+// delay, the same scan is sent to an alternate replica (or equivalent index).
+// Whichever replica responds first is used, and the stream of the other replica
+// is ended.  Since rows are only forwarded from the first replica that responds,
+// no duplicate row is sent.  It returns the error, partial flag and the instance
+// that has served the scan.
+//
+func (c *RequestBroker) hedgedScan(id ResponseHandlerId, client *GsiScanClient, index *common.IndexDefn,
+	instId uint64, rollback int64, partition []common.PartitionId) (error, bool, uint64) {
+
+	type result struct {
+		attempt int32
+		instId  uint64
+		err     error
+		partial bool
+	}
+
+	var winner int32 // attempt that has responded first
+	resultch := make(chan *result, 2)
+	firstch := make(chan bool, 1)
+
+	run := func(attempt int32, client *GsiScanClient, index *common.IndexDefn, instId uint64, rollback int64) {
+
+		begin := time.Now()
+		handler := c.factory(id, instId, partition)
+
+		first := true
+		hedge := func(resp ResponseReader) bool {
+			if first {
+				first = false
+				if !atomic.CompareAndSwapInt32(&winner, 0, attempt) {
+					// other replica has responded first.  End the stream.
+					return false
+				}
+				c.hedger.record(c.cons, time.Since(begin))
+				if attempt == 1 {
+					firstch <- true
+				}
+			}
+			return handler(resp)
+		}
+
+		err, partial := c.scanWithBreaker(client, index, instId, rollback, partition, hedge)
+		resultch <- &result{attempt: attempt, instId: instId, err: err, partial: partial}
+	}
+
+	go run(1, client, index, instId, rollback)
+
+	timer := time.NewTimer(c.hedger.delay(c.cons))
+	defer timer.Stop()
+
+	select {
+	case <-firstch:
+		r := <-resultch
+		return r.err, r.partial, r.instId
+	case r := <-resultch:
+		return r.err, r.partial, r.instId
+	case <-timer.C:
+	}
+
+	var altClient *GsiScanClient
+	var altIndex *common.IndexDefn
+
+	queryport, altDefnId, altInstId, altRollback, ok := c.hedgeTarget(uint64(index.DefnId), instId, partition)
+	if ok {
+		altClient = c.maker(queryport)
+		if altDefnId == uint64(index.DefnId) {
+			altIndex = index
+		} else if c.defnFinder != nil {
+			altIndex = c.defnFinder(altDefnId)
+		}
+	}
+
+	if altClient == nil || altIndex == nil {
+		r := <-resultch
+		return r.err, r.partial, r.instId
+	}
+
+	logging.Verbosef("hedgedScan: requestId %v partition %v hedge inst %v with inst %v at %v",
+		c.requestId, partition, instId, altInstId, queryport)
+
+	c.hedger.hedged()
+	go run(2, altClient, altIndex, altInstId, altRollback)
+
+	// Wait for the result of the replica that has responded first.  If a
+	// replica fails without any response, wait for the other replica.
+	var failed *result
+	for pending := 2; pending > 0; pending-- {
+		r := <-resultch
+
+		w := atomic.LoadInt32(&winner)
+		if w == r.attempt || (w == 0 && r.err == nil && atomic.CompareAndSwapInt32(&winner, 0, r.attempt)) {
+			if r.attempt == 2 {
+				c.hedger.hedgeWon()
+			}
+			return r.err, r.partial, r.instId
+		}
+
+		if w == 0 && failed == nil {
+			failed = r
+		}
+	}
+
+	if failed != nil {
+		return failed.err, failed.partial, failed.instId
+	}
+
+	return nil, false, instId
+}
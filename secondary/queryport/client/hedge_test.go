@@ -0,0 +1,232 @@
+package client
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func testHedgeSettings(percentile float64, minDelay int64, maxDelay int64) *ClientSettings {
+
+	return &ClientSettings{
+		hedgeEnable:     1,
+		hedgePercentile: math.Float64bits(percentile),
+		hedgeMinDelay:   minDelay,
+		hedgeMaxDelay:   maxDelay,
+	}
+}
+
+func TestScanHedgerDelay(t *testing.T) {
+
+	h := newScanHedger(testHedgeSettings(90, 5, 1000))
+	max := 1000 * time.Millisecond
+	min := 5 * time.Millisecond
+
+	record := func(cons common.Consistency, n int, latency time.Duration) {
+		for i := 0; i < n; i++ {
+			h.record(cons, latency)
+		}
+	}
+
+	// max delay is used until enough samples are collected
+	if delay := h.delay(common.AnyConsistency); delay != max {
+		t.Errorf("no sample: expected %v, got %v", max, delay)
+	}
+
+	record(common.AnyConsistency, hedgeSampleInterval-1, 10*time.Millisecond)
+	if delay := h.delay(common.AnyConsistency); delay != max {
+		t.Errorf("too few samples: expected %v, got %v", max, delay)
+	}
+
+	// 90th percentile of 1ms ... 100ms
+	h = newScanHedger(testHedgeSettings(90, 5, 1000))
+	for i := 1; i <= hedgeSampleInterval; i++ {
+		h.record(common.AnyConsistency, time.Duration(i)*time.Millisecond)
+	}
+	if delay := h.delay(common.AnyConsistency); delay != 90*time.Millisecond {
+		t.Errorf("percentile: expected %v, got %v", 90*time.Millisecond, delay)
+	}
+
+	// latency is tracked per consistency level
+	if delay := h.delay(common.SessionConsistency); delay != max {
+		t.Errorf("other consistency: expected %v, got %v", max, delay)
+	}
+
+	// the window only keeps the latest samples
+	record(common.AnyConsistency, hedgeSampleSize, 20*time.Millisecond)
+	if delay := h.delay(common.AnyConsistency); delay != 20*time.Millisecond {
+		t.Errorf("window: expected %v, got %v", 20*time.Millisecond, delay)
+	}
+
+	// the delay is bounded by min and max delay
+	record(common.SessionConsistency, hedgeSampleInterval, time.Millisecond)
+	if delay := h.delay(common.SessionConsistency); delay != min {
+		t.Errorf("min delay: expected %v, got %v", min, delay)
+	}
+
+	record(common.QueryConsistency, hedgeSampleInterval, 5*time.Second)
+	if delay := h.delay(common.QueryConsistency); delay != max {
+		t.Errorf("max delay: expected %v, got %v", max, delay)
+	}
+}
+
+//
+// A fake partition scan.  The scan waits for delay, then either fails
+// with err, or sends a single response to the handler.
+//
+type testHedgeScan struct {
+	delay time.Duration
+	err   error
+}
+
+type testHedgeResult struct {
+	mutex    sync.Mutex
+	wg       sync.WaitGroup
+	handled  []uint64 // instances whose response is passed to the handler
+	scanned  []string // queryports scanned
+	scanDefn map[string]common.IndexDefnId
+}
+
+func (r *testHedgeResult) handle(instId uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.handled = append(r.handled, instId)
+}
+
+func newTestHedgeBroker(scans map[string]*testHedgeScan, target bool, altDefnId uint64,
+	r *testHedgeResult) *RequestBroker {
+
+	b := NewRequestBroker("hedge", 256, -1)
+
+	b.SetScanRequestHandler(func(client *GsiScanClient, index *common.IndexDefn, rollback int64,
+		partition []common.PartitionId, handler ResponseHandler, traceContext string) (error, bool) {
+
+		defer r.wg.Done()
+
+		r.mutex.Lock()
+		r.scanned = append(r.scanned, client.queryport)
+		r.scanDefn[client.queryport] = index.DefnId
+		r.mutex.Unlock()
+
+		scan := scans[client.queryport]
+		time.Sleep(scan.delay)
+		if scan.err != nil {
+			return scan.err, false
+		}
+
+		handler(nil)
+		return nil, false
+	})
+
+	b.factory = func(id ResponseHandlerId, instId uint64, partitions []common.PartitionId) ResponseHandler {
+		return func(resp ResponseReader) bool {
+			r.handle(instId)
+			return true
+		}
+	}
+
+	b.maker = func(queryport string) *GsiScanClient {
+		r.wg.Add(1)
+		return &GsiScanClient{queryport: queryport}
+	}
+
+	finder := func(defnID uint64, instID uint64, partitions []common.PartitionId) (string, uint64, uint64, int64, bool) {
+		if !target {
+			return "", 0, 0, 0, false
+		}
+		if altDefnId != 0 {
+			return "alternate", altDefnId, 2, 0, true
+		}
+		return "alternate", defnID, 2, 0, true
+	}
+
+	defnFinder := func(defnID uint64) *common.IndexDefn {
+		return &common.IndexDefn{DefnId: common.IndexDefnId(defnID)}
+	}
+
+	b.SetHedge(newScanHedger(testHedgeSettings(90, 1, 50)), finder, defnFinder)
+	b.SetConsistency(common.AnyConsistency)
+
+	return b
+}
+
+func TestHedgedScan(t *testing.T) {
+
+	err1 := errors.New("primary error")
+	err2 := errors.New("alternate error")
+
+	testcases := []struct {
+		comment   string
+		primary   *testHedgeScan
+		alternate *testHedgeScan
+		target    bool
+		altDefnId uint64
+		err       error
+		instId    uint64
+		handled   []uint64
+		scanned   int
+		hedged    int64
+		hedgeWon  int64
+	}{
+		{"primary responds before hedge delay", &testHedgeScan{}, &testHedgeScan{}, true, 0,
+			nil, 1, []uint64{1}, 1, 0, 0},
+		{"alternate responds first", &testHedgeScan{delay: 300 * time.Millisecond}, &testHedgeScan{}, true, 0,
+			nil, 2, []uint64{2}, 2, 1, 1},
+		{"primary responds before alternate", &testHedgeScan{delay: 100 * time.Millisecond},
+			&testHedgeScan{delay: 400 * time.Millisecond}, true, 0,
+			nil, 1, []uint64{1}, 2, 1, 0},
+		{"primary fails", &testHedgeScan{delay: 100 * time.Millisecond, err: err1},
+			&testHedgeScan{delay: 200 * time.Millisecond}, true, 0,
+			nil, 2, []uint64{2}, 2, 1, 1},
+		{"both fail", &testHedgeScan{delay: 100 * time.Millisecond, err: err1},
+			&testHedgeScan{delay: 200 * time.Millisecond, err: err2}, true, 0,
+			err1, 1, nil, 2, 1, 0},
+		{"no hedge target", &testHedgeScan{delay: 100 * time.Millisecond}, &testHedgeScan{}, false, 0,
+			nil, 1, []uint64{1}, 1, 0, 0},
+		{"equivalent index", &testHedgeScan{delay: 300 * time.Millisecond}, &testHedgeScan{}, true, 200,
+			nil, 2, []uint64{2}, 2, 1, 1},
+	}
+
+	for _, tc := range testcases {
+		r := &testHedgeResult{scanDefn: make(map[string]common.IndexDefnId)}
+		scans := map[string]*testHedgeScan{"primary": tc.primary, "alternate": tc.alternate}
+		b := newTestHedgeBroker(scans, tc.target, tc.altDefnId, r)
+
+		index := &common.IndexDefn{DefnId: common.IndexDefnId(100)}
+		client := b.maker("primary")
+
+		err, _, instId := b.hedgedScan(ResponseHandlerId(0), client, index, 1, 0, []common.PartitionId{0})
+
+		// wait for the slower replica so that its response is accounted for
+		r.wg.Wait()
+
+		if err != tc.err {
+			t.Errorf("%v: expected error %v, got %v", tc.comment, tc.err, err)
+		}
+
+		if instId != tc.instId {
+			t.Errorf("%v: expected inst %v, got %v", tc.comment, tc.instId, instId)
+		}
+
+		// rows are only forwarded from the replica that responds first
+		if len(r.handled) != len(tc.handled) || (len(tc.handled) != 0 && r.handled[0] != tc.handled[0]) {
+			t.Errorf("%v: expected response from %v, got %v", tc.comment, tc.handled, r.handled)
+		}
+
+		if len(r.scanned) != tc.scanned {
+			t.Errorf("%v: expected %v scans, got %v", tc.comment, tc.scanned, r.scanned)
+		}
+
+		if tc.altDefnId != 0 && r.scanDefn["alternate"] != common.IndexDefnId(tc.altDefnId) {
+			t.Errorf("%v: expected alternate scan on defn %v, got %v", tc.comment, tc.altDefnId, r.scanDefn["alternate"])
+		}
+
+		if hedged, won := b.hedger.stats(); hedged != tc.hedged || won != tc.hedgeWon {
+			t.Errorf("%v: expected hedged %v won %v, got %v %v", tc.comment, tc.hedged, tc.hedgeWon, hedged, won)
+		}
+	}
+}
@@ -0,0 +1,430 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/query/value"
+)
+
+//--------------------------
+// Context based scan API
+//--------------------------
+
+// DefaultRowBufferSize is the number of rows buffered by RowIterator
+// when ScanOptions.BufferSize is not set.
+const DefaultRowBufferSize = 256
+
+// ScanOptions for a context based scan.
+type ScanOptions struct {
+	RequestId   string // generated if empty
+	Scans       Scans
+	Reverse     bool
+	Distinct    bool
+	Projection  *IndexProjection
+	Offset      int64
+	Limit       int64
+	GroupAggr   *GroupAggr
+	IndexOrder  *IndexKeyOrder
+	Consistency common.Consistency
+	Vector      *TsConsistency
+	BufferSize  int                  // number of rows buffered ahead of the consumer
+	Partitions  []common.PartitionId // partitions to scan, all partitions if empty
+}
+
+// IndexRow is a single row returned by RowIterator.
+type IndexRow struct {
+	pkey []byte
+	skey common.ScanResultKey
+	vals []value.Value
+}
+
+// RowIterator streams the result of a scan.  Rows are read from the
+// indexers only as fast as the caller consumes them.  The iterator must
+// be closed after use.
+//
+//     it, err := client.Scan3Context(ctx, defnID, opts)
+//     if err != nil { ... }
+//     defer it.Close()
+//     for it.Next() {
+//         row := it.Row()
+//         ...
+//     }
+//     if err := it.Err(); err != nil { ... }
+//
+type RowIterator struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	rowch  chan *IndexRow
+	row    *IndexRow
+	closed int32
+
+	mutex sync.Mutex
+	err   error
+}
+
+// scanFunc runs the scan with the given request broker.
+type scanFunc func(requestId string, broker *RequestBroker) error
+
+//
+// Scan3Context is the context based version of Scan3.  It returns an
+// iterator over the result rows.  Cancelling the context, or exceeding its
+// deadline, stops the scan and ends the stream on every partition connection.
+//
+func (c *GsiClient) Scan3Context(ctx context.Context, defnID uint64, opts *ScanOptions) (*RowIterator, error) {
+
+	if opts == nil {
+		opts = &ScanOptions{}
+	}
+
+	scan := func(requestId string, broker *RequestBroker) error {
+		return c.Scan3Internal(defnID, requestId, opts.Scans, opts.Reverse,
+			opts.Distinct, opts.Projection, opts.Offset, limitOrMax(opts.Limit),
+			opts.GroupAggr, opts.IndexOrder, opts.Consistency, opts.Vector, broker)
+	}
+
+	return c.startScan(ctx, defnID, opts, scan)
+}
+
+//
+// LookupContext is the context based version of Lookup.
+//
+func (c *GsiClient) LookupContext(ctx context.Context, defnID uint64, values []common.SecondaryKey,
+	opts *ScanOptions) (*RowIterator, error) {
+
+	if opts == nil {
+		opts = &ScanOptions{}
+	}
+
+	scan := func(requestId string, broker *RequestBroker) error {
+		return c.LookupInternal(defnID, requestId, values, opts.Distinct,
+			limitOrMax(opts.Limit), opts.Consistency, opts.Vector, broker)
+	}
+
+	return c.startScan(ctx, defnID, opts, scan)
+}
+
+//
+// RangeContext is the context based version of Range.
+//
+func (c *GsiClient) RangeContext(ctx context.Context, defnID uint64, low, high common.SecondaryKey,
+	inclusion Inclusion, opts *ScanOptions) (*RowIterator, error) {
+
+	if opts == nil {
+		opts = &ScanOptions{}
+	}
+
+	scan := func(requestId string, broker *RequestBroker) error {
+		return c.RangeInternal(defnID, requestId, low, high, inclusion, opts.Distinct,
+			limitOrMax(opts.Limit), opts.Consistency, opts.Vector, broker)
+	}
+
+	return c.startScan(ctx, defnID, opts, scan)
+}
+
+//
+// ScanAllContext is the context based version of ScanAll.
+//
+func (c *GsiClient) ScanAllContext(ctx context.Context, defnID uint64, opts *ScanOptions) (*RowIterator, error) {
+
+	if opts == nil {
+		opts = &ScanOptions{}
+	}
+
+	scan := func(requestId string, broker *RequestBroker) error {
+		return c.ScanAllInternal(defnID, requestId, limitOrMax(opts.Limit),
+			opts.Consistency, opts.Vector, broker)
+	}
+
+	return c.startScan(ctx, defnID, opts, scan)
+}
+
+//
+// Start the scan in the background and return the iterator.
+//
+func (c *GsiClient) startScan(ctx context.Context, defnID uint64, opts *ScanOptions,
+	scan scanFunc) (*RowIterator, error) {
+
+	if c.bridge == nil {
+		return nil, ErrorClientUninitialized
+	}
+
+	// check whether the index is present and available before streaming.
+	if _, err := c.bridge.IndexState(defnID); err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	requestId := opts.RequestId
+	if len(requestId) == 0 {
+		uuid, err := common.NewUUID()
+		if err != nil {
+			return nil, err
+		}
+		requestId = uuid.Str()
+	}
+
+	if len(opts.Partitions) != 0 {
+		partitions := opts.Partitions
+		scanAll := scan
+		scan = func(requestId string, broker *RequestBroker) error {
+			broker.SetPartitions(partitions)
+			return scanAll(requestId, broker)
+		}
+	}
+
+	return newRowIterator(ctx, requestId, opts.BufferSize, c.GetDataEncodingFormat(), scan), nil
+}
+
+//
+// Create the iterator and run the scan in the background.  The scan
+// sends the rows to the iterator through the request broker.
+//
+func newRowIterator(ctx context.Context, requestId string, size int,
+	dataEncFmt common.DataEncodingFormat, scan scanFunc) *RowIterator {
+
+	if size <= 0 {
+		size = DefaultRowBufferSize
+	}
+
+	it := &RowIterator{
+		rowch: make(chan *IndexRow, size),
+	}
+	it.ctx, it.cancel = context.WithCancel(ctx)
+
+	broker := it.makeRequestBroker(requestId, dataEncFmt)
+
+	go func() {
+		defer close(it.rowch)
+
+		// an error caused by Close() is not reported to the caller
+		if err := scan(requestId, broker); err != nil && atomic.LoadInt32(&it.closed) == 0 {
+			it.setErr(err)
+		}
+	}()
+
+	return it
+}
+
+//
+// Create a request broker which sends the rows to the iterator.
+// The sender blocks when the iterator buffer is full, which in turn
+// stops reading from the indexer connections.
+//
+func (it *RowIterator) makeRequestBroker(requestId string,
+	dataEncFmt common.DataEncodingFormat) *RequestBroker {
+
+	broker := NewRequestBroker(requestId, 256, -1)
+	broker.SetDataEncodingFormat(dataEncFmt)
+	broker.SetContext(it.ctx)
+
+	factory := func(id ResponseHandlerId, instId uint64, partitions []common.PartitionId) ResponseHandler {
+		return makeDefaultResponseHandler(id, broker, instId, partitions)
+	}
+
+	sender := func(pkey []byte, mskey []value.Value, uskey common.ScanResultKey, tmpbuf *[]byte) (bool, *[]byte) {
+		broker.IncrementSendCount()
+
+		select {
+		case it.rowch <- newIndexRow(pkey, uskey):
+			return true, nil
+		case <-it.ctx.Done():
+			return false, nil
+		}
+	}
+
+	broker.SetResponseHandlerFactory(factory)
+	broker.SetResponseSender(sender)
+
+	return broker
+}
+
+//
+// Next advances the iterator to the next row.  It returns false when
+// there are no more rows, or on error.  Err() should be checked after
+// Next() returns false.
+//
+func (it *RowIterator) Next() bool {
+
+	if atomic.LoadInt32(&it.closed) == 1 {
+		it.row = nil
+		return false
+	}
+
+	select {
+	case row, ok := <-it.rowch:
+		if ok {
+			it.row = row
+			return true
+		}
+
+	case <-it.ctx.Done():
+		if atomic.LoadInt32(&it.closed) == 0 {
+			it.setErr(it.ctx.Err())
+		}
+	}
+
+	it.row = nil
+	return false
+}
+
+//
+// Row returns the current row.
+//
+func (it *RowIterator) Row() *IndexRow {
+	return it.row
+}
+
+//
+// Err returns the error, if any, that stopped the iteration.
+//
+func (it *RowIterator) Err() error {
+
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	return it.err
+}
+
+//
+// Close stops the scan.  The streams are ended and the indexer connections
+// are returned to the pool in the background.
+//
+func (it *RowIterator) Close() error {
+
+	if !atomic.CompareAndSwapInt32(&it.closed, 0, 1) {
+		return nil
+	}
+
+	it.cancel()
+	return nil
+}
+
+func (it *RowIterator) setErr(err error) {
+
+	it.mutex.Lock()
+	defer it.mutex.Unlock()
+
+	if it.err == nil {
+		it.err = err
+	}
+}
+
+func limitOrMax(limit int64) int64 {
+	if limit <= 0 {
+		return math.MaxInt64
+	}
+	return limit
+}
+
+//--------------------------
+// IndexRow
+//--------------------------
+
+//
+// The keys are copied since the response buffer can be reused
+// once the row is handed over to the iterator.
+//
+func newIndexRow(pkey []byte, skey common.ScanResultKey) *IndexRow {
+
+	row := &IndexRow{skey: skey}
+	row.pkey = append([]byte(nil), pkey...)
+	if skey.Skeycjson != nil {
+		row.skey.Skeycjson = append([]byte(nil), skey.Skeycjson...)
+	}
+
+	return row
+}
+
+//
+// PrimaryKey returns the document id of the row.
+//
+func (r *IndexRow) PrimaryKey() []byte {
+	return r.pkey
+}
+
+//
+// DocId returns the document id of the row as string.
+//
+func (r *IndexRow) DocId() string {
+	return string(r.pkey)
+}
+
+//
+// Values returns the secondary key of the row as n1ql values.
+//
+func (r *IndexRow) Values() ([]value.Value, error) {
+
+	if r.vals != nil {
+		return r.vals, nil
+	}
+
+	if r.skey.Skey == nil && r.skey.Skeycjson == nil {
+		r.vals = make([]value.Value, 0)
+		return r.vals, nil
+	}
+
+	buf := make([]byte, 0, len(r.skey.Skeycjson)*common.SECKEY_TMPBUF_MULTIPLIER)
+	vals, err, _ := r.skey.Get(&buf)
+	if err != nil {
+		return nil, err
+	}
+
+	r.vals = vals
+	return r.vals, nil
+}
+
+//
+// Decode decodes the secondary key of the row into dest, one destination
+// per index key, following the rules of encoding/json.  A nil destination
+// skips the key.  Missing keys leave the destination unchanged.
+//
+func (r *IndexRow) Decode(dest ...interface{}) error {
+
+	vals, err := r.Values()
+	if err != nil {
+		return err
+	}
+
+	if len(dest) > len(vals) {
+		return fmt.Errorf("IndexRow.Decode: %v destinations for %v index keys", len(dest), len(vals))
+	}
+
+	for i, d := range dest {
+		if d == nil || vals[i].Type() == value.MISSING {
+			continue
+		}
+
+		if v, ok := d.(*value.Value); ok {
+			*v = vals[i]
+			continue
+		}
+
+		data, err := vals[i].MarshalJSON()
+		if err != nil {
+			return err
+		}
+
+		if err := json.Unmarshal(data, d); err != nil {
+			return fmt.Errorf("IndexRow.Decode: index key %v: %v", i, err)
+		}
+	}
+
+	return nil
+}
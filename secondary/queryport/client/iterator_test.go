@@ -0,0 +1,203 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+//
+// Return a scan which sends numRow rows to the iterator, and then returns
+// err.  If the iterator stops the scan, the scan returns the error of the
+// broker context.  The number of rows accepted by the iterator is counted
+// in sent, and donech is closed when the scan returns.
+//
+func testRowScan(numRow int, err error, sent *int64, donech chan bool) scanFunc {
+
+	return func(requestId string, broker *RequestBroker) error {
+		defer close(donech)
+
+		for i := 0; i < numRow; i++ {
+			pkey := []byte(fmt.Sprintf("doc%v", i))
+			skey := common.ScanResultKey{
+				Skey:       common.SecondaryKey{fmt.Sprintf("name%v", i), float64(i)},
+				DataEncFmt: common.DATA_ENC_JSON,
+			}
+
+			if ok, _ := broker.sender(pkey, nil, skey, nil); !ok {
+				return broker.ctx.Err()
+			}
+			atomic.AddInt64(sent, 1)
+
+			// the iterator owns a copy of the key
+			pkey[0] = 'x'
+		}
+
+		return err
+	}
+}
+
+func waitScanDone(t *testing.T, comment string, donech chan bool) {
+
+	select {
+	case <-donech:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%v: scan is not stopped", comment)
+	}
+}
+
+func TestRowIterator(t *testing.T) {
+
+	scanErr := errors.New("scan error")
+
+	testcases := []struct {
+		comment string
+		numRow  int
+		err     error
+	}{
+		{"no row", 0, nil},
+		{"rows", 1000, nil},
+		{"error after rows", 10, scanErr},
+	}
+
+	for _, tc := range testcases {
+		var sent int64
+		donech := make(chan bool)
+
+		it := newRowIterator(context.Background(), tc.comment, 4, common.DATA_ENC_JSON,
+			testRowScan(tc.numRow, tc.err, &sent, donech))
+
+		count := 0
+		for it.Next() {
+			row := it.Row()
+
+			if docId := fmt.Sprintf("doc%v", count); row.DocId() != docId {
+				t.Errorf("%v: expected row %v, got %v", tc.comment, docId, row.DocId())
+			}
+
+			var name string
+			var age int
+			if err := row.Decode(&name, &age); err != nil {
+				t.Errorf("%v: row %v: unexpected error %v", tc.comment, count, err)
+			} else if name != fmt.Sprintf("name%v", count) || age != count {
+				t.Errorf("%v: row %v: unexpected keys %v %v", tc.comment, count, name, age)
+			}
+
+			count++
+		}
+
+		if count != tc.numRow {
+			t.Errorf("%v: expected %v rows, got %v", tc.comment, tc.numRow, count)
+		}
+
+		if err := it.Err(); err != tc.err {
+			t.Errorf("%v: expected error %v, got %v", tc.comment, tc.err, err)
+		}
+
+		if it.Next() || it.Row() != nil {
+			t.Errorf("%v: expected no row after the end of the scan", tc.comment)
+		}
+
+		it.Close()
+		waitScanDone(t, tc.comment, donech)
+	}
+}
+
+func TestRowIteratorBackpressure(t *testing.T) {
+
+	var sent int64
+	donech := make(chan bool)
+	size := 4
+
+	it := newRowIterator(context.Background(), "backpressure", size, common.DATA_ENC_JSON,
+		testRowScan(100, nil, &sent, donech))
+
+	// the scan blocks once the buffer is full
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&sent); n != int64(size) {
+		t.Errorf("expected %v rows buffered, got %v", size, n)
+	}
+
+	// consuming a row lets the scan send one more row
+	if !it.Next() {
+		t.Fatalf("expected a row, got error %v", it.Err())
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := atomic.LoadInt64(&sent); n != int64(size+1) {
+		t.Errorf("expected %v rows sent, got %v", size+1, n)
+	}
+
+	// Close stops the blocked scan without reporting an error
+	it.Close()
+	waitScanDone(t, "close", donech)
+
+	if it.Next() {
+		t.Errorf("expected no row after close")
+	}
+	if err := it.Err(); err != nil {
+		t.Errorf("expected no error after close, got %v", err)
+	}
+}
+
+func TestRowIteratorContext(t *testing.T) {
+
+	testcases := []struct {
+		comment string
+		err     error
+		ctx     func() (context.Context, context.CancelFunc)
+		stop    func(cancel context.CancelFunc)
+	}{
+		{
+			"cancel",
+			context.Canceled,
+			func() (context.Context, context.CancelFunc) {
+				return context.WithCancel(context.Background())
+			},
+			func(cancel context.CancelFunc) { cancel() },
+		},
+		{
+			"deadline",
+			context.DeadlineExceeded,
+			func() (context.Context, context.CancelFunc) {
+				return context.WithTimeout(context.Background(), 100*time.Millisecond)
+			},
+			func(cancel context.CancelFunc) {},
+		},
+	}
+
+	for _, tc := range testcases {
+		var sent int64
+		donech := make(chan bool)
+
+		ctx, cancel := tc.ctx()
+		it := newRowIterator(ctx, tc.comment, 1, common.DATA_ENC_JSON,
+			testRowScan(100, nil, &sent, donech))
+
+		if !it.Next() {
+			t.Fatalf("%v: expected a row, got error %v", tc.comment, it.Err())
+		}
+
+		// the scan is blocked on the full buffer until the context is done
+		tc.stop(cancel)
+		waitScanDone(t, tc.comment, donech)
+
+		for it.Next() {
+		}
+
+		if err := it.Err(); err != tc.err {
+			t.Errorf("%v: expected error %v, got %v", tc.comment, tc.err, err)
+		}
+
+		if n := atomic.LoadInt64(&sent); n >= 100 {
+			t.Errorf("%v: expected the scan to stop early, got %v rows", tc.comment, n)
+		}
+
+		it.Close()
+		cancel()
+	}
+}
@@ -22,6 +22,7 @@ import "github.com/couchbase/cbauth"
 import "github.com/couchbase/indexing/secondary/logging"
 import common "github.com/couchbase/indexing/secondary/common"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
+import mc "github.com/couchbase/indexing/secondary/manager/common"
 
 type metadataClient struct {
 	cluster  string
@@ -38,8 +39,10 @@ type metadataClient struct {
 	metaCh         chan bool
 	mdNotifyCh     chan bool
 	stNotifyCh     chan map[common.IndexInstId]map[common.PartitionId]common.Statistics
+	deltaCh        chan *mclient.MetadataDelta // nil if metadata feed is disabled
 
 	settings *ClientSettings
+	breakers *circuitBreakers
 
 	refreshLock    sync.Mutex
 	refreshCond    *sync.Cond
@@ -66,7 +69,8 @@ type indexTopology struct {
 }
 
 func newMetaBridgeClient(
-	cluster string, config common.Config, metaCh chan bool, settings *ClientSettings) (c *metadataClient, err error) {
+	cluster string, config common.Config, metaCh chan bool, settings *ClientSettings,
+	breakers *circuitBreakers) (c *metadataClient, err error) {
 
 	b := &metadataClient{
 		cluster:    cluster,
@@ -75,6 +79,7 @@ func newMetaBridgeClient(
 		mdNotifyCh: make(chan bool, 1),
 		stNotifyCh: make(chan map[common.IndexInstId]map[common.PartitionId]common.Statistics, 1),
 		settings:   settings,
+		breakers:   breakers,
 	}
 	b.refreshCond = sync.NewCond(&b.refreshLock)
 	b.refreshCnt = 0
@@ -100,6 +105,12 @@ func newMetaBridgeClient(
 		return nil, err
 	}
 
+	// index changes pushed by indexers are applied incrementally
+	if config["metadataFeed.enable"].Bool() {
+		b.deltaCh = make(chan *mclient.MetadataDelta, 1000)
+		b.mdClient.EnableMetadataFeed(b.deltaCh)
+	}
+
 	go b.watchClusterChanges() // will also update the indexer list
 	go b.logstats()
 	return b, nil
@@ -213,7 +224,7 @@ func (b *metadataClient) CreateIndex(
 	indexName, bucket, using, exprType, whereExpr string,
 	secExprs []string, desc []bool, isPrimary bool,
 	scheme common.PartitionScheme, partitionKeys []string,
-	planJSON []byte) (uint64, error) {
+	planJSON []byte, ddlReq *mc.DDLRequest) (uint64, error) {
 
 	plan := make(map[string]interface{})
 	if planJSON != nil && len(planJSON) > 0 {
@@ -227,7 +238,7 @@ func (b *metadataClient) CreateIndex(
 RETRY:
 	defnID, err, needRefresh := b.mdClient.CreateIndexWithPlan(
 		indexName, bucket, using, exprType, whereExpr,
-		secExprs, desc, isPrimary, scheme, partitionKeys, plan)
+		secExprs, desc, isPrimary, scheme, partitionKeys, plan, ddlReq)
 
 	if needRefresh && refreshCnt == 0 {
 		fmsg := "GsiClient: Indexer Node List is out-of-date.  Require refresh."
@@ -243,7 +254,7 @@ RETRY:
 }
 
 // BuildIndexes implements BridgeAccessor{} interface.
-func (b *metadataClient) BuildIndexes(defnIDs []uint64) error {
+func (b *metadataClient) BuildIndexes(defnIDs []uint64, ddlReq *mc.DDLRequest) error {
 	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
 
 	for _, defnId := range defnIDs {
@@ -256,7 +267,7 @@ func (b *metadataClient) BuildIndexes(defnIDs []uint64) error {
 	for i, id := range defnIDs {
 		ids[i] = common.IndexDefnId(id)
 	}
-	return b.mdClient.BuildIndexes(ids)
+	return b.mdClient.BuildIndexes(ids, ddlReq)
 }
 
 // MoveIndex implements BridgeAccessor{} interface.
@@ -316,9 +327,68 @@ func (b *metadataClient) AlterReplicaCount(action string, defnID uint64, planJSO
 	return b.mdClient.AlterReplicaCount(action, common.IndexDefnId(defnID), planJSON)
 }
 
+// AlterIndexDefinition implements BridgeAccessor{} interface.
+func (b *metadataClient) AlterIndexDefinition(
+	defnID uint64, using, exprType, whereExpr string,
+	secExprs []string, desc []bool, isPrimary bool,
+	scheme common.PartitionScheme, partitionKeys []string,
+	planJSON []byte, ddlReq *mc.DDLRequest) error {
+
+	plan := make(map[string]interface{})
+	if planJSON != nil && len(planJSON) > 0 {
+		err := json.Unmarshal(planJSON, &plan)
+		if err != nil {
+			return err
+		}
+	}
+
+	err := b.mdClient.AlterIndexDefinition(common.IndexDefnId(defnID), using, exprType,
+		whereExpr, secExprs, desc, isPrimary, scheme, partitionKeys, plan, ddlReq)
+	if err == nil { // the altered index replaces the old index in local cache.
+		b.safeupdate(nil, false /*force*/)
+	}
+	return err
+}
+
+// AbortAlterIndex implements BridgeAccessor{} interface.
+func (b *metadataClient) AbortAlterIndex(defnID uint64) error {
+	return b.mdClient.AbortAlterIndex(common.IndexDefnId(defnID))
+}
+
+// RenameIndex implements BridgeAccessor{} interface.
+func (b *metadataClient) RenameIndex(defnID uint64, name string) error {
+	err := b.mdClient.RenameIndex(common.IndexDefnId(defnID), name)
+	if err == nil { // refresh index local cache.
+		b.safeupdate(nil, false /*force*/)
+	}
+	return err
+}
+
+// SetIndexAlias implements BridgeAccessor{} interface.
+func (b *metadataClient) SetIndexAlias(bucket, alias string, defnID uint64) error {
+	return b.mdClient.SetIndexAlias(bucket, alias, common.IndexDefnId(defnID))
+}
+
+// DropIndexAlias implements BridgeAccessor{} interface.
+func (b *metadataClient) DropIndexAlias(bucket, alias string) error {
+	return b.mdClient.DropIndexAlias(bucket, alias)
+}
+
+// ResolveIndexAlias implements BridgeAccessor{} interface.
+func (b *metadataClient) ResolveIndexAlias(bucket, alias string) (uint64, error) {
+	index, err := b.mdClient.ResolveIndexAlias(bucket, alias)
+	if err != nil {
+		return 0, err
+	}
+	if index == nil {
+		return 0, ErrorIndexNotFound
+	}
+	return uint64(index.Definition.DefnId), nil
+}
+
 // DropIndex implements BridgeAccessor{} interface.
-func (b *metadataClient) DropIndex(defnID uint64) error {
-	err := b.mdClient.DropIndex(common.IndexDefnId(defnID))
+func (b *metadataClient) DropIndex(defnID uint64, ddlReq *mc.DDLRequest) error {
+	err := b.mdClient.DropIndex(common.IndexDefnId(defnID), ddlReq)
 	if err == nil { // cleanup index local cache.
 		b.safeupdate(nil, false /*force*/)
 	}
@@ -358,7 +428,8 @@ func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDef
 		n++
 	}
 
-	insts, rollbackTimes, ok = b.pickRandom(replicas[:n], defnID, excludes[common.IndexDefnId(defnID)])
+	instExcludes := b.excludeOpenCircuits(currmeta, replicas[:n], excludes[common.IndexDefnId(defnID)])
+	insts, rollbackTimes, ok = b.pickRandom(replicas[:n], defnID, instExcludes)
 	if !ok {
 		if len(currmeta.equivalents[common.IndexDefnId(defnID)]) > 1 || len(currmeta.replicas[common.IndexDefnId(defnID)]) > 1 {
 			// skip this index definition for retry only if there is equivalent index or replica
@@ -419,6 +490,172 @@ func (b *metadataClient) GetScanport(defnID uint64, excludes map[common.IndexDef
 	return qp, targetDefnID, in, rt, pid, numPartitions, true
 }
 
+// GetHedgeScanport implements BridgeAccessor{} interface.
+func (b *metadataClient) GetHedgeScanport(defnID uint64, instID uint64,
+	partitions []common.PartitionId) (queryport string, targetDefnID uint64,
+	targetInstID uint64, rollbackTime int64, ok bool) {
+
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+
+	if len(partitions) == 0 {
+		return "", 0, 0, 0, false
+	}
+
+	curInst, ok := currmeta.insts[common.IndexInstId(instID)]
+	if !ok {
+		if curInst, ok = currmeta.rebalInsts[common.IndexInstId(instID)]; !ok {
+			return "", 0, 0, 0, false
+		}
+	}
+	curIndexerId := curInst.IndexerId[partitions[0]]
+
+	type candidate struct {
+		queryport    string
+		defnID       uint64
+		instID       uint64
+		rollbackTime int64
+	}
+	var candidates []candidate
+
+	// Look for a replica, or a replica of an equivalent index.  An equivalent
+	// index must have the same number of partitions so that the partition
+	// ids refer to the same set of documents.
+	for _, equivId := range currmeta.equivalents[common.IndexDefnId(defnID)] {
+
+		var replicas []uint64
+		for _, replicaId := range currmeta.replicas[equivId] {
+			replicas = append(replicas, uint64(replicaId))
+		}
+		if len(replicas) == 0 {
+			continue
+		}
+
+		// Only consider replica that is not lagging behind, so the hedged scan
+		// is as consistent as the original scan.
+		rollbackTimesList := b.pruneStaleReplica(replicas, nil)
+
+		for n, replica := range replicas {
+			if replica == instID {
+				continue
+			}
+
+			inst, ok := currmeta.insts[common.IndexInstId(replica)]
+			if !ok || inst.NumPartitions != curInst.NumPartitions {
+				continue
+			}
+
+			indexerId, ok := inst.IndexerId[partitions[0]]
+			if !ok || indexerId == curIndexerId {
+				continue
+			}
+
+			valid := true
+			for _, partnId := range partitions {
+				t, ok := rollbackTimesList[n][partnId]
+				if !ok || t == math.MaxInt64 || inst.IndexerId[partnId] != indexerId {
+					valid = false
+					break
+				}
+			}
+			if !valid {
+				continue
+			}
+
+			q, ok := currmeta.queryports[indexerId]
+			if !ok || !b.breakers.permit(q) {
+				continue
+			}
+
+			candidates = append(candidates, candidate{
+				queryport:    q,
+				defnID:       uint64(equivId),
+				instID:       replica,
+				rollbackTime: rollbackTimesList[n][partitions[0]],
+			})
+		}
+	}
+
+	if len(candidates) == 0 {
+		return "", 0, 0, 0, false
+	}
+
+	c := candidates[rand.Intn(len(candidates))]
+
+	fmsg := "Hedge scan port %s for index defnID %d inst %d partitions %v"
+	logging.Debugf(fmsg, c.queryport, c.defnID, c.instID, partitions)
+	return c.queryport, c.defnID, c.instID, c.rollbackTime, true
+}
+
+//
+// Exclude replica partitions residing on an indexer node with an open circuit
+// breaker.  If all replicas of a partition reside on such nodes, the circuit
+// breakers are ignored for that partition, so that the scan is still attempted.
+//
+func (b *metadataClient) excludeOpenCircuits(currmeta *indexTopology, replicas []uint64,
+	excludes map[common.PartitionId]map[uint64]bool) map[common.PartitionId]map[uint64]bool {
+
+	if !b.breakers.enabled() {
+		return excludes
+	}
+
+	permits := make(map[common.IndexerId]bool)
+	blocked := make(map[common.PartitionId][]uint64)
+	total := make(map[common.PartitionId]int)
+
+	for _, replica := range replicas {
+		inst, ok := currmeta.insts[common.IndexInstId(replica)]
+		if !ok {
+			continue
+		}
+
+		for partnId, indexerId := range inst.IndexerId {
+			if excludes[partnId][replica] {
+				continue
+			}
+			total[partnId]++
+
+			permit, ok := permits[indexerId]
+			if !ok {
+				permit = b.breakers.permit(currmeta.queryports[indexerId])
+				permits[indexerId] = permit
+			}
+
+			if !permit {
+				blocked[partnId] = append(blocked[partnId], replica)
+			}
+		}
+	}
+
+	var result map[common.PartitionId]map[uint64]bool
+	for partnId, instIds := range blocked {
+		if len(instIds) >= total[partnId] {
+			continue
+		}
+
+		if result == nil {
+			result = make(map[common.PartitionId]map[uint64]bool)
+			for p, m := range excludes {
+				result[p] = make(map[uint64]bool)
+				for instId, exclude := range m {
+					result[p][instId] = exclude
+				}
+			}
+		}
+
+		if _, ok := result[partnId]; !ok {
+			result[partnId] = make(map[uint64]bool)
+		}
+		for _, instId := range instIds {
+			result[partnId][instId] = true
+		}
+	}
+
+	if result == nil {
+		return excludes
+	}
+	return result
+}
+
 // Timeit implement BridgeAccessor{} interface.
 func (b *metadataClient) Timeit(instID uint64, partitionId common.PartitionId, value float64) {
 
@@ -1501,6 +1738,26 @@ func (b *metadataClient) updateTopology(
 		return currmeta
 	}
 
+	queryports := make(map[common.IndexerId]string)
+	for _, indexerID := range adminports {
+		_, qp, _, err := b.mdClient.FindServiceForIndexer(indexerID)
+		if err == nil {
+			// This excludes watcher that is not currently connected
+			queryports[indexerID] = qp
+		}
+	}
+
+	return b.buildTopology(currmeta, adminports, queryports, mindexes, version)
+}
+
+//
+// Create a new topology of the indexes residing on the indexers.  The
+// load stats of the instances are carried over from currmeta.
+//
+func (b *metadataClient) buildTopology(currmeta *indexTopology,
+	adminports map[string]common.IndexerId, queryports map[common.IndexerId]string,
+	mindexes []*mclient.IndexMetadata, version uint64) *indexTopology {
+
 	// create a new topology.
 	newmeta := &indexTopology{
 		version:     version,
@@ -1509,22 +1766,16 @@ func (b *metadataClient) updateTopology(
 		topology:    make(map[common.IndexerId][]*mclient.IndexMetadata),
 		replicas:    make(map[common.IndexDefnId][]common.IndexInstId),
 		equivalents: make(map[common.IndexDefnId][]common.IndexDefnId),
-		queryports:  make(map[common.IndexerId]string),
+		queryports:  queryports,
 		insts:       make(map[common.IndexInstId]*mclient.InstanceDefn),
 		rebalInsts:  make(map[common.IndexInstId]*mclient.InstanceDefn),
 		defns:       make(map[common.IndexDefnId]*mclient.IndexMetadata),
 	}
 
-	// adminport/queryport
+	// adminport
 	for adminport, indexerID := range adminports {
 		newmeta.adminports[adminport] = indexerID
 		newmeta.topology[indexerID] = make([]*mclient.IndexMetadata, 0, 16)
-
-		_, qp, _, err := b.mdClient.FindServiceForIndexer(indexerID)
-		if err == nil {
-			// This excludes watcher that is not currently connected
-			newmeta.queryports[indexerID] = qp
-		}
 	}
 
 	// insts/defns
@@ -1661,6 +1912,217 @@ func (b *metadataClient) safeupdate(
 	logging.Infof(fmsg, currmeta.version, newmeta.version, force)
 }
 
+//
+// Apply the change of index definitions pushed by the metadata feed.  Only
+// the topology of the changed indexes is recomputed.  If the current topology
+// is not of the version that the change is based on, fall back to full refresh.
+//
+func (b *metadataClient) applyDelta(delta *mclient.MetadataDelta) {
+
+	currmeta := (*indexTopology)(atomic.LoadPointer(&b.indexers))
+	if currmeta == nil || currmeta.version != delta.FromVersion {
+		b.safeupdate(nil, false)
+		return
+	}
+
+	mindexes, version := b.mdClient.FindIndexes(delta.DefnIds)
+	if version != delta.ToVersion {
+		// metadata has changed since the delta.
+		b.safeupdate(nil, false)
+		return
+	}
+
+	newmeta := b.patchTopology(currmeta, delta.DefnIds, mindexes, version)
+	if newmeta == nil {
+		b.safeupdate(nil, false)
+		return
+	}
+
+	oldptr := unsafe.Pointer(currmeta)
+	newptr := unsafe.Pointer(newmeta)
+	if !atomic.CompareAndSwapPointer(&b.indexers, oldptr, newptr) {
+		b.safeupdate(nil, false)
+		return
+	}
+
+	// metaCh should never close
+	if b.metaCh != nil {
+		select {
+		// update scan clients
+		case b.metaCh <- true:
+		default:
+		}
+	}
+
+	logging.Debugf("applied metadata delta for %v: switched currmeta from %v -> %v", delta.DefnIds, currmeta.version, newmeta.version)
+}
+
+//
+// Create a new topology from currmeta, by replacing the given index definitions
+// with mindexes.  An index definition missing from mindexes is removed.  It returns
+// nil if the change cannot be applied incrementally.
+//
+func (b *metadataClient) patchTopology(currmeta *indexTopology, defnIds []common.IndexDefnId,
+	mindexes map[common.IndexDefnId]*mclient.IndexMetadata, version uint64) *indexTopology {
+
+	changed := make(map[common.IndexDefnId]bool)
+	for _, defnId := range defnIds {
+		changed[defnId] = true
+	}
+
+	// adminports/queryports are not changed.  They are immutable after creation.
+	newmeta := &indexTopology{
+		version:     version,
+		adminports:  currmeta.adminports,
+		queryports:  currmeta.queryports,
+		topology:    make(map[common.IndexerId][]*mclient.IndexMetadata),
+		replicas:    make(map[common.IndexDefnId][]common.IndexInstId),
+		equivalents: make(map[common.IndexDefnId][]common.IndexDefnId),
+		partitions:  make(map[common.IndexDefnId]map[common.PartitionId][]common.IndexInstId),
+		insts:       make(map[common.IndexInstId]*mclient.InstanceDefn),
+		rebalInsts:  make(map[common.IndexInstId]*mclient.InstanceDefn),
+		defns:       make(map[common.IndexDefnId]*mclient.IndexMetadata),
+		loads:       make(map[common.IndexInstId]*loadHeuristics),
+	}
+
+	// carry over the indexes that have not changed
+	for defnId, mindex := range currmeta.defns {
+		if !changed[defnId] {
+			newmeta.defns[defnId] = mindex
+		}
+	}
+	for _, mindex := range currmeta.allIndexes {
+		if !changed[mindex.Definition.DefnId] {
+			newmeta.allIndexes = append(newmeta.allIndexes, mindex)
+		}
+	}
+	for instId, inst := range currmeta.insts {
+		if !changed[inst.DefnId] {
+			newmeta.insts[instId] = inst
+		}
+	}
+	for instId, inst := range currmeta.rebalInsts {
+		if !changed[inst.DefnId] {
+			newmeta.rebalInsts[instId] = inst
+		}
+	}
+	for defnId, replicas := range currmeta.replicas {
+		if !changed[defnId] {
+			newmeta.replicas[defnId] = replicas
+		}
+	}
+	for defnId, partitions := range currmeta.partitions {
+		if !changed[defnId] {
+			newmeta.partitions[defnId] = partitions
+		}
+	}
+	for indexerId, indexes := range currmeta.topology {
+		newIndexes := make([]*mclient.IndexMetadata, 0, len(indexes)+1)
+		for _, index := range indexes {
+			if !changed[index.Definition.DefnId] {
+				newIndexes = append(newIndexes, index)
+			}
+		}
+		newmeta.topology[indexerId] = newIndexes
+	}
+	for defnId, equivalents := range currmeta.equivalents {
+		if changed[defnId] {
+			continue
+		}
+		newEquivalents := make([]common.IndexDefnId, 0, len(equivalents))
+		for _, equivalent := range equivalents {
+			if !changed[equivalent] {
+				newEquivalents = append(newEquivalents, equivalent)
+			}
+		}
+		newmeta.equivalents[defnId] = newEquivalents
+	}
+
+	// add the changed indexes
+	changedTopo := make(map[common.IndexerId][]*mclient.IndexMetadata)
+	for _, mindex := range mindexes {
+		newmeta.defns[mindex.Definition.DefnId] = mindex
+		newmeta.allIndexes = append(newmeta.allIndexes, mindex)
+
+		indexers := make(map[common.IndexerId]bool)
+		for _, instance := range mindex.Instances {
+			for _, indexerId := range instance.IndexerId {
+				indexers[indexerId] = true
+			}
+			newmeta.insts[instance.InstId] = instance
+		}
+		for _, instance := range mindex.InstsInRebalance {
+			for _, indexerId := range instance.IndexerId {
+				indexers[indexerId] = true
+			}
+			newmeta.rebalInsts[instance.InstId] = instance
+		}
+
+		for indexerId, _ := range indexers {
+			if _, ok := newmeta.topology[indexerId]; !ok {
+				// indexer node is not known.  Leave it to full refresh.
+				return nil
+			}
+			newmeta.topology[indexerId] = append(newmeta.topology[indexerId], mindex)
+			changedTopo[indexerId] = append(changedTopo[indexerId], mindex)
+		}
+	}
+
+	// replicas/partitions of the changed indexes
+	replicas, partitions := b.computeReplicas(changedTopo)
+	for defnId, replica := range replicas {
+		newmeta.replicas[defnId] = replica
+	}
+	for defnId, partition := range partitions {
+		newmeta.partitions[defnId] = partition
+	}
+
+	// equivalent index of the changed indexes
+	for _, indexes := range changedTopo {
+		for _, index := range indexes {
+			if _, ok := newmeta.equivalents[index.Definition.DefnId]; !ok {
+				newmeta.equivalents[index.Definition.DefnId] = []common.IndexDefnId{index.Definition.DefnId}
+			}
+		}
+	}
+	for defnId, _ := range changed {
+		if _, ok := newmeta.equivalents[defnId]; !ok {
+			continue
+		}
+		for otherId, others := range newmeta.equivalents {
+			if otherId == defnId || !b.equivalentIndex(newmeta.defns[defnId], newmeta.defns[otherId]) {
+				continue
+			}
+			newmeta.equivalents[defnId] = append(newmeta.equivalents[defnId], otherId)
+			if !changed[otherId] {
+				// do not modify the slice shared with currmeta
+				newmeta.equivalents[otherId] = append(others[:len(others):len(others)], defnId)
+			}
+		}
+	}
+
+	// loads - carry over the stats of the instances, as in updateTopology()
+	for instId, load := range currmeta.loads {
+		if inst, ok := currmeta.insts[instId]; !ok || !changed[inst.DefnId] {
+			newmeta.loads[instId] = load
+		}
+	}
+	for instId, newInst := range newmeta.insts {
+		if !changed[newInst.DefnId] {
+			continue
+		}
+		if curInst, ok := currmeta.insts[instId]; ok {
+			if load, ok := currmeta.loads[instId]; ok {
+				newmeta.loads[instId] = load.cloneRefresh(curInst, newInst)
+				continue
+			}
+		}
+		newmeta.loads[instId] = newLoadHeuristics(int(newInst.NumPartitions))
+	}
+
+	return newmeta
+}
+
 func (b *metadataClient) hasIndexersChanged(
 	adminports map[string]common.IndexerId) bool {
 
@@ -1877,6 +2339,10 @@ func (b *metadataClient) watchClusterChanges() {
 			if ok {
 				b.updateStats(stats)
 			}
+		case delta, ok := <-b.deltaCh:
+			if ok {
+				b.applyDelta(delta)
+			}
 		case <-ticker.C:
 			// The following code is obsolete with MB-25865.
 
@@ -0,0 +1,219 @@
+package client
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	mclient "github.com/couchbase/indexing/secondary/manager/client"
+)
+
+//
+// Return an active, non-partitioned index with an instance residing on
+// each of the given indexers.
+//
+func newTestIndexMeta(defnId common.IndexDefnId, secExpr string, instIds []common.IndexInstId,
+	indexerIds []common.IndexerId) *mclient.IndexMetadata {
+
+	mindex := &mclient.IndexMetadata{
+		Definition: &common.IndexDefn{
+			DefnId:          defnId,
+			Bucket:          "b1",
+			Name:            fmt.Sprintf("idx%v", defnId),
+			SecExprs:        []string{secExpr},
+			PartitionScheme: common.SINGLE,
+		},
+		State: common.INDEX_STATE_ACTIVE,
+	}
+
+	for i, instId := range instIds {
+		mindex.Instances = append(mindex.Instances, &mclient.InstanceDefn{
+			DefnId:        defnId,
+			InstId:        instId,
+			State:         common.INDEX_STATE_ACTIVE,
+			IndexerId:     map[common.PartitionId]common.IndexerId{0: indexerIds[i]},
+			Versions:      map[common.PartitionId]uint64{0: 0},
+			RState:        uint32(common.REBAL_ACTIVE),
+			ReplicaId:     uint64(i),
+			NumPartitions: 1,
+		})
+	}
+
+	return mindex
+}
+
+//
+// Flatten the topology, with ids sorted, so that topologies built in
+// different ways can be compared.
+//
+func flattenTestTopology(meta *indexTopology) map[string]interface{} {
+
+	sortDefnIds := func(ids []common.IndexDefnId) []common.IndexDefnId {
+		ids = append([]common.IndexDefnId(nil), ids...)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+	sortInstIds := func(ids []common.IndexInstId) []common.IndexInstId {
+		ids = append([]common.IndexInstId(nil), ids...)
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+		return ids
+	}
+
+	topology := make(map[common.IndexerId][]common.IndexDefnId)
+	for indexerId, indexes := range meta.topology {
+		var ids []common.IndexDefnId
+		for _, index := range indexes {
+			ids = append(ids, index.Definition.DefnId)
+		}
+		topology[indexerId] = sortDefnIds(ids)
+	}
+
+	replicas := make(map[common.IndexDefnId][]common.IndexInstId)
+	for defnId, instIds := range meta.replicas {
+		replicas[defnId] = sortInstIds(instIds)
+	}
+
+	partitions := make(map[common.IndexDefnId]map[common.PartitionId][]common.IndexInstId)
+	for defnId, partns := range meta.partitions {
+		partitions[defnId] = make(map[common.PartitionId][]common.IndexInstId)
+		for partnId, instIds := range partns {
+			partitions[defnId][partnId] = sortInstIds(instIds)
+		}
+	}
+
+	equivalents := make(map[common.IndexDefnId][]common.IndexDefnId)
+	for defnId, defnIds := range meta.equivalents {
+		equivalents[defnId] = sortDefnIds(defnIds)
+	}
+
+	var allIndexes []common.IndexDefnId
+	for _, index := range meta.allIndexes {
+		allIndexes = append(allIndexes, index.Definition.DefnId)
+	}
+
+	var defns []common.IndexDefnId
+	for defnId, _ := range meta.defns {
+		defns = append(defns, defnId)
+	}
+
+	var insts, rebalInsts []common.IndexInstId
+	for instId, _ := range meta.insts {
+		insts = append(insts, instId)
+	}
+	for instId, _ := range meta.rebalInsts {
+		rebalInsts = append(rebalInsts, instId)
+	}
+
+	loads := make(map[common.IndexInstId]float64)
+	for instId, load := range meta.loads {
+		loads[instId], _ = load.getLoad(0)
+	}
+
+	return map[string]interface{}{
+		"version":     meta.version,
+		"topology":    topology,
+		"replicas":    replicas,
+		"partitions":  partitions,
+		"equivalents": equivalents,
+		"allIndexes":  sortDefnIds(allIndexes),
+		"defns":       sortDefnIds(defns),
+		"insts":       sortInstIds(insts),
+		"rebalInsts":  sortInstIds(rebalInsts),
+		"loads":       loads,
+	}
+}
+
+func TestPatchTopology(t *testing.T) {
+
+	b := &metadataClient{}
+
+	adminports := map[string]common.IndexerId{"n1:9100": "idxr1", "n2:9100": "idxr2"}
+	queryports := map[common.IndexerId]string{"idxr1": "n1:9101", "idxr2": "n2:9101"}
+	both := []common.IndexerId{"idxr1", "idxr2"}
+
+	idx1 := newTestIndexMeta(1, "`a`", []common.IndexInstId{11, 12}, both)
+	idx2 := newTestIndexMeta(2, "`a`", []common.IndexInstId{21}, both[1:])
+	idx3 := newTestIndexMeta(3, "`b`", []common.IndexInstId{31}, both[:1])
+
+	testcases := []struct {
+		comment  string
+		changed  []common.IndexDefnId
+		mindexes []*mclient.IndexMetadata
+		fallback bool
+	}{
+		{"no change", nil,
+			[]*mclient.IndexMetadata{idx1, idx2, idx3}, false},
+		{"created equivalent", []common.IndexDefnId{4},
+			[]*mclient.IndexMetadata{idx1, idx2, idx3,
+				newTestIndexMeta(4, "`a`", []common.IndexInstId{41}, both[:1])}, false},
+		{"created", []common.IndexDefnId{5},
+			[]*mclient.IndexMetadata{idx1, idx2, idx3,
+				newTestIndexMeta(5, "`c`", []common.IndexInstId{51}, both[1:])}, false},
+		{"dropped", []common.IndexDefnId{2},
+			[]*mclient.IndexMetadata{idx1, idx3}, false},
+		{"replica added", []common.IndexDefnId{3},
+			[]*mclient.IndexMetadata{idx1, idx2,
+				newTestIndexMeta(3, "`b`", []common.IndexInstId{31, 32}, both)}, false},
+		{"replica dropped", []common.IndexDefnId{1},
+			[]*mclient.IndexMetadata{newTestIndexMeta(1, "`a`", []common.IndexInstId{11}, both[:1]),
+				idx2, idx3}, false},
+		{"altered and dropped", []common.IndexDefnId{1, 2},
+			[]*mclient.IndexMetadata{newTestIndexMeta(1, "`b`", []common.IndexInstId{11, 12}, both),
+				idx3}, false},
+		{"unknown indexer", []common.IndexDefnId{6},
+			[]*mclient.IndexMetadata{idx1, idx2, idx3,
+				newTestIndexMeta(6, "`a`", []common.IndexInstId{61}, []common.IndexerId{"idxr3"})}, true},
+	}
+
+	for _, tc := range testcases {
+		currmeta := b.buildTopology(nil, adminports, queryports, []*mclient.IndexMetadata{idx1, idx2, idx3}, 1)
+		currmeta.loads[11].updateLoad(0, 10)
+		currmeta.loads[31].updateLoad(0, 30)
+		before := flattenTestTopology(currmeta)
+
+		changed := make(map[common.IndexDefnId]bool)
+		for _, defnId := range tc.changed {
+			changed[defnId] = true
+		}
+		mindexes := make(map[common.IndexDefnId]*mclient.IndexMetadata)
+		for _, mindex := range tc.mindexes {
+			if changed[mindex.Definition.DefnId] {
+				mindexes[mindex.Definition.DefnId] = mindex
+			}
+		}
+
+		patched := b.patchTopology(currmeta, tc.changed, mindexes, 2)
+
+		if !reflect.DeepEqual(flattenTestTopology(currmeta), before) {
+			t.Errorf("%v: current topology is modified", tc.comment)
+		}
+
+		if tc.fallback {
+			if patched != nil {
+				t.Errorf("%v: expected fallback to full refresh", tc.comment)
+			}
+			continue
+		}
+
+		if patched == nil {
+			t.Errorf("%v: unexpected fallback to full refresh", tc.comment)
+			continue
+		}
+
+		// the patched topology is the same as a full refresh of the same metadata
+		full := b.buildTopology(currmeta, adminports, queryports, tc.mindexes, 2)
+
+		expected, got := flattenTestTopology(full), flattenTestTopology(patched)
+		for key, value := range expected {
+			if !reflect.DeepEqual(got[key], value) {
+				t.Errorf("%v: %v expected %v, got %v", tc.comment, key, value, got[key])
+			}
+		}
+
+		if !reflect.DeepEqual(patched.adminports, adminports) || !reflect.DeepEqual(patched.queryports, queryports) {
+			t.Errorf("%v: expected indexers to be unchanged, got %v %v", tc.comment, patched.adminports, patched.queryports)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package client
+
+import "errors"
+import "fmt"
+import "net"
+import "sync"
+import "sync/atomic"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/logging"
+import "github.com/couchbase/indexing/secondary/transport"
+import protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+import "github.com/golang/protobuf/proto"
+
+// errorMuxUnsupported is returned when indexer does not support
+// multiplexed connections, and connection pool shall be used instead.
+var errorMuxUnsupported = errors.New("queryport.muxUnsupported")
+
+// muxPool hands out streams of multiplexed connections to an indexer.
+// Every request gets a stream of its own, that is closed when the request
+// is done, hence a stream behaves like a dedicated connection from the
+// connection pool. Number of concurrent streams is limited by
+// `connections` * `maxStreams`.
+type muxPool struct {
+	host          string
+	maxPayload    int
+	timeout       time.Duration
+	writeDeadline time.Duration
+	numConns      int
+	logPrefix     string
+
+	mutex       sync.Mutex
+	sessions    []*transport.MuxSession
+	unsupported int32 // indexer does not support multiplexing
+	closed      bool
+
+	streamsem chan bool
+	pkts      chan *transport.TransportPacket // free transport packets
+	// advertise to server that compressed responses can be received.
+	acceptCompression bool
+	compStats         *transport.CompressionStats
+}
+
+func newMuxPool(
+	host string, maxPayload int, timeout, writeDeadline time.Duration,
+	numConns, maxStreams int, acceptCompression bool,
+	compStats *transport.CompressionStats) *muxPool {
+
+	if numConns <= 0 {
+		numConns = 1
+	}
+	if maxStreams <= 0 {
+		maxStreams = 1
+	}
+
+	mp := &muxPool{
+		host:              host,
+		maxPayload:        maxPayload,
+		timeout:           timeout,
+		writeDeadline:     writeDeadline,
+		numConns:          numConns,
+		logPrefix:         fmt.Sprintf("[Queryport-muxpool:%v]", host),
+		streamsem:         make(chan bool, numConns*maxStreams),
+		pkts:              make(chan *transport.TransportPacket, numConns),
+		acceptCompression: acceptCompression,
+		compStats:         compStats,
+	}
+	logging.Infof("%v started connections %v streams per connection %v ...\n",
+		mp.logPrefix, numConns, maxStreams)
+	return mp
+}
+
+func (mp *muxPool) enabled() bool {
+	return atomic.LoadInt32(&mp.unsupported) == 0
+}
+
+// get opens a new stream, waits for at most `d` if all streams are in use.
+func (mp *muxPool) get(d time.Duration) (*connection, error) {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case mp.streamsem <- true:
+	case <-t.C:
+		return nil, ErrorPoolTimeout
+	}
+
+	session, err := mp.getSession()
+	if err != nil {
+		<-mp.streamsem
+		return nil, err
+	}
+
+	stream, err := session.Open()
+	if err != nil {
+		<-mp.streamsem
+		return nil, err
+	}
+
+	return &connection{conn: stream, pkt: mp.getPkt(), muxed: true}, nil
+}
+
+// put closes the stream. Remote end of the stream is notified, hence it is
+// not required to drain the stream before closing.
+func (mp *muxPool) put(connectn *connection) {
+	connectn.conn.Close()
+
+	select {
+	case mp.pkts <- connectn.pkt:
+	default:
+	}
+	<-mp.streamsem
+}
+
+// renew opens a new stream in place of `connectn`, on failure `connectn`
+// is retained.
+func (mp *muxPool) renew(connectn *connection) (*connection, error) {
+	newConn, err := mp.get(mp.timeout * time.Millisecond)
+	if err != nil {
+		return connectn, err
+	}
+	mp.put(connectn)
+	return newConn, nil
+}
+
+// getSession return the least loaded multiplexed connection, a new
+// connection is established if there are fewer than `numConns`.
+func (mp *muxPool) getSession() (*transport.MuxSession, error) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	if mp.closed {
+		return nil, ErrorClosedPool
+	}
+
+	sessions := mp.sessions[:0]
+	for _, session := range mp.sessions {
+		if !session.IsClosed() {
+			sessions = append(sessions, session)
+		}
+	}
+	mp.sessions = sessions
+
+	if len(mp.sessions) < mp.numConns {
+		session, err := mp.dial()
+		if err == nil {
+			mp.sessions = append(mp.sessions, session)
+			return session, nil
+		} else if err == errorMuxUnsupported || len(mp.sessions) == 0 {
+			return nil, err
+		}
+		logging.Warnf("%v unable to open multiplexed connection: %v\n", mp.logPrefix, err)
+	}
+
+	var least *transport.MuxSession
+	for _, session := range mp.sessions {
+		if least == nil || session.NumStreams() < least.NumStreams() {
+			least = session
+		}
+	}
+	return least, nil
+}
+
+// dial a connection and request the indexer to multiplex scans on it.
+func (mp *muxPool) dial() (*transport.MuxSession, error) {
+	logging.Infof("%v open new multiplexed connection ...\n", mp.logPrefix)
+	conn, err := net.Dial("tcp", mp.host)
+	if err != nil {
+		return nil, err
+	}
+
+	pkt := mp.getPkt()
+	defer func() {
+		select {
+		case mp.pkts <- pkt:
+		default:
+		}
+	}()
+
+	req := &protobuf.HeloRequest{
+		Version:   proto.Uint32(uint32(protobuf.ProtobufVersion())),
+		Multiplex: proto.Bool(true),
+	}
+
+	conn.SetDeadline(time.Now().Add(mp.timeout * time.Millisecond))
+	resp, err := mp.heloMultiplex(conn, pkt, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if heloResp, ok := resp.(*protobuf.HeloResponse); !ok || !heloResp.GetMultiplex() {
+		// Indexer does not support multiplexing, connection pool shall be
+		// used for all subsequent requests.
+		conn.Close()
+		atomic.StoreInt32(&mp.unsupported, 1)
+		logging.Infof("%v indexer does not support multiplexed connections\n", mp.logPrefix)
+		return nil, errorMuxUnsupported
+	}
+
+	conn.SetDeadline(time.Time{})
+	if tcpconn, ok := conn.(*net.TCPConn); ok {
+		tcpconn.SetKeepAlive(true)
+	}
+	// number of streams is limited by the pool, across connections.
+	writeTimeout := mp.writeDeadline * time.Millisecond
+	return transport.NewMuxSession(conn, true /*client*/, 0, writeTimeout), nil
+}
+
+func (mp *muxPool) heloMultiplex(
+	conn net.Conn, pkt *transport.TransportPacket,
+	req *protobuf.HeloRequest) (interface{}, error) {
+
+	if err := pkt.Send(conn, req); err != nil {
+		return nil, err
+	}
+	resp, err := pkt.Receive(conn)
+	if err != nil {
+		return nil, err
+	}
+	// <--- end of response
+	if endResp, err := pkt.Receive(conn); err != nil {
+		return nil, err
+	} else if endResp != nil {
+		return nil, ErrorProtocol
+	}
+	return resp, nil
+}
+
+func (mp *muxPool) getPkt() *transport.TransportPacket {
+	select {
+	case pkt := <-mp.pkts:
+		return pkt
+	default:
+	}
+
+	flags := transport.TransportFlag(0).SetProtobuf()
+	if mp.acceptCompression {
+		flags = flags.SetAcceptCompression()
+	}
+	pkt := transport.NewTransportPacket(mp.maxPayload, flags)
+	pkt.SetEncoder(transport.EncodingProtobuf, protobuf.ProtobufEncode)
+	pkt.SetDecoder(transport.EncodingProtobuf, protobuf.ProtobufDecode)
+	pkt.SetCompressionStats(mp.compStats)
+	return pkt
+}
+
+// numStreams return number of multiplexed connections and active streams.
+func (mp *muxPool) numStreams() (int, int) {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	streams := 0
+	for _, session := range mp.sessions {
+		streams += session.NumStreams()
+	}
+	return len(mp.sessions), streams
+}
+
+func (mp *muxPool) close() {
+	mp.mutex.Lock()
+	defer mp.mutex.Unlock()
+
+	mp.closed = true
+	for _, session := range mp.sessions {
+		session.Close()
+	}
+	mp.sessions = nil
+}
@@ -10,6 +10,7 @@
 
 package client
 
+import "bytes"
 import "errors"
 import "fmt"
 import "io"
@@ -41,6 +42,7 @@ type GsiScanClient struct {
 	relConnBatchSize   int32
 
 	serverVersion uint32
+	splitPoints   uint32 // server serves SplitPointsRequest
 }
 
 func NewGsiScanClient(queryport string, config common.Config) (*GsiScanClient, error) {
@@ -61,6 +63,13 @@ func NewGsiScanClient(queryport string, config common.Config) (*GsiScanClient, e
 	c.pool = newConnectionPool(
 		queryport, c.poolSize, c.poolOverflow, c.maxPayload, c.cpTimeout,
 		c.cpAvailWaitTimeout, c.minPoolSizeWM, c.relConnBatchSize)
+	c.pool.acceptCompression = config["enableCompression"].Bool()
+	if config["multiplex.enable"].Bool() {
+		c.pool.mux = newMuxPool(
+			queryport, c.maxPayload, c.cpTimeout, c.writeDeadline,
+			config["multiplex.connections"].Int(), config["multiplex.maxStreams"].Int(),
+			c.pool.acceptCompression, c.pool.compStats)
+	}
 	logging.Infof("%v started ...\n", c.logPrefix)
 
 	if version, err := c.Helo(); err == nil || err == io.EOF {
@@ -83,10 +92,22 @@ func (c *GsiScanClient) RefreshServerVersion() {
 	}
 }
 
+// CompressionStats return decompression statistics for scan responses
+// received from this queryport.
+func (c *GsiScanClient) CompressionStats() map[string]interface{} {
+	return c.pool.compStats.Map()
+}
+
 func (c *GsiScanClient) NeedSessionConsVector() bool {
 	return atomic.LoadUint32(&c.serverVersion) == 0
 }
 
+// SupportsSplitPoints return whether the server can compute split points
+// of a range.
+func (c *GsiScanClient) SupportsSplitPoints() bool {
+	return atomic.LoadUint32(&c.splitPoints) == 1
+}
+
 func (c *GsiScanClient) Helo() (uint32, error) {
 	req := &protobuf.HeloRequest{
 		Version: proto.Uint32(uint32(protobuf.ProtobufVersion())),
@@ -97,6 +118,11 @@ func (c *GsiScanClient) Helo() (uint32, error) {
 		return 0, err
 	}
 	heloResp := resp.(*protobuf.HeloResponse)
+	if heloResp.GetSplitPoints() {
+		atomic.StoreUint32(&c.splitPoints, 1)
+	} else {
+		atomic.StoreUint32(&c.splitPoints, 0)
+	}
 	return heloResp.GetVersion(), nil
 }
 
@@ -169,7 +195,8 @@ func (c *GsiScanClient) Lookup(
 	rollbackTime int64,
 	partitions []common.PartitionId,
 	dataEncFmt common.DataEncodingFormat,
-	retry bool) (error, bool) {
+	retry bool,
+	traceContext string) (error, bool) {
 
 	// serialize lookup value.
 	equals := make([][]byte, 0, len(values))
@@ -202,6 +229,9 @@ func (c *GsiScanClient) Lookup(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if traceContext != "" {
+		req.TraceContext = proto.String(traceContext)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "Lookup", retry)
 }
@@ -278,7 +308,22 @@ func (c *GsiScanClient) Range(
 	defnID uint64, requestId string, low, high common.SecondaryKey, inclusion Inclusion,
 	distinct bool, limit int64, cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, retry bool,
+	traceContext string) (error, bool) {
+
+	return c.RangeSnapshot(
+		defnID, requestId, low, high, inclusion, distinct, limit, cons, vector,
+		callb, rollbackTime, partitions, dataEncFmt, retry, traceContext, 0)
+}
+
+// RangeSnapshot scan index between low and high, from the snapshot pinned
+// by SplitPoints, or as per consistency if snapshotId is 0.
+func (c *GsiScanClient) RangeSnapshot(
+	defnID uint64, requestId string, low, high common.SecondaryKey, inclusion Inclusion,
+	distinct bool, limit int64, cons common.Consistency, vector *TsConsistency,
+	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
+	dataEncFmt common.DataEncodingFormat, retry bool,
+	traceContext string, snapshotId uint64) (error, bool) {
 
 	// serialize low and high values.
 	l, err := json.Marshal(low)
@@ -315,6 +360,12 @@ func (c *GsiScanClient) Range(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if traceContext != "" {
+		req.TraceContext = proto.String(traceContext)
+	}
+	if snapshotId != 0 {
+		req.SnapshotId = proto.Uint64(snapshotId)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "Range", retry)
 }
@@ -324,7 +375,8 @@ func (c *GsiScanClient) RangePrimary(
 	defnID uint64, requestId string, low, high []byte, inclusion Inclusion,
 	distinct bool, limit int64, cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, retry bool,
+	traceContext string) (error, bool) {
 
 	partnIds := make([]uint64, len(partitions))
 	for i, partnId := range partitions {
@@ -352,6 +404,9 @@ func (c *GsiScanClient) RangePrimary(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if traceContext != "" {
+		req.TraceContext = proto.String(traceContext)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "RangePrimary", retry)
 }
@@ -390,7 +445,8 @@ func (c *GsiScanClient) MultiScan(
 	reverse, distinct bool, projection *IndexProjection, offset, limit int64,
 	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, retry bool,
+	traceContext string) (error, bool) {
 
 	// serialize scans
 	protoScans := make([]*protobuf.Scan, len(scans))
@@ -481,6 +537,9 @@ func (c *GsiScanClient) MultiScan(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if traceContext != "" {
+		req.TraceContext = proto.String(traceContext)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "MultiScan", retry)
 }
@@ -490,7 +549,8 @@ func (c *GsiScanClient) MultiScanPrimary(
 	reverse, distinct bool, projection *IndexProjection, offset, limit int64,
 	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, retry bool,
+	traceContext string) (error, bool) {
 
 	var what string
 	// serialize scans
@@ -586,6 +646,9 @@ func (c *GsiScanClient) MultiScanPrimary(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if traceContext != "" {
+		req.TraceContext = proto.String(traceContext)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "MultiScanPrimary", retry)
 }
@@ -717,6 +780,77 @@ func (c *GsiScanClient) CountRange(
 	return countResp.GetCount(), nil
 }
 
+// SplitPoints return keys that split the range between low and high into
+// about numSplits sub-ranges of the same number of entries, along with
+// the id of the snapshot the keys were sampled from. Indexer pins the
+// snapshot, so that every sub-range is scanned from it with RangeSnapshot.
+// Split keys are approximate and in ascending order, fewer keys are
+// returned if the range is small or the storage cannot estimate split
+// points.
+func (c *GsiScanClient) SplitPoints(
+	defnID uint64, requestId string, low, high common.SecondaryKey, inclusion Inclusion,
+	numSplits int, cons common.Consistency, vector *TsConsistency, rollbackTime int64,
+	partitions []common.PartitionId, retry bool) ([]common.SecondaryKey, uint64, error) {
+
+	// serialize low and high values.
+	l, err := json.Marshal(low)
+	if err != nil {
+		return nil, 0, err
+	}
+	h, err := json.Marshal(high)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	partnIds := make([]uint64, len(partitions))
+	for i, partnId := range partitions {
+		partnIds[i] = uint64(partnId)
+	}
+
+	req := &protobuf.SplitPointsRequest{
+		DefnID:    proto.Uint64(defnID),
+		RequestId: proto.String(requestId),
+		Span: &protobuf.Span{
+			Range: &protobuf.Range{
+				Low: l, High: h, Inclusion: proto.Uint32(uint32(inclusion)),
+			},
+		},
+		NumSplits:    proto.Uint32(uint32(numSplits)),
+		Cons:         proto.Uint32(uint32(cons)),
+		RollbackTime: proto.Int64(rollbackTime),
+		PartitionIds: partnIds,
+	}
+	if vector != nil {
+		req.Vector = protobuf.NewTsConsistency(
+			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
+	}
+
+	resp, err := c.doRequestResponse(req, requestId, retry)
+	if err != nil {
+		return nil, 0, err
+	}
+	splitResp := resp.(*protobuf.SplitPointsResponse)
+	if splitResp.GetErr() != nil {
+		err = errors.New(splitResp.GetErr().GetError())
+		return nil, 0, err
+	}
+
+	// numbers are kept as is, split keys must be re-encoded exactly as
+	// received to remain within the range.
+	keys := make([]common.SecondaryKey, 0, len(splitResp.GetSplitKeys()))
+	for _, data := range splitResp.GetSplitKeys() {
+		key := make(common.SecondaryKey, 0)
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err := dec.Decode(&key); err != nil {
+			return nil, 0, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, splitResp.GetSnapshotId(), nil
+}
+
 // CountRange to count number entries in the given range for primary index
 func (c *GsiScanClient) CountRangePrimary(
 	defnID uint64, requestId string, low, high []byte, inclusion Inclusion,
@@ -951,7 +1085,8 @@ func (c *GsiScanClient) Scan3(
 	groupAggr *GroupAggr, sorted bool,
 	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, retry bool,
+	traceContext string) (error, bool) {
 
 	// serialize scans
 	protoScans := make([]*protobuf.Scan, len(scans))
@@ -1082,6 +1217,9 @@ func (c *GsiScanClient) Scan3(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if traceContext != "" {
+		req.TraceContext = proto.String(traceContext)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "Scan3", retry)
 }
@@ -1092,7 +1230,8 @@ func (c *GsiScanClient) Scan3Primary(
 	groupAggr *GroupAggr, sorted bool,
 	cons common.Consistency, vector *TsConsistency,
 	callb ResponseHandler, rollbackTime int64, partitions []common.PartitionId,
-	dataEncFmt common.DataEncodingFormat, retry bool) (error, bool) {
+	dataEncFmt common.DataEncodingFormat, retry bool,
+	traceContext string) (error, bool) {
 
 	var what string
 	// serialize scans
@@ -1227,6 +1366,9 @@ func (c *GsiScanClient) Scan3Primary(
 		req.Vector = protobuf.NewTsConsistency(
 			vector.Vbnos, vector.Seqnos, vector.Vbuuids, vector.Crc64)
 	}
+	if traceContext != "" {
+		req.TraceContext = proto.String(traceContext)
+	}
 
 	return c.doStreamingWithRetry(requestId, req, callb, "Scan3Primary", retry)
 }
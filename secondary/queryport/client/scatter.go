@@ -10,6 +10,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"math"
@@ -18,6 +19,7 @@ import (
 
 	"github.com/couchbase/indexing/secondary/common"
 	"github.com/couchbase/indexing/secondary/logging"
+	"github.com/couchbase/indexing/secondary/tracing"
 	"github.com/couchbase/query/value"
 
 	//"runtime"
@@ -89,6 +91,21 @@ type RequestBroker struct {
 	size        int64
 	concurrency int
 	retry       bool
+	ctx         context.Context
+
+	// hedging
+	hedger      *scanHedger
+	hedgeTarget HedgeTargetFinder
+	defnFinder  func(defnID uint64) *common.IndexDefn
+	maker       scanClientMaker
+	cons        common.Consistency
+
+	// circuit breaker
+	breakers *circuitBreakers
+
+	// tracing
+	span        *tracing.Span // span of the scan request
+	scatterSpan *tracing.Span // span of the current scatter
 
 	// scatter/gather
 	queues   []*Queue
@@ -117,6 +134,7 @@ type RequestBroker struct {
 	indexOrder     *IndexKeyOrder
 	projDesc       []bool
 	distinct       bool
+	partitions     map[common.PartitionId]bool // requested partitions, nil for all
 
 	// stats
 	sendCount    int64
@@ -200,6 +218,79 @@ func (b *RequestBroker) SetResponseTimer(timer ResponseTimer) {
 	b.timer = timer
 }
 
+//
+// Set Context.  If the context is cancelled or its deadline is exceeded,
+// the broker is closed and every scan stream is ended.
+//
+func (b *RequestBroker) SetContext(ctx context.Context) {
+
+	b.ctx = ctx
+}
+
+//
+// Set hedging of partition scans.  The hedge target finder returns an
+// alternate replica for a slow partition scan.
+//
+func (b *RequestBroker) SetHedge(hedger *scanHedger, finder HedgeTargetFinder,
+	defnFinder func(defnID uint64) *common.IndexDefn) {
+
+	b.hedger = hedger
+	b.hedgeTarget = finder
+	b.defnFinder = defnFinder
+}
+
+//
+// Restrict the scan to the given partitions of the index.  All
+// partitions are scanned if the list is empty.
+//
+func (b *RequestBroker) SetPartitions(partitions []common.PartitionId) {
+
+	b.partitions = nil
+	if len(partitions) != 0 {
+		b.partitions = make(map[common.PartitionId]bool)
+		for _, partnId := range partitions {
+			b.partitions[partnId] = true
+		}
+	}
+}
+
+//
+// Set circuit breakers.  Scan results are reported to the circuit breaker
+// of the scanport.
+//
+func (b *RequestBroker) SetCircuitBreakers(breakers *circuitBreakers) {
+
+	b.breakers = breakers
+}
+
+//
+// Set the span of the scan request.  Every scatter, and every partition scan
+// within a scatter, is traced as a child span.
+//
+func (b *RequestBroker) SetSpan(span *tracing.Span) {
+
+	b.span = span
+}
+
+//
+// Set Consistency
+//
+func (b *RequestBroker) SetConsistency(cons common.Consistency) {
+
+	b.cons = cons
+}
+
+//
+// Return the error of the context, if the context is done.
+//
+func (b *RequestBroker) ContextErr() error {
+
+	if b.ctx == nil {
+		return nil
+	}
+	return b.ctx.Err()
+}
+
 //
 // Set BackfillWaiter
 //
@@ -522,6 +613,16 @@ func (c *RequestBroker) scatter(clientMaker scanClientMaker, index *common.Index
 	c.reset()
 	c.SetNumIndexers(len(partition))
 	c.defn = index
+	c.maker = clientMaker
+
+	c.scatterSpan = c.span.StartChild("client.scatter")
+	c.scatterSpan.SetAttribute("index", fmt.Sprintf("%v:%v", index.Bucket, index.Name))
+	c.scatterSpan.SetAttribute("numPartition", numPartition)
+	defer func() {
+		c.scatterSpan.SetError(getScanError(err))
+		c.scatterSpan.SetAttribute("rows", c.SendCount())
+		c.scatterSpan.Finish()
+	}()
 
 	concurrency := int(settings.MaxConcurrency())
 	if concurrency == 0 {
@@ -724,6 +825,22 @@ func (c *RequestBroker) scatterScan2(client []*GsiScanClient, index *common.Inde
 	c.notifych = make(chan bool, 1)
 	donech_gather := make(chan bool, 1)
 
+	// Close the broker when the context is done.  Once the broker is closed,
+	// the response handlers stop reading and end the stream on every
+	// connection with EndStreamRequest.
+	if c.ctx != nil {
+		stopch := make(chan bool)
+		defer close(stopch)
+
+		go func() {
+			select {
+			case <-c.ctx.Done():
+				c.close()
+			case <-stopch:
+			}
+		}()
+	}
+
 	if len(partition) > 1 {
 		c.bGather = true
 	}
@@ -1200,8 +1317,15 @@ func (c *RequestBroker) scanSingleNode(id ResponseHandlerId, client *GsiScanClie
 		return
 	}
 
+	var err error
+	var partial bool
+
 	begin := time.Now()
-	err, partial := c.scan(client, index, rollback, partition, c.factory(id, instId, partition))
+	if c.hedger.enabled() && c.hedgeTarget != nil {
+		err, partial, instId = c.hedgedScan(id, client, index, instId, rollback, partition)
+	} else {
+		err, partial = c.scanWithBreaker(client, index, instId, rollback, partition, c.factory(id, instId, partition))
+	}
 	if err != nil {
 		// If there is any error, then stop the broker.
 		// This will force other go-routine to terminate.
@@ -1222,6 +1346,29 @@ func (c *RequestBroker) scanSingleNode(id ResponseHandlerId, client *GsiScanClie
 	donech <- &doneStatus{err: err, partial: partial}
 }
 
+//
+// This function makes a scan request through a single connection, and
+// reports the result to the circuit breaker of the scanport.  The scan
+// is traced as a child span of the scatter.
+//
+func (c *RequestBroker) scanWithBreaker(client *GsiScanClient, index *common.IndexDefn, instId uint64, rollback int64,
+	partition []common.PartitionId, handler ResponseHandler) (error, bool) {
+
+	span := c.scatterSpan.StartChild("client.partitionScan")
+	span.SetAttribute("queryport", client.queryport)
+	span.SetAttribute("instId", instId)
+	span.SetAttribute("partitions", partition)
+
+	err, partial := c.scan(client, index, rollback, partition, handler, span.Context().String())
+	c.breakers.record(client.queryport, err)
+
+	span.SetError(err)
+	span.SetAttribute("partial", partial)
+	span.Finish()
+
+	return err, partial
+}
+
 //
 // This function makes a count request through a single connection.
 //
@@ -1234,6 +1381,7 @@ func (c *RequestBroker) countSingleNode(id ResponseHandlerId, client *GsiScanCli
 	}
 
 	cnt, err, partial := c.count(client, index, rollback, partition)
+	c.breakers.record(client.queryport, err)
 	if err != nil {
 		// If there is any error, then stop the broker.
 		// This will force other go-routine to terminate.
@@ -1430,10 +1578,14 @@ func makeDefaultResponseHandler(id ResponseHandlerId, broker *RequestBroker, ins
 //--------------------------
 
 //
-// Filter partitions based on index partiton key
+// Filter partitions based on the requested partitions and index partiton key
 //
 func (c *RequestBroker) filterPartitions(index *common.IndexDefn, partitions [][]common.PartitionId, numPartition uint32) [][]common.PartitionId {
 
+	if len(c.partitions) != 0 {
+		partitions = filterPartitionIds(partitions, c.partitions)
+	}
+
 	if numPartition == 1 || (len(partitions) == 1 && len(partitions[0]) == 1) {
 		return partitions
 	}
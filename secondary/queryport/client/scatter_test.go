@@ -0,0 +1,106 @@
+package client
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/tracing"
+)
+
+func TestFilterRequestedPartitions(t *testing.T) {
+
+	index := &common.IndexDefn{
+		DefnId:          common.IndexDefnId(100),
+		PartitionScheme: common.KEY,
+		NumPartitions:   4,
+	}
+
+	// partitions 1, 2 on one host, and 3, 4 on another
+	all := [][]common.PartitionId{{1, 2}, {3, 4}}
+
+	testcases := []struct {
+		comment   string
+		requested []common.PartitionId
+		expected  [][]common.PartitionId
+	}{
+		{"all partitions", nil, [][]common.PartitionId{{1, 2}, {3, 4}}},
+		{"one host", []common.PartitionId{1, 2}, [][]common.PartitionId{{1, 2}, nil}},
+		{"across hosts", []common.PartitionId{2, 3}, [][]common.PartitionId{{2}, {3}}},
+		{"unknown partition", []common.PartitionId{5}, [][]common.PartitionId{nil, nil}},
+	}
+
+	for _, tc := range testcases {
+		b := NewRequestBroker(tc.comment, 256, -1)
+		b.SetPartitions(tc.requested)
+
+		partitions := b.filterPartitions(index, all, index.NumPartitions)
+		if !reflect.DeepEqual(partitions, tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expected, partitions)
+		}
+	}
+}
+
+type testSpanExporter struct {
+	mutex sync.Mutex
+	spans map[string]*tracing.SpanData // by name
+}
+
+func (e *testSpanExporter) Export(span *tracing.SpanData) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.spans[span.Name] = span
+	return nil
+}
+
+func (e *testSpanExporter) Close() error {
+	return nil
+}
+
+func TestScatterTraceContext(t *testing.T) {
+
+	exporter := &testSpanExporter{spans: make(map[string]*tracing.SpanData)}
+	root := tracing.NewTracer("gsiClient", 1, exporter).StartSpan("client.scan")
+
+	b := NewRequestBroker("trace", 256, -1)
+	b.SetSpan(root)
+
+	var traceContext string
+	b.SetScanRequestHandler(func(client *GsiScanClient, index *common.IndexDefn, rollback int64,
+		partition []common.PartitionId, handler ResponseHandler, tc string) (error, bool) {
+
+		traceContext = tc
+		return nil, false
+	})
+	b.SetResponseHandlerFactory(func(id ResponseHandlerId, instId uint64, partitions []common.PartitionId) ResponseHandler {
+		return func(resp ResponseReader) bool {
+			return true
+		}
+	})
+	maker := func(queryport string) *GsiScanClient {
+		return &GsiScanClient{queryport: queryport}
+	}
+
+	index := &common.IndexDefn{DefnId: common.IndexDefnId(100), PartitionScheme: common.SINGLE}
+	b.scatter(maker, index, []string{"node1"}, []uint64{1}, []int64{0},
+		[][]common.PartitionId{{0}}, 1, &ClientSettings{})
+	root.Finish()
+
+	// the scan request carries the context of the partition scan span,
+	// which is a child of the scatter span, in the trace of the request.
+	sc, err := tracing.ParseSpanContext(traceContext)
+	if err != nil {
+		t.Fatalf("expected trace context in scan request, got %q", traceContext)
+	}
+	scan, scatter := exporter.spans["client.partitionScan"], exporter.spans["client.scatter"]
+	if scan == nil || scatter == nil {
+		t.Fatalf("expected scatter and partition scan spans, got %v", exporter.spans)
+	}
+	if sc.TraceId != root.TraceId() || scan.TraceId != root.TraceId() || scatter.TraceId != root.TraceId() {
+		t.Errorf("expected trace %v, got %v %v %v", root.TraceId(), sc.TraceId, scan.TraceId, scatter.TraceId)
+	}
+	if sc.SpanId != scan.SpanId || scan.ParentId != scatter.SpanId || scatter.ParentId != root.Context().SpanId {
+		t.Errorf("unexpected span hierarchy %v, %+v, %+v", sc, scan, scatter)
+	}
+}
@@ -36,6 +36,20 @@ type ClientSettings struct {
 
 	needRefresh          bool
 	allowCJsonScanFormat uint32
+
+	hedgeEnable     uint32
+	hedgePercentile uint64
+	hedgeMinDelay   int64
+	hedgeMaxDelay   int64
+
+	breakerEnable      uint32
+	breakerThreshold   int64
+	breakerOpenTimeout int64
+
+	rangeSplits     uint32
+	rangeSplitQueue uint32
+
+	alterIndexTimeout int64
 }
 
 func NewClientSettings(needRefresh bool) *ClientSettings {
@@ -173,6 +187,70 @@ func (s *ClientSettings) handleSettings(config common.Config) {
 		atomic.StoreUint32(&s.allowCJsonScanFormat, 1)
 	}
 
+	if config["queryport.client.scan.hedge.enable"].Bool() {
+		atomic.StoreUint32(&s.hedgeEnable, 1)
+	} else {
+		atomic.StoreUint32(&s.hedgeEnable, 0)
+	}
+
+	hedgePercentile := config["queryport.client.scan.hedge.percentile"].Float64()
+	if hedgePercentile > 0 && hedgePercentile <= 100 {
+		atomic.StoreUint64(&s.hedgePercentile, math.Float64bits(hedgePercentile))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for hedge.percentile=%v", hedgePercentile)
+	}
+
+	hedgeMinDelay := config["queryport.client.scan.hedge.minDelay"].Int()
+	hedgeMaxDelay := config["queryport.client.scan.hedge.maxDelay"].Int()
+	if hedgeMinDelay >= 0 && hedgeMaxDelay >= hedgeMinDelay {
+		atomic.StoreInt64(&s.hedgeMinDelay, int64(hedgeMinDelay))
+		atomic.StoreInt64(&s.hedgeMaxDelay, int64(hedgeMaxDelay))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for hedge.minDelay=%v hedge.maxDelay=%v",
+			hedgeMinDelay, hedgeMaxDelay)
+	}
+
+	if config["queryport.client.circuitBreaker.enable"].Bool() {
+		atomic.StoreUint32(&s.breakerEnable, 1)
+	} else {
+		atomic.StoreUint32(&s.breakerEnable, 0)
+	}
+
+	breakerThreshold := config["queryport.client.circuitBreaker.failureThreshold"].Int()
+	if breakerThreshold > 0 {
+		atomic.StoreInt64(&s.breakerThreshold, int64(breakerThreshold))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for circuitBreaker.failureThreshold=%v", breakerThreshold)
+	}
+
+	breakerOpenTimeout := config["queryport.client.circuitBreaker.openTimeout"].Int()
+	if breakerOpenTimeout >= 0 {
+		atomic.StoreInt64(&s.breakerOpenTimeout, int64(breakerOpenTimeout))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for circuitBreaker.openTimeout=%v", breakerOpenTimeout)
+	}
+
+	rangeSplits := config["queryport.client.scan.rangeSplit.numSplits"].Int()
+	if rangeSplits >= 0 {
+		atomic.StoreUint32(&s.rangeSplits, uint32(rangeSplits))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for rangeSplit.numSplits=%v", rangeSplits)
+	}
+
+	rangeSplitQueue := config["queryport.client.scan.rangeSplit.queueSize"].Int()
+	if rangeSplitQueue > 0 {
+		atomic.StoreUint32(&s.rangeSplitQueue, uint32(rangeSplitQueue))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for rangeSplit.queueSize=%v", rangeSplitQueue)
+	}
+
+	alterIndexTimeout := config["indexer.settings.alter_index_timeout"].Int()
+	if alterIndexTimeout >= 0 {
+		atomic.StoreInt64(&s.alterIndexTimeout, int64(alterIndexTimeout))
+	} else {
+		logging.Errorf("ClientSettings: invalid setting value for alter_index_timeout=%v", alterIndexTimeout)
+	}
+
 	storageMode := config["indexer.settings.storage_mode"].String()
 	if len(storageMode) != 0 {
 		func() {
@@ -205,6 +283,10 @@ func (s *ClientSettings) StorageMode() string {
 	return s.storageMode
 }
 
+func (s *ClientSettings) AlterIndexTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.alterIndexTimeout)) * time.Second
+}
+
 func (s *ClientSettings) BackfillLimit() int32 {
 	return atomic.LoadInt32(&s.backfillLimit)
 }
@@ -236,3 +318,40 @@ func (s *ClientSettings) MaxConcurrency() uint32 {
 func (s *ClientSettings) AllowCJsonScanFormat() bool {
 	return atomic.LoadUint32(&s.allowCJsonScanFormat) == 1
 }
+
+func (s *ClientSettings) HedgeEnabled() bool {
+	return atomic.LoadUint32(&s.hedgeEnable) == 1
+}
+
+func (s *ClientSettings) HedgePercentile() float64 {
+	bits := atomic.LoadUint64(&s.hedgePercentile)
+	return math.Float64frombits(bits)
+}
+
+func (s *ClientSettings) HedgeMinDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.hedgeMinDelay)) * time.Millisecond
+}
+
+func (s *ClientSettings) HedgeMaxDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.hedgeMaxDelay)) * time.Millisecond
+}
+
+func (s *ClientSettings) CircuitBreakerEnabled() bool {
+	return atomic.LoadUint32(&s.breakerEnable) == 1
+}
+
+func (s *ClientSettings) CircuitBreakerThreshold() int64 {
+	return atomic.LoadInt64(&s.breakerThreshold)
+}
+
+func (s *ClientSettings) CircuitBreakerOpenTimeout() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.breakerOpenTimeout)) * time.Millisecond
+}
+
+func (s *ClientSettings) RangeSplits() int {
+	return int(atomic.LoadUint32(&s.rangeSplits))
+}
+
+func (s *ClientSettings) RangeSplitQueueSize() int {
+	return int(atomic.LoadUint32(&s.rangeSplitQueue))
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+package client
+
+import (
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/couchbase/indexing/secondary/common"
+	"github.com/couchbase/indexing/secondary/logging"
+	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
+)
+
+//--------------------------
+// Range split
+//--------------------------
+
+// A range scan on a non-partitioned index is served by a single stream
+// from the indexer. To scan a large range in parallel, the range is split
+// into sub-ranges at split points sampled by the indexer, the sub-ranges
+// are scanned concurrently and their responses are returned in order.
+//
+// Indexer pins the snapshot the split points were sampled from, and every
+// sub-range is scanned from that snapshot, so the result is the same as
+// scanning the whole range. Split points are only sampled from memdb
+// indexes, a range on forestdb and plasma indexes is scanned as a whole.
+//
+// A scan with a limit is not split, the entries beyond the limit would be
+// read by the sub-ranges only to be discarded.
+
+// subRange is a part of a split range.
+type subRange struct {
+	low, high common.SecondaryKey
+	inclusion Inclusion
+}
+
+// rangeSplits return the number of sub-ranges a range scan on the index
+// shall be split into, 0 if the scan is not split.
+func (c *GsiClient) rangeSplits(qc *GsiScanClient, index *common.IndexDefn, limit int64) int {
+	numSplits := c.settings.RangeSplits()
+	if numSplits < 2 || !qc.SupportsSplitPoints() || limit != math.MaxInt64 {
+		return 0
+	}
+
+	if index.IsPrimary || common.IsPartitioned(index.PartitionScheme) {
+		return 0
+	}
+	for _, desc := range index.Desc {
+		if desc {
+			return 0
+		}
+	}
+	return numSplits
+}
+
+// splitRange scan the range between low and high, split into numSplits
+// sub-ranges scanned concurrently. Range is scanned as a whole if the
+// indexer does not return split points.
+func (c *GsiClient) splitRange(
+	qc *GsiScanClient, defnID uint64, requestId string, low, high common.SecondaryKey,
+	inclusion Inclusion, distinct bool, limit int64, cons common.Consistency,
+	vector *TsConsistency, callb ResponseHandler, rollbackTime int64,
+	partitions []common.PartitionId, dataEncFmt common.DataEncodingFormat,
+	retry bool, traceContext string, numSplits int) (error, bool) {
+
+	splitKeys, snapshotId, err := qc.SplitPoints(defnID, requestId, low, high, inclusion,
+		numSplits, cons, vector, rollbackTime, partitions, retry)
+	if err != nil {
+		logging.Warnf("Range {%v,%v} split points failed, scan without split: %v",
+			defnID, requestId, err)
+	}
+	if err != nil || len(splitKeys) == 0 || snapshotId == 0 {
+		return qc.Range(
+			defnID, requestId, low, high, inclusion, distinct, limit, cons, vector,
+			callb, rollbackTime, partitions, dataEncFmt, retry, traceContext)
+	}
+
+	ranges := makeSubRanges(low, high, inclusion, splitKeys)
+	logging.Verbosef("Range {%v,%v} split into %v sub-ranges", defnID, requestId, len(ranges))
+
+	donech := make(chan bool)
+	queues := make([]chan ResponseReader, len(ranges))
+	errs := make([]error, len(ranges))
+
+	var wg sync.WaitGroup
+	for i, r := range ranges {
+		queues[i] = make(chan ResponseReader, c.settings.RangeSplitQueueSize())
+
+		wg.Add(1)
+		go func(i int, r *subRange) {
+			defer wg.Done()
+			defer close(queues[i])
+
+			handler := func(resp ResponseReader) bool {
+				if _, ok := resp.(*protobuf.StreamEndResponse); ok {
+					return false
+				}
+				select {
+				case queues[i] <- resp:
+					return true
+				case <-donech:
+					return false
+				}
+			}
+
+			// sub-ranges are distinct requests to the indexer.
+			subRequestId := fmt.Sprintf("%v-%v", requestId, i)
+			errs[i], _ = qc.RangeSnapshot(
+				defnID, subRequestId, r.low, r.high, r.inclusion, distinct, limit, cons,
+				vector, handler, rollbackTime, partitions, dataEncFmt, retry, traceContext,
+				snapshotId)
+		}(i, r)
+	}
+
+	// Return the sub-ranges in order. Queues are drained even after the
+	// scan is stopped, so that the sub-range scans can terminate.
+	partial, stopped := false, false
+	stop := func() {
+		if !stopped {
+			stopped = true
+			close(donech)
+		}
+	}
+
+	for i := range queues {
+		for resp := range queues[i] {
+			if !stopped {
+				partial = true
+				if !callb(resp) {
+					stop()
+				}
+			}
+		}
+		if errs[i] != nil && !stopped {
+			err = errs[i]
+			stop()
+		}
+	}
+	wg.Wait()
+
+	if !stopped {
+		callb(&protobuf.StreamEndResponse{})
+	}
+	return err, partial
+}
+
+// makeSubRanges split the range between low and high at the split keys.
+// Split key is included in the sub-range it starts, so that every entry of
+// the range is in exactly one sub-range.
+func makeSubRanges(low, high common.SecondaryKey, inclusion Inclusion,
+	splitKeys []common.SecondaryKey) []*subRange {
+
+	ranges := make([]*subRange, 0, len(splitKeys)+1)
+	from, incl := low, inclusion&Low
+	for _, key := range splitKeys {
+		ranges = append(ranges, &subRange{low: from, high: key, inclusion: incl})
+		from, incl = key, Low
+	}
+	ranges = append(ranges, &subRange{low: from, high: high, inclusion: incl | inclusion&High})
+	return ranges
+}
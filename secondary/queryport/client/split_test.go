@@ -0,0 +1,87 @@
+package client
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/couchbase/indexing/secondary/common"
+)
+
+func TestMakeSubRanges(t *testing.T) {
+
+	a, b, c, d := common.SecondaryKey{"a"}, common.SecondaryKey{"b"},
+		common.SecondaryKey{"c"}, common.SecondaryKey{"d"}
+
+	testcases := []struct {
+		comment   string
+		inclusion Inclusion
+		splitKeys []common.SecondaryKey
+		expected  []subRange
+	}{
+		{"no split key", Both, nil,
+			[]subRange{{a, d, Both}}},
+		{"both", Both, []common.SecondaryKey{b, c},
+			[]subRange{{a, b, Low}, {b, c, Low}, {c, d, Both}}},
+		{"neither", Neither, []common.SecondaryKey{b, c},
+			[]subRange{{a, b, Neither}, {b, c, Low}, {c, d, Low}}},
+		{"low", Low, []common.SecondaryKey{b},
+			[]subRange{{a, b, Low}, {b, d, Low}}},
+		{"high", High, []common.SecondaryKey{b},
+			[]subRange{{a, b, Neither}, {b, d, Both}}},
+	}
+
+	for _, tc := range testcases {
+		ranges := makeSubRanges(a, d, tc.inclusion, tc.splitKeys)
+
+		var got []subRange
+		for _, r := range ranges {
+			got = append(got, *r)
+		}
+		if !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expected, got)
+		}
+
+		// every split key is included in exactly one sub-range
+		for _, key := range tc.splitKeys {
+			n := 0
+			for _, r := range ranges {
+				if (reflect.DeepEqual(r.low, key) && r.inclusion&Low != 0) ||
+					(reflect.DeepEqual(r.high, key) && r.inclusion&High != 0) {
+					n++
+				}
+			}
+			if n != 1 {
+				t.Errorf("%v: split key %v included in %v sub-ranges", tc.comment, key, n)
+			}
+		}
+	}
+}
+
+func TestRangeSplits(t *testing.T) {
+
+	c := &GsiClient{settings: &ClientSettings{rangeSplits: 4}}
+	qc := &GsiScanClient{splitPoints: 1}
+	noLimit := int64(math.MaxInt64)
+
+	testcases := []struct {
+		comment  string
+		qc       *GsiScanClient
+		index    *common.IndexDefn
+		limit    int64
+		expected int
+	}{
+		{"split", qc, &common.IndexDefn{}, noLimit, 4},
+		{"limit", qc, &common.IndexDefn{}, 10, 0},
+		{"old indexer", &GsiScanClient{}, &common.IndexDefn{}, noLimit, 0},
+		{"primary", qc, &common.IndexDefn{IsPrimary: true}, noLimit, 0},
+		{"partitioned", qc, &common.IndexDefn{PartitionScheme: common.KEY}, noLimit, 0},
+		{"desc", qc, &common.IndexDefn{Desc: []bool{false, true}}, noLimit, 0},
+	}
+
+	for _, tc := range testcases {
+		if got := c.rangeSplits(tc.qc, tc.index, tc.limit); got != tc.expected {
+			t.Errorf("%v: expected %v, got %v", tc.comment, tc.expected, got)
+		}
+	}
+}
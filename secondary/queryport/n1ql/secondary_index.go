@@ -27,6 +27,7 @@ import c "github.com/couchbase/indexing/secondary/common"
 import "github.com/couchbase/indexing/secondary/collatejson"
 import qclient "github.com/couchbase/indexing/secondary/queryport/client"
 import mclient "github.com/couchbase/indexing/secondary/manager/client"
+import mc "github.com/couchbase/indexing/secondary/manager/common"
 import "github.com/couchbase/query/datastore"
 import "github.com/couchbase/query/errors"
 import "github.com/couchbase/query/expression"
@@ -215,23 +216,38 @@ func (gsi *gsiKeyspace) IndexById(id string) (datastore.Index, errors.Error) {
 }
 
 // IndexByName implements datastore.Indexer{} interface. Find an index on
-// this keyspace using the index's name.
+// this keyspace using the index's name. If there is no index with that
+// name, name is resolved as an index alias.
 func (gsi *gsiKeyspace) IndexByName(name string) (datastore.Index, errors.Error) {
+	if index := gsi.indexByName(name); index != nil {
+		return index, nil
+	}
+
+	if defnID, e := gsi.gsiClient.ResolveIndexAlias(gsi.keyspace, name); e == nil {
+		if index, err := gsi.IndexById(defnID2String(defnID)); err == nil {
+			return index, nil
+		}
+	}
+
+	err := errors.NewError(nil, fmt.Sprintf("GSI index %v not found.", name))
+	return nil, err
+}
+
+func (gsi *gsiKeyspace) indexByName(name string) datastore.Index {
 	gsi.rw.RLock()
 	defer gsi.rw.RUnlock()
 
 	for _, index := range gsi.indexes {
 		if index.Name() == name {
-			return index, nil
+			return index
 		}
 	}
 	for _, index := range gsi.primaryIndexes {
 		if index.Name() == name {
-			return index, nil
+			return index
 		}
 	}
-	err := errors.NewError(nil, fmt.Sprintf("GSI index %v not found.", name))
-	return nil, err
+	return nil
 }
 
 // Indexes implements datastore.Indexer{} interface. Return the latest
@@ -306,7 +322,7 @@ func (gsi *gsiKeyspace) CreatePrimaryIndex3(
 		}
 	}
 
-	defnID, err := gsi.gsiClient.CreateIndex3(
+	defnID, err := gsi.gsiClient.CreateIndex4(
 		name,
 		gsi.keyspace, /*bucket-name*/
 		"GSI",        /*using*/
@@ -317,7 +333,8 @@ func (gsi *gsiKeyspace) CreatePrimaryIndex3(
 		true,         /*isPrimary*/
 		partitionScheme,
 		partitionKeys,
-		withJSON)
+		withJSON,
+		ddlRequest(requestId))
 	if err != nil {
 		return nil, errors.NewError(err, "GSI CreatePrimaryIndex()")
 	}
@@ -431,7 +448,7 @@ func (gsi *gsiKeyspace) CreateIndex3(
 		}
 	}
 
-	defnID, err := gsi.gsiClient.CreateIndex3(
+	defnID, err := gsi.gsiClient.CreateIndex4(
 		name,
 		gsi.keyspace, /*bucket-name*/
 		"GSI",        /*using*/
@@ -442,7 +459,8 @@ func (gsi *gsiKeyspace) CreateIndex3(
 		false, /*isPrimary*/
 		partitionScheme,
 		partitionKeys,
-		withJSON)
+		withJSON,
+		ddlRequest(requestId))
 	if err != nil {
 		return nil, errors.NewError(err, "GSI CreateIndex()")
 	}
@@ -453,6 +471,18 @@ func (gsi *gsiKeyspace) CreateIndex3(
 	return gsi.IndexById(defnID2String(defnID))
 }
 
+// ddlRequest to record a DDL issued by query request `requestId` in
+// DDL history. Query does not pass the credentials of the request down
+// to the indexer, hence the user is not known.
+func ddlRequest(requestId string) *mc.DDLRequest {
+	return &mc.DDLRequest{
+		Context: mc.DDLHistoryContext{
+			Source:    mc.DDLHistorySourceQuery,
+			RequestId: requestId,
+		},
+	}
+}
+
 func partitionKey(partitionType datastore.PartitionType) c.PartitionScheme {
 	if partitionType == datastore.HASH_PARTITION {
 		return c.PartitionScheme(c.KEY)
@@ -471,7 +501,7 @@ func (gsi *gsiKeyspace) BuildIndexes(requestId string, names ...string) errors.E
 		}
 		defnIDs[i] = string2defnID(index.Id())
 	}
-	err := gsi.gsiClient.BuildIndexes(defnIDs)
+	err := gsi.gsiClient.BuildIndexes2(defnIDs, ddlRequest(requestId))
 	if err != nil {
 		return errors.NewError(err, "BuildIndexes")
 	}
@@ -812,7 +842,7 @@ func (si *secondaryIndex) Drop(requestId string) errors.Error {
 	if si == nil {
 		return ErrorIndexEmpty
 	}
-	if err := si.gsi.gsiClient.DropIndex(si.defnID); err != nil {
+	if err := si.gsi.gsiClient.DropIndex2(si.defnID, ddlRequest(requestId)); err != nil {
 		return errors.NewError(err, "GSI Drop()")
 	}
 	si.gsi.delIndex(si.Id())
@@ -1180,6 +1210,49 @@ func (si *secondaryIndex3) Alter(requestId string, with value.Value) (
 			return nil, errors.NewError(e, "GSI AlterIndex()")
 		}
 		return datastore.Index(si), nil
+	case "alter_definition":
+		e := si.alterDefinition(requestId, withMap)
+		if e != nil {
+			return nil, errors.NewError(e, "GSI AlterIndex()")
+		}
+		return datastore.Index(si), nil
+	case "abort_alter":
+		client := si.gsi.gsiClient
+		e := client.AbortAlterIndex(si.defnID)
+		if e != nil {
+			return nil, errors.NewError(e, "GSI AlterIndex()")
+		}
+		return datastore.Index(si), nil
+	case "rename":
+		name, ok := withMap["name"].(string)
+		if !ok {
+			return nil, errors.NewError(fmt.Errorf("GSI AlterIndex() name key missing in WITH clause"), "")
+		}
+		client := si.gsi.gsiClient
+		if e := client.RenameIndex(si.defnID, name); e != nil {
+			return nil, errors.NewError(e, "GSI AlterIndex()")
+		}
+		// refresh to get back the renamed index.
+		if err := si.gsi.Refresh(); err != nil {
+			return nil, err
+		}
+		return si.gsi.IndexById(defnID2String(si.defnID))
+	case "set_alias", "drop_alias":
+		alias, ok := withMap["alias"].(string)
+		if !ok {
+			return nil, errors.NewError(fmt.Errorf("GSI AlterIndex() alias key missing in WITH clause"), "")
+		}
+		client := si.gsi.gsiClient
+		var e error
+		if action == "set_alias" {
+			e = client.SetIndexAlias(si.bucketn, alias, si.defnID)
+		} else {
+			e = client.DropIndexAlias(si.bucketn, alias)
+		}
+		if e != nil {
+			return nil, errors.NewError(e, "GSI AlterIndex()")
+		}
+		return datastore.Index(si), nil
 	default:
 		return nil, errors.NewError(fmt.Errorf(ErrorUnsupportedAction), "")
 	}
@@ -1187,6 +1260,130 @@ func (si *secondaryIndex3) Alter(requestId string, with value.Value) (
 	return datastore.Index(si), nil
 }
 
+// alterDefinition rebuilds the index with the keys, where clause and
+// partition keys given in the WITH clause. Attributes not specified in
+// the WITH clause are retained from the current definition. All other
+// WITH parameters are passed on as the plan for the rebuilt index.
+func (si *secondaryIndex3) alterDefinition(
+	requestId string, withMap map[string]interface{}) error {
+
+	toStrings := func(key string) ([]string, bool, error) {
+		v, ok := withMap[key]
+		if !ok {
+			return nil, false, nil
+		}
+		list, ok := v.([]interface{})
+		if !ok {
+			return nil, true, fmt.Errorf("%v must be an array of expressions", key)
+		}
+		strs := make([]string, 0, len(list))
+		for _, item := range list {
+			str, ok := item.(string)
+			if !ok {
+				return nil, true, fmt.Errorf("%v must be an array of expressions", key)
+			}
+			if _, err := parser.Parse(str); err != nil {
+				return nil, true, fmt.Errorf("invalid expression %v in %v: %v", str, key, err)
+			}
+			strs = append(strs, str)
+		}
+		return strs, true, nil
+	}
+
+	// index keys
+	secStrs := make([]string, len(si.secExprs))
+	for i, expr := range si.secExprs {
+		secStrs[i] = expression.NewStringer().Visit(expr)
+	}
+	desc := si.desc
+	keys, ok, err := toStrings("keys")
+	if err != nil {
+		return err
+	}
+	if ok {
+		if len(keys) == 0 {
+			return fmt.Errorf("keys cannot be empty")
+		}
+		secStrs = keys
+		desc = make([]bool, len(keys))
+		if v, ok := withMap["desc"]; ok {
+			list, ok := v.([]interface{})
+			if !ok || len(list) != len(keys) {
+				return fmt.Errorf("desc must be an array of booleans matching keys")
+			}
+			for i, item := range list {
+				if desc[i], ok = item.(bool); !ok {
+					return fmt.Errorf("desc must be an array of booleans matching keys")
+				}
+			}
+		}
+	}
+
+	// where
+	var whereStr string
+	if si.whereExpr != nil {
+		whereStr = expression.NewStringer().Visit(si.whereExpr)
+	}
+	if v, ok := withMap["where"]; ok {
+		if whereStr, ok = v.(string); !ok {
+			return fmt.Errorf("where must be an expression string")
+		}
+		if len(whereStr) != 0 {
+			if _, err := parser.Parse(whereStr); err != nil {
+				return fmt.Errorf("invalid where expression %v: %v", whereStr, err)
+			}
+		}
+	}
+
+	// partition
+	partitionScheme := c.PartitionScheme(c.SINGLE)
+	var partitionKeys []string
+	for _, expr := range si.partnExpr {
+		partitionKeys = append(partitionKeys, expression.NewStringer().Visit(expr))
+	}
+	if len(partitionKeys) != 0 {
+		partitionScheme = c.PartitionScheme(c.KEY)
+	}
+	keys, ok, err = toStrings("partition_keys")
+	if err != nil {
+		return err
+	}
+	if ok {
+		partitionKeys = keys
+		partitionScheme = c.PartitionScheme(c.SINGLE)
+		if len(keys) != 0 {
+			partitionScheme = c.PartitionScheme(c.KEY)
+		}
+	}
+
+	// remaining parameters form the plan
+	plan := make(map[string]interface{})
+	for key, value := range withMap {
+		switch key {
+		case "action", "keys", "desc", "where", "partition_keys":
+		default:
+			plan[key] = value
+		}
+	}
+	planJSON, err := json.Marshal(plan)
+	if err != nil {
+		return err
+	}
+
+	return si.gsi.gsiClient.AlterIndexDefinition2(
+		si.defnID,
+		"GSI",  /*using*/
+		"N1QL", /*exprType*/
+		whereStr,
+		secStrs,
+		desc,
+		si.isPrimary,
+		partitionScheme,
+		partitionKeys,
+		planJSON,
+		ddlRequest(requestId))
+}
+
 // ScanEntries3 implements datastore.PrimaryIndex3 interface.
 func (si *secondaryIndex3) ScanEntries3(
 	requestId string, projection *datastore.IndexProjection, offset, limit int64,
@@ -15,6 +15,7 @@ import (
 
 	protobuf "github.com/couchbase/indexing/secondary/protobuf/query"
 	"github.com/couchbase/indexing/secondary/transport"
+	"github.com/golang/protobuf/proto"
 )
 
 // RequestHandler shall interpret the request message
@@ -27,8 +28,10 @@ type RequestHandler func(
 type ConnectionHandler func() interface{}
 
 type request struct {
-	r      interface{}
-	quitch chan bool
+	r         interface{}
+	quitch    chan bool
+	compress  bool // client accepts compressed responses
+	multiplex bool // client switches the connection to multiplexed mode
 }
 
 var Ping *request = &request{}
@@ -53,12 +56,20 @@ type Server struct {
 	writeDeadline     time.Duration
 	keepAliveInterval time.Duration
 	streamChanSize    int
+	compression       byte
+	compThreshold     int
+	multiplex         bool
+	maxStreams        int // per multiplexed connection
 	logPrefix         string
 	nConnections      int64
+	nStreams          int64
+	compStats         *transport.CompressionStats
 }
 
 type ServerStats struct {
 	Connections int64
+	Streams     int64 // active streams on multiplexed connections
+	Compression map[string]interface{}
 }
 
 // NewServer creates a new queryport daemon.
@@ -74,8 +85,17 @@ func NewServer(
 		readDeadline:   time.Duration(config["readDeadline"].Int()),
 		writeDeadline:  time.Duration(config["writeDeadline"].Int()),
 		streamChanSize: config["streamChanSize"].Int(),
+		compThreshold:  config["compressionThreshold"].Int(),
+		multiplex:      config["multiplex"].Bool(),
+		maxStreams:     config["multiplex.maxStreams"].Int(),
 		logPrefix:      fmt.Sprintf("[Queryport %q]", laddr),
 		nConnections:   0,
+		compStats:      transport.NewCompressionStats(),
+	}
+	s.compression, err = transport.CompressionType(config["compression"].String())
+	if err != nil {
+		logging.Errorf("%v invalid compression: %v\n", s.logPrefix, err)
+		return nil, err
 	}
 	keepAliveInterval := config["keepAliveInterval"].Int()
 	s.keepAliveInterval = time.Duration(keepAliveInterval) * time.Second
@@ -92,6 +112,8 @@ func NewServer(
 func (s *Server) Statistics() ServerStats {
 	return ServerStats{
 		Connections: atomic.LoadInt64(&s.nConnections),
+		Streams:     atomic.LoadInt64(&s.nStreams),
+		Compression: s.compStats.Map(),
 	}
 }
 
@@ -182,29 +204,119 @@ func (s *Server) handleConnection(conn net.Conn) {
 		tcpconn.SetKeepAlivePeriod(s.keepAliveInterval)
 	}
 
+	var ctx interface{}
+	if s.conb != nil {
+		ctx = s.conb()
+	}
+
+	if s.serveRequests(conn, ctx, s.multiplex) {
+		s.handleMuxConnection(conn, ctx)
+	}
+}
+
+// serve requests on a connection, or on a stream of a multiplexed
+// connection, one request at a time. Return true if client has switched
+// the connection to multiplexed mode.
+func (s *Server) serveRequests(conn net.Conn, ctx interface{}, multiplex bool) (switched bool) {
 	// start a receive routine.
 	killch := make(chan bool)
 	rcvch := make(chan request, s.streamChanSize)
 
-	go s.doReceive(conn, rcvch, killch)
+	go s.doReceive(conn, rcvch, killch, multiplex)
 	go s.doPing(rcvch, killch)
 
-	var ctx interface{}
-	if s.conb != nil {
-		ctx = s.conb()
+	// responses are compressed only for clients that accept compression.
+	var cconn net.Conn
+	if s.compression != transport.CompressionNone {
+		cconn = transport.NewCompressedConn(
+			conn, s.compression, s.compThreshold, s.compStats)
 	}
 
 	for req := range rcvch {
-		s.callb(req.r, ctx, conn, req.quitch) // blocking call
+		if req.multiplex {
+			switched = s.heloMultiplex(conn) == nil
+			continue
+		}
+		wconn := conn
+		if req.compress && cconn != nil {
+			wconn = cconn
+		}
+		s.callb(req.r, ctx, wconn, req.quitch) // blocking call
 		if req.r != Ping {
 			transport.SendResponseEnd(conn)
 		}
 	}
+	return switched
+}
+
+// acknowledge client's request to multiplex scans on the connection.
+func (s *Server) heloMultiplex(conn net.Conn) error {
+	buf := make([]byte, 1024)
+	res := &protobuf.HeloResponse{
+		Version:   proto.Uint32(c.INDEXER_CUR_VERSION),
+		Multiplex: proto.Bool(true),
+	}
+	if err := protobuf.EncodeAndWrite(conn, buf, res); err != nil {
+		return err
+	}
+	return transport.SendResponseEnd(conn)
+}
+
+// handle a multiplexed connection, every stream is served like a
+// connection of its own.
+func (s *Server) handleMuxConnection(conn net.Conn, ctx interface{}) {
+	raddr := conn.RemoteAddr()
+	logging.Infof("%v connection %v switched to multiplexed mode\n", s.logPrefix, raddr)
+
+	writeTimeout := s.writeDeadline * time.Millisecond
+	session := transport.NewMuxSession(conn, false /*client*/, s.maxStreams, writeTimeout)
+	defer session.Close()
+
+	// connection context is not safe for concurrent requests, hence
+	// contexts are reused by streams one at a time.
+	var mu sync.Mutex
+	ctxs := []interface{}{ctx}
+	getCtx := func() interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		if n := len(ctxs); n > 0 {
+			ctx := ctxs[n-1]
+			ctxs = ctxs[:n-1]
+			return ctx
+		} else if s.conb != nil {
+			return s.conb()
+		}
+		return nil
+	}
+	putCtx := func(ctx interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		ctxs = append(ctxs, ctx)
+	}
+
+	for {
+		stream, err := session.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			atomic.AddInt64(&s.nStreams, 1)
+			defer atomic.AddInt64(&s.nStreams, -1)
+
+			sctx := getCtx()
+			defer putCtx(sctx)
+			defer stream.Close()
+
+			s.serveRequests(stream, sctx, false /*multiplex*/)
+		}()
+	}
 }
 
 // receive requests from remote, when this function returns
-// the connection is expected to be closed.
-func (s *Server) doReceive(conn net.Conn, rcvch chan<- request, killch chan bool) {
+// the connection is expected to be closed, or switched to
+// multiplexed mode.
+func (s *Server) doReceive(conn net.Conn, rcvch chan<- request, killch chan bool, multiplex bool) {
 	raddr := conn.RemoteAddr()
 
 	// transport buffer for receiving
@@ -250,12 +362,23 @@ loop:
 			// reset currRequest such that subsequent connection close will not try to
 			// close the quitch channel twice.
 			currRequest.quitch = nil
+		} else if helo, yes := reqMsg.(*protobuf.HeloRequest); yes && multiplex && helo.GetMultiplex() {
+			// Client requested to multiplex scans on this connection. No more
+			// requests are received in this mode, the connection is handed over
+			// to the multiplexer after acknowledging the request.
+			format := "%v connection %s client requested multiplexing"
+			logging.Infof(format, s.logPrefix, raddr)
+			req := newRequest(reqMsg)
+			req.multiplex = true
+			rcvch <- req
+			break loop
 		} else {
 			// Each queryport connection can only handle one client request at a time. Client must ensure that:
 			// 1) If there is network error (e.g. timeout), connection is not going to be reused.
 			// 2) If client cancel request, EndStreamRequest must be sent.
 			// 3) Connection is not reused until current client request is successfully finished or canceled.
 			currRequest = newRequest(reqMsg)
+			currRequest.compress = rpkt.PeerAcceptsCompression()
 			rcvch <- currRequest
 		}
 	}
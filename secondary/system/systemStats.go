@@ -1,12 +1,14 @@
 package system
 
 //#cgo LDFLAGS: -lsigar
+//#include <stdlib.h>
 //#include <sigar.h>
 import "C"
 
 import (
 	"errors"
 	"fmt"
+	"unsafe"
 )
 
 type SystemStats struct {
@@ -91,3 +93,20 @@ func (h *SystemStats) TotalMem() (uint64, error) {
 
 	return uint64(mem.total), nil
 }
+
+//
+// Get Total disk space of the file system of a directory
+//
+func (h *SystemStats) TotalDisk(dir string) (uint64, error) {
+
+	cdir := C.CString(dir)
+	defer C.free(unsafe.Pointer(cdir))
+
+	var usage C.sigar_file_system_usage_t
+	if err := C.sigar_file_system_usage_get(h.handle, cdir, &usage); err != C.SIGAR_OK {
+		return uint64(0), errors.New(fmt.Sprintf("Fail to get total disk.  Err=%v", C.sigar_strerror(h.handle, err)))
+	}
+
+	// sigar reports file system size in KB
+	return uint64(usage.total) * 1024, nil
+}
@@ -24,7 +24,11 @@ var options struct {
 	stat          int      // periodic timeout to print dataport statistics
 	timeout       int      // timeout for dataport to exit
 	auth          string
-	projector     bool // start projector, useful in debug mode.
+	projector     bool    // start projector, useful in debug mode.
+	capture       string  // capture mutations received by endpoints
+	replay        string  // replay captured mutations into endpoints
+	speed         float64 // replay speed relative to capture
+	maxGap        int     // compress idle gaps in replay, in milliseconds
 	debug         bool
 	trace         bool
 }
@@ -48,6 +52,14 @@ func argParse() []string {
 		"Auth user and password")
 	flag.BoolVar(&options.projector, "projector", false,
 		"start projector for debug mode")
+	flag.StringVar(&options.capture, "capture", "",
+		"directory to capture mutations received by endpoints")
+	flag.StringVar(&options.replay, "replay", "",
+		"directory to replay captured mutations from, into endpoints")
+	flag.Float64Var(&options.speed, "speed", 0,
+		"replay speed, 1 for real time, 0 for as fast as possible")
+	flag.IntVar(&options.maxGap, "maxgap", 0,
+		"compress idle gaps in replay to maxgap milliseconds, 0 preserves")
 	flag.BoolVar(&options.debug, "debug", false,
 		"run in debug mode")
 	flag.BoolVar(&options.trace, "trace", false,
@@ -66,7 +78,9 @@ func argParse() []string {
 	}
 
 	args := flag.Args()
-	if len(args) < 1 || len(options.buckets) < 1 {
+	if options.replay != "" {
+		return nil
+	} else if len(args) < 1 || len(options.buckets) < 1 {
 		usage()
 		os.Exit(1)
 	}
@@ -75,6 +89,7 @@ func argParse() []string {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage : %s [OPTIONS] <addr> \n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "        %s -replay <dir> [OPTIONS]\n", os.Args[0])
 	flag.PrintDefaults()
 }
 
@@ -82,6 +97,10 @@ var projectors = make(map[string]*projc.Client)
 
 func main() {
 	clusters := argParse()
+	if options.replay != "" {
+		replay()
+		return
+	}
 
 	// setup cbauth
 	up := strings.Split(options.auth, ":")
@@ -93,6 +112,9 @@ func main() {
 	maxvbs := c.SystemConfig["maxVbuckets"].Int()
 	dconf := c.SystemConfig.SectionConfig("indexer.dataport.", true)
 	dconf.SetValue("genServerChanSize", 1000000)
+	if options.capture != "" {
+		dconf.SetValue("captureDir", options.capture)
+	}
 
 	// start dataport servers.
 	for _, endpoint := range options.endpoints {
@@ -139,6 +161,34 @@ func main() {
 	//<-make(chan bool) // wait for ever
 }
 
+// replay captured mutations into each endpoint, endpoints can be local
+// dataport servers, like that of an indexer, or those started by this
+// tool with -capture option. Each endpoint is replayed with the mutations
+// captured on its port. To replay directly into indexer's stream reader,
+// skipping the dataport, refer indexer.dataport.replayDir.
+func replay() {
+	maxPayload := c.SystemConfig["projector.dataport.maxPayload"].Int()
+	opts := dataport.ReplayOptions{
+		Speed:  options.speed,
+		MaxGap: time.Duration(options.maxGap) * time.Millisecond,
+	}
+	for _, endpoint := range options.endpoints {
+		prefix := dataport.CapturePrefix(endpoint)
+		reader, err := dataport.NewCaptureReader(options.replay, prefix)
+		mf(err, "capture reader")
+		sink, err := dataport.NewEndpointSink(endpoint, maxPayload)
+		mf(err, "endpoint sink")
+		stats, err := dataport.Replay(reader, sink, opts)
+		mf(err, "replay")
+		reader.Close()
+		sink.Close()
+		fmsg := "replayed %v records, %v mutations, %v connection errors " +
+			"to %q in %v\n"
+		log.Printf(fmsg, stats.Records, stats.Mutations, stats.ConnErrors,
+			endpoint, stats.Elapsed)
+	}
+}
+
 func getProjectorAdminport(cluster, pooln string) string {
 	url, err := c.ClusterAuthUrl(cluster)
 	if err != nil {
@@ -0,0 +1,189 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// ErrExporterFull is returned when a span is dropped because the
+// exporter cannot keep up.
+var ErrExporterFull = errors.New("tracing: exporter queue full")
+
+// ErrExporterClosed is returned when a span is exported after the
+// exporter is closed.
+var ErrExporterClosed = errors.New("tracing: exporter closed")
+
+// Exporter receives finished spans. Export is called on the request
+// path, hence it shall not block.
+type Exporter interface {
+	Export(span *SpanData) error
+	Close() error
+}
+
+const fileExporterQueueSize = 10000
+const fileExporterFlushInterval = time.Second
+
+// FileExporter writes spans to a file, one JSON object per line. Spans
+// are queued and written by a separate routine, spans are dropped when
+// the queue is full. Once the file grows beyond maxSize, it is rotated to
+// `<path>.1`, replacing the previously rotated file, so that at most
+// twice maxSize is used on disk.
+type FileExporter struct {
+	path    string
+	maxSize int64
+	file    *os.File
+	size    int64
+	queue   chan *SpanData
+	dropped uint64
+
+	mutex  sync.RWMutex
+	closed bool
+	donech chan bool
+}
+
+// NewFileExporter opens `path` for appending spans, the file is rotated
+// once it grows beyond `maxSize` bytes, 0 for no limit.
+func NewFileExporter(path string, maxSize int64) (*FileExporter, error) {
+	e := &FileExporter{
+		path:    path,
+		maxSize: maxSize,
+		queue:   make(chan *SpanData, fileExporterQueueSize),
+		donech:  make(chan bool),
+	}
+	if err := e.open(); err != nil {
+		return nil, err
+	}
+	go e.run()
+	return e, nil
+}
+
+// Export queues the span for writing.
+func (e *FileExporter) Export(span *SpanData) error {
+	e.mutex.RLock()
+	defer e.mutex.RUnlock()
+
+	if e.closed {
+		return ErrExporterClosed
+	}
+
+	select {
+	case e.queue <- span:
+		return nil
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+		return ErrExporterFull
+	}
+}
+
+// Dropped return number of spans dropped because the queue was full.
+func (e *FileExporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Close writes the queued spans and closes the file.
+func (e *FileExporter) Close() error {
+	e.mutex.Lock()
+	if e.closed {
+		e.mutex.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.queue)
+	e.mutex.Unlock()
+
+	<-e.donech
+	return e.file.Close()
+}
+
+func (e *FileExporter) open() error {
+	file, err := os.OpenFile(e.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	e.file, e.size = file, info.Size()
+	return nil
+}
+
+// rotate the file to `<path>.1` and open a new file. If the file cannot
+// be renamed, it is truncated so that the size limit is still honored.
+func (e *FileExporter) rotate() error {
+	e.file.Close()
+	if err := os.Rename(e.path, e.path+".1"); err != nil {
+		logging.Warnf("tracing: unable to rotate trace file %v, truncating. Error = %v", e.path, err)
+		if err := os.Truncate(e.path, 0); err != nil {
+			return err
+		}
+	}
+	return e.open()
+}
+
+// fileWriter counts the bytes written to the current file of exporter.
+type fileWriter struct {
+	e *FileExporter
+}
+
+func (w fileWriter) Write(p []byte) (int, error) {
+	n, err := w.e.file.Write(p)
+	w.e.size += int64(n)
+	return n, err
+}
+
+func (e *FileExporter) run() {
+	defer close(e.donech)
+
+	w := bufio.NewWriter(fileWriter{e})
+	encoder := json.NewEncoder(w)
+
+	ticker := time.NewTicker(fileExporterFlushInterval)
+	defer ticker.Stop()
+
+	flush := func() {
+		if err := w.Flush(); err != nil {
+			logging.Errorf("tracing: unable to write to trace file %v. Error = %v", e.path, err)
+		}
+	}
+
+	for {
+		select {
+		case span, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if err := encoder.Encode(span); err != nil {
+				logging.Debugf("tracing: unable to encode span %v. Error = %v", span.SpanId, err)
+			}
+			if e.maxSize > 0 && e.size+int64(w.Buffered()) >= e.maxSize {
+				flush()
+				if err := e.rotate(); err != nil {
+					logging.Errorf("tracing: unable to open trace file %v, stop tracing. Error = %v", e.path, err)
+					return
+				}
+			}
+
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidContext is returned when a trace context received from a
+// remote process cannot be parsed.
+var ErrInvalidContext = errors.New("tracing: invalid trace context")
+
+const traceIdLen = 32
+const spanIdLen = 16
+
+// SpanContext identifies a span across process boundaries.
+type SpanContext struct {
+	TraceId string
+	SpanId  string
+}
+
+// IsValid return true if the context belongs to a sampled trace.
+func (sc SpanContext) IsValid() bool {
+	return len(sc.TraceId) == traceIdLen && len(sc.SpanId) == spanIdLen
+}
+
+// String encodes the context as `<traceId>-<spanId>`, empty string
+// if the context is not valid.
+func (sc SpanContext) String() string {
+	if !sc.IsValid() {
+		return ""
+	}
+	return sc.TraceId + "-" + sc.SpanId
+}
+
+// ParseSpanContext decodes a context encoded by SpanContext.String().
+func ParseSpanContext(s string) (SpanContext, error) {
+	parts := strings.Split(s, "-")
+	if len(parts) != 2 || len(parts[0]) != traceIdLen || len(parts[1]) != spanIdLen {
+		return SpanContext{}, ErrInvalidContext
+	}
+	for _, part := range parts {
+		if _, err := hex.DecodeString(part); err != nil {
+			return SpanContext{}, ErrInvalidContext
+		}
+	}
+	return SpanContext{TraceId: parts[0], SpanId: parts[1]}, nil
+}
+
+// Span is a timed operation within a trace. All methods are safe to call
+// on a nil span, which is what a tracer returns for a request that is not
+// traced, so callers do not have to check whether tracing is enabled.
+type Span struct {
+	tracer   *Tracer
+	name     string
+	context  SpanContext
+	parentId string
+	start    time.Time
+
+	mutex      sync.Mutex
+	attributes map[string]interface{}
+	finished   bool
+}
+
+// SpanData is the record of a finished span handed to the exporter.
+type SpanData struct {
+	TraceId    string                 `json:"traceId"`
+	SpanId     string                 `json:"spanId"`
+	ParentId   string                 `json:"parentId,omitempty"`
+	Name       string                 `json:"name"`
+	Service    string                 `json:"service"`
+	Start      time.Time              `json:"start"`
+	Duration   int64                  `json:"durationNs"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Context return the context to propagate to child spans, possibly in
+// another process.
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+// TraceId return the id of the trace, for correlating log messages with
+// the trace.
+func (s *Span) TraceId() string {
+	if s == nil {
+		return ""
+	}
+	return s.context.TraceId
+}
+
+// StartChild starts a span that is a child of this span.
+func (s *Span) StartChild(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.newSpan(name, s.context.TraceId, s.context.SpanId)
+}
+
+// SetAttribute adds a key/value to the span. Value must be JSON
+// serializable.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.attributes == nil {
+		s.attributes = make(map[string]interface{})
+	}
+	s.attributes[key] = value
+}
+
+// SetError records the error, if any, as an attribute of the span.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.SetAttribute("error", err.Error())
+}
+
+// Finish ends the span and hands it to the exporter. Calling Finish
+// more than once has no effect.
+func (s *Span) Finish() {
+	if s == nil {
+		return
+	}
+
+	s.mutex.Lock()
+	if s.finished {
+		s.mutex.Unlock()
+		return
+	}
+	s.finished = true
+
+	data := &SpanData{
+		TraceId:    s.context.TraceId,
+		SpanId:     s.context.SpanId,
+		ParentId:   s.parentId,
+		Name:       s.name,
+		Service:    s.tracer.service,
+		Start:      s.start,
+		Duration:   int64(time.Since(s.start)),
+		Attributes: s.attributes,
+	}
+	s.mutex.Unlock()
+
+	s.tracer.export(data)
+}
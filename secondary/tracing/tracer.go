@@ -0,0 +1,132 @@
+// Copyright (c) 2014 Couchbase, Inc.
+// Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+// except in compliance with the License. You may obtain a copy of the License at
+//   http://www.apache.org/licenses/LICENSE-2.0
+// Unless required by applicable law or agreed to in writing, software distributed under the
+// License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing permissions
+// and limitations under the License.
+
+// Package tracing provides spans that follow a scan request from the
+// GSI client, over queryport, into the indexer and its storage. Trace
+// context is propagated between processes as a string, see SpanContext.
+// Finished spans are handed to a pluggable Exporter.
+package tracing
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/couchbase/indexing/secondary/logging"
+)
+
+// Tracer creates spans for a service and exports them when finished.
+// A nil tracer is valid and does not trace anything.
+type Tracer struct {
+	service    string
+	sampleRate float64
+	exporter   Exporter
+
+	mutex sync.Mutex
+	rnd   *rand.Rand
+}
+
+// NewTracer returns a tracer for `service`. Traces started by this tracer
+// are sampled at `sampleRate`, 0 to 1, while child spans of a remote
+// context are always traced.
+func NewTracer(service string, sampleRate float64, exporter Exporter) *Tracer {
+	return &Tracer{
+		service:    service,
+		sampleRate: sampleRate,
+		exporter:   exporter,
+		rnd:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// NewFileTracer returns a tracer that exports spans, as JSON, to `path`,
+// rotated once it grows beyond `maxSize` bytes. If tracing is not enabled
+// or the file cannot be opened, nil tracer is returned.
+func NewFileTracer(service string, enable bool, sampleRate float64, path string, maxSize int64) *Tracer {
+	if !enable {
+		return nil
+	}
+
+	exporter, err := NewFileExporter(path, maxSize)
+	if err != nil {
+		logging.Errorf("tracing: unable to open trace file %v for %v. Error = %v", path, service, err)
+		return nil
+	}
+
+	logging.Infof("tracing: %v traces are written to %v, sample rate %v", service, path, sampleRate)
+	return NewTracer(service, sampleRate, exporter)
+}
+
+// StartSpan starts the root span of a new trace, nil is returned if the
+// trace is not sampled.
+func (t *Tracer) StartSpan(name string) *Span {
+	if t == nil || t.sampleRate <= 0 {
+		return nil
+	}
+
+	t.mutex.Lock()
+	sampled := t.sampleRate >= 1 || t.rnd.Float64() < t.sampleRate
+	t.mutex.Unlock()
+
+	if !sampled {
+		return nil
+	}
+	return t.newSpan(name, t.newId(traceIdLen/2), "")
+}
+
+// StartRemoteSpan starts a span that is a child of a span in another
+// process. Nil is returned if the remote trace context is empty or
+// invalid, that is the caller is not tracing the request.
+func (t *Tracer) StartRemoteSpan(name string, remote string) *Span {
+	if t == nil || len(remote) == 0 {
+		return nil
+	}
+
+	parent, err := ParseSpanContext(remote)
+	if err != nil {
+		logging.Debugf("tracing: ignore trace context %v. Error = %v", remote, err)
+		return nil
+	}
+	return t.newSpan(name, parent.TraceId, parent.SpanId)
+}
+
+// Close the exporter.
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	return t.exporter.Close()
+}
+
+func (t *Tracer) newSpan(name, traceId, parentId string) *Span {
+	return &Span{
+		tracer:   t,
+		name:     name,
+		context:  SpanContext{TraceId: traceId, SpanId: t.newId(spanIdLen / 2)},
+		parentId: parentId,
+		start:    time.Now(),
+	}
+}
+
+func (t *Tracer) newId(size int) string {
+	buf := make([]byte, size)
+
+	t.mutex.Lock()
+	t.rnd.Read(buf)
+	t.mutex.Unlock()
+
+	return hex.EncodeToString(buf)
+}
+
+func (t *Tracer) export(span *SpanData) {
+	if err := t.exporter.Export(span); err != nil {
+		logging.Debugf("tracing: unable to export span %v of trace %v. Error = %v",
+			span.SpanId, span.TraceId, err)
+	}
+}
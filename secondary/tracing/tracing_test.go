@@ -0,0 +1,164 @@
+package tracing
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpanContext(t *testing.T) {
+	tracer := NewTracer("test", 1, &memExporter{})
+
+	span := tracer.StartSpan("root")
+	sc, err := ParseSpanContext(span.Context().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sc != span.Context() {
+		t.Fatalf("expected %v, got %v", span.Context(), sc)
+	}
+
+	for _, s := range []string{"", "abc", "abc-def", span.Context().TraceId} {
+		if _, err := ParseSpanContext(s); err != ErrInvalidContext {
+			t.Fatalf("expected invalid context for %q", s)
+		}
+	}
+}
+
+func TestSampling(t *testing.T) {
+	if span := NewTracer("test", 0, &memExporter{}).StartSpan("root"); span != nil {
+		t.Fatal("expected trace not to be sampled")
+	}
+
+	var tracer *Tracer
+	span := tracer.StartSpan("root")
+	if span != nil {
+		t.Fatal("expected nil span from nil tracer")
+	}
+	// nil span is a no-op
+	span.SetAttribute("key", 1)
+	span.StartChild("child").Finish()
+	span.Finish()
+}
+
+func TestRemoteSpan(t *testing.T) {
+	exporter := &memExporter{}
+	client := NewTracer("client", 1, exporter)
+	server := NewTracer("server", 0, exporter)
+
+	root := client.StartSpan("root")
+	remote := server.StartRemoteSpan("remote", root.Context().String())
+	child := remote.StartChild("child")
+	child.SetAttribute("rows", 10)
+	child.Finish()
+	remote.Finish()
+	root.Finish()
+	root.Finish()
+
+	if server.StartRemoteSpan("remote", "") != nil {
+		t.Fatal("expected no span without remote context")
+	}
+
+	if len(exporter.spans) != 3 {
+		t.Fatalf("expected 3 spans, got %v", len(exporter.spans))
+	}
+	c, r, p := exporter.spans[0], exporter.spans[1], exporter.spans[2]
+	if c.TraceId != p.TraceId || r.TraceId != p.TraceId {
+		t.Fatal("expected all spans in the same trace")
+	}
+	if c.ParentId != r.SpanId || r.ParentId != p.SpanId || p.ParentId != "" {
+		t.Fatal("unexpected span hierarchy")
+	}
+	if r.Service != "server" || c.Attributes["rows"] != 10 {
+		t.Fatalf("unexpected span %+v", c)
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "trace.json")
+	tracer := NewFileTracer("test", true, 1, path, 0)
+	for i := 0; i < 10; i++ {
+		span := tracer.StartSpan("root")
+		span.SetAttribute("i", i)
+		span.Finish()
+	}
+	tracer.Close()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	count := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var span SpanData
+		if err := json.Unmarshal(scanner.Bytes(), &span); err != nil {
+			t.Fatal(err)
+		}
+		if span.Name != "root" || span.Attributes["i"] != float64(count) {
+			t.Fatalf("unexpected span %+v", span)
+		}
+		count++
+	}
+	if count != 10 {
+		t.Fatalf("expected 10 spans, got %v", count)
+	}
+}
+
+func TestFileExporterRotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tracing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "trace.json")
+	maxSize := int64(1024)
+	exporter, err := NewFileExporter(path, maxSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := NewTracer("test", 1, exporter)
+	for i := 0; i < 100; i++ {
+		tracer.StartSpan("root").Finish()
+	}
+	tracer.Close()
+
+	// a file exceeds maxSize by at most one span.
+	for _, name := range []string{path, path + ".1"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 || info.Size() > maxSize+512 {
+			t.Fatalf("unexpected size %v of %v", info.Size(), name)
+		}
+	}
+	if _, err := os.Stat(path + ".2"); !os.IsNotExist(err) {
+		t.Fatalf("expected a single rotated file")
+	}
+}
+
+type memExporter struct {
+	spans []*SpanData
+}
+
+func (e *memExporter) Export(span *SpanData) error {
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+func (e *memExporter) Close() error {
+	return nil
+}
@@ -0,0 +1,211 @@
+package transport
+
+import "bytes"
+import "compress/bzip2"
+import "compress/gzip"
+import "io"
+import "io/ioutil"
+import "net"
+import "strings"
+import "sync/atomic"
+import "time"
+
+import "github.com/golang/snappy"
+
+// CompressionType returns compression type for the configured `name`,
+// which can be one of "none", "snappy", "gzip".
+func CompressionType(name string) (byte, error) {
+	switch strings.ToLower(name) {
+	case "", "none":
+		return CompressionNone, nil
+	case "snappy":
+		return CompressionSnappy, nil
+	case "gzip":
+		return CompressionGzip, nil
+	}
+	return CompressionNone, ErrorCompressionUnknown
+}
+
+// Compress `big` using compression `typ`.
+func Compress(typ byte, big []byte) (small []byte, err error) {
+	switch typ {
+	case CompressionNone:
+		return big, nil
+
+	case CompressionSnappy:
+		return snappy.Encode(nil, big), nil
+
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err = w.Write(big); err != nil {
+			return nil, err
+		}
+		if err = w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+
+	case CompressionBzip2: // standard library can only decompress bzip2.
+		return nil, ErrorCompressionUnsupported
+	}
+	return nil, ErrorCompressionUnknown
+}
+
+// Decompress `small` using compression `typ`, fail with ErrorPacketOverflow
+// if it decompresses to more than `maxPayload` bytes.
+func Decompress(typ byte, small []byte, maxPayload int) (big []byte, err error) {
+	switch typ {
+	case CompressionNone:
+		return small, nil
+
+	case CompressionSnappy:
+		if n, err := snappy.DecodedLen(small); err != nil {
+			return nil, err
+		} else if n > maxPayload {
+			return nil, ErrorPacketOverflow
+		}
+		return snappy.Decode(nil, small)
+
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(small))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return readAllLimited(r, maxPayload)
+
+	case CompressionBzip2:
+		return readAllLimited(bzip2.NewReader(bytes.NewReader(small)), maxPayload)
+	}
+	return nil, ErrorCompressionUnknown
+}
+
+// read `r` till EOF, fail if it has more than `maxPayload` bytes.
+func readAllLimited(r io.Reader, maxPayload int) ([]byte, error) {
+	big, err := ioutil.ReadAll(io.LimitReader(r, int64(maxPayload)+1))
+	if err != nil {
+		return nil, err
+	} else if len(big) > maxPayload {
+		return nil, ErrorPacketOverflow
+	}
+	return big, nil
+}
+
+// CompressionStats accumulate compression ratio and cost for packets
+// sent and received. Same object can be shared across connections.
+type CompressionStats struct {
+	compressCount     int64 // number of payloads compressed
+	skipCount         int64 // number of payloads below threshold
+	uncompressedBytes int64 // bytes before compression
+	compressedBytes   int64 // bytes after compression
+	compressTime      int64 // nanoseconds spent compressing
+	decompressCount   int64 // number of payloads decompressed
+	decompressTime    int64 // nanoseconds spent decompressing
+}
+
+// NewCompressionStats return a new set of compression statistics.
+func NewCompressionStats() *CompressionStats {
+	return &CompressionStats{}
+}
+
+func (stats *CompressionStats) addCompress(in, out int, since time.Time) {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.compressCount, 1)
+	atomic.AddInt64(&stats.uncompressedBytes, int64(in))
+	atomic.AddInt64(&stats.compressedBytes, int64(out))
+	atomic.AddInt64(&stats.compressTime, int64(time.Since(since)))
+}
+
+func (stats *CompressionStats) addSkip() {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.skipCount, 1)
+}
+
+func (stats *CompressionStats) addDecompress(since time.Time) {
+	if stats == nil {
+		return
+	}
+	atomic.AddInt64(&stats.decompressCount, 1)
+	atomic.AddInt64(&stats.decompressTime, int64(time.Since(since)))
+}
+
+// Ratio of uncompressed bytes to compressed bytes, 0 if nothing was
+// compressed yet.
+func (stats *CompressionStats) Ratio() float64 {
+	out := atomic.LoadInt64(&stats.compressedBytes)
+	if out == 0 {
+		return 0
+	}
+	return float64(atomic.LoadInt64(&stats.uncompressedBytes)) / float64(out)
+}
+
+// Map returns statistics as a map of stat-name to value.
+func (stats *CompressionStats) Map() map[string]interface{} {
+	return map[string]interface{}{
+		"compressCount":     atomic.LoadInt64(&stats.compressCount),
+		"compressSkipped":   atomic.LoadInt64(&stats.skipCount),
+		"uncompressedBytes": atomic.LoadInt64(&stats.uncompressedBytes),
+		"compressedBytes":   atomic.LoadInt64(&stats.compressedBytes),
+		"compressRatio":     stats.Ratio(),
+		"compressTime":      atomic.LoadInt64(&stats.compressTime),
+		"decompressCount":   atomic.LoadInt64(&stats.decompressCount),
+		"decompressTime":    atomic.LoadInt64(&stats.decompressTime),
+	}
+}
+
+// compress `big` with `typ` if it is not smaller than `threshold`, return
+// flags describing the payload on the wire.
+func compressPayload(
+	flags TransportFlag, typ byte, threshold int, stats *CompressionStats,
+	big []byte) (TransportFlag, []byte, error) {
+
+	if typ == CompressionNone {
+		return flags.SetCompression(CompressionNone), big, nil
+	} else if len(big) < threshold {
+		stats.addSkip()
+		return flags.SetCompression(CompressionNone), big, nil
+	}
+	since := time.Now()
+	small, err := Compress(typ, big)
+	if err != nil {
+		return flags, nil, err
+	}
+	stats.addCompress(len(big), len(small), since)
+	return flags.SetCompression(typ), small, nil
+}
+
+// CompressedConn wraps a connection whose remote has advertised that it
+// accepts compressed payloads, refer TransportFlag.AcceptsCompression().
+type CompressedConn struct {
+	net.Conn
+	compression byte
+	threshold   int
+	stats       *CompressionStats
+}
+
+// NewCompressedConn returns a connection that shall compress payloads
+// larger than `threshold` bytes using `compression`.
+func NewCompressedConn(
+	conn net.Conn, compression byte, threshold int,
+	stats *CompressionStats) *CompressedConn {
+
+	return &CompressedConn{
+		Conn:        conn,
+		compression: compression,
+		threshold:   threshold,
+		stats:       stats,
+	}
+}
+
+// Compress payload for this connection, return flags describing the
+// payload on the wire.
+func (cc *CompressedConn) Compress(
+	flags TransportFlag, data []byte) (TransportFlag, []byte, error) {
+
+	return compressPayload(flags, cc.compression, cc.threshold, cc.stats, data)
+}
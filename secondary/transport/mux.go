@@ -0,0 +1,562 @@
+// Multiplexed connection, many streams share a single connection. Every
+// stream behaves like a net.Conn, hence packets are sent and received on
+// a stream the same way as on a dedicated connection.
+//
+//      { uint32(streamID), uint8(frameType), uint32(framelen), []byte(data) }
+//
+// Streams are opened by the client end of the connection. A stream is
+// created on the server end when the first data frame arrives for it.
+//
+// Every stream has its own flow control. Sender can send up to
+// MuxStreamWindow bytes on a stream, and then waits for the receiver to
+// grant more credit via window frames, as the application consumes the
+// data. Hence a slow reader on one stream does not block other streams.
+//
+// Server end accepts at most `maxStreams` concurrent streams, a stream
+// opened beyond that is reset by sending a close frame. Frames are written
+// on the connection with a write deadline, a connection that does not
+// drain within the deadline is closed along with all its streams.
+
+package transport
+
+import "bytes"
+import "encoding/binary"
+import "errors"
+import "fmt"
+import "io"
+import "net"
+import "sync"
+import "time"
+
+import "github.com/couchbase/indexing/secondary/logging"
+
+// ErrorMuxClosed is error on a closed multiplexed connection.
+var ErrorMuxClosed = errors.New("transport.muxClosed")
+
+// ErrorMuxProtocol is error on receiving an invalid frame.
+var ErrorMuxProtocol = errors.New("transport.muxProtocol")
+
+// ErrorStreamClosed is error writing to a stream closed by either end.
+var ErrorStreamClosed = errors.New("transport.streamClosed")
+
+// ErrorMuxStreams is error opening more than maximum number of streams.
+var ErrorMuxStreams = errors.New("transport.muxTooManyStreams")
+
+// MuxStreamWindow is the number of bytes that can be sent on a stream
+// without waiting for credits from the remote.
+const MuxStreamWindow = 256 * 1024
+
+// maximum size of data in a frame.
+const muxMaxFrameSize = 64 * 1024
+
+const ( // types of frames
+	muxFrameData   byte = 1 // stream data
+	muxFrameWindow byte = 2 // window update, data is uint32(credit)
+	muxFrameClose  byte = 3 // stream closed
+)
+
+// frame field offset and size in bytes
+const (
+	muxIdOffset    int = 0
+	muxIdSize      int = 4
+	muxTypeOffset  int = muxIdOffset + muxIdSize
+	muxTypeSize    int = 1
+	muxLenOffset   int = muxTypeOffset + muxTypeSize
+	muxLenSize     int = 4
+	muxHeaderSize  int = muxLenOffset + muxLenSize
+	muxMaxFrameLen int = muxHeaderSize + muxMaxFrameSize
+)
+
+// MuxSession multiplexes streams on a connection.
+type MuxSession struct {
+	conn         net.Conn
+	client       bool
+	maxStreams   int           // maximum concurrent streams, 0 for no limit
+	writeTimeout time.Duration // deadline for writing a frame, 0 for none
+	logPrefix    string
+
+	wmu  sync.Mutex // serialize frames written on connection
+	wbuf []byte
+
+	mu       sync.Mutex
+	streams  map[uint32]*MuxStream
+	nextID   uint32 // client, id of the next stream to open
+	lastID   uint32 // server, id of the last accepted stream
+	acceptch chan *MuxStream
+	err      error
+	donech   chan struct{}
+}
+
+// NewMuxSession starts multiplexing streams on `conn`. Streams are opened
+// by the `client` end of the connection and accepted by the other end.
+// There can be at most `maxStreams` concurrent streams, and every frame
+// shall be written within `writeTimeout`, zero value disables the limit.
+func NewMuxSession(
+	conn net.Conn, client bool,
+	maxStreams int, writeTimeout time.Duration) *MuxSession {
+
+	s := &MuxSession{
+		conn:         conn,
+		client:       client,
+		maxStreams:   maxStreams,
+		writeTimeout: writeTimeout,
+		logPrefix:    fmt.Sprintf("[MuxSession %v->%v]", conn.LocalAddr(), conn.RemoteAddr()),
+		wbuf:         make([]byte, muxMaxFrameLen),
+		streams:      make(map[uint32]*MuxStream),
+		acceptch:     make(chan *MuxStream),
+		donech:       make(chan struct{}),
+	}
+	go s.recvLoop()
+	logging.Infof("%v started ...\n", s.logPrefix)
+	return s
+}
+
+// Open a new stream, applicable only for client end of the connection.
+func (s *MuxSession) Open() (*MuxStream, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return nil, s.err
+	} else if !s.client {
+		return nil, ErrorMuxProtocol
+	} else if s.maxStreams > 0 && len(s.streams) >= s.maxStreams {
+		return nil, ErrorMuxStreams
+	}
+
+	s.nextID++
+	stream := newMuxStream(s, s.nextID)
+	s.streams[stream.id] = stream
+	return stream, nil
+}
+
+// Accept the next stream opened by the remote, applicable only for
+// server end of the connection.
+func (s *MuxSession) Accept() (*MuxStream, error) {
+	select {
+	case stream := <-s.acceptch:
+		return stream, nil
+	case <-s.donech:
+		return nil, s.Err()
+	}
+}
+
+// NumStreams return the number of open streams.
+func (s *MuxSession) NumStreams() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.streams)
+}
+
+// IsClosed return whether the session is closed.
+func (s *MuxSession) IsClosed() bool {
+	select {
+	case <-s.donech:
+		return true
+	default:
+		return false
+	}
+}
+
+// Err return the error that closed the session.
+func (s *MuxSession) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// Close the session and the underlying connection, all streams are closed.
+func (s *MuxSession) Close() error {
+	s.closeWithError(ErrorMuxClosed)
+	return nil
+}
+
+func (s *MuxSession) closeWithError(err error) {
+	s.mu.Lock()
+	if s.err != nil {
+		s.mu.Unlock()
+		return
+	}
+	s.err = err
+	s.streams = make(map[uint32]*MuxStream)
+	close(s.donech)
+	s.mu.Unlock()
+
+	s.conn.Close()
+	if err == io.EOF || err == ErrorMuxClosed {
+		logging.Infof("%v closed %v\n", s.logPrefix, err)
+	} else {
+		logging.Errorf("%v closed %v\n", s.logPrefix, err)
+	}
+}
+
+// lookup the stream for an incoming frame. For server end, a data frame
+// for a new stream id accepts the stream, unless there are already
+// `maxStreams` streams, in which case the stream is reset.
+func (s *MuxSession) lookup(id uint32, typ byte) (*MuxStream, bool) {
+	s.mu.Lock()
+	if stream, ok := s.streams[id]; ok || s.err != nil {
+		s.mu.Unlock()
+		return stream, ok
+	}
+	if s.client || typ != muxFrameData || id <= s.lastID {
+		// frame for a stream that is already closed.
+		s.mu.Unlock()
+		return nil, false
+	}
+	s.lastID = id
+	if s.maxStreams > 0 && len(s.streams) >= s.maxStreams {
+		s.mu.Unlock()
+		logging.Warnf("%v stream %v reset, %v streams open\n", s.logPrefix, id, s.maxStreams)
+		// remaining frames of the stream are ignored, as id <= lastID.
+		s.writeFrame(muxFrameClose, id, nil)
+		return nil, false
+	}
+	stream := newMuxStream(s, id)
+	s.streams[id] = stream
+	s.mu.Unlock()
+
+	select {
+	case s.acceptch <- stream:
+		return stream, true
+	case <-s.donech:
+		return nil, false
+	}
+}
+
+func (s *MuxSession) remove(id uint32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.streams, id)
+}
+
+// receive frames and dispatch them to streams, until the connection
+// fails or is closed.
+func (s *MuxSession) recvLoop() {
+	hdr := make([]byte, muxHeaderSize)
+	buf := make([]byte, muxMaxFrameSize)
+
+	for {
+		if err := fullRead(s.conn, hdr); err != nil {
+			s.closeWithError(err)
+			return
+		}
+		a, b := muxIdOffset, muxIdOffset+muxIdSize
+		id := binary.BigEndian.Uint32(hdr[a:b])
+		typ := hdr[muxTypeOffset]
+		a, b = muxLenOffset, muxLenOffset+muxLenSize
+		n := binary.BigEndian.Uint32(hdr[a:b])
+
+		if n > muxMaxFrameSize {
+			logging.Errorf("%v stream %v frame length %v > %v\n", s.logPrefix, id, n, muxMaxFrameSize)
+			s.closeWithError(ErrorMuxProtocol)
+			return
+		}
+		data := buf[:n]
+		if err := fullRead(s.conn, data); err != nil {
+			s.closeWithError(err)
+			return
+		}
+
+		stream, ok := s.lookup(id, typ)
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch typ {
+		case muxFrameData:
+			err = stream.pushData(data)
+		case muxFrameWindow:
+			if len(data) != 4 {
+				err = ErrorMuxProtocol
+				break
+			}
+			stream.addCredit(binary.BigEndian.Uint32(data))
+		case muxFrameClose:
+			stream.remoteClose()
+		default:
+			err = ErrorMuxProtocol
+		}
+		if err != nil {
+			logging.Errorf("%v stream %v frame type %v: %v\n", s.logPrefix, id, typ, err)
+			s.closeWithError(err)
+			return
+		}
+	}
+}
+
+// write a frame on the connection. Frames from all streams are serialized
+// on the connection, hence the write is bounded by `writeTimeout` so that
+// a stalled connection does not block all streams indefinitely.
+func (s *MuxSession) writeFrame(typ byte, id uint32, data []byte) error {
+	s.wmu.Lock()
+	defer s.wmu.Unlock()
+
+	if s.IsClosed() {
+		return s.Err()
+	}
+
+	if s.writeTimeout > 0 {
+		s.conn.SetWriteDeadline(time.Now().Add(s.writeTimeout))
+	}
+
+	a, b := muxIdOffset, muxIdOffset+muxIdSize
+	binary.BigEndian.PutUint32(s.wbuf[a:b], id)
+	s.wbuf[muxTypeOffset] = typ
+	a, b = muxLenOffset, muxLenOffset+muxLenSize
+	binary.BigEndian.PutUint32(s.wbuf[a:b], uint32(len(data)))
+	n := copy(s.wbuf[muxHeaderSize:], data)
+
+	if err := connWrite(s.conn, s.wbuf[:muxHeaderSize+n]); err != nil {
+		s.closeWithError(err)
+		return err
+	}
+	return nil
+}
+
+// MuxStream is a single stream of a multiplexed connection and
+// implements net.Conn interface.
+type MuxStream struct {
+	id      uint32
+	session *MuxSession
+
+	mu        sync.Mutex
+	rbuf      bytes.Buffer
+	rwindow   uint32 // bytes remote can send without new credit
+	consumed  uint32 // bytes read, not yet granted to remote
+	credit    uint32 // bytes that can be sent without new credit
+	rclosed   bool   // closed by remote
+	lclosed   bool   // closed locally
+	rdeadline time.Time
+	wdeadline time.Time
+	readch    chan struct{} // notify data or close
+	writech   chan struct{} // notify credit or close
+}
+
+func newMuxStream(s *MuxSession, id uint32) *MuxStream {
+	return &MuxStream{
+		id:      id,
+		session: s,
+		rwindow: MuxStreamWindow,
+		credit:  MuxStreamWindow,
+		readch:  make(chan struct{}, 1),
+		writech: make(chan struct{}, 1),
+	}
+}
+
+// ID return stream id.
+func (m *MuxStream) ID() uint32 {
+	return m.id
+}
+
+// Read implement net.Conn interface.
+func (m *MuxStream) Read(b []byte) (int, error) {
+	for {
+		m.mu.Lock()
+		if m.rbuf.Len() > 0 {
+			n, _ := m.rbuf.Read(b)
+			m.consumed += uint32(n)
+			var credit uint32
+			if m.consumed >= MuxStreamWindow/2 && !m.rclosed {
+				credit, m.consumed = m.consumed, 0
+				m.rwindow += credit
+			}
+			m.mu.Unlock()
+
+			if credit > 0 {
+				var data [4]byte
+				binary.BigEndian.PutUint32(data[:], credit)
+				m.session.writeFrame(muxFrameWindow, m.id, data[:])
+			}
+			return n, nil
+		}
+		if m.lclosed {
+			m.mu.Unlock()
+			return 0, ErrorStreamClosed
+		} else if m.rclosed {
+			m.mu.Unlock()
+			return 0, io.EOF
+		}
+		deadline := m.rdeadline
+		m.mu.Unlock()
+
+		if err := m.wait(m.readch, deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write implement net.Conn interface.
+func (m *MuxStream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		m.mu.Lock()
+		if m.lclosed || m.rclosed {
+			m.mu.Unlock()
+			return written, ErrorStreamClosed
+		}
+		if m.credit == 0 {
+			deadline := m.wdeadline
+			m.mu.Unlock()
+			if err := m.wait(m.writech, deadline); err != nil {
+				return written, err
+			}
+			continue
+		}
+		n := len(b) - written
+		if n > int(m.credit) {
+			n = int(m.credit)
+		}
+		if n > muxMaxFrameSize {
+			n = muxMaxFrameSize
+		}
+		m.credit -= uint32(n)
+		m.mu.Unlock()
+
+		if err := m.session.writeFrame(muxFrameData, m.id, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// Close implement net.Conn interface. Remote gets io.EOF on reading from
+// the stream, after draining the data already received.
+func (m *MuxStream) Close() error {
+	m.mu.Lock()
+	if m.lclosed {
+		m.mu.Unlock()
+		return nil
+	}
+	m.lclosed = true
+	sendClose := !m.rclosed
+	m.mu.Unlock()
+
+	m.notify(m.readch)
+	m.notify(m.writech)
+	m.session.remove(m.id)
+	if sendClose {
+		return m.session.writeFrame(muxFrameClose, m.id, nil)
+	}
+	return nil
+}
+
+// LocalAddr implement net.Conn interface.
+func (m *MuxStream) LocalAddr() net.Addr {
+	return m.session.conn.LocalAddr()
+}
+
+// RemoteAddr implement net.Conn interface.
+func (m *MuxStream) RemoteAddr() net.Addr {
+	return m.session.conn.RemoteAddr()
+}
+
+// SetDeadline implement net.Conn interface.
+func (m *MuxStream) SetDeadline(t time.Time) error {
+	m.SetReadDeadline(t)
+	return m.SetWriteDeadline(t)
+}
+
+// SetReadDeadline implement net.Conn interface.
+func (m *MuxStream) SetReadDeadline(t time.Time) error {
+	m.mu.Lock()
+	m.rdeadline = t
+	m.mu.Unlock()
+	m.notify(m.readch)
+	return nil
+}
+
+// SetWriteDeadline implement net.Conn interface.
+func (m *MuxStream) SetWriteDeadline(t time.Time) error {
+	m.mu.Lock()
+	m.wdeadline = t
+	m.mu.Unlock()
+	m.notify(m.writech)
+	return nil
+}
+
+func (m *MuxStream) pushData(data []byte) error {
+	m.mu.Lock()
+	if uint32(len(data)) > m.rwindow {
+		m.mu.Unlock()
+		return ErrorMuxProtocol
+	}
+	m.rwindow -= uint32(len(data))
+	if !m.lclosed {
+		m.rbuf.Write(data)
+	}
+	m.mu.Unlock()
+
+	m.notify(m.readch)
+	return nil
+}
+
+func (m *MuxStream) addCredit(credit uint32) {
+	m.mu.Lock()
+	m.credit += credit
+	m.mu.Unlock()
+
+	m.notify(m.writech)
+}
+
+func (m *MuxStream) remoteClose() {
+	m.mu.Lock()
+	m.rclosed = true
+	lclosed := m.lclosed
+	m.mu.Unlock()
+
+	m.notify(m.readch)
+	m.notify(m.writech)
+	if lclosed {
+		m.session.remove(m.id)
+	}
+}
+
+func (m *MuxStream) notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// wait for notification on `ch`, until deadline or session is closed.
+func (m *MuxStream) wait(ch chan struct{}, deadline time.Time) error {
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		d := deadline.Sub(time.Now())
+		if d <= 0 {
+			return muxTimeoutError{}
+		}
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-timeout:
+		return muxTimeoutError{}
+	case <-m.session.donech:
+		if ch == m.readch && m.hasData() {
+			return nil
+		}
+		return m.session.Err()
+	}
+}
+
+func (m *MuxStream) hasData() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.rbuf.Len() > 0
+}
+
+// muxTimeoutError implement net.Error interface, so that stream timeouts
+// are treated like connection timeouts.
+type muxTimeoutError struct{}
+
+func (e muxTimeoutError) Error() string   { return "transport.muxTimeout" }
+func (e muxTimeoutError) Timeout() bool   { return true }
+func (e muxTimeoutError) Temporary() bool { return true }
@@ -0,0 +1,290 @@
+package transport
+
+import "bytes"
+import "io"
+import "net"
+import "testing"
+import "time"
+
+func newTestMuxSessions(maxStreams int, writeTimeout time.Duration) (*MuxSession, *MuxSession) {
+	cconn, sconn := net.Pipe()
+	client := NewMuxSession(cconn, true /*client*/, 0, writeTimeout)
+	server := NewMuxSession(sconn, false /*client*/, maxStreams, writeTimeout)
+	return client, server
+}
+
+// accept streams on the server end, until the session is closed.
+func acceptTestStreams(server *MuxSession) chan *MuxStream {
+	acceptch := make(chan *MuxStream, 16)
+	go func() {
+		for {
+			stream, err := server.Accept()
+			if err != nil {
+				close(acceptch)
+				return
+			}
+			acceptch <- stream
+		}
+	}()
+	return acceptch
+}
+
+func nextTestStream(t *testing.T, acceptch chan *MuxStream) *MuxStream {
+	select {
+	case stream := <-acceptch:
+		return stream
+	case <-time.After(5 * time.Second):
+		t.Fatalf("stream not accepted")
+	}
+	return nil
+}
+
+func waitTestStreams(t *testing.T, s *MuxSession, n int) {
+	for i := 0; i < 500; i++ {
+		if s.NumStreams() == n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("expected %v streams, got %v", n, s.NumStreams())
+}
+
+func isTimeout(err error) bool {
+	nerr, ok := err.(net.Error)
+	return ok && nerr.Timeout()
+}
+
+func TestMuxStreams(t *testing.T) {
+	client, server := newTestMuxSessions(0, time.Second)
+	defer client.Close()
+	defer server.Close()
+	acceptch := acceptTestStreams(server)
+
+	// streams are independent of each other
+	cstreams := make([]*MuxStream, 3)
+	sstreams := make([]*MuxStream, 3)
+	for i := range cstreams {
+		var err error
+		if cstreams[i], err = client.Open(); err != nil {
+			t.Fatalf("stream %v: unexpected error %v", i, err)
+		}
+		cstreams[i].Write([]byte{byte(i)})
+		sstreams[i] = nextTestStream(t, acceptch)
+	}
+
+	for i := len(sstreams) - 1; i >= 0; i-- {
+		var b [1]byte
+		if n, err := sstreams[i].Read(b[:]); n != 1 || err != nil || b[0] != byte(i) {
+			t.Errorf("stream %v: expected %v, got %v %v %v", i, i, n, b[0], err)
+		}
+		if sstreams[i].ID() != cstreams[i].ID() {
+			t.Errorf("stream %v: expected id %v, got %v", i, cstreams[i].ID(), sstreams[i].ID())
+		}
+	}
+
+	if _, err := server.Open(); err != ErrorMuxProtocol {
+		t.Errorf("server open: expected %v, got %v", ErrorMuxProtocol, err)
+	}
+}
+
+func TestMuxStreamCredit(t *testing.T) {
+	client, server := newTestMuxSessions(0, time.Second)
+	defer client.Close()
+	defer server.Close()
+	acceptch := acceptTestStreams(server)
+
+	cstream, _ := client.Open()
+	data := bytes.Repeat([]byte("x"), MuxStreamWindow+1000)
+
+	// sender blocks once the window is exhausted
+	cstream.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+	n, err := cstream.Write(data)
+	if n != MuxStreamWindow || !isTimeout(err) {
+		t.Fatalf("window exhausted: expected %v bytes and timeout, got %v %v", MuxStreamWindow, n, err)
+	}
+	sstream := nextTestStream(t, acceptch)
+
+	// a slow reader on one stream does not block other streams
+	other, _ := client.Open()
+	if _, err := other.Write([]byte("y")); err != nil {
+		t.Errorf("other stream: unexpected error %v", err)
+	}
+	nextTestStream(t, acceptch)
+
+	// reading half the window grants credit to the sender
+	buf := make([]byte, MuxStreamWindow/2)
+	if _, err := io.ReadFull(sstream, buf); err != nil {
+		t.Fatalf("read: unexpected error %v", err)
+	}
+
+	cstream.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if m, err := cstream.Write(data[n:]); m != len(data)-n || err != nil {
+		t.Fatalf("credit granted: expected %v bytes, got %v %v", len(data)-n, m, err)
+	}
+
+	rest := make([]byte, len(data)-len(buf))
+	if _, err := io.ReadFull(sstream, rest); err != nil {
+		t.Errorf("read rest: unexpected error %v", err)
+	}
+}
+
+func TestMuxStreamClose(t *testing.T) {
+	client, server := newTestMuxSessions(0, time.Second)
+	defer client.Close()
+	defer server.Close()
+	acceptch := acceptTestStreams(server)
+
+	// remote reads the data sent before close, and then io.EOF
+	cstream, _ := client.Open()
+	cstream.Write([]byte("data"))
+	cstream.Close()
+	sstream := nextTestStream(t, acceptch)
+
+	buf := make([]byte, 16)
+	if n, err := sstream.Read(buf); n != 4 || err != nil {
+		t.Errorf("close: expected 4 bytes, got %v %v", n, err)
+	}
+	if _, err := sstream.Read(buf); err != io.EOF {
+		t.Errorf("close: expected %v, got %v", io.EOF, err)
+	}
+	if _, err := sstream.Write(buf); err != ErrorStreamClosed {
+		t.Errorf("write after remote close: expected %v, got %v", ErrorStreamClosed, err)
+	}
+	if _, err := cstream.Read(buf); err != ErrorStreamClosed {
+		t.Errorf("read after close: expected %v, got %v", ErrorStreamClosed, err)
+	}
+	sstream.Close()
+	waitTestStreams(t, server, 0)
+	waitTestStreams(t, client, 0)
+
+	// closing the session closes all its streams
+	cstream, _ = client.Open()
+	cstream.Write([]byte("data"))
+	sstream = nextTestStream(t, acceptch)
+
+	client.Close()
+	if !client.IsClosed() || client.Err() != ErrorMuxClosed {
+		t.Errorf("session close: expected %v, got %v", ErrorMuxClosed, client.Err())
+	}
+	if _, err := cstream.Read(buf); err != ErrorMuxClosed {
+		t.Errorf("session close: expected %v, got %v", ErrorMuxClosed, err)
+	}
+	if _, err := client.Open(); err != ErrorMuxClosed {
+		t.Errorf("open after close: expected %v, got %v", ErrorMuxClosed, err)
+	}
+
+	// remote drains the data received before the connection is closed
+	if n, err := sstream.Read(buf); n != 4 || err != nil {
+		t.Errorf("remote drain: expected 4 bytes, got %v %v", n, err)
+	}
+	if _, err := sstream.Read(buf); err != io.EOF {
+		t.Errorf("remote close: expected %v, got %v", io.EOF, err)
+	}
+	if _, ok := <-acceptch; ok {
+		t.Errorf("remote close: expected accept to fail")
+	}
+}
+
+func TestMuxMaxStreams(t *testing.T) {
+	client, server := newTestMuxSessions(2, time.Second)
+	defer client.Close()
+	defer server.Close()
+	acceptch := acceptTestStreams(server)
+
+	var sstreams []*MuxStream
+	for i := 0; i < 2; i++ {
+		cstream, _ := client.Open()
+		cstream.Write([]byte("data"))
+		sstreams = append(sstreams, nextTestStream(t, acceptch))
+	}
+
+	// stream beyond the limit is reset by the server
+	buf := make([]byte, 16)
+	cstream, _ := client.Open()
+	cstream.Write([]byte("data"))
+	cstream.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := cstream.Read(buf); err != io.EOF {
+		t.Errorf("reset: expected %v, got %v", io.EOF, err)
+	}
+	if _, err := cstream.Write(buf); err != ErrorStreamClosed {
+		t.Errorf("reset: expected %v, got %v", ErrorStreamClosed, err)
+	}
+	cstream.Close()
+	if server.NumStreams() != 2 {
+		t.Errorf("reset: expected 2 streams, got %v", server.NumStreams())
+	}
+
+	// a stream is accepted once another is closed
+	sstreams[0].Close()
+	waitTestStreams(t, server, 1)
+
+	cstream, _ = client.Open()
+	cstream.Write([]byte("data"))
+	sstream := nextTestStream(t, acceptch)
+	if n, err := sstream.Read(buf); n != 4 || err != nil {
+		t.Errorf("accept: expected 4 bytes, got %v %v", n, err)
+	}
+}
+
+func TestMuxStreamDeadline(t *testing.T) {
+	client, server := newTestMuxSessions(0, time.Second)
+	defer client.Close()
+	defer server.Close()
+	acceptTestStreams(server)
+
+	cstream, _ := client.Open()
+	buf := make([]byte, 16)
+
+	cstream.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := cstream.Read(buf); !isTimeout(err) {
+		t.Errorf("read deadline: expected timeout, got %v", err)
+	}
+
+	// expired deadline fails right away
+	if _, err := cstream.Read(buf); !isTimeout(err) {
+		t.Errorf("expired deadline: expected timeout, got %v", err)
+	}
+
+	// deadline is cleared with zero value
+	cstream.SetReadDeadline(time.Time{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cstream.Close()
+	}()
+	if _, err := cstream.Read(buf); err != ErrorStreamClosed {
+		t.Errorf("no deadline: expected %v, got %v", ErrorStreamClosed, err)
+	}
+}
+
+func TestMuxWriteTimeout(t *testing.T) {
+	// remote end of the connection does not read
+	cconn, sconn := net.Pipe()
+	defer sconn.Close()
+	client := NewMuxSession(cconn, true /*client*/, 0, 100*time.Millisecond)
+
+	cstream, _ := client.Open()
+	other, _ := client.Open()
+
+	donech := make(chan error, 1)
+	go func() {
+		_, err := cstream.Write([]byte("data"))
+		donech <- err
+	}()
+
+	select {
+	case err := <-donech:
+		if !isTimeout(err) {
+			t.Errorf("write timeout: expected timeout, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("write timeout: write is blocked")
+	}
+
+	// a stalled connection is closed along with all its streams
+	if !client.IsClosed() {
+		t.Errorf("write timeout: expected session to be closed")
+	}
+	if _, err := other.Write([]byte("data")); err == nil {
+		t.Errorf("write timeout: expected error on other stream")
+	}
+}
@@ -10,6 +10,8 @@ package transport
 
 import "errors"
 import "net"
+import "time"
+
 import "github.com/couchbase/indexing/secondary/logging"
 
 // error codes
@@ -29,6 +31,12 @@ var ErrorDecoderUnknown = errors.New("transport.decoderUnknown")
 //ErrorChecksumMismatch for mismatch in checksum
 var ErrorChecksumMismatch = errors.New("transport.checksumUnknown")
 
+// ErrorCompressionUnknown for unknown compression.
+var ErrorCompressionUnknown = errors.New("transport.compressionUnknown")
+
+// ErrorCompressionUnsupported for compression that can only be decompressed.
+var ErrorCompressionUnsupported = errors.New("transport.compressionUnsupported")
+
 // packet field offset and size in bytes
 const (
 	pktLenOffset   int = 0
@@ -49,10 +57,13 @@ type transporter interface { // facilitates unit testing
 // TransportPacket to send and receive mutation packets between router
 // and downstream client.
 type TransportPacket struct {
-	flags    TransportFlag
-	buf      []byte
-	encoders map[byte]Encoder
-	decoders map[byte]Decoder
+	flags     TransportFlag // for sending packets
+	rflags    TransportFlag // from last received packet
+	buf       []byte
+	encoders  map[byte]Encoder
+	decoders  map[byte]Decoder
+	threshold int // payloads smaller than threshold are not compressed
+	stats     *CompressionStats
 }
 
 // Encoder callback
@@ -81,6 +92,33 @@ func NewTransportPacket(maxlen int, flags TransportFlag) *TransportPacket {
 	return pkt
 }
 
+// SetCompression for sending payloads that are `threshold` bytes or more,
+// caller should make sure that remote AcceptsCompression().
+func (pkt *TransportPacket) SetCompression(typ byte, threshold int) *TransportPacket {
+	pkt.flags = pkt.flags.SetCompression(typ)
+	pkt.threshold = threshold
+	return pkt
+}
+
+// SetAcceptCompression advertise to remote, with every packet sent, that
+// compressed payloads can be received.
+func (pkt *TransportPacket) SetAcceptCompression() *TransportPacket {
+	pkt.flags = pkt.flags.SetAcceptCompression()
+	return pkt
+}
+
+// PeerAcceptsCompression returns whether the last packet received from
+// remote advertised that it can receive compressed payloads.
+func (pkt *TransportPacket) PeerAcceptsCompression() bool {
+	return pkt.rflags.AcceptsCompression()
+}
+
+// SetCompressionStats to accumulate compression ratio and cost.
+func (pkt *TransportPacket) SetCompressionStats(stats *CompressionStats) *TransportPacket {
+	pkt.stats = stats
+	return pkt
+}
+
 // SetEncoder callback function for `type`.
 func (pkt *TransportPacket) SetEncoder(typ byte, callb Encoder) *TransportPacket {
 	pkt.encoders[typ] = callb
@@ -96,17 +134,18 @@ func (pkt *TransportPacket) SetDecoder(typ byte, callb Decoder) *TransportPacket
 // Send payload to the other end using sufficient encoding and compression.
 func (pkt *TransportPacket) Send(conn transporter, payload interface{}) (err error) {
 	var data []byte
+	var flags TransportFlag
 
 	// encode
 	if data, err = pkt.encode(payload); err != nil {
 		return
 	}
 	// compress
-	if data, err = pkt.compress(data); err != nil {
+	if flags, data, err = pkt.compress(data); err != nil {
 		return
 	}
 
-	err = Send(conn, pkt.buf, pkt.flags, data, true)
+	err = Send(conn, pkt.buf, flags, data, true)
 	return
 }
 
@@ -126,17 +165,17 @@ func (pkt *TransportPacket) Receive(conn transporter) (payload interface{}, err
 		return nil, nil
 	}
 
-	pkt.flags = flags
+	pkt.rflags = flags
 
 	laddr, raddr := conn.LocalAddr(), conn.RemoteAddr()
 	logging.Tracef("read %v bytes on connection %v<-%v", len(data), laddr, raddr)
 
 	// de-compression
-	if data, err = pkt.decompress(data); err != nil {
+	if data, err = pkt.decompress(flags, data); err != nil {
 		return
 	}
 	// decoding
-	if payload, err = pkt.decode(data); err != nil {
+	if payload, err = pkt.decode(flags, data); err != nil {
 		return
 	}
 	return
@@ -156,30 +195,37 @@ func (pkt *TransportPacket) encode(payload interface{}) (data []byte, err error)
 
 // decode array of bytes back to payload, if callback was specified `nil` for
 // a valid type then return `data` as `payload`.
-func (pkt *TransportPacket) decode(data []byte) (payload interface{}, err error) {
-	typ := pkt.flags.GetEncoding()
+func (pkt *TransportPacket) decode(
+	flags TransportFlag, data []byte) (payload interface{}, err error) {
+
+	typ := flags.GetEncoding()
 	if callb, ok := pkt.decoders[typ]; ok && callb != nil {
 		return callb(data)
 	}
 	return nil, ErrorDecoderUnknown
 }
 
-// compress array of bytes.
-func (pkt *TransportPacket) compress(big []byte) (small []byte, err error) {
-	switch pkt.flags.GetCompression() {
-	case CompressionNone:
-		small = big
-	}
-	return
+// compress array of bytes, return flags describing the payload on the wire.
+func (pkt *TransportPacket) compress(big []byte) (TransportFlag, []byte, error) {
+	typ := pkt.flags.GetCompression()
+	return compressPayload(pkt.flags, typ, pkt.threshold, pkt.stats, big)
 }
 
-// decompress array of bytes.
-func (pkt *TransportPacket) decompress(small []byte) (big []byte, err error) {
-	switch pkt.flags.GetCompression() {
-	case CompressionNone:
-		big = small
+// decompress array of bytes, payloads are not allowed to decompress
+// beyond the size of packet buffer.
+func (pkt *TransportPacket) decompress(
+	flags TransportFlag, small []byte) (big []byte, err error) {
+
+	typ := flags.GetCompression()
+	if typ == CompressionNone {
+		return small, nil
 	}
-	return
+	since := time.Now()
+	if big, err = Decompress(typ, small, len(pkt.buf)); err != nil {
+		return nil, err
+	}
+	pkt.stats.addDecompress(since)
+	return big, nil
 }
 
 // read len(buf) bytes from `conn`.
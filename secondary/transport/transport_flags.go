@@ -4,8 +4,12 @@
 //           +---------------+---------------+
 //       bits|0 1 2 3 4 5 6 7|0 1 2 3 4 5 6 7|
 //           +-------+-------+---------------+  COMP. - Compression
-//          0| COMP. |  ENC. |  checksum   |X|  ENC.  - Encoding
-//           +-------+-------+---------------+  X     - Reserved for future
+//          0| COMP. |  ENC. |  checksum   |A|  ENC.  - Encoding
+//           +-------+-------+---------------+  A     - Accept compression
+//
+// `A` is set by a peer that can decompress payloads sent by the other end,
+// senders shall apply compression on a connection only after the remote has
+// advertised it.
 
 package transport
 
@@ -27,6 +31,9 @@ const ( // types of compression over the wire.
 	CompressionBzip2 = 3
 )
 
+// acceptCompression bit in flags.
+const acceptCompression TransportFlag = 0x8000
+
 // TransportFlag tell packet encoding and compression formats.
 type TransportFlag uint16
 
@@ -50,6 +57,21 @@ func (flags TransportFlag) SetBzip2() TransportFlag {
 	return (flags & TransportFlag(0xFFF0)) | TransportFlag(CompressionBzip2)
 }
 
+// SetCompression will set packet compression to `typ`
+func (flags TransportFlag) SetCompression(typ byte) TransportFlag {
+	return (flags & TransportFlag(0xFFF0)) | TransportFlag(typ&0x0F)
+}
+
+// SetAcceptCompression will advertise that sender can decompress payloads.
+func (flags TransportFlag) SetAcceptCompression() TransportFlag {
+	return flags | acceptCompression
+}
+
+// AcceptsCompression returns whether sender can decompress payloads.
+func (flags TransportFlag) AcceptsCompression() bool {
+	return (flags & acceptCompression) == acceptCompression
+}
+
 // GetEncoding will get the encoding bits from flags
 func (flags TransportFlag) GetEncoding() byte {
 	return byte(flags & TransportFlag(0x00F0))